@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/url"
 	"sync"
+	"time"
 
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/pkg/websocket"
@@ -20,13 +21,22 @@ const (
 
 	// defaultProxyURL is the URL of the Piko proxy port when running locally.
 	defaultProxyURL = "ws://localhost:8000"
+
+	// defaultHeartbeatInterval is the default interval to send heartbeats to
+	// the server.
+	defaultHeartbeatInterval = time.Second * 10
+
+	// defaultHeartbeatTimeout is the default timeout waiting for a heartbeat
+	// response before closing the connection.
+	defaultHeartbeatTimeout = time.Second * 10
 )
 
 // Client manages registering listeners with Piko.
 //
 // The client establishes an outbound-only connection to the server for each
-// listener. Proxied connections for the listener are then multiplexed over
-// that outbound connection. Therefore the client never exposes a port.
+// listener (or multiple connections if configured with [WithConnections]).
+// Proxied connections for the listener are then multiplexed over that
+// outbound connection. Therefore the client never exposes a port.
 type Client struct {
 	options options
 	logger  log.Logger
@@ -37,6 +47,11 @@ func New(opts ...Option) *Client {
 		token:       "",
 		upstreamURL: defaultUpstreamURL,
 		proxyURL:    defaultProxyURL,
+		heartbeat: heartbeatOption{
+			Interval: defaultHeartbeatInterval,
+			Timeout:  defaultHeartbeatTimeout,
+		},
+		connections: 1,
 		logger:      log.NewNopLogger(),
 	}
 	for _, o := range opts {
@@ -55,7 +70,7 @@ func New(opts ...Option) *Client {
 //
 // The returned [Listener] is a [net.Listener].
 func (c *Client) Listen(ctx context.Context, endpointID string) (Listener, error) {
-	return listen(ctx, endpointID, c.options, c.logger)
+	return c.listen(ctx, endpointID)
 }
 
 // ListenAndForward listens for connections on the given endpoint ID and
@@ -63,7 +78,7 @@ func (c *Client) Listen(ctx context.Context, endpointID string) (Listener, error
 func (c *Client) ListenAndForward(
 	ctx context.Context, endpointID string, addr string,
 ) error {
-	ln, err := listen(ctx, endpointID, c.options, c.logger)
+	ln, err := c.listen(ctx, endpointID)
 	if err != nil {
 		return fmt.Errorf("listen: %w", err)
 	}
@@ -82,7 +97,20 @@ func (c *Client) ListenAndForward(
 // Dial opens a TCP connection to an upstream listening on the given endpoint
 // ID via Piko.
 func (c *Client) Dial(ctx context.Context, endpointID string) (net.Conn, error) {
-	return websocket.Dial(ctx, proxyTCPURL(c.options.proxyURL, endpointID))
+	return websocket.Dial(
+		ctx,
+		proxyTCPURL(c.options.proxyURL, endpointID),
+		websocket.WithToken(c.options.token),
+	)
+}
+
+func (c *Client) listen(ctx context.Context, endpointID string) (contextListener, error) {
+	if c.options.connections > 1 {
+		return listenMulti(
+			ctx, endpointID, c.options.connections, c.options, c.logger,
+		)
+	}
+	return listen(ctx, endpointID, c.options, c.logger)
 }
 
 func (c *Client) forwardConn(ctx context.Context, conn net.Conn, addr string) {
@@ -4,12 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/andydunstall/piko/pkg/backoff"
+	"github.com/andydunstall/piko/pkg/compress"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/tunnel"
 	"github.com/andydunstall/piko/pkg/websocket"
 	"github.com/hashicorp/yamux"
 	"go.uber.org/zap"
@@ -44,13 +50,27 @@ type Listener interface {
 	EndpointID() string
 }
 
+// contextListener is a Listener that can accept connections with a context.
+type contextListener interface {
+	Listener
+
+	AcceptWithContext(ctx context.Context) (net.Conn, error)
+}
+
 type listener struct {
 	endpointID string
 
-	sess *yamux.Session
-
 	options options
 
+	// connCh receives connections accepted from the server.
+	connCh chan net.Conn
+
+	// failedCh is closed if the listener fails to reconnect to the server,
+	// where err is the reconnect error.
+	failedCh chan struct{}
+	failOnce sync.Once
+	err      error
+
 	closeCtx    context.Context
 	closeCancel func()
 
@@ -67,64 +87,37 @@ func listen(
 	ln := &listener{
 		endpointID:  endpointID,
 		options:     options,
+		connCh:      make(chan net.Conn),
+		failedCh:    make(chan struct{}),
 		closeCtx:    closeCtx,
 		closeCancel: closeCancel,
 		logger:      logger,
 	}
-	sess, err := ln.connect(ctx)
+	sess, control, err := ln.connect(ctx)
 	if err != nil {
+		closeCancel()
 		return nil, fmt.Errorf("connect: %w", err)
 	}
-	ln.sess = sess
+	ln.serve(sess, control)
 
 	return ln, nil
 }
 
 // Accept accepts a proxied connection for the endpoint.
 func (l *listener) Accept() (net.Conn, error) {
-	for {
-		conn, err := l.sess.Accept()
-		if err == nil {
-			return conn, nil
-		}
-
-		if l.closeCtx.Err() != nil {
-			return nil, err
-		}
-
-		l.logger.Warn("failed to accept conn", zap.Error(err))
-
-		sess, err := l.connect(l.closeCtx)
-		if err != nil {
-			return nil, err
-		}
-
-		l.sess = sess
-	}
+	return l.AcceptWithContext(context.Background())
 }
 
 func (l *listener) AcceptWithContext(ctx context.Context) (net.Conn, error) {
-	for {
-		conn, err := l.sess.AcceptStreamWithContext(ctx)
-		if err == nil {
-			return conn, nil
-		}
-
-		if ctx.Err() != nil {
-			return nil, err
-		}
-		if l.closeCtx.Err() != nil {
-			return nil, err
-		}
-
-		l.logger.Warn("failed to accept conn", zap.Error(err))
-
-		sess, err := l.connect(l.closeCtx)
-		if err != nil {
-			return nil, err
-		}
-
-		l.sess = sess
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.failedCh:
+		return nil, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.closeCtx.Done():
+		return nil, net.ErrClosed
 	}
 }
 
@@ -132,40 +125,119 @@ func (l *listener) Addr() net.Addr {
 	return &pikoAddr{endpointID: l.endpointID}
 }
 
+// Close closes the listener, including closing its connections to the
+// server.
 func (l *listener) Close() error {
 	l.closeCancel()
-
-	return l.sess.Close()
+	return nil
 }
 
 func (l *listener) EndpointID() string {
 	return l.endpointID
 }
 
-func (l *listener) connect(ctx context.Context) (*yamux.Session, error) {
-	backoff := backoff.New(0, minReconnectBackoff, maxReconnectBackoff)
+// serve accepts connections from the session in the background.
+//
+// If the session closes, or the server sends ControlGoAway on the control
+// stream, the listener reconnects. When asked to reconnect, the existing
+// session stays open until closed by the server, so connections already
+// opened by the server aren't interrupted.
+func (l *listener) serve(sess *yamux.Session, control net.Conn) {
+	var reconnectOnce sync.Once
+	reconnect := func() {
+		reconnectOnce.Do(l.reconnect)
+	}
+
+	// goAway indicates whether the server asked the listener to reconnect.
+	var goAway atomic.Bool
+	if control != nil {
+		go l.readControl(control, func() {
+			goAway.Store(true)
+			reconnect()
+		})
+	}
+
+	go func() {
+		stop := context.AfterFunc(l.closeCtx, func() {
+			sess.Close()
+		})
+		defer stop()
+
+		for {
+			conn, err := sess.Accept()
+			if err != nil {
+				// The server closes the session once its connections
+				// complete after asking the listener to reconnect.
+				if l.closeCtx.Err() == nil && !goAway.Load() {
+					l.logger.Warn("failed to accept conn", zap.Error(err))
+				}
+				sess.Close()
+				reconnect()
+				return
+			}
+
+			select {
+			case l.connCh <- conn:
+			case <-l.closeCtx.Done():
+				conn.Close()
+				return
+			}
+		}
+	}()
+}
+
+// readControl reads control messages from the server until the control
+// stream is closed.
+func (l *listener) readControl(control net.Conn, goAway func()) {
+	defer control.Close()
+
+	b := make([]byte, 1)
 	for {
-		conn, err := websocket.Dial(
-			ctx,
-			upstreamURL(l.options.upstreamURL, l.endpointID),
-			websocket.WithToken(l.options.token),
-			websocket.WithTLSConfig(l.options.tlsConfig),
-		)
-		if err == nil {
-			l.logger.Debug(
-				"listener connected",
+		if _, err := io.ReadFull(control, b); err != nil {
+			return
+		}
+
+		switch b[0] {
+		case tunnel.ControlGoAway:
+			l.logger.Info(
+				"server requested reconnect",
 				zap.String("url", upstreamURL(l.options.upstreamURL, l.endpointID)),
 			)
+			goAway()
+		default:
+			// Ignore unknown messages so the server can add new messages.
+			l.logger.Debug(
+				"unknown control message",
+				zap.Uint8("message", b[0]),
+			)
+		}
+	}
+}
 
-			muxConfig := yamux.DefaultConfig()
-			muxConfig.Logger = l.logger.StdLogger(zap.WarnLevel)
-			muxConfig.LogOutput = nil
-			sess, err := yamux.Client(conn, muxConfig)
-			if err != nil {
-				// Will not happen.
-				panic("yamux client: " + err.Error())
-			}
-			return sess, nil
+func (l *listener) reconnect() {
+	if l.closeCtx.Err() != nil {
+		return
+	}
+
+	sess, control, err := l.connect(l.closeCtx)
+	if err != nil {
+		if l.closeCtx.Err() == nil {
+			l.failOnce.Do(func() {
+				l.err = err
+				close(l.failedCh)
+			})
+		}
+		return
+	}
+	l.serve(sess, control)
+}
+
+func (l *listener) connect(ctx context.Context) (*yamux.Session, net.Conn, error) {
+	backoff := backoff.New(0, minReconnectBackoff, maxReconnectBackoff)
+	for {
+		sess, control, err := l.dial(ctx)
+		if err == nil {
+			return sess, control, nil
 		}
 
 		var retryableError *websocket.RetryableError
@@ -175,7 +247,7 @@ func (l *listener) connect(ctx context.Context) (*yamux.Session, error) {
 				zap.String("url", upstreamURL(l.options.upstreamURL, l.endpointID)),
 				zap.Error(err),
 			)
-			return nil, err
+			return nil, nil, err
 		}
 
 		l.logger.Warn(
@@ -185,12 +257,180 @@ func (l *listener) connect(ctx context.Context) (*yamux.Session, error) {
 		)
 
 		if !backoff.Wait(ctx) {
-			return nil, ctx.Err()
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// dial connects to the server, and opens a control stream if supported by
+// the server. The control stream is nil if not supported.
+func (l *listener) dial(ctx context.Context) (*yamux.Session, net.Conn, error) {
+	conn, err := websocket.Dial(
+		ctx,
+		upstreamURL(l.options.upstreamURL, l.endpointID),
+		l.dialOptions()...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l.logger.Debug(
+		"listener connected",
+		zap.String("url", upstreamURL(l.options.upstreamURL, l.endpointID)),
+	)
+
+	muxConfig := yamux.DefaultConfig()
+	// Note yamux closes the session if a heartbeat isn't acknowledged within
+	// the connection write timeout.
+	muxConfig.KeepAliveInterval = l.options.heartbeat.Interval
+	muxConfig.ConnectionWriteTimeout = l.options.heartbeat.Timeout
+	muxConfig.Logger = l.logger.StdLogger(zap.WarnLevel)
+	muxConfig.LogOutput = nil
+	sess, err := yamux.Client(conn, muxConfig)
+	if err != nil {
+		// The configuration is invalid so retrying won't help.
+		conn.Close()
+		return nil, nil, fmt.Errorf("yamux client: %w", err)
+	}
+
+	// Servers that don't respond with a version only support version 1.
+	version, _ := strconv.Atoi(conn.Header().Get(tunnel.VersionHeader))
+	if version < tunnel.ControlVersion {
+		return sess, nil, nil
+	}
+
+	control, err := sess.OpenStream()
+	if err != nil {
+		sess.Close()
+		return nil, nil, websocket.NewRetryableError(
+			fmt.Errorf("open control stream: %w", err),
+		)
+	}
+	return sess, control, nil
+}
+
+func (l *listener) dialOptions() []websocket.DialOption {
+	opts := []websocket.DialOption{
+		websocket.WithToken(l.options.token),
+		websocket.WithTLSConfig(l.options.tlsConfig),
+		websocket.WithHeader(tunnel.VersionHeader, strconv.Itoa(tunnel.Version)),
+	}
+	if compress.Enabled(l.options.compression) {
+		opts = append(opts, websocket.WithHeader(
+			compress.TunnelAcceptEncodingHeader, l.options.compression,
+		))
+	}
+	return opts
+}
+
+// multiListener accepts connections for an endpoint from multiple
+// listeners, each with its own connection to the server.
+//
+// Each listener reconnects independently, so if one connection is lost the
+// endpoint still accepts connections on the others.
+type multiListener struct {
+	endpointID string
+
+	listeners []*listener
+
+	connCh chan net.Conn
+	errCh  chan error
+
+	closeCtx    context.Context
+	closeCancel func()
+}
+
+func listenMulti(
+	ctx context.Context,
+	endpointID string,
+	connections int,
+	options options,
+	logger log.Logger,
+) (*multiListener, error) {
+	closeCtx, closeCancel := context.WithCancel(context.Background())
+	ln := &multiListener{
+		endpointID:  endpointID,
+		connCh:      make(chan net.Conn),
+		errCh:       make(chan error, connections),
+		closeCtx:    closeCtx,
+		closeCancel: closeCancel,
+	}
+	// Open all connections before returning, so the endpoint has its full
+	// capacity once registered.
+	for i := 0; i != connections; i++ {
+		l, err := listen(ctx, endpointID, options, logger)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		ln.listeners = append(ln.listeners, l)
+	}
+	for _, l := range ln.listeners {
+		go ln.accept(l)
+	}
+	return ln, nil
+}
+
+// Accept accepts a proxied connection for the endpoint from any of the
+// listeners.
+func (l *multiListener) Accept() (net.Conn, error) {
+	return l.AcceptWithContext(context.Background())
+}
+
+func (l *multiListener) AcceptWithContext(ctx context.Context) (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case err := <-l.errCh:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.closeCtx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *multiListener) Addr() net.Addr {
+	return &pikoAddr{endpointID: l.endpointID}
+}
+
+func (l *multiListener) Close() error {
+	l.closeCancel()
+
+	var errs error
+	for _, ln := range l.listeners {
+		errs = errors.Join(errs, ln.Close())
+	}
+	return errs
+}
+
+func (l *multiListener) EndpointID() string {
+	return l.endpointID
+}
+
+func (l *multiListener) accept(ln *listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			// The listener only returns an error if closed or it fails to
+			// reconnect.
+			if l.closeCtx.Err() == nil {
+				l.errCh <- err
+			}
+			return
+		}
+
+		select {
+		case l.connCh <- conn:
+		case <-l.closeCtx.Done():
+			conn.Close()
+			return
 		}
 	}
 }
 
 var _ Listener = &listener{}
+var _ Listener = &multiListener{}
 
 func upstreamURL(urlStr, endpointID string) string {
 	// Already verified URL in Config.Validate.
@@ -2,6 +2,7 @@ package client
 
 import (
 	"crypto/tls"
+	"time"
 
 	"github.com/andydunstall/piko/pkg/log"
 )
@@ -11,6 +12,9 @@ type options struct {
 	proxyURL    string
 	upstreamURL string
 	tlsConfig   *tls.Config
+	compression string
+	heartbeat   heartbeatOption
+	connections int
 	logger      log.Logger
 }
 
@@ -66,6 +70,65 @@ func WithTLSConfig(config *tls.Config) Option {
 	return tlsConfigOption{TLSConfig: config}
 }
 
+type compressionOption string
+
+func (o compressionOption) apply(opts *options) {
+	opts.compression = string(o)
+}
+
+// WithCompression requests the server compresses HTTP requests sent to
+// listeners using the given encoding, either 'gzip' or 'zstd'.
+//
+// Listeners must then decompress requests, and may compress responses,
+// using [github.com/andydunstall/piko/pkg/compress.DecompressRequest]. So this must only be used when
+// serving HTTP requests, such as with the Piko agent.
+func WithCompression(encoding string) Option {
+	return compressionOption(encoding)
+}
+
+type heartbeatOption struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+func (o heartbeatOption) apply(opts *options) {
+	if o.Interval <= 0 {
+		o.Interval = defaultHeartbeatInterval
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultHeartbeatTimeout
+	}
+	opts.heartbeat = o
+}
+
+// WithHeartbeat configures the interval to send heartbeats to the server,
+// and the timeout waiting for a heartbeat response before closing the
+// connection and reconnecting.
+//
+// Defaults to an interval of 10 seconds and a timeout of 10 seconds. A zero
+// or negative interval or timeout uses the default.
+func WithHeartbeat(interval time.Duration, timeout time.Duration) Option {
+	return heartbeatOption{Interval: interval, Timeout: timeout}
+}
+
+type connectionsOption int
+
+func (o connectionsOption) apply(opts *options) {
+	opts.connections = int(o)
+}
+
+// WithConnections configures the number of connections each listener opens
+// to the server.
+//
+// The server load balances requests among the connections, so multiple
+// connections spread load, and if one connection is lost the listener
+// still accepts requests on the other connections while it reconnects.
+//
+// Defaults to 1.
+func WithConnections(n int) Option {
+	return connectionsOption(n)
+}
+
 type loggerOption struct {
 	Logger log.Logger
 }
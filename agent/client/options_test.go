@@ -0,0 +1,28 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithHeartbeat(t *testing.T) {
+	t.Run("configured", func(t *testing.T) {
+		c := New(WithHeartbeat(time.Second, time.Second*2))
+		assert.Equal(t, time.Second, c.options.heartbeat.Interval)
+		assert.Equal(t, time.Second*2, c.options.heartbeat.Timeout)
+	})
+
+	// Tests a zero or negative heartbeat uses the defaults rather than an
+	// invalid yamux configuration.
+	t.Run("defaults", func(t *testing.T) {
+		c := New(WithHeartbeat(0, 0))
+		assert.Equal(t, defaultHeartbeatInterval, c.options.heartbeat.Interval)
+		assert.Equal(t, defaultHeartbeatTimeout, c.options.heartbeat.Timeout)
+
+		c = New(WithHeartbeat(-time.Second, -time.Second))
+		assert.Equal(t, defaultHeartbeatInterval, c.options.heartbeat.Interval)
+		assert.Equal(t, defaultHeartbeatTimeout, c.options.heartbeat.Timeout)
+	})
+}
@@ -10,7 +10,10 @@ import (
 	"strconv"
 	"time"
 
+	pikoconfig "github.com/andydunstall/piko/pkg/config"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/spiffe"
+	"github.com/andydunstall/piko/pkg/telemetry"
 	"github.com/spf13/pflag"
 )
 
@@ -166,23 +169,80 @@ type ConnectConfig struct {
 	// Token is a token to authenticate with the Piko server.
 	Token string
 
+	// TokenFile is the path of a file containing the token.
+	TokenFile string `json:"token_file" yaml:"token_file"`
+
 	// Timeout is the timeout attempting to connect to the Piko server on
 	// boot.
 	Timeout time.Duration `json:"timeout" yaml:"timeout"`
 
+	// HeartbeatInterval is the interval to send heartbeats to the Piko
+	// server.
+	HeartbeatInterval time.Duration `json:"heartbeat_interval" yaml:"heartbeat_interval"`
+
+	// HeartbeatTimeout is the timeout waiting for a heartbeat response
+	// before closing the connection and reconnecting.
+	HeartbeatTimeout time.Duration `json:"heartbeat_timeout" yaml:"heartbeat_timeout"`
+
+	// Connections is the number of connections to open to the Piko server
+	// for each listener.
+	Connections int `json:"connections" yaml:"connections"`
+
+	// Compression is the encoding to request the Piko server uses to
+	// compress HTTP requests to the agent, either 'gzip', 'zstd' or 'off'.
+	Compression string `json:"compression" yaml:"compression"`
+
 	TLS TLSConfig `json:"tls" yaml:"tls"`
+
+	// SPIFFE configures using a SPIFFE X.509 SVID for mutual TLS with the
+	// Piko server.
+	SPIFFE spiffe.Config `json:"spiffe" yaml:"spiffe"`
+}
+
+// LoadSecrets loads the token from a file if configured.
+func (c *ConnectConfig) LoadSecrets() error {
+	if err := pikoconfig.LoadSecretFile(&c.Token, c.TokenFile); err != nil {
+		return fmt.Errorf("token: %w", err)
+	}
+	return nil
 }
 
 func (c *ConnectConfig) Validate() error {
 	if c.URL == "" {
 		return fmt.Errorf("missing url")
 	}
-	if _, err := url.Parse(c.URL); err != nil {
+	u, err := url.Parse(c.URL)
+	if err != nil {
 		return fmt.Errorf("invalid url: %w", err)
 	}
 	if c.Timeout == 0 {
 		return fmt.Errorf("missing timeout")
 	}
+	if c.HeartbeatInterval <= 0 {
+		return fmt.Errorf("missing heartbeat interval")
+	}
+	if c.HeartbeatTimeout <= 0 {
+		return fmt.Errorf("missing heartbeat timeout")
+	}
+	if c.Connections < 1 {
+		return fmt.Errorf("connections must be at least 1")
+	}
+	switch c.Compression {
+	case "", "off", "gzip", "zstd":
+	default:
+		return fmt.Errorf("unsupported compression: %s", c.Compression)
+	}
+	if err := c.SPIFFE.Validate(); err != nil {
+		return fmt.Errorf("spiffe: %w", err)
+	}
+	if c.SPIFFE.Enabled() {
+		if u.Scheme != "https" {
+			return fmt.Errorf("spiffe requires an 'https' url")
+		}
+		if c.TLS.RootCAs != "" {
+			return fmt.Errorf("cannot set both spiffe and tls root cas")
+		}
+	}
 	return nil
 }
 
@@ -204,6 +264,15 @@ Piko server 'upstream' port.`,
 Token is a token to authenticate with the Piko server.`,
 	)
 
+	fs.StringVar(
+		&c.TokenFile,
+		"connect.token-file",
+		c.TokenFile,
+		`
+Path of a file containing the token to authenticate with the Piko server, so
+the token isn't passed as a flag or environment variable.`,
+	)
+
 	fs.DurationVar(
 		&c.Timeout,
 		"connect.timeout",
@@ -214,7 +283,59 @@ is disconnected after the initial connection succeeds it will keep trying to
 reconnect.`,
 	)
 
+	fs.DurationVar(
+		&c.HeartbeatInterval,
+		"connect.heartbeat-interval",
+		c.HeartbeatInterval,
+		`
+The interval to send heartbeats to the Piko server to detect a broken
+connection.`,
+	)
+
+	fs.DurationVar(
+		&c.HeartbeatTimeout,
+		"connect.heartbeat-timeout",
+		c.HeartbeatTimeout,
+		`
+The timeout waiting for a heartbeat response (or writing to the connection)
+before closing the connection and reconnecting.
+
+The agent detects a broken connection within the heartbeat interval plus the
+heartbeat timeout.`,
+	)
+
+	fs.IntVar(
+		&c.Connections,
+		"connect.connections",
+		c.Connections,
+		`
+The number of connections to open to the Piko server for each listener.
+
+The server load balances requests among the connections, so multiple
+connections spread load (which may be across multiple server nodes), and if a
+connection is lost the listener still receives requests on the other
+connections while it reconnects.`,
+	)
+
+	fs.StringVar(
+		&c.Compression,
+		"connect.compression",
+		c.Compression,
+		`
+The encoding to request the Piko server uses to compress HTTP requests and
+responses sent to HTTP listeners, either 'gzip', 'zstd' or 'off'.
+
+Compression is negotiated when connecting, so if the server doesn't support
+compression requests are sent uncompressed. Bodies that are already
+compressed, such as with a 'Content-Encoding' or an image, video or archive
+content type, aren't compressed again.
+
+Compressing requests reduces the bandwidth between the agent and server, such
+as for verbose JSON APIs over metered links, at the cost of additional CPU.`,
+	)
+
 	c.TLS.RegisterFlags(fs, "connect")
+	c.SPIFFE.RegisterFlags(fs, "connect")
 }
 
 type ServerConfig struct {
@@ -251,6 +372,8 @@ type Config struct {
 
 	Log log.Config `json:"log" yaml:"log"`
 
+	Telemetry telemetry.Config `json:"telemetry" yaml:"telemetry"`
+
 	// GracePeriod is the duration to gracefully shutdown the agent. During
 	// the grace period, listeners and idle connections are closed, then waits
 	// for active requests to complete and closes their connections.
@@ -260,19 +383,41 @@ type Config struct {
 func Default() *Config {
 	return &Config{
 		Connect: ConnectConfig{
-			URL:     "http://localhost:8001",
-			Timeout: time.Second * 30,
+			URL:               "http://localhost:8001",
+			Timeout:           time.Second * 30,
+			HeartbeatInterval: time.Second * 10,
+			HeartbeatTimeout:  time.Second * 10,
+			Connections:       1,
+			Compression:       "off",
 		},
 		Server: ServerConfig{
 			BindAddr: ":5000",
 		},
 		Log: log.Config{
-			Level: "info",
+			Level:  "info",
+			Format: "json",
+			File: log.FileConfig{
+				MaxSize:        100,
+				RotateInterval: time.Hour * 24,
+				MaxBackups:     5,
+			},
+		},
+		Telemetry: telemetry.Config{
+			SampleRate: 1,
 		},
 		GracePeriod: time.Minute,
 	}
 }
 
+// LoadSecrets loads any secrets configured from files. This must be called
+// before Validate.
+func (c *Config) LoadSecrets() error {
+	if err := c.Connect.LoadSecrets(); err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	return nil
+}
+
 func (c *Config) Validate() error {
 	// Note don't validate the number of listeners, as some commands don't
 	// require any.
@@ -297,6 +442,10 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("log: %w", err)
 	}
 
+	if err := c.Telemetry.Validate(); err != nil {
+		return fmt.Errorf("telemetry: %w", err)
+	}
+
 	if c.GracePeriod == 0 {
 		return fmt.Errorf("missing grace period")
 	}
@@ -308,6 +457,7 @@ func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
 	c.Connect.RegisterFlags(fs)
 	c.Server.RegisterFlags(fs)
 	c.Log.RegisterFlags(fs)
+	c.Telemetry.RegisterFlags(fs)
 
 	fs.DurationVar(
 		&c.GracePeriod,
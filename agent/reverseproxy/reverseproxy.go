@@ -9,7 +9,10 @@ import (
 	"time"
 
 	"github.com/andydunstall/piko/agent/config"
+	"github.com/andydunstall/piko/pkg/compress"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/streaming"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -42,20 +45,37 @@ func NewReverseProxy(conf config.ListenerConfig, logger log.Logger) *ReverseProx
 }
 
 func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if p.timeout != 0 {
-		ctx, cancel := context.WithTimeout(r.Context(), p.timeout)
-		defer cancel()
+	// Streaming responses, such as Server-Sent Events, are exempt from the
+	// timeout once the upstream responds.
+	ctx, w, cancel := streaming.WithTimeout(r.Context(), w, p.timeout)
+	defer cancel()
 
-		r = r.WithContext(ctx)
+	r = r.WithContext(ctx)
+
+	// If the agent requested compression when connecting, the server may
+	// compress the request body and accept a compressed response.
+	if r.Header.Get("Upgrade") == "" {
+		compressedW, compressedR, finish, err := compress.DecompressRequest(w, r)
+		if err != nil {
+			p.logger.Warn("compressed request", zap.Error(err))
+
+			_ = errorResponse(w, http.StatusBadRequest, "invalid compressed request")
+			return
+		}
+		defer finish()
+
+		w, r = compressedW, compressedR
 	}
 
 	p.proxy.ServeHTTP(w, r)
 }
 
-func (p *ReverseProxy) errorHandler(w http.ResponseWriter, _ *http.Request, err error) {
+func (p *ReverseProxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
 	p.logger.Warn("proxy request", zap.Error(err))
 
-	if errors.Is(err, context.DeadlineExceeded) {
+	trace.SpanFromContext(r.Context()).RecordError(err)
+
+	if errors.Is(err, context.DeadlineExceeded) || streaming.TimedOut(r.Context()) {
 		_ = errorResponse(w, http.StatusGatewayTimeout, "upstream timeout")
 		return
 	}
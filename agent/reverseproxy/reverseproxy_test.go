@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/andydunstall/piko/agent/config"
+	"github.com/andydunstall/piko/pkg/compress"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/stretchr/testify/assert"
 )
@@ -55,6 +56,54 @@ func TestReverseProxy_Forward(t *testing.T) {
 		assert.Equal(t, "bar", buf.String())
 	})
 
+	t.Run("compressed", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Empty(t, r.Header.Get(compress.EncodingHeader))
+
+				buf := new(strings.Builder)
+				// nolint
+				io.Copy(buf, r.Body)
+				assert.Equal(t, strings.Repeat("foo", 100), buf.String())
+
+				// nolint
+				w.Write([]byte(strings.Repeat("bar", 100)))
+			},
+		))
+		defer upstream.Close()
+
+		proxy := NewReverseProxy(config.ListenerConfig{
+			EndpointID: "my-endpoint",
+			Addr:       upstream.URL,
+		}, log.NewNopLogger())
+
+		transport := compress.NewTransport(roundTripperFunc(
+			func(r *http.Request) (*http.Response, error) {
+				assert.Equal(t, "zstd", r.Header.Get(compress.EncodingHeader))
+
+				w := httptest.NewRecorder()
+				proxy.ServeHTTP(w, r)
+				resp := w.Result()
+				assert.Equal(t, "zstd", resp.Header.Get(compress.EncodingHeader))
+				return resp, nil
+			},
+		), "zstd")
+
+		r := httptest.NewRequest(
+			http.MethodPost, "/", strings.NewReader(strings.Repeat("foo", 100)),
+		)
+		resp, err := transport.RoundTrip(r)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		buf := new(strings.Builder)
+		// nolint
+		io.Copy(buf, resp.Body)
+		assert.Equal(t, strings.Repeat("bar", 100), buf.String())
+	})
+
 	t.Run("timeout", func(t *testing.T) {
 		blockCh := make(chan struct{})
 		upstream := httptest.NewServer(http.HandlerFunc(
@@ -107,3 +156,9 @@ func TestReverseProxy_Forward(t *testing.T) {
 		assert.Equal(t, "upstream unreachable", m.Error)
 	})
 }
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
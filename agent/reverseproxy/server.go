@@ -11,6 +11,7 @@ import (
 	"github.com/andydunstall/piko/pkg/middleware"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -55,6 +56,11 @@ func NewServer(
 	}
 	router.Use(metrics.Handler())
 
+	router.Use(middleware.NewTracing(
+		"piko.agent.proxy",
+		attribute.String("piko.endpoint_id", conf.EndpointID),
+	))
+
 	s.router.NoRoute(s.proxyRoute)
 
 	return s
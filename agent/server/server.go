@@ -81,7 +81,11 @@ func (s *Server) panicRoute(c *gin.Context, err any) {
 func (s *Server) metricsHandler() gin.HandlerFunc {
 	h := promhttp.HandlerFor(
 		s.registry,
-		promhttp.HandlerOpts{Registry: s.registry},
+		promhttp.HandlerOpts{
+			Registry: s.registry,
+			// Enable OpenMetrics to expose exemplars.
+			EnableOpenMetrics: true,
+		},
 	)
 	return func(c *gin.Context) {
 		h.ServeHTTP(c.Writer, c.Request)
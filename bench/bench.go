@@ -0,0 +1,215 @@
+// Package bench benchmarks a Piko cluster, by registering synthetic upstream
+// endpoints and sending proxy requests to those endpoints.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andydunstall/piko/agent/client"
+	agentconfig "github.com/andydunstall/piko/agent/config"
+	"github.com/andydunstall/piko/agent/reverseproxy"
+	"github.com/andydunstall/piko/bench/config"
+	"github.com/andydunstall/piko/pkg/log"
+	"go.uber.org/zap"
+)
+
+// Bench runs a benchmark against a Piko cluster.
+type Bench struct {
+	conf *config.Config
+
+	logger log.Logger
+}
+
+func NewBench(conf *config.Config, logger log.Logger) *Bench {
+	return &Bench{
+		conf:   conf,
+		logger: logger,
+	}
+}
+
+// Run registers the upstream endpoints, then sends requests for the
+// configured duration and returns a report of the results.
+//
+// If the context is cancelled, Run stops sending requests and returns a
+// report of the requests sent so far.
+func (b *Bench) Run(ctx context.Context) (*Report, error) {
+	// All endpoints forward to the same echo server.
+	server := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer server.Close()
+
+	upstreamsCtx, upstreamsCancel := context.WithCancel(context.Background())
+	defer upstreamsCancel()
+
+	var upstreamsWG sync.WaitGroup
+	defer upstreamsWG.Wait()
+
+	client := client.New(
+		client.WithToken(b.conf.Server.Token),
+		client.WithUpstreamURL(b.conf.Server.UpstreamURL),
+		client.WithLogger(b.logger.WithSubsystem("client")),
+	)
+	for i := 0; i != b.conf.Endpoints; i++ {
+		endpointID := b.endpointID(i)
+		ln, err := client.Listen(ctx, endpointID)
+		if err != nil {
+			upstreamsCancel()
+			return nil, fmt.Errorf("listen: %s: %w", endpointID, err)
+		}
+
+		proxy := reverseproxy.NewServer(agentconfig.ListenerConfig{
+			EndpointID: endpointID,
+			Addr:       server.Listener.Addr().String(),
+		}, nil, b.logger)
+
+		upstreamsWG.Add(2)
+		go func() {
+			defer upstreamsWG.Done()
+			_ = proxy.Serve(ln)
+		}()
+		go func() {
+			defer upstreamsWG.Done()
+			<-upstreamsCtx.Done()
+			_ = proxy.Shutdown(context.Background())
+			ln.Close()
+		}()
+	}
+
+	b.logger.Info(
+		"registered endpoints",
+		zap.Int("endpoints", b.conf.Endpoints),
+	)
+
+	report := b.sendRequests(ctx)
+	upstreamsCancel()
+	return report, nil
+}
+
+// sendRequests sends requests to the registered endpoints for the configured
+// duration.
+func (b *Bench) sendRequests(ctx context.Context) *Report {
+	ctx, cancel := context.WithTimeout(ctx, b.conf.Requests.Duration)
+	defer cancel()
+
+	// If a rate is configured, clients wait for a tick before sending each
+	// request, so the total rate across all clients is limited.
+	var ticks <-chan time.Time
+	if b.conf.Requests.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(b.conf.Requests.Rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: b.conf.Requests.Concurrency,
+		},
+	}
+
+	start := time.Now()
+
+	results := make([][]result, b.conf.Requests.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i != b.conf.Requests.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Offset each client so requests are distributed evenly across
+			// endpoints.
+			next := i
+			for {
+				if ticks != nil {
+					select {
+					case <-ticks:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+
+				endpointID := b.endpointID(next % b.conf.Endpoints)
+				next++
+
+				r := b.sendRequest(ctx, httpClient, endpointID)
+				// Ignore requests interrupted by the benchmark ending.
+				if ctx.Err() != nil {
+					return
+				}
+				results[i] = append(results[i], r)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var merged []result
+	for _, r := range results {
+		merged = append(merged, r...)
+	}
+	return newReport(merged, time.Since(start))
+}
+
+func (b *Bench) sendRequest(
+	ctx context.Context,
+	httpClient *http.Client,
+	endpointID string,
+) result {
+	body := make([]byte, b.conf.Requests.Size)
+
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, b.conf.Server.ProxyURL, bytes.NewReader(body),
+	)
+	if err != nil {
+		return result{err: "request: " + err.Error()}
+	}
+	req.Header.Set("x-piko-endpoint", endpointID)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		b.logger.Debug("request failed", zap.Error(err))
+		return result{latency: time.Since(start), err: "request failed"}
+	}
+	defer resp.Body.Close()
+
+	n, err := io.Copy(io.Discard, resp.Body)
+	latency := time.Since(start)
+	if resp.StatusCode != http.StatusOK {
+		return result{
+			latency: latency,
+			err:     "status " + strconv.Itoa(resp.StatusCode),
+		}
+	}
+	if err != nil {
+		b.logger.Debug("read response failed", zap.Error(err))
+		return result{latency: latency, err: "read response failed"}
+	}
+	if n != int64(len(body)) {
+		return result{latency: latency, err: "invalid response size"}
+	}
+	return result{latency: latency}
+}
+
+func (b *Bench) endpointID(i int) string {
+	return b.conf.EndpointPrefix + strconv.Itoa(i)
+}
+
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	// Note can't use io.Copy as not supported by http.ResponseWriter.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	_, _ = w.Write(body)
+}
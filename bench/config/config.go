@@ -0,0 +1,233 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/spf13/pflag"
+)
+
+type ServerConfig struct {
+	// ProxyURL is the URL of the Piko server proxy port.
+	ProxyURL string `json:"proxy_url" yaml:"proxy_url"`
+
+	// UpstreamURL is the URL of the Piko server upstream port.
+	UpstreamURL string `json:"upstream_url" yaml:"upstream_url"`
+
+	// Token is a token to authenticate the upstreams with the server.
+	Token string `json:"token" yaml:"token"`
+}
+
+func (c *ServerConfig) Validate() error {
+	if c.ProxyURL == "" {
+		return fmt.Errorf("missing proxy url")
+	}
+	if _, err := url.Parse(c.ProxyURL); err != nil {
+		return fmt.Errorf("invalid proxy url: %w", err)
+	}
+	if c.UpstreamURL == "" {
+		return fmt.Errorf("missing upstream url")
+	}
+	if _, err := url.Parse(c.UpstreamURL); err != nil {
+		return fmt.Errorf("invalid upstream url: %w", err)
+	}
+	return nil
+}
+
+type RequestsConfig struct {
+	// Concurrency is the number of concurrent requests.
+	Concurrency int `json:"concurrency" yaml:"concurrency"`
+
+	// Rate is the total number of requests per second to send. If zero,
+	// each client sends requests as fast as possible.
+	Rate int `json:"rate" yaml:"rate"`
+
+	// Duration is the duration to send requests.
+	Duration time.Duration `json:"duration" yaml:"duration"`
+
+	// Size is the size of each request body.
+	Size int `json:"size" yaml:"size"`
+}
+
+func (c *RequestsConfig) Validate() error {
+	if c.Concurrency <= 0 {
+		return fmt.Errorf("concurrency must be greater than 0")
+	}
+	if c.Rate < 0 {
+		return fmt.Errorf("rate cannot be negative")
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("duration must be greater than 0")
+	}
+	if c.Size < 0 {
+		return fmt.Errorf("size cannot be negative")
+	}
+	return nil
+}
+
+type Config struct {
+	// Endpoints is the number of synthetic endpoints to register.
+	Endpoints int `json:"endpoints" yaml:"endpoints"`
+
+	// EndpointPrefix is the prefix of each registered endpoint ID.
+	EndpointPrefix string `json:"endpoint_prefix" yaml:"endpoint_prefix"`
+
+	Requests RequestsConfig `json:"requests" yaml:"requests"`
+
+	Server ServerConfig `json:"server" yaml:"server"`
+
+	Log log.Config `json:"log" yaml:"log"`
+}
+
+func Default() *Config {
+	return &Config{
+		Endpoints:      10,
+		EndpointPrefix: "bench-",
+		Requests: RequestsConfig{
+			Concurrency: 10,
+			Rate:        0,
+			Duration:    time.Second * 30,
+			Size:        1024,
+		},
+		Server: ServerConfig{
+			ProxyURL:    "http://localhost:8000",
+			UpstreamURL: "http://localhost:8001",
+		},
+		Log: log.Config{
+			Level:  "error",
+			Format: "json",
+		},
+	}
+}
+
+func (c *Config) Validate() error {
+	if c.Endpoints <= 0 {
+		return fmt.Errorf("endpoints must be greater than 0")
+	}
+	if err := c.Requests.Validate(); err != nil {
+		return fmt.Errorf("requests: %w", err)
+	}
+	if err := c.Server.Validate(); err != nil {
+		return fmt.Errorf("server: %w", err)
+	}
+	if err := c.Log.Validate(); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+	return nil
+}
+
+func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
+	c.RegisterLoadFlags(fs)
+
+	fs.StringVar(
+		&c.Server.ProxyURL,
+		"server.proxy-url",
+		c.Server.ProxyURL,
+		`
+Piko server proxy URL.`,
+	)
+
+	fs.StringVar(
+		&c.Server.UpstreamURL,
+		"server.upstream-url",
+		c.Server.UpstreamURL,
+		`
+Piko server upstream URL.`,
+	)
+
+	fs.StringVar(
+		&c.Server.Token,
+		"server.token",
+		c.Server.Token,
+		`
+Token to authenticate the upstreams with the server, if the server requires
+authentication.`,
+	)
+
+	c.Log.RegisterFlags(fs)
+}
+
+// RegisterLoadFlags registers the flags configuring the endpoints and
+// requests, excluding the server to benchmark.
+func (c *Config) RegisterLoadFlags(fs *pflag.FlagSet) {
+	fs.IntVar(
+		&c.Endpoints,
+		"endpoints",
+		c.Endpoints,
+		`
+The number of synthetic endpoints to register.
+
+Each endpoint has an upstream that echos the request body. Requests are
+distributed evenly across the endpoints.`,
+	)
+
+	fs.StringVar(
+		&c.EndpointPrefix,
+		"endpoint-prefix",
+		c.EndpointPrefix,
+		`
+The prefix of each registered endpoint ID, such as 'bench-' registers
+'bench-0', 'bench-1', ...`,
+	)
+
+	fs.IntVar(
+		&c.Requests.Concurrency,
+		"requests.concurrency",
+		c.Requests.Concurrency,
+		`
+The number of concurrent requests.`,
+	)
+
+	fs.IntVar(
+		&c.Requests.Rate,
+		"requests.rate",
+		c.Requests.Rate,
+		`
+The total number of requests per second to send across all clients.
+
+If 0, each client sends requests as fast as possible, to measure the maximum
+throughput.`,
+	)
+
+	fs.DurationVar(
+		&c.Requests.Duration,
+		"requests.duration",
+		c.Requests.Duration,
+		`
+The duration to send requests.`,
+	)
+
+	fs.IntVar(
+		&c.Requests.Size,
+		"requests.size",
+		c.Requests.Size,
+		`
+The size of each request body. As the upstream echos the request body, the
+response has the same size.`,
+	)
+}
+
+type ProfileConfig struct {
+	// Dir is the directory to write profiles to. If empty profiles aren't
+	// recorded.
+	Dir string `json:"dir" yaml:"dir"`
+}
+
+func (c *ProfileConfig) Enabled() bool {
+	return c.Dir != ""
+}
+
+func (c *ProfileConfig) RegisterFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&c.Dir,
+		"profile.dir",
+		c.Dir,
+		`
+The directory to write CPU, heap, allocation, mutex and goroutine profiles
+recorded during the benchmark, along with the benchmark report.
+
+If empty profiles aren't recorded.`,
+	)
+}
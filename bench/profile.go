@@ -0,0 +1,88 @@
+package bench
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+)
+
+// mutexProfileFraction is the fraction of mutex contention events recorded
+// while profiling.
+const mutexProfileFraction = 10
+
+// Profiler records profiles of the process while a benchmark runs.
+//
+// The CPU profile is recorded from Start until Stop, then Stop writes the
+// heap, allocation, mutex and goroutine profiles. Profiles are written to a
+// directory in pprof format, so can be compared across Piko versions using
+// 'go tool pprof -diff_base'.
+type Profiler struct {
+	dir string
+	cpu *os.File
+}
+
+func NewProfiler(dir string) *Profiler {
+	return &Profiler{
+		dir: dir,
+	}
+}
+
+// Start starts recording the CPU profile.
+func (p *Profiler) Start() error {
+	if err := os.MkdirAll(p.dir, 0o755); err != nil {
+		return fmt.Errorf("create dir: %w", err)
+	}
+
+	f, err := os.Create(filepath.Join(p.dir, "cpu.pprof"))
+	if err != nil {
+		return fmt.Errorf("create cpu profile: %w", err)
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return fmt.Errorf("start cpu profile: %w", err)
+	}
+	p.cpu = f
+
+	runtime.SetMutexProfileFraction(mutexProfileFraction)
+
+	return nil
+}
+
+// Stop stops recording the CPU profile, then writes the remaining profiles.
+// Returns the paths of the written profiles.
+func (p *Profiler) Stop() ([]string, error) {
+	pprof.StopCPUProfile()
+	runtime.SetMutexProfileFraction(0)
+
+	if err := p.cpu.Close(); err != nil {
+		return nil, fmt.Errorf("close cpu profile: %w", err)
+	}
+	paths := []string{p.cpu.Name()}
+
+	// Run a GC so the heap profile is up to date.
+	runtime.GC()
+
+	for _, name := range []string{"heap", "allocs", "mutex", "goroutine"} {
+		path := filepath.Join(p.dir, name+".pprof")
+		if err := writeProfile(name, path); err != nil {
+			return nil, fmt.Errorf("%s profile: %w", name, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func writeProfile(name string, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	defer f.Close()
+
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,109 @@
+package bench
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// result is the result of a single request.
+type result struct {
+	latency time.Duration
+
+	// err describes why the request failed, or is empty if the request
+	// succeeded.
+	err string
+}
+
+// LatencyReport contains request latency statistics.
+type LatencyReport struct {
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	Max  time.Duration `json:"max"`
+}
+
+// Report contains the results of a benchmark.
+type Report struct {
+	// Requests is the number of requests sent.
+	Requests int `json:"requests"`
+
+	// Errors is the number of failed requests.
+	Errors int `json:"errors"`
+
+	// ErrorRate is the fraction of requests that failed, from 0 to 1.
+	ErrorRate float64 `json:"error_rate"`
+
+	// Throughput is the number of requests per second.
+	Throughput float64 `json:"throughput"`
+
+	Duration time.Duration `json:"duration"`
+
+	// Latency contains the latency of successful requests.
+	Latency LatencyReport `json:"latency"`
+
+	// ErrorReasons maps the reason a request failed (such as 'status 502') to
+	// the number of requests that failed for that reason.
+	ErrorReasons map[string]int `json:"error_reasons,omitempty"`
+}
+
+func newReport(results []result, duration time.Duration) *Report {
+	report := &Report{
+		Requests: len(results),
+		Duration: duration,
+	}
+
+	var latencies []time.Duration
+	for _, r := range results {
+		if r.err != "" {
+			report.Errors++
+			if report.ErrorReasons == nil {
+				report.ErrorReasons = make(map[string]int)
+			}
+			report.ErrorReasons[r.err]++
+			continue
+		}
+		latencies = append(latencies, r.latency)
+	}
+
+	if report.Requests > 0 {
+		report.ErrorRate = float64(report.Errors) / float64(report.Requests)
+	}
+	if duration > 0 {
+		report.Throughput = float64(report.Requests) / duration.Seconds()
+	}
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool {
+			return latencies[i] < latencies[j]
+		})
+
+		var total time.Duration
+		for _, latency := range latencies {
+			total += latency
+		}
+		report.Latency = LatencyReport{
+			Mean: total / time.Duration(len(latencies)),
+			P50:  percentile(latencies, 0.5),
+			P90:  percentile(latencies, 0.9),
+			P99:  percentile(latencies, 0.99),
+			Max:  latencies[len(latencies)-1],
+		}
+	}
+
+	return report
+}
+
+// percentile returns the latency at the given percentile (from 0 to 1) using
+// the nearest-rank method. The latencies must be sorted.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(latencies)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(latencies) {
+		rank = len(latencies) - 1
+	}
+	return latencies[rank]
+}
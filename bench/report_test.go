@@ -0,0 +1,52 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var results []result
+		for i := 1; i <= 100; i++ {
+			results = append(results, result{
+				latency: time.Duration(i) * time.Millisecond,
+			})
+		}
+		results = append(results, result{
+			latency: time.Second,
+			err:     "status 502",
+		})
+		results = append(results, result{
+			err: "request failed",
+		})
+
+		report := newReport(results, time.Second*2)
+		assert.Equal(t, 102, report.Requests)
+		assert.Equal(t, 2, report.Errors)
+		assert.InDelta(t, 2.0/102, report.ErrorRate, 0.0001)
+		assert.InDelta(t, 51, report.Throughput, 0.0001)
+		assert.Equal(t, map[string]int{
+			"status 502":     1,
+			"request failed": 1,
+		}, report.ErrorReasons)
+
+		// Failed requests are excluded from the latency.
+		assert.Equal(t, LatencyReport{
+			Mean: time.Microsecond * 50500,
+			P50:  time.Millisecond * 50,
+			P90:  time.Millisecond * 90,
+			P99:  time.Millisecond * 99,
+			Max:  time.Millisecond * 100,
+		}, report.Latency)
+	})
+
+	t.Run("no requests", func(t *testing.T) {
+		report := newReport(nil, time.Second)
+		assert.Equal(t, 0, report.Requests)
+		assert.Equal(t, 0.0, report.ErrorRate)
+		assert.Equal(t, LatencyReport{}, report.Latency)
+	})
+}
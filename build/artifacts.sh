@@ -12,11 +12,13 @@ mkdir -p bin/artifacts
 for i in "${arr[@]}"
 do
 	VERSION=$(git describe)
+	COMMIT=$(git rev-parse HEAD)
+	BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
 	GOOSARCH=$i
 	GOOS=${GOOSARCH%/*}
 	GOARCH=${GOOSARCH#*/}
 	BINARY_NAME=piko-$GOOS-$GOARCH
 
 	echo "Building $BINARY_NAME $VERSION..."
-	GOOS=$GOOS GOARCH=$GOARCH go build -ldflags="-X github.com/andydunstall/piko/pkg/build.Version=$VERSION" -o bin/artifacts/$BINARY_NAME main.go
+	GOOS=$GOOS GOARCH=$GOARCH go build -ldflags="-X github.com/andydunstall/piko/pkg/build.Version=$VERSION -X github.com/andydunstall/piko/pkg/build.Commit=$COMMIT -X github.com/andydunstall/piko/pkg/build.Date=$BUILD_DATE" -o bin/artifacts/$BINARY_NAME main.go
 done
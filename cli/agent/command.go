@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/andydunstall/piko/agent/client"
 	"github.com/andydunstall/piko/agent/config"
@@ -16,6 +17,8 @@ import (
 	"github.com/andydunstall/piko/pkg/build"
 	pikoconfig "github.com/andydunstall/piko/pkg/config"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/spiffe"
+	"github.com/andydunstall/piko/pkg/telemetry"
 	rungroup "github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
@@ -68,11 +71,28 @@ Examples:
 	conf.RegisterFlags(cmd.PersistentFlags())
 	loadConf.RegisterFlags(cmd.PersistentFlags())
 
+	var validateConfig bool
+	cmd.PersistentFlags().BoolVar(
+		&validateConfig,
+		"validate-config",
+		false,
+		`
+Validate the configuration then exit, without starting the agent.
+
+This loads and validates the YAML configuration, environment variables and
+flags, and loads the TLS configuration. Exits with a non-zero status if the
+configuration is invalid, such as for CI and pre-deploy checks.`,
+	)
+
 	cmd.PersistentPreRun = func(_ *cobra.Command, _ []string) {
 		if err := loadConf.Load(conf); err != nil {
 			fmt.Println(err.Error())
 			os.Exit(1)
 		}
+		if err := conf.LoadSecrets(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
 
 		// Listener protocol defaults to HTTP.
 		for _, listener := range conf.Listeners {
@@ -85,33 +105,84 @@ Examples:
 			fmt.Printf("config: %s\n", err.Error())
 			os.Exit(1)
 		}
+
+		if validateConfig {
+			if _, err := conf.Connect.TLS.Load(); err != nil {
+				fmt.Printf("config: connect: tls: %s\n", err.Error())
+				os.Exit(1)
+			}
+			fmt.Println("config valid")
+			os.Exit(0)
+		}
 	}
 
 	cmd.AddCommand(newStartCommand(conf))
 	cmd.AddCommand(newHTTPCommand(conf))
 	cmd.AddCommand(newTCPCommand(conf))
+	cmd.AddCommand(newServiceCommand(&loadConf))
 
 	return cmd
 }
 
-func runAgent(conf *config.Config, logger log.Logger) error {
+// runAgent runs the agent until it receives a shutdown signal or the given
+// context is cancelled.
+func runAgent(ctx context.Context, conf *config.Config, logger log.Logger) error {
 	logger.Info(
 		"starting piko agent",
 		zap.String("version", build.Version),
 	)
 	logger.Debug("piko config", zap.Any("config", conf))
 
+	tracer, err := telemetry.NewTracer(conf.Telemetry, "piko-agent")
+	if err != nil {
+		return fmt.Errorf("telemetry: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		if err := tracer.Shutdown(ctx); err != nil {
+			logger.Warn("failed to shutdown tracer", zap.Error(err))
+		}
+	}()
+
 	connectTLSConfig, err := conf.Connect.TLS.Load()
 	if err != nil {
 		return fmt.Errorf("connect tls: %w", err)
 	}
+	if conf.Connect.SPIFFE.Enabled() {
+		spiffeCtx, spiffeCancel := context.WithTimeout(
+			context.Background(),
+			conf.Connect.Timeout,
+		)
+		defer spiffeCancel()
 
-	client := client.New(
+		// The source rotates the SVID as the Workload API pushes updates.
+		source, err := spiffe.NewSource(spiffeCtx, conf.Connect.SPIFFE, logger)
+		if err != nil {
+			return fmt.Errorf("connect spiffe: %w", err)
+		}
+		defer source.Close()
+
+		connectTLSConfig = source.ClientTLSConfig()
+	}
+
+	clientOpts := []client.Option{
 		client.WithToken(conf.Connect.Token),
 		client.WithUpstreamURL(conf.Connect.URL),
 		client.WithTLSConfig(connectTLSConfig),
+		client.WithHeartbeat(
+			conf.Connect.HeartbeatInterval, conf.Connect.HeartbeatTimeout,
+		),
+		client.WithConnections(conf.Connect.Connections),
 		client.WithLogger(logger.WithSubsystem("client")),
-	)
+	}
+	tcpClient := client.New(clientOpts...)
+	// Compression only applies to HTTP listeners, since TCP listeners
+	// forward raw connections.
+	httpClient := client.New(append(
+		clientOpts, client.WithCompression(conf.Connect.Compression),
+	)...)
 
 	registry := prometheus.NewRegistry()
 
@@ -124,7 +195,11 @@ func runAgent(conf *config.Config, logger log.Logger) error {
 		)
 		defer connectCancel()
 
-		ln, err := client.Listen(connectCtx, listenerConfig.EndpointID)
+		listenClient := tcpClient
+		if listenerConfig.Protocol == config.ListenerProtocolHTTP {
+			listenClient = httpClient
+		}
+		ln, err := listenClient.Listen(connectCtx, listenerConfig.EndpointID)
 		if err != nil {
 			return fmt.Errorf("listen: %s: %w", listenerConfig.EndpointID, err)
 		}
@@ -201,6 +276,9 @@ func runAgent(conf *config.Config, logger log.Logger) error {
 				zap.String("signal", sig.String()),
 			)
 			return nil
+		case <-ctx.Done():
+			logger.Info("received shutdown request")
+			return nil
 		case <-signalCtx.Done():
 			return nil
 		}
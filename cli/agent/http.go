@@ -1,11 +1,13 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"time"
 
 	"github.com/andydunstall/piko/agent/config"
+	"github.com/andydunstall/piko/cli/completion"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -13,9 +15,11 @@ import (
 
 func newHTTPCommand(conf *config.Config) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "http [endpoint] [addr] [flags]",
-		Args:  cobra.ExactArgs(2),
-		Short: "register a http listener",
+		Use:  "http [endpoint] [addr] [flags]",
+		Args: cobra.ExactArgs(2),
+		// Complete the endpoint ID argument.
+		ValidArgsFunction: completion.EndpointIDs(0),
+		Short:             "register a http listener",
 		Long: `Listens for HTTP traffic on the given endpoint and forwards
 incoming connections to your upstream service.
 
@@ -66,7 +70,7 @@ Timeout forwarding incoming HTTP requests to the upstream.`,
 		}}
 
 		var err error
-		logger, err = log.NewLogger(conf.Log.Level, conf.Log.Subsystems)
+		logger, err = log.NewLoggerFromConfig(conf.Log)
 		if err != nil {
 			fmt.Printf("failed to setup logger: %s\n", err.Error())
 			os.Exit(1)
@@ -74,7 +78,7 @@ Timeout forwarding incoming HTTP requests to the upstream.`,
 	}
 
 	cmd.Run = func(_ *cobra.Command, _ []string) {
-		if err := runAgent(conf, logger); err != nil {
+		if err := runAgent(context.Background(), conf, logger); err != nil {
 			logger.Error("failed to run agent", zap.Error(err))
 			os.Exit(1)
 		}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	pikoconfig "github.com/andydunstall/piko/pkg/config"
+	"github.com/andydunstall/piko/pkg/winsvc"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// serviceName is the default name of the agent Windows service.
+	serviceName = "piko-agent"
+)
+
+func newServiceCommand(loadConf *pikoconfig.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "service [command] [flags]",
+		Short: "manage the agent windows service",
+		Long: `Install or uninstall the agent as a Windows service.
+
+The service runs 'piko agent start' with the configured YAML configuration
+file, starts when the host boots, and is restarted if it fails. When the
+service is stopped, or the host shuts down, the agent gracefully shuts down.
+
+Windows services are only supported on Windows.
+
+Examples:
+  # Install the agent service using the configuration in agent.yaml.
+  piko agent service install --config.path C:\piko\agent.yaml
+
+  # Uninstall the agent service.
+  piko agent service uninstall
+`,
+	}
+
+	cmd.AddCommand(newServiceInstallCommand(loadConf))
+	cmd.AddCommand(newServiceUninstallCommand())
+
+	return cmd
+}
+
+func newServiceInstallCommand(loadConf *pikoconfig.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "install [flags]",
+		Args:  cobra.NoArgs,
+		Short: "install the agent windows service",
+		Long: `Install the agent as a Windows service.
+
+The service loads its configuration from the YAML file configured with
+'--config.path', so the file must configure the listeners to register.
+
+Examples:
+  piko agent service install --config.path C:\piko\agent.yaml
+`,
+	}
+
+	var name string
+	cmd.Flags().StringVar(
+		&name,
+		"name",
+		serviceName,
+		`
+Name of the Windows service.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if loadConf.Path == "" {
+			fmt.Println("missing config path; configure with '--config.path'")
+			os.Exit(1)
+		}
+		// The service doesn't run in the current working directory so
+		// requires an absolute path.
+		path, err := filepath.Abs(loadConf.Path)
+		if err != nil {
+			fmt.Printf("invalid config path: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		args := []string{"agent", "start", "--config.path", path}
+		if loadConf.Dir != "" {
+			dir, err := filepath.Abs(loadConf.Dir)
+			if err != nil {
+				fmt.Printf("invalid config dir: %s\n", err.Error())
+				os.Exit(1)
+			}
+			args = append(args, "--config.dir", dir)
+		}
+		if loadConf.ExpandEnv {
+			args = append(args, "--config.expand-env")
+		}
+
+		if err := winsvc.Install(
+			name, "Piko agent forwarding traffic to upstream services.", args,
+		); err != nil {
+			fmt.Printf("failed to install service: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("installed service %s\n", name)
+	}
+
+	return cmd
+}
+
+func newServiceUninstallCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "uninstall [flags]",
+		Args:  cobra.NoArgs,
+		Short: "uninstall the agent windows service",
+		Long: `Uninstall the agent Windows service.
+
+Examples:
+  piko agent service uninstall
+`,
+	}
+
+	var name string
+	cmd.Flags().StringVar(
+		&name,
+		"name",
+		serviceName,
+		`
+Name of the Windows service.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if err := winsvc.Uninstall(name); err != nil {
+			fmt.Printf("failed to uninstall service: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("uninstalled service %s\n", name)
+	}
+
+	return cmd
+}
@@ -1,11 +1,13 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"os"
 
 	"github.com/andydunstall/piko/agent/config"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/winsvc"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -27,7 +29,7 @@ Examples:
 
 	cmd.PreRun = func(_ *cobra.Command, _ []string) {
 		var err error
-		logger, err = log.NewLogger(conf.Log.Level, conf.Log.Subsystems)
+		logger, err = log.NewLoggerFromConfig(conf.Log)
 		if err != nil {
 			fmt.Printf("failed to setup logger: %s\n", err.Error())
 			os.Exit(1)
@@ -40,7 +42,19 @@ Examples:
 	}
 
 	cmd.Run = func(_ *cobra.Command, _ []string) {
-		if err := runAgent(conf, logger); err != nil {
+		run := func(ctx context.Context) error {
+			return runAgent(ctx, conf, logger)
+		}
+
+		var err error
+		// When running as a Windows service, the agent is stopped by the
+		// service control manager rather than a signal.
+		if winsvc.IsService() {
+			err = winsvc.Run(serviceName, run)
+		} else {
+			err = run(context.Background())
+		}
+		if err != nil {
 			logger.Error("failed to run agent", zap.Error(err))
 			os.Exit(1)
 		}
@@ -0,0 +1,141 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/andydunstall/piko/bench"
+	"github.com/andydunstall/piko/bench/config"
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench [flags]",
+		Short: "benchmark a piko cluster",
+		Long: `Benchmark a Piko cluster.
+
+Registers the configured number of synthetic upstream endpoints with the
+cluster, then sends proxy requests to those endpoints for the configured
+duration. Once complete, outputs the throughput, latency percentiles and error
+rate.
+
+Each upstream echos the request body, so the response size matches the
+request size.
+
+This can be used for capacity planning, or to find performance regressions
+by comparing results across Piko versions.
+
+By default clients send requests as fast as possible to measure the maximum
+throughput. Use '--requests.rate' to instead send a fixed rate of requests,
+such as to measure latency under an expected load.
+
+Examples:
+  # Benchmark a local Piko server for 30 seconds.
+  piko bench
+
+  # Register 100 endpoints and send 1000 requests per second using 50
+  # concurrent clients for 5 minutes.
+  piko bench --endpoints 100 --requests.rate 1000 --requests.concurrency 50 \
+    --requests.duration 5m
+
+  # Specify the Piko server addresses.
+  piko bench --server.proxy-url https://piko.example.com:8000 \
+    --server.upstream-url https://piko.example.com:8001
+
+  # Output the results in JSON.
+  piko bench --output json
+
+  # Benchmark an in-process server and record profiles.
+  piko bench self --profile.dir ./profiles
+`,
+	}
+
+	conf := config.Default()
+
+	// Register flags and set default values.
+	conf.RegisterFlags(cmd.Flags())
+
+	var output string
+	cmd.Flags().StringVar(
+		&output,
+		"output",
+		"table",
+		`
+Output format, either 'table' or 'json'.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if output != "table" && output != "json" {
+			fmt.Printf("unsupported output: %s\n", output)
+			os.Exit(1)
+		}
+
+		if err := conf.Validate(); err != nil {
+			fmt.Printf("invalid config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		logger, err := log.NewLoggerFromConfig(conf.Log)
+		if err != nil {
+			fmt.Printf("failed to setup logger: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		// On a shutdown signal, stop the benchmark early and output the
+		// results so far.
+		ctx, cancel := signal.NotifyContext(
+			context.Background(), syscall.SIGINT, syscall.SIGTERM,
+		)
+		defer cancel()
+
+		report, err := bench.NewBench(conf, logger).Run(ctx)
+		if err != nil {
+			logger.Error("failed to run benchmark", zap.Error(err))
+			os.Exit(1)
+		}
+
+		showReport(report, output)
+	}
+
+	cmd.AddCommand(newSelfCommand())
+
+	return cmd
+}
+
+func showReport(report *bench.Report, output string) {
+	if output == "json" {
+		b, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+
+	fmt.Printf("requests: %d\n", report.Requests)
+	fmt.Printf("duration: %s\n", report.Duration.Round(time.Millisecond))
+	fmt.Printf("throughput: %.2f req/s\n", report.Throughput)
+	fmt.Printf("errors: %d (%.2f%%)\n", report.Errors, report.ErrorRate*100)
+
+	reasons := make([]string, 0, len(report.ErrorReasons))
+	for reason := range report.ErrorReasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("  %s: %d\n", reason, report.ErrorReasons[reason])
+	}
+
+	fmt.Println("latency:")
+	fmt.Printf("  mean: %s\n", report.Latency.Mean)
+	fmt.Printf("  p50: %s\n", report.Latency.P50)
+	fmt.Printf("  p90: %s\n", report.Latency.P90)
+	fmt.Printf("  p99: %s\n", report.Latency.P99)
+	fmt.Printf("  max: %s\n", report.Latency.Max)
+}
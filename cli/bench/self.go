@@ -0,0 +1,153 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/andydunstall/piko/bench"
+	"github.com/andydunstall/piko/bench/config"
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/workloadv2/cluster"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func newSelfCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "self [flags]",
+		Short: "benchmark an in-process piko server",
+		Long: `Benchmark an in-process Piko server.
+
+Starts a Piko server node in the same process, then runs the benchmark
+against that node, the same as 'piko bench'. Since the server, upstreams and
+clients run on the same host, results are only comparable across runs on the
+same host, such as to find performance regressions between Piko versions.
+
+Use '--profile.dir' to record CPU, heap, allocation, mutex and goroutine
+profiles of the process during the benchmark. The profiles are written in
+pprof format along with the benchmark report ('report.json'), so can be
+inspected and compared using 'go tool pprof'.
+
+Examples:
+  # Benchmark an in-process server for 30 seconds.
+  piko bench self
+
+  # Benchmark with 64KB requests and write profiles to ./profiles.
+  piko bench self --requests.size 65536 --profile.dir ./profiles
+
+  # Compare the CPU profile against a previous release.
+  go tool pprof -diff_base v1/cpu.pprof v2/cpu.pprof
+`,
+	}
+
+	conf := config.Default()
+
+	// Register flags and set default values.
+	conf.RegisterLoadFlags(cmd.Flags())
+	conf.Log.RegisterFlags(cmd.Flags())
+
+	var profileConf config.ProfileConfig
+	profileConf.RegisterFlags(cmd.Flags())
+
+	var output string
+	cmd.Flags().StringVar(
+		&output,
+		"output",
+		"table",
+		`
+Output format, either 'table' or 'json'.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if output != "table" && output != "json" {
+			fmt.Printf("unsupported output: %s\n", output)
+			os.Exit(1)
+		}
+
+		if err := conf.Validate(); err != nil {
+			fmt.Printf("invalid config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		logger, err := log.NewLoggerFromConfig(conf.Log)
+		if err != nil {
+			fmt.Printf("failed to setup logger: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		if err := runSelf(conf, &profileConf, output, logger); err != nil {
+			logger.Error("failed to run benchmark", zap.Error(err))
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
+
+func runSelf(
+	conf *config.Config,
+	profileConf *config.ProfileConfig,
+	output string,
+	logger log.Logger,
+) error {
+	node := cluster.NewNode(cluster.WithLogger(logger.WithSubsystem("server")))
+	node.Start()
+	defer node.Stop()
+
+	conf.Server.ProxyURL = "http://" + node.ProxyAddr()
+	conf.Server.UpstreamURL = "http://" + node.UpstreamAddr()
+
+	// On a shutdown signal, stop the benchmark early and output the
+	// results so far.
+	ctx, cancel := signal.NotifyContext(
+		context.Background(), syscall.SIGINT, syscall.SIGTERM,
+	)
+	defer cancel()
+
+	var profiler *bench.Profiler
+	if profileConf.Enabled() {
+		profiler = bench.NewProfiler(profileConf.Dir)
+		if err := profiler.Start(); err != nil {
+			return fmt.Errorf("profile: %w", err)
+		}
+	}
+
+	report, err := bench.NewBench(conf, logger).Run(ctx)
+
+	var profiles []string
+	if profiler != nil {
+		// Always stop the profiler, even if the benchmark failed.
+		var profileErr error
+		profiles, profileErr = profiler.Stop()
+		if err == nil && profileErr != nil {
+			err = fmt.Errorf("profile: %w", profileErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if profileConf.Enabled() {
+		path := filepath.Join(profileConf.Dir, "report.json")
+		b, _ := json.MarshalIndent(report, "", "  ")
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			return fmt.Errorf("write report: %w", err)
+		}
+		profiles = append(profiles, path)
+	}
+
+	showReport(report, output)
+
+	if output == "table" && len(profiles) > 0 {
+		fmt.Println("profiles:")
+		for _, path := range profiles {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+	return nil
+}
@@ -2,8 +2,13 @@ package cli
 
 import (
 	"github.com/andydunstall/piko/cli/agent"
+	"github.com/andydunstall/piko/cli/bench"
+	"github.com/andydunstall/piko/cli/completion"
+	"github.com/andydunstall/piko/cli/endpoints"
 	"github.com/andydunstall/piko/cli/forward"
 	"github.com/andydunstall/piko/cli/server"
+	"github.com/andydunstall/piko/cli/token"
+	"github.com/andydunstall/piko/cli/version"
 	"github.com/andydunstall/piko/cli/workload"
 	workloadv2 "github.com/andydunstall/piko/cli/workloadv2"
 	"github.com/spf13/cobra"
@@ -55,14 +60,32 @@ to an upstream listener via Piko. Such as to forward port 3000 to endpoint
 
   $ piko forward tcp 3000 my-endpoint
 
+To list the endpoints registered with the cluster, use:
+
+  $ piko endpoints ls
+
+To create or inspect endpoint tokens for authenticating upstreams, use
+'piko token'.
+
+To show the Piko version, use:
+
+  $ piko version
+
+To enable shell completion, see 'piko completion --help'.
+
 `,
 	}
 
 	cmd.AddCommand(server.NewCommand())
 	cmd.AddCommand(agent.NewCommand())
 	cmd.AddCommand(forward.NewCommand())
+	cmd.AddCommand(endpoints.NewCommand())
 	cmd.AddCommand(workload.NewCommand())
 	cmd.AddCommand(workloadv2.NewCommand())
+	cmd.AddCommand(token.NewCommand())
+	cmd.AddCommand(bench.NewCommand())
+	cmd.AddCommand(version.NewCommand())
+	cmd.AddCommand(completion.NewCommand())
 
 	return cmd
 }
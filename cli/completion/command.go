@@ -0,0 +1,65 @@
+package completion
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:       "completion [bash|zsh|fish|powershell]",
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		Short:     "generate shell completion scripts",
+		Long: `Generate a completion script for the given shell.
+
+Completion includes the available commands and flags, and completes endpoint
+IDs (such as 'piko forward tcp 3000 <TAB>') by querying the endpoints with
+upstreams connected to the cluster. Endpoint IDs are queried from the Piko
+server admin port at 'PIKO_SERVER_URL' (defaults to 'http://localhost:8002').
+If the server is unavailable endpoint IDs aren't completed.
+
+Examples:
+  # Load completions in the current bash shell.
+  source <(piko completion bash)
+
+  # Load completions for every new bash shell on Linux.
+  piko completion bash > /etc/bash_completion.d/piko
+
+  # Load completions for every new zsh shell.
+  piko completion zsh > "${fpath[1]}/_piko"
+
+  # Load completions for every new fish shell.
+  piko completion fish > ~/.config/fish/completions/piko.fish
+
+  # Load completions in the current PowerShell session.
+  piko completion powershell | Out-String | Invoke-Expression
+`,
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		if err := genCompletion(cmd.Root(), args[0]); err != nil {
+			fmt.Printf("failed to generate completion: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
+
+func genCompletion(root *cobra.Command, shell string) error {
+	switch shell {
+	case "bash":
+		return root.GenBashCompletionV2(os.Stdout, true)
+	case "zsh":
+		return root.GenZshCompletion(os.Stdout)
+	case "fish":
+		return root.GenFishCompletion(os.Stdout, true)
+	case "powershell":
+		return root.GenPowerShellCompletionWithDesc(os.Stdout)
+	default:
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+}
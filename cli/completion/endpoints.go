@@ -0,0 +1,77 @@
+package completion
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andydunstall/piko/server/status/client"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// serverURLEnv is the environment variable containing the Piko server
+	// admin URL to query endpoint IDs from.
+	serverURLEnv = "PIKO_SERVER_URL"
+
+	defaultServerURL = "http://localhost:8002"
+
+	// queryTimeout is the maximum time to wait for the server when completing
+	// endpoint IDs, to avoid blocking the shell if the server is unreachable.
+	queryTimeout = time.Second * 2
+)
+
+// EndpointIDs returns a cobra argument completion function that completes the
+// argument at the given position with the endpoint IDs with upstreams
+// connected to the cluster.
+//
+// If the server can't be queried, no endpoint IDs are completed.
+func EndpointIDs(
+	position int,
+) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(
+		_ *cobra.Command, args []string, toComplete string,
+	) ([]string, cobra.ShellCompDirective) {
+		if len(args) != position {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		endpointIDs, err := queryEndpointIDs()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		var completions []string
+		for _, endpointID := range endpointIDs {
+			if strings.HasPrefix(endpointID, toComplete) {
+				completions = append(completions, endpointID)
+			}
+		}
+		return completions, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func queryEndpointIDs() ([]string, error) {
+	serverURL := os.Getenv(serverURLEnv)
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := client.NewClient(u)
+	c.SetTimeout(queryTimeout)
+
+	endpoints, err := client.NewUpstream(c).ClusterEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	var endpointIDs []string
+	for _, endpoint := range endpoints {
+		endpointIDs = append(endpointIDs, endpoint.EndpointID)
+	}
+	return endpointIDs, nil
+}
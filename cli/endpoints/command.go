@@ -0,0 +1,473 @@
+package endpoints
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andydunstall/piko/cli/output"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/status/client"
+	"github.com/andydunstall/piko/server/status/config"
+	"github.com/andydunstall/piko/server/upstream"
+	"github.com/spf13/cobra"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "endpoints",
+		Short: "inspect endpoints registered with the cluster",
+		Long: `Inspect endpoints registered with the cluster.
+
+Queries a Piko server admin port for the endpoints with upstreams connected to
+any node in the cluster.
+
+Examples:
+  # List the endpoints registered with the cluster.
+  piko endpoints ls
+
+  # List the endpoints in JSON format.
+  piko endpoints ls --output json
+
+  # List the endpoints using a server at a custom URL.
+  piko endpoints ls --server.url https://piko-admin.example.com
+
+  # List the endpoints waiting for approval.
+  piko endpoints pending
+
+  # Approve an endpoint on all nodes in the cluster.
+  piko endpoints approve my-endpoint
+
+  # Make an endpoint public on all nodes in the cluster.
+  piko endpoints visibility my-endpoint public
+`,
+	}
+
+	var conf config.Config
+	conf.RegisterFlags(cmd.PersistentFlags())
+
+	var opts output.Options
+	opts.RegisterFlags(cmd.PersistentFlags())
+
+	c := client.NewClient(nil)
+
+	cmd.PersistentPreRun = func(_ *cobra.Command, _ []string) {
+		if err := conf.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if err := opts.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		tlsConfig, err := conf.Server.TLS.Load()
+		if err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		url, _ := url.Parse(conf.Server.URL)
+		c.SetURL(url)
+		c.SetTLSConfig(tlsConfig)
+		c.SetForward(conf.Forward)
+		c.SetToken(conf.Server.Token)
+	}
+
+	cmd.AddCommand(newListCommand(c, &opts))
+	cmd.AddCommand(newPendingCommand(c, &opts))
+	cmd.AddCommand(newApproveCommand(c, &opts))
+	cmd.AddCommand(newRevokeCommand(c, &opts))
+	cmd.AddCommand(newVisibilityCommand(c, &opts))
+
+	return cmd
+}
+
+func newListCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Args:    cobra.NoArgs,
+		Short:   "list endpoints registered with the cluster",
+		Long: `List endpoints registered with the cluster.
+
+Lists each endpoint with upstreams connected to any node in the cluster,
+including the total number of upstream connections for the endpoint and the
+nodes the upstreams are connected to (with the number of connections to each
+node).
+
+Note the endpoints are based on the cluster state known by the queried node,
+which is eventually consistent.
+
+Endpoints belonging to a tenant are listed with the endpoint ID scoped to the
+tenant, such as 'team-a/my-endpoint'. Use '--tenant' to only list the
+endpoints of a tenant.
+
+Use '--output json' to output JSON rather than a table.
+
+Examples:
+  piko endpoints ls
+
+  # List the endpoints of tenant 'team-a'.
+  piko endpoints ls --tenant team-a
+
+  piko endpoints ls --output json
+`,
+	}
+
+	var tenant string
+	cmd.Flags().StringVar(
+		&tenant,
+		"tenant",
+		"",
+		`
+Only list endpoints belonging to the given tenant.`,
+	)
+
+	cmd.Run = func(cmd *cobra.Command, _ []string) {
+		var filter *string
+		if cmd.Flags().Changed("tenant") {
+			filter = &tenant
+		}
+		listEndpoints(c, filter, opts)
+	}
+
+	return cmd
+}
+
+type listOutput struct {
+	Endpoints []upstream.EndpointInfo `json:"endpoints"`
+}
+
+func listEndpoints(c *client.Client, tenant *string, opts *output.Options) {
+	endpoints, err := client.NewUpstream(c).ClusterEndpoints()
+	if err != nil {
+		fmt.Printf("failed to get endpoints: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if tenant != nil {
+		var filtered []upstream.EndpointInfo
+		for _, endpoint := range endpoints {
+			if endpoint.Tenant == *tenant {
+				filtered = append(filtered, endpoint)
+			}
+		}
+		endpoints = filtered
+	}
+
+	// Sort by endpoint ID, and each endpoints nodes by node ID.
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].EndpointID < endpoints[j].EndpointID
+	})
+	for _, endpoint := range endpoints {
+		sort.Slice(endpoint.Nodes, func(i, j int) bool {
+			return endpoint.Nodes[i].NodeID < endpoint.Nodes[j].NodeID
+		})
+	}
+
+	out := listOutput{
+		Endpoints: endpoints,
+	}
+	if out.Endpoints == nil {
+		out.Endpoints = []upstream.EndpointInfo{}
+	}
+	opts.Print(out, func() {
+		printEndpointsTable(endpoints)
+	})
+}
+
+func printEndpointsTable(endpoints []upstream.EndpointInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENDPOINT\tCONNECTIONS\tNODES")
+	for _, endpoint := range endpoints {
+		var nodes []string
+		for _, node := range endpoint.Nodes {
+			nodes = append(
+				nodes,
+				node.NodeID+" ("+strconv.Itoa(node.Connections)+")",
+			)
+		}
+		fmt.Fprintf(
+			w,
+			"%s\t%d\t%s\n",
+			endpoint.EndpointID,
+			endpoint.Connections,
+			strings.Join(nodes, ", "),
+		)
+	}
+	w.Flush()
+}
+
+func newPendingCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pending",
+		Args:  cobra.NoArgs,
+		Short: "list endpoints waiting for approval",
+		Long: `List endpoints waiting for approval.
+
+When the server requires endpoints to be approved
+('--upstream.require-approval'), upstreams for endpoints that aren't approved
+are held as pending until the endpoint is approved.
+
+Lists the pending endpoints on each active node in the cluster, including the
+number of pending upstreams and their client IPs.
+
+Examples:
+  piko endpoints pending
+
+  piko endpoints pending --output json
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		listPending(c, opts)
+	}
+
+	return cmd
+}
+
+func newApproveCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "approve [endpoint]",
+		Args:  cobra.ExactArgs(1),
+		Short: "approve an endpoint to receive traffic",
+		Long: `Approve an endpoint to receive traffic.
+
+When the server requires endpoints to be approved
+('--upstream.require-approval'), approves the endpoint on the node, which
+propagates the approval to the rest of the cluster, so the endpoints pending
+upstreams start receiving traffic.
+
+Note approvals aren't persisted, so are lost if every node in the cluster
+restarts. To always approve an endpoint, add it to
+'--upstream.approved-endpoints'.
+
+Examples:
+  piko endpoints approve my-endpoint
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, args []string) {
+		updateApproval(c, opts, args[0], true)
+	}
+
+	return cmd
+}
+
+func newRevokeCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "revoke [endpoint]",
+		Args:  cobra.ExactArgs(1),
+		Short: "revoke an endpoints approval",
+		Long: `Revoke an endpoints approval.
+
+Revokes the approval on the node, which propagates the revocation to the rest
+of the cluster. Each node closes the endpoints connected upstreams, which will
+wait for approval again when they reconnect.
+
+Endpoints approved using '--upstream.approved-endpoints' can't be revoked.
+
+Examples:
+  piko endpoints revoke my-endpoint
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, args []string) {
+		updateApproval(c, opts, args[0], false)
+	}
+
+	return cmd
+}
+
+func newVisibilityCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:       "visibility [endpoint] [public|private|reset]",
+		Args:      cobra.ExactArgs(2),
+		ValidArgs: []string{"public", "private", "reset"},
+		Short:     "set an endpoints visibility",
+		Long: `Set an endpoints visibility.
+
+When proxy client authentication is enabled, public endpoints can be reached
+without authenticating whereas private endpoints require a valid client token. Endpoints are private unless configured otherwise in
+'proxy.endpoints'.
+
+Sets the visibility on the node, which propagates it to the rest of the
+cluster, and overrides the configured visibility. Use 'reset' to revert to
+the configured visibility.
+
+Endpoints belonging to a tenant must be given with the endpoint ID scoped to
+the tenant, such as 'team-a/my-endpoint'.
+
+Note the visibility isn't persisted, so is lost if every node in the cluster
+restarts.
+
+Examples:
+  piko endpoints visibility my-endpoint public
+
+  piko endpoints visibility my-endpoint reset
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, args []string) {
+		visibility := args[1]
+		if visibility != "reset" && !upstream.ValidVisibility(visibility) {
+			fmt.Printf("invalid visibility: %s\n", visibility)
+			os.Exit(1)
+		}
+		updateVisibility(c, opts, args[0], visibility)
+	}
+
+	return cmd
+}
+
+type pendingEndpoint struct {
+	NodeID string `json:"node_id"`
+
+	upstream.PendingEndpoint
+}
+
+type pendingOutput struct {
+	Pending []pendingEndpoint `json:"pending"`
+}
+
+func listPending(c *client.Client, opts *output.Options) {
+	nodeIDs, err := activeNodes(c)
+	if err != nil {
+		fmt.Printf("failed to get nodes: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := pendingOutput{
+		Pending: []pendingEndpoint{},
+	}
+	for _, nodeID := range nodeIDs {
+		c.SetForward(nodeID)
+		approvals, err := client.NewUpstream(c).Approvals()
+		if err != nil {
+			fmt.Printf("failed to get approvals: %s: %s\n", nodeID, err.Error())
+			os.Exit(1)
+		}
+		for _, pending := range approvals.Pending {
+			out.Pending = append(out.Pending, pendingEndpoint{
+				NodeID:          nodeID,
+				PendingEndpoint: pending,
+			})
+		}
+	}
+
+	opts.Print(out, func() {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ENDPOINT\tNODE\tUPSTREAMS\tCLIENT IPS\tPENDING")
+		for _, pending := range out.Pending {
+			fmt.Fprintf(
+				w,
+				"%s\t%s\t%d\t%s\t%s\n",
+				pending.EndpointID,
+				pending.NodeID,
+				pending.Upstreams,
+				strings.Join(pending.ClientIPs, ", "),
+				time.Since(pending.Since).Round(time.Second),
+			)
+		}
+		w.Flush()
+	})
+}
+
+type approvalOutput struct {
+	EndpointID string `json:"endpoint_id"`
+	Approved   bool   `json:"approved"`
+}
+
+func updateApproval(
+	c *client.Client,
+	opts *output.Options,
+	endpointID string,
+	approve bool,
+) {
+	// The node propagates the approval to the rest of the cluster, so only
+	// update a single node.
+	var err error
+	upstreamClient := client.NewUpstream(c)
+	if approve {
+		_, err = upstreamClient.Approve(endpointID)
+	} else {
+		_, err = upstreamClient.Revoke(endpointID)
+	}
+	if err != nil {
+		fmt.Printf("failed to update approval: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := approvalOutput{
+		EndpointID: endpointID,
+		Approved:   approve,
+	}
+	opts.Print(out, func() {
+		action := "approved"
+		if !approve {
+			action = "revoked"
+		}
+		fmt.Printf("%s endpoint %s\n", action, endpointID)
+	})
+}
+
+type visibilityOutput struct {
+	EndpointID string `json:"endpoint_id"`
+	Visibility string `json:"visibility"`
+}
+
+func updateVisibility(
+	c *client.Client,
+	opts *output.Options,
+	endpointID string,
+	visibility string,
+) {
+	// The node propagates the visibility to the rest of the cluster, so
+	// only update a single node.
+	var err error
+	upstreamClient := client.NewUpstream(c)
+	if visibility == "reset" {
+		err = upstreamClient.UnsetVisibility(endpointID)
+	} else {
+		err = upstreamClient.SetVisibility(endpointID, visibility)
+	}
+	if err != nil {
+		fmt.Printf("failed to update visibility: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := visibilityOutput{
+		EndpointID: endpointID,
+		Visibility: visibility,
+	}
+	opts.Print(out, func() {
+		fmt.Printf(
+			"updated endpoint %s visibility to %s\n", endpointID, visibility,
+		)
+	})
+}
+
+// activeNodes returns the IDs of the active nodes in the cluster, sorted by
+// ID.
+func activeNodes(c *client.Client) ([]string, error) {
+	nodes, err := client.NewCluster(c).Nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodeIDs []string
+	for _, node := range nodes {
+		if node.Status != cluster.NodeStatusActive {
+			continue
+		}
+		nodeIDs = append(nodeIDs, node.ID)
+	}
+	sort.Strings(nodeIDs)
+	return nodeIDs, nil
+}
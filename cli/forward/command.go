@@ -83,6 +83,7 @@ func runForward(conf *config.Config, logger log.Logger) error {
 
 	client := client.New(
 		client.WithProxyURL(conf.Connect.URL),
+		client.WithToken(conf.Connect.Token),
 		client.WithTLSConfig(connectTLSConfig),
 		client.WithLogger(logger.WithSubsystem("client")),
 	)
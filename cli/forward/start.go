@@ -27,7 +27,7 @@ Examples:
 
 	cmd.PreRun = func(_ *cobra.Command, _ []string) {
 		var err error
-		logger, err = log.NewLogger(conf.Log.Level, conf.Log.Subsystems)
+		logger, err = log.NewLoggerFromConfig(conf.Log)
 		if err != nil {
 			fmt.Printf("failed to setup logger: %s\n", err.Error())
 			os.Exit(1)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/andydunstall/piko/cli/completion"
 	"github.com/andydunstall/piko/forward/config"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/spf13/cobra"
@@ -12,9 +13,11 @@ import (
 
 func newTCPCommand(conf *config.Config) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "tcp [addr] [endpoint] [flags]",
-		Args:  cobra.ExactArgs(2),
-		Short: "open a tcp port",
+		Use:  "tcp [addr] [endpoint] [flags]",
+		Args: cobra.ExactArgs(2),
+		// Complete the endpoint ID argument.
+		ValidArgsFunction: completion.EndpointIDs(1),
+		Short:             "open a tcp port",
 		Long: `Opens a TCP port and forwards to the configured endpoint.
 
 The configured address may be a port or host and port.
@@ -39,7 +42,7 @@ Examples:
 		}}
 
 		var err error
-		logger, err = log.NewLogger(conf.Log.Level, conf.Log.Subsystems)
+		logger, err = log.NewLoggerFromConfig(conf.Log)
 		if err != nil {
 			fmt.Printf("failed to setup logger: %s\n", err.Error())
 			os.Exit(1)
@@ -0,0 +1,88 @@
+// Package output writes command output in either a human readable or
+// machine readable format.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/spf13/pflag"
+)
+
+// Options configures how a command writes its output.
+type Options struct {
+	// Format is the output format, either 'text' or 'json'. 'table' is
+	// accepted as an alias of 'text'.
+	Format string
+
+	// Quiet disables writing output, so the command only reports whether it
+	// succeeded with its exit status.
+	Quiet bool
+}
+
+func (o *Options) Validate() error {
+	switch o.Format {
+	case "text", "table", "json":
+		return nil
+	default:
+		return fmt.Errorf("unsupported output: %s", o.Format)
+	}
+}
+
+// RegisterFlags registers the output flags. These are typically registered as
+// persistent flags so they apply to all subcommands.
+func (o *Options) RegisterFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&o.Format,
+		"output",
+		"text",
+		`
+Output format, either 'text' or 'json'.
+
+'text' outputs a human readable format, which may change between versions,
+whereas 'json' outputs JSON to be parsed by scripts.`,
+	)
+	fs.BoolVar(
+		&o.Quiet,
+		"quiet",
+		false,
+		`
+Whether to disable writing output.
+
+The exit status indicates whether the command succeeded. Errors are still
+written.`,
+	)
+}
+
+// JSON returns whether the output format is JSON.
+func (o *Options) JSON() bool {
+	return o.Format == "json"
+}
+
+// Print writes v as indented JSON if the output format is JSON, otherwise
+// calls text to write the human readable output.
+//
+// If quiet, nothing is written.
+func (o *Options) Print(v any, text func()) {
+	if o.Quiet {
+		return
+	}
+	if o.JSON() {
+		b, _ := json.MarshalIndent(v, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	text()
+}
+
+// PrintYAML writes v as indented JSON if the output format is JSON, otherwise
+// writes v as YAML.
+//
+// If quiet, nothing is written.
+func (o *Options) PrintYAML(v any) {
+	o.Print(v, func() {
+		b, _ := yaml.Marshal(v)
+		fmt.Println(string(b))
+	})
+}
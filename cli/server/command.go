@@ -6,14 +6,20 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/andydunstall/piko/cli/server/status"
+	"github.com/andydunstall/piko/pkg/build"
 	pikoconfig "github.com/andydunstall/piko/pkg/config"
+	"github.com/andydunstall/piko/pkg/errorreport"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/telemetry"
+	"github.com/andydunstall/piko/pkg/winsvc"
 	"github.com/andydunstall/piko/server"
 	"github.com/andydunstall/piko/server/cluster"
 	"github.com/andydunstall/piko/server/config"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 )
 
@@ -66,14 +72,56 @@ Examples:
 	conf.RegisterFlags(cmd.Flags())
 	loadConf.RegisterFlags(cmd.Flags())
 
+	var validateConfig bool
+	cmd.Flags().BoolVar(
+		&validateConfig,
+		"validate-config",
+		false,
+		`
+Validate the configuration then exit, without starting the server or binding
+any ports.
+
+This loads and validates the YAML configuration, environment variables and
+flags, resolves the advertise addresses, loads the TLS certificates and parses
+the authentication keys. Exits with a non-zero status if the configuration is
+invalid, such as for CI and pre-deploy checks.`,
+	)
+
 	var logger log.Logger
+	var reporter *errorreport.Reporter
+
+	// staticNodeID indicates the node ID is configured rather than
+	// generated, so the node can't be upgraded to a new process.
+	var staticNodeID bool
 
 	cmd.PreRun = func(_ *cobra.Command, _ []string) {
 		if err := loadConf.Load(conf); err != nil {
 			fmt.Println(err.Error())
 			os.Exit(1)
 		}
+		if err := conf.LoadSecrets(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
 
+		staticNodeID = conf.Cluster.NodeID != "" || conf.Cluster.NodeIDFile != ""
+
+		// When validating the config, don't generate the node ID file.
+		if conf.Cluster.NodeID == "" && conf.Cluster.NodeIDFile != "" && !validateConfig {
+			key, err := conf.Encryption.LoadKey()
+			if err != nil {
+				fmt.Printf("config: encryption: %s\n", err.Error())
+				os.Exit(1)
+			}
+			nodeID, err := cluster.LoadOrGenerateNodeID(
+				conf.Cluster.NodeIDFile, conf.Cluster.NodeIDPrefix, key,
+			)
+			if err != nil {
+				fmt.Printf("config: %s\n", err.Error())
+				os.Exit(1)
+			}
+			conf.Cluster.NodeID = nodeID
+		}
 		if conf.Cluster.NodeID == "" {
 			nodeID := cluster.GenerateNodeID()
 			if conf.Cluster.NodeIDPrefix != "" {
@@ -82,13 +130,35 @@ Examples:
 			conf.Cluster.NodeID = nodeID
 		}
 
+		if validateConfig {
+			if err := server.ValidateConfig(conf); err != nil {
+				fmt.Printf("config: %s\n", err.Error())
+				os.Exit(1)
+			}
+			printValidConfig(conf)
+			os.Exit(0)
+		}
+
 		if err := conf.Validate(); err != nil {
 			fmt.Printf("config: %s\n", err.Error())
 			os.Exit(1)
 		}
 
+		var reporters []log.Reporter
+		if conf.ErrorReporting.Enabled() {
+			var err error
+			reporter, err = errorreport.NewReporter(
+				conf.ErrorReporting, build.Version,
+			)
+			if err != nil {
+				fmt.Printf("failed to setup error reporting: %s\n", err.Error())
+				os.Exit(1)
+			}
+			reporters = append(reporters, reporter)
+		}
+
 		var err error
-		logger, err = log.NewLogger(conf.Log.Level, conf.Log.Subsystems)
+		logger, err = log.NewLoggerFromConfig(conf.Log, reporters...)
 		if err != nil {
 			fmt.Printf("failed to setup logger: %s\n", err.Error())
 			os.Exit(1)
@@ -96,27 +166,169 @@ Examples:
 	}
 
 	cmd.Run = func(_ *cobra.Command, _ []string) {
-		if err := runServer(conf, logger); err != nil {
+		if reporter != nil {
+			// Report panics that would crash the server.
+			defer reporter.RecoverPanic(time.Second * 2)
+		}
+
+		// Loads the latest configuration to reload, using the same command
+		// line flags.
+		loadConfig := func() (*config.Config, error) {
+			reloadConf := config.Default()
+			var reloadLoadConf pikoconfig.Config
+
+			fs := pflag.NewFlagSet("reload", pflag.ContinueOnError)
+			reloadConf.RegisterFlags(fs)
+			reloadLoadConf.RegisterFlags(fs)
+			if err := pikoconfig.CopyFlags(fs, cmd.Flags()); err != nil {
+				return nil, err
+			}
+
+			if err := reloadLoadConf.Load(reloadConf); err != nil {
+				return nil, err
+			}
+			// Re-read any secrets from files.
+			if err := reloadConf.LoadSecrets(); err != nil {
+				return nil, fmt.Errorf("config: %w", err)
+			}
+			// The node ID may have been generated so keep the existing ID.
+			reloadConf.Cluster.NodeID = conf.Cluster.NodeID
+			if err := reloadConf.Validate(); err != nil {
+				return nil, fmt.Errorf("config: %w", err)
+			}
+			return reloadConf, nil
+		}
+
+		run := func(ctx context.Context) error {
+			return runServer(ctx, conf, loadConfig, !staticNodeID, logger)
+		}
+
+		var err error
+		// When running as a Windows service, the server is stopped by the
+		// service control manager rather than a signal.
+		if winsvc.IsService() {
+			err = winsvc.Run(serviceName, run)
+		} else {
+			err = run(context.Background())
+		}
+		if err != nil {
 			logger.Error("failed to run server", zap.Error(err))
+			// Flush any reported errors before exiting.
+			_ = logger.Sync()
 			os.Exit(1)
 		}
+		_ = logger.Sync()
 	}
 
 	cmd.AddCommand(status.NewCommand())
+	cmd.AddCommand(newDrainCommand())
+	cmd.AddCommand(newRollingDrainCommand())
+	cmd.AddCommand(newLogCommand())
+	cmd.AddCommand(newServiceCommand())
 
 	return cmd
 }
 
-func runServer(conf *config.Config, logger log.Logger) error {
+func printValidConfig(conf *config.Config) {
+	fmt.Println("config valid")
+	fmt.Printf("proxy advertise addr: %s\n", conf.Proxy.AdvertiseAddr)
+	if !conf.Cluster.ProxyOnly() {
+		fmt.Printf("upstream advertise addr: %s\n", conf.Upstream.AdvertiseAddr)
+	}
+	fmt.Printf("admin advertise addr: %s\n", conf.Admin.AdvertiseAddr)
+	if conf.RPC.Enabled() {
+		fmt.Printf("rpc advertise addr: %s\n", conf.RPC.AdvertiseAddr)
+	}
+	fmt.Printf("gossip advertise addr: %s\n", conf.Gossip.AdvertiseAddr)
+}
+
+// upgradeTimeout is the maximum duration to wait for the new process to be
+// ready when upgrading.
+const upgradeTimeout = time.Minute
+
+// runServer runs the server until it receives a shutdown signal or the given
+// context is cancelled.
+//
+// If upgradable is true, the server can be upgraded to a new process by
+// sending SIGUSR2.
+func runServer(
+	ctx context.Context,
+	conf *config.Config,
+	loadConfig func() (*config.Config, error),
+	upgradable bool,
+	logger log.Logger,
+) error {
 	ctx, cancel := signal.NotifyContext(
-		context.Background(), syscall.SIGINT, syscall.SIGTERM,
+		ctx, syscall.SIGINT, syscall.SIGTERM,
 	)
 	defer cancel()
 
+	tracer, err := telemetry.NewTracer(conf.Telemetry, "piko-server")
+	if err != nil {
+		return fmt.Errorf("telemetry: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		if err := tracer.Shutdown(ctx); err != nil {
+			logger.Warn("failed to shutdown tracer", zap.Error(err))
+		}
+	}()
+
 	server, err := server.NewServer(conf, logger)
 	if err != nil {
 		return err
 	}
+	server.SetConfigLoader(loadConfig)
+
+	// Reload the configuration on SIGHUP.
+	reloadCh := make(chan os.Signal, 1)
+	signal.Notify(reloadCh, syscall.SIGHUP)
+	defer signal.Stop(reloadCh)
+	go func() {
+		for {
+			select {
+			case <-reloadCh:
+				if err := server.ReloadConfig(); err != nil {
+					logger.Warn("failed to reload config", zap.Error(err))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Upgrade to a new process on SIGUSR2.
+	upgradeCh := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 {
+		signal.Notify(upgradeCh, upgradeSignals...)
+		defer signal.Stop(upgradeCh)
+	}
+	go func() {
+		for {
+			select {
+			case <-upgradeCh:
+				if !upgradable {
+					logger.Warn(
+						"failed to upgrade: node id is static; upgrading requires a generated node id",
+					)
+					continue
+				}
+
+				logger.Info("upgrading server")
+
+				upgradeCtx, cancel := context.WithTimeout(ctx, upgradeTimeout)
+				err := server.Upgrade(upgradeCtx)
+				cancel()
+				if err != nil {
+					logger.Warn("failed to upgrade", zap.Error(err))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
 	if err := server.Run(ctx); err != nil {
 		return err
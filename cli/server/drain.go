@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/andydunstall/piko/server"
+	"github.com/andydunstall/piko/server/status/client"
+	"github.com/andydunstall/piko/server/status/config"
+	yaml "github.com/goccy/go-yaml"
+	"github.com/spf13/cobra"
+)
+
+func newDrainCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "drain [flags]",
+		Short: "drain a server node",
+		Long: `Drain a server node ahead of shutting it down.
+
+Marks the node as draining so other nodes in the cluster stop forwarding
+new requests to it, then closes the upstream connections to the node so they
+reconnect to another node in the cluster.
+
+The command waits until all upstreams have disconnected from the node, after
+which the node is safe to shut down.
+
+Examples:
+  # Drain the node at localhost:8002.
+  piko server drain
+
+  # Drain node bbc69214.
+  piko server drain --forward bbc69214
+`,
+	}
+
+	var conf config.Config
+	conf.RegisterFlags(cmd.Flags())
+
+	var timeout time.Duration
+	cmd.Flags().DurationVar(
+		&timeout,
+		"timeout",
+		time.Minute,
+		`
+Maximum duration to wait for the node to drain.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if err := conf.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		tlsConfig, err := conf.Server.TLS.Load()
+		if err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		url, _ := url.Parse(conf.Server.URL)
+		c := client.NewClient(url)
+		c.SetTLSConfig(tlsConfig)
+		c.SetForward(conf.Forward)
+		c.SetToken(conf.Server.Token)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		drainNode(ctx, c)
+	}
+
+	return cmd
+}
+
+func drainNode(ctx context.Context, c *client.Client) {
+	status, err := waitDrained(ctx, client.NewDrain(c))
+	if err != nil {
+		fmt.Printf("failed to drain node: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	b, _ := yaml.Marshal(status)
+	fmt.Print(string(b))
+}
+
+// waitDrained starts draining the node and waits for the node to finish
+// draining.
+func waitDrained(
+	ctx context.Context,
+	drain *client.Drain,
+) (*server.DrainStatus, error) {
+	status, err := drain.Drain()
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for !status.Drained {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		status, err = drain.Status()
+		if err != nil {
+			return nil, fmt.Errorf("status: %w", err)
+		}
+	}
+
+	return status, nil
+}
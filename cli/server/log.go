@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/andydunstall/piko/server/admin"
+	"github.com/andydunstall/piko/server/status/client"
+	"github.com/andydunstall/piko/server/status/config"
+	yaml "github.com/goccy/go-yaml"
+	"github.com/spf13/cobra"
+)
+
+func newLogCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "log [flags]",
+		Short: "inspect or update the log configuration of a server node",
+		Long: `Inspect or update the log configuration of a server node.
+
+Without flags, outputs the current log level and enabled subsystems of the
+node.
+
+Use '--level' and '--subsystems' to update the log configuration at runtime,
+such as to enable debug logs without restarting the node. Note the updated
+configuration isn't persisted, so is reset when the node restarts.
+
+Examples:
+  # Inspect the log configuration of the node at localhost:8002.
+  piko server log
+
+  # Enable debug logs on node bbc69214.
+  piko server log --level debug --forward bbc69214
+
+  # Enable all logs for the 'proxy' and 'upstream' subsystems.
+  piko server log --subsystems proxy,upstream
+
+  # Disable all subsystems.
+  piko server log --subsystems ""
+`,
+	}
+
+	var conf config.Config
+	conf.RegisterFlags(cmd.Flags())
+
+	var level string
+	cmd.Flags().StringVar(
+		&level,
+		"level",
+		"",
+		`
+Minimum log level to output.
+
+The available levels are 'debug', 'info', 'warn' and 'error'.`,
+	)
+
+	var subsystems []string
+	cmd.Flags().StringSliceVar(
+		&subsystems,
+		"subsystems",
+		nil,
+		`
+Subsystems to enable all log levels for. Replaces the existing enabled
+subsystems.`,
+	)
+
+	cmd.Run = func(cmd *cobra.Command, _ []string) {
+		if err := conf.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		tlsConfig, err := conf.Server.TLS.Load()
+		if err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		url, _ := url.Parse(conf.Server.URL)
+		c := client.NewClient(url)
+		c.SetTLSConfig(tlsConfig)
+		c.SetForward(conf.Forward)
+		c.SetToken(conf.Server.Token)
+
+		var update admin.LogUpdate
+		update.Level = level
+		if cmd.Flags().Changed("subsystems") {
+			update.Subsystems = &subsystems
+		}
+
+		if update.Level == "" && update.Subsystems == nil {
+			showLogConfig(c)
+		} else {
+			updateLogConfig(c, update)
+		}
+	}
+
+	return cmd
+}
+
+func showLogConfig(c *client.Client) {
+	conf, err := client.NewLog(c).Config()
+	if err != nil {
+		fmt.Printf("failed to get log config: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	b, _ := yaml.Marshal(conf)
+	fmt.Print(string(b))
+}
+
+func updateLogConfig(c *client.Client, update admin.LogUpdate) {
+	conf, err := client.NewLog(c).Update(update)
+	if err != nil {
+		fmt.Printf("failed to update log config: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	b, _ := yaml.Marshal(conf)
+	fmt.Print(string(b))
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/status/client"
+	"github.com/andydunstall/piko/server/status/config"
+	"github.com/spf13/cobra"
+)
+
+func newRollingDrainCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rolling-drain [flags]",
+		Short: "drain and shut down each node in the cluster",
+		Long: `Drain and shut down each node in the cluster one at a time.
+
+For each active node in the cluster, drains the node (see 'piko server drain'),
+shuts the node down, then waits for the endpoints that were connected to the
+node to reconnect to another node in the cluster before moving on to the next
+node.
+
+The node at '--server.url' is drained last, since it is used to inspect the
+cluster.
+
+This is useful for rolling restarts, such as if the nodes are restarted by
+your orchestrator after shutting down.
+
+Examples:
+  # Drain the cluster of the node at localhost:8002.
+  piko server rolling-drain
+
+  # Wait up to 5 minutes for each step.
+  piko server rolling-drain --timeout 5m
+`,
+	}
+
+	var conf config.Config
+	conf.RegisterFlags(cmd.Flags())
+
+	var timeout time.Duration
+	cmd.Flags().DurationVar(
+		&timeout,
+		"timeout",
+		time.Minute*2,
+		`
+Maximum duration to wait for each step, including waiting for a node to drain
+and waiting for its endpoints to reconnect to another node.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if err := conf.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		tlsConfig, err := conf.Server.TLS.Load()
+		if err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		url, _ := url.Parse(conf.Server.URL)
+
+		if err := rollingDrain(url, tlsConfig, conf.Server.Token, timeout); err != nil {
+			fmt.Printf("failed to drain cluster: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
+
+func rollingDrain(
+	url *url.URL,
+	tlsConfig *tls.Config,
+	token string,
+	timeout time.Duration,
+) error {
+	c := client.NewClient(url)
+	c.SetTLSConfig(tlsConfig)
+	c.SetToken(token)
+	clusterClient := client.NewCluster(c)
+
+	localNode, err := clusterClient.Node("local")
+	if err != nil {
+		return fmt.Errorf("get local node: %w", err)
+	}
+	nodes, err := clusterClient.Nodes()
+	if err != nil {
+		return fmt.Errorf("get nodes: %w", err)
+	}
+
+	// Drain remote nodes in order of ID, then the local node last.
+	var nodeIDs []string
+	for _, node := range nodes {
+		if node.ID == localNode.ID || node.Status != cluster.NodeStatusActive {
+			continue
+		}
+		nodeIDs = append(nodeIDs, node.ID)
+	}
+	sort.Strings(nodeIDs)
+	nodeIDs = append(nodeIDs, localNode.ID)
+
+	for _, nodeID := range nodeIDs {
+		fmt.Printf("draining node %s\n", nodeID)
+
+		nodeClient := client.NewClient(url)
+		nodeClient.SetTLSConfig(tlsConfig)
+		nodeClient.SetForward(nodeID)
+		nodeClient.SetToken(token)
+
+		node, err := client.NewCluster(nodeClient).Node("local")
+		if err != nil {
+			return fmt.Errorf("get node: %s: %w", nodeID, err)
+		}
+
+		drain := client.NewDrain(nodeClient)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_, err = waitDrained(ctx, drain)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("drain node: %s: %w", nodeID, err)
+		}
+
+		fmt.Printf("shutting down node %s\n", nodeID)
+
+		if err := drain.Shutdown(); err != nil {
+			return fmt.Errorf("shutdown node: %s: %w", nodeID, err)
+		}
+
+		if nodeID == localNode.ID {
+			// We can't inspect the cluster once the local node has shut
+			// down.
+			break
+		}
+
+		fmt.Printf(
+			"waiting for %d endpoints to reconnect\n", len(node.Endpoints),
+		)
+
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+		err = waitEndpointsReconnected(ctx, clusterClient, nodeID, node.Endpoints)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("wait for endpoints: %s: %w", nodeID, err)
+		}
+	}
+
+	fmt.Println("cluster drained")
+
+	return nil
+}
+
+// waitEndpointsReconnected waits for each of the given endpoints to be active
+// on an active node other than the drained node.
+func waitEndpointsReconnected(
+	ctx context.Context,
+	clusterClient *client.Cluster,
+	drainedNodeID string,
+	endpoints map[string]int,
+) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		active, err := activeEndpoints(clusterClient, drainedNodeID)
+		if err != nil {
+			return err
+		}
+
+		reconnected := true
+		for endpointID := range endpoints {
+			if _, ok := active[endpointID]; !ok {
+				reconnected = false
+				break
+			}
+		}
+		if reconnected {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// activeEndpoints returns the set of endpoints active on any active node
+// excluding the drained node.
+func activeEndpoints(
+	clusterClient *client.Cluster,
+	drainedNodeID string,
+) (map[string]struct{}, error) {
+	nodes, err := clusterClient.Nodes()
+	if err != nil {
+		return nil, fmt.Errorf("get nodes: %w", err)
+	}
+
+	active := make(map[string]struct{})
+	for _, metadata := range nodes {
+		if metadata.ID == drainedNodeID ||
+			metadata.Status != cluster.NodeStatusActive ||
+			metadata.Draining {
+			continue
+		}
+
+		node, err := clusterClient.Node(metadata.ID)
+		if err != nil {
+			return nil, fmt.Errorf("get node: %s: %w", metadata.ID, err)
+		}
+		for endpointID, listeners := range node.Endpoints {
+			if listeners > 0 {
+				active[endpointID] = struct{}{}
+			}
+		}
+	}
+	return active, nil
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	pikoconfig "github.com/andydunstall/piko/pkg/config"
+	"github.com/andydunstall/piko/pkg/winsvc"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// serviceName is the default name of the server Windows service.
+	serviceName = "piko-server"
+)
+
+func newServiceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "service [command] [flags]",
+		Short: "manage the server windows service",
+		Long: `Install or uninstall the server as a Windows service.
+
+The service runs 'piko server' with the configured YAML configuration file,
+starts when the host boots, and is restarted if it fails. When the service is
+stopped, or the host shuts down, the server gracefully shuts down (including
+leaving the cluster).
+
+Windows services are only supported on Windows.
+
+Examples:
+  # Install the server service using the configuration in server.yaml.
+  piko server service install --config.path C:\piko\server.yaml
+
+  # Uninstall the server service.
+  piko server service uninstall
+`,
+	}
+
+	cmd.AddCommand(newServiceInstallCommand())
+	cmd.AddCommand(newServiceUninstallCommand())
+
+	return cmd
+}
+
+func newServiceInstallCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "install [flags]",
+		Args:  cobra.NoArgs,
+		Short: "install the server windows service",
+		Long: `Install the server as a Windows service.
+
+The service loads its configuration from the YAML file configured with
+'--config.path'.
+
+Examples:
+  piko server service install --config.path C:\piko\server.yaml
+`,
+	}
+
+	var name string
+	cmd.Flags().StringVar(
+		&name,
+		"name",
+		serviceName,
+		`
+Name of the Windows service.`,
+	)
+
+	var loadConf pikoconfig.Config
+	loadConf.RegisterFlags(cmd.Flags())
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if loadConf.Path == "" {
+			fmt.Println("missing config path; configure with '--config.path'")
+			os.Exit(1)
+		}
+		// The service doesn't run in the current working directory so
+		// requires an absolute path.
+		path, err := filepath.Abs(loadConf.Path)
+		if err != nil {
+			fmt.Printf("invalid config path: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		args := []string{"server", "--config.path", path}
+		if loadConf.Dir != "" {
+			dir, err := filepath.Abs(loadConf.Dir)
+			if err != nil {
+				fmt.Printf("invalid config dir: %s\n", err.Error())
+				os.Exit(1)
+			}
+			args = append(args, "--config.dir", dir)
+		}
+		if loadConf.ExpandEnv {
+			args = append(args, "--config.expand-env")
+		}
+
+		if err := winsvc.Install(
+			name, "Piko server routing traffic to upstream services.", args,
+		); err != nil {
+			fmt.Printf("failed to install service: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("installed service %s\n", name)
+	}
+
+	return cmd
+}
+
+func newServiceUninstallCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "uninstall [flags]",
+		Args:  cobra.NoArgs,
+		Short: "uninstall the server windows service",
+		Long: `Uninstall the server Windows service.
+
+Examples:
+  piko server service uninstall
+`,
+	}
+
+	var name string
+	cmd.Flags().StringVar(
+		&name,
+		"name",
+		serviceName,
+		`
+Name of the Windows service.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if err := winsvc.Uninstall(name); err != nil {
+			fmt.Printf("failed to uninstall service: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("uninstalled service %s\n", name)
+	}
+
+	return cmd
+}
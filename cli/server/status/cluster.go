@@ -1,29 +1,151 @@
 package status
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"sort"
+	"strconv"
+	"text/tabwriter"
 
+	"github.com/andydunstall/piko/cli/output"
 	"github.com/andydunstall/piko/server/cluster"
 	"github.com/andydunstall/piko/server/status/client"
-	yaml "github.com/goccy/go-yaml"
 	"github.com/spf13/cobra"
 )
 
-func newClusterCommand(c *client.Client) *cobra.Command {
+func newClusterCommand(c *client.Client, opts *output.Options) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "cluster",
 		Short: "inspect proxy cluster",
+		Long: `Inspect the proxy cluster.
+
+Queries the server for an overview of the cluster, including the known nodes,
+the version of each node, the number of endpoints and upstreams connected to
+each node, and the gossip health of each node.
+
+Use '--output json' to output JSON rather than a table.
+
+Examples:
+  piko server status cluster
+
+  piko server status cluster --output json
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showCluster(c, opts)
 	}
 
-	cmd.AddCommand(newClusterNodesCommand(c))
-	cmd.AddCommand(newClusterNodeCommand(c))
+	cmd.AddCommand(newClusterNodesCommand(c, opts))
+	cmd.AddCommand(newClusterNodeCommand(c, opts))
+	cmd.AddCommand(newClusterNetmapCommand(c, opts))
 
 	return cmd
 }
 
-func newClusterNodesCommand(c *client.Client) *cobra.Command {
+type clusterNodeOutput struct {
+	ID        string             `json:"id"`
+	Status    cluster.NodeStatus `json:"status"`
+	Role      cluster.NodeRole   `json:"role,omitempty"`
+	Version   string             `json:"version,omitempty"`
+	Commit    string             `json:"commit,omitempty"`
+	GoVersion string             `json:"go_version,omitempty"`
+	Draining  bool               `json:"draining"`
+	Endpoints int                `json:"endpoints"`
+	Upstreams int                `json:"upstreams"`
+	// Gossip is the gossip health of the node, either 'reachable',
+	// 'unreachable', 'left' or 'unknown'.
+	Gossip string `json:"gossip"`
+}
+
+type clusterOutput struct {
+	Nodes []clusterNodeOutput `json:"nodes"`
+}
+
+func showCluster(c *client.Client, opts *output.Options) {
+	nodes, err := client.NewCluster(c).Nodes()
+	if err != nil {
+		fmt.Printf("failed to get cluster nodes: %s\n", err.Error())
+		os.Exit(1)
+	}
+	gossipNodes, err := client.NewGossip(c).Nodes()
+	if err != nil {
+		fmt.Printf("failed to get gossip nodes: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	gossipHealth := make(map[string]string)
+	for _, node := range gossipNodes {
+		switch {
+		case node.Left:
+			gossipHealth[node.ID] = "left"
+		case node.Unreachable:
+			gossipHealth[node.ID] = "unreachable"
+		default:
+			gossipHealth[node.ID] = "reachable"
+		}
+	}
+
+	// Sort by ID.
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	var out clusterOutput
+	for _, node := range nodes {
+		health, ok := gossipHealth[node.ID]
+		if !ok {
+			health = "unknown"
+		}
+		out.Nodes = append(out.Nodes, clusterNodeOutput{
+			ID:        node.ID,
+			Status:    node.Status,
+			Role:      node.Role,
+			Version:   node.Version,
+			Commit:    node.Commit,
+			GoVersion: node.GoVersion,
+			Draining:  node.Draining,
+			Endpoints: node.Endpoints,
+			Upstreams: node.Upstreams,
+			Gossip:    health,
+		})
+	}
+
+	opts.Print(out, func() {
+		printClusterTable(out)
+	})
+}
+
+func printClusterTable(out clusterOutput) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tROLE\tVERSION\tDRAINING\tENDPOINTS\tUPSTREAMS\tGOSSIP")
+	for _, node := range out.Nodes {
+		role := string(node.Role)
+		if role == "" {
+			role = string(cluster.NodeRoleFull)
+		}
+		version := node.Version
+		if version == "" {
+			version = "-"
+		}
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			node.ID,
+			node.Status,
+			role,
+			version,
+			strconv.FormatBool(node.Draining),
+			node.Endpoints,
+			node.Upstreams,
+			node.Gossip,
+		)
+	}
+	w.Flush()
+}
+
+func newClusterNodesCommand(c *client.Client, opts *output.Options) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "nodes",
 		Short: "inspect cluster nodes",
@@ -38,7 +160,7 @@ Examples:
 	}
 
 	cmd.Run = func(_ *cobra.Command, _ []string) {
-		showClusterNodes(c)
+		showClusterNodes(c, opts)
 	}
 
 	return cmd
@@ -48,7 +170,7 @@ type clusterNodesOutput struct {
 	Nodes []*cluster.NodeMetadata `json:"nodes"`
 }
 
-func showClusterNodes(c *client.Client) {
+func showClusterNodes(c *client.Client, opts *output.Options) {
 	cluster := client.NewCluster(c)
 
 	nodes, err := cluster.Nodes()
@@ -62,14 +184,13 @@ func showClusterNodes(c *client.Client) {
 		return nodes[i].ID < nodes[j].ID
 	})
 
-	output := clusterNodesOutput{
+	out := clusterNodesOutput{
 		Nodes: nodes,
 	}
-	b, _ := yaml.Marshal(output)
-	fmt.Print(string(b))
+	opts.PrintYAML(out)
 }
 
-func newClusterNodeCommand(c *client.Client) *cobra.Command {
+func newClusterNodeCommand(c *client.Client, opts *output.Options) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "node",
 		Args:  cobra.ExactArgs(1),
@@ -89,13 +210,13 @@ Examples:
 	}
 
 	cmd.Run = func(_ *cobra.Command, args []string) {
-		showClusterNode(args[0], c)
+		showClusterNode(args[0], c, opts)
 	}
 
 	return cmd
 }
 
-func showClusterNode(nodeID string, c *client.Client) {
+func showClusterNode(nodeID string, c *client.Client, opts *output.Options) {
 	cluster := client.NewCluster(c)
 
 	node, err := cluster.Node(nodeID)
@@ -104,6 +225,60 @@ func showClusterNode(nodeID string, c *client.Client) {
 		os.Exit(1)
 	}
 
-	b, _ := yaml.Marshal(node)
-	fmt.Print(string(b))
+	opts.PrintYAML(node)
+}
+
+func newClusterNetmapCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "netmap",
+		Short: "inspect cluster netmap",
+		Long: `Inspect the cluster netmap.
+
+Queries the server for the known state of each node in the cluster, including
+addresses, status, labels and the endpoints on each node. The output is JSON,
+intended for scripts and external tools.
+
+Examples:
+  piko server status cluster netmap
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showClusterNetmap(c, opts)
+	}
+
+	return cmd
+}
+
+// newNetmapCommand is a shortcut for 'piko server status cluster netmap'.
+func newNetmapCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := newClusterNetmapCommand(c, opts)
+	cmd.Long = `Inspect the cluster netmap.
+
+Queries the server for the known state of each node in the cluster, including
+addresses, status, labels and the endpoints on each node. The output is JSON,
+intended for scripts and external tools.
+
+This is the same as 'piko server status cluster netmap'.
+
+Examples:
+  piko server status netmap
+`
+	return cmd
+}
+
+func showClusterNetmap(c *client.Client, opts *output.Options) {
+	cluster := client.NewCluster(c)
+
+	netmap, err := cluster.Netmap()
+	if err != nil {
+		fmt.Printf("failed to get cluster netmap: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	// The netmap is always output as JSON.
+	opts.Print(netmap, func() {
+		b, _ := json.MarshalIndent(netmap, "", "  ")
+		fmt.Println(string(b))
+	})
 }
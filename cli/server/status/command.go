@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"os"
 
+	"github.com/andydunstall/piko/cli/output"
 	"github.com/andydunstall/piko/server/status/client"
 	"github.com/andydunstall/piko/server/status/config"
 	"github.com/spf13/cobra"
@@ -24,18 +25,37 @@ can be used to answer questions such as:
 
 See 'piko server status --help' for the available commands.
 
+Use '--output json' to output JSON to be parsed by scripts, or '--quiet' to
+disable output so only the exit status indicates whether the command
+succeeded.
+
 Examples:
+  # Inspect the proxy requests handled by the node.
+  piko server status proxy
+
+  # Inspect the gossip state of each known node.
+  piko server status gossip
+
+  # Inspect the cluster netmap.
+  piko server status netmap
+
   # Inspect the known nodes in the cluster.
   piko server status cluster nodes
 
   # Inspect the known nodes by node cv6cdyo.
   piko server status cluster nodes --forward cv6cdyo
+
+  # Inspect the upstream connections in JSON format.
+  piko server status upstream conns --output json
 `,
 	}
 
 	var conf config.Config
 	conf.RegisterFlags(cmd.PersistentFlags())
 
+	var opts output.Options
+	opts.RegisterFlags(cmd.PersistentFlags())
+
 	c := client.NewClient(nil)
 
 	cmd.PersistentPreRun = func(_ *cobra.Command, _ []string) {
@@ -43,15 +63,30 @@ Examples:
 			fmt.Printf("config: %s\n", err.Error())
 			os.Exit(1)
 		}
+		if err := opts.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		tlsConfig, err := conf.Server.TLS.Load()
+		if err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
 
 		url, _ := url.Parse(conf.Server.URL)
 		c.SetURL(url)
+		c.SetTLSConfig(tlsConfig)
 		c.SetForward(conf.Forward)
+		c.SetToken(conf.Server.Token)
 	}
 
-	cmd.AddCommand(newUpstreamCommand(c))
-	cmd.AddCommand(newClusterCommand(c))
-	cmd.AddCommand(newGossipCommand(c))
+	cmd.AddCommand(newProxyCommand(c, &opts))
+	cmd.AddCommand(newUpstreamCommand(c, &opts))
+	cmd.AddCommand(newClusterCommand(c, &opts))
+	cmd.AddCommand(newGossipCommand(c, &opts))
+	cmd.AddCommand(newFederationCommand(c, &opts))
+	cmd.AddCommand(newNetmapCommand(c, &opts))
 
 	return cmd
 }
@@ -0,0 +1,63 @@
+package status
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/andydunstall/piko/cli/output"
+	"github.com/andydunstall/piko/server/federation"
+	"github.com/andydunstall/piko/server/status/client"
+	"github.com/spf13/cobra"
+)
+
+func newFederationCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "federation",
+		Short: "inspect federated clusters",
+	}
+
+	cmd.AddCommand(newFederationClustersCommand(c, opts))
+
+	return cmd
+}
+
+func newFederationClustersCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clusters",
+		Short: "inspect federated clusters",
+		Long: `Inspect federated clusters.
+
+Queries the server for the known state of each remote federated cluster,
+including the number of endpoints available in the cluster and the result of
+the last sync.
+
+Examples:
+  piko server status federation clusters
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showFederationClusters(c, opts)
+	}
+
+	return cmd
+}
+
+type federationClustersOutput struct {
+	Clusters []federation.ClusterStatus `json:"clusters"`
+}
+
+func showFederationClusters(c *client.Client, opts *output.Options) {
+	federation := client.NewFederation(c)
+
+	clusters, err := federation.Clusters()
+	if err != nil {
+		fmt.Printf("failed to get federated clusters: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := federationClustersOutput{
+		Clusters: clusters,
+	}
+	opts.PrintYAML(out)
+}
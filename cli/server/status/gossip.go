@@ -5,25 +5,41 @@ import (
 	"os"
 	"sort"
 
+	"github.com/andydunstall/piko/cli/output"
 	"github.com/andydunstall/piko/pkg/gossip"
 	"github.com/andydunstall/piko/server/status/client"
-	yaml "github.com/goccy/go-yaml"
 	"github.com/spf13/cobra"
 )
 
-func newGossipCommand(c *client.Client) *cobra.Command {
+func newGossipCommand(c *client.Client, opts *output.Options) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "gossip",
 		Short: "inspect gossip state",
+		Long: `Inspect gossip state.
+
+Without a subcommand, queries the server for the metadata for each known
+gossip node in the cluster (the same as 'piko server status gossip nodes').
+
+Examples:
+  piko server status gossip
+
+  piko server status gossip node bbc69214
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showGossipNodes(c, opts)
 	}
 
-	cmd.AddCommand(newGossipNodesCommand(c))
-	cmd.AddCommand(newGossipNodeCommand(c))
+	cmd.AddCommand(newGossipNodesCommand(c, opts))
+	cmd.AddCommand(newGossipNodeCommand(c, opts))
+	cmd.AddCommand(newGossipSyncCommand(c, opts))
+	cmd.AddCommand(newGossipAuthFailuresCommand(c, opts))
 
 	return cmd
 }
 
-func newGossipNodesCommand(c *client.Client) *cobra.Command {
+func newGossipNodesCommand(c *client.Client, opts *output.Options) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "nodes",
 		Short: "inspect gossip nodes",
@@ -38,7 +54,7 @@ Examples:
 	}
 
 	cmd.Run = func(_ *cobra.Command, _ []string) {
-		showGossipNodes(c)
+		showGossipNodes(c, opts)
 	}
 
 	return cmd
@@ -48,7 +64,7 @@ type gossipNodesOutput struct {
 	Nodes []gossip.NodeMetadata `json:"nodes"`
 }
 
-func showGossipNodes(c *client.Client) {
+func showGossipNodes(c *client.Client, opts *output.Options) {
 	gossip := client.NewGossip(c)
 
 	nodes, err := gossip.Nodes()
@@ -62,14 +78,13 @@ func showGossipNodes(c *client.Client) {
 		return nodes[i].ID < nodes[j].ID
 	})
 
-	output := gossipNodesOutput{
+	out := gossipNodesOutput{
 		Nodes: nodes,
 	}
-	b, _ := yaml.Marshal(output)
-	fmt.Println(string(b))
+	opts.PrintYAML(out)
 }
 
-func newGossipNodeCommand(c *client.Client) *cobra.Command {
+func newGossipNodeCommand(c *client.Client, opts *output.Options) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "node",
 		Args:  cobra.ExactArgs(1),
@@ -84,13 +99,13 @@ Examples:
 	}
 
 	cmd.Run = func(_ *cobra.Command, args []string) {
-		showGossipNode(args[0], c)
+		showGossipNode(args[0], c, opts)
 	}
 
 	return cmd
 }
 
-func showGossipNode(nodeID string, c *client.Client) {
+func showGossipNode(nodeID string, c *client.Client, opts *output.Options) {
 	gossip := client.NewGossip(c)
 
 	node, err := gossip.Node(nodeID)
@@ -99,6 +114,78 @@ func showGossipNode(nodeID string, c *client.Client) {
 		os.Exit(1)
 	}
 
-	b, _ := yaml.Marshal(node)
-	fmt.Println(string(b))
+	opts.PrintYAML(node)
+}
+
+func newGossipSyncCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "inspect full state syncs",
+		Long: `Inspect full state syncs.
+
+Queries the server for statistics about the full state syncs the node has
+initiated with other nodes in the cluster.
+
+Examples:
+  piko server status gossip sync
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showGossipSync(c, opts)
+	}
+
+	return cmd
+}
+
+func showGossipSync(c *client.Client, opts *output.Options) {
+	gossip := client.NewGossip(c)
+
+	stats, err := gossip.Sync()
+	if err != nil {
+		fmt.Printf("failed to get gossip sync stats: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	opts.PrintYAML(stats)
+}
+
+func newGossipAuthFailuresCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "auth-failures",
+		Short: "inspect rejected unauthenticated nodes",
+		Long: `Inspect rejected unauthenticated nodes.
+
+When '--gossip.join-token' is configured, queries the server for the most
+recent gossip messages rejected from nodes that failed to authenticate.
+
+Examples:
+  piko server status gossip auth-failures
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showGossipAuthFailures(c, opts)
+	}
+
+	return cmd
+}
+
+type gossipAuthFailuresOutput struct {
+	Failures []gossip.AuthFailure `json:"failures"`
+}
+
+func showGossipAuthFailures(c *client.Client, opts *output.Options) {
+	gossip := client.NewGossip(c)
+
+	failures, err := gossip.AuthFailures()
+	if err != nil {
+		fmt.Printf("failed to get gossip auth failures: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := gossipAuthFailuresOutput{
+		Failures: failures,
+	}
+	opts.PrintYAML(out)
 }
@@ -0,0 +1,145 @@
+package status
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/andydunstall/piko/cli/output"
+	"github.com/andydunstall/piko/server/proxy"
+	"github.com/andydunstall/piko/server/status/client"
+	"github.com/spf13/cobra"
+)
+
+func newProxyCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proxy",
+		Short: "inspect proxy requests",
+		Long: `Inspect proxy requests.
+
+Queries the server for the number of requests and failed requests the node
+has handled for each endpoint, and the most recent failed requests.
+
+Use the subcommands to inspect only the endpoints or errors.
+
+Examples:
+  piko server status proxy
+
+  piko server status proxy endpoints
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showProxy(c, opts)
+	}
+
+	cmd.AddCommand(newProxyEndpointsCommand(c, opts))
+	cmd.AddCommand(newProxyErrorsCommand(c, opts))
+
+	return cmd
+}
+
+type proxyOutput struct {
+	Endpoints []proxy.EndpointStats `json:"endpoints"`
+	Errors    []proxy.RequestError  `json:"errors"`
+}
+
+func showProxy(c *client.Client, opts *output.Options) {
+	proxy := client.NewProxy(c)
+
+	endpoints, err := proxy.Endpoints()
+	if err != nil {
+		fmt.Printf("failed to get proxy endpoints: %s\n", err.Error())
+		os.Exit(1)
+	}
+	errors, err := proxy.Errors()
+	if err != nil {
+		fmt.Printf("failed to get proxy errors: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := proxyOutput{
+		Endpoints: endpoints,
+		Errors:    errors,
+	}
+	opts.PrintYAML(out)
+}
+
+func newProxyEndpointsCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "endpoints",
+		Short: "inspect proxy requests for each endpoint",
+		Long: `Inspect proxy requests for each endpoint.
+
+Queries the server for the number of requests and failed requests the node
+has handled for each endpoint.
+
+Examples:
+  piko server status proxy endpoints
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showProxyEndpoints(c, opts)
+	}
+
+	return cmd
+}
+
+type proxyEndpointsOutput struct {
+	Endpoints []proxy.EndpointStats `json:"endpoints"`
+}
+
+func showProxyEndpoints(c *client.Client, opts *output.Options) {
+	proxy := client.NewProxy(c)
+
+	endpoints, err := proxy.Endpoints()
+	if err != nil {
+		fmt.Printf("failed to get proxy endpoints: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := proxyEndpointsOutput{
+		Endpoints: endpoints,
+	}
+	opts.PrintYAML(out)
+}
+
+func newProxyErrorsCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "errors",
+		Short: "inspect recent proxy errors",
+		Long: `Inspect recent proxy errors.
+
+Queries the server for the most recent failed proxy requests, such as
+requests for endpoints with no upstreams or upstreams that timed out.
+
+Examples:
+  piko server status proxy errors
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showProxyErrors(c, opts)
+	}
+
+	return cmd
+}
+
+type proxyErrorsOutput struct {
+	Errors []proxy.RequestError `json:"errors"`
+}
+
+func showProxyErrors(c *client.Client, opts *output.Options) {
+	proxy := client.NewProxy(c)
+
+	errors, err := proxy.Errors()
+	if err != nil {
+		fmt.Printf("failed to get proxy errors: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := proxyErrorsOutput{
+		Errors: errors,
+	}
+	opts.PrintYAML(out)
+}
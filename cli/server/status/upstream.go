@@ -3,24 +3,30 @@ package status
 import (
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/andydunstall/piko/cli/output"
 	"github.com/andydunstall/piko/server/status/client"
-	yaml "github.com/goccy/go-yaml"
+	"github.com/andydunstall/piko/server/upstream"
 	"github.com/spf13/cobra"
 )
 
-func newUpstreamCommand(c *client.Client) *cobra.Command {
+func newUpstreamCommand(c *client.Client, opts *output.Options) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "upstream",
-		Short: "inspect connected upstreams",
+		Short: "inspect and manage connected upstreams",
 	}
 
-	cmd.AddCommand(newUpstreamEndpointsCommand(c))
+	cmd.AddCommand(newUpstreamEndpointsCommand(c, opts))
+	cmd.AddCommand(newUpstreamConnsCommand(c, opts))
+	cmd.AddCommand(newUpstreamClusterEndpointsCommand(c, opts))
+	cmd.AddCommand(newUpstreamDisconnectCommand(c, opts))
+	cmd.AddCommand(newUpstreamBansCommand(c, opts))
 
 	return cmd
 }
 
-func newUpstreamEndpointsCommand(c *client.Client) *cobra.Command {
+func newUpstreamEndpointsCommand(c *client.Client, opts *output.Options) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "endpoints",
 		Short: "inspect endpoints",
@@ -34,13 +40,13 @@ Examples:
 	}
 
 	cmd.Run = func(_ *cobra.Command, _ []string) {
-		showUpstreamEndpoints(c)
+		showUpstreamEndpoints(c, opts)
 	}
 
 	return cmd
 }
 
-func showUpstreamEndpoints(c *client.Client) {
+func showUpstreamEndpoints(c *client.Client, opts *output.Options) {
 	upstream := client.NewUpstream(c)
 
 	endpoints, err := upstream.Endpoints()
@@ -49,6 +55,184 @@ func showUpstreamEndpoints(c *client.Client) {
 		os.Exit(1)
 	}
 
-	b, _ := yaml.Marshal(endpoints)
-	fmt.Print(string(b))
+	opts.PrintYAML(endpoints)
+}
+
+func newUpstreamConnsCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "conns",
+		Short: "inspect upstream connections",
+		Long: `Inspect upstream connections.
+
+Queries the server for the upstreams connected to the node, including the
+connection ID, endpoint ID, client IP and connection age.
+
+Examples:
+  piko server status upstream conns
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showUpstreamConns(c, opts)
+	}
+
+	return cmd
+}
+
+type upstreamConnsOutput struct {
+	Conns []upstream.ConnInfo `json:"conns"`
+}
+
+func showUpstreamConns(c *client.Client, opts *output.Options) {
+	upstream := client.NewUpstream(c)
+
+	conns, err := upstream.Conns()
+	if err != nil {
+		fmt.Printf("failed to get upstream connections: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := upstreamConnsOutput{
+		Conns: conns,
+	}
+	opts.PrintYAML(out)
+}
+
+func newUpstreamClusterEndpointsCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cluster-endpoints",
+		Short: "inspect endpoints across the cluster",
+		Long: `Inspect endpoints across the cluster.
+
+Queries the server for the endpoints with upstreams connected to any node in
+the cluster, including the number of upstreams connected to each node.
+
+Examples:
+  piko server status upstream cluster-endpoints
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showUpstreamClusterEndpoints(c, opts)
+	}
+
+	return cmd
+}
+
+type upstreamClusterEndpointsOutput struct {
+	Endpoints []upstream.EndpointInfo `json:"endpoints"`
+}
+
+func showUpstreamClusterEndpoints(c *client.Client, opts *output.Options) {
+	upstream := client.NewUpstream(c)
+
+	endpoints, err := upstream.ClusterEndpoints()
+	if err != nil {
+		fmt.Printf("failed to get cluster endpoints: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := upstreamClusterEndpointsOutput{
+		Endpoints: endpoints,
+	}
+	opts.PrintYAML(out)
+}
+
+func newUpstreamDisconnectCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "disconnect [id]",
+		Args:  cobra.ExactArgs(1),
+		Short: "disconnect an upstream",
+		Long: `Disconnect an upstream.
+
+Forcibly closes the upstream connection with the given connection ID. The
+connection ID can be found using 'piko server status upstream conns'.
+
+The upstream will typically reconnect. To prevent this, use '--ban' to ban
+the upstream from reconnecting to the cluster for the given duration. The ban
+is propagated to the other nodes. Upstreams are identified by their endpoint
+ID and client IP.
+
+Examples:
+  # Disconnect an upstream.
+  piko server status upstream disconnect 8c5a4b6f1e2d3c7a
+
+  # Disconnect an upstream and ban it from reconnecting for 10 minutes.
+  piko server status upstream disconnect 8c5a4b6f1e2d3c7a --ban 10m
+`,
+	}
+
+	var ban time.Duration
+	cmd.Flags().DurationVar(
+		&ban,
+		"ban",
+		0,
+		`
+Ban the upstream from reconnecting to the cluster for the given duration.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, args []string) {
+		disconnectUpstream(c, args[0], ban, opts)
+	}
+
+	return cmd
+}
+
+type upstreamDisconnectOutput struct {
+	Conn upstream.ConnInfo `json:"conn"`
+}
+
+func disconnectUpstream(c *client.Client, id string, ban time.Duration, opts *output.Options) {
+	upstream := client.NewUpstream(c)
+
+	conn, err := upstream.CloseConn(id, ban)
+	if err != nil {
+		fmt.Printf("failed to disconnect upstream: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := upstreamDisconnectOutput{
+		Conn: conn,
+	}
+	opts.PrintYAML(out)
+}
+
+func newUpstreamBansCommand(c *client.Client, opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bans",
+		Short: "inspect banned upstreams",
+		Long: `Inspect banned upstreams.
+
+Queries the server for the upstreams banned from connecting, including bans
+added on other nodes, and when each ban expires.
+
+Examples:
+  piko server status upstream bans
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showUpstreamBans(c, opts)
+	}
+
+	return cmd
+}
+
+type upstreamBansOutput struct {
+	Bans []upstream.Ban `json:"bans"`
+}
+
+func showUpstreamBans(c *client.Client, opts *output.Options) {
+	upstream := client.NewUpstream(c)
+
+	bans, err := upstream.Bans()
+	if err != nil {
+		fmt.Printf("failed to get upstream bans: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	out := upstreamBansOutput{
+		Bans: bans,
+	}
+	opts.PrintYAML(out)
 }
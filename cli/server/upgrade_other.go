@@ -0,0 +1,11 @@
+//go:build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals are the signals that upgrade the server to a new process.
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package server
+
+import (
+	"os"
+)
+
+// upgradeSignals are the signals that upgrade the server to a new process,
+// which isn't supported on Windows.
+var upgradeSignals []os.Signal
@@ -0,0 +1,48 @@
+package token
+
+import (
+	"github.com/andydunstall/piko/cli/output"
+	"github.com/spf13/cobra"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "create and inspect endpoint tokens",
+		Long: `Create and inspect endpoint tokens.
+
+When authentication is enabled, upstream connections must provide a JWT to
+authenticate. The token may restrict the endpoints the upstream can register
+using the 'piko.endpoints' claim, and scope endpoints to a tenant using the
+'piko.tenant' claim.
+
+Use 'piko token create' to sign tokens using the same keys configured on the
+Piko server, 'piko token inspect' to decode and verify existing tokens, and
+'piko token revoke' to revoke a leaked token.
+
+Examples:
+  # Create a token for endpoint 'my-endpoint' that expires in 24 hours.
+  piko token create --endpoint my-endpoint --expiry 24h \
+    --hmac-secret-key-file ./secret
+
+  # Inspect a token.
+  piko token inspect eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9...
+
+  # Verify a token in a script, using only the exit status.
+  piko token inspect --quiet --auth.token-hmac-secret-key-file ./secret \
+    < token.jwt
+
+  # Revoke a token on all nodes in the cluster.
+  piko token revoke 4f3c1e2a9b8d7c6e5f4a3b2c1d0e9f8a
+`,
+	}
+
+	var opts output.Options
+	opts.RegisterFlags(cmd.PersistentFlags())
+
+	cmd.AddCommand(newCreateCommand(&opts))
+	cmd.AddCommand(newInspectCommand(&opts))
+	cmd.AddCommand(newRevokeCommand(&opts))
+
+	return cmd
+}
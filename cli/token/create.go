@@ -0,0 +1,270 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/andydunstall/piko/cli/output"
+	pikoconfig "github.com/andydunstall/piko/pkg/config"
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/cobra"
+)
+
+type createOptions struct {
+	id        string
+	endpoints []string
+	tenant    string
+	expiry    time.Duration
+
+	algorithm string
+
+	hmacSecretKey       string
+	hmacSecretKeyFile   string
+	rsaPrivateKeyFile   string
+	ecdsaPrivateKeyFile string
+
+	audience string
+	issuer   string
+}
+
+type createOutput struct {
+	ID    string `json:"id"`
+	Token string `json:"token"`
+}
+
+func newCreateCommand(outputOpts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create",
+		Args:  cobra.NoArgs,
+		Short: "create a signed endpoint token",
+		Long: `Create a signed endpoint token.
+
+Signs a JWT that can be used by upstreams to authenticate with the Piko server
+(such as using the agent 'connect.token' option). The token is signed using
+either an HMAC secret key, RSA private key or ECDSA private key, which must
+match the key configured on the server ('auth.token-hmac-secret-key',
+'auth.token-rsa-public-key' or 'auth.token-ecdsa-public-key').
+
+Each token has a unique ID ('jti' claim), which can be used to revoke the
+token with 'piko token revoke' if the token is leaked.
+
+The token is written to stdout. Use '--output json' to output the token and
+its ID as a JSON object.
+
+Examples:
+  # Create a token for endpoint 'my-endpoint' signed with an HMAC secret key.
+  piko token create --endpoint my-endpoint --hmac-secret-key-file ./secret
+
+  # Create a token for all endpoints with prefix 'team-a-'.
+  piko token create --endpoint 'team-a-*' --hmac-secret-key-file ./secret
+
+  # Create a token for endpoint 'my-endpoint' in tenant 'team-a'.
+  piko token create --endpoint my-endpoint --tenant team-a \
+    --hmac-secret-key-file ./secret
+
+  # Create a token for endpoints 'foo' and 'bar' that expires in 1 hour.
+  piko token create --endpoint foo --endpoint bar --expiry 1h \
+    --hmac-secret-key-file ./secret
+
+  # Create a token signed with an RSA private key using RS512.
+  piko token create --endpoint my-endpoint --algorithm RS512 \
+    --rsa-private-key-file ./key.pem
+`,
+	}
+
+	var opts createOptions
+
+	cmd.Flags().StringVar(
+		&opts.id,
+		"id",
+		"",
+		`
+Unique ID of the token ('jti' claim), used to revoke the token.
+
+Defaults to a random ID.`,
+	)
+	cmd.Flags().StringSliceVar(
+		&opts.endpoints,
+		"endpoint",
+		nil,
+		`
+Endpoint ID the token permits the upstream to register. May be given
+multiple times.
+
+The endpoint ID may be a pattern, where '*' matches any sequence of
+characters, such as 'team-a-*'.
+
+If no endpoints are given, the token permits all endpoints.`,
+	)
+	cmd.Flags().StringVar(
+		&opts.tenant,
+		"tenant",
+		"",
+		`
+Tenant the token belongs to.
+
+Endpoints registered and accessed with the token are scoped to the tenant,
+so tenants can use the same endpoint IDs without conflicting.`,
+	)
+	cmd.Flags().DurationVar(
+		&opts.expiry,
+		"expiry",
+		0,
+		`
+Duration until the token expires.
+
+If 0 the token doesn't expire.`,
+	)
+	cmd.Flags().StringVar(
+		&opts.algorithm,
+		"algorithm",
+		"",
+		`
+JWT signing algorithm, such as 'HS512' or 'RS384'. Must match the type of
+the configured key.
+
+Defaults to 'HS256', 'RS256' or 'ES256' depending on the key.`,
+	)
+	cmd.Flags().StringVar(
+		&opts.hmacSecretKey,
+		"hmac-secret-key",
+		"",
+		`
+Secret key to sign an HMAC token.`,
+	)
+	cmd.Flags().StringVar(
+		&opts.hmacSecretKeyFile,
+		"hmac-secret-key-file",
+		"",
+		`
+Path of a file containing the secret key to sign an HMAC token.`,
+	)
+	cmd.Flags().StringVar(
+		&opts.rsaPrivateKeyFile,
+		"rsa-private-key-file",
+		"",
+		`
+Path of a PEM file containing the private key to sign an RSA token.`,
+	)
+	cmd.Flags().StringVar(
+		&opts.ecdsaPrivateKeyFile,
+		"ecdsa-private-key-file",
+		"",
+		`
+Path of a PEM file containing the private key to sign an ECDSA token.`,
+	)
+	cmd.Flags().StringVar(
+		&opts.audience,
+		"audience",
+		"",
+		`
+Audience of the token ('aud' claim).
+
+Required if the server configures 'auth.token-audience'.`,
+	)
+	cmd.Flags().StringVar(
+		&opts.issuer,
+		"issuer",
+		"",
+		`
+Issuer of the token ('iss' claim).
+
+Required if the server configures 'auth.token-issuer'.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if err := outputOpts.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		if opts.id == "" {
+			opts.id = generateTokenID()
+		}
+		token, err := createToken(opts)
+		if err != nil {
+			fmt.Printf("failed to create token: %s\n", err.Error())
+			os.Exit(1)
+		}
+		outputOpts.Print(createOutput{ID: opts.id, Token: token}, func() {
+			fmt.Println(token)
+		})
+	}
+
+	return cmd
+}
+
+func createToken(opts createOptions) (string, error) {
+	if opts.expiry < 0 {
+		return "", fmt.Errorf("expiry cannot be negative")
+	}
+	if !auth.ValidTenant(opts.tenant) {
+		return "", fmt.Errorf("tenant cannot contain '/'")
+	}
+
+	signerConf := auth.JWTSignerConfig{
+		Algorithm: opts.algorithm,
+		Audience:  opts.audience,
+		Issuer:    opts.issuer,
+	}
+
+	if err := pikoconfig.LoadSecretFile(
+		&opts.hmacSecretKey, opts.hmacSecretKeyFile,
+	); err != nil {
+		return "", fmt.Errorf("hmac secret key: %w", err)
+	}
+	signerConf.HMACSecretKey = []byte(opts.hmacSecretKey)
+
+	if opts.rsaPrivateKeyFile != "" {
+		b, err := os.ReadFile(opts.rsaPrivateKeyFile)
+		if err != nil {
+			return "", fmt.Errorf("rsa private key: %w", err)
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(b)
+		if err != nil {
+			return "", fmt.Errorf("rsa private key: %w", err)
+		}
+		signerConf.RSAPrivateKey = key
+	}
+	if opts.ecdsaPrivateKeyFile != "" {
+		b, err := os.ReadFile(opts.ecdsaPrivateKeyFile)
+		if err != nil {
+			return "", fmt.Errorf("ecdsa private key: %w", err)
+		}
+		key, err := jwt.ParseECPrivateKeyFromPEM(b)
+		if err != nil {
+			return "", fmt.Errorf("ecdsa private key: %w", err)
+		}
+		signerConf.ECDSAPrivateKey = key
+	}
+
+	signer, err := auth.NewJWTSigner(signerConf)
+	if err != nil {
+		return "", err
+	}
+
+	var expiry time.Time
+	if opts.expiry != 0 {
+		expiry = time.Now().Add(opts.expiry)
+	}
+	return signer.SignEndpointToken(auth.EndpointToken{
+		ID:        opts.id,
+		Expiry:    expiry,
+		Endpoints: opts.endpoints,
+		Tenant:    opts.tenant,
+	})
+}
+
+// generateTokenID returns a random 128-bit token ID, hex encoded.
+func generateTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// Will not happen.
+		panic("generate token id: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,201 @@
+package token
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andydunstall/piko/cli/output"
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/cobra"
+)
+
+type endpointClaims struct {
+	jwt.RegisteredClaims
+	Piko struct {
+		Endpoints []string `json:"endpoints"`
+		Tenant    string   `json:"tenant"`
+	} `json:"piko"`
+}
+
+type tokenInfo struct {
+	ID        string     `json:"id,omitempty"`
+	Algorithm string     `json:"algorithm"`
+	Endpoints []string   `json:"endpoints"`
+	Tenant    string     `json:"tenant,omitempty"`
+	IssuedAt  *time.Time `json:"issued_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired"`
+	Audience  []string   `json:"audience,omitempty"`
+	Issuer    string     `json:"issuer,omitempty"`
+	Subject   string     `json:"subject,omitempty"`
+
+	// Verified is nil if no key was configured to verify the token.
+	Verified    *bool  `json:"verified,omitempty"`
+	VerifyError string `json:"verify_error,omitempty"`
+}
+
+func newInspectCommand(opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inspect [token]",
+		Args:  cobra.MaximumNArgs(1),
+		Short: "decode and verify an endpoint token",
+		Long: `Decode and verify an endpoint token.
+
+Shows the token algorithm, permitted endpoints, expiry and other claims. If
+no token is given, or the token is '-', the token is read from stdin.
+
+By default the token signature isn't verified. To verify the token, configure
+the same 'auth' options as the Piko server. If the token is invalid, exits
+with a non-zero status.
+
+Examples:
+  # Inspect a token.
+  piko token inspect eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9...
+
+  # Inspect a token read from a file in JSON format.
+  piko token inspect --output json < token.jwt
+
+  # Inspect and verify a token signed with an HMAC secret key.
+  piko token inspect eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9... \
+    --auth.token-hmac-secret-key-file ./secret
+`,
+	}
+
+	var authConf auth.Config
+	authConf.RegisterFlags(cmd.Flags())
+
+	cmd.Run = func(_ *cobra.Command, args []string) {
+		if err := opts.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		var tokenString string
+		if len(args) == 0 || args[0] == "-" {
+			b, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Printf("failed to read token: %s\n", err.Error())
+				os.Exit(1)
+			}
+			tokenString = string(b)
+		} else {
+			tokenString = args[0]
+		}
+		tokenString = strings.TrimSpace(tokenString)
+
+		if err := authConf.LoadSecrets(); err != nil {
+			fmt.Printf("auth: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		info, err := inspectToken(tokenString, authConf)
+		if err != nil {
+			fmt.Printf("failed to inspect token: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		showToken(info, opts)
+
+		if info.Verified != nil && !*info.Verified {
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
+
+func inspectToken(tokenString string, authConf auth.Config) (tokenInfo, error) {
+	var claims endpointClaims
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, &claims)
+	if err != nil {
+		return tokenInfo{}, fmt.Errorf("decode: %w", err)
+	}
+
+	info := tokenInfo{
+		ID:        claims.ID,
+		Algorithm: token.Method.Alg(),
+		Endpoints: claims.Piko.Endpoints,
+		Tenant:    claims.Piko.Tenant,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		Subject:   claims.Subject,
+	}
+	if claims.IssuedAt != nil {
+		info.IssuedAt = &claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		info.ExpiresAt = &claims.ExpiresAt.Time
+		info.Expired = claims.ExpiresAt.Before(time.Now())
+	}
+
+	if authConf.AuthEnabled() {
+		verifier, err := auth.NewJWTVerifierFromConfig(authConf)
+		if err != nil {
+			return tokenInfo{}, fmt.Errorf("verifier: %w", err)
+		}
+
+		_, err = verifier.VerifyEndpointToken(tokenString)
+		verified := err == nil
+		info.Verified = &verified
+		if err != nil {
+			info.VerifyError = err.Error()
+		}
+	}
+
+	return info, nil
+}
+
+func showToken(info tokenInfo, opts *output.Options) {
+	opts.Print(info, func() {
+		printToken(info)
+	})
+}
+
+func printToken(info tokenInfo) {
+	if info.ID != "" {
+		fmt.Printf("id: %s\n", info.ID)
+	}
+	fmt.Printf("algorithm: %s\n", info.Algorithm)
+	if len(info.Endpoints) == 0 {
+		fmt.Println("endpoints: all")
+	} else {
+		fmt.Printf("endpoints: %s\n", strings.Join(info.Endpoints, ", "))
+	}
+	if info.Tenant != "" {
+		fmt.Printf("tenant: %s\n", info.Tenant)
+	}
+	if info.IssuedAt != nil {
+		fmt.Printf("issued at: %s\n", info.IssuedAt.Format(time.RFC3339))
+	}
+	if info.ExpiresAt != nil {
+		expiry := info.ExpiresAt.Format(time.RFC3339)
+		if info.Expired {
+			expiry += " (expired)"
+		}
+		fmt.Printf("expires at: %s\n", expiry)
+	} else {
+		fmt.Println("expires at: never")
+	}
+	if len(info.Audience) != 0 {
+		fmt.Printf("audience: %s\n", strings.Join(info.Audience, ", "))
+	}
+	if info.Issuer != "" {
+		fmt.Printf("issuer: %s\n", info.Issuer)
+	}
+	if info.Subject != "" {
+		fmt.Printf("subject: %s\n", info.Subject)
+	}
+
+	switch {
+	case info.Verified == nil:
+		fmt.Println("verified: unknown (no key configured)")
+	case *info.Verified:
+		fmt.Println("verified: true")
+	default:
+		fmt.Printf("verified: false (%s)\n", info.VerifyError)
+	}
+}
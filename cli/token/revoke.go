@@ -0,0 +1,106 @@
+package token
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/andydunstall/piko/cli/output"
+	"github.com/andydunstall/piko/server/revocation"
+	"github.com/andydunstall/piko/server/status/client"
+	"github.com/andydunstall/piko/server/status/config"
+	"github.com/spf13/cobra"
+)
+
+type revokeOutput struct {
+	Revocation revocation.Revocation `json:"revocation"`
+}
+
+func newRevokeCommand(opts *output.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "revoke [id]",
+		Args:  cobra.ExactArgs(1),
+		Short: "revoke a token",
+		Long: `Revoke a token.
+
+Revokes the token with the given ID ('jti' claim), so upstreams and proxy
+clients can no longer authenticate with the token, without rotating the
+signing key. Upstreams already connected with the token are disconnected.
+Use 'piko token inspect' to find the ID of a token.
+
+The token is revoked on the queried node, which propagates the revocation to
+the rest of the cluster. Revocations aren't persisted, so are lost if all
+nodes in the cluster restart.
+
+If '--expiry' is given, the revocation is discarded once the token expires.
+Otherwise the revocation is kept until the cluster restarts.
+
+Examples:
+  piko token revoke 4f3c1e2a9b8d7c6e5f4a3b2c1d0e9f8a
+
+  # Revoke a token that expires at the given time.
+  piko token revoke 4f3c1e2a9b8d7c6e5f4a3b2c1d0e9f8a \
+    --expiry 2024-07-01T12:00:00Z
+`,
+	}
+
+	var conf config.Config
+	conf.RegisterFlags(cmd.Flags())
+
+	var expiry string
+	cmd.Flags().StringVar(
+		&expiry,
+		"expiry",
+		"",
+		`
+Time the token expires, in RFC 3339 format, after which the revocation is
+discarded.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, args []string) {
+		if err := conf.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if err := opts.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		r := revocation.Revocation{
+			ID: args[0],
+		}
+		if expiry != "" {
+			t, err := time.Parse(time.RFC3339, expiry)
+			if err != nil {
+				fmt.Printf("config: invalid expiry: %s\n", err.Error())
+				os.Exit(1)
+			}
+			r.Expiry = t
+		}
+
+		tlsConfig, err := conf.Server.TLS.Load()
+		if err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		url, _ := url.Parse(conf.Server.URL)
+		c := client.NewClient(url)
+		c.SetTLSConfig(tlsConfig)
+		c.SetForward(conf.Forward)
+		c.SetToken(conf.Server.Token)
+
+		if err := client.NewTokens(c).Revoke(r); err != nil {
+			fmt.Printf("failed to revoke token: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		opts.Print(revokeOutput{Revocation: r}, func() {
+			fmt.Printf("revoked token %s\n", r.ID)
+		})
+	}
+
+	return cmd
+}
@@ -0,0 +1,54 @@
+package version
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/andydunstall/piko/cli/output"
+	"github.com/andydunstall/piko/pkg/build"
+	"github.com/spf13/cobra"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "version",
+		Args:  cobra.NoArgs,
+		Short: "show the piko version",
+		Long: `Show the Piko version, including the git commit and date the
+binary was built from and the Go version used to build it.
+
+To inspect the versions of the nodes in a Piko cluster, use
+'piko server status cluster nodes'.
+
+Examples:
+  # Show the version.
+  piko version
+
+  # Show the version in JSON format.
+  piko version --output json
+`,
+	}
+
+	var opts output.Options
+	opts.RegisterFlags(cmd.Flags())
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if err := opts.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		showVersion(build.GetInfo(), &opts)
+	}
+
+	return cmd
+}
+
+func showVersion(info build.Info, opts *output.Options) {
+	opts.Print(info, func() {
+		fmt.Printf("version: %s\n", info.Version)
+		fmt.Printf("commit: %s\n", info.Commit)
+		fmt.Printf("build date: %s\n", info.Date)
+		fmt.Printf("go version: %s\n", info.GoVersion)
+	})
+}
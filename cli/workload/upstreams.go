@@ -56,7 +56,7 @@ Examples:
 			os.Exit(1)
 		}
 
-		logger, err := log.NewLogger(conf.Log.Level, conf.Log.Subsystems)
+		logger, err := log.NewLoggerFromConfig(conf.Log)
 		if err != nil {
 			fmt.Printf("failed to setup logger: %s\n", err.Error())
 			os.Exit(1)
@@ -12,6 +12,7 @@ import (
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/workloadv2/cluster"
 	"github.com/andydunstall/piko/workloadv2/cluster/config"
+	"github.com/andydunstall/piko/workloadv2/cluster/probe"
 	"github.com/andydunstall/piko/workloadv2/cluster/proxy"
 	rungroup "github.com/oklog/run"
 	"github.com/spf13/cobra"
@@ -29,6 +30,15 @@ The cluster runs the configured number of nodes with a load balancer proxy.
 To test nodes joining and leaving the cluster, configure the 'churn' interval
 which defines how often to stop a node and replace it with a new one.
 
+To test the cluster under failures, configure the 'chaos' options to restart
+random nodes, drop gossip packets and add latency to requests forwarded
+between nodes.
+
+To verify the cluster remains available, configure the 'probe' interval to
+send requests to an upstream endpoint through the load balancer. When the
+cluster shuts down, it exits with an error if the availability is below
+'--probe.min-availability'.
+
 Supports both YAML configuration and command line flags. Configure a YAML file
 using '--config.path'. When enabling '--config.expand-env', Piko will expand
 environment variables in the loaded YAML configuration.
@@ -38,10 +48,20 @@ signal to the process.
 
 Examples:
   # Start a cluster of 5 nodes.
-  piko workload cluster --nodes 5
+  piko workloadv2 cluster --nodes 5
 
   # Start a cluster and replace one node every 10 seconds.
-  piko workload cluster --churn.interval 10s
+  piko workloadv2 cluster --churn.interval 10s
+
+  # Start a cluster that restarts a node every 20 seconds, drops 10% of
+  # gossip packets and adds 50ms of latency to forwarded requests, and
+  # requires 99% of probe requests to succeed.
+  piko workloadv2 cluster \
+    --chaos.restart-interval 20s \
+    --chaos.gossip-packet-loss 0.1 \
+    --chaos.forward-latency 50ms \
+    --probe.interval 100ms \
+    --probe.min-availability 0.99
 `,
 	}
 
@@ -78,7 +98,7 @@ Examples:
 		}
 
 		var err error
-		logger, err = log.NewLogger(conf.Log.Level, conf.Log.Subsystems)
+		logger, err = log.NewLoggerFromConfig(conf.Log)
 		if err != nil {
 			fmt.Printf("failed to setup logger: %s\n", err.Error())
 			os.Exit(1)
@@ -120,6 +140,36 @@ func runCluster(
 
 	var group rungroup.Group
 
+	// Chaos.
+	chaosCtx, chaosCancel := context.WithCancel(context.Background())
+	group.Add(func() error {
+		manager.Run(chaosCtx)
+		return nil
+	}, func(error) {
+		chaosCancel()
+	})
+
+	// Probe.
+	var prober *probe.Prober
+	if conf.Probe.Interval != 0 {
+		prober = probe.NewProber(
+			"http://127.0.0.1:8000",
+			"http://127.0.0.1:8001",
+			conf.Probe.Interval,
+			logger,
+		)
+
+		probeCtx, probeCancel := context.WithCancel(context.Background())
+		group.Add(func() error {
+			if err := prober.Run(probeCtx); err != nil {
+				return fmt.Errorf("probe: %w", err)
+			}
+			return nil
+		}, func(error) {
+			probeCancel()
+		})
+	}
+
 	// Config reload.
 	hup := make(chan os.Signal, 1)
 	signal.Notify(hup, syscall.SIGHUP)
@@ -167,5 +217,22 @@ func runCluster(
 		return err
 	}
 
+	if prober != nil {
+		requests, failed := prober.Requests()
+		availability := prober.Availability()
+		logger.Info(
+			"probe availability",
+			zap.Int("requests", requests),
+			zap.Int("failed", failed),
+			zap.Float64("availability", availability),
+		)
+		if availability < conf.Probe.MinAvailability {
+			return fmt.Errorf(
+				"availability %f below minimum %f",
+				availability, conf.Probe.MinAvailability,
+			)
+		}
+	}
+
 	return nil
 }
@@ -102,6 +102,9 @@ type ConnectConfig struct {
 	// URL is the Piko server URL to connect to.
 	URL string
 
+	// Token is a proxy client token to authenticate with the Piko server.
+	Token string `json:"token" yaml:"token"`
+
 	// Timeout is the timeout attempting to connect to the Piko server.
 	Timeout time.Duration `json:"timeout" yaml:"timeout"`
 
@@ -131,6 +134,15 @@ The Piko server URL to connect to. Note this must be configured to use the
 Piko server 'proxy' port.`,
 	)
 
+	fs.StringVar(
+		&c.Token,
+		"connect.token",
+		c.Token,
+		`
+Token to authenticate with the Piko server, if the server requires proxy
+clients to authenticate. The token must permit the forwarded endpoints.`,
+	)
+
 	fs.DurationVar(
 		&c.Timeout,
 		"connect.timeout",
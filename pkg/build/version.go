@@ -1,4 +1,63 @@
 package build
 
+import (
+	"runtime"
+	"runtime/debug"
+)
+
 // Version contains the binary version. Set at build time.
 var Version = "unknown"
+
+// Commit contains the git commit the binary was built from. Set at build
+// time.
+//
+// If not set, the commit recorded by the Go toolchain is used when available.
+var Commit = ""
+
+// Date contains the time the binary was built, in RFC 3339 format. Set at
+// build time.
+//
+// If not set, the commit time recorded by the Go toolchain is used when
+// available.
+var Date = ""
+
+// Info contains metadata about the binary build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// GetInfo returns the metadata about the binary build.
+func GetInfo() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range buildInfo.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.Date == "" {
+					info.Date = setting.Value
+				}
+			}
+		}
+	}
+
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
@@ -0,0 +1,333 @@
+// Package compress compresses HTTP request and response bodies sent between
+// Piko nodes, and between Piko nodes and agents.
+//
+// Compression is applied using piko specific headers rather than
+// 'Content-Encoding', so it doesn't interfere with any encoding used by the
+// client or upstream.
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// EncodingHeader is the header containing the encoding of a compressed
+	// request or response body.
+	EncodingHeader = "x-piko-forward-encoding"
+
+	// AcceptEncodingHeader is the header containing the encoding the sender
+	// accepts for the response body.
+	AcceptEncodingHeader = "x-piko-forward-accept-encoding"
+
+	// TunnelAcceptEncodingHeader is the header an upstream sets when
+	// connecting with the encodings it accepts for requests to the upstream,
+	// in order of preference.
+	TunnelAcceptEncodingHeader = "x-piko-tunnel-accept-encoding"
+
+	// TunnelEncodingHeader is the header the server responds to an upstream
+	// connection with containing the negotiated encoding.
+	TunnelEncodingHeader = "x-piko-tunnel-encoding"
+)
+
+// Enabled returns whether the given encoding is enabled.
+func Enabled(encoding string) bool {
+	return encoding != "" && encoding != "off"
+}
+
+// Supported returns whether the given encoding is supported, either 'gzip'
+// or 'zstd'.
+func Supported(encoding string) bool {
+	return encoding == "gzip" || encoding == "zstd"
+}
+
+// Negotiate returns the first supported encoding in the comma separated list
+// of accepted encodings, or an empty string if none are supported.
+func Negotiate(accept string) string {
+	for _, encoding := range strings.Split(accept, ",") {
+		encoding = strings.TrimSpace(encoding)
+		if Supported(encoding) {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressedContentTypes are content types that are already compressed, so
+// aren't worth compressing again.
+var compressedContentTypes = map[string]struct{}{
+	"application/gzip":             {},
+	"application/x-gzip":           {},
+	"application/zip":              {},
+	"application/zstd":             {},
+	"application/x-7z-compressed":  {},
+	"application/x-bzip2":          {},
+	"application/x-rar-compressed": {},
+	"application/x-xz":             {},
+	"font/woff":                    {},
+	"font/woff2":                   {},
+}
+
+// IsCompressed returns whether a body with the given headers is already
+// compressed, either with a 'Content-Encoding' or a compressed content type
+// such as images, video and archives.
+func IsCompressed(h http.Header) bool {
+	if encoding := h.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return true
+	}
+
+	contentType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	if _, ok := compressedContentTypes[contentType]; ok {
+		return true
+	}
+	// SVG images are text so are worth compressing.
+	if strings.HasPrefix(contentType, "image/") {
+		return contentType != "image/svg+xml"
+	}
+	return strings.HasPrefix(contentType, "video/") ||
+		strings.HasPrefix(contentType, "audio/")
+}
+
+func NewWriter(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+}
+
+func NewReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+}
+
+// Transport compresses request bodies, and decompresses their responses.
+//
+// Bodies that are already compressed are sent as is.
+type Transport struct {
+	transport http.RoundTripper
+	encoding  string
+}
+
+func NewTransport(
+	transport http.RoundTripper,
+	encoding string,
+) *Transport {
+	return &Transport{
+		transport: transport,
+		encoding:  encoding,
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Upgraded connections aren't compressed.
+	if req.Header.Get("Upgrade") != "" {
+		return t.transport.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set(AcceptEncodingHeader, t.encoding)
+
+	// Request bodies with 'Expect: 100-continue' aren't compressed, since
+	// compressing would read the body before the upstream responds with
+	// '100 Continue'.
+	if req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0 &&
+		!IsCompressed(req.Header) && !expectContinue(req.Header) {
+		body := req.Body
+		pr, pw := io.Pipe()
+		go func() {
+			defer body.Close()
+
+			w, err := NewWriter(t.encoding, pw)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(w, body); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			pw.CloseWithError(w.Close())
+		}()
+
+		req.Body = pr
+		req.ContentLength = -1
+		req.Header.Del("Content-Length")
+		req.Header.Set(EncodingHeader, t.encoding)
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := resp.Header.Get(EncodingHeader)
+	if encoding == "" {
+		return resp, nil
+	}
+
+	body, err := NewReader(encoding, resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("decompress response: %w", err)
+	}
+	resp.Body = &decompressBody{
+		ReadCloser: body,
+		body:       resp.Body,
+	}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	resp.Header.Del(EncodingHeader)
+	return resp, nil
+}
+
+func expectContinue(h http.Header) bool {
+	return strings.EqualFold(h.Get("Expect"), "100-continue")
+}
+
+// DecompressRequest decompresses the body of a request sent by a Transport,
+// and compresses the response if the sender accepts compressed responses.
+//
+// The returned function must be called once the response has been written.
+func DecompressRequest(
+	w http.ResponseWriter,
+	r *http.Request,
+) (http.ResponseWriter, *http.Request, func(), error) {
+	if encoding := r.Header.Get(EncodingHeader); encoding != "" {
+		body, err := NewReader(encoding, r.Body)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("decompress request: %w", err)
+		}
+		r.Body = &decompressBody{
+			ReadCloser: body,
+			body:       r.Body,
+		}
+		r.ContentLength = -1
+		r.Header.Del("Content-Length")
+		r.Header.Del(EncodingHeader)
+	}
+
+	encoding := r.Header.Get(AcceptEncodingHeader)
+	r.Header.Del(AcceptEncodingHeader)
+	if encoding == "" || r.Method == http.MethodHead {
+		return w, r, func() {}, nil
+	}
+
+	cw := &compressResponseWriter{
+		ResponseWriter: w,
+		encoding:       encoding,
+	}
+	return cw, r, cw.close, nil
+}
+
+// decompressBody closes both the decompressed reader and underlying body.
+type decompressBody struct {
+	io.ReadCloser
+
+	body io.ReadCloser
+}
+
+func (b *decompressBody) Close() error {
+	b.ReadCloser.Close()
+	return b.body.Close()
+}
+
+// compressResponseWriter compresses the response body written to the
+// underlying response writer.
+type compressResponseWriter struct {
+	http.ResponseWriter
+
+	encoding string
+
+	// w is the compressed writer, which is nil if the response is not
+	// compressed.
+	w io.WriteCloser
+
+	wroteHeader bool
+}
+
+func (w *compressResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	// Informational responses are passed through.
+	if statusCode < http.StatusOK {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	w.wroteHeader = true
+
+	// Responses that can't have a body, or are already compressed, aren't
+	// compressed.
+	if statusCode != http.StatusNoContent &&
+		statusCode != http.StatusNotModified &&
+		!IsCompressed(w.Header()) {
+		cw, err := NewWriter(w.encoding, w.ResponseWriter)
+		if err == nil {
+			w.w = cw
+			w.Header().Del("Content-Length")
+			w.Header().Set(EncodingHeader, w.encoding)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.w == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.w.Write(b)
+}
+
+// Flush flushes any buffered compressed data, so streamed responses aren't
+// delayed by compression.
+func (w *compressResponseWriter) Flush() {
+	if f, ok := w.w.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying response writer, so the response can be
+// controlled using an http.ResponseController.
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressResponseWriter) close() {
+	if w.w != nil {
+		_ = w.w.Close()
+	}
+}
+
+var _ http.RoundTripper = &Transport{}
+var _ http.ResponseWriter = &compressResponseWriter{}
+var _ http.Flusher = &compressResponseWriter{}
@@ -0,0 +1,100 @@
+package compress
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	assert.Equal(t, "zstd", Negotiate("zstd"))
+	assert.Equal(t, "gzip", Negotiate("br, gzip, zstd"))
+	assert.Equal(t, "", Negotiate("br"))
+	assert.Equal(t, "", Negotiate(""))
+}
+
+func TestIsCompressed(t *testing.T) {
+	tests := []struct {
+		header     http.Header
+		compressed bool
+	}{
+		{header: http.Header{}, compressed: false},
+		{header: http.Header{"Content-Type": {"application/json"}}, compressed: false},
+		{header: http.Header{"Content-Type": {"text/html; charset=utf-8"}}, compressed: false},
+		{header: http.Header{"Content-Type": {"image/svg+xml"}}, compressed: false},
+		{header: http.Header{"Content-Encoding": {"identity"}}, compressed: false},
+		{header: http.Header{"Content-Encoding": {"gzip"}}, compressed: true},
+		{header: http.Header{"Content-Type": {"image/png"}}, compressed: true},
+		{header: http.Header{"Content-Type": {"video/mp4"}}, compressed: true},
+		{header: http.Header{"Content-Type": {"application/zip"}}, compressed: true},
+		{header: http.Header{"Content-Type": {"font/woff2"}}, compressed: true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.compressed, IsCompressed(tt.header), tt.header)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	for _, encoding := range []string{"gzip", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			var contentType string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w, r, finish, err := DecompressRequest(w, r)
+				require.NoError(t, err)
+				defer finish()
+
+				assert.Empty(t, r.Header.Get(EncodingHeader))
+				assert.Empty(t, r.Header.Get(AcceptEncodingHeader))
+
+				b, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				assert.Equal(t, strings.Repeat("foo", 1000), string(b))
+
+				w.Header().Set("Content-Type", contentType)
+				_, _ = w.Write([]byte(strings.Repeat("bar", 1000)))
+			})
+
+			transport := NewTransport(roundTripperFunc(
+				func(r *http.Request) (*http.Response, error) {
+					assert.Equal(t, encoding, r.Header.Get(EncodingHeader))
+
+					w := httptest.NewRecorder()
+					handler.ServeHTTP(w, r)
+					resp := w.Result()
+					if IsCompressed(resp.Header) {
+						// Compressed responses are sent as is.
+						assert.Empty(t, resp.Header.Get(EncodingHeader))
+					} else {
+						assert.Equal(t, encoding, resp.Header.Get(EncodingHeader))
+					}
+					return resp, nil
+				},
+			), encoding)
+
+			for _, contentType = range []string{"application/json", "image/png"} {
+				r := httptest.NewRequest(
+					http.MethodPost, "/", strings.NewReader(strings.Repeat("foo", 1000)),
+				)
+				resp, err := transport.RoundTrip(r)
+				require.NoError(t, err)
+
+				b, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				resp.Body.Close()
+				assert.Equal(t, strings.Repeat("bar", 1000), string(b))
+				assert.Empty(t, resp.Header.Get(EncodingHeader))
+			}
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -2,8 +2,12 @@ package config
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 
 	"github.com/spf13/pflag"
@@ -12,10 +16,17 @@ import (
 
 type Config struct {
 	Path      string `json:"path" yaml:"path"`
+	Dir       string `json:"dir" yaml:"dir"`
 	ExpandEnv bool   `json:"expand_env" yaml:"expand_env"`
+
+	// flags is the flag set the config was registered with. Flags set on the
+	// command line override values in the config file.
+	flags *pflag.FlagSet
 }
 
 func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
+	c.flags = fs
+
 	fs.StringVar(
 		&c.Path,
 		"config.path",
@@ -24,6 +35,22 @@ func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
 YAML config file path.`,
 	)
 
+	fs.StringVar(
+		&c.Dir,
+		"config.dir",
+		"",
+		`
+Directory containing additional YAML config files, such as './conf.d'.
+
+Each '.yaml' or '.yml' file in the directory is loaded in lexical order, and
+merged with the config file at '--config.path' (if configured) and any
+previous files. Such as to split the configuration across files managed by
+different teams.
+
+When merging, objects are merged recursively, lists are appended, and any
+other value replaces the value from previous files.`,
+	)
+
 	fs.BoolVar(
 		&c.ExpandEnv,
 		"config.expand-env",
@@ -39,15 +66,118 @@ default value can be given using form ${VAR:default}.`,
 	)
 }
 
-// Load load the YAML configuration from the file at the given path.
+// Load load the YAML configuration from the file at the given path and any
+// files in the config directory, and configuration from environment
+// variables.
+//
+// Each flag can be set using an environment variable named after the flag,
+// such as 'PIKO_PROXY_BIND_ADDR' for '--proxy.bind-addr'. Flags take
+// precedence over environment variables, which take precedence over the
+// file.
+//
+// Unknown fields in the file are rejected.
 func (c *Config) Load(conf interface{}) error {
-	if c.Path == "" {
+	// Load the config file flags from the environment first, since they
+	// configure the file to load.
+	if err := c.loadEnv(true); err != nil {
+		return err
+	}
+
+	// Since the flags have already been parsed, record the values of any
+	// flags that were set to re-apply once the file is loaded.
+	overrides := c.flagOverrides()
+
+	if err := c.loadFile(conf); err != nil {
+		return err
+	}
+
+	if err := c.loadEnv(false); err != nil {
+		return err
+	}
+
+	for _, override := range overrides {
+		if err := override(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) loadFile(conf interface{}) error {
+	paths, err := c.paths()
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	var merged *yaml.Node
+	for _, path := range paths {
+		node, err := c.parseFile(path, conf)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			continue
+		}
+		merged = mergeNodes(merged, node)
+	}
+	if merged == nil {
 		return nil
 	}
 
-	buf, err := os.ReadFile(c.Path)
+	buf, err := yaml.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("merge config: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	if err := dec.Decode(conf); err != nil {
+		return fmt.Errorf("parse config: %w", err)
+	}
+
+	return nil
+}
+
+// paths returns the paths of the config files to load in order.
+func (c *Config) paths() ([]string, error) {
+	var paths []string
+	if c.Path != "" {
+		paths = append(paths, c.Path)
+	}
+	if c.Dir == "" {
+		return paths, nil
+	}
+
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %s: %w", c.Dir, err)
+	}
+	// Note entries are sorted by name.
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		paths = append(paths, filepath.Join(c.Dir, entry.Name()))
+	}
+	return paths, nil
+}
+
+// parseFile parses the YAML config file at the given path, or returns nil if
+// the file is empty.
+//
+// The file is also decoded into a new value of the same type as conf, to
+// reject unknown fields with an error that includes the file path.
+func (c *Config) parseFile(path string, conf interface{}) (*yaml.Node, error) {
+	buf, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("read file: %s: %w", c.Path, err)
+		return nil, fmt.Errorf("read file: %s: %w", path, err)
 	}
 
 	if c.ExpandEnv {
@@ -56,12 +186,175 @@ func (c *Config) Load(conf interface{}) error {
 
 	dec := yaml.NewDecoder(bytes.NewReader(buf))
 	dec.KnownFields(true)
+	if err := dec.Decode(reflect.New(reflect.TypeOf(conf).Elem()).Interface()); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("parse config: %s: %w", path, err)
+	}
 
-	if err := dec.Decode(conf); err != nil {
-		return fmt.Errorf("parse config: %s: %w", c.Path, err)
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("parse config: %s: %w", path, err)
 	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	return doc.Content[0], nil
+}
 
-	return nil
+// mergeNodes merges the YAML node src into dst and returns the merged node.
+//
+// Mappings are merged recursively, sequences are appended, and any other
+// value in src replaces the value in dst.
+func mergeNodes(dst *yaml.Node, src *yaml.Node) *yaml.Node {
+	if dst == nil || dst.Kind != src.Kind {
+		return src
+	}
+
+	switch src.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			key, value := src.Content[i], src.Content[i+1]
+
+			merged := false
+			for j := 0; j+1 < len(dst.Content); j += 2 {
+				if dst.Content[j].Value == key.Value {
+					dst.Content[j+1] = mergeNodes(dst.Content[j+1], value)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				dst.Content = append(dst.Content, key, value)
+			}
+		}
+		return dst
+	case yaml.SequenceNode:
+		dst.Content = append(dst.Content, src.Content...)
+		return dst
+	default:
+		return src
+	}
+}
+
+// loadEnv sets any flags not set on the command line from their environment
+// variable. If configFlags is true only the config file flags are loaded,
+// otherwise all other flags are loaded.
+func (c *Config) loadEnv(configFlags bool) error {
+	if c.flags == nil {
+		return nil
+	}
+
+	var err error
+	c.flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || isConfigFlag(f.Name) != configFlags {
+			return
+		}
+
+		name := EnvName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("env: %s: %w", name, setErr)
+		}
+	})
+	return err
+}
+
+// flagOverrides returns functions to re-apply the current values of the flags
+// set on the command line.
+func (c *Config) flagOverrides() []func() error {
+	if c.flags == nil {
+		return nil
+	}
+
+	var overrides []func() error
+	// Note uses VisitAll rather than Visit, since if the flags are
+	// persistent flags they may have been parsed by a different flag set.
+	c.flags.VisitAll(func(f *pflag.Flag) {
+		if !f.Changed || isConfigFlag(f.Name) {
+			return
+		}
+
+		set := flagSetter(f.Value)
+		overrides = append(overrides, func() error {
+			if err := set(f.Value); err != nil {
+				return fmt.Errorf("flag: %s: %w", f.Name, err)
+			}
+			return nil
+		})
+	})
+	return overrides
+}
+
+// CopyFlags sets the flags in dst to the values of the flags set in src.
+//
+// Such as to load a new configuration using the same command line flags, by
+// registering the new configuration flags with dst.
+func CopyFlags(dst *pflag.FlagSet, src *pflag.FlagSet) error {
+	var err error
+	src.VisitAll(func(f *pflag.Flag) {
+		if err != nil || !f.Changed {
+			return
+		}
+		dstFlag := dst.Lookup(f.Name)
+		if dstFlag == nil {
+			return
+		}
+		if setErr := flagSetter(f.Value)(dstFlag.Value); setErr != nil {
+			err = fmt.Errorf("flag: %s: %w", f.Name, setErr)
+			return
+		}
+		dstFlag.Changed = true
+	})
+	return err
+}
+
+// flagSetter returns a function that sets a flag value to the current value
+// of the given flag.
+func flagSetter(value pflag.Value) func(dst pflag.Value) error {
+	if sv, ok := value.(pflag.SliceValue); ok {
+		values := append([]string(nil), sv.GetSlice()...)
+		return func(dst pflag.Value) error {
+			dstSV, ok := dst.(pflag.SliceValue)
+			if !ok {
+				return fmt.Errorf("not a slice")
+			}
+			return dstSV.Replace(values)
+		}
+	}
+
+	s := value.String()
+	if value.Type() == "stringToString" {
+		// Maps are formatted as '[a=1,b=2]' though parsed as 'a=1,b=2'.
+		// Note if the destination flag has already been set, the values are
+		// added to its existing values.
+		s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+		if s == "" {
+			return func(pflag.Value) error {
+				return nil
+			}
+		}
+	}
+	return func(dst pflag.Value) error {
+		return dst.Set(s)
+	}
+}
+
+// EnvName returns the name of the environment variable for the given flag,
+// such as 'PIKO_PROXY_BIND_ADDR' for 'proxy.bind-addr'.
+func EnvName(flag string) string {
+	name := strings.NewReplacer(".", "_", "-", "_").Replace(flag)
+	return "PIKO_" + strings.ToUpper(name)
+}
+
+// isConfigFlag returns whether the flag configures loading the config file,
+// rather than the loaded config.
+func isConfigFlag(name string) bool {
+	return strings.HasPrefix(name, "config.")
 }
 
 // expandEnv replaces ${VAR} or $VAR in the given string with the corresponding
@@ -82,3 +375,25 @@ func expandEnv(s string) string {
 		return env
 	})
 }
+
+// LoadSecretFile sets value to the contents of the file at the given path,
+// such as to load a secret from a file rather than passing it as a flag. Any
+// leading or trailing whitespace, such as a trailing newline, is removed.
+//
+// If path is empty value is unchanged. Returns an error if both value and
+// path are set.
+func LoadSecretFile(value *string, path string) error {
+	if path == "" {
+		return nil
+	}
+	if *value != "" {
+		return fmt.Errorf("cannot set both value and file")
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+	*value = strings.TrimSpace(string(b))
+	return nil
+}
@@ -2,21 +2,61 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
 )
 
 type fakeConfig struct {
-	Foo string        `yaml:"foo"`
-	Bar string        `yaml:"bar"`
-	Sub fakeSubConfig `yaml:"sub"`
+	Foo  string            `yaml:"foo"`
+	Bar  string            `yaml:"bar"`
+	List []string          `yaml:"list"`
+	Map  map[string]string `yaml:"map"`
+	Sub  fakeSubConfig     `yaml:"sub"`
 }
 
 type fakeSubConfig struct {
 	Car int `yaml:"car"`
 }
 
+func TestCopyFlags(t *testing.T) {
+	var srcConf fakeConfig
+	src := pflag.NewFlagSet("src", pflag.ContinueOnError)
+	src.StringVar(&srcConf.Foo, "foo", "", "")
+	src.StringVar(&srcConf.Bar, "bar", "", "")
+	src.StringSliceVar(&srcConf.List, "list", nil, "")
+	src.StringToStringVar(&srcConf.Map, "map", nil, "")
+	assert.NoError(t, src.Parse([]string{
+		"--foo", "val1", "--list", "a,b", "--map", "a=1,b=2",
+	}))
+
+	dstConf := fakeConfig{
+		Bar: "default",
+	}
+	dst := pflag.NewFlagSet("dst", pflag.ContinueOnError)
+	dst.StringVar(&dstConf.Foo, "foo", dstConf.Foo, "")
+	dst.StringVar(&dstConf.Bar, "bar", dstConf.Bar, "")
+	dst.StringSliceVar(&dstConf.List, "list", nil, "")
+	dst.StringToStringVar(&dstConf.Map, "map", nil, "")
+
+	assert.NoError(t, CopyFlags(dst, src))
+
+	assert.Equal(t, "val1", dstConf.Foo)
+	// Flags that weren't set aren't copied.
+	assert.Equal(t, "default", dstConf.Bar)
+	assert.Equal(t, []string{"a", "b"}, dstConf.List)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, dstConf.Map)
+	assert.True(t, dst.Lookup("foo").Changed)
+	assert.False(t, dst.Lookup("bar").Changed)
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "PIKO_PROXY_BIND_ADDR", EnvName("proxy.bind-addr"))
+	assert.Equal(t, "PIKO_LOG_FILE_MAX_SIZE", EnvName("log.file.max-size"))
+}
+
 func TestLoad(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		f, err := os.CreateTemp("", "piko")
@@ -67,6 +107,123 @@ sub:
 		assert.Equal(t, 5, conf.Sub.Car)
 	})
 
+	t.Run("flag overrides", func(t *testing.T) {
+		f, err := os.CreateTemp("", "piko")
+		assert.NoError(t, err)
+
+		_, err = f.WriteString(`foo: val1
+bar: val2
+list: [a, b]
+map:
+  a: "1"
+sub:
+  car: 5`)
+		assert.NoError(t, err)
+
+		var conf fakeConfig
+
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.StringVar(&conf.Foo, "foo", "", "")
+		fs.StringVar(&conf.Bar, "bar", "", "")
+		fs.StringSliceVar(&conf.List, "list", nil, "")
+		fs.StringToStringVar(&conf.Map, "map", nil, "")
+		fs.IntVar(&conf.Sub.Car, "sub.car", 0, "")
+
+		var loadConfig Config
+		loadConfig.RegisterFlags(fs)
+
+		assert.NoError(t, fs.Parse([]string{
+			"--config.path", f.Name(),
+			"--foo", "flag1",
+			"--list", "c",
+			"--map", "b=2",
+			"--sub.car", "10",
+		}))
+
+		assert.NoError(t, loadConfig.Load(&conf))
+
+		// Flags override the file.
+		assert.Equal(t, "flag1", conf.Foo)
+		assert.Equal(t, []string{"c"}, conf.List)
+		assert.Equal(t, map[string]string{"a": "1", "b": "2"}, conf.Map)
+		assert.Equal(t, 10, conf.Sub.Car)
+		// Values not set by flags are loaded from the file.
+		assert.Equal(t, "val2", conf.Bar)
+	})
+
+	t.Run("env", func(t *testing.T) {
+		f, err := os.CreateTemp("", "piko")
+		assert.NoError(t, err)
+
+		_, err = f.WriteString(`foo: val1
+bar: val2
+sub:
+  car: 5`)
+		assert.NoError(t, err)
+
+		t.Setenv("PIKO_CONFIG_PATH", f.Name())
+		t.Setenv("PIKO_FOO", "env1")
+		t.Setenv("PIKO_BAR", "env2")
+		t.Setenv("PIKO_LIST", "a,b")
+		t.Setenv("PIKO_SUB_CAR", "10")
+
+		var conf fakeConfig
+
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.StringVar(&conf.Foo, "foo", "", "")
+		fs.StringVar(&conf.Bar, "bar", "", "")
+		fs.StringSliceVar(&conf.List, "list", nil, "")
+		fs.IntVar(&conf.Sub.Car, "sub.car", 0, "")
+
+		var loadConfig Config
+		loadConfig.RegisterFlags(fs)
+
+		assert.NoError(t, fs.Parse([]string{
+			"--foo", "flag1",
+		}))
+
+		assert.NoError(t, loadConfig.Load(&conf))
+
+		// Flags override environment variables.
+		assert.Equal(t, "flag1", conf.Foo)
+		// Environment variables override the file.
+		assert.Equal(t, "env2", conf.Bar)
+		assert.Equal(t, []string{"a", "b"}, conf.List)
+		assert.Equal(t, 10, conf.Sub.Car)
+	})
+
+	t.Run("invalid env", func(t *testing.T) {
+		t.Setenv("PIKO_SUB_CAR", "foo")
+
+		var conf fakeConfig
+
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.IntVar(&conf.Sub.Car, "sub.car", 0, "")
+
+		var loadConfig Config
+		loadConfig.RegisterFlags(fs)
+		assert.NoError(t, fs.Parse(nil))
+
+		assert.Error(t, loadConfig.Load(&conf))
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		f, err := os.CreateTemp("", "piko")
+		assert.NoError(t, err)
+
+		_, err = f.WriteString(`foo: val1
+unknown: val2`)
+		assert.NoError(t, err)
+
+		var conf fakeConfig
+
+		loadConfig := &Config{
+			Path:      f.Name(),
+			ExpandEnv: false,
+		}
+		assert.Error(t, loadConfig.Load(&conf))
+	})
+
 	t.Run("invalid yaml", func(t *testing.T) {
 		f, err := os.CreateTemp("", "piko")
 		assert.NoError(t, err)
@@ -91,4 +248,99 @@ sub:
 		}
 		assert.Error(t, loadConfig.Load(&conf))
 	})
+
+	t.Run("dir", func(t *testing.T) {
+		f, err := os.CreateTemp("", "piko")
+		assert.NoError(t, err)
+
+		_, err = f.WriteString(`foo: val1
+list: [a]
+map:
+  a: "1"`)
+		assert.NoError(t, err)
+
+		dir := t.TempDir()
+		// Files are loaded in lexical order.
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "20-b.yml"), []byte(`foo: val3
+list: [c]`), 0o600))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "10-a.yaml"), []byte(`foo: val2
+bar: val2
+list: [b]
+map:
+  b: "2"
+sub:
+  car: 5`), 0o600))
+		// Empty files and non-YAML files are ignored.
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "30-empty.yaml"), nil, 0o600))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("readme"), 0o600))
+
+		var conf fakeConfig
+
+		loadConfig := &Config{
+			Path: f.Name(),
+			Dir:  dir,
+		}
+		assert.NoError(t, loadConfig.Load(&conf))
+
+		assert.Equal(t, "val3", conf.Foo)
+		assert.Equal(t, "val2", conf.Bar)
+		assert.Equal(t, []string{"a", "b", "c"}, conf.List)
+		assert.Equal(t, map[string]string{"a": "1", "b": "2"}, conf.Map)
+		assert.Equal(t, 5, conf.Sub.Car)
+	})
+
+	t.Run("dir unknown field", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "a.yaml")
+		assert.NoError(t, os.WriteFile(path, []byte(`unknown: val1`), 0o600))
+
+		var conf fakeConfig
+
+		loadConfig := &Config{
+			Dir: dir,
+		}
+		err := loadConfig.Load(&conf)
+		assert.ErrorContains(t, err, path)
+	})
+
+	t.Run("dir not found", func(t *testing.T) {
+		var conf fakeConfig
+		loadConfig := &Config{
+			Dir: "/a/b/c/notfound",
+		}
+		assert.Error(t, loadConfig.Load(&conf))
+	})
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		f, err := os.CreateTemp("", "piko")
+		assert.NoError(t, err)
+		_, err = f.WriteString("my-secret\n")
+		assert.NoError(t, err)
+
+		var secret string
+		assert.NoError(t, LoadSecretFile(&secret, f.Name()))
+		// The trailing newline is removed.
+		assert.Equal(t, "my-secret", secret)
+	})
+
+	t.Run("no file", func(t *testing.T) {
+		secret := "my-secret"
+		assert.NoError(t, LoadSecretFile(&secret, ""))
+		assert.Equal(t, "my-secret", secret)
+	})
+
+	t.Run("value and file", func(t *testing.T) {
+		f, err := os.CreateTemp("", "piko")
+		assert.NoError(t, err)
+
+		secret := "my-secret"
+		assert.Error(t, LoadSecretFile(&secret, f.Name()))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		var secret string
+		assert.Error(t, LoadSecretFile(&secret, "/a/b/c/notfound"))
+	})
 }
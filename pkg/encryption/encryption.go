@@ -0,0 +1,112 @@
+// Package encryption encrypts state persisted to disk using AES-256-GCM.
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// KeySize is the size of the encryption key in bytes.
+	KeySize = 32
+)
+
+// prefix identifies encrypted data, so encrypted and plaintext files can be
+// distinguished.
+var prefix = []byte("piko-encrypted:v1:")
+
+var (
+	ErrDecrypt = errors.New("decrypt failed")
+)
+
+// LoadKeyFile loads a hex encoded 32 byte key from the file at the given
+// path, such as generated with 'openssl rand -hex 32'.
+func LoadKeyFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+	return key, nil
+}
+
+// IsEncrypted returns whether the data was encrypted with Encrypt.
+func IsEncrypted(b []byte) bool {
+	return bytes.HasPrefix(b, prefix)
+}
+
+// Encrypt encrypts the plaintext with the given key.
+//
+// The output is the prefix followed by the base64 encoded nonce and
+// ciphertext, so it can be safely written to text files.
+func Encrypt(key []byte, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+
+	encoded := make([]byte, len(prefix)+base64.StdEncoding.EncodedLen(len(sealed)))
+	copy(encoded, prefix)
+	base64.StdEncoding.Encode(encoded[len(prefix):], sealed)
+	return encoded, nil
+}
+
+// Decrypt decrypts data encrypted with Encrypt using the given key.
+func Decrypt(key []byte, b []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsEncrypted(b) {
+		return nil, fmt.Errorf("%w: not encrypted", ErrDecrypt)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(
+		strings.TrimSpace(string(b[len(prefix):])),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecrypt, err.Error())
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: too short", ErrDecrypt)
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		// Either the key is wrong or the data was modified.
+		return nil, fmt.Errorf("%w: %s", ErrDecrypt, err.Error())
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,91 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, KeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func TestEncrypt(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		key := testKey(t)
+
+		encrypted, err := Encrypt(key, []byte("my-node"))
+		require.NoError(t, err)
+		assert.True(t, IsEncrypted(encrypted))
+		assert.NotContains(t, string(encrypted), "my-node")
+
+		plaintext, err := Decrypt(key, encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, "my-node", string(plaintext))
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		encrypted, err := Encrypt(testKey(t), []byte("my-node"))
+		require.NoError(t, err)
+
+		_, err = Decrypt(testKey(t), encrypted)
+		assert.ErrorIs(t, err, ErrDecrypt)
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		key := testKey(t)
+
+		encrypted, err := Encrypt(key, []byte("my-node"))
+		require.NoError(t, err)
+		// Modify the last base64 character before the padding.
+		encrypted[len(encrypted)-3] ^= 1
+
+		_, err = Decrypt(key, encrypted)
+		assert.ErrorIs(t, err, ErrDecrypt)
+	})
+
+	t.Run("not encrypted", func(t *testing.T) {
+		assert.False(t, IsEncrypted([]byte("my-node")))
+
+		_, err := Decrypt(testKey(t), []byte("my-node"))
+		assert.ErrorIs(t, err, ErrDecrypt)
+	})
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key")
+		require.NoError(t, os.WriteFile(
+			path,
+			[]byte("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n"),
+			0o600,
+		))
+
+		key, err := LoadKeyFile(path)
+		require.NoError(t, err)
+		assert.Len(t, key, KeySize)
+	})
+
+	t.Run("invalid size", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key")
+		require.NoError(t, os.WriteFile(path, []byte("0001"), 0o600))
+
+		_, err := LoadKeyFile(path)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid encoding", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key")
+		require.NoError(t, os.WriteFile(path, []byte("not-hex"), 0o600))
+
+		_, err := LoadKeyFile(path)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,75 @@
+package errorreport
+
+import (
+	"fmt"
+
+	pikoconfig "github.com/andydunstall/piko/pkg/config"
+	"github.com/getsentry/sentry-go"
+	"github.com/spf13/pflag"
+)
+
+type Config struct {
+	// DSN is the Sentry (or Sentry compatible) DSN to report errors to. If
+	// empty error reporting is disabled.
+	DSN string `json:"dsn" yaml:"dsn"`
+
+	// DSNFile is the path of a file containing the DSN.
+	DSNFile string `json:"dsn_file" yaml:"dsn_file"`
+
+	// Environment is the environment to tag reported errors with, such as
+	// 'production'.
+	Environment string `json:"environment" yaml:"environment"`
+}
+
+func (c *Config) Enabled() bool {
+	return c.DSN != ""
+}
+
+// LoadSecrets loads the DSN from a file if configured.
+func (c *Config) LoadSecrets() error {
+	if err := pikoconfig.LoadSecretFile(&c.DSN, c.DSNFile); err != nil {
+		return fmt.Errorf("dsn: %w", err)
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if _, err := sentry.NewDsn(c.DSN); err != nil {
+		return fmt.Errorf("invalid dsn: %w", err)
+	}
+	return nil
+}
+
+func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&c.DSN,
+		"error-reporting.dsn",
+		c.DSN,
+		`
+Sentry DSN to report errors to, such as
+'https://public@sentry.example.com/1'. Any Sentry compatible service can be
+used.
+
+When configured, error logs and panics are reported to Sentry.
+
+If not set error reporting is disabled.`,
+	)
+	fs.StringVar(
+		&c.DSNFile,
+		"error-reporting.dsn-file",
+		c.DSNFile,
+		`
+Path of a file containing the Sentry DSN to report errors to, so the DSN
+isn't passed as a flag or environment variable.`,
+	)
+	fs.StringVar(
+		&c.Environment,
+		"error-reporting.environment",
+		c.Environment,
+		`
+Environment to tag reported errors with, such as 'production'.`,
+	)
+}
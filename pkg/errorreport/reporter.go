@@ -0,0 +1,82 @@
+// Package errorreport reports error logs and panics to Sentry, or any Sentry
+// compatible service.
+package errorreport
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap/zapcore"
+)
+
+// Reporter reports error logs to Sentry.
+//
+// Reports are sent asynchronously, so Flush must be called before the
+// process exits to avoid losing reports.
+type Reporter struct {
+	hub *sentry.Hub
+}
+
+// NewReporter creates a reporter using the given configuration. release is
+// the version of the service.
+func NewReporter(conf Config, release string) (*Reporter, error) {
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:         conf.DSN,
+		Environment: conf.Environment,
+		Release:     release,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sentry client: %w", err)
+	}
+	return &Reporter{
+		hub: sentry.NewHub(client, sentry.NewScope()),
+	}, nil
+}
+
+// Report reports the given error log record.
+func (r *Reporter) Report(entry zapcore.Entry, fields map[string]any) {
+	event := sentry.NewEvent()
+	event.Level = sentryLevel(entry.Level)
+	event.Message = entry.Message
+	event.Logger = entry.LoggerName
+	event.Timestamp = entry.Time
+	event.Tags["subsystem"] = entry.LoggerName
+	for k, v := range fields {
+		event.Extra[k] = v
+	}
+	r.hub.CaptureEvent(event)
+}
+
+// RecoverPanic reports a panic to Sentry, then continues panicking. This
+// must be called using defer.
+func (r *Reporter) RecoverPanic(timeout time.Duration) {
+	if err := recover(); err != nil {
+		r.hub.Recover(err)
+		r.hub.Flush(timeout)
+		panic(err)
+	}
+}
+
+// Flush waits for any pending reports to be sent, up to the given timeout.
+func (r *Reporter) Flush(timeout time.Duration) {
+	r.hub.Flush(timeout)
+}
+
+func sentryLevel(lvl zapcore.Level) sentry.Level {
+	switch lvl {
+	case zapcore.DebugLevel:
+		return sentry.LevelDebug
+	case zapcore.InfoLevel:
+		return sentry.LevelInfo
+	case zapcore.WarnLevel:
+		return sentry.LevelWarning
+	case zapcore.ErrorLevel:
+		return sentry.LevelError
+	default:
+		return sentry.LevelFatal
+	}
+}
+
+var _ log.Reporter = &Reporter{}
@@ -0,0 +1,326 @@
+package gossip
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// authTagSize is the size of the HMAC tag added to authenticated
+	// messages.
+	authTagSize = sha256.Size
+
+	// maxAuthFailures is the maximum number of recent authentication
+	// failures to record.
+	maxAuthFailures = 10
+
+	// streamNonceSize is the size of the random nonce sent with each
+	// authenticated stream request.
+	streamNonceSize = 16
+
+	// maxStreamPayloadSize is the maximum size of an authenticated stream
+	// message payload.
+	maxStreamPayloadSize = 64 << 20
+)
+
+var errUnauthenticated = errors.New("unauthenticated")
+
+// AuthFailure describes a rejected message from an unauthenticated node.
+type AuthFailure struct {
+	// Addr is the remote address of the rejected message.
+	Addr string `json:"addr"`
+
+	// Transport is the transport the message was received on ('stream' or
+	// 'packet').
+	Transport string `json:"transport"`
+
+	// Time is the time the message was rejected.
+	Time time.Time `json:"time"`
+}
+
+// authenticator authenticates gossip messages using a shared join token.
+//
+// Packets are authenticated by appending a HMAC-SHA256 tag of the packet
+// contents.
+//
+// Stream messages are sent as a length prefixed payload followed by a
+// HMAC-SHA256 tag of the message type, version, direction and payload. Each
+// request includes a random nonce, which is covered by the tags of both the
+// request and response, so a response can't be replayed to another request.
+// Since the payload is authenticated, a replayed request only contains state
+// the receiver has already seen from a node in the cluster.
+//
+// If no token is configured, messages are not authenticated.
+type authenticator struct {
+	key []byte
+
+	// failures contains the most recent authentication failures.
+	failures []AuthFailure
+
+	// mu protects failures.
+	mu sync.Mutex
+
+	metrics *Metrics
+}
+
+func newAuthenticator(token string, metrics *Metrics) *authenticator {
+	var key []byte
+	if token != "" {
+		key = []byte(token)
+	}
+	return &authenticator{
+		key:     key,
+		metrics: metrics,
+	}
+}
+
+// Enabled returns whether messages are authenticated.
+func (a *authenticator) Enabled() bool {
+	return a.key != nil
+}
+
+// Overhead returns the number of bytes added to each packet.
+func (a *authenticator) Overhead() int {
+	if !a.Enabled() {
+		return 0
+	}
+	return authTagSize
+}
+
+// SignPacket returns the given packet with an authentication tag appended.
+func (a *authenticator) SignPacket(b []byte) []byte {
+	if !a.Enabled() {
+		return b
+	}
+	return append(b, a.tag(b)...)
+}
+
+// VerifyPacket verifies the authentication tag of the given packet and
+// returns the packet with the tag removed.
+func (a *authenticator) VerifyPacket(b []byte) ([]byte, error) {
+	if !a.Enabled() {
+		return b, nil
+	}
+
+	if len(b) < authTagSize {
+		return nil, errUnauthenticated
+	}
+	payload := b[:len(b)-authTagSize]
+	if !hmac.Equal(b[len(b)-authTagSize:], a.tag(payload)) {
+		return nil, errUnauthenticated
+	}
+	return payload, nil
+}
+
+// WriteStreamRequest writes a stream request of the given type with the
+// given payload, and returns the request nonce used to verify the response.
+//
+// If authentication is disabled, the payload is written as is.
+func (a *authenticator) WriteStreamRequest(
+	w io.Writer,
+	messageType messageType,
+	payload []byte,
+) ([]byte, error) {
+	if !a.Enabled() {
+		if _, err := w.Write(payload); err != nil {
+			return nil, fmt.Errorf("write: %w", err)
+		}
+		return nil, nil
+	}
+
+	nonce := make([]byte, streamNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	if _, err := w.Write(nonce); err != nil {
+		return nil, fmt.Errorf("write: %w", err)
+	}
+	if err := a.writeStreamPayload(w, messageType, false, nonce, payload); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// ReadStreamRequest reads and verifies a stream request of the given type.
+// Returns a reader for the request payload, and the request nonce used to
+// write the response.
+//
+// If authentication is disabled, returns r to read the payload.
+func (a *authenticator) ReadStreamRequest(
+	r io.Reader,
+	messageType messageType,
+) (io.Reader, []byte, error) {
+	if !a.Enabled() {
+		return r, nil, nil
+	}
+
+	nonce := make([]byte, streamNonceSize)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, nil, fmt.Errorf("read: %w", err)
+	}
+	payload, err := a.readStreamPayload(r, messageType, false, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(payload), nonce, nil
+}
+
+// WriteStreamResponse writes a response with the given payload to the
+// stream request with the given nonce.
+//
+// If authentication is disabled, the payload is written as is.
+func (a *authenticator) WriteStreamResponse(
+	w io.Writer,
+	messageType messageType,
+	nonce []byte,
+	payload []byte,
+) error {
+	if !a.Enabled() {
+		if _, err := w.Write(payload); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+		return nil
+	}
+	return a.writeStreamPayload(w, messageType, true, nonce, payload)
+}
+
+// ReadStreamResponse reads and verifies the response to the stream request
+// with the given nonce. Returns a reader for the response payload.
+//
+// If authentication is disabled, returns r to read the payload.
+func (a *authenticator) ReadStreamResponse(
+	r io.Reader,
+	messageType messageType,
+	nonce []byte,
+) (io.Reader, error) {
+	if !a.Enabled() {
+		return r, nil
+	}
+
+	payload, err := a.readStreamPayload(r, messageType, true, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(payload), nil
+}
+
+// RecordFailure records a message from the given address was rejected.
+func (a *authenticator) RecordFailure(addr string, transport string) {
+	a.metrics.AuthFailuresTotal.With(prometheus.Labels{
+		"transport": transport,
+	}).Inc()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.failures = append(a.failures, AuthFailure{
+		Addr:      addr,
+		Transport: transport,
+		Time:      time.Now(),
+	})
+	if len(a.failures) > maxAuthFailures {
+		a.failures = a.failures[len(a.failures)-maxAuthFailures:]
+	}
+}
+
+// Failures returns the most recent authentication failures, ordered from
+// oldest to newest.
+func (a *authenticator) Failures() []AuthFailure {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	failures := make([]AuthFailure, len(a.failures))
+	copy(failures, a.failures)
+	return failures
+}
+
+func (a *authenticator) writeStreamPayload(
+	w io.Writer,
+	messageType messageType,
+	response bool,
+	nonce []byte,
+	payload []byte,
+) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(payload)))
+	if _, err := w.Write(size[:]); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if _, err := w.Write(a.streamTag(messageType, response, nonce, payload)); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+func (a *authenticator) readStreamPayload(
+	r io.Reader,
+	messageType messageType,
+	response bool,
+	nonce []byte,
+) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxStreamPayloadSize {
+		return nil, fmt.Errorf("payload too large: %d", n)
+	}
+
+	// Read the payload as it arrives rather than allocating the full size
+	// upfront, since the size isn't authenticated until the tag is read.
+	payload, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	if len(payload) != int(n) {
+		return nil, fmt.Errorf("read: %w", io.ErrUnexpectedEOF)
+	}
+
+	tag := make([]byte, authTagSize)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	if !hmac.Equal(tag, a.streamTag(messageType, response, nonce, payload)) {
+		return nil, errUnauthenticated
+	}
+	return payload, nil
+}
+
+func (a *authenticator) streamTag(
+	messageType messageType,
+	response bool,
+	nonce []byte,
+	payload []byte,
+) []byte {
+	direction := byte(0)
+	if response {
+		direction = 1
+	}
+	mac := hmac.New(sha256.New, a.key)
+	_, _ = mac.Write([]byte{byte(messageType), supportedVersion, direction})
+	// The nonce has a fixed size, so the nonce and payload can't be shifted
+	// between each other.
+	_, _ = mac.Write(nonce)
+	_, _ = mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (a *authenticator) tag(b []byte) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	_, _ = mac.Write(b)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,185 @@
+package gossip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticator_Packet(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		auth := newAuthenticator("my-token", newMetrics())
+
+		b := auth.SignPacket([]byte("foo"))
+		assert.Len(t, b, 3+auth.Overhead())
+
+		payload, err := auth.VerifyPacket(b)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("foo"), payload)
+	})
+
+	t.Run("mismatched token", func(t *testing.T) {
+		auth1 := newAuthenticator("my-token", newMetrics())
+		auth2 := newAuthenticator("other-token", newMetrics())
+
+		_, err := auth2.VerifyPacket(auth1.SignPacket([]byte("foo")))
+		assert.ErrorIs(t, err, errUnauthenticated)
+	})
+
+	t.Run("modified packet", func(t *testing.T) {
+		auth := newAuthenticator("my-token", newMetrics())
+
+		b := auth.SignPacket([]byte("foo"))
+		b[0] = 'b'
+
+		_, err := auth.VerifyPacket(b)
+		assert.ErrorIs(t, err, errUnauthenticated)
+	})
+
+	t.Run("packet too small", func(t *testing.T) {
+		auth := newAuthenticator("my-token", newMetrics())
+
+		_, err := auth.VerifyPacket([]byte("foo"))
+		assert.ErrorIs(t, err, errUnauthenticated)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		auth := newAuthenticator("", newMetrics())
+
+		b := auth.SignPacket([]byte("foo"))
+		assert.Equal(t, []byte("foo"), b)
+
+		payload, err := auth.VerifyPacket(b)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("foo"), payload)
+	})
+}
+
+func TestAuthenticator_Stream(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		auth := newAuthenticator("my-token", newMetrics())
+
+		var buf bytes.Buffer
+		nonce, err := auth.WriteStreamRequest(&buf, messageTypeJoin, []byte("foo"))
+		require.NoError(t, err)
+
+		req, reqNonce, err := auth.ReadStreamRequest(&buf, messageTypeJoin)
+		require.NoError(t, err)
+		assert.Equal(t, nonce, reqNonce)
+		payload, err := io.ReadAll(req)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("foo"), payload)
+
+		require.NoError(t, auth.WriteStreamResponse(
+			&buf, messageTypeJoin, reqNonce, []byte("bar"),
+		))
+
+		resp, err := auth.ReadStreamResponse(&buf, messageTypeJoin, nonce)
+		require.NoError(t, err)
+		payload, err = io.ReadAll(resp)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("bar"), payload)
+	})
+
+	t.Run("modified payload", func(t *testing.T) {
+		auth := newAuthenticator("my-token", newMetrics())
+
+		var buf bytes.Buffer
+		_, err := auth.WriteStreamRequest(&buf, messageTypeJoin, []byte("foo"))
+		require.NoError(t, err)
+
+		b := buf.Bytes()
+		b[streamNonceSize+4] = 'x'
+
+		_, _, err = auth.ReadStreamRequest(bytes.NewReader(b), messageTypeJoin)
+		assert.ErrorIs(t, err, errUnauthenticated)
+	})
+
+	t.Run("mismatched message type", func(t *testing.T) {
+		auth := newAuthenticator("my-token", newMetrics())
+
+		var buf bytes.Buffer
+		_, err := auth.WriteStreamRequest(&buf, messageTypeJoin, []byte("foo"))
+		require.NoError(t, err)
+
+		_, _, err = auth.ReadStreamRequest(&buf, messageTypeLeave)
+		assert.ErrorIs(t, err, errUnauthenticated)
+	})
+
+	t.Run("replayed response", func(t *testing.T) {
+		auth := newAuthenticator("my-token", newMetrics())
+
+		var buf bytes.Buffer
+		require.NoError(t, auth.WriteStreamResponse(
+			&buf, messageTypeJoin, []byte("0123456789abcdef"), []byte("bar"),
+		))
+
+		// A response to a different request must be rejected.
+		_, err := auth.ReadStreamResponse(
+			&buf, messageTypeJoin, []byte("fedcba9876543210"),
+		)
+		assert.ErrorIs(t, err, errUnauthenticated)
+	})
+
+	t.Run("request as response", func(t *testing.T) {
+		auth := newAuthenticator("my-token", newMetrics())
+
+		var buf bytes.Buffer
+		nonce, err := auth.WriteStreamRequest(&buf, messageTypeJoin, []byte("foo"))
+		require.NoError(t, err)
+
+		// Discard the nonce and read the request payload as a response.
+		buf.Next(streamNonceSize)
+		_, err = auth.ReadStreamResponse(&buf, messageTypeJoin, nonce)
+		assert.ErrorIs(t, err, errUnauthenticated)
+	})
+
+	t.Run("mismatched token", func(t *testing.T) {
+		auth1 := newAuthenticator("my-token", newMetrics())
+		auth2 := newAuthenticator("other-token", newMetrics())
+
+		var buf bytes.Buffer
+		_, err := auth1.WriteStreamRequest(&buf, messageTypeJoin, []byte("foo"))
+		require.NoError(t, err)
+
+		_, _, err = auth2.ReadStreamRequest(&buf, messageTypeJoin)
+		assert.ErrorIs(t, err, errUnauthenticated)
+	})
+
+	t.Run("payload too large", func(t *testing.T) {
+		auth := newAuthenticator("my-token", newMetrics())
+
+		b := make([]byte, streamNonceSize+4)
+		binary.BigEndian.PutUint32(b[streamNonceSize:], maxStreamPayloadSize+1)
+
+		_, _, err := auth.ReadStreamRequest(bytes.NewReader(b), messageTypeJoin)
+		assert.Error(t, err)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		auth := newAuthenticator("", newMetrics())
+
+		var buf bytes.Buffer
+		_, err := auth.WriteStreamRequest(&buf, messageTypeJoin, []byte("foo"))
+		require.NoError(t, err)
+		// The payload is written as is.
+		assert.Equal(t, []byte("foo"), buf.Bytes())
+	})
+}
+
+func TestAuthenticator_Failures(t *testing.T) {
+	auth := newAuthenticator("my-token", newMetrics())
+
+	for i := 0; i != maxAuthFailures+5; i++ {
+		auth.RecordFailure("10.26.104.56:8003", "packet")
+	}
+
+	failures := auth.Failures()
+	assert.Len(t, failures, maxAuthFailures)
+	assert.Equal(t, "10.26.104.56:8003", failures[0].Addr)
+	assert.Equal(t, "packet", failures[0].Transport)
+}
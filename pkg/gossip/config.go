@@ -2,8 +2,11 @@ package gossip
 
 import (
 	"fmt"
+	"net"
+	"strings"
 	"time"
 
+	pikoconfig "github.com/andydunstall/piko/pkg/config"
 	"github.com/spf13/pflag"
 )
 
@@ -14,23 +17,106 @@ type Config struct {
 	// AdvertiseAddr is the address to advertise to other nodes.
 	AdvertiseAddr string `json:"advertise_addr" yaml:"advertise_addr"`
 
+	// AdvertiseInterface is a network interface name or CIDR to select the
+	// advertise IP from.
+	AdvertiseInterface string `json:"advertise_interface" yaml:"advertise_interface"`
+
+	// PacketBindAddr is the address to bind to listen for gossip packets
+	// (UDP). If empty, the same host and port as BindAddr is used.
+	PacketBindAddr string `json:"packet_bind_addr" yaml:"packet_bind_addr"`
+
+	// PacketAdvertiseAddr is the address to advertise to other nodes to send
+	// gossip packets. If empty, defaults to the advertise address when
+	// PacketBindAddr is empty.
+	PacketAdvertiseAddr string `json:"packet_advertise_addr" yaml:"packet_advertise_addr"`
+
+	// TCPOnly indicates to send gossip packets using TCP rather than UDP.
+	TCPOnly bool `json:"tcp_only" yaml:"tcp_only"`
+
 	// Interval is the rate to initiate a gossip round.
 	Interval time.Duration `json:"interval" yaml:"interval"`
 
+	// Fanout is the number of random live nodes to gossip with in each
+	// gossip round.
+	Fanout int `json:"fanout" yaml:"fanout"`
+
 	// MaxPacketSize is the maximum size of any packet sent.
 	MaxPacketSize int `json:"max_packet_size" yaml:"max_packet_size"`
+
+	// LivenessInterval is the rate to check the liveness of each known node.
+	LivenessInterval time.Duration `json:"liveness_interval" yaml:"liveness_interval"`
+
+	// SuspicionThreshold is the failure detector suspicion level (phi) above
+	// which a node is considered unreachable.
+	SuspicionThreshold float64 `json:"suspicion_threshold" yaml:"suspicion_threshold"`
+
+	// NodeExpiry is the duration a left or unreachable node is stored until
+	// it is removed from the cluster.
+	NodeExpiry time.Duration `json:"node_expiry" yaml:"node_expiry"`
+
+	// FullSyncInterval is the rate to initiate a full state sync with
+	// random nodes. If zero full state syncs are disabled.
+	FullSyncInterval time.Duration `json:"full_sync_interval" yaml:"full_sync_interval"`
+
+	// FullSyncFanout is the number of random nodes to sync with in each
+	// full state sync.
+	FullSyncFanout int `json:"full_sync_fanout" yaml:"full_sync_fanout"`
+
+	// JoinToken is a shared token used to authenticate gossip messages. If
+	// set, messages from nodes without the same token are rejected.
+	JoinToken string `json:"join_token" yaml:"join_token"`
+
+	// JoinTokenFile is the path of a file containing the join token.
+	JoinTokenFile string `json:"join_token_file" yaml:"join_token_file"`
+}
+
+// LoadSecrets loads the join token from a file if configured.
+func (c *Config) LoadSecrets() error {
+	if err := pikoconfig.LoadSecretFile(&c.JoinToken, c.JoinTokenFile); err != nil {
+		return fmt.Errorf("join token: %w", err)
+	}
+	return nil
 }
 
 func (c *Config) Validate() error {
 	if c.BindAddr == "" {
 		return fmt.Errorf("missing bind addr")
 	}
+	if c.AdvertiseInterface != "" && c.AdvertiseAddr != "" {
+		return fmt.Errorf("cannot set both advertise addr and advertise interface")
+	}
+	if strings.Contains(c.AdvertiseInterface, "/") {
+		if _, _, err := net.ParseCIDR(c.AdvertiseInterface); err != nil {
+			return fmt.Errorf("invalid advertise interface cidr: %w", err)
+		}
+	}
+	if c.TCPOnly && c.PacketBindAddr != "" {
+		return fmt.Errorf("cannot set packet bind addr when tcp only")
+	}
 	if c.Interval == 0 {
 		return fmt.Errorf("missing interval")
 	}
+	if c.Fanout <= 0 {
+		return fmt.Errorf("fanout must be positive")
+	}
 	if c.MaxPacketSize == 0 {
 		return fmt.Errorf("missing max packet size")
 	}
+	if c.LivenessInterval <= 0 {
+		return fmt.Errorf("liveness interval must be positive")
+	}
+	if c.SuspicionThreshold <= 0 {
+		return fmt.Errorf("suspicion threshold must be positive")
+	}
+	if c.NodeExpiry <= 0 {
+		return fmt.Errorf("node expiry must be positive")
+	}
+	if c.FullSyncInterval < 0 {
+		return fmt.Errorf("full sync interval cannot be negative")
+	}
+	if c.FullSyncInterval > 0 && c.FullSyncFanout <= 0 {
+		return fmt.Errorf("full sync fanout must be positive")
+	}
 	return nil
 }
 
@@ -63,6 +149,59 @@ private IP will be used, such as a bind address of ':8003' may have an
 advertise address of '10.26.104.14:8003'.`,
 	)
 
+	fs.StringVar(
+		&c.AdvertiseInterface,
+		"gossip.advertise-interface",
+		c.AdvertiseInterface,
+		`
+Network interface to select the advertise IP from, when no advertise address
+is configured. The advertised port is the bind port.
+
+This may be either an interface name, such as 'eth1', or a CIDR to select the
+first interface address in the range, such as '10.26.0.0/16'.
+
+If the interface has both IPv4 and IPv6 addresses, the address is selected
+using '--cluster.advertise-ip-family'.`,
+	)
+
+	fs.StringVar(
+		&c.PacketBindAddr,
+		"gossip.packet-bind-addr",
+		c.PacketBindAddr,
+		`
+The host/port to listen for inter-node gossip packets (UDP).
+
+Gossip uses TCP for joining and leaving the cluster and full state syncs, and
+UDP for periodic gossip rounds. By default the UDP listener binds to the same
+host and port as '--gossip.bind-addr', though you can bind to a different
+port or interface, such as '--gossip.packet-bind-addr 10.26.104.45:8004'.`,
+	)
+
+	fs.StringVar(
+		&c.PacketAdvertiseAddr,
+		"gossip.packet-advertise-addr",
+		c.PacketAdvertiseAddr,
+		`
+Gossip packet listen address to advertise to other nodes in the cluster.
+
+If '--gossip.packet-bind-addr' is not set this defaults to the gossip
+advertise address. Otherwise this defaults to the packet bind address, using
+the nodes private IP if the bind address does not include an IP.`,
+	)
+
+	fs.BoolVar(
+		&c.TCPOnly,
+		"gossip.tcp-only",
+		c.TCPOnly,
+		`
+Whether to send gossip packets using TCP rather than UDP.
+
+This is useful in environments where UDP is blocked, though opens a new TCP
+connection for each gossip packet, so adds overhead.
+
+All nodes in the cluster must use the same setting.`,
+	)
+
 	fs.DurationVar(
 		&c.Interval,
 		"gossip.interval",
@@ -73,6 +212,22 @@ The interval to initiate rounds of gossip.
 Each gossip round selects another known node to synchronize with.`,
 	)
 
+	fs.IntVar(
+		&c.Fanout,
+		"gossip.fanout",
+		c.Fanout,
+		`
+The number of random live nodes to gossip with in each gossip round.
+
+Increasing the fanout reduces the time for updates to propagate around the
+cluster at the cost of additional bandwidth.
+
+Note there is no separate retransmit setting, since each round exchanges
+digests and the receiving node responds with any state the sender is
+missing. Therefore updates are retransmitted until every node has received
+them.`,
+	)
+
 	fs.IntVar(
 		&c.MaxPacketSize,
 		"gossip.max-packet-size",
@@ -83,4 +238,95 @@ The maximum size of any packet sent.
 Depending on your networks MTU you may be able to increase to include more data
 in each packet.`,
 	)
+
+	fs.DurationVar(
+		&c.LivenessInterval,
+		"gossip.liveness-interval",
+		c.LivenessInterval,
+		`
+The interval to check the liveness of each known node.
+
+Node liveness is inferred from the gossip messages received from each node,
+using a phi accrual failure detector.`,
+	)
+
+	fs.Float64Var(
+		&c.SuspicionThreshold,
+		"gossip.suspicion-threshold",
+		c.SuspicionThreshold,
+		`
+The failure detector suspicion level (phi) above which a node is considered
+unreachable.
+
+The suspicion level is the time since the last message from the node
+relative to the mean interval between messages. Such as a threshold of 20
+means a node is considered unreachable if no messages have been received for
+20 times the mean interval.
+
+Increase the threshold on lossy networks to avoid nodes flapping between
+reachable and unreachable, at the cost of detecting failed nodes slower.`,
+	)
+
+	fs.DurationVar(
+		&c.NodeExpiry,
+		"gossip.node-expiry",
+		c.NodeExpiry,
+		`
+The duration a left or unreachable node is stored until it is removed from
+the cluster.
+
+If an unreachable node recovers before it expires, it is considered
+reachable again.`,
+	)
+
+	fs.DurationVar(
+		&c.FullSyncInterval,
+		"gossip.full-sync-interval",
+		c.FullSyncInterval,
+		`
+The interval to initiate a full state sync with random nodes.
+
+Each gossip round only exchanges the state that fits in a single packet, so
+in large clusters it may take many rounds for a node to converge. A full state
+sync exchanges the full cluster state with another node over TCP, which
+bounds the time for nodes to converge at the cost of additional bandwidth.
+
+Set to 0 to disable full state syncs.`,
+	)
+
+	fs.IntVar(
+		&c.FullSyncFanout,
+		"gossip.full-sync-fanout",
+		c.FullSyncFanout,
+		`
+The number of random nodes to sync with in each full state sync.`,
+	)
+
+	fs.StringVar(
+		&c.JoinToken,
+		"gossip.join-token",
+		c.JoinToken,
+		`
+A shared token used to authenticate nodes in the cluster.
+
+When set, every gossip message is authenticated using a HMAC of the token,
+and messages from nodes without the same token are rejected, so nodes can
+only join the cluster if configured with the same token.
+
+Nodes also use the token to authenticate RPC requests and requests forwarded
+between nodes.
+
+Note the token authenticates gossip traffic but does not encrypt it.
+
+Rejected messages are logged and can be inspected using
+'piko server status gossip auth-failures'.`,
+	)
+	fs.StringVar(
+		&c.JoinTokenFile,
+		"gossip.join-token-file",
+		c.JoinTokenFile,
+		`
+Path of a file containing the shared token used to authenticate nodes in the
+cluster, so the token isn't passed as a flag or environment variable.`,
+	)
 }
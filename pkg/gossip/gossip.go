@@ -3,14 +3,17 @@ package gossip
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
@@ -18,20 +21,57 @@ import (
 const (
 	streamTimeout = time.Second * 10
 
-	suspicionThreshold = 20
-	compactThreshold   = 100
+	compactThreshold = 100
 )
 
+// SyncStats contains statistics about the full state syncs initiated by the
+// local node.
+type SyncStats struct {
+	// Interval is the configured full sync interval, or zero if full syncs
+	// are disabled.
+	Interval time.Duration `json:"interval"`
+
+	// Fanout is the number of nodes to sync with in each full sync.
+	Fanout int `json:"fanout"`
+
+	// Syncs is the number of successful full syncs.
+	Syncs int `json:"syncs"`
+
+	// Failures is the number of failed full syncs.
+	Failures int `json:"failures"`
+
+	// LastSyncNodeID is the ID of the last node successfully synced with.
+	LastSyncNodeID string `json:"last_sync_node_id,omitempty"`
+
+	// LastSync is the time of the last successful full sync.
+	LastSync time.Time `json:"last_sync"`
+
+	// LastError contains the error from the last failed full sync.
+	LastError string `json:"last_error,omitempty"`
+}
+
 type Gossip struct {
 	state *clusterState
 
+	auth *authenticator
+
+	syncStats SyncStats
+
+	// syncMu protects syncStats.
+	syncMu sync.Mutex
+
 	config *Config
 
 	streamListener *streamListener
 	packetListener *packetListener
 
-	dialer     *net.Dialer
-	packetConn net.PacketConn
+	dialer *net.Dialer
+
+	// resolver resolves domains in join addresses.
+	resolver *net.Resolver
+
+	// transport sends packets to other nodes.
+	transport packetTransport
 
 	metrics *Metrics
 
@@ -67,29 +107,66 @@ func New(
 		nodeID,
 		config.AdvertiseAddr,
 		failureDetector,
+		config.NodeExpiry,
 		metrics,
 		watcher,
 	)
 
-	streamListener := newStreamListener(
-		streamLn, state, streamTimeout, metrics, logger,
-	)
-	go streamListener.Serve()
+	auth := newAuthenticator(config.JoinToken, metrics)
+
+	var transport packetTransport
+	if config.TCPOnly {
+		transport = newTCPTransport(streamTimeout, auth, metrics)
+		// Packets are received via the stream listener.
+		packetLn = nil
+	} else {
+		transport = newUDPTransport(packetLn)
+		if config.PacketAdvertiseAddr != "" &&
+			config.PacketAdvertiseAddr != config.AdvertiseAddr {
+			state.SetLocalPacketAddr(config.PacketAdvertiseAddr)
+		}
+	}
 
 	packetListener := newPacketListener(
-		packetLn, state, failureDetector, config.MaxPacketSize, metrics, logger,
+		packetLn,
+		transport,
+		state,
+		failureDetector,
+		auth,
+		config.MaxPacketSize,
+		metrics,
+		logger,
+	)
+	if packetLn != nil {
+		go packetListener.Serve()
+	}
+
+	streamListener := newStreamListener(
+		streamLn,
+		state,
+		packetListener,
+		auth,
+		streamTimeout,
+		metrics,
+		logger,
 	)
-	go packetListener.Serve()
+	go streamListener.Serve()
 
 	gossip := &Gossip{
-		state:          state,
+		state: state,
+		auth:  auth,
+		syncStats: SyncStats{
+			Interval: config.FullSyncInterval,
+			Fanout:   config.FullSyncFanout,
+		},
 		config:         config,
 		streamListener: streamListener,
 		packetListener: packetListener,
 		dialer: &net.Dialer{
 			Timeout: streamTimeout,
 		},
-		packetConn: packetLn,
+		resolver:   net.DefaultResolver,
+		transport:  transport,
 		metrics:    metrics,
 		logger:     logger,
 		closed:     atomic.NewBool(false),
@@ -124,19 +201,52 @@ func (g *Gossip) Nodes() []NodeMetadata {
 	return g.state.Nodes()
 }
 
+// SetResolver sets the resolver used to resolve domains in join addresses.
+//
+// Defaults to net.DefaultResolver. Must be called before joining.
+func (g *Gossip) SetResolver(resolver *net.Resolver) {
+	g.resolver = resolver
+}
+
 // Join attempts to join an existing cluster by syncronising with the nodes
 // at the given addresses.
 //
 // The addresses may contain either IP addresses or domain names. When a domain
 // name is used, the domain is resolved and each resolved IP address is
-// attempted. Will also periodically re-resolve the joined domains and
-// attempt to gossip with any unknown nodes. If the port is omitted the
-// default bind port is used.
+// attempted. If the port is omitted the default bind port is used.
 //
 // Returns the IDs of joined nodes. Or if addresses were provided by no
 // nodes could be joined an error is returned. Note if a domain was provided
 // that only resolved to the current node then Join will return nil.
 func (g *Gossip) Join(addrs []string) ([]string, error) {
+	return g.joinAddrs(addrs, nil)
+}
+
+// Rejoin re-resolves the given addresses and attempts to join any resolved
+// addresses that don't belong to a known live node.
+//
+// This is used to periodically re-resolve join domains, so the node
+// discovers nodes added to the domain after it joined, and recovers if it
+// couldn't join any nodes on startup.
+//
+// Returns the IDs of joined nodes. Or if there were unknown addresses but
+// none could be joined an error is returned.
+func (g *Gossip) Rejoin(addrs []string) ([]string, error) {
+	known := map[string]struct{}{
+		g.state.LocalNodeMetadata().Addr: {},
+	}
+	for _, node := range g.state.LiveNodes() {
+		known[node.Addr] = struct{}{}
+	}
+	return g.joinAddrs(addrs, known)
+}
+
+// joinAddrs resolves and joins the given addresses, skipping any resolved
+// addresses in skip.
+func (g *Gossip) joinAddrs(
+	addrs []string,
+	skip map[string]struct{},
+) ([]string, error) {
 	if len(addrs) == 0 {
 		return nil, nil
 	}
@@ -145,14 +255,11 @@ func (g *Gossip) Join(addrs []string) ([]string, error) {
 	var lastJoinErr error
 	for _, unresolvedAddr := range addrs {
 		unresolvedAddr = g.ensurePort(unresolvedAddr)
-		resolvedAddrs, err := resolveAddr(unresolvedAddr)
+		resolvedAddrs, err := g.resolveAddr(unresolvedAddr)
 		if err != nil {
 			return nil, fmt.Errorf("resolve: %s: %w", unresolvedAddr, err)
 		}
 
-		// TODO(andydunstall): If unresolvedAddr contains a domain, store
-		// and periodically re-resolve.
-
 		if len(resolvedAddrs) == 0 {
 			g.logger.Warn(
 				"join: domain did not resolve any addresses",
@@ -162,6 +269,10 @@ func (g *Gossip) Join(addrs []string) ([]string, error) {
 		}
 
 		for _, addr := range resolvedAddrs {
+			if _, ok := skip[addr]; ok {
+				continue
+			}
+
 			nodeID, err := g.join(addr)
 			if err != nil {
 				lastJoinErr = err
@@ -245,6 +356,21 @@ func (g *Gossip) Leave() error {
 	return lastLeaveErr
 }
 
+// SyncStats returns statistics about the full state syncs initiated by the
+// local node.
+func (g *Gossip) SyncStats() SyncStats {
+	g.syncMu.Lock()
+	defer g.syncMu.Unlock()
+
+	return g.syncStats
+}
+
+// AuthFailures returns the most recent messages rejected from
+// unauthenticated nodes.
+func (g *Gossip) AuthFailures() []AuthFailure {
+	return g.auth.Failures()
+}
+
 func (g *Gossip) Metrics() *Metrics {
 	return g.metrics
 }
@@ -278,8 +404,8 @@ func (g *Gossip) schedule() {
 			g.logger.Warn("gossip round failed", zap.Error(err))
 		}
 	})
-	go g.scheduleFunc(g.config.Interval, func() {
-		g.state.UpdateLiveness(float64(suspicionThreshold))
+	go g.scheduleFunc(g.config.LivenessInterval, func() {
+		g.state.UpdateLiveness(g.config.SuspicionThreshold)
 	})
 	go g.scheduleFunc(g.config.Interval*10, func() {
 		g.state.CompactLocal(compactThreshold)
@@ -287,6 +413,11 @@ func (g *Gossip) schedule() {
 	go g.scheduleFunc(g.config.Interval*10, func() {
 		g.state.RemoveExpired()
 	})
+	if g.config.FullSyncInterval > 0 {
+		go g.scheduleFunc(g.config.FullSyncInterval, func() {
+			g.fullSyncRound()
+		})
+	}
 }
 
 func (g *Gossip) scheduleFunc(interval time.Duration, f func()) {
@@ -313,12 +444,19 @@ func (g *Gossip) scheduleFunc(interval time.Duration, f func()) {
 
 // gossipRound initiates a round of gossip.
 func (g *Gossip) gossipRound() error {
-	// Select a random live node to gossip with.
+	// Select up to the configured fanout of random live nodes to gossip
+	// with.
 	nodes := g.state.LiveNodes()
-	if len(nodes) > 0 {
-		node := nodes[rand.Int()%len(nodes)]
+	rand.Shuffle(len(nodes), func(i, j int) {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	})
+	if len(nodes) > g.config.Fanout {
+		nodes = nodes[:g.config.Fanout]
+	}
+	var errs error
+	for _, node := range nodes {
 		if err := g.gossip(node); err != nil {
-			return fmt.Errorf("gossip: %s: %w", node.ID, err)
+			errs = errors.Join(errs, fmt.Errorf("gossip: %s: %w", node.ID, err))
 		}
 	}
 
@@ -331,11 +469,59 @@ func (g *Gossip) gossipRound() error {
 	if len(nodes) > 0 {
 		node := nodes[rand.Int()%len(nodes)]
 		if err := g.gossip(node); err != nil {
-			return fmt.Errorf("gossip: %s: %w", node.ID, err)
+			errs = errors.Join(errs, fmt.Errorf("gossip: %s: %w", node.ID, err))
 		}
 	}
 
-	return nil
+	return errs
+}
+
+// fullSyncRound exchanges the full cluster state with up to the configured
+// fanout of random live nodes.
+//
+// Unlike a gossip round, which is limited to a single packet, the full state
+// is exchanged over a stream connection.
+func (g *Gossip) fullSyncRound() {
+	nodes := g.state.LiveNodes()
+	rand.Shuffle(len(nodes), func(i, j int) {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	})
+	if len(nodes) > g.config.FullSyncFanout {
+		nodes = nodes[:g.config.FullSyncFanout]
+	}
+
+	for _, node := range nodes {
+		// A full sync uses the same exchange as join, where the response
+		// contains the full state we're missing.
+		_, err := g.join(node.Addr)
+
+		g.syncMu.Lock()
+		if err != nil {
+			g.syncStats.Failures++
+			g.syncStats.LastError = err.Error()
+		} else {
+			g.syncStats.Syncs++
+			g.syncStats.LastSyncNodeID = node.ID
+			g.syncStats.LastSync = time.Now()
+		}
+		g.syncMu.Unlock()
+
+		if err != nil {
+			g.metrics.FullSyncsTotal.With(prometheus.Labels{
+				"result": "failure",
+			}).Inc()
+
+			g.logger.Warn(
+				"full sync failed",
+				zap.String("node-id", node.ID),
+				zap.Error(err),
+			)
+		} else {
+			g.metrics.FullSyncsTotal.With(prometheus.Labels{
+				"result": "success",
+			}).Inc()
+		}
+	}
 }
 
 func (g *Gossip) gossip(node NodeMetadata) error {
@@ -347,17 +533,21 @@ func (g *Gossip) gossip(node NodeMetadata) error {
 
 	localMeta := g.state.LocalNodeMetadata()
 	if err := encoder.Encode(&digestHeader{
-		NodeID:  localMeta.ID,
-		Addr:    localMeta.Addr,
-		Request: true,
+		NodeID:     localMeta.ID,
+		Addr:       localMeta.Addr,
+		PacketAddr: g.state.LocalPacketAddr(),
+		Request:    true,
 	}); err != nil {
 		return fmt.Errorf("encode: %w", err)
 	}
 
-	if buf.Len() > g.config.MaxPacketSize {
+	// Reserve space for the authentication tag.
+	maxPacketSize := g.config.MaxPacketSize - g.auth.Overhead()
+
+	if buf.Len() > maxPacketSize {
 		return fmt.Errorf(
 			"max packet size too small for header: %d < %d",
-			g.config.MaxPacketSize, buf.Len(),
+			maxPacketSize, buf.Len(),
 		)
 	}
 
@@ -376,21 +566,18 @@ func (g *Gossip) gossip(node NodeMetadata) error {
 			return fmt.Errorf("encode: %w", err)
 		}
 
-		if buf.Len() > g.config.MaxPacketSize {
+		if buf.Len() > maxPacketSize {
 			break
 		}
 		bufLen = buf.Len()
 	}
+	b := g.auth.SignPacket(buf.Bytes()[:bufLen])
 
-	udpAddr, err := net.ResolveUDPAddr("udp", node.Addr)
-	if err != nil {
-		return fmt.Errorf("resolve udp: %s: %w", node.Addr, err)
-	}
-	if _, err = g.packetConn.WriteTo(buf.Bytes()[:bufLen], udpAddr); err != nil {
-		return fmt.Errorf("write packet: %s: %w", node.Addr, err)
+	if err := g.transport.WriteTo(b, g.state.PacketAddr(node)); err != nil {
+		return err
 	}
 
-	g.metrics.PacketBytesOutbound.Add(float64(bufLen))
+	g.metrics.PacketBytesOutbound.Add(float64(len(b)))
 
 	return nil
 }
@@ -427,7 +614,9 @@ func (g *Gossip) join(addr string) (string, error) {
 		return "", fmt.Errorf("write: %w", err)
 	}
 
-	encoder := newEncoder(w)
+	// Encode the request to a buffer so the payload can be authenticated.
+	var buf bytes.Buffer
+	encoder := newEncoder(&buf)
 
 	localMeta := g.state.LocalNodeMetadata()
 	if err := encoder.Encode(&joinHeader{
@@ -445,11 +634,21 @@ func (g *Gossip) join(addr string) (string, error) {
 		return "", fmt.Errorf("encode: %w", err)
 	}
 
+	nonce, err := g.auth.WriteStreamRequest(w, messageTypeJoin, buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+
 	if err := w.Flush(); err != nil {
 		return "", fmt.Errorf("flush: %w", err)
 	}
 
-	decoder := newDecoder(r)
+	resp, err := g.auth.ReadStreamResponse(r, messageTypeJoin, nonce)
+	if err != nil {
+		return "", fmt.Errorf("verify: %w", err)
+	}
+
+	decoder := newDecoder(resp)
 
 	var header joinHeader
 	if err := decoder.Decode(&header); err != nil {
@@ -498,7 +697,9 @@ func (g *Gossip) leave(addr string) error {
 		return fmt.Errorf("write: %w", err)
 	}
 
-	encoder := newEncoder(w)
+	// Encode the request to a buffer so the payload can be authenticated.
+	var buf bytes.Buffer
+	encoder := newEncoder(&buf)
 
 	localMeta := g.state.LocalNodeMetadata()
 	if err := encoder.Encode(&joinHeader{
@@ -512,11 +713,21 @@ func (g *Gossip) leave(addr string) error {
 		return fmt.Errorf("encode: %w", err)
 	}
 
+	nonce, err := g.auth.WriteStreamRequest(w, messageTypeLeave, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
 	if err := w.Flush(); err != nil {
 		return fmt.Errorf("flush: %w", err)
 	}
 
-	decoder := newDecoder(r)
+	resp, err := g.auth.ReadStreamResponse(r, messageTypeLeave, nonce)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+
+	decoder := newDecoder(resp)
 
 	// Wait for a header as an acknowledgement.
 	var header leaveHeader
@@ -529,8 +740,11 @@ func (g *Gossip) leave(addr string) error {
 
 // ensurePort adds the configured bind port to addr if addr doesn't already
 // have a port.
+//
+// addr may be an IPv6 literal, with or without brackets, such as 'fd00::1' or
+// '[fd00::1]'.
 func (g *Gossip) ensurePort(addr string) string {
-	if strings.Contains(addr, ":") {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
 		return addr
 	}
 
@@ -540,12 +754,13 @@ func (g *Gossip) ensurePort(addr string) string {
 		panic("invalid bind addr:" + g.config.BindAddr)
 	}
 
-	return addr + ":" + bindPort
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.JoinHostPort(host, bindPort)
 }
 
 // resolveAddr resolves the given address, which may be a domain pointing
-// to multiple IP addresses. If no port is given the bind port is used.
-func resolveAddr(addr string) ([]string, error) {
+// to multiple IP addresses.
+func (g *Gossip) resolveAddr(addr string) ([]string, error) {
 	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid addr: %s: %w", addr, err)
@@ -556,14 +771,17 @@ func resolveAddr(addr string) ([]string, error) {
 		return []string{addr}, nil
 	}
 
-	ips, err := net.LookupIP(host)
+	ctx, cancel := context.WithTimeout(context.Background(), streamTimeout)
+	defer cancel()
+
+	ips, err := g.resolver.LookupIP(ctx, "ip", host)
 	if err != nil {
 		return nil, fmt.Errorf("lookup host: %s: %w", host, err)
 	}
 
 	var addrs []string
 	for _, ip := range ips {
-		addrs = append(addrs, ip.String()+":"+port)
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
 	}
 	return addrs, nil
 }
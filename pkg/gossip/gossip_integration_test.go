@@ -65,6 +65,123 @@ func TestGossip_Join(t *testing.T) {
 	})
 }
 
+func TestGossip_Rejoin(t *testing.T) {
+	t.Run("join unknown node", func(t *testing.T) {
+		node1 := testNode("node-1", t)
+		defer node1.Close()
+
+		node2 := testNode("node-2", t)
+		defer node2.Close()
+
+		nodeIDs, err := node2.Rejoin([]string{node1.LocalNode().Addr})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"node-1"}, nodeIDs)
+
+		assert.Len(t, node1.Nodes(), 2)
+		assert.Len(t, node2.Nodes(), 2)
+	})
+
+	t.Run("skip known nodes", func(t *testing.T) {
+		node1 := testNode("node-1", t)
+		defer node1.Close()
+
+		node2 := testNode("node-2", t)
+		defer node2.Close()
+
+		nodeIDs, err := node2.Join([]string{node1.LocalNode().Addr})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"node-1"}, nodeIDs)
+
+		// Close node 1 so rejoining would fail if it wasn't skipped.
+		node1.Close()
+
+		nodeIDs, err = node2.Rejoin([]string{
+			node1.LocalNode().Addr, node2.LocalNode().Addr,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, nodeIDs)
+	})
+}
+
+func TestGossip_FullSync(t *testing.T) {
+	node1 := testNode("node-1", t)
+	defer node1.Close()
+
+	node2 := testNode("node-2", t)
+	defer node2.Close()
+
+	_, err := node2.Join([]string{node1.LocalNode().Addr})
+	require.NoError(t, err)
+
+	node1.UpsertLocal("k1", "v1")
+
+	node2.fullSyncRound()
+
+	stats := node2.SyncStats()
+	assert.Equal(t, 1, stats.Syncs)
+	assert.Equal(t, 0, stats.Failures)
+	assert.Equal(t, "node-1", stats.LastSyncNodeID)
+
+	state, ok := node2.Node("node-1")
+	require.True(t, ok)
+	var found bool
+	for _, entry := range state.Entries {
+		if entry.Key == "k1" {
+			assert.Equal(t, "v1", entry.Value)
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestGossip_JoinToken(t *testing.T) {
+	t.Run("matching token", func(t *testing.T) {
+		node1 := testNodeWithToken("node-1", "my-token", t)
+		defer node1.Close()
+
+		node2 := testNodeWithToken("node-2", "my-token", t)
+		defer node2.Close()
+
+		nodeIDs, err := node2.Join([]string{node1.LocalNode().Addr})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"node-1"}, nodeIDs)
+
+		assert.Empty(t, node1.AuthFailures())
+	})
+
+	t.Run("mismatched token", func(t *testing.T) {
+		node1 := testNodeWithToken("node-1", "my-token", t)
+		defer node1.Close()
+
+		node2 := testNodeWithToken("node-2", "other-token", t)
+		defer node2.Close()
+
+		_, err := node2.Join([]string{node1.LocalNode().Addr})
+		assert.Error(t, err)
+
+		assert.Len(t, node1.Nodes(), 1)
+
+		// The failure is recorded asynchronously by the listener.
+		assert.Eventually(t, func() bool {
+			failures := node1.AuthFailures()
+			return len(failures) == 1 && failures[0].Transport == "stream"
+		}, time.Second, time.Millisecond*10)
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		node1 := testNodeWithToken("node-1", "my-token", t)
+		defer node1.Close()
+
+		node2 := testNode("node-2", t)
+		defer node2.Close()
+
+		_, err := node2.Join([]string{node1.LocalNode().Addr})
+		assert.Error(t, err)
+
+		assert.Len(t, node1.Nodes(), 1)
+	})
+}
+
 func TestGossip_Leave(t *testing.T) {
 	t.Run("leave single node", func(t *testing.T) {
 		node1 := testNode("node-1", t)
@@ -149,6 +266,114 @@ func TestGossip_Gossip(t *testing.T) {
 	})
 }
 
+func TestGossip_Transport(t *testing.T) {
+	t.Run("tcp only", func(t *testing.T) {
+		node1Watcher := &updateWatcher{
+			Ch: make(chan updateEvent, 10),
+		}
+		defer node1Watcher.Close()
+
+		streamLn, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		node1Config := testConfig()
+		node1Config.AdvertiseAddr = streamLn.Addr().String()
+		node1Config.TCPOnly = true
+		node1 := New(
+			"node-1",
+			node1Config,
+			streamLn,
+			nil,
+			node1Watcher,
+			log.NewNopLogger(),
+		)
+		defer node1.Close()
+
+		streamLn, err = net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		node2Config := testConfig()
+		node2Config.AdvertiseAddr = streamLn.Addr().String()
+		node2Config.TCPOnly = true
+		node2 := New(
+			"node-2",
+			node2Config,
+			streamLn,
+			nil,
+			newNopWatcher(),
+			log.NewNopLogger(),
+		)
+		defer node2.Close()
+
+		_, err = node2.Join([]string{node1.LocalNode().Addr})
+		require.NoError(t, err)
+
+		// Update node 2 and wait for the update to be propagated to node 1
+		// via gossip.
+		node2.UpsertLocal("k1", "v1")
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+		defer cancel()
+
+		event, err := node1Watcher.Next(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, updateEvent{
+			NodeID: "node-2",
+			Key:    "k1",
+			Value:  "v1",
+		}, event)
+	})
+
+	t.Run("separate packet addr", func(t *testing.T) {
+		node1Watcher := &updateWatcher{
+			Ch: make(chan updateEvent, 10),
+		}
+		defer node1Watcher.Close()
+
+		node1 := testNodeWithWatcher("node-1", node1Watcher, t)
+		defer node1.Close()
+
+		streamLn, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		packetLn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		node2Config := testConfig()
+		node2Config.AdvertiseAddr = streamLn.Addr().String()
+		node2Config.PacketAdvertiseAddr = packetLn.LocalAddr().String()
+		node2 := New(
+			"node-2",
+			node2Config,
+			streamLn,
+			packetLn,
+			newNopWatcher(),
+			log.NewNopLogger(),
+		)
+		defer node2.Close()
+
+		_, err = node2.Join([]string{node1.LocalNode().Addr})
+		require.NoError(t, err)
+
+		// Node 1 should discover the packet address of node 2.
+		assert.Equal(
+			t,
+			packetLn.LocalAddr().String(),
+			node1.state.PacketAddr(node2.state.LocalNodeMetadata()),
+		)
+
+		node2.UpsertLocal("k1", "v1")
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+		defer cancel()
+
+		event, err := node1Watcher.Next(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, updateEvent{
+			NodeID: "node-2",
+			Key:    "k1",
+			Value:  "v1",
+		}, event)
+	})
+}
+
 func TestGossip_NodeUnreachable(t *testing.T) {
 	t.Run("detect unreachable", func(t *testing.T) {
 		node1Watcher := &livenessWatcher{
@@ -294,6 +519,21 @@ func testNodeWithWatcher(nodeID string, w Watcher, t *testing.T) *Gossip {
 	)
 }
 
+func testNodeWithToken(nodeID string, token string, t *testing.T) *Gossip {
+	streamLn, packetLn := testListen(t)
+	nodeConfig := testConfig()
+	nodeConfig.AdvertiseAddr = streamLn.Addr().String()
+	nodeConfig.JoinToken = token
+	return New(
+		nodeID,
+		nodeConfig,
+		streamLn,
+		packetLn,
+		newNopWatcher(),
+		log.NewNopLogger(),
+	)
+}
+
 func testListen(t *testing.T) (net.Listener, net.PacketConn) {
 	streamLn, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
@@ -309,8 +549,13 @@ func testListen(t *testing.T) (net.Listener, net.PacketConn) {
 
 func testConfig() *Config {
 	return &Config{
-		BindAddr:      "127.0.0.1:0",
-		Interval:      time.Millisecond * 10,
-		MaxPacketSize: 1400,
+		BindAddr:           "127.0.0.1:0",
+		Interval:           time.Millisecond * 10,
+		Fanout:             1,
+		MaxPacketSize:      1400,
+		LivenessInterval:   time.Millisecond * 10,
+		SuspicionThreshold: 20,
+		NodeExpiry:         time.Minute,
+		FullSyncFanout:     1,
 	}
 }
@@ -0,0 +1,58 @@
+package gossip
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGossip_EnsurePort(t *testing.T) {
+	g := &Gossip{
+		config: &Config{
+			BindAddr: "[::]:8003",
+		},
+	}
+
+	tests := []struct {
+		addr     string
+		expected string
+	}{
+		{"10.26.104.14", "10.26.104.14:8003"},
+		{"10.26.104.14:7000", "10.26.104.14:7000"},
+		{"piko.prod-piko-ns", "piko.prod-piko-ns:8003"},
+		{"piko.prod-piko-ns:7000", "piko.prod-piko-ns:7000"},
+		{"fd00::1", "[fd00::1]:8003"},
+		{"[fd00::1]", "[fd00::1]:8003"},
+		{"[fd00::1]:7000", "[fd00::1]:7000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equal(t, tt.expected, g.ensurePort(tt.addr))
+		})
+	}
+}
+
+func TestGossip_ResolveAddr(t *testing.T) {
+	g := &Gossip{
+		resolver: net.DefaultResolver,
+	}
+
+	t.Run("ipv4", func(t *testing.T) {
+		addrs, err := g.resolveAddr("10.26.104.14:8003")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.26.104.14:8003"}, addrs)
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		addrs, err := g.resolveAddr("[fd00::1]:8003")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"[fd00::1]:8003"}, addrs)
+	})
+
+	t.Run("missing port", func(t *testing.T) {
+		_, err := g.resolveAddr("fd00::1")
+		assert.Error(t, err)
+	})
+}
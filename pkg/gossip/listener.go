@@ -2,6 +2,8 @@ package gossip
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -20,6 +22,12 @@ type streamListener struct {
 
 	state *clusterState
 
+	// packets handles packets received via a stream connection when using
+	// TCP only gossip.
+	packets *packetListener
+
+	auth *authenticator
+
 	streamTimeout time.Duration
 
 	metrics *Metrics
@@ -30,6 +38,8 @@ type streamListener struct {
 func newStreamListener(
 	ln net.Listener,
 	state *clusterState,
+	packets *packetListener,
+	auth *authenticator,
 	streamTimeout time.Duration,
 	metrics *Metrics,
 	logger log.Logger,
@@ -37,6 +47,8 @@ func newStreamListener(
 	return &streamListener{
 		ln:            ln,
 		state:         state,
+		packets:       packets,
+		auth:          auth,
 		streamTimeout: streamTimeout,
 		metrics:       metrics,
 		logger:        logger,
@@ -63,7 +75,16 @@ func (l *streamListener) Serve() {
 		l.metrics.ConnectionsInbound.Inc()
 
 		go func() {
-			if err := l.handleConn(conn); err != nil {
+			err := l.handleConn(conn)
+			if errors.Is(err, errUnauthenticated) {
+				l.auth.RecordFailure(conn.RemoteAddr().String(), "stream")
+				l.logger.Warn(
+					"rejected unauthenticated connection",
+					zap.String("addr", conn.RemoteAddr().String()),
+				)
+				return
+			}
+			if err != nil {
 				l.logger.Warn(
 					"failed to handle connection",
 					zap.String("addr", conn.RemoteAddr().String()),
@@ -110,17 +131,24 @@ func (l *streamListener) handleConn(conn net.Conn) error {
 		return fmt.Errorf("unsupported version: %d", version)
 	}
 
+	req, nonce, err := l.auth.ReadStreamRequest(r, messageType)
+	if err != nil {
+		return err
+	}
+
 	switch messageType {
 	case messageTypeJoin:
-		return l.join(r, w)
+		return l.join(req, w, nonce)
 	case messageTypeLeave:
-		return l.leave(r, w)
+		return l.leave(req, w, nonce)
+	case messageTypePacket:
+		return l.packet(req, conn.RemoteAddr().String())
 	default:
 		return fmt.Errorf("unsupported message type: %d", version)
 	}
 }
 
-func (l *streamListener) join(r io.Reader, w *bufio.Writer) error {
+func (l *streamListener) join(r io.Reader, w *bufio.Writer, nonce []byte) error {
 	decoder := newDecoder(r)
 	var header joinHeader
 	if err := decoder.Decode(&header); err != nil {
@@ -141,8 +169,10 @@ func (l *streamListener) join(r io.Reader, w *bufio.Writer) error {
 	// Discover any unknown nodes from the digest.
 	l.state.ApplyDigest(digest)
 
+	// Encode the response to a buffer so the payload can be authenticated.
+	var buf bytes.Buffer
 	localMeta := l.state.LocalNodeMetadata()
-	encoder := newEncoder(w)
+	encoder := newEncoder(&buf)
 	if err := encoder.Encode(&joinHeader{
 		NodeID: localMeta.ID,
 		Addr:   localMeta.Addr,
@@ -156,6 +186,12 @@ func (l *streamListener) join(r io.Reader, w *bufio.Writer) error {
 		return fmt.Errorf("encode: %w", err)
 	}
 
+	if err := l.auth.WriteStreamResponse(
+		w, messageTypeJoin, nonce, buf.Bytes(),
+	); err != nil {
+		return err
+	}
+
 	if err := w.Flush(); err != nil {
 		return fmt.Errorf("flush: %w", err)
 	}
@@ -163,7 +199,7 @@ func (l *streamListener) join(r io.Reader, w *bufio.Writer) error {
 	return nil
 }
 
-func (l *streamListener) leave(r io.Reader, w *bufio.Writer) error {
+func (l *streamListener) leave(r io.Reader, w *bufio.Writer, nonce []byte) error {
 	decoder := newDecoder(r)
 	var header leaveHeader
 	if err := decoder.Decode(&header); err != nil {
@@ -178,8 +214,9 @@ func (l *streamListener) leave(r io.Reader, w *bufio.Writer) error {
 	l.state.ApplyDelta(delta)
 
 	// Send our own header as an acknowledgement.
+	var buf bytes.Buffer
 	localMeta := l.state.LocalNodeMetadata()
-	encoder := newEncoder(w)
+	encoder := newEncoder(&buf)
 	if err := encoder.Encode(&leaveHeader{
 		NodeID: localMeta.ID,
 		Addr:   localMeta.Addr,
@@ -187,6 +224,12 @@ func (l *streamListener) leave(r io.Reader, w *bufio.Writer) error {
 		return fmt.Errorf("encode: %w", err)
 	}
 
+	if err := l.auth.WriteStreamResponse(
+		w, messageTypeLeave, nonce, buf.Bytes(),
+	); err != nil {
+		return err
+	}
+
 	if err := w.Flush(); err != nil {
 		return fmt.Errorf("flush: %w", err)
 	}
@@ -194,14 +237,47 @@ func (l *streamListener) leave(r io.Reader, w *bufio.Writer) error {
 	return nil
 }
 
+// packet reads a length prefixed packet and handles the packet as if it were
+// received via the packet listener.
+func (l *streamListener) packet(r io.Reader, addr string) error {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > uint32(l.packets.maxPacketSize) {
+		return fmt.Errorf("packet too large: %d", n)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	l.metrics.PacketBytesInbound.Add(float64(n))
+
+	l.packets.Receive(b, addr)
+	return nil
+}
+
 // packetListener listens for and handles incoming packets.
+//
+// When using TCP only gossip, there is no packet connection to listen on and
+// packets are instead received via the stream listener.
 type packetListener struct {
+	// ln is the packet connection to listen on, or nil if using TCP only
+	// gossip.
 	ln net.PacketConn
 
+	// transport sends packets to other nodes.
+	transport packetTransport
+
 	state *clusterState
 
 	failureDetector failureDetector
 
+	auth *authenticator
+
 	readBuf []byte
 
 	maxPacketSize int
@@ -213,16 +289,20 @@ type packetListener struct {
 
 func newPacketListener(
 	ln net.PacketConn,
+	transport packetTransport,
 	state *clusterState,
 	failureDetector failureDetector,
+	auth *authenticator,
 	maxPacketSize int,
 	metrics *Metrics,
 	logger log.Logger,
 ) *packetListener {
 	return &packetListener{
 		ln:              ln,
+		transport:       transport,
 		state:           state,
 		failureDetector: failureDetector,
+		auth:            auth,
 		readBuf:         make([]byte, maxPacketSize),
 		maxPacketSize:   maxPacketSize,
 		metrics:         metrics,
@@ -243,18 +323,36 @@ func (l *packetListener) Serve() {
 
 		l.metrics.PacketBytesInbound.Add(float64(n))
 
-		buf := l.readBuf[:n]
-		if err = l.handlePacket(buf); err != nil {
-			l.logger.Warn(
-				"failed to handle packet",
-				zap.String("addr", addr.String()),
-				zap.Error(err),
-			)
-		}
+		l.Receive(l.readBuf[:n], addr.String())
+	}
+}
+
+// Receive authenticates and handles a packet received from the given
+// address.
+func (l *packetListener) Receive(b []byte, addr string) {
+	b, err := l.auth.VerifyPacket(b)
+	if err != nil {
+		l.auth.RecordFailure(addr, "packet")
+		l.logger.Warn(
+			"rejected unauthenticated packet",
+			zap.String("addr", addr),
+		)
+		return
+	}
+
+	if err = l.handlePacket(b); err != nil {
+		l.logger.Warn(
+			"failed to handle packet",
+			zap.String("addr", addr),
+			zap.Error(err),
+		)
 	}
 }
 
 func (l *packetListener) Close() error {
+	if l.ln == nil {
+		return nil
+	}
 	return l.ln.Close()
 }
 
@@ -288,8 +386,15 @@ func (l *packetListener) digest(b []byte) error {
 	// Discover any unknown nodes from the digest.
 	l.state.ApplyDigest(digest)
 
+	// Respond to the senders packet address if it differs from its node
+	// address.
+	addr := header.Addr
+	if header.PacketAddr != "" {
+		addr = header.PacketAddr
+	}
+
 	delta := l.state.Delta(digest, false)
-	if err := l.sendDelta(delta, header.Addr); err != nil {
+	if err := l.sendDelta(delta, addr); err != nil {
 		return fmt.Errorf("send delta: %w", err)
 	}
 
@@ -297,7 +402,7 @@ func (l *packetListener) digest(b []byte) error {
 	if header.Request {
 		if err := l.sendDigest(
 			l.state.Digest(),
-			header.Addr,
+			addr,
 			false,
 		); err != nil {
 			return fmt.Errorf("send digest: %w", err)
@@ -328,17 +433,16 @@ func (l *packetListener) sendDelta(delta delta, addr string) error {
 		NodeID: localMeta.ID,
 		Addr:   localMeta.Addr,
 	}
-	b, err := encodeDelta(header, delta, l.maxPacketSize)
+	b, err := encodeDelta(
+		header, delta, l.maxPacketSize-l.auth.Overhead(),
+	)
 	if err != nil {
 		return fmt.Errorf("encode: %w", err)
 	}
+	b = l.auth.SignPacket(b)
 
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
-	if err != nil {
-		return fmt.Errorf("resolve udp: %s: %w", addr, err)
-	}
-	if _, err = l.ln.WriteTo(b, udpAddr); err != nil {
-		return fmt.Errorf("write packet: %s: %w", addr, err)
+	if err := l.transport.WriteTo(b, addr); err != nil {
+		return err
 	}
 
 	l.metrics.PacketBytesOutbound.Add(float64(len(b)))
@@ -358,21 +462,21 @@ func (l *packetListener) sendDigest(
 
 	localMeta := l.state.LocalNodeMetadata()
 	header := digestHeader{
-		NodeID:  localMeta.ID,
-		Addr:    localMeta.Addr,
-		Request: request,
-	}
-	b, err := encodeDigest(header, digest, l.maxPacketSize)
+		NodeID:     localMeta.ID,
+		Addr:       localMeta.Addr,
+		PacketAddr: l.state.LocalPacketAddr(),
+		Request:    request,
+	}
+	b, err := encodeDigest(
+		header, digest, l.maxPacketSize-l.auth.Overhead(),
+	)
 	if err != nil {
 		return fmt.Errorf("encode: %w", err)
 	}
+	b = l.auth.SignPacket(b)
 
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
-	if err != nil {
-		return fmt.Errorf("resolve udp: %s: %w", addr, err)
-	}
-	if _, err = l.ln.WriteTo(b, udpAddr); err != nil {
-		return fmt.Errorf("write packet: %s: %w", addr, err)
+	if err := l.transport.WriteTo(b, addr); err != nil {
+		return err
 	}
 
 	l.metrics.PacketBytesOutbound.Add(float64(len(b)))
@@ -27,6 +27,26 @@ type Metrics struct {
 	// connection.
 	PacketBytesOutbound prometheus.Counter
 
+	// FullSyncsTotal is the total number of full state syncs initiated by
+	// this node, labelled by result ('success' or 'failure').
+	FullSyncsTotal *prometheus.CounterVec
+
+	// NodesUnreachableTotal is the total number of times a remote node was
+	// detected as unreachable.
+	NodesUnreachableTotal prometheus.Counter
+
+	// NodesReachableTotal is the total number of times an unreachable remote
+	// node recovered.
+	NodesReachableTotal prometheus.Counter
+
+	// NodesExpiredTotal is the total number of left or unreachable remote
+	// nodes that were removed after expiring.
+	NodesExpiredTotal prometheus.Counter
+
+	// AuthFailuresTotal is the total number of rejected messages from
+	// unauthenticated nodes, labelled by transport ('stream' or 'packet').
+	AuthFailuresTotal *prometheus.CounterVec
+
 	// Entries is the number of entries labelled by node_id, deleted and
 	// internal.
 	Entries *prometheus.GaugeVec
@@ -82,6 +102,48 @@ func newMetrics() *Metrics {
 				Help:      "Total number of written bytes via a packet connection",
 			},
 		),
+		FullSyncsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "gossip",
+				Name:      "full_syncs_total",
+				Help:      "Total number of full state syncs",
+			},
+			[]string{"result"},
+		),
+		NodesUnreachableTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "gossip",
+				Name:      "nodes_unreachable_total",
+				Help:      "Total number of times a node was detected as unreachable",
+			},
+		),
+		NodesReachableTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "gossip",
+				Name:      "nodes_reachable_total",
+				Help:      "Total number of times an unreachable node recovered",
+			},
+		),
+		NodesExpiredTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "gossip",
+				Name:      "nodes_expired_total",
+				Help:      "Total number of left or unreachable nodes removed",
+			},
+		),
+		AuthFailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "gossip",
+				Name:      "auth_failures_total",
+				Help:      "Total number of rejected messages from unauthenticated nodes",
+			},
+			[]string{"transport"},
+		),
 		Entries: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: "piko",
@@ -102,6 +164,11 @@ func (m *Metrics) Register(reg *prometheus.Registry) {
 		m.ConnectionsOutbound,
 		m.StreamBytesOutbound,
 		m.PacketBytesOutbound,
+		m.FullSyncsTotal,
+		m.NodesUnreachableTotal,
+		m.NodesReachableTotal,
+		m.NodesExpiredTotal,
+		m.AuthFailuresTotal,
 		m.Entries,
 	)
 }
@@ -16,6 +16,7 @@ const (
 	messageTypeDelta
 	messageTypeJoin
 	messageTypeLeave
+	messageTypePacket
 )
 
 func (t messageType) String() string {
@@ -28,6 +29,8 @@ func (t messageType) String() string {
 		return "join"
 	case messageTypeLeave:
 		return "leave"
+	case messageTypePacket:
+		return "packet"
 	default:
 		return "unknown"
 	}
@@ -312,9 +315,12 @@ func decodeDelta(b []byte) (deltaHeader, delta, error) {
 }
 
 type digestHeader struct {
-	NodeID  string `codec:"node_id"`
-	Addr    string `codec:"addr"`
-	Request bool   `codec:"request"`
+	NodeID string `codec:"node_id"`
+	Addr   string `codec:"addr"`
+	// PacketAddr is the address to send response packets to if it differs
+	// from Addr.
+	PacketAddr string `codec:"packet_addr,omitempty"`
+	Request    bool   `codec:"request"`
 }
 
 type deltaHeader struct {
@@ -17,9 +17,9 @@ const (
 	// compaction.
 	compactKey = "_internal:compact"
 
-	// nodeExpiry is the duration a left or unreachable node is stored until it
-	// is is removed.
-	nodeExpiry = time.Minute
+	// packetAddrKey contains the address to send packets to the node if it
+	// differs from the node address.
+	packetAddrKey = "_internal:packet_addr"
 )
 
 // Entry represents a versioned key-value pair state.
@@ -115,6 +115,10 @@ type clusterState struct {
 
 	failureDetector failureDetector
 
+	// nodeExpiry is the duration a left or unreachable node is stored until
+	// it is removed.
+	nodeExpiry time.Duration
+
 	metrics *Metrics
 
 	watcher Watcher
@@ -125,6 +129,7 @@ func newClusterState(
 	localID string,
 	localAddr string,
 	failureDetector failureDetector,
+	nodeExpiry time.Duration,
 	metrics *Metrics,
 	watcher Watcher,
 ) *clusterState {
@@ -142,6 +147,7 @@ func newClusterState(
 		localID:         localID,
 		nodes:           nodes,
 		failureDetector: failureDetector,
+		nodeExpiry:      nodeExpiry,
 		metrics:         metrics,
 		watcher:         watcher,
 	}
@@ -272,6 +278,50 @@ func (s *clusterState) DeleteLocal(key string) {
 	s.metricsUpsertEntry(state.ID, state.Entries[key], existing)
 }
 
+// SetLocalPacketAddr sets the address other nodes should send packets to
+// the local node, when it differs from the local node address.
+func (s *clusterState) SetLocalPacketAddr(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.nodes[s.localID]
+
+	state.Version++
+	existing := state.Entries[packetAddrKey]
+	state.Entries[packetAddrKey] = Entry{
+		Key:      packetAddrKey,
+		Value:    addr,
+		Version:  state.Version,
+		Internal: true,
+	}
+
+	s.metricsUpsertEntry(state.ID, state.Entries[packetAddrKey], existing)
+}
+
+// LocalPacketAddr returns the address to send packets to the local node, or
+// an empty string if it is the same as the local node address.
+func (s *clusterState) LocalPacketAddr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.nodes[s.localID].Entries[packetAddrKey].Value
+}
+
+// PacketAddr returns the address to send packets to the given node.
+func (s *clusterState) PacketAddr(node NodeMetadata) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.nodes[node.ID]
+	if !ok {
+		return node.Addr
+	}
+	if entry, ok := state.Entries[packetAddrKey]; ok && entry.Value != "" {
+		return entry.Value
+	}
+	return node.Addr
+}
+
 // LeaveLocal updates the local node state to indicate the node has left the
 // cluster.
 func (s *clusterState) LeaveLocal() {
@@ -531,7 +581,7 @@ func (s *clusterState) applyDeltaEntry(entry deltaEntry) {
 		if e.Internal {
 			if e.Key == leftKey {
 				state.Left = true
-				state.Expiry = time.Now().Add(nodeExpiry)
+				state.Expiry = time.Now().Add(s.nodeExpiry)
 
 				s.watcher.OnLeave(entry.ID)
 			} else if e.Key == compactKey {
@@ -587,6 +637,8 @@ func (s *clusterState) RemoveExpiredAt(t time.Time) {
 			"node_id": id,
 		})
 
+		s.metrics.NodesExpiredTotal.Inc()
+
 		s.watcher.OnExpired(id)
 		s.failureDetector.Remove(id)
 	}
@@ -605,13 +657,15 @@ func (s *clusterState) UpdateLiveness(suspicionThreshold float64) {
 		if suspicionLevel > suspicionThreshold {
 			if !node.Unreachable {
 				node.Unreachable = true
-				node.Expiry = time.Now().Add(nodeExpiry)
+				node.Expiry = time.Now().Add(s.nodeExpiry)
+				s.metrics.NodesUnreachableTotal.Inc()
 				s.watcher.OnUnreachable(node.ID)
 			}
 		} else {
 			if node.Unreachable {
 				node.Unreachable = false
 				node.Expiry = time.Time{}
+				s.metrics.NodesReachableTotal.Inc()
 				s.watcher.OnReachable(node.ID)
 			}
 		}
@@ -5,13 +5,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestClusterState_LocalState(t *testing.T) {
 	t.Run("initial state", func(t *testing.T) {
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 		node := clusterState.LocalNode()
 		assert.Equal(t, "node-1", node.ID)
@@ -24,7 +25,7 @@ func TestClusterState_LocalState(t *testing.T) {
 
 	t.Run("upsert", func(t *testing.T) {
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 
 		clusterState.UpsertLocal("k1", "v1")
@@ -46,7 +47,7 @@ func TestClusterState_LocalState(t *testing.T) {
 
 	t.Run("delete", func(t *testing.T) {
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 
 		clusterState.UpsertLocal("k1", "v1")
@@ -72,7 +73,7 @@ func TestClusterState_LocalState(t *testing.T) {
 func TestClusterState_ApplyDigest(t *testing.T) {
 	t.Run("apply", func(t *testing.T) {
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+			"node-1", "1.1.1.1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 
 		clusterState.ApplyDigest(digest{
@@ -101,7 +102,7 @@ func TestClusterState_ApplyDigest(t *testing.T) {
 
 	t.Run("ignore left", func(t *testing.T) {
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+			"node-1", "1.1.1.1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 
 		// Apply should ignore left nodes.
@@ -130,7 +131,7 @@ func TestClusterState_ApplyDigest(t *testing.T) {
 	t.Run("watch", func(t *testing.T) {
 		watcher := &fakeWatcher{}
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1", &fakeFailureDetector{}, newMetrics(), watcher,
+			"node-1", "1.1.1.1", &fakeFailureDetector{}, time.Minute, newMetrics(), watcher,
 		)
 
 		clusterState.ApplyDigest(digest{
@@ -149,7 +150,7 @@ func TestClusterState_ApplyDigest(t *testing.T) {
 func TestClusterState_ApplyDelta(t *testing.T) {
 	t.Run("apply", func(t *testing.T) {
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+			"node-1", "1.1.1.1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 
 		clusterState.ApplyDelta(delta{
@@ -237,7 +238,7 @@ func TestClusterState_ApplyDelta(t *testing.T) {
 	t.Run("watch", func(t *testing.T) {
 		watcher := &fakeWatcher{}
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1", &fakeFailureDetector{}, newMetrics(), watcher,
+			"node-1", "1.1.1.1", &fakeFailureDetector{}, time.Minute, newMetrics(), watcher,
 		)
 
 		clusterState.ApplyDelta(delta{
@@ -298,7 +299,7 @@ func TestClusterState_ApplyDelta(t *testing.T) {
 
 func TestClusterState_Digest(t *testing.T) {
 	clusterState := newClusterState(
-		"node-1", "1.1.1.1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+		"node-1", "1.1.1.1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 	)
 	clusterState.UpsertLocal("k1", "v1")
 	clusterState.UpsertLocal("k2", "v2")
@@ -339,7 +340,7 @@ func TestClusterState_Digest(t *testing.T) {
 
 func TestClusterState_Delta(t *testing.T) {
 	clusterState := newClusterState(
-		"node-1", "1.1.1.1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+		"node-1", "1.1.1.1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 	)
 	clusterState.UpsertLocal("k1", "v1")
 	clusterState.UpsertLocal("k2", "v2")
@@ -437,7 +438,7 @@ func TestClusterState_Delta(t *testing.T) {
 func TestClusterState_Leave(t *testing.T) {
 	t.Run("leave local", func(t *testing.T) {
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 		clusterState.LeaveLocal()
 
@@ -458,7 +459,7 @@ func TestClusterState_Leave(t *testing.T) {
 
 	t.Run("leave remote", func(t *testing.T) {
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 
 		// Add node-2.
@@ -502,7 +503,7 @@ func TestClusterState_Leave(t *testing.T) {
 	t.Run("watch", func(t *testing.T) {
 		watcher := &fakeWatcher{}
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, newMetrics(), watcher,
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, time.Minute, newMetrics(), watcher,
 		)
 
 		// Add node-2.
@@ -534,7 +535,7 @@ func TestClusterState_Leave(t *testing.T) {
 	t.Run("expire", func(t *testing.T) {
 		watcher := &fakeWatcher{}
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, newMetrics(), watcher,
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, time.Minute, newMetrics(), watcher,
 		)
 
 		// Add node-2.
@@ -559,7 +560,7 @@ func TestClusterState_Leave(t *testing.T) {
 			},
 		})
 
-		clusterState.RemoveExpiredAt(time.Now().Add(nodeExpiry * 2))
+		clusterState.RemoveExpiredAt(time.Now().Add(time.Minute * 2))
 
 		assert.Equal(t, []string{"node-2"}, watcher.joins)
 		assert.Equal(t, []string{"node-2"}, watcher.leaves)
@@ -570,7 +571,7 @@ func TestClusterState_Leave(t *testing.T) {
 func TestClusterState_Compact(t *testing.T) {
 	t.Run("compact local", func(t *testing.T) {
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 
 		clusterState.UpsertLocal("k1", "v1")
@@ -614,7 +615,7 @@ func TestClusterState_Compact(t *testing.T) {
 
 	t.Run("compact remote", func(t *testing.T) {
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, newMetrics(), newNopWatcher(),
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 
 		// Add entries.
@@ -669,7 +670,7 @@ func TestClusterState_Compact(t *testing.T) {
 	t.Run("watch", func(t *testing.T) {
 		watcher := &fakeWatcher{}
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, newMetrics(), watcher,
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{}, time.Minute, newMetrics(), watcher,
 		)
 
 		// Add entries.
@@ -720,7 +721,7 @@ func TestClusterState_UpdateLiveness(t *testing.T) {
 					"node-2": 15.0,
 					"node-3": 25.0,
 				},
-			}, newMetrics(), newNopWatcher(),
+			}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 		clusterState.ApplyDelta(delta{
 			{
@@ -749,7 +750,7 @@ func TestClusterState_UpdateLiveness(t *testing.T) {
 			"node-3": 25.0,
 		}
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{suspicionLevels}, newMetrics(), newNopWatcher(),
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{suspicionLevels}, time.Minute, newMetrics(), newNopWatcher(),
 		)
 		clusterState.ApplyDelta(delta{
 			{
@@ -782,8 +783,9 @@ func TestClusterState_UpdateLiveness(t *testing.T) {
 			"node-2": 25.0,
 		}
 		watcher := &fakeWatcher{}
+		metrics := newMetrics()
 		clusterState := newClusterState(
-			"node-1", "1.1.1.1:1", &fakeFailureDetector{suspicionLevels}, newMetrics(), watcher,
+			"node-1", "1.1.1.1:1", &fakeFailureDetector{suspicionLevels}, time.Minute, metrics, watcher,
 		)
 		clusterState.ApplyDelta(delta{
 			{
@@ -795,11 +797,13 @@ func TestClusterState_UpdateLiveness(t *testing.T) {
 		clusterState.UpdateLiveness(20.0)
 
 		assert.Equal(t, []string{"node-2"}, watcher.unreachables)
+		assert.Equal(t, 1.0, testutil.ToFloat64(metrics.NodesUnreachableTotal))
 
 		suspicionLevels["node-2"] = 5.0
 		clusterState.UpdateLiveness(20.0)
 
 		assert.Equal(t, []string{"node-2"}, watcher.reachables)
+		assert.Equal(t, 1.0, testutil.ToFloat64(metrics.NodesReachableTotal))
 	})
 }
 
@@ -0,0 +1,105 @@
+package gossip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// packetTransport sends gossip packets to other nodes.
+type packetTransport interface {
+	WriteTo(b []byte, addr string) error
+}
+
+// udpTransport sends packets using UDP.
+type udpTransport struct {
+	conn net.PacketConn
+}
+
+func newUDPTransport(conn net.PacketConn) *udpTransport {
+	return &udpTransport{
+		conn: conn,
+	}
+}
+
+func (t *udpTransport) WriteTo(b []byte, addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("resolve udp: %s: %w", addr, err)
+	}
+	if _, err = t.conn.WriteTo(b, udpAddr); err != nil {
+		return fmt.Errorf("write packet: %s: %w", addr, err)
+	}
+	return nil
+}
+
+// tcpTransport sends packets using a stream connection, for environments
+// where UDP is blocked.
+//
+// Each packet is sent using a new connection, containing the packet message
+// type followed by the length prefixed packet.
+type tcpTransport struct {
+	dialer *net.Dialer
+
+	auth *authenticator
+
+	metrics *Metrics
+}
+
+func newTCPTransport(
+	timeout time.Duration,
+	auth *authenticator,
+	metrics *Metrics,
+) *tcpTransport {
+	return &tcpTransport{
+		dialer: &net.Dialer{
+			Timeout: timeout,
+		},
+		auth:    auth,
+		metrics: metrics,
+	}
+}
+
+func (t *tcpTransport) WriteTo(b []byte, addr string) error {
+	conn, err := t.dialer.Dial("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("dial: %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(t.dialer.Timeout))
+
+	t.metrics.ConnectionsOutbound.Inc()
+
+	trackedWriter := newTrackedWriter(conn)
+	defer func() {
+		t.metrics.StreamBytesOutbound.Add(float64(trackedWriter.NumBytesWritten()))
+	}()
+
+	w := bufio.NewWriter(trackedWriter)
+
+	if err := w.WriteByte(byte(messageTypePacket)); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := w.WriteByte(supportedVersion); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	// The payload contains the length prefixed packet.
+	payload := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(payload, uint32(len(b)))
+	copy(payload[4:], b)
+	if _, err := t.auth.WriteStreamRequest(w, messageTypePacket, payload); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
+
+var _ packetTransport = &udpTransport{}
+var _ packetTransport = &tcpTransport{}
@@ -2,10 +2,86 @@ package log
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/pflag"
 )
 
+// FileConfig configures writing logs to a file.
+type FileConfig struct {
+	// Path is the path of the file to write logs to. If empty logs are
+	// written to stderr.
+	Path string `json:"path" yaml:"path"`
+
+	// MaxSize is the maximum size of the log file in megabytes before it is
+	// rotated. A size of 0 disables size based rotation.
+	MaxSize int `json:"max_size" yaml:"max_size"`
+
+	// RotateInterval is the maximum duration to write to the log file before
+	// it is rotated. An interval of 0 disables time based rotation.
+	RotateInterval time.Duration `json:"rotate_interval" yaml:"rotate_interval"`
+
+	// MaxBackups is the maximum number of rotated log files to keep. A value
+	// of 0 keeps all rotated files.
+	MaxBackups int `json:"max_backups" yaml:"max_backups"`
+}
+
+func (c *FileConfig) Validate() error {
+	if c.MaxSize < 0 {
+		return fmt.Errorf("max size cannot be negative")
+	}
+	if c.RotateInterval < 0 {
+		return fmt.Errorf("rotate interval cannot be negative")
+	}
+	if c.MaxBackups < 0 {
+		return fmt.Errorf("max backups cannot be negative")
+	}
+	return nil
+}
+
+func (c *FileConfig) RegisterFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&c.Path,
+		"log.file.path",
+		c.Path,
+		`
+Path of a file to write logs to, including access logs, instead of stderr.
+
+Log files are rotated based on '--log.file.max-size' and
+'--log.file.rotate-interval', where the rotated file is renamed with the
+time of rotation as a suffix, such as 'piko.log.2024-06-01T10-00-00.000'.`,
+	)
+	fs.IntVar(
+		&c.MaxSize,
+		"log.file.max-size",
+		c.MaxSize,
+		`
+Maximum size of the log file in megabytes before it is rotated.
+
+Set to 0 to disable size based rotation.`,
+	)
+	fs.DurationVar(
+		&c.RotateInterval,
+		"log.file.rotate-interval",
+		c.RotateInterval,
+		`
+Maximum duration to write to the log file before it is rotated, such as
+'24h' to rotate the log file daily.
+
+Set to 0 to disable time based rotation.`,
+	)
+	fs.IntVar(
+		&c.MaxBackups,
+		"log.file.max-backups",
+		c.MaxBackups,
+		`
+Maximum number of rotated log files to keep, where the oldest rotated files
+are removed.
+
+Set to 0 to keep all rotated log files.`,
+	)
+}
+
 type Config struct {
 	// Level is the minimum record level to log. Either 'debug', 'info', 'warn'
 	// or 'error'.
@@ -14,6 +90,12 @@ type Config struct {
 	// Subsystems enables debug logging on log records whose 'subsystem'
 	// matches one of the given values (overrides `Level`).
 	Subsystems []string `json:"subsystems" yaml:"subsystems"`
+
+	// Format is the log output format. Either 'json' or 'console'. Defaults
+	// to 'json'.
+	Format string `json:"format" yaml:"format"`
+
+	File FileConfig `json:"file" yaml:"file"`
 }
 
 func (c *Config) Validate() error {
@@ -23,6 +105,14 @@ func (c *Config) Validate() error {
 	if _, err := zapLevelFromString(c.Level); err != nil {
 		return err
 	}
+	switch c.Format {
+	case "", "json", "console":
+	default:
+		return fmt.Errorf("unsupported format: %s", c.Format)
+	}
+	if err := c.File.Validate(); err != nil {
+		return fmt.Errorf("file: %w", err)
+	}
 	return nil
 }
 
@@ -49,4 +139,17 @@ debug logs.
 
 Such as you can enable 'gossip' logs with '--log.subsystems gossip'.`,
 	)
+	fs.StringVar(
+		&c.Format,
+		"log.format",
+		c.Format,
+		`
+Log output format. Either 'json' or 'console'.
+
+'json' outputs structured logs, which is recommended for production. 'console'
+outputs human-friendly logs for local development, which are colored when
+written to stderr.`,
+	)
+
+	c.File.RegisterFlags(fs)
 }
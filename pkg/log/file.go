@@ -0,0 +1,185 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rotatedTimeFormat is the format of the suffix added to rotated log
+	// files.
+	rotatedTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+// rotatingFile is a log file that is rotated once it exceeds the configured
+// maximum size or has been written to for the configured rotate interval.
+//
+// When rotated, the current file is renamed with the time of rotation as a
+// suffix and a new file is created. Once the number of rotated files exceeds
+// the configured maximum, the oldest files are removed.
+type rotatingFile struct {
+	path string
+
+	maxSize        int64
+	rotateInterval time.Duration
+	maxBackups     int
+
+	file *os.File
+	size int64
+	// openedAt is the time the current file was opened.
+	openedAt time.Time
+
+	// mu protects the above fields.
+	mu sync.Mutex
+}
+
+func openRotatingFile(conf FileConfig) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:           conf.Path,
+		maxSize:        int64(conf.MaxSize) * 1024 * 1024,
+		rotateInterval: conf.RotateInterval,
+		maxBackups:     conf.MaxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.shouldRotate(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			return 0, fmt.Errorf("rotate: %w", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Sync()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+func (f *rotatingFile) shouldRotate(n int64) bool {
+	// Don't rotate an empty file, even if the write exceeds the maximum size.
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+n > f.maxSize {
+		return true
+	}
+	if f.rotateInterval > 0 && time.Since(f.openedAt) >= f.rotateInterval {
+		return true
+	}
+	return false
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	rotatedPath := f.path + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(f.path, rotatedPath); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	return f.removeBackups()
+}
+
+func (f *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("create dir: %w", err)
+	}
+
+	file, err := os.OpenFile(
+		f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644,
+	)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	return nil
+}
+
+// removeBackups removes the oldest rotated files exceeding the maximum
+// number of backups.
+func (f *rotatingFile) removeBackups() error {
+	if f.maxBackups == 0 {
+		return nil
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		return fmt.Errorf("list backups: %w", err)
+	}
+	if len(backups) <= f.maxBackups {
+		return nil
+	}
+	for _, backup := range backups[:len(backups)-f.maxBackups] {
+		if err := os.Remove(backup); err != nil {
+			return fmt.Errorf("remove backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// backups returns the paths of the rotated files, ordered from oldest to
+// newest.
+func (f *rotatingFile) backups() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(f.path) + "."
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, err := time.Parse(
+			rotatedTimeFormat, strings.TrimPrefix(name, prefix),
+		); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(f.path), name))
+	}
+	// Since the suffix is a fixed width timestamp, sorting by name sorts by
+	// the time of rotation.
+	sort.Strings(backups)
+	return backups, nil
+}
@@ -0,0 +1,110 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	t.Run("max size", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "piko.log")
+		f, err := openRotatingFile(FileConfig{
+			Path:    path,
+			MaxSize: 1,
+		})
+		require.NoError(t, err)
+		defer f.Close()
+
+		record := make([]byte, 600*1024)
+		_, err = f.Write(record)
+		assert.NoError(t, err)
+		// Exceeds the maximum size so rotates the file.
+		_, err = f.Write(record)
+		assert.NoError(t, err)
+
+		backups, err := f.backups()
+		require.NoError(t, err)
+		assert.Equal(t, 1, len(backups))
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(record)), info.Size())
+	})
+
+	t.Run("rotate interval", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "piko.log")
+		f, err := openRotatingFile(FileConfig{
+			Path:           path,
+			RotateInterval: time.Hour,
+		})
+		require.NoError(t, err)
+		defer f.Close()
+
+		_, err = f.Write([]byte("foo\n"))
+		assert.NoError(t, err)
+
+		f.openedAt = time.Now().Add(-time.Hour)
+		_, err = f.Write([]byte("bar\n"))
+		assert.NoError(t, err)
+
+		backups, err := f.backups()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(backups))
+
+		b, err := os.ReadFile(backups[0])
+		require.NoError(t, err)
+		assert.Equal(t, "foo\n", string(b))
+		b, err = os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "bar\n", string(b))
+	})
+
+	t.Run("max backups", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "piko.log")
+		f, err := openRotatingFile(FileConfig{
+			Path:       path,
+			MaxBackups: 2,
+		})
+		require.NoError(t, err)
+		defer f.Close()
+
+		for i := 0; i != 5; i++ {
+			_, err = f.Write([]byte("foo\n"))
+			assert.NoError(t, err)
+
+			f.mu.Lock()
+			assert.NoError(t, f.rotate())
+			f.mu.Unlock()
+
+			// Ensure each rotated file has a unique timestamp.
+			time.Sleep(time.Millisecond * 2)
+		}
+
+		backups, err := f.backups()
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(backups))
+	})
+
+	t.Run("append existing", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "piko.log")
+		require.NoError(t, os.WriteFile(path, []byte("foo\n"), 0o600))
+
+		f, err := openRotatingFile(FileConfig{
+			Path: path,
+		})
+		require.NoError(t, err)
+		defer f.Close()
+
+		_, err = f.Write([]byte("bar\n"))
+		assert.NoError(t, err)
+
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "foo\nbar\n", string(b))
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	stdlog "log"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -28,6 +29,9 @@ func (l *loggerWriter) Write(p []byte) (int, error) {
 // configured minimum level are logged. The log level can be overridden to
 // include all logs matching the enabled subsystems.
 //
+// The log level and enabled subsystems can be updated at runtime, which
+// applies to the logger and all loggers derived from it.
+//
 // Logger is a simplified zap.Logger (which uses zapcore). zap.Logger had to
 // be reimplemented to support overriding the log level filter.
 type Logger interface {
@@ -43,48 +47,152 @@ type Logger interface {
 	// StdLogger returns a standard library log.Logger that logs records using
 	// with the given level.
 	StdLogger(level zapcore.Level) *stdlog.Logger
+	// Level returns the minimum log level.
+	Level() string
+	// SetLevel updates the minimum log level.
+	SetLevel(lvl string) error
+	// Subsystems returns the enabled subsystems.
+	Subsystems() []string
+	// SetSubsystems updates the enabled subsystems.
+	SetSubsystems(subsystems []string)
+}
+
+// filter contains the log level and enabled subsystems, which is shared by
+// a logger and all loggers derived from it so can be updated at runtime.
+type filter struct {
+	level zap.AtomicLevel
+
+	subsystems atomic.Pointer[[]string]
+}
+
+func newFilter(lvl zapcore.Level, subsystems []string) *filter {
+	f := &filter{
+		level: zap.NewAtomicLevelAt(lvl),
+	}
+	f.SetSubsystems(subsystems)
+	return f
+}
+
+func (f *filter) Subsystems() []string {
+	return *f.subsystems.Load()
+}
+
+func (f *filter) SetSubsystems(subsystems []string) {
+	subsystems = append([]string(nil), subsystems...)
+	f.subsystems.Store(&subsystems)
+}
+
+func (f *filter) SubsystemEnabled(subsystem string) bool {
+	return subsystemMatch(subsystem, f.Subsystems())
 }
 
 type logger struct {
 	core zapcore.Core
 
-	subsystem         string
-	subsystemEnabled  bool
-	enabledSubsystems []string
+	subsystem string
+
+	filter *filter
 
 	errorOutput zapcore.WriteSyncer
 }
 
-// NewLogger creates a new logger filtering using the given log level and
-// enabled subsystems.
+// NewLogger creates a new logger writing to stderr, filtering using the
+// given log level and enabled subsystems.
 func NewLogger(lvl string, enabledSubsystems []string) (Logger, error) {
-	zapLevel, err := zapLevelFromString(lvl)
+	sink, _, err := zap.Open("stderr")
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("open sync: %w", err)
+	}
+	return newLogger(lvl, enabledSubsystems, newJSONEncoder(), sink)
+}
+
+// NewLoggerFromConfig creates a new logger using the given configuration.
+//
+// If a log file is configured logs are written to the file, otherwise logs
+// are written to stderr.
+//
+// Error logs are also reported to the given reporters, such as to report
+// errors to Sentry.
+func NewLoggerFromConfig(conf Config, reporters ...Reporter) (Logger, error) {
+	var sink zapcore.WriteSyncer
+	if conf.File.Path == "" {
+		stderr, _, err := zap.Open("stderr")
+		if err != nil {
+			return nil, fmt.Errorf("open sync: %w", err)
+		}
+		sink = stderr
+	} else {
+		file, err := openRotatingFile(conf.File)
+		if err != nil {
+			return nil, fmt.Errorf("log file: %w", err)
+		}
+		sink = file
 	}
 
+	var enc zapcore.Encoder
+	switch conf.Format {
+	case "", "json":
+		enc = newJSONEncoder()
+	case "console":
+		// Only color logs written to stderr, to avoid writing escape codes
+		// to log files.
+		enc = newConsoleEncoder(conf.File.Path == "")
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", conf.Format)
+	}
+
+	return newLogger(conf.Level, conf.Subsystems, enc, sink, reporters...)
+}
+
+func newJSONEncoder() zapcore.Encoder {
 	encoderConfig := zap.NewProductionEncoderConfig()
 	// Using the logger name for 'subsystem'.
 	encoderConfig.NameKey = "subsystem"
 	encoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout(
 		"2006-01-02T15:04:05.999Z07:00",
 	)
+	return zapcore.NewJSONEncoder(encoderConfig)
+}
 
-	enc := zapcore.NewJSONEncoder(encoderConfig)
-	sink, _, err := zap.Open("stderr")
+func newConsoleEncoder(color bool) zapcore.Encoder {
+	encoderConfig := zap.NewDevelopmentEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout(
+		"2006-01-02T15:04:05.000Z07:00",
+	)
+	if color {
+		encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+	return zapcore.NewConsoleEncoder(encoderConfig)
+}
+
+func newLogger(
+	lvl string,
+	enabledSubsystems []string,
+	enc zapcore.Encoder,
+	sink zapcore.WriteSyncer,
+	reporters ...Reporter,
+) (Logger, error) {
+	zapLevel, err := zapLevelFromString(lvl)
 	if err != nil {
-		return nil, fmt.Errorf("open sync: %w", err)
+		return nil, err
+	}
+
+	filter := newFilter(zapLevel, enabledSubsystems)
+	var inner zapcore.Core = zapcore.NewCore(enc, sink, filter.level)
+	if len(reporters) > 0 {
+		cores := []zapcore.Core{inner}
+		for _, reporter := range reporters {
+			cores = append(cores, newReporterCore(reporter))
+		}
+		inner = zapcore.NewTee(cores...)
 	}
-	core := &core{core: zapcore.NewCore(
-		enc, sink, zap.NewAtomicLevelAt(zapLevel),
-	)}
+	core := &core{core: inner}
 	return &logger{
 		core: core,
 		// Use 'main' as default subsystem.
-		subsystem:         "main",
-		subsystemEnabled:  subsystemMatch("main", enabledSubsystems),
-		enabledSubsystems: enabledSubsystems,
-		errorOutput:       zapcore.Lock(os.Stderr),
+		subsystem:   "main",
+		filter:      filter,
+		errorOutput: zapcore.Lock(os.Stderr),
 	}, nil
 }
 
@@ -99,7 +207,6 @@ func (l *logger) WithSubsystem(s string) Logger {
 
 	clone := l.clone()
 	clone.subsystem = s
-	clone.subsystemEnabled = subsystemMatch(s, clone.enabledSubsystems)
 	return clone
 }
 
@@ -151,6 +258,27 @@ func (l *logger) StdLogger(level zapcore.Level) *stdlog.Logger {
 	}, "", 0)
 }
 
+func (l *logger) Level() string {
+	return l.filter.level.Level().String()
+}
+
+func (l *logger) SetLevel(lvl string) error {
+	zapLevel, err := zapLevelFromString(lvl)
+	if err != nil {
+		return err
+	}
+	l.filter.level.SetLevel(zapLevel)
+	return nil
+}
+
+func (l *logger) Subsystems() []string {
+	return append([]string(nil), l.filter.Subsystems()...)
+}
+
+func (l *logger) SetSubsystems(subsystems []string) {
+	l.filter.SetSubsystems(subsystems)
+}
+
 func (l *logger) clone() *logger {
 	clone := *l
 	return &clone
@@ -158,7 +286,7 @@ func (l *logger) clone() *logger {
 
 func (l *logger) check(lvl zapcore.Level, msg string) *zapcore.CheckedEntry {
 	// Only filter by log level if the subsystem isn't enabled.
-	if !l.subsystemEnabled {
+	if !l.filter.SubsystemEnabled(l.subsystem) {
 		if lvl < zapcore.DPanicLevel && !l.core.Enabled(lvl) {
 			return nil
 		}
@@ -225,6 +353,22 @@ func (l *nopLogger) StdLogger(_ zapcore.Level) *stdlog.Logger {
 	}, "", 0)
 }
 
+func (l *nopLogger) Level() string {
+	return ""
+}
+
+func (l *nopLogger) SetLevel(lvl string) error {
+	_, err := zapLevelFromString(lvl)
+	return err
+}
+
+func (l *nopLogger) Subsystems() []string {
+	return nil
+}
+
+func (l *nopLogger) SetSubsystems(_ []string) {
+}
+
 func subsystemMatch(subsystem string, enabled []string) bool {
 	for _, s := range enabled {
 		if subsystem == s {
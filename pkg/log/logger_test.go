@@ -0,0 +1,67 @@
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLoggerFromConfig(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "piko.log")
+		logger, err := NewLoggerFromConfig(Config{
+			Level:  "info",
+			Format: "json",
+			File:   FileConfig{Path: path},
+		})
+		require.NoError(t, err)
+
+		logger.WithSubsystem("foo").Info("bar")
+		require.NoError(t, logger.Sync())
+
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(b, &record))
+		assert.Equal(t, "info", record["level"])
+		assert.Equal(t, "foo", record["subsystem"])
+		assert.Equal(t, "bar", record["msg"])
+	})
+
+	t.Run("console", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "piko.log")
+		logger, err := NewLoggerFromConfig(Config{
+			Level:  "info",
+			Format: "console",
+			File:   FileConfig{Path: path},
+		})
+		require.NoError(t, err)
+
+		logger.WithSubsystem("foo").Info("bar")
+		require.NoError(t, logger.Sync())
+
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		// Logs written to a file aren't colored.
+		fields := strings.Split(strings.TrimSpace(string(b)), "\t")
+		require.Equal(t, 4, len(fields))
+		assert.Equal(t, "INFO", fields[1])
+		assert.Equal(t, "foo", fields[2])
+		assert.Equal(t, "bar", fields[3])
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		_, err := NewLoggerFromConfig(Config{
+			Level:  "info",
+			Format: "foo",
+		})
+		assert.Error(t, err)
+	})
+}
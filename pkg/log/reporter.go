@@ -0,0 +1,82 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Reporter reports error logs to an external error reporting service, such
+// as Sentry.
+type Reporter interface {
+	// Report reports the given log record. The record fields are encoded as
+	// a map.
+	//
+	// Report must not block, such as by reporting asynchronously.
+	Report(entry zapcore.Entry, fields map[string]any)
+	// Flush waits for any pending reports to be sent, up to the given
+	// timeout.
+	Flush(timeout time.Duration)
+}
+
+const (
+	// reporterFlushTimeout is the maximum time to wait for pending reports
+	// to be sent when the logger is synced.
+	reporterFlushTimeout = time.Second * 2
+)
+
+// reporterCore is a zapcore.Core that reports error logs to a Reporter.
+type reporterCore struct {
+	reporter Reporter
+
+	// fields contains the fields added using With.
+	fields []zap.Field
+}
+
+func newReporterCore(reporter Reporter) *reporterCore {
+	return &reporterCore{
+		reporter: reporter,
+	}
+}
+
+func (c *reporterCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= zapcore.ErrorLevel
+}
+
+func (c *reporterCore) With(fields []zap.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(append([]zap.Field(nil), c.fields...), fields...)
+	return &clone
+}
+
+func (c *reporterCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+	return ce.AddCore(ent, c)
+}
+
+func (c *reporterCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	// Since the logger bypasses Check, filter by level on write.
+	if !c.Enabled(ent.Level) {
+		return nil
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(enc)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	c.reporter.Report(ent, enc.Fields)
+	return nil
+}
+
+func (c *reporterCore) Sync() error {
+	c.reporter.Flush(reporterFlushTimeout)
+	return nil
+}
+
+var _ zapcore.Core = &reporterCore{}
@@ -0,0 +1,72 @@
+package log
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type report struct {
+	entry  zapcore.Entry
+	fields map[string]any
+}
+
+type fakeReporter struct {
+	reports []report
+	flushed bool
+}
+
+func (r *fakeReporter) Report(entry zapcore.Entry, fields map[string]any) {
+	r.reports = append(r.reports, report{
+		entry:  entry,
+		fields: fields,
+	})
+}
+
+func (r *fakeReporter) Flush(_ time.Duration) {
+	r.flushed = true
+}
+
+func TestReporter(t *testing.T) {
+	t.Run("report errors", func(t *testing.T) {
+		reporter := &fakeReporter{}
+		logger, err := newLogger(
+			"debug", nil, newJSONEncoder(), zapcore.AddSync(io.Discard), reporter,
+		)
+		require.NoError(t, err)
+
+		logger = logger.WithSubsystem("foo").With(zap.String("a", "1"))
+		logger.Info("info")
+		logger.Warn("warn")
+		logger.Error("error", zap.Int("b", 2))
+
+		require.Equal(t, 1, len(reporter.reports))
+		assert.Equal(t, "error", reporter.reports[0].entry.Message)
+		assert.Equal(t, zapcore.ErrorLevel, reporter.reports[0].entry.Level)
+		assert.Equal(t, "foo", reporter.reports[0].entry.LoggerName)
+		assert.Equal(t, map[string]any{
+			"a": "1",
+			"b": int64(2),
+		}, reporter.reports[0].fields)
+
+		assert.NoError(t, logger.Sync())
+		assert.True(t, reporter.flushed)
+	})
+
+	t.Run("report filtered errors", func(t *testing.T) {
+		reporter := &fakeReporter{}
+		logger, err := newLogger(
+			"error", nil, newJSONEncoder(), zapcore.AddSync(io.Discard), reporter,
+		)
+		require.NoError(t, err)
+
+		// Errors are reported regardless of subsystem.
+		logger.WithSubsystem("foo").Error("error")
+		assert.Equal(t, 1, len(reporter.reports))
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/andydunstall/piko/pkg/telemetry"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -35,7 +36,7 @@ func NewMetrics(subsystem string) *Metrics {
 				Name:      "requests_total",
 				Help:      "Total requests.",
 			},
-			[]string{"status", "method"},
+			[]string{"status_class", "method"},
 		),
 		RequestLatency: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
@@ -45,7 +46,7 @@ func NewMetrics(subsystem string) *Metrics {
 				Help:      "Request latency.",
 				Buckets:   prometheus.DefBuckets,
 			},
-			[]string{"status", "method"},
+			[]string{"status_class", "method"},
 		),
 		RequestSize: prometheus.NewHistogram(
 			prometheus.HistogramOpts{
@@ -78,14 +79,21 @@ func (m *Metrics) Handler() gin.HandlerFunc {
 		// Process request.
 		c.Next()
 
+		statusClass := StatusClass(c.Writer.Status())
 		m.RequestsTotal.With(prometheus.Labels{
-			"status": strconv.Itoa(c.Writer.Status()),
-			"method": c.Request.Method,
+			"status_class": statusClass,
+			"method":       c.Request.Method,
 		}).Inc()
-		m.RequestLatency.With(prometheus.Labels{
-			"status": strconv.Itoa(c.Writer.Status()),
-			"method": c.Request.Method,
-		}).Observe(float64(time.Since(start).Milliseconds()) / 1000)
+		// Note the request may have been updated by later handlers, such as
+		// to add a span, so use the request from the context.
+		telemetry.ObserveWithExemplar(
+			c.Request.Context(),
+			m.RequestLatency.With(prometheus.Labels{
+				"status_class": statusClass,
+				"method":       c.Request.Method,
+			}),
+			float64(time.Since(start).Milliseconds())/1000,
+		)
 		m.RequestSize.Observe(float64(computeApproximateRequestSize(c.Request)))
 		m.ResponseSize.Observe(float64(c.Writer.Size()))
 	}
@@ -101,6 +109,15 @@ func (m *Metrics) Register(registry *prometheus.Registry) {
 	)
 }
 
+// StatusClass returns the class of the HTTP status code, such as '2xx', used
+// as the 'status_class' metric label.
+func StatusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
 func computeApproximateRequestSize(r *http.Request) int {
 	s := 0
 	if r.URL != nil {
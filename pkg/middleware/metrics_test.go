@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "1xx", StatusClass(http.StatusSwitchingProtocols))
+	assert.Equal(t, "2xx", StatusClass(http.StatusOK))
+	assert.Equal(t, "5xx", StatusClass(http.StatusBadGateway))
+	assert.Equal(t, "unknown", StatusClass(0))
+}
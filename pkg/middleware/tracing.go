@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"github.com/andydunstall/piko/pkg/telemetry"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// NewTracing creates a middleware that records an OpenTelemetry span for
+// each request, with the given span name and attributes.
+func NewTracing(spanName string, attrs ...attribute.KeyValue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r, span := telemetry.StartSpan(c.Request, spanName, attrs...)
+		c.Request = r
+
+		// Process request.
+		c.Next()
+
+		telemetry.EndSpan(span, c.Writer.Status())
+	}
+}
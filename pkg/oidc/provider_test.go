@@ -0,0 +1,183 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &fakeProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": "key-1",
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e": base64.RawURLEncoding.EncodeToString(
+						big.NewInt(int64(key.E)).Bytes(),
+					),
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if clientID != "my-client" || clientSecret != "my-secret" ||
+			r.FormValue("code") != "my-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"id_token": p.sign(t, "key-1", jwt.MapClaims{
+				"iss":    p.server.URL,
+				"aud":    "my-client",
+				"sub":    "user-1",
+				"exp":    time.Now().Add(time.Hour).Unix(),
+				"groups": []string{"g1", "g2"},
+			}),
+		})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *fakeProvider) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(p.key)
+	require.NoError(t, err)
+	return s
+}
+
+func TestProvider(t *testing.T) {
+	t.Run("auth code url", func(t *testing.T) {
+		fake := newFakeProvider(t)
+		p := NewProvider(Config{
+			IssuerURL:   fake.server.URL,
+			ClientID:    "my-client",
+			RedirectURL: "https://piko.example.com/oidc/callback",
+			Scopes:      []string{"openid", "email"},
+		})
+
+		authURL, err := p.AuthCodeURL(context.Background(), "my-state", "my-nonce")
+		require.NoError(t, err)
+
+		u, err := url.Parse(authURL)
+		require.NoError(t, err)
+		assert.Equal(t, "/authorize", u.Path)
+		assert.Equal(t, "code", u.Query().Get("response_type"))
+		assert.Equal(t, "my-client", u.Query().Get("client_id"))
+		assert.Equal(t, "openid email", u.Query().Get("scope"))
+		assert.Equal(t, "my-state", u.Query().Get("state"))
+		assert.Equal(t, "my-nonce", u.Query().Get("nonce"))
+	})
+
+	t.Run("exchange", func(t *testing.T) {
+		fake := newFakeProvider(t)
+		p := NewProvider(Config{
+			IssuerURL:    fake.server.URL,
+			ClientID:     "my-client",
+			ClientSecret: "my-secret",
+		})
+
+		claims, err := p.Exchange(context.Background(), "my-code")
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", claims.Subject())
+		assert.Equal(t, []string{"g1", "g2"}, claims.Strings("groups"))
+
+		_, err = p.Exchange(context.Background(), "unknown")
+		assert.Error(t, err)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		fake := newFakeProvider(t)
+		p := NewProvider(Config{
+			IssuerURL: fake.server.URL,
+			ClientID:  "my-client",
+		})
+
+		validClaims := func() jwt.MapClaims {
+			return jwt.MapClaims{
+				"iss": fake.server.URL,
+				"aud": "my-client",
+				"sub": "user-1",
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}
+		}
+
+		claims, err := p.Verify(
+			context.Background(), fake.sign(t, "key-1", validClaims()),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", claims.Subject())
+
+		// Wrong audience.
+		c := validClaims()
+		c["aud"] = "other-client"
+		_, err = p.Verify(context.Background(), fake.sign(t, "key-1", c))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		// Wrong issuer.
+		c = validClaims()
+		c["iss"] = "https://other.example.com"
+		_, err = p.Verify(context.Background(), fake.sign(t, "key-1", c))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		// Expired.
+		c = validClaims()
+		c["exp"] = time.Now().Add(-time.Hour).Unix()
+		_, err = p.Verify(context.Background(), fake.sign(t, "key-1", c))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		// Unknown key.
+		_, err = p.Verify(
+			context.Background(), fake.sign(t, "key-2", validClaims()),
+		)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		// Signed by a different key.
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims())
+		token.Header["kid"] = "key-1"
+		s, err := token.SignedString(otherKey)
+		require.NoError(t, err)
+		_, err = p.Verify(context.Background(), s)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
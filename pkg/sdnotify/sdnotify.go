@@ -0,0 +1,46 @@
+// Package sdnotify implements the systemd service notification protocol
+// (sd_notify), so a service with 'Type=notify' can report its state to
+// systemd.
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+const (
+	// Ready tells the service manager the service has finished starting up.
+	Ready = "READY=1"
+
+	// Stopping tells the service manager the service is shutting down.
+	Stopping = "STOPPING=1"
+)
+
+// Notify sends the given state to the service manager.
+//
+// The service manager socket is configured by the 'NOTIFY_SOCKET' environment
+// variable. If the variable isn't set, such as when not running under systemd,
+// Notify does nothing.
+func Notify(state string) error {
+	socketAddr := os.Getenv("NOTIFY_SOCKET")
+	if socketAddr == "" {
+		return nil
+	}
+
+	// Note abstract sockets (starting with '@') are handled by the net
+	// package.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: socketAddr,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package sdnotify
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	t.Run("notify", func(t *testing.T) {
+		socketAddr := filepath.Join(t.TempDir(), "notify.sock")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{
+			Name: socketAddr,
+			Net:  "unixgram",
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		t.Setenv("NOTIFY_SOCKET", socketAddr)
+
+		assert.NoError(t, Notify(Ready))
+
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, Ready, string(buf[:n]))
+	})
+
+	t.Run("no socket", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", "")
+
+		assert.NoError(t, Notify(Ready))
+	})
+
+	t.Run("socket not found", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "notify.sock"))
+
+		assert.Error(t, Notify(Ready))
+	})
+}
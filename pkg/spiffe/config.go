@@ -0,0 +1,79 @@
+package spiffe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// Config configures using a SPIFFE X.509 SVID from the SPIFFE Workload API
+// for mutual TLS.
+type Config struct {
+	// WorkloadAPIAddr is the address of the SPIFFE Workload API, such as
+	// 'unix:///run/spire/agent.sock'. If empty, SPIFFE is disabled.
+	WorkloadAPIAddr string `json:"workload_api_addr" yaml:"workload_api_addr"`
+
+	// TrustDomains are the trust domains of peers to accept.
+	//
+	// Defaults to the workload's own trust domain.
+	TrustDomains []string `json:"trust_domains" yaml:"trust_domains"`
+}
+
+func (c *Config) Enabled() bool {
+	return c.WorkloadAPIAddr != ""
+}
+
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		if len(c.TrustDomains) != 0 {
+			return fmt.Errorf("trust domains configured without workload api addr")
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(c.WorkloadAPIAddr, "unix://") &&
+		!strings.HasPrefix(c.WorkloadAPIAddr, "tcp://") {
+		return fmt.Errorf("workload api addr must have scheme 'unix' or 'tcp'")
+	}
+	for _, td := range c.TrustDomains {
+		if !ValidTrustDomain(td) {
+			return fmt.Errorf("invalid trust domain: %s", td)
+		}
+	}
+	return nil
+}
+
+// RegisterFlags registers the flags with the given prefix, such as
+// 'connect' registers '--connect.spiffe.workload-api-addr'. If the prefix is
+// empty the flags are registered under 'spiffe'.
+func (c *Config) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	if prefix != "" {
+		prefix = prefix + "."
+	}
+	prefix = prefix + "spiffe."
+	fs.StringVar(
+		&c.WorkloadAPIAddr,
+		prefix+"workload-api-addr",
+		c.WorkloadAPIAddr,
+		`
+Address of the SPIFFE Workload API to fetch an X.509 SVID from, such as
+'unix:///run/spire/agent.sock' or 'tcp://127.0.0.1:8081'.
+
+The SVID and trust bundle are rotated automatically as the Workload API
+pushes updates.
+
+If not set SPIFFE is disabled.`,
+	)
+	fs.StringSliceVar(
+		&c.TrustDomains,
+		prefix+"trust-domains",
+		c.TrustDomains,
+		`
+Trust domains of peers to accept, such as 'example.org'. Peers must present
+an X.509 SVID from one of the trust domains, signed by the trust domains
+bundle (including federated bundles).
+
+Defaults to the trust domain of the local SVID.`,
+	)
+}
@@ -0,0 +1,66 @@
+package spiffe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		conf Config
+		err  string
+	}{
+		{
+			name: "disabled",
+			conf: Config{},
+		},
+		{
+			name: "unix",
+			conf: Config{
+				WorkloadAPIAddr: "unix:///run/spire/agent.sock",
+				TrustDomains:    []string{"example.org"},
+			},
+		},
+		{
+			name: "tcp",
+			conf: Config{
+				WorkloadAPIAddr: "tcp://127.0.0.1:8081",
+			},
+		},
+		{
+			name: "missing scheme",
+			conf: Config{
+				WorkloadAPIAddr: "/run/spire/agent.sock",
+			},
+			err: "workload api addr must have scheme",
+		},
+		{
+			name: "invalid trust domain",
+			conf: Config{
+				WorkloadAPIAddr: "unix:///run/spire/agent.sock",
+				TrustDomains:    []string{"Example.org"},
+			},
+			err: "invalid trust domain",
+		},
+		{
+			name: "trust domains without workload api",
+			conf: Config{
+				TrustDomains: []string{"example.org"},
+			},
+			err: "trust domains configured without workload api addr",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.conf.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
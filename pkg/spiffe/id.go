@@ -0,0 +1,105 @@
+package spiffe
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ID is a SPIFFE ID, such as 'spiffe://example.org/piko/server'.
+type ID struct {
+	TrustDomain string
+	Path        string
+}
+
+// ParseID parses the given SPIFFE ID URI.
+func ParseID(s string) (ID, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ID{}, fmt.Errorf("invalid spiffe id: %w", err)
+	}
+	return idFromURL(u)
+}
+
+// IDFromCertificate returns the SPIFFE ID of the given X.509 SVID, which
+// must contain exactly one URI SAN.
+func IDFromCertificate(cert *x509.Certificate) (ID, error) {
+	if len(cert.URIs) == 0 {
+		return ID{}, fmt.Errorf("certificate missing spiffe id")
+	}
+	if len(cert.URIs) > 1 {
+		return ID{}, fmt.Errorf("certificate contains multiple uri sans")
+	}
+	return idFromURL(cert.URIs[0])
+}
+
+func (id ID) String() string {
+	return "spiffe://" + id.TrustDomain + id.Path
+}
+
+// MemberOf returns whether the ID is in one of the given trust domains.
+func (id ID) MemberOf(trustDomains []string) bool {
+	for _, td := range trustDomains {
+		if id.TrustDomain == td {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidTrustDomain returns whether the trust domain name is valid, which
+// may only contain lowercase letters, numbers, dots, dashes and
+// underscores.
+func ValidTrustDomain(td string) bool {
+	if td == "" {
+		return false
+	}
+	for _, c := range td {
+		if !isTrustDomainChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func idFromURL(u *url.URL) (ID, error) {
+	if u.Scheme != "spiffe" {
+		return ID{}, fmt.Errorf("invalid spiffe id: scheme must be 'spiffe'")
+	}
+	if u.User != nil || u.Port() != "" {
+		return ID{}, fmt.Errorf("invalid spiffe id: unexpected user info or port")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return ID{}, fmt.Errorf("invalid spiffe id: unexpected query or fragment")
+	}
+	if !ValidTrustDomain(u.Host) {
+		return ID{}, fmt.Errorf("invalid spiffe id: invalid trust domain")
+	}
+	if u.Path != "" {
+		for _, segment := range strings.Split(u.Path, "/")[1:] {
+			if segment == "" || segment == "." || segment == ".." {
+				return ID{}, fmt.Errorf("invalid spiffe id: invalid path")
+			}
+			for _, c := range segment {
+				if !isPathChar(c) {
+					return ID{}, fmt.Errorf("invalid spiffe id: invalid path")
+				}
+			}
+		}
+	}
+	return ID{
+		TrustDomain: u.Host,
+		Path:        u.Path,
+	}, nil
+}
+
+func isTrustDomainChar(c rune) bool {
+	return (c >= 'a' && c <= 'z') ||
+		(c >= '0' && c <= '9') ||
+		c == '.' || c == '-' || c == '_'
+}
+
+func isPathChar(c rune) bool {
+	return isTrustDomainChar(c) || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,60 @@
+package spiffe
+
+import (
+	"crypto/x509"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseID(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		id, err := ParseID("spiffe://example.org/piko/server")
+		require.NoError(t, err)
+		assert.Equal(t, "example.org", id.TrustDomain)
+		assert.Equal(t, "/piko/server", id.Path)
+		assert.Equal(t, "spiffe://example.org/piko/server", id.String())
+
+		id, err = ParseID("spiffe://example.org")
+		require.NoError(t, err)
+		assert.Equal(t, "example.org", id.TrustDomain)
+		assert.Equal(t, "", id.Path)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{
+			"",
+			"https://example.org/piko",
+			"spiffe:///piko",
+			"spiffe://Example.org/piko",
+			"spiffe://example.org:8080/piko",
+			"spiffe://user@example.org/piko",
+			"spiffe://example.org/piko?foo=bar",
+			"spiffe://example.org/piko#foo",
+			"spiffe://example.org/piko/",
+			"spiffe://example.org//piko",
+			"spiffe://example.org/piko/../server",
+			"spiffe://example.org/pi%20ko",
+		} {
+			_, err := ParseID(s)
+			assert.Error(t, err, s)
+		}
+	})
+}
+
+func TestIDFromCertificate(t *testing.T) {
+	u1, _ := url.Parse("spiffe://example.org/foo")
+	u2, _ := url.Parse("spiffe://example.org/bar")
+
+	id, err := IDFromCertificate(&x509.Certificate{URIs: []*url.URL{u1}})
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/foo", id.String())
+
+	_, err = IDFromCertificate(&x509.Certificate{})
+	assert.Error(t, err)
+
+	_, err = IDFromCertificate(&x509.Certificate{URIs: []*url.URL{u1, u2}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,240 @@
+// Package spiffe implements fetching X.509 SVIDs from the SPIFFE Workload API
+// and using them for mutual TLS.
+package spiffe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/backoff"
+	"github.com/andydunstall/piko/pkg/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	minReconnectBackoff = time.Millisecond * 100
+	maxReconnectBackoff = time.Second * 15
+)
+
+var fetchX509SVIDStreamDesc = grpc.StreamDesc{
+	StreamName:    "FetchX509SVID",
+	ServerStreams: true,
+}
+
+// Source fetches the workload's X.509 SVID and trust bundles from the SPIFFE
+// Workload API.
+//
+// The Workload API pushes a new SVID and bundles whenever they are rotated,
+// so TLS configurations returned by the source always use the latest SVID
+// without having to reload.
+type Source struct {
+	conf Config
+
+	x509Context atomic.Pointer[X509Context]
+
+	conn *grpc.ClientConn
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	logger log.Logger
+}
+
+// NewSource connects to the Workload API and blocks until the first SVID is
+// received or the context is cancelled.
+//
+// The source keeps watching for updates until closed.
+func NewSource(ctx context.Context, conf Config, logger log.Logger) (*Source, error) {
+	logger = logger.WithSubsystem("spiffe")
+
+	// gRPC supports 'unix://' targets, though TCP addresses must not have a
+	// scheme.
+	target := strings.TrimPrefix(conf.WorkloadAPIAddr, "tcp://")
+	conn, err := grpc.NewClient(
+		target, grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("workload api: %s: %w", conf.WorkloadAPIAddr, err)
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	s := &Source{
+		conf:   conf,
+		conn:   conn,
+		cancel: cancel,
+		logger: logger,
+	}
+
+	updateCh := make(chan struct{}, 1)
+	errCh := make(chan error, 1)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(runCtx, updateCh, errCh)
+	}()
+
+	var lastErr error
+	for {
+		select {
+		case <-updateCh:
+			return s, nil
+		case err := <-errCh:
+			lastErr = err
+		case <-ctx.Done():
+			s.Close()
+			if lastErr != nil {
+				return nil, fmt.Errorf("fetch svid: %w", lastErr)
+			}
+			return nil, fmt.Errorf("fetch svid: %w", ctx.Err())
+		}
+	}
+}
+
+// X509Context returns the latest SVID and bundles.
+func (s *Source) X509Context() *X509Context {
+	return s.x509Context.Load()
+}
+
+// ServerTLSConfig returns a TLS configuration that presents the workload's
+// SVID and requires clients to present an SVID from an accepted trust
+// domain.
+func (s *Source) ServerTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.X509Context().Certificate, nil
+		},
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: s.verifyPeerCertificate,
+	}
+}
+
+// ClientTLSConfig returns a TLS configuration that presents the workload's
+// SVID and requires the server to present an SVID from an accepted trust
+// domain.
+func (s *Source) ClientTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return s.X509Context().Certificate, nil
+		},
+		// The server is verified by its SPIFFE ID using the trust bundles
+		// in VerifyPeerCertificate, rather than by hostname using the host
+		// root CAs.
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: s.verifyPeerCertificate,
+	}
+}
+
+// Close stops watching for updates and closes the Workload API connection.
+func (s *Source) Close() error {
+	s.cancel()
+	err := s.conn.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Source) verifyPeerCertificate(
+	rawCerts [][]byte,
+	_ [][]*x509.Certificate,
+) error {
+	x509Context := s.X509Context()
+	trustDomains := s.conf.TrustDomains
+	if len(trustDomains) == 0 {
+		trustDomains = []string{x509Context.ID.TrustDomain}
+	}
+	_, err := x509Context.VerifyPeer(rawCerts, trustDomains)
+	return err
+}
+
+// run watches for SVID updates, reconnecting to the Workload API with
+// backoff if the stream fails.
+func (s *Source) run(
+	ctx context.Context,
+	updateCh chan<- struct{},
+	errCh chan<- error,
+) {
+	b := backoff.New(0, minReconnectBackoff, maxReconnectBackoff)
+	for {
+		received, err := s.watch(ctx, updateCh)
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.logger.Warn("workload api stream failed", zap.Error(err))
+		select {
+		case errCh <- err:
+		default:
+		}
+
+		if received {
+			b = backoff.New(0, minReconnectBackoff, maxReconnectBackoff)
+		}
+		if !b.Wait(ctx) {
+			return
+		}
+	}
+}
+
+// watch opens a stream to fetch SVIDs and updates the source with each
+// received SVID until the stream fails. Returns whether any SVIDs were
+// received.
+func (s *Source) watch(ctx context.Context, updateCh chan<- struct{}) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, workloadAPIHeader, "true")
+	stream, err := s.conn.NewStream(
+		ctx, &fetchX509SVIDStreamDesc, fetchX509SVIDMethod,
+		grpc.ForceCodec(rawCodec{}),
+	)
+	if err != nil {
+		return false, err
+	}
+	// The 'X509SVIDRequest' message is empty.
+	req := []byte{}
+	if err := stream.SendMsg(&req); err != nil {
+		return false, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return false, err
+	}
+
+	received := false
+	for {
+		var resp []byte
+		if err := stream.RecvMsg(&resp); err != nil {
+			return received, err
+		}
+
+		x509Context, err := parseX509SVIDResponse(resp)
+		if err != nil {
+			// Keep using the previous SVID.
+			s.logger.Warn("invalid workload api response", zap.Error(err))
+			continue
+		}
+
+		s.x509Context.Store(x509Context)
+		received = true
+
+		s.logger.Info(
+			"updated svid",
+			zap.String("spiffe-id", x509Context.ID.String()),
+			zap.Time("expiry", x509Context.Certificate.Leaf.NotAfter),
+		)
+
+		select {
+		case updateCh <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package spiffe
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeWorkloadAPI is a Workload API server that sends the responses written
+// to responses.
+type fakeWorkloadAPI struct {
+	responses chan []byte
+}
+
+func newFakeWorkloadAPI(t *testing.T) (*fakeWorkloadAPI, string) {
+	api := &fakeWorkloadAPI{
+		responses: make(chan []byte, 8),
+	}
+
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	server := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}))
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "SpiffeWorkloadAPI",
+		HandlerType: (*any)(nil),
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName:    "FetchX509SVID",
+				Handler:       api.fetchX509SVID,
+				ServerStreams: true,
+			},
+		},
+	}, api)
+	go func() {
+		_ = server.Serve(ln)
+	}()
+	t.Cleanup(server.Stop)
+
+	return api, "unix://" + path
+}
+
+func (api *fakeWorkloadAPI) fetchX509SVID(_ any, stream grpc.ServerStream) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if v := md.Get(workloadAPIHeader); len(v) != 1 || v[0] != "true" {
+		return status.Error(codes.InvalidArgument, "missing security header")
+	}
+
+	var req []byte
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case resp := <-api.responses:
+			if err := stream.SendMsg(&resp); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// encodeX509SVIDResponse encodes an 'X509SVIDResponse' message.
+func encodeX509SVIDResponse(
+	id string,
+	svid []byte,
+	key []byte,
+	bundle []byte,
+	federatedBundles map[string][]byte,
+) []byte {
+	var svidMsg []byte
+	svidMsg = protowire.AppendTag(svidMsg, x509SVIDSpiffeID, protowire.BytesType)
+	svidMsg = protowire.AppendString(svidMsg, id)
+	svidMsg = protowire.AppendTag(svidMsg, x509SVIDCerts, protowire.BytesType)
+	svidMsg = protowire.AppendBytes(svidMsg, svid)
+	svidMsg = protowire.AppendTag(svidMsg, x509SVIDKey, protowire.BytesType)
+	svidMsg = protowire.AppendBytes(svidMsg, key)
+	svidMsg = protowire.AppendTag(svidMsg, x509SVIDBundle, protowire.BytesType)
+	svidMsg = protowire.AppendBytes(svidMsg, bundle)
+	// Unknown fields are ignored.
+	svidMsg = protowire.AppendTag(svidMsg, 5, protowire.BytesType)
+	svidMsg = protowire.AppendString(svidMsg, "hint")
+
+	var resp []byte
+	resp = protowire.AppendTag(resp, x509SVIDResponseSVIDs, protowire.BytesType)
+	resp = protowire.AppendBytes(resp, svidMsg)
+	for td, bundle := range federatedBundles {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, td)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, bundle)
+
+		resp = protowire.AppendTag(resp, x509SVIDResponseFederatedBundles, protowire.BytesType)
+		resp = protowire.AppendBytes(resp, entry)
+	}
+	return resp
+}
+
+func TestSource(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	federatedCA := newTestCA(t, "federated.org")
+
+	api, addr := newFakeWorkloadAPI(t)
+
+	svid, key := ca.issue(t, "spiffe://example.org/piko/server")
+	api.responses <- encodeX509SVIDResponse(
+		"spiffe://example.org/piko/server", svid, key, ca.cert.Raw,
+		map[string][]byte{"federated.org": federatedCA.cert.Raw},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source, err := NewSource(ctx, Config{WorkloadAPIAddr: addr}, log.NewNopLogger())
+	require.NoError(t, err)
+	defer source.Close()
+
+	x509Context := source.X509Context()
+	assert.Equal(t, "spiffe://example.org/piko/server", x509Context.ID.String())
+	assert.Equal(t, svid, x509Context.Certificate.Certificate[0])
+	assert.Contains(t, x509Context.Bundles, "example.org")
+	assert.Contains(t, x509Context.Bundles, "federated.org")
+
+	t.Run("mtls", func(t *testing.T) {
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		defer serverConn.Close()
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- tls.Server(serverConn, source.ServerTLSConfig()).Handshake()
+		}()
+
+		require.NoError(t, tls.Client(clientConn, source.ClientTLSConfig()).Handshake())
+		require.NoError(t, <-errCh)
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		rotated, key := ca.issue(t, "spiffe://example.org/piko/server")
+		api.responses <- encodeX509SVIDResponse(
+			"spiffe://example.org/piko/server", rotated, key, ca.cert.Raw, nil,
+		)
+
+		assert.Eventually(t, func() bool {
+			cert := source.X509Context().Certificate
+			return string(cert.Certificate[0]) == string(rotated)
+		}, time.Second*5, time.Millisecond*10)
+	})
+
+	t.Run("invalid response", func(t *testing.T) {
+		api.responses <- []byte("invalid")
+
+		// Keeps using the previous SVID.
+		time.Sleep(time.Millisecond * 50)
+		assert.Equal(
+			t,
+			"spiffe://example.org/piko/server",
+			source.X509Context().ID.String(),
+		)
+	})
+}
+
+func TestSource_Unavailable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	_, err := NewSource(
+		ctx, Config{WorkloadAPIAddr: "unix://" + path}, log.NewNopLogger(),
+	)
+	assert.Error(t, err)
+}
+
+func TestParseX509SVIDResponse(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	svid, key := ca.issue(t, "spiffe://example.org/piko/server")
+
+	t.Run("id mismatch", func(t *testing.T) {
+		_, err := parseX509SVIDResponse(encodeX509SVIDResponse(
+			"spiffe://example.org/other", svid, key, ca.cert.Raw, nil,
+		))
+		assert.ErrorContains(t, err, "spiffe id mismatch")
+	})
+
+	t.Run("missing svid", func(t *testing.T) {
+		_, err := parseX509SVIDResponse(nil)
+		assert.ErrorContains(t, err, "missing svid")
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := parseX509SVIDResponse(encodeX509SVIDResponse(
+			"spiffe://example.org/piko/server", svid, []byte("invalid"), ca.cert.Raw, nil,
+		))
+		assert.ErrorContains(t, err, "parse svid key")
+	})
+}
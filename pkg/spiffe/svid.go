@@ -0,0 +1,71 @@
+package spiffe
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// X509Context contains the workload's X.509 SVID and the trust bundles used
+// to verify peers.
+type X509Context struct {
+	// ID is the SPIFFE ID of the workload.
+	ID ID
+
+	// Certificate contains the workload's X.509 SVID chain and private key.
+	Certificate *tls.Certificate
+
+	// Bundles contains the root CAs of each trust domain, including the
+	// workload's own trust domain and any federated trust domains.
+	Bundles map[string]*x509.CertPool
+}
+
+// VerifyPeer verifies the X.509 SVID chain presented by a peer, and returns
+// the peer's SPIFFE ID.
+//
+// The peer's SVID must be signed by the bundle of the peer's trust domain,
+// and the peer's trust domain must be one of the given trust domains.
+func (c *X509Context) VerifyPeer(rawCerts [][]byte, trustDomains []string) (ID, error) {
+	if len(rawCerts) == 0 {
+		return ID{}, fmt.Errorf("missing peer certificate")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return ID{}, fmt.Errorf("parse peer certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	leaf := certs[0]
+	id, err := IDFromCertificate(leaf)
+	if err != nil {
+		return ID{}, err
+	}
+	if leaf.IsCA {
+		return ID{}, fmt.Errorf("peer certificate is a ca: %s", id)
+	}
+	if !id.MemberOf(trustDomains) {
+		return ID{}, fmt.Errorf("untrusted trust domain: %s", id)
+	}
+
+	bundle, ok := c.Bundles[id.TrustDomain]
+	if !ok {
+		return ID{}, fmt.Errorf("missing bundle for trust domain: %s", id.TrustDomain)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         bundle,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return ID{}, fmt.Errorf("verify peer certificate: %s: %w", id, err)
+	}
+	return id, nil
+}
@@ -0,0 +1,129 @@
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, trustDomain string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	u, _ := url.Parse("spiffe://" + trustDomain)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{trustDomain}},
+		URIs:                  []*url.URL{u},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a DER encoded X.509 SVID and PKCS#8 encoded key for the
+// SPIFFE ID.
+func (ca *testCA) issue(t *testing.T, id string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	u, err := url.Parse(id)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		URIs:         []*url.URL{u},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth,
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return der, keyDER
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func TestX509Context_VerifyPeer(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	federatedCA := newTestCA(t, "federated.org")
+	otherCA := newTestCA(t, "other.org")
+
+	x509Context := &X509Context{
+		Bundles: map[string]*x509.CertPool{
+			"example.org":   ca.pool(),
+			"federated.org": federatedCA.pool(),
+		},
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		der, _ := ca.issue(t, "spiffe://example.org/piko/agent")
+		id, err := x509Context.VerifyPeer([][]byte{der}, []string{"example.org"})
+		require.NoError(t, err)
+		assert.Equal(t, "spiffe://example.org/piko/agent", id.String())
+	})
+
+	t.Run("federated trust domain", func(t *testing.T) {
+		der, _ := federatedCA.issue(t, "spiffe://federated.org/piko/agent")
+		_, err := x509Context.VerifyPeer(
+			[][]byte{der}, []string{"example.org", "federated.org"},
+		)
+		require.NoError(t, err)
+	})
+
+	t.Run("untrusted trust domain", func(t *testing.T) {
+		der, _ := federatedCA.issue(t, "spiffe://federated.org/piko/agent")
+		_, err := x509Context.VerifyPeer([][]byte{der}, []string{"example.org"})
+		assert.ErrorContains(t, err, "untrusted trust domain")
+	})
+
+	t.Run("missing bundle", func(t *testing.T) {
+		der, _ := otherCA.issue(t, "spiffe://other.org/piko/agent")
+		_, err := x509Context.VerifyPeer([][]byte{der}, []string{"other.org"})
+		assert.ErrorContains(t, err, "missing bundle")
+	})
+
+	t.Run("signed by other trust domain", func(t *testing.T) {
+		// Issued by the federated CA with an ID in the local trust domain.
+		der, _ := federatedCA.issue(t, "spiffe://example.org/piko/agent")
+		_, err := x509Context.VerifyPeer([][]byte{der}, []string{"example.org"})
+		assert.ErrorContains(t, err, "verify peer certificate")
+	})
+
+	t.Run("missing certificate", func(t *testing.T) {
+		_, err := x509Context.VerifyPeer(nil, []string{"example.org"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,261 @@
+package spiffe
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The SPIFFE Workload API X.509 SVID profile.
+//
+// Rather than generating the service from the protobuf definition, messages
+// are decoded directly from the protobuf wire format, since only a single
+// method is used.
+const (
+	fetchX509SVIDMethod = "/SpiffeWorkloadAPI/FetchX509SVID"
+
+	// workloadAPIHeader must be included in all Workload API requests.
+	workloadAPIHeader = "workload.spiffe.io"
+)
+
+// Field numbers of 'X509SVIDResponse'.
+const (
+	x509SVIDResponseSVIDs            protowire.Number = 1
+	x509SVIDResponseFederatedBundles protowire.Number = 3
+)
+
+// Field numbers of 'X509SVID'.
+const (
+	x509SVIDSpiffeID protowire.Number = 1
+	x509SVIDCerts    protowire.Number = 2
+	x509SVIDKey      protowire.Number = 3
+	x509SVIDBundle   protowire.Number = 4
+)
+
+// rawCodec passes messages to and from gRPC as raw protobuf encoded bytes.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("unsupported message type: %T", v)
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unsupported message type: %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// parseX509SVIDResponse parses a Workload API 'X509SVIDResponse' message.
+//
+// The first SVID in the response is the workload's default identity, so
+// any other SVIDs are ignored.
+func parseX509SVIDResponse(b []byte) (*X509Context, error) {
+	var svid []byte
+	federated := make(map[string][]byte)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == x509SVIDResponseSVIDs && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			if svid == nil {
+				svid = v
+			}
+			b = b[n:]
+		case num == x509SVIDResponseFederatedBundles && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			td, bundle, err := parseMapEntry(v)
+			if err != nil {
+				return nil, fmt.Errorf("federated bundles: %w", err)
+			}
+			federated[td] = bundle
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+
+	if svid == nil {
+		return nil, fmt.Errorf("response missing svid")
+	}
+	x509Context, err := parseX509SVID(svid)
+	if err != nil {
+		return nil, err
+	}
+	for td, der := range federated {
+		// Federated bundles must not replace the workload's own bundle.
+		if td == x509Context.ID.TrustDomain {
+			continue
+		}
+		bundle, err := parseBundle(der)
+		if err != nil {
+			return nil, fmt.Errorf("federated bundle: %s: %w", td, err)
+		}
+		x509Context.Bundles[td] = bundle
+	}
+	return x509Context, nil
+}
+
+// parseX509SVID parses a Workload API 'X509SVID' message.
+func parseX509SVID(b []byte) (*X509Context, error) {
+	var rawID string
+	var certsDER, keyDER, bundleDER []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType {
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case x509SVIDSpiffeID:
+			rawID = string(v)
+		case x509SVIDCerts:
+			certsDER = v
+		case x509SVIDKey:
+			keyDER = v
+		case x509SVIDBundle:
+			bundleDER = v
+		}
+	}
+
+	certs, err := x509.ParseCertificates(certsDER)
+	if err != nil {
+		return nil, fmt.Errorf("parse svid: %w", err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("parse svid: missing certificate")
+	}
+	id, err := IDFromCertificate(certs[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse svid: %w", err)
+	}
+	if id.String() != rawID {
+		return nil, fmt.Errorf(
+			"parse svid: spiffe id mismatch: %s != %s", id, rawID,
+		)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		return nil, fmt.Errorf("parse svid key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("parse svid key: unsupported key type: %T", key)
+	}
+
+	bundle, err := parseBundle(bundleDER)
+	if err != nil {
+		return nil, fmt.Errorf("parse bundle: %w", err)
+	}
+
+	cert := &tls.Certificate{
+		PrivateKey: signer,
+		Leaf:       certs[0],
+	}
+	for _, c := range certs {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+
+	return &X509Context{
+		ID:          id,
+		Certificate: cert,
+		Bundles: map[string]*x509.CertPool{
+			id.TrustDomain: bundle,
+		},
+	}, nil
+}
+
+// parseBundle parses a bundle of concatenated DER encoded root CAs.
+func parseBundle(der []byte) (*x509.CertPool, error) {
+	certs, err := x509.ParseCertificates(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("empty bundle")
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// parseMapEntry parses a 'map<string, bytes>' entry.
+func parseMapEntry(b []byte) (string, []byte, error) {
+	var key string
+	var value []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ == protowire.BytesType && (num == 1 || num == 2) {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return "", nil, protowire.ParseError(n)
+			}
+			if num == 1 {
+				key = string(v)
+			} else {
+				value = v
+			}
+			b = b[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return key, value, nil
+}
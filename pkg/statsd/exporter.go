@@ -0,0 +1,214 @@
+// Package statsd exports Prometheus metrics to a StatsD server.
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxPacketSize is the maximum size of a UDP packet sent to the StatsD
+	// server. This fits in a single ethernet frame to avoid fragmentation.
+	maxPacketSize = 1432
+)
+
+// Exporter periodically pushes the metrics in a Prometheus registry to a
+// StatsD server.
+//
+// Counters are sent as StatsD counters containing the increase since the
+// last push, and gauges are sent as StatsD gauges. Histograms and summaries
+// are sent as '<name>_count' and '<name>_sum' counters.
+//
+// Metric labels are sent as tags using the DogStatsD format.
+type Exporter struct {
+	gatherer prometheus.Gatherer
+
+	conn net.Conn
+
+	interval time.Duration
+
+	// tags are added to every metric.
+	tags []string
+
+	// counters contains the last pushed value of each counter, used to
+	// calculate the increase since the last push.
+	counters map[string]float64
+
+	logger log.Logger
+}
+
+func NewExporter(
+	gatherer prometheus.Gatherer,
+	addr string,
+	interval time.Duration,
+	tags []string,
+	logger log.Logger,
+) (*Exporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	return &Exporter{
+		gatherer: gatherer,
+		conn:     conn,
+		interval: interval,
+		tags:     tags,
+		counters: make(map[string]float64),
+		logger:   logger.WithSubsystem("statsd"),
+	}, nil
+}
+
+// Run pushes metrics every interval until the context is cancelled. Metrics
+// are pushed once more before returning.
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.push()
+		case <-ctx.Done():
+			e.push()
+			return
+		}
+	}
+}
+
+func (e *Exporter) Close() error {
+	return e.conn.Close()
+}
+
+func (e *Exporter) push() {
+	lines, err := e.lines()
+	if err != nil {
+		e.logger.Warn("failed to gather metrics", zap.Error(err))
+		return
+	}
+
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+len(line)+1 > maxPacketSize {
+			e.write(packet)
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		e.write(packet)
+	}
+}
+
+func (e *Exporter) write(packet []byte) {
+	if _, err := e.conn.Write(packet); err != nil {
+		e.logger.Debug("failed to write metrics", zap.Error(err))
+	}
+}
+
+// lines returns the StatsD lines for the gathered metrics.
+func (e *Exporter) lines() ([]string, error) {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			tags := e.metricTags(m)
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				lines = e.appendCounter(
+					lines, name, tags, m.GetCounter().GetValue(),
+				)
+			case dto.MetricType_GAUGE:
+				lines = appendGauge(
+					lines, name, tags, m.GetGauge().GetValue(),
+				)
+			case dto.MetricType_UNTYPED:
+				lines = appendGauge(
+					lines, name, tags, m.GetUntyped().GetValue(),
+				)
+			case dto.MetricType_HISTOGRAM:
+				lines = e.appendCounter(
+					lines, name+"_count", tags,
+					float64(m.GetHistogram().GetSampleCount()),
+				)
+				lines = e.appendCounter(
+					lines, name+"_sum", tags, m.GetHistogram().GetSampleSum(),
+				)
+			case dto.MetricType_SUMMARY:
+				lines = e.appendCounter(
+					lines, name+"_count", tags,
+					float64(m.GetSummary().GetSampleCount()),
+				)
+				lines = e.appendCounter(
+					lines, name+"_sum", tags, m.GetSummary().GetSampleSum(),
+				)
+			}
+		}
+	}
+	return lines, nil
+}
+
+func (e *Exporter) appendCounter(
+	lines []string,
+	name string,
+	tags string,
+	value float64,
+) []string {
+	key := name + tags
+	delta := value - e.counters[key]
+	// If the counter decreased it must have been reset.
+	if delta < 0 {
+		delta = value
+	}
+	e.counters[key] = value
+	if delta == 0 {
+		return lines
+	}
+	return append(lines, name+":"+formatValue(delta)+"|c"+tags)
+}
+
+func appendGauge(
+	lines []string,
+	name string,
+	tags string,
+	value float64,
+) []string {
+	return append(lines, name+":"+formatValue(value)+"|g"+tags)
+}
+
+// metricTags returns the DogStatsD tags for the metric, including the
+// exporter tags, such as '|#endpoint_id:my-endpoint,env:prod'.
+func (e *Exporter) metricTags(m *dto.Metric) string {
+	tags := make([]string, 0, len(m.GetLabel())+len(e.tags))
+	for _, label := range m.GetLabel() {
+		tags = append(tags, label.GetName()+":"+label.GetValue())
+	}
+	sort.Strings(tags)
+	tags = append(tags, e.tags...)
+	if len(tags) == 0 {
+		return ""
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,73 @@
+package statsd
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readLines(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+
+	buf := make([]byte, maxPacketSize)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	lines := strings.Split(string(buf[:n]), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestExporter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "requests_total",
+	}, []string{"status"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "connections",
+	})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "latency_seconds",
+	})
+	registry.MustRegister(counter, gauge, histogram)
+
+	exporter, err := NewExporter(
+		registry, conn.LocalAddr().String(), time.Second, []string{"env:prod"},
+		log.NewNopLogger(),
+	)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	counter.WithLabelValues("200").Add(3)
+	gauge.Set(5)
+	histogram.Observe(0.5)
+
+	exporter.push()
+	assert.Equal(t, []string{
+		"connections:5|g|#env:prod",
+		"latency_seconds_count:1|c|#env:prod",
+		"latency_seconds_sum:0.5|c|#env:prod",
+		"requests_total:3|c|#status:200,env:prod",
+	}, readLines(t, conn))
+
+	// Counters only include the increase since the last push.
+	counter.WithLabelValues("200").Add(2)
+
+	exporter.push()
+	assert.Equal(t, []string{
+		"connections:5|g|#env:prod",
+		"requests_total:2|c|#status:200,env:prod",
+	}, readLines(t, conn))
+}
@@ -0,0 +1,112 @@
+// Package streaming supports proxying streaming responses, such as
+// Server-Sent Events, which stay open for as long as the client is
+// connected.
+//
+// Streaming responses are exempt from the proxy timeout and the HTTP server
+// write timeout once the response headers are received, since otherwise the
+// stream would be closed after the timeout.
+package streaming
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// IsStreaming returns whether a response with the given headers is a
+// streaming response.
+func IsStreaming(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/event-stream"
+}
+
+// WithTimeout returns a context that is cancelled if the request doesn't
+// complete within the timeout, and a response writer that stops the timeout
+// if the response is a streaming response. If the timeout is zero, there is
+// no timeout.
+//
+// The response writer also clears the write deadline of the HTTP server for
+// streaming responses.
+//
+// The returned function must be called once the request completes.
+func WithTimeout(
+	ctx context.Context,
+	w http.ResponseWriter,
+	timeout time.Duration,
+) (context.Context, http.ResponseWriter, func()) {
+	sw := &responseWriter{
+		ResponseWriter: w,
+	}
+	if timeout == 0 {
+		return ctx, sw, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	sw.timer = time.AfterFunc(timeout, func() {
+		cancel(context.DeadlineExceeded)
+	})
+	return ctx, sw, func() {
+		sw.timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// TimedOut returns whether the context was cancelled due to a timeout added
+// with WithTimeout.
+func TimedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), context.DeadlineExceeded)
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+
+	// timer cancels the request when the timeout expires, or is nil if
+	// there is no timeout.
+	timer *time.Timer
+
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader && statusCode >= http.StatusOK {
+		w.wroteHeader = true
+
+		if IsStreaming(w.Header()) {
+			if w.timer != nil {
+				w.timer.Stop()
+			}
+			// Ignore the error if the writer doesn't support deadlines.
+			_ = http.NewResponseController(w.ResponseWriter).SetWriteDeadline(
+				time.Time{},
+			)
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying response writer, so the reverse proxy can
+// hijack the connection for upgraded requests.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+var _ http.ResponseWriter = &responseWriter{}
+var _ http.Flusher = &responseWriter{}
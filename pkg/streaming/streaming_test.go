@@ -0,0 +1,94 @@
+package streaming
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsStreaming(t *testing.T) {
+	assert.True(t, IsStreaming(http.Header{"Content-Type": {"text/event-stream"}}))
+	assert.True(t, IsStreaming(http.Header{"Content-Type": {"text/event-stream; charset=utf-8"}}))
+	assert.False(t, IsStreaming(http.Header{"Content-Type": {"application/json"}}))
+	assert.False(t, IsStreaming(http.Header{}))
+}
+
+func TestWithTimeout(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		ctx, w, cancel := WithTimeout(
+			context.Background(), httptest.NewRecorder(), time.Millisecond*10,
+		)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		<-ctx.Done()
+		assert.True(t, TimedOut(ctx))
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		ctx, w, cancel := WithTimeout(
+			context.Background(), httptest.NewRecorder(), time.Millisecond*10,
+		)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+
+		<-time.After(time.Millisecond * 50)
+		assert.NoError(t, ctx.Err())
+
+		cancel()
+		assert.Error(t, ctx.Err())
+		assert.False(t, TimedOut(ctx))
+	})
+
+	t.Run("streaming write timeout", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				_, w, cancel := WithTimeout(r.Context(), w, 0)
+				defer cancel()
+
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.WriteHeader(http.StatusOK)
+				for i := 0; i != 3; i++ {
+					// Exceed the server write timeout.
+					<-time.After(time.Millisecond * 20)
+
+					// nolint
+					w.Write([]byte("data: foo\n\n"))
+					w.(http.Flusher).Flush()
+				}
+			},
+		))
+		server.Config.WriteTimeout = time.Millisecond * 10
+		server.Start()
+		defer server.Close()
+
+		resp, err := http.Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("data: foo\n\n", 3), string(b))
+	})
+
+	t.Run("no timeout", func(t *testing.T) {
+		ctx, w, cancel := WithTimeout(
+			context.Background(), httptest.NewRecorder(), 0,
+		)
+		defer cancel()
+
+		// nolint
+		w.Write([]byte("foo"))
+		assert.NoError(t, ctx.Err())
+	})
+}
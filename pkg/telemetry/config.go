@@ -0,0 +1,65 @@
+package telemetry
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/pflag"
+)
+
+type Config struct {
+	// OTLPEndpoint is the URL of the OTLP gRPC endpoint to export traces
+	// to. If empty tracing is disabled.
+	OTLPEndpoint string `json:"otlp_endpoint" yaml:"otlp_endpoint"`
+
+	// SampleRate is the fraction of traces to sample, from 0 to 1.
+	//
+	// Traces that are sampled by a parent span are always sampled.
+	SampleRate float64 `json:"sample_rate" yaml:"sample_rate"`
+}
+
+func (c *Config) Enabled() bool {
+	return c.OTLPEndpoint != ""
+}
+
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	u, err := url.Parse(c.OTLPEndpoint)
+	if err != nil {
+		return fmt.Errorf("invalid otlp endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid otlp endpoint: unsupported scheme: %s", u.Scheme)
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("sample rate must be between 0 and 1")
+	}
+	return nil
+}
+
+func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&c.OTLPEndpoint,
+		"telemetry.otlp-endpoint",
+		c.OTLPEndpoint,
+		`
+URL of an OpenTelemetry collector OTLP gRPC endpoint to export traces to,
+such as 'http://localhost:4317'.
+
+Use a 'https' URL to connect to the collector using TLS.
+
+If not set tracing is disabled.`,
+	)
+	fs.Float64Var(
+		&c.SampleRate,
+		"telemetry.sample-rate",
+		c.SampleRate,
+		`
+Fraction of traces to sample, from 0 to 1.
+
+Requests that are already part of a sampled trace (such as requests forwarded
+from another node) are always sampled.`,
+	)
+}
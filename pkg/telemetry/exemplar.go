@@ -0,0 +1,33 @@
+package telemetry
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ObserveWithExemplar observes the given value, with the trace ID of the
+// span in the context attached as an exemplar.
+//
+// The exemplar is only attached if the span is sampled, since otherwise the
+// trace won't be exported.
+func ObserveWithExemplar(
+	ctx context.Context,
+	observer prometheus.Observer,
+	v float64,
+) {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if !spanCtx.IsSampled() {
+		observer.Observe(v)
+		return
+	}
+	exemplarObserver, ok := observer.(prometheus.ExemplarObserver)
+	if !ok {
+		observer.Observe(v)
+		return
+	}
+	exemplarObserver.ObserveWithExemplar(v, prometheus.Labels{
+		"trace_id": spanCtx.TraceID().String(),
+	})
+}
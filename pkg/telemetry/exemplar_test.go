@@ -0,0 +1,65 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestObserveWithExemplar(t *testing.T) {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+
+	t.Run("sampled", func(t *testing.T) {
+		ctx := trace.ContextWithSpanContext(
+			context.Background(),
+			trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    traceID,
+				SpanID:     spanID,
+				TraceFlags: trace.FlagsSampled,
+			}),
+		)
+
+		h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "foo"})
+		ObserveWithExemplar(ctx, h, 0.5)
+
+		var m dto.Metric
+		require.NoError(t, h.Write(&m))
+		var exemplars []*dto.Exemplar
+		for _, bucket := range m.GetHistogram().GetBucket() {
+			if bucket.GetExemplar() != nil {
+				exemplars = append(exemplars, bucket.GetExemplar())
+			}
+		}
+		require.Equal(t, 1, len(exemplars))
+		assert.Equal(t, "trace_id", exemplars[0].GetLabel()[0].GetName())
+		assert.Equal(t, traceID.String(), exemplars[0].GetLabel()[0].GetValue())
+	})
+
+	t.Run("not sampled", func(t *testing.T) {
+		ctx := trace.ContextWithSpanContext(
+			context.Background(),
+			trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID: traceID,
+				SpanID:  spanID,
+			}),
+		)
+
+		h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "foo"})
+		ObserveWithExemplar(ctx, h, 0.5)
+
+		var m dto.Metric
+		require.NoError(t, h.Write(&m))
+		assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+		for _, bucket := range m.GetHistogram().GetBucket() {
+			assert.Nil(t, bucket.GetExemplar())
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package telemetry
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/andydunstall/piko"
+)
+
+// StartSpan starts a server span for the given request, with the given span
+// name and attributes.
+//
+// The span continues the trace from the request trace context headers (if
+// any). Those headers are then replaced with the context of the new span, so
+// if the request is proxied the next hop records a child span.
+//
+// Returns the request with the span added to its context.
+func StartSpan(
+	r *http.Request,
+	spanName string,
+	attrs ...attribute.KeyValue,
+) (*http.Request, trace.Span) {
+	propagator := otel.GetTextMapPropagator()
+	carrier := propagation.HeaderCarrier(r.Header)
+
+	ctx := propagator.Extract(r.Context(), carrier)
+	ctx, span := otel.Tracer(tracerName).Start(
+		ctx,
+		spanName,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		),
+	)
+
+	propagator.Inject(ctx, carrier)
+	return r.WithContext(ctx), span
+}
+
+// EndSpan records the response status code and ends the span.
+func EndSpan(span trace.Span, statusCode int) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(statusCode))
+	if statusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(statusCode))
+	}
+	span.End()
+}
@@ -0,0 +1,87 @@
+package telemetry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestStartSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+
+	t.Run("continue trace", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		r.Header.Set(
+			"traceparent",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		)
+
+		r, span := StartSpan(
+			r, "piko.proxy", attribute.String("piko.endpoint_id", "my-endpoint"),
+		)
+		EndSpan(span, http.StatusOK)
+
+		spans := recorder.Ended()
+		require.Equal(t, 1, len(spans))
+		recorded := spans[len(spans)-1]
+
+		assert.Equal(t, "piko.proxy", recorded.Name())
+		assert.Equal(t, trace.SpanKindServer, recorded.SpanKind())
+		assert.Equal(
+			t,
+			"4bf92f3577b34da6a3ce929d0e0e4736",
+			recorded.SpanContext().TraceID().String(),
+		)
+		assert.Equal(t, "00f067aa0ba902b7", recorded.Parent().SpanID().String())
+		assert.Contains(
+			t,
+			recorded.Attributes(),
+			attribute.String("piko.endpoint_id", "my-endpoint"),
+		)
+		assert.Contains(
+			t,
+			recorded.Attributes(),
+			attribute.Int("http.response.status_code", http.StatusOK),
+		)
+
+		// The request headers must be updated to propagate the new span.
+		assert.Equal(
+			t,
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-"+
+				recorded.SpanContext().SpanID().String()+"-01",
+			r.Header.Get("traceparent"),
+		)
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+
+		_, span := StartSpan(r, "piko.proxy")
+		EndSpan(span, http.StatusBadGateway)
+
+		spans := recorder.Ended()
+		recorded := spans[len(spans)-1]
+
+		// Without a trace context header, the span starts a new trace.
+		assert.False(t, recorded.Parent().IsValid())
+		assert.Equal(t, codes.Error, recorded.Status().Code)
+	})
+}
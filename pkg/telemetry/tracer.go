@@ -0,0 +1,75 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andydunstall/piko/pkg/build"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+)
+
+// Tracer exports OpenTelemetry traces to an OTLP endpoint.
+//
+// The tracer is registered as the global OpenTelemetry tracer provider and
+// propagator, so spans created by any package are exported, and trace
+// context is propagated using W3C trace context headers.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+}
+
+// NewTracer creates a tracer exporting traces to the configured OTLP
+// endpoint.
+//
+// If tracing is disabled, the global OpenTelemetry no-op tracer is left in
+// place, so spans aren't recorded and no trace headers are added to
+// requests.
+func NewTracer(conf Config, serviceName string) (*Tracer, error) {
+	if !conf.Enabled() {
+		return &Tracer{}, nil
+	}
+
+	exporter, err := otlptracegrpc.New(
+		context.Background(),
+		otlptracegrpc.WithEndpointURL(conf.OTLPEndpoint),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(build.Version),
+	)
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(
+			sdktrace.TraceIDRatioBased(conf.SampleRate),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return &Tracer{
+		provider: provider,
+	}, nil
+}
+
+// Shutdown flushes any pending spans and stops exporting.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
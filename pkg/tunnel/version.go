@@ -0,0 +1,71 @@
+// Package tunnel contains the protocol shared by upstreams and the Piko
+// server for the upstream tunnel connection.
+//
+// Only the connection handshake is versioned. There is no protobuf or other
+// RPC framing: each stream carries the proxied connection directly, and the
+// control stream carries single-byte messages.
+package tunnel
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// VersionHeader is the header an upstream sets when connecting with the
+	// latest protocol version it supports. The server responds with the
+	// negotiated version in the same header.
+	VersionHeader = "x-piko-tunnel-version"
+
+	// Version is the latest supported protocol version.
+	//
+	// Version 1 multiplexes a stream for each proxied connection using yamux,
+	// where HTTP listeners are sent HTTP/1.1 requests on each stream.
+	//
+	// Version 2 adds a control stream, see ControlVersion.
+	Version = 2
+
+	// MinVersion is the oldest supported protocol version.
+	MinVersion = 1
+
+	// ControlVersion is the first protocol version with a control stream.
+	//
+	// Once connected, the upstream opens a single stream to the server, which
+	// the server uses to send control messages to the upstream. All other
+	// streams are opened by the server for proxied connections.
+	ControlVersion = 2
+)
+
+const (
+	// ControlGoAway is sent by the server on the control stream to ask the
+	// upstream to reconnect, such as when the server is rebalancing upstreams
+	// or draining.
+	//
+	// The server stops opening new streams on the connection, then closes the
+	// connection once its open streams complete. So the upstream should
+	// reconnect without closing the existing connection.
+	ControlGoAway byte = 1
+)
+
+// NegotiateVersion returns the protocol version to use with an upstream
+// given the version header it connected with.
+//
+// Upstreams that don't set a version are from before the protocol was
+// versioned, so use version 1. Upstreams supporting a newer version than
+// the local node use the latest version supported locally.
+func NegotiateVersion(header string) (int, error) {
+	if header == "" {
+		return 1, nil
+	}
+
+	version, err := strconv.Atoi(header)
+	if err != nil {
+		return 0, fmt.Errorf("invalid version: %s", header)
+	}
+	if version < MinVersion {
+		return 0, fmt.Errorf(
+			"unsupported version: %d (min %d)", version, MinVersion,
+		)
+	}
+	return min(version, Version), nil
+}
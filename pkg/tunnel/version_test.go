@@ -0,0 +1,32 @@
+package tunnel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		header  string
+		version int
+		err     string
+	}{
+		{header: "", version: 1},
+		{header: "1", version: 1},
+		{header: "100", version: Version},
+		{header: "0", err: "unsupported version"},
+		{header: "foo", err: "invalid version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			version, err := NegotiateVersion(tt.header)
+			if tt.err == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.version, version)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
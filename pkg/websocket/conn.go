@@ -50,6 +50,7 @@ func (e *RetryableError) Error() string {
 type dialOptions struct {
 	token     string
 	tlsConfig *tls.Config
+	header    http.Header
 }
 
 type DialOption interface {
@@ -78,6 +79,23 @@ func WithTLSConfig(config *tls.Config) DialOption {
 	return tlsConfigOption{TLSConfig: config}
 }
 
+type headerOption struct {
+	Name  string
+	Value string
+}
+
+func (o headerOption) apply(opts *dialOptions) {
+	if opts.header == nil {
+		opts.header = make(http.Header)
+	}
+	opts.header.Set(o.Name, o.Value)
+}
+
+// WithHeader adds a header to the WebSocket handshake request.
+func WithHeader(name string, value string) DialOption {
+	return headerOption{Name: name, Value: value}
+}
+
 // Conn implements a [net.Conn] using WebSockets as the underlying transport.
 //
 // This adds a small amount of overhead compared to using TCP directly, though
@@ -86,6 +104,10 @@ type Conn struct {
 	wsConn *websocket.Conn
 
 	reader io.Reader
+
+	// header contains the handshake response headers if the connection was
+	// dialed.
+	header http.Header
 }
 
 func New(wsConn *websocket.Conn) *Conn {
@@ -109,7 +131,10 @@ func Dial(ctx context.Context, url string, opts ...DialOption) (*Conn, error) {
 		dialer.TLSClientConfig = options.tlsConfig
 	}
 
-	header := make(http.Header)
+	header := options.header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
 	if options.token != "" {
 		header.Set("Authorization", "Bearer "+options.token)
 	}
@@ -118,7 +143,9 @@ func Dial(ctx context.Context, url string, opts ...DialOption) (*Conn, error) {
 		ctx, url, header,
 	)
 	if err == nil {
-		return New(wsConn), nil
+		conn := New(wsConn)
+		conn.header = resp.Header
+		return conn, nil
 	}
 	if resp == nil {
 		return nil, NewRetryableError(err)
@@ -140,6 +167,12 @@ func Dial(ctx context.Context, url string, opts ...DialOption) (*Conn, error) {
 	return nil, err
 }
 
+// Header returns the headers of the servers handshake response, or nil if
+// the connection wasn't dialed.
+func (c *Conn) Header() http.Header {
+	return c.header
+}
+
 func (c *Conn) Read(b []byte) (int, error) {
 	for {
 		if c.reader == nil {
@@ -0,0 +1,16 @@
+// Package winsvc supports running Piko as a Windows service.
+//
+// On Windows, services are stopped by the service control manager rather than
+// a signal, so a service must handle stop and shutdown requests to gracefully
+// shutdown. On other platforms running as a service isn't supported.
+package winsvc
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNotSupported is returned when Windows services aren't supported on
+	// the current platform.
+	ErrNotSupported = errors.New("windows services not supported on this platform")
+)
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package winsvc
+
+import (
+	"context"
+)
+
+// IsService returns whether the process is running as a Windows service,
+// which is always false on non-Windows platforms.
+func IsService() bool {
+	return false
+}
+
+func Run(_ string, _ func(ctx context.Context) error) error {
+	return ErrNotSupported
+}
+
+func Install(_ string, _ string, _ []string) error {
+	return ErrNotSupported
+}
+
+func Uninstall(_ string) error {
+	return ErrNotSupported
+}
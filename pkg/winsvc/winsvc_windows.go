@@ -0,0 +1,147 @@
+//go:build windows
+
+package winsvc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// IsService returns whether the process is running as a Windows service.
+func IsService() bool {
+	isService, err := svc.IsWindowsService()
+	return err == nil && isService
+}
+
+// Run runs the given function as the Windows service with the given name.
+//
+// The context passed to run is cancelled when the service control manager
+// requests the service stops (such as the user stopping the service or the
+// host shutting down). Run blocks until run returns.
+func Run(name string, run func(ctx context.Context) error) error {
+	h := &handler{
+		run: run,
+	}
+	if err := svc.Run(name, h); err != nil {
+		return fmt.Errorf("run service: %w", err)
+	}
+	return h.err
+}
+
+// Install installs a Windows service with the given name, that runs the
+// current executable with the given arguments.
+//
+// The service starts automatically when the host boots, and is restarted if
+// it fails.
+func Install(name string, description string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("executable: %w", err)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connect to service manager: %w", err)
+	}
+	defer func() { _ = m.Disconnect() }()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: name,
+		Description: description,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("create service: %w", err)
+	}
+	defer s.Close()
+
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: time.Second * 5},
+	}, uint32((time.Hour * 24).Seconds())); err != nil {
+		return fmt.Errorf("set recovery actions: %w", err)
+	}
+
+	return nil
+}
+
+// Uninstall removes the Windows service with the given name.
+//
+// If the service is running, it is removed once it stops.
+func Uninstall(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connect to service manager: %w", err)
+	}
+	defer func() { _ = m.Disconnect() }()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("open service: %s: %w", name, err)
+	}
+	defer s.Close()
+
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("delete service: %w", err)
+	}
+	return nil
+}
+
+// handler handles requests from the service control manager.
+type handler struct {
+	run func(ctx context.Context) error
+
+	// err is the error returned by run.
+	err error
+}
+
+func (h *handler) Execute(
+	_ []string,
+	requests <-chan svc.ChangeRequest,
+	status chan<- svc.Status,
+) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- h.run(ctx)
+	}()
+
+	status <- svc.Status{
+		State:   svc.Running,
+		Accepts: svc.AcceptStop | svc.AcceptShutdown,
+	}
+
+	for {
+		select {
+		case err := <-errCh:
+			h.err = err
+			if err != nil {
+				// Use a service specific exit code so the service manager
+				// considers the service failed and restarts it.
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/andydunstall/piko/server/config"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager returns a manager that obtains and renews the proxy
+// listener certificates using ACME.
+//
+// The manager responds to TLS-ALPN-01 challenges on the proxy listener, and
+// to HTTP-01 challenges using the handler returned by HTTPHandler.
+func newACMEManager(conf config.ProxyTLSConfig) *autocert.Manager {
+	manager := &autocert.Manager{
+		// The CA terms of service must be accepted to request certificates.
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(conf.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(conf.ACMEDomains...),
+		Email:      conf.ACMEEmail,
+	}
+	if conf.ACMEDirectoryURL != "" {
+		manager.Client = &acme.Client{
+			DirectoryURL: conf.ACMEDirectoryURL,
+		}
+	}
+	return manager
+}
+
+// newACMEHTTPServer returns a server that responds to ACME HTTP-01
+// challenges, and redirects any other requests to HTTPS.
+func newACMEHTTPServer(manager *autocert.Manager) *http.Server {
+	return &http.Server{
+		Handler:           manager.HTTPHandler(nil),
+		ReadHeaderTimeout: time.Second * 10,
+	}
+}
@@ -0,0 +1,126 @@
+package admin
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxAuditBodySize is the maximum size of the request body to include in
+	// the audit log.
+	maxAuditBodySize = 4096
+
+	// forwardedContextKey is set when the request is forwarded to another
+	// node.
+	forwardedContextKey = "_piko_forwarded"
+
+	// forwardedForContextKey is the IP of the client that made the request,
+	// if the request was forwarded from an authenticated node.
+	forwardedForContextKey = "_piko_forwarded_for"
+)
+
+// auditRecord describes an admin request that modifies the node, or that was
+// rejected as unauthenticated.
+type auditRecord struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+	// Body is the request body, truncated to maxAuditBodySize bytes.
+	Body   string `json:"body,omitempty"`
+	Status int    `json:"status"`
+
+	// ClientIP is the IP of the connection the request was received on.
+	// Forwarding headers are never trusted, so if the request was forwarded
+	// by another node or a proxy, this is the IP of the forwarding node.
+	ClientIP string `json:"client_ip"`
+	// ForwardedFor is the IP of the client that made the request, if the
+	// request was forwarded by another node. Only set if the forwarding node
+	// is authenticated using the cluster join token.
+	ForwardedFor string `json:"forwarded_for,omitempty"`
+	// Caller identifies the user making the request, set by the 'piko
+	// server' CLI to the local user and host. Note the caller is reported by
+	// the client so isn't verified.
+	Caller    string `json:"caller,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// User is the authenticated user making the request, if OIDC is
+	// enabled. Unlike Caller, the user is verified.
+	User string `json:"user,omitempty"`
+	// AuthFailed indicates the request was rejected as it wasn't
+	// authenticated or permitted.
+	AuthFailed bool `json:"auth_failed,omitempty"`
+}
+
+// auditInterceptor logs every admin request that modifies the node, such as
+// draining the node, disconnecting upstreams and updating the log level, to
+// the 'admin.audit' log subsystem. Requests rejected by authentication are
+// also logged, whether or not they modify the node.
+//
+// Requests forwarded to another node are only logged by the node handling
+// the request.
+func (s *Server) auditInterceptor(c *gin.Context) {
+	mutating := isMutatingRequest(c.Request)
+
+	var body []byte
+	if mutating && c.Request.Body != nil {
+		body, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxAuditBodySize))
+		// Restore the read body so it can be read by the handler.
+		c.Request.Body = &replayedBody{
+			Reader: io.MultiReader(bytes.NewReader(body), c.Request.Body),
+			Closer: c.Request.Body,
+		}
+	}
+
+	c.Next()
+
+	if c.GetBool(forwardedContextKey) {
+		return
+	}
+	authFailed := c.GetBool(authFailedContextKey)
+	if !mutating && !authFailed {
+		return
+	}
+
+	record := &auditRecord{
+		Method:       c.Request.Method,
+		Path:         c.Request.URL.Path,
+		Query:        c.Request.URL.RawQuery,
+		Body:         string(body),
+		Status:       c.Writer.Status(),
+		ClientIP:     c.RemoteIP(),
+		ForwardedFor: c.GetString(forwardedForContextKey),
+		Caller:       c.Request.Header.Get("x-piko-caller"),
+		UserAgent:    c.Request.UserAgent(),
+		AuthFailed:   authFailed,
+	}
+	if u, ok := c.Get(userContextKey); ok {
+		record.User = u.(*user).Name
+		if record.User == "" {
+			record.User = u.(*user).Subject
+		}
+	}
+	s.auditLogger.Info("admin request", zap.Any("request", record))
+}
+
+// isMutatingRequest returns whether the request may modify the node.
+func isMutatingRequest(r *http.Request) bool {
+	// Profiling endpoints don't modify the node.
+	if strings.HasPrefix(r.URL.Path, "/debug/pprof") {
+		return false
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+type replayedBody struct {
+	io.Reader
+	io.Closer
+}
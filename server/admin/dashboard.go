@@ -0,0 +1,27 @@
+package admin
+
+import (
+	"embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dashboardFS contains the static dashboard UI.
+//
+//go:embed dashboard/index.html
+var dashboardFS embed.FS
+
+// dashboardRoute serves the dashboard UI.
+//
+// The dashboard is a single static page that polls the status API, so it
+// doesn't require any server side rendering.
+func (s *Server) dashboardRoute(c *gin.Context) {
+	b, err := dashboardFS.ReadFile("dashboard/index.html")
+	if err != nil {
+		// Should never happen since the file is embedded.
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", b)
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// LogUpdate contains the log configuration to update.
+type LogUpdate struct {
+	// Level is the minimum log level, or empty to leave the level
+	// unchanged.
+	Level string `json:"level,omitempty"`
+
+	// Subsystems contains the enabled subsystems, or nil to leave the
+	// subsystems unchanged. An empty list disables all subsystems.
+	Subsystems *[]string `json:"subsystems,omitempty"`
+}
+
+// getLogRoute returns the current log configuration.
+func (s *Server) getLogRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, s.logConfig())
+}
+
+// updateLogRoute updates the log level and enabled subsystems at runtime.
+//
+// Note the updated configuration isn't persisted so is reset when the node
+// restarts.
+func (s *Server) updateLogRoute(c *gin.Context) {
+	var update LogUpdate
+	if err := c.BindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if update.Level != "" {
+		if err := s.logger.SetLevel(update.Level); err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{"error": fmt.Sprintf("invalid level: %s", err.Error())},
+			)
+			return
+		}
+	}
+	if update.Subsystems != nil {
+		s.logger.SetSubsystems(*update.Subsystems)
+	}
+
+	conf := s.logConfig()
+	s.logger.Info(
+		"updated log config",
+		zap.String("level", conf.Level),
+		zap.Strings("subsystems", conf.Subsystems),
+	)
+
+	c.JSON(http.StatusOK, conf)
+}
+
+func (s *Server) logConfig() log.Config {
+	subsystems := s.logger.Subsystems()
+	if subsystems == nil {
+		subsystems = []string{}
+	}
+	return log.Config{
+		Level:      s.logger.Level(),
+		Subsystems: subsystems,
+	}
+}
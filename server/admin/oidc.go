@@ -0,0 +1,419 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/oidc"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/lockout"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	sessionCookieName = "piko_session"
+	loginCookieName   = "piko_oidc_login"
+
+	// loginTimeout is the maximum duration for the user to log in with the
+	// provider.
+	loginTimeout = time.Minute * 10
+
+	// sessionAudience and loginAudience are the audience of the session
+	// and login cookies, so one can't be used as the other.
+	sessionAudience = "piko-admin-session"
+	loginAudience   = "piko-admin-login"
+
+	userContextKey = "_piko_user"
+
+	// authFailedContextKey is set when the request is rejected as it isn't
+	// authenticated or permitted, so the rejection is audited.
+	authFailedContextKey = "_piko_auth_failed"
+)
+
+var (
+	errMissingSession = errors.New("missing session")
+)
+
+type role string
+
+const (
+	roleAdmin    role = "admin"
+	roleReadOnly role = "read-only"
+)
+
+// user is an authenticated admin user.
+type user struct {
+	// Subject is the provider's identifier for the user.
+	Subject string
+	// Name is the user's email or name, if known.
+	Name string
+	Role role
+}
+
+type sessionClaims struct {
+	jwt.RegisteredClaims
+
+	Name string `json:"name,omitempty"`
+	Role role   `json:"role"`
+}
+
+// loginClaims contains the state of an in-progress login, stored in a
+// cookie while the user authenticates with the provider.
+type loginClaims struct {
+	jwt.RegisteredClaims
+
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Redirect string `json:"redirect"`
+}
+
+// oidcAuth authenticates admin users with an OpenID Connect provider, and
+// authorizes requests based on the user's groups.
+//
+// Once logged in, users are given a signed session cookie, so nodes don't
+// need to store any session state. API clients may instead pass an ID token
+// issued by the provider in the 'Authorization' header.
+type oidcAuth struct {
+	provider *oidc.Provider
+
+	conf config.OIDCConfig
+
+	// lockout locks out clients after repeated failed authentication
+	// attempts, or is nil if clients are never locked out.
+	lockout *lockout.Lockout
+
+	logger log.Logger
+}
+
+func newOIDCAuth(
+	conf config.OIDCConfig,
+	lockout *lockout.Lockout,
+	logger log.Logger,
+) *oidcAuth {
+	return &oidcAuth{
+		provider: oidc.NewProvider(oidc.Config{
+			IssuerURL:    conf.IssuerURL,
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RedirectURL:  conf.RedirectURL,
+			Scopes:       conf.Scopes,
+		}),
+		conf:    conf,
+		lockout: lockout,
+		logger:  logger.WithSubsystem("admin.oidc"),
+	}
+}
+
+func (a *oidcAuth) Register(router *gin.Engine) {
+	router.GET("/oidc/login", a.loginRoute)
+	router.GET("/oidc/callback", a.callbackRoute)
+	router.GET("/oidc/logout", a.logoutRoute)
+}
+
+// Authenticate verifies the request is from an authenticated user permitted
+// to make the request.
+//
+// Requests with an invalid ID token or session count as failed attempts from
+// the client IP, so the client may be locked out. Note the client IP is the
+// connection's remote address, since the admin server doesn't trust
+// forwarding headers.
+func (a *oidcAuth) Authenticate(c *gin.Context) {
+	if isPublicPath(c.Request.URL.Path) {
+		c.Next()
+		return
+	}
+
+	clientIP := c.RemoteIP()
+	if a.lockout != nil && !a.lockout.Allow(c.Writer, clientIP) {
+		c.Set(authFailedContextKey, true)
+		c.Abort()
+		return
+	}
+
+	u, err := a.authenticate(c)
+	if err != nil {
+		a.logger.Debug("unauthenticated request", zap.Error(err))
+		c.Set(authFailedContextKey, true)
+
+		if a.lockout != nil && !errors.Is(err, errMissingSession) {
+			a.lockout.Failed(clientIP)
+		}
+
+		// Redirect users to log in when opening the dashboard.
+		if c.Request.Method == http.MethodGet && c.Request.URL.Path == "/dashboard" {
+			c.Redirect(
+				http.StatusFound,
+				"/oidc/login?redirect="+url.QueryEscape(c.Request.URL.RequestURI()),
+			)
+			c.Abort()
+			return
+		}
+
+		c.AbortWithStatusJSON(
+			http.StatusUnauthorized, gin.H{"error": "unauthenticated"},
+		)
+		return
+	}
+
+	if u.Role != roleAdmin && isMutatingRequest(c.Request) {
+		c.Set(authFailedContextKey, true)
+		c.AbortWithStatusJSON(
+			http.StatusForbidden, gin.H{"error": "not permitted"},
+		)
+		return
+	}
+
+	if a.lockout != nil {
+		a.lockout.Succeeded(clientIP)
+	}
+
+	c.Set(userContextKey, u)
+	c.Next()
+}
+
+func (a *oidcAuth) authenticate(c *gin.Context) (*user, error) {
+	authorization := c.Request.Header.Get("Authorization")
+	if rawIDToken, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		claims, err := a.provider.Verify(c.Request.Context(), rawIDToken)
+		if err != nil {
+			return nil, err
+		}
+		role, ok := a.role(claims)
+		if !ok {
+			return nil, errors.New("user not permitted")
+		}
+		return &user{
+			Subject: claims.Subject(),
+			Name:    claimsName(claims),
+			Role:    role,
+		}, nil
+	}
+
+	cookie, err := c.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, errMissingSession
+	}
+	var claims sessionClaims
+	if err := a.parse(cookie, &claims, sessionAudience); err != nil {
+		return nil, err
+	}
+	if claims.Role != roleAdmin && claims.Role != roleReadOnly {
+		return nil, errors.New("invalid role")
+	}
+	return &user{
+		Subject: claims.Subject,
+		Name:    claims.Name,
+		Role:    claims.Role,
+	}, nil
+}
+
+// loginRoute redirects the user to log in with the provider.
+func (a *oidcAuth) loginRoute(c *gin.Context) {
+	login := loginClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{loginAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(loginTimeout)),
+		},
+		State:    randomString(),
+		Nonce:    randomString(),
+		Redirect: safeRedirect(c.Query("redirect")),
+	}
+
+	authURL, err := a.provider.AuthCodeURL(
+		c.Request.Context(), login.State, login.Nonce,
+	)
+	if err != nil {
+		a.logger.Warn("failed to get auth url", zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "oidc provider unavailable"})
+		return
+	}
+
+	cookie, err := a.sign(login)
+	if err != nil {
+		a.logger.Error("failed to sign login cookie", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	a.setCookie(c, loginCookieName, cookie, loginTimeout)
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// callbackRoute handles the provider redirecting the user back to Piko after
+// logging in.
+func (a *oidcAuth) callbackRoute(c *gin.Context) {
+	loginCookie, err := c.Cookie(loginCookieName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing login state"})
+		return
+	}
+	a.setCookie(c, loginCookieName, "", -1)
+
+	var login loginClaims
+	if err := a.parse(loginCookie, &login, loginAudience); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid login state"})
+		return
+	}
+	if c.Query("state") != login.State {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid login state"})
+		return
+	}
+	if errMessage := c.Query("error"); errMessage != "" {
+		a.logger.Warn("login failed", zap.String("error", errMessage))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login failed"})
+		return
+	}
+
+	claims, err := a.provider.Exchange(c.Request.Context(), c.Query("code"))
+	if err != nil {
+		a.logger.Warn("failed to exchange code", zap.Error(err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login failed"})
+		return
+	}
+	if claims.String("nonce") != login.Nonce {
+		a.logger.Warn("login nonce mismatch")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login failed"})
+		return
+	}
+
+	role, ok := a.role(claims)
+	if !ok {
+		a.logger.Warn(
+			"user not permitted",
+			zap.String("subject", claims.Subject()),
+			zap.String("name", claimsName(claims)),
+		)
+		c.JSON(http.StatusForbidden, gin.H{"error": "not permitted"})
+		return
+	}
+
+	session, err := a.sign(sessionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   claims.Subject(),
+			Audience:  jwt.ClaimStrings{sessionAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(a.conf.SessionDuration)),
+		},
+		Name: claimsName(claims),
+		Role: role,
+	})
+	if err != nil {
+		a.logger.Error("failed to sign session cookie", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	a.setCookie(c, sessionCookieName, session, a.conf.SessionDuration)
+
+	a.logger.Info(
+		"user logged in",
+		zap.String("subject", claims.Subject()),
+		zap.String("name", claimsName(claims)),
+		zap.String("role", string(role)),
+	)
+
+	c.Redirect(http.StatusFound, login.Redirect)
+}
+
+func (a *oidcAuth) logoutRoute(c *gin.Context) {
+	a.setCookie(c, sessionCookieName, "", -1)
+	c.JSON(http.StatusOK, gin.H{"status": "logged out"})
+}
+
+// role returns the role of the user with the given claims, or false if the
+// user isn't permitted any role.
+func (a *oidcAuth) role(claims oidc.Claims) (role, bool) {
+	if len(a.conf.AdminGroups) == 0 && len(a.conf.ReadOnlyGroups) == 0 {
+		return roleAdmin, true
+	}
+
+	groups := claims.Strings(a.conf.GroupsClaim)
+	if containsAny(a.conf.AdminGroups, groups) {
+		return roleAdmin, true
+	}
+	if containsAny(a.conf.ReadOnlyGroups, groups) {
+		return roleReadOnly, true
+	}
+	return "", false
+}
+
+func (a *oidcAuth) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(a.conf.SessionKey))
+}
+
+func (a *oidcAuth) parse(s string, claims jwt.Claims, audience string) error {
+	_, err := jwt.ParseWithClaims(
+		s,
+		claims,
+		func(*jwt.Token) (any, error) {
+			return []byte(a.conf.SessionKey), nil
+		},
+		jwt.WithValidMethods([]string{"HS256"}),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+	return err
+}
+
+func (a *oidcAuth) setCookie(
+	c *gin.Context,
+	name string,
+	value string,
+	maxAge time.Duration,
+) {
+	secure := strings.HasPrefix(a.conf.RedirectURL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, int(maxAge.Seconds()), "/", "", secure, true)
+}
+
+// isPublicPath returns whether the path doesn't require authentication.
+func isPublicPath(path string) bool {
+	switch path {
+	case "/health", "/ready", "/metrics":
+		return true
+	default:
+		return strings.HasPrefix(path, "/oidc/")
+	}
+}
+
+// safeRedirect returns the redirect path if it's a local path, to avoid
+// redirecting to other sites after login, otherwise the dashboard.
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") ||
+		strings.HasPrefix(redirect, "//") ||
+		strings.HasPrefix(redirect, "/\\") {
+		return "/dashboard"
+	}
+	return redirect
+}
+
+func claimsName(claims oidc.Claims) string {
+	if email := claims.String("email"); email != "" {
+		return email
+	}
+	return claims.String("name")
+}
+
+func containsAny(values []string, targets []string) bool {
+	for _, target := range targets {
+		if slices.Contains(values, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	// Read never returns an error.
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,232 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/oidc"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/lockout"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testOIDCConfig() config.OIDCConfig {
+	return config.OIDCConfig{
+		IssuerURL:       "https://idp.example.com",
+		ClientID:        "my-client",
+		RedirectURL:     "https://piko.example.com/oidc/callback",
+		Scopes:          []string{"openid"},
+		GroupsClaim:     "groups",
+		AdminGroups:     []string{"admins"},
+		ReadOnlyGroups:  []string{"devs"},
+		SessionKey:      "01234567890123456789012345678901",
+		SessionDuration: time.Hour,
+	}
+}
+
+func TestOIDCAuth_Authenticate(t *testing.T) {
+	a := newOIDCAuth(testOIDCConfig(), nil, log.NewNopLogger())
+
+	router := gin.New()
+	router.Use(a.Authenticate)
+	a.Register(router)
+	router.GET("/health", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/dashboard", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/status", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.PUT("/log", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	session := func(role role, expiry time.Time) string {
+		s, err := a.sign(sessionClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   "user-1",
+				Audience:  jwt.ClaimStrings{sessionAudience},
+				ExpiresAt: jwt.NewNumericDate(expiry),
+			},
+			Role: role,
+		})
+		require.NoError(t, err)
+		return s
+	}
+
+	request := func(method string, path string, cookie string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("public path", func(t *testing.T) {
+		w := request(http.MethodGet, "/health", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		w := request(http.MethodGet, "/status", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("unauthenticated dashboard", func(t *testing.T) {
+		w := request(http.MethodGet, "/dashboard", "")
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(
+			t, "/oidc/login?redirect=%2Fdashboard", w.Header().Get("Location"),
+		)
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		cookie := session(roleAdmin, time.Now().Add(time.Hour))
+
+		w := request(http.MethodGet, "/status", cookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = request(http.MethodPut, "/log", cookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		cookie := session(roleReadOnly, time.Now().Add(time.Hour))
+
+		w := request(http.MethodGet, "/status", cookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = request(http.MethodPut, "/log", cookie)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		cookie := session(roleAdmin, time.Now().Add(-time.Hour))
+
+		w := request(http.MethodGet, "/status", cookie)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("invalid session", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Audience:  jwt.ClaimStrings{sessionAudience},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+			Role: roleAdmin,
+		})
+		cookie, err := token.SignedString([]byte("invalid-key"))
+		require.NoError(t, err)
+
+		w := request(http.MethodGet, "/status", cookie)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("login state as session", func(t *testing.T) {
+		cookie, err := a.sign(loginClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Audience:  jwt.ClaimStrings{loginAudience},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		})
+		require.NoError(t, err)
+
+		w := request(http.MethodGet, "/status", cookie)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestOIDCAuth_Lockout(t *testing.T) {
+	a := newOIDCAuth(testOIDCConfig(), lockout.NewLockout(config.LockoutConfig{
+		MaxFailures: 2,
+		Window:      time.Minute,
+		Duration:    time.Minute,
+	}, "admin", log.NewNopLogger()), log.NewNopLogger())
+
+	router := gin.New()
+	router.Use(a.Authenticate)
+	router.GET("/status", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(cookie string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/status", nil)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// Requests without a session don't count as failed attempts.
+	for i := 0; i != 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, request("").Code)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("invalid").Code)
+	assert.Equal(t, http.StatusUnauthorized, request("invalid").Code)
+
+	// Once locked out, even valid sessions are rejected.
+	cookie, err := a.sign(sessionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user-1",
+			Audience:  jwt.ClaimStrings{sessionAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Role: roleAdmin,
+	})
+	require.NoError(t, err)
+	w := request(cookie)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestOIDCAuth_Role(t *testing.T) {
+	t.Run("groups", func(t *testing.T) {
+		a := newOIDCAuth(testOIDCConfig(), nil, log.NewNopLogger())
+
+		r, ok := a.role(oidc.Claims{"groups": []any{"devs", "admins"}})
+		assert.True(t, ok)
+		assert.Equal(t, roleAdmin, r)
+
+		r, ok = a.role(oidc.Claims{"groups": []any{"devs"}})
+		assert.True(t, ok)
+		assert.Equal(t, roleReadOnly, r)
+
+		_, ok = a.role(oidc.Claims{"groups": []any{"other"}})
+		assert.False(t, ok)
+
+		_, ok = a.role(oidc.Claims{})
+		assert.False(t, ok)
+	})
+
+	t.Run("no groups configured", func(t *testing.T) {
+		conf := testOIDCConfig()
+		conf.AdminGroups = nil
+		conf.ReadOnlyGroups = nil
+		a := newOIDCAuth(conf, nil, log.NewNopLogger())
+
+		r, ok := a.role(oidc.Claims{})
+		assert.True(t, ok)
+		assert.Equal(t, roleAdmin, r)
+	})
+}
+
+func TestSafeRedirect(t *testing.T) {
+	assert.Equal(t, "/status/proxy", safeRedirect("/status/proxy"))
+	assert.Equal(t, "/dashboard", safeRedirect(""))
+	assert.Equal(t, "/dashboard", safeRedirect("https://evil.example.com"))
+	assert.Equal(t, "/dashboard", safeRedirect("//evil.example.com"))
+	assert.Equal(t, "/dashboard", safeRedirect("/\\evil.example.com"))
+}
@@ -2,6 +2,7 @@ package admin
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/nodeauth"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -17,24 +19,52 @@ type contextKey int
 
 const (
 	hostContextKey contextKey = iota
+	clientIPContextKey
 )
 
 type ReverseProxy struct {
 	proxy *httputil.ReverseProxy
 
+	// nodeAuth authenticates requests forwarded to other nodes, including
+	// the IP of the client that made the request. Nil if forwarded requests
+	// aren't authenticated.
+	nodeAuth *nodeauth.Authenticator
+
 	logger log.Logger
 }
 
-func NewReverseProxy(logger log.Logger) *ReverseProxy {
+// NewReverseProxy creates a proxy to forward admin requests to other nodes.
+//
+// If tlsConfig is not nil, requests are forwarded using HTTPS with the given
+// TLS configuration.
+func NewReverseProxy(tlsConfig *tls.Config, logger log.Logger) *ReverseProxy {
 	rp := &ReverseProxy{
 		logger: logger,
 	}
 
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		scheme = "https"
+		transport.TLSClientConfig = tlsConfig
+	}
+
 	rp.proxy = &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
-			req.URL.Scheme = "http"
+			req.URL.Scheme = scheme
 			req.URL.Host = req.Context().Value(hostContextKey).(string)
+
+			// Remove the 'forward' query so the target node always handles
+			// the request itself, rather than forwarding again if its view
+			// of the cluster differs (which could cause a forwarding loop).
+			query := req.URL.Query()
+			query.Del("forward")
+			req.URL.RawQuery = query.Encode()
+
+			clientIP, _ := req.Context().Value(clientIPContextKey).(string)
+			rp.nodeAuth.SignForwardedRequest(req, clientIP)
 		},
+		Transport:    transport,
 		ErrorLog:     logger.StdLogger(zapcore.WarnLevel),
 		ErrorHandler: rp.errorHandler,
 	}
@@ -7,9 +7,13 @@ import (
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"sync/atomic"
 
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/lockout"
+	"github.com/andydunstall/piko/server/nodeauth"
 	"github.com/andydunstall/piko/server/status"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
@@ -31,34 +35,72 @@ type Server struct {
 
 	router *gin.Engine
 
+	// joined indicates whether the node has completed its initial attempt to
+	// join the cluster.
+	joined atomic.Bool
+
+	// oidc authenticates admin users, or is nil if authentication is
+	// disabled.
+	oidc *oidcAuth
+
+	// nodeAuth authenticates requests forwarded from other nodes. Nil if
+	// forwarded requests aren't authenticated.
+	nodeAuth *nodeauth.Authenticator
+
+	auditLogger log.Logger
+
 	logger log.Logger
 }
 
+// NewServer creates the admin server.
+//
+// tlsConfig configures TLS on the admin listener, and forwardTLSConfig
+// configures TLS when forwarding admin requests to other nodes. Both are nil
+// if TLS is disabled.
 func NewServer(
 	clusterState *cluster.State,
 	registry *prometheus.Registry,
+	httpConfig config.HTTPConfig,
 	tlsConfig *tls.Config,
+	forwardTLSConfig *tls.Config,
 	logger log.Logger,
 ) *Server {
 	logger = logger.WithSubsystem("admin")
 
 	router := gin.New()
+	// Don't trust any proxies, so the client IP can't be spoofed using
+	// forwarding headers.
+	_ = router.SetTrustedProxies(nil)
+
 	server := &Server{
 		clusterState: clusterState,
 		registry:     registry,
-		proxy:        NewReverseProxy(logger),
+		proxy:        NewReverseProxy(forwardTLSConfig, logger),
 		httpServer: &http.Server{
-			Handler:   router,
-			TLSConfig: tlsConfig,
-			ErrorLog:  logger.StdLogger(zapcore.WarnLevel),
+			Handler:           router,
+			TLSConfig:         tlsConfig,
+			ReadTimeout:       httpConfig.ReadTimeout,
+			ReadHeaderTimeout: httpConfig.ReadHeaderTimeout,
+			WriteTimeout:      httpConfig.WriteTimeout,
+			IdleTimeout:       httpConfig.IdleTimeout,
+			MaxHeaderBytes:    httpConfig.MaxHeaderBytes,
+			ErrorLog:          logger.StdLogger(zapcore.WarnLevel),
 		},
-		router: router,
-		logger: logger,
+		router:      router,
+		auditLogger: logger.WithSubsystem("admin.audit"),
+		logger:      logger,
 	}
 
 	// Recover from panics.
 	router.Use(gin.CustomRecoveryWithWriter(nil, server.panicRoute))
 
+	router.Use(server.nodeForwardInterceptor)
+	// Note the audit log is before authentication so rejected requests are
+	// also logged.
+	router.Use(server.auditInterceptor)
+	// Note authentication is before forwarding so unauthenticated requests
+	// aren't forwarded.
+	router.Use(server.authInterceptor)
 	if clusterState != nil {
 		router.Use(server.forwardInterceptor)
 	}
@@ -68,6 +110,26 @@ func NewServer(
 	return server
 }
 
+// SetOIDC enables authenticating admin users with the configured OpenID
+// Connect provider. Must be called before serving requests.
+//
+// If lockout is not nil, clients are locked out after repeated failed
+// authentication attempts.
+func (s *Server) SetOIDC(conf config.OIDCConfig, lockout *lockout.Lockout) {
+	s.oidc = newOIDCAuth(conf, lockout, s.logger)
+	s.oidc.Register(s.router)
+}
+
+// SetNodeAuthToken sets the token shared by nodes in the cluster, used to
+// authenticate the client IP of requests forwarded between nodes.
+//
+// Must be called before serving requests.
+func (s *Server) SetNodeAuthToken(token string) {
+	auth := nodeauth.NewAuthenticator(token)
+	s.nodeAuth = auth
+	s.proxy.nodeAuth = auth
+}
+
 func (s *Server) Serve(ln net.Listener) error {
 	s.logger.Info(
 		"starting admin server",
@@ -93,14 +155,32 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return s.httpServer.Shutdown(ctx)
 }
 
+// SetJoined marks the node as having completed its initial attempt to join
+// the cluster. Until then '/ready' reports the node as unavailable.
+func (s *Server) SetJoined() {
+	s.joined.Store(true)
+}
+
 func (s *Server) AddStatus(route string, handler status.Handler) {
 	group := s.router.Group("/status").Group(route)
 	handler.Register(group)
 }
 
+// AddHandler registers the routes of the given handler under the given route.
+//
+// Unlike AddStatus, the handler isn't limited to inspecting the node status
+// so may register routes that modify the node.
+func (s *Server) AddHandler(route string, handler status.Handler) {
+	group := s.router.Group(route)
+	handler.Register(group)
+}
+
 func (s *Server) registerRoutes(router *gin.Engine) {
 	router.GET("/health", s.healthRoute)
 	router.GET("/ready", s.readyRoute)
+	router.GET("/dashboard", s.dashboardRoute)
+	router.GET("/log", s.getLogRoute)
+	router.PUT("/log", s.updateLogRoute)
 
 	if s.registry != nil {
 		router.GET("/metrics", s.metricsHandler())
@@ -127,9 +207,46 @@ func (s *Server) healthRoute(c *gin.Context) {
 }
 
 func (s *Server) readyRoute(c *gin.Context) {
+	if s.clusterState == nil {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	if !s.joined.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "unavailable",
+			"reason": "joining",
+		})
+		return
+	}
+	// Once draining, load balancers should stop sending new traffic to the
+	// node.
+	if s.clusterState.LocalDraining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "unavailable",
+			"reason": "draining",
+		})
+		return
+	}
+	if s.clusterState.Partitioned() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "degraded",
+			"reason": "partitioned",
+		})
+		return
+	}
 	c.Status(http.StatusOK)
 }
 
+// authInterceptor authenticates admin requests if OIDC is enabled.
+func (s *Server) authInterceptor(c *gin.Context) {
+	if s.oidc == nil {
+		c.Next()
+		return
+	}
+	s.oidc.Authenticate(c)
+}
+
 // forwardInterceptor intercepts all admin requests. If the request has a
 // 'forward' query, the request is forwarded to the node with the requested ID.
 func (s *Server) forwardInterceptor(c *gin.Context) {
@@ -142,19 +259,34 @@ func (s *Server) forwardInterceptor(c *gin.Context) {
 
 	node, ok := s.clusterState.Node(forward)
 	if !ok {
-		c.AbortWithStatus(http.StatusNotFound)
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "node not found"})
 		return
 	}
 
 	ctx := context.WithValue(c.Request.Context(), hostContextKey, node.AdminAddr)
+	ctx = context.WithValue(ctx, clientIPContextKey, c.RemoteIP())
 	r := c.Request.WithContext(ctx)
 
+	// Mark the request as forwarded so it's only audited by the node
+	// handling the request.
+	c.Set(forwardedContextKey, true)
+
 	s.proxy.ServeHTTP(c.Writer, r)
 
 	// Abort to avoid going to the next handler.
 	c.Abort()
 }
 
+// nodeForwardInterceptor records the IP of the client that made the request
+// if the request was forwarded from another node with a valid node
+// credential. The forwarding headers are always removed, so clients can't
+// report their own IP.
+func (s *Server) nodeForwardInterceptor(c *gin.Context) {
+	if clientIP, ok := s.nodeAuth.VerifyForwardedRequest(c.Request); ok {
+		c.Set(forwardedForContextKey, clientIP)
+	}
+}
+
 func (s *Server) panicRoute(c *gin.Context, err any) {
 	s.logger.Error(
 		"handler panic",
@@ -167,7 +299,11 @@ func (s *Server) panicRoute(c *gin.Context, err any) {
 func (s *Server) metricsHandler() gin.HandlerFunc {
 	h := promhttp.HandlerFor(
 		s.registry,
-		promhttp.HandlerOpts{Registry: s.registry},
+		promhttp.HandlerOpts{
+			Registry: s.registry,
+			// Enable OpenMetrics to expose exemplars.
+			EnableOpenMetrics: true,
+		},
 	)
 	return func(c *gin.Context) {
 		h.ServeHTTP(c.Writer, c.Request)
@@ -4,14 +4,20 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/pkg/testutil"
 	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/nodeauth"
 	"github.com/andydunstall/piko/server/status"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
@@ -39,6 +45,8 @@ func TestServer_AdminRoutes(t *testing.T) {
 	s := NewServer(
 		nil,
 		prometheus.NewRegistry(),
+		config.HTTPConfig{},
+		nil,
 		nil,
 		log.NewNopLogger(),
 	)
@@ -74,6 +82,16 @@ func TestServer_AdminRoutes(t *testing.T) {
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 	})
 
+	t.Run("dashboard", func(t *testing.T) {
+		url := fmt.Sprintf("http://%s/dashboard", ln.Addr().String())
+		resp, err := http.Get(url)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	})
+
 	t.Run("not found", func(t *testing.T) {
 		url := fmt.Sprintf("http://%s/foo", ln.Addr().String())
 		resp, err := http.Get(url)
@@ -84,6 +102,313 @@ func TestServer_AdminRoutes(t *testing.T) {
 	})
 }
 
+func TestServer_Log(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	logger, err := log.NewLogger("warn", nil)
+	require.NoError(t, err)
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		config.HTTPConfig{},
+		nil,
+		nil,
+		logger,
+	)
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	url := fmt.Sprintf("http://%s/log", ln.Addr().String())
+
+	t.Run("get", func(t *testing.T) {
+		resp, err := http.Get(url)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var conf log.Config
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&conf))
+		assert.Equal(t, log.Config{
+			Level:      "warn",
+			Subsystems: []string{},
+		}, conf)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		req, err := http.NewRequest(
+			http.MethodPut,
+			url,
+			strings.NewReader(`{"level": "error", "subsystems": ["gossip"]}`),
+		)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var conf log.Config
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&conf))
+		assert.Equal(t, log.Config{
+			Level:      "error",
+			Subsystems: []string{"gossip"},
+		}, conf)
+
+		// Loggers derived from the server logger must be updated.
+		assert.Equal(t, "error", logger.WithSubsystem("proxy").Level())
+		assert.Equal(t, []string{"gossip"}, logger.Subsystems())
+	})
+
+	t.Run("update subsystems only", func(t *testing.T) {
+		req, err := http.NewRequest(
+			http.MethodPut,
+			url,
+			strings.NewReader(`{"subsystems": []}`),
+		)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var conf log.Config
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&conf))
+		assert.Equal(t, log.Config{
+			Level:      "error",
+			Subsystems: []string{},
+		}, conf)
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		req, err := http.NewRequest(
+			http.MethodPut,
+			url,
+			strings.NewReader(`{"level": "foo"}`),
+		)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, "error", logger.Level())
+	})
+}
+
+func TestServer_Audit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	logPath := filepath.Join(t.TempDir(), "piko.log")
+	logger, err := log.NewLoggerFromConfig(log.Config{
+		Level: "info",
+		File: log.FileConfig{
+			Path: logPath,
+		},
+	})
+	require.NoError(t, err)
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		config.HTTPConfig{},
+		nil,
+		nil,
+		logger,
+	)
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	url := fmt.Sprintf("http://%s/log", ln.Addr().String())
+
+	// Requests that don't modify the node aren't audited.
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	req, err := http.NewRequest(
+		http.MethodPut, url, strings.NewReader(`{"level": "debug"}`),
+	)
+	require.NoError(t, err)
+	req.Header.Set("x-piko-caller", "alice@laptop")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// Verify the handler still received the audited body.
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "debug", logger.Level())
+
+	records := readAuditRecords(t, logPath)
+	require.Equal(t, 1, len(records))
+	assert.Equal(t, http.MethodPut, records[0].Method)
+	assert.Equal(t, "/log", records[0].Path)
+	assert.Equal(t, `{"level": "debug"}`, records[0].Body)
+	assert.Equal(t, http.StatusOK, records[0].Status)
+	assert.Equal(t, "127.0.0.1", records[0].ClientIP)
+	assert.Equal(t, "alice@laptop", records[0].Caller)
+}
+
+func TestServer_AuditClientIP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	logPath := filepath.Join(t.TempDir(), "piko.log")
+	logger, err := log.NewLoggerFromConfig(log.Config{
+		Level: "info",
+		File: log.FileConfig{
+			Path: logPath,
+		},
+	})
+	require.NoError(t, err)
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		config.HTTPConfig{},
+		nil,
+		nil,
+		logger,
+	)
+	s.SetNodeAuthToken("my-token")
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	url := fmt.Sprintf("http://%s/log", ln.Addr().String())
+
+	// Forwarding headers from clients are ignored.
+	req, err := http.NewRequest(
+		http.MethodPut, url, strings.NewReader(`{"level": "info"}`),
+	)
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-For", "10.26.104.10")
+	req.Header.Set("X-Real-IP", "10.26.104.10")
+	req.Header.Set(nodeauth.ForwardedForHeader, "10.26.104.10")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// Forwarding headers from a node with an invalid credential are ignored.
+	req, err = http.NewRequest(
+		http.MethodPut, url, strings.NewReader(`{"level": "info"}`),
+	)
+	require.NoError(t, err)
+	nodeauth.NewAuthenticator("invalid-token").SignForwardedRequest(
+		req, "10.26.104.10",
+	)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// Requests forwarded from an authenticated node include the client IP.
+	req, err = http.NewRequest(
+		http.MethodPut, url, strings.NewReader(`{"level": "info"}`),
+	)
+	require.NoError(t, err)
+	nodeauth.NewAuthenticator("my-token").SignForwardedRequest(
+		req, "10.26.104.10",
+	)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	records := readAuditRecords(t, logPath)
+	require.Equal(t, 3, len(records))
+	for _, record := range records {
+		assert.Equal(t, "127.0.0.1", record.ClientIP)
+	}
+	assert.Equal(t, "", records[0].ForwardedFor)
+	assert.Equal(t, "", records[1].ForwardedFor)
+	assert.Equal(t, "10.26.104.10", records[2].ForwardedFor)
+}
+
+func TestServer_AuditAuthFailed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	logPath := filepath.Join(t.TempDir(), "piko.log")
+	logger, err := log.NewLoggerFromConfig(log.Config{
+		Level: "info",
+		File: log.FileConfig{
+			Path: logPath,
+		},
+	})
+	require.NoError(t, err)
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		config.HTTPConfig{},
+		nil,
+		nil,
+		logger,
+	)
+	s.SetOIDC(testOIDCConfig(), nil)
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	// Rejected requests are audited even if they don't modify the node.
+	resp, err := http.Get(fmt.Sprintf("http://%s/status", ln.Addr().String()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, err := http.NewRequest(
+		http.MethodPut,
+		fmt.Sprintf("http://%s/log", ln.Addr().String()),
+		strings.NewReader(`{"level": "debug"}`),
+	)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "info", logger.Level())
+
+	records := readAuditRecords(t, logPath)
+	require.Equal(t, 2, len(records))
+	assert.Equal(t, http.MethodGet, records[0].Method)
+	assert.Equal(t, "/status", records[0].Path)
+	assert.Equal(t, http.StatusUnauthorized, records[0].Status)
+	assert.True(t, records[0].AuthFailed)
+	assert.Equal(t, http.MethodPut, records[1].Method)
+	assert.Equal(t, "/log", records[1].Path)
+	assert.Equal(t, `{"level": "debug"}`, records[1].Body)
+	assert.True(t, records[1].AuthFailed)
+}
+
+func readAuditRecords(t *testing.T, logPath string) []auditRecord {
+	b, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+
+	var records []auditRecord
+	for _, line := range strings.Split(string(b), "\n") {
+		var entry struct {
+			Subsystem string      `json:"subsystem"`
+			Request   auditRecord `json:"request"`
+		}
+		if json.Unmarshal([]byte(line), &entry) != nil {
+			continue
+		}
+		if entry.Subsystem == "admin.audit" {
+			records = append(records, entry.Request)
+		}
+	}
+	return records
+}
+
 func TestServer_StatusRoutes(t *testing.T) {
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
@@ -91,6 +416,8 @@ func TestServer_StatusRoutes(t *testing.T) {
 	s := NewServer(
 		nil,
 		prometheus.NewRegistry(),
+		config.HTTPConfig{},
+		nil,
 		nil,
 		log.NewNopLogger(),
 	)
@@ -125,6 +452,102 @@ func TestServer_StatusRoutes(t *testing.T) {
 	})
 }
 
+func TestServer_HandlerRoutes(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		config.HTTPConfig{},
+		nil,
+		nil,
+		log.NewNopLogger(),
+	)
+	s.AddHandler("/myhandler", &fakeStatus{})
+
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	url := fmt.Sprintf("http://%s/myhandler/foo", ln.Addr().String())
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	buf := new(bytes.Buffer)
+	//nolint
+	buf.ReadFrom(resp.Body)
+	assert.Equal(t, []byte("foo"), buf.Bytes())
+}
+
+func TestServer_Ready(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	clusterState := cluster.NewState(&cluster.Node{
+		ID: "local",
+	}, log.NewNopLogger())
+
+	s := NewServer(
+		clusterState,
+		prometheus.NewRegistry(),
+		config.HTTPConfig{},
+		nil,
+		nil,
+		log.NewNopLogger(),
+	)
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	url := fmt.Sprintf("http://%s/ready", ln.Addr().String())
+
+	t.Run("joining", func(t *testing.T) {
+		resp, err := http.Get(url)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+
+	s.SetJoined()
+
+	t.Run("ready", func(t *testing.T) {
+		resp, err := http.Get(url)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		clusterState.SetLocalDraining(true)
+		defer clusterState.SetLocalDraining(false)
+
+		resp, err := http.Get(url)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+
+	t.Run("partitioned", func(t *testing.T) {
+		clusterState.SetExpectedSize(3)
+		defer clusterState.SetExpectedSize(0)
+
+		resp, err := http.Get(url)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+}
+
 // TestServer_Forward tests forwarding an admin request to another node
 // in the cluster.
 func TestServer_Forward(t *testing.T) {
@@ -139,6 +562,8 @@ func TestServer_Forward(t *testing.T) {
 	s1 := NewServer(
 		state1,
 		prometheus.NewRegistry(),
+		config.HTTPConfig{},
+		nil,
 		nil,
 		log.NewNopLogger(),
 	)
@@ -165,6 +590,8 @@ func TestServer_Forward(t *testing.T) {
 	s2 := NewServer(
 		state2,
 		prometheus.NewRegistry(),
+		config.HTTPConfig{},
+		nil,
 		nil,
 		log.NewNopLogger(),
 	)
@@ -195,6 +622,31 @@ func TestServer_Forward(t *testing.T) {
 		defer resp.Body.Close()
 
 		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+		assert.Equal(t, "node not found", errResp.Error)
+	})
+
+	// Tests a forwarded request is always handled by the target node, even
+	// if the target has a different view of the cluster.
+	t.Run("forward loop", func(t *testing.T) {
+		// Add a node that node 2 thinks is at its own address.
+		state2.AddNode(&cluster.Node{
+			ID:        "node-3",
+			AdminAddr: ln2.Addr().String(),
+		})
+
+		url := fmt.Sprintf("http://%s/status/mystatus/foo?forward=node-3", ln2.Addr().String())
+		resp, err := http.Get(url)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		// Node 2 handles the forwarded request itself, which doesn't have
+		// the status route.
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 	})
 }
 
@@ -211,7 +663,9 @@ func TestServer_TLS(t *testing.T) {
 	s := NewServer(
 		nil,
 		prometheus.NewRegistry(),
+		config.HTTPConfig{},
 		tlsConfig,
+		nil,
 		log.NewNopLogger(),
 	)
 	go func() {
@@ -248,6 +702,72 @@ func TestServer_TLS(t *testing.T) {
 		assert.ErrorContains(t, err, "verify certificate")
 	})
 
+	// Tests forwarding an admin request to another node using TLS.
+	t.Run("forward", func(t *testing.T) {
+		ln1, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		state1 := cluster.NewState(&cluster.Node{
+			ID:        "node-1",
+			AdminAddr: ln1.Addr().String(),
+		}, log.NewNopLogger())
+
+		s1 := NewServer(
+			state1,
+			prometheus.NewRegistry(),
+			config.HTTPConfig{},
+			&tls.Config{Certificates: []tls.Certificate{cert}},
+			&tls.Config{RootCAs: rootCAPool},
+			log.NewNopLogger(),
+		)
+		s1.AddStatus("/mystatus", &fakeStatus{})
+		go func() {
+			require.NoError(t, s1.Serve(ln1))
+		}()
+		defer s1.Shutdown(context.TODO())
+
+		ln2, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		state2 := cluster.NewState(&cluster.Node{
+			ID:        "node-2",
+			AdminAddr: ln2.Addr().String(),
+		}, log.NewNopLogger())
+		state2.AddNode(&cluster.Node{
+			ID:        "node-1",
+			AdminAddr: ln1.Addr().String(),
+		})
+
+		s2 := NewServer(
+			state2,
+			prometheus.NewRegistry(),
+			config.HTTPConfig{},
+			&tls.Config{Certificates: []tls.Certificate{cert}},
+			&tls.Config{RootCAs: rootCAPool},
+			log.NewNopLogger(),
+		)
+		go func() {
+			require.NoError(t, s2.Serve(ln2))
+		}()
+		defer s2.Shutdown(context.TODO())
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs: rootCAPool,
+				},
+			},
+		}
+
+		resp, err := client.Get(fmt.Sprintf(
+			"https://%s/status/mystatus/foo?forward=node-1", ln2.Addr().String(),
+		))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
 	t.Run("http", func(t *testing.T) {
 		url := fmt.Sprintf("http://%s/health", ln.Addr().String())
 		resp, err := http.Get(url)
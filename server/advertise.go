@@ -0,0 +1,188 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/andydunstall/piko/server/config"
+	"github.com/hashicorp/go-sockaddr"
+)
+
+// advertiseAddrFromBindAddr returns the address to advertise to other nodes
+// for the given bind address.
+//
+// If an advertise interface is given, the IP is selected from the interface
+// (either an interface name or a CIDR). Otherwise, if the bind address doesn't
+// include an IP, a private IP of the given family ('auto', 'ipv4' or 'ipv6')
+// is used.
+func advertiseAddrFromBindAddr(
+	bindAddr string,
+	advertiseInterface string,
+	ipFamily string,
+) (string, error) {
+	// Unix domain sockets are advertised as is, since there is no address
+	// other nodes could use instead.
+	if _, ok := config.UnixSocketPath(bindAddr); ok {
+		if advertiseInterface != "" {
+			return "", fmt.Errorf("cannot use advertise interface with a unix socket")
+		}
+		return bindAddr, nil
+	}
+
+	host, port, err := net.SplitHostPort(bindAddr)
+	if err != nil {
+		return "", fmt.Errorf("invalid bind addr: %s: %w", bindAddr, err)
+	}
+
+	if advertiseInterface != "" {
+		ip, err := interfaceIP(advertiseInterface, ipFamily)
+		if err != nil {
+			return "", err
+		}
+		return net.JoinHostPort(ip, port), nil
+	}
+
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		ip, err := privateIP(ipFamily)
+		if err != nil {
+			return "", err
+		}
+		return net.JoinHostPort(ip, port), nil
+	}
+	return bindAddr, nil
+}
+
+// interfaceIP returns the IP to advertise from the given interface, which is
+// either an interface name (such as 'eth1') or a CIDR (such as
+// '10.26.0.0/16') to select the first interface address in the range.
+//
+// If an interface name is given and the interface has multiple addresses,
+// the address is selected using the given IP family, like privateIP.
+func interfaceIP(advertiseInterface string, ipFamily string) (string, error) {
+	if strings.Contains(advertiseInterface, "/") {
+		_, ipNet, err := net.ParseCIDR(advertiseInterface)
+		if err != nil {
+			return "", fmt.Errorf("invalid advertise interface cidr: %w", err)
+		}
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return "", fmt.Errorf("get interface addrs: %w", err)
+		}
+		for _, addr := range addrs {
+			if ip := addrIP(addr); ip != nil && ipNet.Contains(ip) {
+				return ip.String(), nil
+			}
+		}
+		return "", fmt.Errorf("no interface address in %s", advertiseInterface)
+	}
+
+	iface, err := net.InterfaceByName(advertiseInterface)
+	if err != nil {
+		return "", fmt.Errorf("interface %s: %w", advertiseInterface, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("interface %s: get addrs: %w", advertiseInterface, err)
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		ip := addrIP(addr)
+		if ip == nil || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ip)
+	}
+
+	if ipFamily == "auto" || ipFamily == "ipv4" {
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				return ip.String(), nil
+			}
+		}
+	}
+	if ipFamily == "auto" || ipFamily == "ipv6" {
+		for _, ip := range ips {
+			if ip.To4() == nil {
+				return ip.String(), nil
+			}
+		}
+	}
+
+	if ipFamily == "auto" {
+		return "", fmt.Errorf("interface %s: no address found", advertiseInterface)
+	}
+	return "", fmt.Errorf(
+		"interface %s: no %s address found", advertiseInterface, ipFamily,
+	)
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.IPNet:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	default:
+		return nil
+	}
+}
+
+// privateIP returns the IP of the host to advertise for the given IP family.
+//
+// IPv4 addresses must be private. IPv6 addresses prefer unique local
+// addresses, though fall back to global addresses since IPv6 hosts commonly
+// only have global addresses. Link-local addresses are never used since
+// they require a zone.
+func privateIP(ipFamily string) (string, error) {
+	privateIfs, err := sockaddr.GetPrivateInterfaces()
+	if err != nil {
+		return "", fmt.Errorf("get interface addr: %w", err)
+	}
+
+	var ipv6Ifs sockaddr.IfAddrs
+	ipv6Ifs = append(ipv6Ifs, privateIfs...)
+	if ipFamily != "ipv4" {
+		publicIfs, err := sockaddr.GetPublicInterfaces()
+		if err != nil {
+			return "", fmt.Errorf("get interface addr: %w", err)
+		}
+		ipv6Ifs = append(ipv6Ifs, publicIfs...)
+	}
+
+	if ipFamily == "auto" || ipFamily == "ipv4" {
+		if ip := firstIP(privateIfs, false); ip != "" {
+			return ip, nil
+		}
+	}
+	if ipFamily == "auto" || ipFamily == "ipv6" {
+		if ip := firstIP(ipv6Ifs, true); ip != "" {
+			return ip, nil
+		}
+	}
+
+	if ipFamily == "auto" {
+		return "", fmt.Errorf("no private ip found")
+	}
+	return "", fmt.Errorf("no private %s address found", ipFamily)
+}
+
+// firstIP returns the first IP in the given interfaces of the given family,
+// or an empty string if there are none.
+func firstIP(ifAddrs sockaddr.IfAddrs, ipv6 bool) string {
+	for _, ifAddr := range ifAddrs {
+		ipAddr := sockaddr.ToIPAddr(ifAddr.SockAddr)
+		if ipAddr == nil {
+			continue
+		}
+		ip := (*ipAddr).NetIP()
+		if ip == nil || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if (ip.To4() == nil) == ipv6 {
+			return ip.String()
+		}
+	}
+	return ""
+}
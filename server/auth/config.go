@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"fmt"
+
+	pikoconfig "github.com/andydunstall/piko/pkg/config"
 	"github.com/spf13/pflag"
 )
 
@@ -9,14 +12,26 @@ type Config struct {
 	// connection JWTs.
 	TokenHMACSecretKey string `json:"token_hmac_secret_key" yaml:"token_hmac_secret_key"`
 
+	// TokenHMACSecretKeyFile is the path of a file containing the HMAC
+	// secret key.
+	TokenHMACSecretKeyFile string `json:"token_hmac_secret_key_file" yaml:"token_hmac_secret_key_file"`
+
 	// TokenRSAPublicKey is the public key to authenticate RSA endpoint
 	// connection JWTs.
 	TokenRSAPublicKey string `json:"token_rsa_public_key" yaml:"token_rsa_public_key"`
 
+	// TokenRSAPublicKeyFile is the path of a file containing the RSA public
+	// key.
+	TokenRSAPublicKeyFile string `json:"token_rsa_public_key_file" yaml:"token_rsa_public_key_file"`
+
 	// TokenECDSAPublicKey is the public key to authenticate ECDSA endpoint
 	// connection JWTs.
 	TokenECDSAPublicKey string `json:"token_ecdsa_public_key" yaml:"token_ecdsa_public_key"`
 
+	// TokenECDSAPublicKeyFile is the path of a file containing the ECDSA
+	// public key.
+	TokenECDSAPublicKeyFile string `json:"token_ecdsa_public_key_file" yaml:"token_ecdsa_public_key_file"`
+
 	// TokenAudience is the required 'aud' claim of the authenticated JWTs.
 	//
 	// If not given the 'aud' claim will be ignored.
@@ -32,46 +47,108 @@ func (c *Config) AuthEnabled() bool {
 	return c.TokenHMACSecretKey != "" || c.TokenRSAPublicKey != "" || c.TokenECDSAPublicKey != ""
 }
 
+// LoadSecrets loads any keys configured from files.
+func (c *Config) LoadSecrets() error {
+	if err := pikoconfig.LoadSecretFile(
+		&c.TokenHMACSecretKey, c.TokenHMACSecretKeyFile,
+	); err != nil {
+		return fmt.Errorf("token hmac secret key: %w", err)
+	}
+	if err := pikoconfig.LoadSecretFile(
+		&c.TokenRSAPublicKey, c.TokenRSAPublicKeyFile,
+	); err != nil {
+		return fmt.Errorf("token rsa public key: %w", err)
+	}
+	if err := pikoconfig.LoadSecretFile(
+		&c.TokenECDSAPublicKey, c.TokenECDSAPublicKeyFile,
+	); err != nil {
+		return fmt.Errorf("token ecdsa public key: %w", err)
+	}
+	return nil
+}
+
 func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
+	c.RegisterFlagsWithPrefix(fs, "auth", "endpoint connection")
+}
+
+// RegisterFlagsWithPrefix registers the flags with the given prefix, where
+// tokenType describes the JWTs being authenticated in the flag descriptions.
+func (c *Config) RegisterFlagsWithPrefix(
+	fs *pflag.FlagSet,
+	prefix string,
+	tokenType string,
+) {
+	prefix += "."
+
 	fs.StringVar(
 		&c.TokenHMACSecretKey,
-		"auth.token-hmac-secret-key",
+		prefix+"token-hmac-secret-key",
 		c.TokenHMACSecretKey,
-		`
-Secret key to authenticate HMAC endpoint connection JWTs.`,
+		fmt.Sprintf(`
+Secret key to authenticate HMAC %s JWTs.`, tokenType),
+	)
+	fs.StringVar(
+		&c.TokenHMACSecretKeyFile,
+		prefix+"token-hmac-secret-key-file",
+		c.TokenHMACSecretKeyFile,
+		fmt.Sprintf(`
+Path of a file containing the secret key to authenticate HMAC %s
+JWTs, so the key isn't passed as a flag or environment variable.
+
+The file is re-read when the configuration is reloaded.`, tokenType),
 	)
 	fs.StringVar(
 		&c.TokenRSAPublicKey,
-		"auth.token-rsa-public-key",
+		prefix+"token-rsa-public-key",
 		c.TokenRSAPublicKey,
-		`
-Public key to authenticate RSA endpoint connection JWTs.`,
+		fmt.Sprintf(`
+Public key to authenticate RSA %s JWTs.`, tokenType),
+	)
+	fs.StringVar(
+		&c.TokenRSAPublicKeyFile,
+		prefix+"token-rsa-public-key-file",
+		c.TokenRSAPublicKeyFile,
+		fmt.Sprintf(`
+Path of a file containing the public key to authenticate RSA %s
+JWTs.
+
+The file is re-read when the configuration is reloaded.`, tokenType),
 	)
 	fs.StringVar(
 		&c.TokenECDSAPublicKey,
-		"auth.token-ecdsa-public-key",
+		prefix+"token-ecdsa-public-key",
 		c.TokenECDSAPublicKey,
-		`
-Public key to authenticate ECDSA endpoint connection JWTs.`,
+		fmt.Sprintf(`
+Public key to authenticate ECDSA %s JWTs.`, tokenType),
+	)
+	fs.StringVar(
+		&c.TokenECDSAPublicKeyFile,
+		prefix+"token-ecdsa-public-key-file",
+		c.TokenECDSAPublicKeyFile,
+		fmt.Sprintf(`
+Path of a file containing the public key to authenticate ECDSA %s
+JWTs.
+
+The file is re-read when the configuration is reloaded.`, tokenType),
 	)
 	fs.StringVar(
 		&c.TokenAudience,
-		"auth.token-audience",
+		prefix+"token-audience",
 		c.TokenAudience,
-		`
-Audience of endpoint connection JWT token to verify.
+		fmt.Sprintf(`
+Audience of %s JWT token to verify.
 
 If given the JWT 'aud' claim must match the given audience. Otherwise it
-is ignored.`,
+is ignored.`, tokenType),
 	)
 	fs.StringVar(
 		&c.TokenIssuer,
-		"auth.token-issuer",
+		prefix+"token-issuer",
 		c.TokenIssuer,
-		`
-Issuer of endpoint connection JWT token to verify.
+		fmt.Sprintf(`
+Issuer of %s JWT token to verify.
 
 If given the JWT 'iss' claim must match the given issuer. Otherwise it
-is ignored.`,
+is ignored.`, tokenType),
 	)
 }
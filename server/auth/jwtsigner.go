@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type JWTSignerConfig struct {
+	// Algorithm is the JWT signing algorithm, such as 'HS256' or 'RS512'.
+	// Defaults to the SHA-256 variant for the configured key.
+	Algorithm string
+
+	// One of HMACSecretKey, RSAPrivateKey or ECDSAPrivateKey must be
+	// configured.
+	HMACSecretKey   []byte
+	RSAPrivateKey   *rsa.PrivateKey
+	ECDSAPrivateKey *ecdsa.PrivateKey
+
+	Audience string
+	Issuer   string
+}
+
+// JWTSigner signs endpoint tokens that can be verified by JWTVerifier.
+type JWTSigner struct {
+	method jwt.SigningMethod
+	key    interface{}
+
+	audience string
+	issuer   string
+}
+
+func NewJWTSigner(conf JWTSignerConfig) (*JWTSigner, error) {
+	var keys int
+	var defaultAlgorithm string
+	var key interface{}
+	if len(conf.HMACSecretKey) > 0 {
+		keys++
+		defaultAlgorithm = "HS256"
+		key = conf.HMACSecretKey
+	}
+	if conf.RSAPrivateKey != nil {
+		keys++
+		defaultAlgorithm = "RS256"
+		key = conf.RSAPrivateKey
+	}
+	if conf.ECDSAPrivateKey != nil {
+		keys++
+		defaultAlgorithm = "ES256"
+		key = conf.ECDSAPrivateKey
+	}
+	if keys == 0 {
+		return nil, fmt.Errorf("missing key")
+	}
+	if keys > 1 {
+		return nil, fmt.Errorf("multiple keys configured")
+	}
+
+	algorithm := conf.Algorithm
+	if algorithm == "" {
+		algorithm = defaultAlgorithm
+	}
+	// The algorithm must match the key type, such as 'HS384' requires an
+	// HMAC key.
+	if !strings.HasPrefix(algorithm, defaultAlgorithm[:2]) {
+		return nil, fmt.Errorf(
+			"algorithm %s not supported with configured key", algorithm,
+		)
+	}
+	method := jwt.GetSigningMethod(algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+
+	return &JWTSigner{
+		method:   method,
+		key:      key,
+		audience: conf.Audience,
+		issuer:   conf.Issuer,
+	}, nil
+}
+
+// SignEndpointToken returns a signed JWT for the given endpoint token.
+func (s *JWTSigner) SignEndpointToken(token EndpointToken) (string, error) {
+	claims := endpointJWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       token.ID,
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+			Issuer:   s.issuer,
+		},
+		Piko: pikoEndpointClaims{
+			Endpoints: token.Endpoints,
+			Tenant:    token.Tenant,
+		},
+	}
+	if !token.Expiry.IsZero() {
+		claims.ExpiresAt = jwt.NewNumericDate(token.Expiry)
+	}
+	if s.audience != "" {
+		claims.Audience = jwt.ClaimStrings{s.audience}
+	}
+
+	tokenString, err := jwt.NewWithClaims(s.method, claims).SignedString(s.key)
+	if err != nil {
+		return "", fmt.Errorf("sign: %w", err)
+	}
+	return tokenString, nil
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/elliptic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTSigner(t *testing.T) {
+	t.Run("hs", func(t *testing.T) {
+		secretKey := generateTestHSKey(t)
+
+		for _, alg := range []string{"", "HS256", "HS384", "HS512"} {
+			signer, err := NewJWTSigner(JWTSignerConfig{
+				Algorithm:     alg,
+				HMACSecretKey: secretKey,
+			})
+			require.NoError(t, err)
+
+			expiry := time.Now().Add(time.Hour)
+			tokenString, err := signer.SignEndpointToken(EndpointToken{
+				ID:        "my-token",
+				Expiry:    expiry,
+				Endpoints: []string{"my-endpoint"},
+			})
+			require.NoError(t, err)
+
+			verifier := NewJWTVerifier(JWTVerifierConfig{
+				HMACSecretKey: secretKey,
+			})
+			token, err := verifier.VerifyEndpointToken(tokenString)
+			require.NoError(t, err)
+
+			assert.Equal(t, "my-token", token.ID)
+			assert.Equal(t, []string{"my-endpoint"}, token.Endpoints)
+			assert.Equal(t, expiry.Unix(), token.Expiry.Unix())
+		}
+	})
+
+	t.Run("rs", func(t *testing.T) {
+		privateKey, publicKey := generateTestRSAKeys(t)
+
+		for _, alg := range []string{"", "RS256", "RS384", "RS512"} {
+			signer, err := NewJWTSigner(JWTSignerConfig{
+				Algorithm:     alg,
+				RSAPrivateKey: privateKey,
+			})
+			require.NoError(t, err)
+
+			tokenString, err := signer.SignEndpointToken(EndpointToken{
+				Endpoints: []string{"my-endpoint"},
+			})
+			require.NoError(t, err)
+
+			verifier := NewJWTVerifier(JWTVerifierConfig{
+				RSAPublicKey: publicKey,
+			})
+			token, err := verifier.VerifyEndpointToken(tokenString)
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"my-endpoint"}, token.Endpoints)
+			assert.True(t, token.Expiry.IsZero())
+		}
+	})
+
+	t.Run("es", func(t *testing.T) {
+		privateKey, publicKey := generateTestECDSAKeys(elliptic.P256(), t)
+
+		signer, err := NewJWTSigner(JWTSignerConfig{
+			ECDSAPrivateKey: privateKey,
+		})
+		require.NoError(t, err)
+
+		tokenString, err := signer.SignEndpointToken(EndpointToken{
+			Endpoints: []string{"my-endpoint"},
+		})
+		require.NoError(t, err)
+
+		verifier := NewJWTVerifier(JWTVerifierConfig{
+			ECDSAPublicKey: publicKey,
+		})
+		token, err := verifier.VerifyEndpointToken(tokenString)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"my-endpoint"}, token.Endpoints)
+	})
+
+	t.Run("tenant", func(t *testing.T) {
+		secretKey := generateTestHSKey(t)
+
+		signer, err := NewJWTSigner(JWTSignerConfig{
+			HMACSecretKey: secretKey,
+		})
+		require.NoError(t, err)
+
+		tokenString, err := signer.SignEndpointToken(EndpointToken{
+			Endpoints: []string{"my-endpoint"},
+			Tenant:    "team-a",
+		})
+		require.NoError(t, err)
+
+		verifier := NewJWTVerifier(JWTVerifierConfig{
+			HMACSecretKey: secretKey,
+		})
+		token, err := verifier.VerifyEndpointToken(tokenString)
+		require.NoError(t, err)
+
+		assert.Equal(t, "team-a", token.Tenant)
+	})
+
+	t.Run("audience and issuer", func(t *testing.T) {
+		secretKey := generateTestHSKey(t)
+
+		signer, err := NewJWTSigner(JWTSignerConfig{
+			HMACSecretKey: secretKey,
+			Audience:      "my-audience",
+			Issuer:        "my-issuer",
+		})
+		require.NoError(t, err)
+
+		tokenString, err := signer.SignEndpointToken(EndpointToken{})
+		require.NoError(t, err)
+
+		verifier := NewJWTVerifier(JWTVerifierConfig{
+			HMACSecretKey: secretKey,
+			Audience:      "my-audience",
+			Issuer:        "my-issuer",
+		})
+		_, err = verifier.VerifyEndpointToken(tokenString)
+		assert.NoError(t, err)
+
+		verifier = NewJWTVerifier(JWTVerifierConfig{
+			HMACSecretKey: secretKey,
+			Audience:      "other-audience",
+		})
+		_, err = verifier.VerifyEndpointToken(tokenString)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		secretKey := generateTestHSKey(t)
+
+		signer, err := NewJWTSigner(JWTSignerConfig{
+			HMACSecretKey: secretKey,
+		})
+		require.NoError(t, err)
+
+		tokenString, err := signer.SignEndpointToken(EndpointToken{
+			Expiry: time.Now().Add(-time.Hour),
+		})
+		require.NoError(t, err)
+
+		verifier := NewJWTVerifier(JWTVerifierConfig{
+			HMACSecretKey: secretKey,
+		})
+		_, err = verifier.VerifyEndpointToken(tokenString)
+		assert.Equal(t, ErrExpiredToken, err)
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		_, err := NewJWTSigner(JWTSignerConfig{})
+		assert.ErrorContains(t, err, "missing key")
+	})
+
+	t.Run("multiple keys", func(t *testing.T) {
+		privateKey, _ := generateTestRSAKeys(t)
+		_, err := NewJWTSigner(JWTSignerConfig{
+			HMACSecretKey: generateTestHSKey(t),
+			RSAPrivateKey: privateKey,
+		})
+		assert.ErrorContains(t, err, "multiple keys")
+	})
+
+	t.Run("algorithm key mismatch", func(t *testing.T) {
+		_, err := NewJWTSigner(JWTSignerConfig{
+			Algorithm:     "RS256",
+			HMACSecretKey: generateTestHSKey(t),
+		})
+		assert.ErrorContains(t, err, "not supported")
+	})
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		_, err := NewJWTSigner(JWTSignerConfig{
+			Algorithm:     "HS1",
+			HMACSecretKey: generateTestHSKey(t),
+		})
+		assert.ErrorContains(t, err, "unsupported algorithm")
+	})
+}
@@ -12,6 +12,7 @@ import (
 
 type pikoEndpointClaims struct {
 	Endpoints []string `json:"endpoints"`
+	Tenant    string   `json:"tenant,omitempty"`
 }
 
 type endpointJWTClaims struct {
@@ -60,6 +61,36 @@ func NewJWTVerifier(conf JWTVerifierConfig) *JWTVerifier {
 	return v
 }
 
+// NewJWTVerifierFromConfig creates a verifier using the keys in the given
+// configuration.
+func NewJWTVerifierFromConfig(conf Config) (*JWTVerifier, error) {
+	verifierConf := JWTVerifierConfig{
+		HMACSecretKey: []byte(conf.TokenHMACSecretKey),
+		Audience:      conf.TokenAudience,
+		Issuer:        conf.TokenIssuer,
+	}
+
+	if conf.TokenRSAPublicKey != "" {
+		rsaPublicKey, err := jwt.ParseRSAPublicKeyFromPEM(
+			[]byte(conf.TokenRSAPublicKey),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("parse rsa public key: %w", err)
+		}
+		verifierConf.RSAPublicKey = rsaPublicKey
+	}
+	if conf.TokenECDSAPublicKey != "" {
+		ecdsaPublicKey, err := jwt.ParseECPublicKeyFromPEM(
+			[]byte(conf.TokenECDSAPublicKey),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("parse ecdsa public key: %w", err)
+		}
+		verifierConf.ECDSAPublicKey = ecdsaPublicKey
+	}
+	return NewJWTVerifier(verifierConf), nil
+}
+
 func (v *JWTVerifier) VerifyEndpointToken(tokenString string) (EndpointToken, error) {
 	claims := &endpointJWTClaims{}
 
@@ -110,14 +141,19 @@ func (v *JWTVerifier) VerifyEndpointToken(tokenString string) (EndpointToken, er
 	if !token.Valid {
 		return EndpointToken{}, ErrInvalidToken
 	}
+	if !ValidTenant(claims.Piko.Tenant) {
+		return EndpointToken{}, ErrInvalidToken
+	}
 
 	var expiry time.Time
 	if claims.ExpiresAt != nil {
 		expiry = claims.ExpiresAt.Time
 	}
 	return EndpointToken{
+		ID:        claims.ID,
 		Expiry:    expiry,
 		Endpoints: claims.Piko.Endpoints,
+		Tenant:    claims.Piko.Tenant,
 	}, nil
 }
 
@@ -229,6 +229,31 @@ func TestJWTVerifier_Invalid(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("tenant", func(t *testing.T) {
+		secretKey := generateTestHSKey(t)
+
+		endpointClaims := endpointJWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+			Piko: pikoEndpointClaims{
+				Endpoints: []string{"my-endpoint"},
+				// Tenants must not contain the separator.
+				Tenant: "team-a/foo",
+			},
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, endpointClaims)
+		tokenString, err := token.SignedString([]byte(secretKey))
+		assert.NoError(t, err)
+
+		verifier := NewJWTVerifier(JWTVerifierConfig{
+			HMACSecretKey: secretKey,
+		})
+		_, err = verifier.VerifyEndpointToken(tokenString)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
 	t.Run("issuer", func(t *testing.T) {
 		secretKey := generateTestHSKey(t)
 
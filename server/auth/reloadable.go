@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"sync"
+)
+
+// ReloadableVerifier is a Verifier whose underlying verifier can be replaced
+// at runtime, such as to update the token verification keys without
+// restarting the server.
+type ReloadableVerifier struct {
+	verifier Verifier
+
+	mu sync.RWMutex
+}
+
+func NewReloadableVerifier(verifier Verifier) *ReloadableVerifier {
+	return &ReloadableVerifier{
+		verifier: verifier,
+	}
+}
+
+func (v *ReloadableVerifier) VerifyEndpointToken(token string) (EndpointToken, error) {
+	v.mu.RLock()
+	verifier := v.verifier
+	v.mu.RUnlock()
+
+	return verifier.VerifyEndpointToken(token)
+}
+
+// Reload replaces the underlying verifier. Tokens are verified using the new
+// verifier from now on, though connections that have already been
+// authenticated are not affected.
+func (v *ReloadableVerifier) Reload(verifier Verifier) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.verifier = verifier
+}
+
+var _ Verifier = &ReloadableVerifier{}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadableVerifier(t *testing.T) {
+	oldKey := generateTestHSKey(t)
+	newKey := generateTestHSKey(t)
+
+	sign := func(key []byte) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, endpointJWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		})
+		tokenString, err := token.SignedString(key)
+		require.NoError(t, err)
+		return tokenString
+	}
+
+	verifier := NewReloadableVerifier(NewJWTVerifier(JWTVerifierConfig{
+		HMACSecretKey: oldKey,
+	}))
+
+	_, err := verifier.VerifyEndpointToken(sign(oldKey))
+	assert.NoError(t, err)
+	_, err = verifier.VerifyEndpointToken(sign(newKey))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	verifier.Reload(NewJWTVerifier(JWTVerifierConfig{
+		HMACSecretKey: newKey,
+	}))
+
+	_, err = verifier.VerifyEndpointToken(sign(oldKey))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = verifier.VerifyEndpointToken(sign(newKey))
+	assert.NoError(t, err)
+}
@@ -2,22 +2,39 @@ package auth
 
 import (
 	"errors"
+	"strings"
 	"time"
 )
 
 var (
 	ErrInvalidToken = errors.New("invalid token")
 	ErrExpiredToken = errors.New("expired token")
+	ErrRevokedToken = errors.New("revoked token")
 )
 
 type EndpointToken struct {
+	// ID is the unique identifier of the token ('jti' claim), used to
+	// revoke the token, or empty if the token doesn't have an ID.
+	ID string
+
 	// Expiry contains the time the token expires, or zero if there is no
 	// expiry.
 	Expiry time.Time
 
 	// Endpoints contains the list of endpoint IDs the connection is permitted
 	// to register. If empty then all endpoints are allowed.
+	//
+	// Each entry may be a pattern containing '*' wildcards, which match any
+	// sequence of characters, such as 'team-a-*'.
 	Endpoints []string
+
+	// Tenant is the tenant namespace the token belongs to, or empty if the
+	// token isn't scoped to a tenant.
+	//
+	// Endpoints registered and accessed with a tenant token are scoped to
+	// the tenant, so tenants can't access each others endpoints even when
+	// using the same endpoint ID.
+	Tenant string
 }
 
 // EndpointPermitted returns whether the given endpoint ID is permitted for
@@ -27,14 +44,70 @@ func (t *EndpointToken) EndpointPermitted(endpointID string) bool {
 		// If 'Endpoints' is empty then all endpoints are allowed.
 		return true
 	}
-	for _, id := range t.Endpoints {
-		if endpointID == id {
+	for _, pattern := range t.Endpoints {
+		if MatchEndpoint(pattern, endpointID) {
 			return true
 		}
 	}
 	return false
 }
 
+// ScopeEndpoint returns the endpoint ID scoped to the given tenant, such as
+// 'team-a/my-endpoint'. If the tenant or endpoint ID is empty, returns the
+// endpoint ID unchanged.
+func ScopeEndpoint(tenant string, endpointID string) string {
+	if tenant == "" || endpointID == "" {
+		return endpointID
+	}
+	return tenant + "/" + endpointID
+}
+
+// SplitEndpoint splits a scoped endpoint ID into the tenant and endpoint ID.
+// If the endpoint ID isn't scoped to a tenant, the tenant is empty.
+func SplitEndpoint(scopedID string) (string, string) {
+	tenant, endpointID, ok := strings.Cut(scopedID, "/")
+	if !ok {
+		return "", scopedID
+	}
+	return tenant, endpointID
+}
+
+// ValidTenant returns whether the tenant name is valid. Tenants must not
+// contain '/', which separates the tenant from the endpoint ID.
+func ValidTenant(tenant string) bool {
+	return !strings.Contains(tenant, "/")
+}
+
+// MatchEndpoint returns whether the endpoint ID matches the given pattern,
+// where '*' matches any sequence of characters (including an empty
+// sequence).
+func MatchEndpoint(pattern string, endpointID string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		// No wildcards.
+		return pattern == endpointID
+	}
+
+	// The first part must be a prefix and the last part must be a suffix.
+	prefix, suffix := parts[0], parts[len(parts)-1]
+	if len(endpointID) < len(prefix)+len(suffix) ||
+		!strings.HasPrefix(endpointID, prefix) ||
+		!strings.HasSuffix(endpointID, suffix) {
+		return false
+	}
+	endpointID = endpointID[len(prefix) : len(endpointID)-len(suffix)]
+
+	// Each middle part must appear in order.
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(endpointID, part)
+		if i < 0 {
+			return false
+		}
+		endpointID = endpointID[i+len(part):]
+	}
+	return true
+}
+
 type Verifier interface {
 	VerifyEndpointToken(token string) (EndpointToken, error)
 }
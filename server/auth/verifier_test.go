@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointToken_EndpointPermitted(t *testing.T) {
+	tests := []struct {
+		endpoints  []string
+		endpointID string
+		permitted  bool
+	}{
+		{nil, "my-endpoint", true},
+		{[]string{"my-endpoint"}, "my-endpoint", true},
+		{[]string{"my-endpoint"}, "my-endpoint-2", false},
+		{[]string{"foo", "my-endpoint"}, "my-endpoint", true},
+
+		{[]string{"team-a-*"}, "team-a-foo", true},
+		{[]string{"team-a-*"}, "team-a-", true},
+		{[]string{"team-a-*"}, "team-b-foo", false},
+		{[]string{"team-a-*"}, "team-a", false},
+		{[]string{"*-prod"}, "api-prod", true},
+		{[]string{"*-prod"}, "api-staging", false},
+		{[]string{"team-*-prod"}, "team-a-prod", true},
+		{[]string{"team-*-prod"}, "team-prod", false},
+		{[]string{"a*b*c"}, "axxbyyc", true},
+		{[]string{"a*b*c"}, "axxcyyb", false},
+		{[]string{"*"}, "anything", true},
+		{[]string{"team-b-*", "team-a-*"}, "team-a-foo", true},
+	}
+	for _, tt := range tests {
+		token := EndpointToken{
+			Endpoints: tt.endpoints,
+		}
+		assert.Equal(
+			t,
+			tt.permitted,
+			token.EndpointPermitted(tt.endpointID),
+			"endpoints=%v, endpoint-id=%s", tt.endpoints, tt.endpointID,
+		)
+	}
+}
+
+func TestScopeEndpoint(t *testing.T) {
+	assert.Equal(t, "team-a/my-endpoint", ScopeEndpoint("team-a", "my-endpoint"))
+	assert.Equal(t, "my-endpoint", ScopeEndpoint("", "my-endpoint"))
+	assert.Equal(t, "", ScopeEndpoint("team-a", ""))
+
+	tenant, endpointID := SplitEndpoint("team-a/my-endpoint")
+	assert.Equal(t, "team-a", tenant)
+	assert.Equal(t, "my-endpoint", endpointID)
+
+	tenant, endpointID = SplitEndpoint("my-endpoint")
+	assert.Equal(t, "", tenant)
+	assert.Equal(t, "my-endpoint", endpointID)
+}
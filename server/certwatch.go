@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"go.uber.org/zap"
+)
+
+// certWatcher polls the cert and key files of a listener, and reloads the
+// listener certificate when either file changes.
+//
+// Files are polled rather than using filesystem notifications, which
+// supports files that are replaced using symlinks, such as Kubernetes
+// secrets.
+type certWatcher struct {
+	name     string
+	certFile string
+	keyFile  string
+	cert     *certificate
+	interval time.Duration
+
+	// certStat and keyStat are the files state when last checked.
+	certStat fileStat
+	keyStat  fileStat
+
+	server *Server
+
+	logger log.Logger
+}
+
+func newCertWatcher(c reloadCert, server *Server, logger log.Logger) *certWatcher {
+	return &certWatcher{
+		name:     c.name,
+		certFile: c.conf.Cert,
+		keyFile:  c.conf.Key,
+		cert:     c.cert,
+		interval: c.conf.WatchInterval,
+		certStat: statFile(c.conf.Cert),
+		keyStat:  statFile(c.conf.Key),
+		server:   server,
+		logger:   logger,
+	}
+}
+
+// Run polls the files until the context is cancelled.
+func (w *certWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *certWatcher) check() {
+	certStat := statFile(w.certFile)
+	keyStat := statFile(w.keyFile)
+	if certStat.Equal(w.certStat) && keyStat.Equal(w.keyStat) {
+		return
+	}
+	// Update the files state even if loading fails, so a failure is only
+	// logged once. If the cert and key files are updated separately, loading
+	// is retried once the second file is updated.
+	w.certStat = certStat
+	w.keyStat = keyStat
+
+	cert, err := tls.LoadX509KeyPair(w.certFile, w.keyFile)
+	if err != nil {
+		w.logger.Warn(
+			"failed to reload tls certificate",
+			zap.String("listener", w.name),
+			zap.Error(err),
+		)
+		return
+	}
+
+	// Avoid reloading concurrently with a configuration reload.
+	w.server.reloadMu.Lock()
+	w.cert.Store(&cert)
+	w.server.reloadMu.Unlock()
+
+	w.logger.Info(
+		"reloaded tls certificate",
+		zap.String("listener", w.name),
+	)
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func (s fileStat) Equal(o fileStat) bool {
+	return s.modTime.Equal(o.modTime) && s.size == o.size
+}
+
+// statFile returns the file state, or a zero state if the file doesn't exist.
+func statFile(path string) fileStat {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStat{}
+	}
+	return fileStat{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+}
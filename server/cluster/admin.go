@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/andydunstall/piko/server/status"
+	"github.com/gin-gonic/gin"
+)
+
+// NetmapNode describes a node in the cluster netmap.
+//
+// Unlike Node, the netmap format is intended for external tools, so fields
+// are only ever added and never renamed or removed.
+type NetmapNode struct {
+	ID string `json:"id"`
+
+	Status NodeStatus `json:"status"`
+
+	// Role is the role of the node in the cluster, or empty for full nodes.
+	Role NodeRole `json:"role,omitempty"`
+
+	Version string `json:"version,omitempty"`
+
+	Commit string `json:"commit,omitempty"`
+
+	BuildDate string `json:"build_date,omitempty"`
+
+	GoVersion string `json:"go_version,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	ProxyAddr string `json:"proxy_addr"`
+
+	AdminAddr string `json:"admin_addr"`
+
+	RPCAddr string `json:"rpc_addr,omitempty"`
+
+	Draining bool `json:"draining"`
+
+	// Local indicates whether the node is the node serving the request.
+	Local bool `json:"local"`
+
+	// Endpoints maps the endpoint ID to the number of upstreams connected to
+	// the node for that endpoint.
+	Endpoints map[string]int `json:"endpoints"`
+
+	// EndpointCount is the number of endpoints with upstreams connected to
+	// the node.
+	EndpointCount int `json:"endpoint_count"`
+
+	// UpstreamCount is the number of upstreams connected to the node.
+	UpstreamCount int `json:"upstream_count"`
+}
+
+// Netmap contains the known state of each node in the cluster.
+type Netmap struct {
+	LocalID string `json:"local_id"`
+
+	// Nodes contains the known nodes, sorted by ID.
+	Nodes []NetmapNode `json:"nodes"`
+}
+
+// AdminHandler registers the admin routes to inspect the cluster in a
+// machine-readable format.
+type AdminHandler struct {
+	state *State
+}
+
+func NewAdminHandler(state *State) *AdminHandler {
+	return &AdminHandler{
+		state: state,
+	}
+}
+
+func (h *AdminHandler) Register(group *gin.RouterGroup) {
+	group.GET("/nodes", h.listNodesRoute)
+}
+
+// listNodesRoute returns the cluster netmap.
+func (h *AdminHandler) listNodesRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, h.netmap())
+}
+
+func (h *AdminHandler) netmap() Netmap {
+	localID := h.state.LocalID()
+
+	nodes := make([]NetmapNode, 0)
+	for _, node := range h.state.Nodes() {
+		endpoints := node.Endpoints
+		if endpoints == nil {
+			endpoints = make(map[string]int)
+		}
+		upstreams := 0
+		for _, listeners := range endpoints {
+			upstreams += listeners
+		}
+
+		nodes = append(nodes, NetmapNode{
+			ID:            node.ID,
+			Status:        node.Status,
+			Role:          node.Role,
+			Version:       node.Version,
+			Commit:        node.Commit,
+			BuildDate:     node.BuildDate,
+			GoVersion:     node.GoVersion,
+			Labels:        node.Labels,
+			ProxyAddr:     node.ProxyAddr,
+			AdminAddr:     node.AdminAddr,
+			RPCAddr:       node.RPCAddr,
+			Draining:      node.Draining,
+			Local:         node.ID == localID,
+			Endpoints:     endpoints,
+			EndpointCount: len(endpoints),
+			UpstreamCount: upstreams,
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	return Netmap{
+		LocalID: localID,
+		Nodes:   nodes,
+	}
+}
+
+var _ status.Handler = &AdminHandler{}
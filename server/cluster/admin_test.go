@@ -0,0 +1,67 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandler_Nodes(t *testing.T) {
+	s := NewState(&Node{
+		ID:        "local",
+		Status:    NodeStatusActive,
+		Labels:    map[string]string{"region": "eu-west-1"},
+		ProxyAddr: "10.26.104.56:8000",
+		AdminAddr: "10.26.104.56:8002",
+	}, log.NewNopLogger())
+	s.AddLocalEndpoint("endpoint-1")
+	s.AddNode(&Node{
+		ID:        "remote",
+		Status:    NodeStatusUnreachable,
+		ProxyAddr: "10.26.104.57:8000",
+		AdminAddr: "10.26.104.57:8002",
+	})
+	s.UpdateRemoteEndpoint("remote", "endpoint-1", 2)
+	s.UpdateRemoteEndpoint("remote", "endpoint-2", 1)
+
+	router := gin.New()
+	NewAdminHandler(s).Register(router.Group("/cluster"))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cluster/nodes", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var netmap Netmap
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&netmap))
+	assert.Equal(t, Netmap{
+		LocalID: "local",
+		Nodes: []NetmapNode{
+			{
+				ID:            "local",
+				Status:        NodeStatusActive,
+				Labels:        map[string]string{"region": "eu-west-1"},
+				ProxyAddr:     "10.26.104.56:8000",
+				AdminAddr:     "10.26.104.56:8002",
+				Local:         true,
+				Endpoints:     map[string]int{"endpoint-1": 1},
+				EndpointCount: 1,
+				UpstreamCount: 1,
+			},
+			{
+				ID:            "remote",
+				Status:        NodeStatusUnreachable,
+				ProxyAddr:     "10.26.104.57:8000",
+				AdminAddr:     "10.26.104.57:8002",
+				Endpoints:     map[string]int{"endpoint-1": 2, "endpoint-2": 1},
+				EndpointCount: 2,
+				UpstreamCount: 3,
+			},
+		},
+	}, netmap)
+}
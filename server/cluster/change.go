@@ -0,0 +1,46 @@
+package cluster
+
+// ChangeType is the type of a change to the cluster state.
+type ChangeType string
+
+const (
+	// ChangeTypeNodeAdded means a remote node was added to the cluster.
+	ChangeTypeNodeAdded ChangeType = "node_added"
+	// ChangeTypeNodeRemoved means a remote node was removed from the
+	// cluster.
+	ChangeTypeNodeRemoved ChangeType = "node_removed"
+	// ChangeTypeNodeStatus means the status of a remote node changed.
+	ChangeTypeNodeStatus ChangeType = "node_status"
+	// ChangeTypeNodeDraining means whether a node is draining changed.
+	ChangeTypeNodeDraining ChangeType = "node_draining"
+	// ChangeTypeEndpointUpdated means the number of listeners for an
+	// endpoint on a node changed.
+	ChangeTypeEndpointUpdated ChangeType = "endpoint_updated"
+	// ChangeTypeEndpointRemoved means an endpoint is no longer active on a
+	// node.
+	ChangeTypeEndpointRemoved ChangeType = "endpoint_removed"
+)
+
+// Change describes a change to the cluster state.
+type Change struct {
+	Type ChangeType `json:"type"`
+
+	// NodeID is the ID of the node that changed.
+	NodeID string `json:"node_id"`
+
+	// Status is the updated node status. Only set for 'node_added' and
+	// 'node_status'.
+	Status NodeStatus `json:"status,omitempty"`
+
+	// Draining is whether the node is draining. Only set for
+	// 'node_draining'.
+	Draining bool `json:"draining,omitempty"`
+
+	// EndpointID is the ID of the endpoint that changed. Only set for
+	// 'endpoint_updated' and 'endpoint_removed'.
+	EndpointID string `json:"endpoint_id,omitempty"`
+
+	// Listeners is the updated number of listeners for the endpoint on the
+	// node. Only set for 'endpoint_updated'.
+	Listeners int `json:"listeners,omitempty"`
+}
@@ -6,6 +6,14 @@ type Metrics struct {
 	// Nodes contains the number of known nodes in the cluster, labelled by
 	// status.
 	Nodes *prometheus.GaugeVec
+
+	// ExpectedNodes contains the configured expected number of nodes in the
+	// cluster, or zero if not configured.
+	ExpectedNodes prometheus.Gauge
+
+	// Partitioned is 1 if the node can see fewer than a quorum of the
+	// expected number of nodes, otherwise 0.
+	Partitioned prometheus.Gauge
 }
 
 func NewMetrics() *Metrics {
@@ -19,11 +27,29 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"status"},
 		),
+		ExpectedNodes: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "piko",
+				Subsystem: "cluster",
+				Name:      "expected_nodes",
+				Help:      "Expected number of nodes in the cluster",
+			},
+		),
+		Partitioned: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "piko",
+				Subsystem: "cluster",
+				Name:      "partitioned",
+				Help:      "Whether the node can see fewer than a quorum of the expected nodes",
+			},
+		),
 	}
 }
 
 func (m *Metrics) Register(registry *prometheus.Registry) {
 	registry.MustRegister(
 		m.Nodes,
+		m.ExpectedNodes,
+		m.Partitioned,
 	)
 }
@@ -2,7 +2,14 @@ package cluster
 
 import (
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andydunstall/piko/pkg/encryption"
 )
 
 var (
@@ -21,6 +28,18 @@ const (
 	NodeStatusLeft NodeStatus = "left"
 )
 
+// NodeRole contains the role of a node in the cluster.
+type NodeRole string
+
+const (
+	// NodeRoleFull means the node accepts both downstream proxy traffic
+	// and upstream connections.
+	NodeRoleFull NodeRole = "full"
+	// NodeRoleProxy means the node accepts downstream proxy traffic and
+	// forwards it to other nodes, but never accepts upstream connections.
+	NodeRoleProxy NodeRole = "proxy"
+)
+
 // Node represents the known state about a node in the cluster.
 //
 // Note to ensure updates are propagated, never update a node directly, only
@@ -34,6 +53,39 @@ type Node struct {
 	// Status contains the known status of the node.
 	Status NodeStatus `json:"status"`
 
+	// Role is the role of the node in the cluster. If empty the node is
+	// considered a full node.
+	//
+	// The role is immutable.
+	Role NodeRole `json:"role,omitempty"`
+
+	// Version is the Piko version the node is running.
+	//
+	// The version is immutable.
+	Version string `json:"version,omitempty"`
+
+	// Commit is the git commit of the Piko binary the node is running.
+	//
+	// The commit is immutable.
+	Commit string `json:"commit,omitempty"`
+
+	// BuildDate is the date the Piko binary the node is running was built.
+	//
+	// The build date is immutable.
+	BuildDate string `json:"build_date,omitempty"`
+
+	// GoVersion is the Go version the Piko binary the node is running was
+	// built with.
+	//
+	// The Go version is immutable.
+	GoVersion string `json:"go_version,omitempty"`
+
+	// Labels contains custom metadata about the node, such as the region
+	// the node is running in.
+	//
+	// The labels are immutable.
+	Labels map[string]string `json:"labels,omitempty"`
+
 	// ProxyAddr is the advertised proxy address.
 	//
 	// The address is immutable.
@@ -44,6 +96,16 @@ type Node struct {
 	// The address is immutable.
 	AdminAddr string `json:"admin_addr"`
 
+	// RPCAddr is the advertised RPC address used to forward requests between
+	// nodes. If empty the node doesn't accept RPC requests.
+	//
+	// The address is immutable.
+	RPCAddr string `json:"rpc_addr,omitempty"`
+
+	// Draining indicates the node is draining ahead of shutting down, so
+	// other nodes must not forward new requests to the node.
+	Draining bool `json:"draining"`
+
 	// Endpoints contains the known active endpoints on the node (endpoints
 	// with at least one upstream listener).
 	//
@@ -63,12 +125,25 @@ func (n *Node) Copy() *Node {
 	return &Node{
 		ID:        n.ID,
 		Status:    n.Status,
+		Role:      n.Role,
+		Version:   n.Version,
+		Commit:    n.Commit,
+		BuildDate: n.BuildDate,
+		GoVersion: n.GoVersion,
+		Labels:    copyLabels(n.Labels),
 		ProxyAddr: n.ProxyAddr,
 		AdminAddr: n.AdminAddr,
+		RPCAddr:   n.RPCAddr,
+		Draining:  n.Draining,
 		Endpoints: endpoints,
 	}
 }
 
+// AcceptsUpstreams returns whether the node accepts upstream connections.
+func (n *Node) AcceptsUpstreams() bool {
+	return n.Role != NodeRoleProxy
+}
+
 func (n *Node) Metadata() *NodeMetadata {
 	upstreams := 0
 	for _, endpointUpstreams := range n.Endpoints {
@@ -77,8 +152,16 @@ func (n *Node) Metadata() *NodeMetadata {
 	return &NodeMetadata{
 		ID:        n.ID,
 		Status:    n.Status,
+		Role:      n.Role,
+		Version:   n.Version,
+		Commit:    n.Commit,
+		BuildDate: n.BuildDate,
+		GoVersion: n.GoVersion,
+		Labels:    copyLabels(n.Labels),
 		ProxyAddr: n.ProxyAddr,
 		AdminAddr: n.AdminAddr,
+		RPCAddr:   n.RPCAddr,
+		Draining:  n.Draining,
 		Endpoints: len(n.Endpoints),
 		Upstreams: upstreams,
 	}
@@ -86,15 +169,45 @@ func (n *Node) Metadata() *NodeMetadata {
 
 // NodeMetadata contains metadata fields from Node.
 type NodeMetadata struct {
-	ID        string     `json:"id"`
-	Status    NodeStatus `json:"status"`
-	ProxyAddr string     `json:"proxy_addr"`
-	AdminAddr string     `json:"admin_addr"`
-	Endpoints int        `json:"endpoints"`
+	ID        string            `json:"id"`
+	Status    NodeStatus        `json:"status"`
+	Role      NodeRole          `json:"role,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Commit    string            `json:"commit,omitempty"`
+	BuildDate string            `json:"build_date,omitempty"`
+	GoVersion string            `json:"go_version,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	ProxyAddr string            `json:"proxy_addr"`
+	AdminAddr string            `json:"admin_addr"`
+	RPCAddr   string            `json:"rpc_addr,omitempty"`
+	Draining  bool              `json:"draining"`
+	Endpoints int               `json:"endpoints"`
 	// Upstreams is the number of upstreams connected to this node.
 	Upstreams int `json:"upstreams"`
 }
 
+// MatchesLabels returns whether the node has the same value as the given
+// labels for each of the given keys.
+func (n *Node) MatchesLabels(labels map[string]string, keys []string) bool {
+	for _, key := range keys {
+		if n.Labels[key] != labels[key] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
+
 func GenerateNodeID() string {
 	b := make([]byte, 7)
 	for i := range b {
@@ -108,3 +221,64 @@ func GenerateNodeID() string {
 	}
 	return string(b)
 }
+
+// LoadOrGenerateNodeID loads the node ID from the file at the given path. If
+// the file doesn't exist, a new node ID is generated with the given prefix
+// and written to the file, so the node reuses the same ID when it restarts.
+//
+// If key is set, the file is encrypted with the key. An existing plaintext
+// file is encrypted when loaded.
+func LoadOrGenerateNodeID(path string, prefix string, key []byte) (string, error) {
+	b, err := os.ReadFile(path)
+	if err == nil {
+		nodeID, err := decodeNodeID(b, key)
+		if err != nil {
+			return "", fmt.Errorf("read node id: %w", err)
+		}
+		if nodeID != "" {
+			if key != nil && !encryption.IsEncrypted(b) {
+				if err := writeNodeID(path, nodeID, key); err != nil {
+					return "", fmt.Errorf("write node id: %w", err)
+				}
+			}
+			return nodeID, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("read node id: %w", err)
+	}
+
+	nodeID := prefix + GenerateNodeID()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("write node id: %w", err)
+	}
+	if err := writeNodeID(path, nodeID, key); err != nil {
+		return "", fmt.Errorf("write node id: %w", err)
+	}
+	return nodeID, nil
+}
+
+func decodeNodeID(b []byte, key []byte) (string, error) {
+	if encryption.IsEncrypted(b) {
+		if key == nil {
+			return "", fmt.Errorf("file is encrypted but no key configured")
+		}
+		plaintext, err := encryption.Decrypt(key, b)
+		if err != nil {
+			return "", err
+		}
+		b = plaintext
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func writeNodeID(path string, nodeID string, key []byte) error {
+	b := []byte(nodeID)
+	if key != nil {
+		encrypted, err := encryption.Encrypt(key, b)
+		if err != nil {
+			return err
+		}
+		b = encrypted
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
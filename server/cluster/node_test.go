@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andydunstall/piko/pkg/encryption"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOrGenerateNodeID(t *testing.T) {
+	t.Run("generate", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data", "node-id")
+
+		nodeID, err := LoadOrGenerateNodeID(path, "my-prefix-", nil)
+		require.NoError(t, err)
+		assert.Contains(t, nodeID, "my-prefix-")
+
+		// Loading again should return the persisted ID.
+		loadedID, err := LoadOrGenerateNodeID(path, "my-prefix-", nil)
+		require.NoError(t, err)
+		assert.Equal(t, nodeID, loadedID)
+	})
+
+	t.Run("load", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "node-id")
+		require.NoError(t, os.WriteFile(path, []byte("my-node\n"), 0o644))
+
+		nodeID, err := LoadOrGenerateNodeID(path, "", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "my-node", nodeID)
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "node-id")
+		key := make([]byte, encryption.KeySize)
+
+		nodeID, err := LoadOrGenerateNodeID(path, "my-prefix-", key)
+		require.NoError(t, err)
+
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.True(t, encryption.IsEncrypted(b))
+		assert.NotContains(t, string(b), nodeID)
+
+		loadedID, err := LoadOrGenerateNodeID(path, "my-prefix-", key)
+		require.NoError(t, err)
+		assert.Equal(t, nodeID, loadedID)
+
+		// Loading without the key should fail rather than generating a new
+		// ID.
+		_, err = LoadOrGenerateNodeID(path, "my-prefix-", nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("encrypt plaintext", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "node-id")
+		require.NoError(t, os.WriteFile(path, []byte("my-node\n"), 0o644))
+		key := make([]byte, encryption.KeySize)
+
+		nodeID, err := LoadOrGenerateNodeID(path, "", key)
+		require.NoError(t, err)
+		assert.Equal(t, "my-node", nodeID)
+
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.True(t, encryption.IsEncrypted(b))
+	})
+
+}
@@ -2,30 +2,63 @@ package cluster
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
 )
 
 // State represents the known state of the cluster as seen by the local
 // node.
 //
 // This state is eventually consistent.
+//
+// Readers, such as looking up an endpoint for each proxied request, read an
+// immutable snapshot of the state without locking. Writers update the state
+// with the mutex locked, then publish a new snapshot, copying only the nodes
+// that changed.
 type State struct {
 	localID string
 	nodes   map[string]*Node
 
 	localEndpointSubscribers  []func(endpointID string)
 	remoteEndpointSubscribers []func(nodeID string, endpointID string)
+	localDrainingSubscribers  []func(draining bool)
+	nodeAddedSubscribers      []func(nodeID string)
+	changeSubscribers         map[int]func(change Change)
+	nextChangeSubscriberID    int
+
+	// expectedSize is the expected number of nodes in the cluster, used to
+	// detect whether the node is partitioned from the rest of the cluster.
+	// If zero partition detection is disabled.
+	expectedSize int
+	partitioned  bool
+
+	// preferLabels contains the label keys to prefer nodes that have the
+	// same label values as the local node when looking up an endpoint.
+	preferLabels []string
 
 	// mu protects the above fields.
-	mu sync.RWMutex
+	mu sync.Mutex
+
+	// snapshot is the latest published snapshot of the state.
+	snapshot atomic.Pointer[snapshot]
 
 	metrics *Metrics
 
 	logger log.Logger
 }
 
+// snapshot is an immutable snapshot of the cluster state.
+//
+// The snapshot and its nodes must never be modified.
+type snapshot struct {
+	nodes        map[string]*Node
+	preferLabels []string
+	partitioned  bool
+}
+
 func NewState(
 	localNode *Node,
 	logger log.Logger,
@@ -36,22 +69,25 @@ func NewState(
 	nodes[localNode.ID] = localNode
 
 	s := &State{
-		localID: localNode.ID,
-		nodes:   nodes,
-		metrics: NewMetrics(),
-		logger:  logger.WithSubsystem("cluster"),
+		localID:           localNode.ID,
+		nodes:             nodes,
+		changeSubscribers: make(map[int]func(change Change)),
+		metrics:           NewMetrics(),
+		logger:            logger.WithSubsystem("cluster"),
 	}
 	s.addMetricsNode(localNode.Status)
+	s.snapshot.Store(&snapshot{
+		nodes: map[string]*Node{
+			localNode.ID: localNode.Copy(),
+		},
+	})
 	return s
 }
 
 // Node returns the known state of the node with the given ID, or false if the
 // node is unknown.
 func (s *State) Node(id string) (*Node, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	node, ok := s.nodes[id]
+	node, ok := s.snapshot.Load().nodes[id]
 	if !ok {
 		return nil, false
 	}
@@ -66,10 +102,7 @@ func (s *State) LocalID() string {
 
 // LocalNode returns the state of the local node.
 func (s *State) LocalNode() *Node {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	node, ok := s.nodes[s.localID]
+	node, ok := s.snapshot.Load().nodes[s.localID]
 	if !ok {
 		panic("local node not in cluster")
 	}
@@ -78,11 +111,10 @@ func (s *State) LocalNode() *Node {
 
 // Nodes returns the state of the known nodes.
 func (s *State) Nodes() []*Node {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	snapshot := s.snapshot.Load()
 
-	nodes := make([]*Node, 0, len(s.nodes))
-	for _, node := range s.nodes {
+	nodes := make([]*Node, 0, len(snapshot.nodes))
+	for _, node := range snapshot.nodes {
 		nodes = append(nodes, node.Copy())
 	}
 	return nodes
@@ -90,11 +122,10 @@ func (s *State) Nodes() []*Node {
 
 // NodesMetadata returns the metadata of the known nodes.
 func (s *State) NodesMetadata() []*NodeMetadata {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	snapshot := s.snapshot.Load()
 
-	nodes := make([]*NodeMetadata, 0, len(s.nodes))
-	for _, node := range s.nodes {
+	nodes := make([]*NodeMetadata, 0, len(snapshot.nodes))
+	for _, node := range snapshot.nodes {
 		nodes = append(nodes, node.Metadata())
 	}
 	return nodes
@@ -102,11 +133,19 @@ func (s *State) NodesMetadata() []*NodeMetadata {
 
 // LookupEndpoint looks up a node that the endpoint with the given ID is active
 // on.
+//
+// If preferred labels are configured, nodes with the same values for those
+// labels as the local node are preferred.
+//
+// Since this is called for each proxied request, the returned node is shared
+// with the state snapshot rather than copied, so must not be modified.
 func (s *State) LookupEndpoint(endpointID string) (*Node, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	snapshot := s.snapshot.Load()
 
-	for _, node := range s.nodes {
+	localNode := snapshot.nodes[s.localID]
+
+	var fallback *Node
+	for _, node := range snapshot.nodes {
 		if node.ID == s.localID {
 			// Ignore ourselves.
 			continue
@@ -115,14 +154,59 @@ func (s *State) LookupEndpoint(endpointID string) (*Node, bool) {
 			// Ignore unreachable and left nodes.
 			continue
 		}
-		if listeners, ok := node.Endpoints[endpointID]; ok && listeners > 0 {
-			return node.Copy(), true
+		if node.Draining {
+			// Ignore draining nodes to avoid forwarding new requests to a
+			// node thats about to shut down.
+			continue
+		}
+		if listeners, ok := node.Endpoints[endpointID]; !ok || listeners == 0 {
+			continue
+		}
+		if node.MatchesLabels(localNode.Labels, snapshot.preferLabels) {
+			return node, true
+		}
+		if fallback == nil {
+			fallback = node
 		}
 	}
 
+	if fallback != nil {
+		return fallback, true
+	}
 	return nil, false
 }
 
+// Endpoints returns the active endpoints in the cluster, mapping each
+// endpoint ID to the total number of listeners for that endpoint across all
+// active nodes.
+//
+// Like LookupEndpoint, unreachable, left and draining nodes are ignored.
+func (s *State) Endpoints() map[string]int {
+	endpoints := make(map[string]int)
+	for _, node := range s.snapshot.Load().nodes {
+		if node.Status != NodeStatusActive || node.Draining {
+			continue
+		}
+		for endpointID, listeners := range node.Endpoints {
+			if listeners > 0 {
+				endpoints[endpointID] += listeners
+			}
+		}
+	}
+	return endpoints
+}
+
+// SetPreferLabels sets the label keys used to prefer nodes when looking up an
+// endpoint. Nodes with the same values for those labels as the local node are
+// preferred.
+func (s *State) SetPreferLabels(keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.preferLabels = keys
+	s.publishLocked()
+}
+
 // AddLocalEndpoint adds the active endpoint to the local node state.
 func (s *State) AddLocalEndpoint(endpointID string) {
 	s.mu.Lock()
@@ -137,15 +221,24 @@ func (s *State) AddLocalEndpoint(endpointID string) {
 	}
 
 	node.Endpoints[endpointID] = node.Endpoints[endpointID] + 1
+	listeners := node.Endpoints[endpointID]
+	s.publishLocked(s.localID)
 
 	subscribers := make([]func(endpointID string), 0, len(s.localEndpointSubscribers))
 	subscribers = append(subscribers, s.localEndpointSubscribers...)
+	changeSubscribers := s.changeSubscribersLocked()
 
 	s.mu.Unlock()
 
 	for _, f := range subscribers {
 		f(endpointID)
 	}
+	notifyChange(changeSubscribers, Change{
+		Type:       ChangeTypeEndpointUpdated,
+		NodeID:     s.localID,
+		EndpointID: endpointID,
+		Listeners:  listeners,
+	})
 }
 
 // RemoveLocalEndpoint removes the active endpoint from the local node state.
@@ -168,35 +261,88 @@ func (s *State) RemoveLocalEndpoint(endpointID string) {
 		return
 	}
 
+	change := Change{
+		Type:       ChangeTypeEndpointRemoved,
+		NodeID:     s.localID,
+		EndpointID: endpointID,
+	}
 	if listeners > 1 {
 		node.Endpoints[endpointID] = listeners - 1
+		change.Type = ChangeTypeEndpointUpdated
+		change.Listeners = listeners - 1
 	} else {
 		delete(node.Endpoints, endpointID)
 	}
+	s.publishLocked(s.localID)
 
 	subscribers := make([]func(endpointID string), 0, len(s.localEndpointSubscribers))
 	subscribers = append(subscribers, s.localEndpointSubscribers...)
+	changeSubscribers := s.changeSubscribersLocked()
 
 	s.mu.Unlock()
 
 	for _, f := range subscribers {
 		f(endpointID)
 	}
+	notifyChange(changeSubscribers, change)
 }
 
 func (s *State) LocalEndpointListeners(endpointID string) int {
+	node, ok := s.snapshot.Load().nodes[s.localID]
+	if !ok {
+		panic("local node not in cluster")
+	}
+	return node.Endpoints[endpointID]
+}
+
+// SetLocalDraining sets whether the local node is draining.
+func (s *State) SetLocalDraining(draining bool) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
 	node, ok := s.nodes[s.localID]
 	if !ok {
 		panic("local node not in cluster")
 	}
 
-	if node.Endpoints == nil {
-		return 0
+	if node.Draining == draining {
+		s.mu.Unlock()
+		return
 	}
-	return node.Endpoints[endpointID]
+	node.Draining = draining
+	s.publishLocked(s.localID)
+
+	subscribers := make([]func(draining bool), 0, len(s.localDrainingSubscribers))
+	subscribers = append(subscribers, s.localDrainingSubscribers...)
+	changeSubscribers := s.changeSubscribersLocked()
+
+	s.mu.Unlock()
+
+	for _, f := range subscribers {
+		f(draining)
+	}
+	notifyChange(changeSubscribers, Change{
+		Type:     ChangeTypeNodeDraining,
+		NodeID:   s.localID,
+		Draining: draining,
+	})
+}
+
+// LocalDraining returns whether the local node is draining.
+func (s *State) LocalDraining() bool {
+	node, ok := s.snapshot.Load().nodes[s.localID]
+	if !ok {
+		panic("local node not in cluster")
+	}
+	return node.Draining
+}
+
+// OnLocalDrainingUpdate subscribes to changes to whether the local node is
+// draining.
+func (s *State) OnLocalDrainingUpdate(f func(draining bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.localDrainingSubscribers = append(s.localDrainingSubscribers, f)
 }
 
 // OnLocalEndpointUpdate subscribes to changes to the local nodes active
@@ -218,13 +364,42 @@ func (s *State) OnRemoteEndpointUpdate(f func(nodeID string, endpointID string))
 	s.remoteEndpointSubscribers = append(s.remoteEndpointSubscribers, f)
 }
 
+// OnNodeAdded subscribes to remote nodes being added to the cluster.
+func (s *State) OnNodeAdded(f func(nodeID string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nodeAddedSubscribers = append(s.nodeAddedSubscribers, f)
+}
+
+// Subscribe subscribes to all changes to the cluster state. Returns a
+// function to unsubscribe.
+//
+// The callback is called without the cluster mutex locked, though must not
+// block.
+func (s *State) Subscribe(f func(change Change)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextChangeSubscriberID
+	s.nextChangeSubscriberID++
+	s.changeSubscribers[id] = f
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.changeSubscribers, id)
+	}
+}
+
 // AddNode adds the given node to the cluster.
 func (s *State) AddNode(node *Node) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
 	if node.ID == s.localID {
 		s.logger.Warn("add node: cannot add local node")
+		s.mu.Unlock()
 		return
 	}
 
@@ -236,26 +411,55 @@ func (s *State) AddNode(node *Node) {
 
 	s.nodes[node.ID] = node
 	s.addMetricsNode(node.Status)
+	s.updatePartitionedLocked()
+	s.publishLocked(node.ID)
+
+	subscribers := make([]func(nodeID string), 0, len(s.nodeAddedSubscribers))
+	subscribers = append(subscribers, s.nodeAddedSubscribers...)
+	changeSubscribers := s.changeSubscribersLocked()
+
+	s.mu.Unlock()
+
+	for _, f := range subscribers {
+		f(node.ID)
+	}
+	notifyChange(changeSubscribers, Change{
+		Type:   ChangeTypeNodeAdded,
+		NodeID: node.ID,
+		Status: node.Status,
+	})
 }
 
 // RemoveNode removes the node with the given ID from the cluster.
 func (s *State) RemoveNode(id string) bool {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
 	if id == s.localID {
 		s.logger.Warn("remove node: cannot remove local node")
+		s.mu.Unlock()
 		return false
 	}
 
 	node, ok := s.nodes[id]
 	if !ok {
 		s.logger.Warn("remove node: node not in cluster")
+		s.mu.Unlock()
 		return false
 	}
 
 	delete(s.nodes, id)
 	s.removeMetricsNode(node.Status)
+	s.updatePartitionedLocked()
+	s.publishLocked(id)
+
+	changeSubscribers := s.changeSubscribersLocked()
+
+	s.mu.Unlock()
+
+	notifyChange(changeSubscribers, Change{
+		Type:   ChangeTypeNodeRemoved,
+		NodeID: id,
+	})
 
 	return true
 }
@@ -263,22 +467,75 @@ func (s *State) RemoveNode(id string) bool {
 // UpdateRemoteStatus sets the status of the remote node with the given ID.
 func (s *State) UpdateRemoteStatus(id string, status NodeStatus) bool {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
 	if id == s.localID {
 		s.logger.Warn("update remote status: cannot update local node")
+		s.mu.Unlock()
 		return false
 	}
 
 	n, ok := s.nodes[id]
 	if !ok {
 		s.logger.Warn("update remote status: node not in cluster")
+		s.mu.Unlock()
 		return false
 	}
 
 	oldStatus := n.Status
 	n.Status = status
 	s.updateMetricsNode(oldStatus, status)
+	s.updatePartitionedLocked()
+	s.publishLocked(id)
+
+	changeSubscribers := s.changeSubscribersLocked()
+
+	s.mu.Unlock()
+
+	if oldStatus != status {
+		notifyChange(changeSubscribers, Change{
+			Type:   ChangeTypeNodeStatus,
+			NodeID: id,
+			Status: status,
+		})
+	}
+
+	return true
+}
+
+// UpdateRemoteDraining sets whether the remote node with the given ID is
+// draining.
+func (s *State) UpdateRemoteDraining(id string, draining bool) bool {
+	s.mu.Lock()
+
+	if id == s.localID {
+		s.logger.Warn("update remote draining: cannot update local node")
+		s.mu.Unlock()
+		return false
+	}
+
+	n, ok := s.nodes[id]
+	if !ok {
+		s.logger.Warn("update remote draining: node not in cluster")
+		s.mu.Unlock()
+		return false
+	}
+
+	changed := n.Draining != draining
+	n.Draining = draining
+	s.publishLocked(id)
+
+	changeSubscribers := s.changeSubscribersLocked()
+
+	s.mu.Unlock()
+
+	if changed {
+		notifyChange(changeSubscribers, Change{
+			Type:     ChangeTypeNodeDraining,
+			NodeID:   id,
+			Draining: draining,
+		})
+	}
+
 	return true
 }
 
@@ -298,12 +555,19 @@ func (s *State) UpdateRemoteEndpoint(
 
 	subscribers := make([]func(nodeID string, endpointID string), 0, len(s.remoteEndpointSubscribers))
 	subscribers = append(subscribers, s.remoteEndpointSubscribers...)
+	changeSubscribers := s.changeSubscribersLocked()
 
 	s.mu.Unlock()
 
 	for _, f := range subscribers {
 		f(id, endpointID)
 	}
+	notifyChange(changeSubscribers, Change{
+		Type:       ChangeTypeEndpointUpdated,
+		NodeID:     id,
+		EndpointID: endpointID,
+		Listeners:  listeners,
+	})
 
 	return true
 }
@@ -320,16 +584,44 @@ func (s *State) RemoveRemoteEndpoint(id string, endpointID string) bool {
 
 	subscribers := make([]func(nodeID string, endpointID string), 0, len(s.remoteEndpointSubscribers))
 	subscribers = append(subscribers, s.remoteEndpointSubscribers...)
+	changeSubscribers := s.changeSubscribersLocked()
 
 	s.mu.Unlock()
 
 	for _, f := range subscribers {
 		f(id, endpointID)
 	}
+	notifyChange(changeSubscribers, Change{
+		Type:       ChangeTypeEndpointRemoved,
+		NodeID:     id,
+		EndpointID: endpointID,
+	})
 
 	return true
 }
 
+// SetExpectedSize sets the expected number of nodes in the cluster. If the
+// local node can see fewer than a quorum of the expected nodes as active, it
+// considers itself partitioned from the cluster.
+//
+// A size of zero disables partition detection.
+func (s *State) SetExpectedSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expectedSize = size
+	s.metrics.ExpectedNodes.Set(float64(size))
+	s.updatePartitionedLocked()
+	s.publishLocked()
+}
+
+// Partitioned returns whether the local node can see fewer than a quorum of
+// the expected number of nodes in the cluster, meaning it may be partitioned
+// from the rest of the cluster.
+func (s *State) Partitioned() bool {
+	return s.snapshot.Load().partitioned
+}
+
 func (s *State) Metrics() *Metrics {
 	return s.metrics
 }
@@ -355,6 +647,7 @@ func (s *State) updateRemoteEndpointLocked(
 	}
 
 	n.Endpoints[endpointID] = listeners
+	s.publishLocked(id)
 
 	return true
 }
@@ -374,10 +667,90 @@ func (s *State) removeRemoteEndpointLocked(id string, endpointID string) bool {
 	if n.Endpoints != nil {
 		delete(n.Endpoints, endpointID)
 	}
+	s.publishLocked(id)
 
 	return true
 }
 
+// publishLocked publishes a new snapshot of the state. Only the nodes with
+// the given IDs are copied, since the remaining nodes are unchanged so can be
+// shared with the previous snapshot.
+func (s *State) publishLocked(ids ...string) {
+	prev := s.snapshot.Load()
+
+	nodes := make(map[string]*Node, len(s.nodes))
+	for id, node := range prev.nodes {
+		nodes[id] = node
+	}
+	for _, id := range ids {
+		if node, ok := s.nodes[id]; ok {
+			nodes[id] = node.Copy()
+		} else {
+			delete(nodes, id)
+		}
+	}
+
+	s.snapshot.Store(&snapshot{
+		nodes:        nodes,
+		preferLabels: s.preferLabels,
+		partitioned:  s.partitioned,
+	})
+}
+
+func (s *State) changeSubscribersLocked() []func(change Change) {
+	subscribers := make([]func(change Change), 0, len(s.changeSubscribers))
+	for _, f := range s.changeSubscribers {
+		subscribers = append(subscribers, f)
+	}
+	return subscribers
+}
+
+func notifyChange(subscribers []func(change Change), change Change) {
+	for _, f := range subscribers {
+		f(change)
+	}
+}
+
+func (s *State) updatePartitionedLocked() {
+	if s.expectedSize == 0 {
+		s.partitioned = false
+		s.metrics.Partitioned.Set(0)
+		return
+	}
+
+	active := 0
+	for _, node := range s.nodes {
+		if node.Status == NodeStatusActive {
+			active++
+		}
+	}
+	quorum := s.expectedSize/2 + 1
+	partitioned := active < quorum
+
+	if partitioned && !s.partitioned {
+		s.logger.Warn(
+			"node partitioned; active nodes below quorum",
+			zap.Int("active", active),
+			zap.Int("quorum", quorum),
+			zap.Int("expected-size", s.expectedSize),
+		)
+	} else if !partitioned && s.partitioned {
+		s.logger.Info(
+			"node no longer partitioned",
+			zap.Int("active", active),
+			zap.Int("quorum", quorum),
+			zap.Int("expected-size", s.expectedSize),
+		)
+	}
+
+	s.partitioned = partitioned
+	if partitioned {
+		s.metrics.Partitioned.Set(1)
+	} else {
+		s.metrics.Partitioned.Set(0)
+	}
+}
+
 func (s *State) updateMetricsNode(oldStatus NodeStatus, newStatus NodeStatus) {
 	s.removeMetricsNode(oldStatus)
 	s.addMetricsNode(newStatus)
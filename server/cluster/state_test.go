@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"sort"
+	"strconv"
 	"testing"
 
 	"github.com/andydunstall/piko/pkg/log"
@@ -69,6 +70,100 @@ func TestState_UpdateLocalEndpoint(t *testing.T) {
 	assert.Equal(t, 0, n.Endpoints["my-endpoint"])
 }
 
+func TestState_SetLocalDraining(t *testing.T) {
+	localNode := &Node{
+		ID:     "local",
+		Status: NodeStatusActive,
+	}
+	s := NewState(localNode.Copy(), log.NewNopLogger())
+
+	var notifyDraining []bool
+	s.OnLocalDrainingUpdate(func(draining bool) {
+		notifyDraining = append(notifyDraining, draining)
+	})
+
+	assert.False(t, s.LocalDraining())
+
+	s.SetLocalDraining(true)
+	assert.True(t, s.LocalDraining())
+	assert.True(t, s.LocalNode().Draining)
+
+	// Setting the same value again should not notify subscribers.
+	s.SetLocalDraining(true)
+	assert.Equal(t, []bool{true}, notifyDraining)
+}
+
+func TestState_Partitioned(t *testing.T) {
+	localNode := &Node{
+		ID:     "local",
+		Status: NodeStatusActive,
+	}
+	s := NewState(localNode.Copy(), log.NewNopLogger())
+
+	// Partition detection disabled.
+	assert.False(t, s.Partitioned())
+
+	// Only the local node is active so below quorum.
+	s.SetExpectedSize(3)
+	assert.True(t, s.Partitioned())
+
+	s.AddNode(&Node{
+		ID:     "remote-1",
+		Status: NodeStatusActive,
+	})
+	assert.False(t, s.Partitioned())
+
+	s.UpdateRemoteStatus("remote-1", NodeStatusUnreachable)
+	assert.True(t, s.Partitioned())
+
+	s.UpdateRemoteStatus("remote-1", NodeStatusActive)
+	assert.False(t, s.Partitioned())
+
+	s.RemoveNode("remote-1")
+	assert.True(t, s.Partitioned())
+}
+
+func TestState_Subscribe(t *testing.T) {
+	localNode := &Node{
+		ID:     "local",
+		Status: NodeStatusActive,
+	}
+	s := NewState(localNode.Copy(), log.NewNopLogger())
+
+	var changes []Change
+	unsubscribe := s.Subscribe(func(change Change) {
+		changes = append(changes, change)
+	})
+
+	s.AddNode(&Node{
+		ID:     "remote",
+		Status: NodeStatusActive,
+	})
+	s.UpdateRemoteEndpoint("remote", "my-endpoint", 2)
+	s.UpdateRemoteStatus("remote", NodeStatusUnreachable)
+	s.RemoveRemoteEndpoint("remote", "my-endpoint")
+	s.RemoveNode("remote")
+	s.AddLocalEndpoint("my-endpoint")
+	s.RemoveLocalEndpoint("my-endpoint")
+	s.SetLocalDraining(true)
+
+	assert.Equal(t, []Change{
+		{Type: ChangeTypeNodeAdded, NodeID: "remote", Status: NodeStatusActive},
+		{Type: ChangeTypeEndpointUpdated, NodeID: "remote", EndpointID: "my-endpoint", Listeners: 2},
+		{Type: ChangeTypeNodeStatus, NodeID: "remote", Status: NodeStatusUnreachable},
+		{Type: ChangeTypeEndpointRemoved, NodeID: "remote", EndpointID: "my-endpoint"},
+		{Type: ChangeTypeNodeRemoved, NodeID: "remote"},
+		{Type: ChangeTypeEndpointUpdated, NodeID: "local", EndpointID: "my-endpoint", Listeners: 1},
+		{Type: ChangeTypeEndpointRemoved, NodeID: "local", EndpointID: "my-endpoint"},
+		{Type: ChangeTypeNodeDraining, NodeID: "local", Draining: true},
+	}, changes)
+
+	unsubscribe()
+
+	s.SetLocalDraining(false)
+	assert.Len(t, changes, 8)
+}
+
 func TestState_AddNode(t *testing.T) {
 	t.Run("add node", func(t *testing.T) {
 		localNode := &Node{
@@ -310,6 +405,45 @@ func TestState_LookupEndpoint(t *testing.T) {
 		assert.Equal(t, newNode, node)
 	})
 
+	t.Run("prefer labels", func(t *testing.T) {
+		localNode := &Node{
+			ID:     "local",
+			Status: NodeStatusActive,
+			Labels: map[string]string{"region": "eu"},
+		}
+		s := NewState(localNode.Copy(), log.NewNopLogger())
+		s.SetPreferLabels([]string{"region"})
+
+		// Add multiple nodes in another region to make it unlikely the
+		// matching node is found first by chance.
+		for _, id := range []string{"us-1", "us-2", "us-3", "us-4"} {
+			s.AddNode(&Node{
+				ID:     id,
+				Status: NodeStatusActive,
+				Labels: map[string]string{"region": "us"},
+			})
+			assert.True(t, s.UpdateRemoteEndpoint(id, "my-endpoint-1", 1))
+		}
+		s.AddNode(&Node{
+			ID:     "eu-1",
+			Status: NodeStatusActive,
+			Labels: map[string]string{"region": "eu"},
+		})
+		assert.True(t, s.UpdateRemoteEndpoint("eu-1", "my-endpoint-1", 1))
+
+		for i := 0; i != 10; i++ {
+			node, ok := s.LookupEndpoint("my-endpoint-1")
+			assert.True(t, ok)
+			assert.Equal(t, "eu-1", node.ID)
+		}
+
+		// If no nodes match should fallback to any node.
+		s.RemoveNode("eu-1")
+		node, ok := s.LookupEndpoint("my-endpoint-1")
+		assert.True(t, ok)
+		assert.Equal(t, "us", node.Labels["region"])
+	})
+
 	t.Run("ignore unreachable", func(t *testing.T) {
 		localNode := &Node{
 			ID:     "local",
@@ -346,6 +480,25 @@ func TestState_LookupEndpoint(t *testing.T) {
 		assert.False(t, ok)
 	})
 
+	t.Run("ignore draining", func(t *testing.T) {
+		localNode := &Node{
+			ID:     "local",
+			Status: NodeStatusActive,
+		}
+		s := NewState(localNode.Copy(), log.NewNopLogger())
+
+		newNode := &Node{
+			ID:     "remote",
+			Status: NodeStatusActive,
+		}
+		s.AddNode(newNode)
+		assert.True(t, s.UpdateRemoteEndpoint("remote", "my-endpoint-1", 7))
+		assert.True(t, s.UpdateRemoteDraining("remote", true))
+
+		_, ok := s.LookupEndpoint("my-endpoint-1")
+		assert.False(t, ok)
+	})
+
 	t.Run("not found", func(t *testing.T) {
 		localNode := &Node{
 			ID:     "local",
@@ -363,4 +516,137 @@ func TestState_LookupEndpoint(t *testing.T) {
 		_, ok := s.LookupEndpoint("my-endpoint-2")
 		assert.False(t, ok)
 	})
+
+	// Tests updating the state doesn't modify a node that was already looked
+	// up.
+	t.Run("snapshot", func(t *testing.T) {
+		localNode := &Node{
+			ID:     "local",
+			Status: NodeStatusActive,
+		}
+		s := NewState(localNode.Copy(), log.NewNopLogger())
+
+		s.AddNode(&Node{
+			ID:     "remote",
+			Status: NodeStatusActive,
+		})
+		assert.True(t, s.UpdateRemoteEndpoint("remote", "my-endpoint-1", 7))
+
+		node, ok := s.LookupEndpoint("my-endpoint-1")
+		assert.True(t, ok)
+
+		assert.True(t, s.UpdateRemoteEndpoint("remote", "my-endpoint-1", 3))
+		assert.True(t, s.UpdateRemoteDraining("remote", true))
+
+		assert.Equal(t, 7, node.Endpoints["my-endpoint-1"])
+		assert.False(t, node.Draining)
+
+		_, ok = s.LookupEndpoint("my-endpoint-1")
+		assert.False(t, ok)
+	})
+}
+
+func TestState_Endpoints(t *testing.T) {
+	localNode := &Node{
+		ID:     "local",
+		Status: NodeStatusActive,
+	}
+	s := NewState(localNode.Copy(), log.NewNopLogger())
+
+	s.AddLocalEndpoint("my-endpoint-1")
+
+	s.AddNode(&Node{
+		ID:     "remote-1",
+		Status: NodeStatusActive,
+	})
+	assert.True(t, s.UpdateRemoteEndpoint("remote-1", "my-endpoint-1", 2))
+	assert.True(t, s.UpdateRemoteEndpoint("remote-1", "my-endpoint-2", 3))
+
+	// Unreachable nodes are ignored.
+	s.AddNode(&Node{
+		ID:     "remote-2",
+		Status: NodeStatusActive,
+	})
+	assert.True(t, s.UpdateRemoteEndpoint("remote-2", "my-endpoint-3", 1))
+	assert.True(t, s.UpdateRemoteStatus("remote-2", NodeStatusUnreachable))
+
+	// Draining nodes are ignored.
+	s.AddNode(&Node{
+		ID:     "remote-3",
+		Status: NodeStatusActive,
+	})
+	assert.True(t, s.UpdateRemoteEndpoint("remote-3", "my-endpoint-4", 1))
+	assert.True(t, s.UpdateRemoteDraining("remote-3", true))
+
+	assert.Equal(t, map[string]int{
+		"my-endpoint-1": 3,
+		"my-endpoint-2": 3,
+	}, s.Endpoints())
+}
+
+// benchmarkState returns a state with the given number of remote nodes,
+// each with the given number of endpoints.
+func benchmarkState(nodes int, endpoints int) *State {
+	s := NewState(&Node{
+		ID:     "local",
+		Status: NodeStatusActive,
+	}, log.NewNopLogger())
+	for i := 0; i != nodes; i++ {
+		node := &Node{
+			ID:        "node-" + strconv.Itoa(i),
+			Status:    NodeStatusActive,
+			Endpoints: make(map[string]int),
+		}
+		for j := 0; j != endpoints; j++ {
+			node.Endpoints["endpoint-"+strconv.Itoa(i*endpoints+j)] = 1
+		}
+		s.AddNode(node)
+	}
+	return s
+}
+
+func BenchmarkState_LookupEndpoint(b *testing.B) {
+	s := benchmarkState(100, 100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, ok := s.LookupEndpoint("endpoint-" + strconv.Itoa(i%10000)); !ok {
+			b.Fatal("endpoint not found")
+		}
+	}
+}
+
+// Benchmarks looking up endpoints concurrently while gossip updates the
+// state.
+func BenchmarkState_LookupEndpointParallel(b *testing.B) {
+	s := benchmarkState(100, 100)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			s.UpdateRemoteEndpoint("node-"+strconv.Itoa(i%100), "endpoint-new", i%2)
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, ok := s.LookupEndpoint("endpoint-" + strconv.Itoa(i%10000)); !ok {
+				b.Error("endpoint not found")
+				return
+			}
+			i++
+		}
+	})
 }
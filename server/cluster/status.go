@@ -21,6 +21,7 @@ func (s *Status) Register(group *gin.RouterGroup) {
 	group.GET("/nodes", s.listNodesRoute)
 	group.GET("/nodes/local", s.getLocalNodeRoute)
 	group.GET("/nodes/:id", s.getNodeRoute)
+	group.GET("/watch", s.watchRoute)
 }
 
 func (s *Status) listNodesRoute(c *gin.Context) {
@@ -43,4 +44,20 @@ func (s *Status) getNodeRoute(c *gin.Context) {
 	c.JSON(http.StatusOK, node)
 }
 
+// watchRoute streams changes to the cluster state as server-sent events.
+//
+// Each event has the change type as the event name and the JSON encoded
+// change as the data. Note only changes after the request is received are
+// streamed, so clients should first fetch '/nodes' to get the current state.
+func (s *Status) watchRoute(c *gin.Context) {
+	stream := status.NewStream()
+
+	unsubscribe := s.state.Subscribe(func(change Change) {
+		stream.Publish(string(change.Type), change)
+	})
+	defer unsubscribe()
+
+	stream.Serve(c)
+}
+
 var _ status.Handler = &Status{}
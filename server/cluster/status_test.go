@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatus_Watch(t *testing.T) {
+	s := NewState(&Node{
+		ID: "local",
+	}, log.NewNopLogger())
+
+	router := gin.New()
+	NewStatus(s).Register(router.Group("/status/cluster"))
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/status/cluster/watch")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	s.AddNode(&Node{
+		ID:     "remote",
+		Status: NodeStatusActive,
+	})
+
+	r := bufio.NewReader(resp.Body)
+
+	event, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event:node_added", strings.TrimSpace(event))
+
+	data, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		`data:{"type":"node_added","node_id":"remote","status":"active"}`,
+		strings.TrimSpace(data),
+	)
+}
@@ -2,10 +2,19 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
+	pikoconfig "github.com/andydunstall/piko/pkg/config"
+	"github.com/andydunstall/piko/pkg/encryption"
+	"github.com/andydunstall/piko/pkg/errorreport"
 	"github.com/andydunstall/piko/pkg/gossip"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/spiffe"
+	"github.com/andydunstall/piko/pkg/telemetry"
 	"github.com/andydunstall/piko/server/auth"
 	"github.com/spf13/pflag"
 )
@@ -18,16 +27,76 @@ type ClusterConfig struct {
 	// the node ID to ensure uniqueness.
 	NodeIDPrefix string `json:"node_id_prefix" yaml:"node_id_prefix"`
 
+	// NodeIDFile is a path to persist the generated node ID, so the node
+	// reuses the same ID across restarts.
+	NodeIDFile string `json:"node_id_file" yaml:"node_id_file"`
+
 	// Join contians a list of addresses of members in the cluster to join.
 	Join []string `json:"join" yaml:"join"`
 
+	// JoinDNSServers contains the DNS servers used to resolve join domains.
+	// If empty the system resolver is used.
+	JoinDNSServers []string `json:"join_dns_servers" yaml:"join_dns_servers"`
+
+	// JoinInterval is the interval to re-resolve and join the join addresses.
+	// If zero the join addresses are only resolved on startup.
+	JoinInterval time.Duration `json:"join_interval" yaml:"join_interval"`
+
 	AbortIfJoinFails bool `json:"abort_if_join_fails" yaml:"abort_if_join_fails"`
+
+	// Metadata contains custom labels to attach to the node, which are
+	// propagated to the other nodes in the cluster.
+	Metadata map[string]string `json:"metadata" yaml:"metadata"`
+
+	// PreferLabels contains label keys used to prefer forwarding requests
+	// to nodes with the same label values as this node.
+	PreferLabels []string `json:"prefer_labels" yaml:"prefer_labels"`
+
+	// ExpectedSize is the expected number of nodes in the cluster, used to
+	// detect whether the node is partitioned. If zero partition detection is
+	// disabled.
+	ExpectedSize int `json:"expected_size" yaml:"expected_size"`
+
+	// Role is the role of the node in the cluster, either 'full' or 'proxy'.
+	Role string `json:"role" yaml:"role"`
+
+	// AdvertiseIPFamily is the IP family to use when discovering the
+	// advertise IP for bind addresses without a host, either 'auto', 'ipv4'
+	// or 'ipv6'.
+	AdvertiseIPFamily string `json:"advertise_ip_family" yaml:"advertise_ip_family"`
+}
+
+// ProxyOnly returns whether the node is configured to only accept proxy
+// traffic.
+func (c *ClusterConfig) ProxyOnly() bool {
+	return c.Role == "proxy"
 }
 
 func (c *ClusterConfig) Validate() error {
 	if c.NodeID == "" {
 		return fmt.Errorf("missing node id")
 	}
+	for _, server := range c.JoinDNSServers {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			host = strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid join dns server: %s", server)
+		}
+	}
+	if c.JoinInterval < 0 {
+		return fmt.Errorf("join interval cannot be negative")
+	}
+	if c.ExpectedSize < 0 {
+		return fmt.Errorf("expected size cannot be negative")
+	}
+	if c.Role != "full" && c.Role != "proxy" {
+		return fmt.Errorf("unsupported role: %s", c.Role)
+	}
+	if c.AdvertiseIPFamily != "auto" && c.AdvertiseIPFamily != "ipv4" && c.AdvertiseIPFamily != "ipv6" {
+		return fmt.Errorf("unsupported advertise ip family: %s", c.AdvertiseIPFamily)
+	}
 
 	return nil
 }
@@ -57,6 +126,21 @@ Such as you could use the node or pod  name as a prefix, then add a unique
 identifier to ensure the node ID is unique across restarts.`,
 	)
 
+	fs.StringVar(
+		&c.NodeIDFile,
+		"cluster.node-id-file",
+		c.NodeIDFile,
+		`
+A path to a file to persist the node ID.
+
+If the file exists the node ID is loaded from the file, otherwise Piko will
+generate a node ID (including the '--cluster.node-id-prefix') and write it to
+the file. This means the node keeps the same ID across restarts, rather than
+appearing as a new node in the cluster.
+
+Ignored if '--cluster.node-id' is set.`,
+	)
+
 	fs.StringSliceVar(
 		&c.Join,
 		"cluster.join",
@@ -76,6 +160,37 @@ Note each node propagates membership information to the other known nodes,
 so the initial set of configured members only needs to be a subset of nodes.`,
 	)
 
+	fs.StringSliceVar(
+		&c.JoinDNSServers,
+		"cluster.join-dns-servers",
+		c.JoinDNSServers,
+		`
+A list of DNS servers used to resolve domains in '--cluster.join', such as
+'--cluster.join-dns-servers 10.96.0.10,10.96.0.11:53'.
+
+Each server is an IP address with an optional port (defaulting to 53). If a
+query to one server fails, it is retried with the next server.
+
+By default the system resolver is used.`,
+	)
+
+	fs.DurationVar(
+		&c.JoinInterval,
+		"cluster.join-interval",
+		c.JoinInterval,
+		`
+The interval to re-resolve the addresses in '--cluster.join' and attempt to
+join any unknown nodes.
+
+This means the node keeps retrying the join addresses as the DNS records
+change, rather than only resolving the addresses once on startup. Such as if
+the node fails to join the cluster on startup, or the cluster is partitioned
+and the join domain resolves to the nodes in the other partition, the node
+will rejoin once the nodes are reachable.
+
+If zero, the addresses are only resolved on startup.`,
+	)
+
 	fs.BoolVar(
 		&c.AbortIfJoinFails,
 		"cluster.abort-if-join-fails",
@@ -84,6 +199,81 @@ so the initial set of configured members only needs to be a subset of nodes.`,
 Whether the server node should abort if it is configured with more than one
 node to join (excluding itself) but fails to join any members.`,
 	)
+
+	fs.StringToStringVar(
+		&c.Metadata,
+		"cluster.metadata",
+		c.Metadata,
+		`
+Custom metadata labels to attach to the node, such as
+'--cluster.metadata region=eu,tier=edge'.
+
+The labels are propagated to the other nodes in the cluster, included in the
+cluster status, and can be used to route requests with
+'--cluster.prefer-labels'.`,
+	)
+
+	fs.StringSliceVar(
+		&c.PreferLabels,
+		"cluster.prefer-labels",
+		c.PreferLabels,
+		`
+A list of label keys used to prefer forwarding requests to nodes with the same
+label values as this node.
+
+Such as if the node has label 'region=eu' and is configured with
+'--cluster.prefer-labels region', when a request is received for an endpoint
+that is connected to multiple other nodes, the request is forwarded to a node
+in the same region if possible.`,
+	)
+
+	fs.IntVar(
+		&c.ExpectedSize,
+		"cluster.expected-size",
+		c.ExpectedSize,
+		`
+The expected number of nodes in the cluster.
+
+If configured, when the node can see fewer than a quorum (a majority) of the
+expected nodes as active, it considers itself partitioned from the cluster.
+A partitioned node reports a degraded status on '/ready' and sets the
+'piko_cluster_partitioned' metric.
+
+Set to 0 to disable partition detection.`,
+	)
+
+	fs.StringVar(
+		&c.Role,
+		"cluster.role",
+		c.Role,
+		`
+The role of the node in the cluster, either 'full' or 'proxy'.
+
+A 'full' node accepts both downstream proxy traffic and upstream connections.
+
+A 'proxy' node accepts downstream proxy traffic and forwards it to the other
+nodes in the cluster, but never accepts upstream connections itself. Such as
+you may deploy lightweight 'proxy' nodes at the edge in front of a set of
+'full' nodes.
+
+Note when running a 'proxy' node the upstream port isn't opened.`,
+	)
+
+	fs.StringVar(
+		&c.AdvertiseIPFamily,
+		"cluster.advertise-ip-family",
+		c.AdvertiseIPFamily,
+		`
+The IP family of the private IP to advertise when a bind address doesn't
+include an IP (such as ':8000' or '[::]:8000') and no advertise address is
+configured. Either 'auto', 'ipv4' or 'ipv6'.
+
+'auto' prefers a private IPv4 address, falling back to a private IPv6
+address on IPv6 only hosts. 'ipv4' and 'ipv6' only use an address of the
+given family.
+
+Note link-local IPv6 addresses are never advertised.`,
+	)
 }
 
 // HTTPConfig contains generic configuration for the HTTP servers.
@@ -159,188 +349,1351 @@ keys and values, including the request line.`,
 	)
 }
 
-type ProxyConfig struct {
+// ForwardConfig configures the pool of connections used to forward requests
+// to other nodes in the cluster.
+type ForwardConfig struct {
+	// Protocol is the protocol used to forward requests to other nodes,
+	// either 'http' or 'grpc'.
+	Protocol string `json:"protocol" yaml:"protocol"`
+
+	// MaxConns is the maximum number of connections to each node, including
+	// idle connections. Requests exceeding the limit wait for a connection.
+	//
+	// A limit of 0 means there is no limit.
+	MaxConns int `json:"max_conns" yaml:"max_conns"`
+
+	// MaxIdleConns is the maximum number of idle connections to keep open to
+	// each node.
+	MaxIdleConns int `json:"max_idle_conns" yaml:"max_idle_conns"`
+
+	// IdleTimeout is the maximum amount of time an idle connection to another
+	// node is kept open.
+	IdleTimeout time.Duration `json:"idle_timeout" yaml:"idle_timeout"`
+
+	// Compression is the encoding used to compress request and response
+	// bodies forwarded to other nodes, either 'gzip', 'zstd' or 'off'.
+	Compression string `json:"compression" yaml:"compression"`
+}
+
+func (c *ForwardConfig) Validate() error {
+	if c.Protocol != "" && c.Protocol != "http" && c.Protocol != "grpc" {
+		return fmt.Errorf("unsupported protocol: %s", c.Protocol)
+	}
+	switch c.Compression {
+	case "", "off", "gzip", "zstd":
+	default:
+		return fmt.Errorf("unsupported compression: %s", c.Compression)
+	}
+	if c.MaxConns < 0 {
+		return fmt.Errorf("max conns cannot be negative")
+	}
+	if c.MaxIdleConns < 0 {
+		return fmt.Errorf("max idle conns cannot be negative")
+	}
+	if c.IdleTimeout < 0 {
+		return fmt.Errorf("idle timeout cannot be negative")
+	}
+	return nil
+}
+
+func (c *ForwardConfig) RegisterFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&c.Protocol,
+		"proxy.forward.protocol",
+		c.Protocol,
+		`
+The protocol used to forward requests to other nodes in the cluster, either
+'http' or 'grpc'.
+
+When 'grpc' is used, requests are forwarded to the RPC port of the node with
+the upstream (see '--rpc.bind-addr'), which multiplexes requests over a single
+connection, propagates deadlines and returns typed errors.
+
+Requests are still forwarded using HTTP to nodes that don't have an RPC port,
+and for WebSocket requests.`,
+	)
+
+	fs.IntVar(
+		&c.MaxConns,
+		"proxy.forward.max-conns",
+		c.MaxConns,
+		`
+The maximum number of connections to each node in the cluster when forwarding
+requests using HTTP, including idle connections.
+
+Requests exceeding the limit wait for a connection to become available, up to
+the proxy timeout. This stops a slow node from exhausting the sockets of the
+nodes forwarding requests to it.
+
+Set to 0 for no limit.`,
+	)
+
+	fs.IntVar(
+		&c.MaxIdleConns,
+		"proxy.forward.max-idle-conns",
+		c.MaxIdleConns,
+		`
+The maximum number of idle connections to keep open to each node in the
+cluster when forwarding requests.
+
+Forwarded requests reuse persistent connections to the other nodes, which
+avoids the latency of opening a new connection for each request.`,
+	)
+
+	fs.DurationVar(
+		&c.IdleTimeout,
+		"proxy.forward.idle-timeout",
+		c.IdleTimeout,
+		`
+The maximum amount of time an idle connection to another node is kept open.`,
+	)
+
+	fs.StringVar(
+		&c.Compression,
+		"proxy.forward.compression",
+		c.Compression,
+		`
+The encoding used to compress request and response bodies forwarded to other
+nodes in the cluster, either 'gzip', 'zstd' or 'off'.
+
+Compressing forwarded bodies reduces the bandwidth between nodes, such as when
+nodes are spread across availability zones or regions, at the cost of
+additional CPU.
+
+Nodes always accept compressed requests from other nodes, so compression can
+be enabled one node at a time.`,
+	)
+}
+
+// ProxyListenerConfig configures an additional proxy listener.
+type ProxyListenerConfig struct {
+	// BindAddr is the address to bind to listen for incoming HTTP connections.
+	BindAddr string `json:"bind_addr" yaml:"bind_addr"`
+
+	TLS TLSConfig `json:"tls" yaml:"tls"`
+}
+
+func (c *ProxyListenerConfig) Validate() error {
+	if c.BindAddr == "" {
+		return fmt.Errorf("missing bind addr")
+	}
+	if path, ok := UnixSocketPath(c.BindAddr); ok && path == "" {
+		return fmt.Errorf("missing bind addr unix socket path")
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	return nil
+}
+
+// WebhookConfig configures verifying webhook signatures.
+type WebhookConfig struct {
+	// Format is the signature format, either 'github', 'stripe' or 'hmac'.
+	// If empty, signatures aren't verified.
+	Format string `json:"format" yaml:"format"`
+
+	// Secret is the secret key shared with the webhook sender.
+	Secret string `json:"secret" yaml:"secret"`
+
+	// SecretFile is the path of a file containing the secret.
+	SecretFile string `json:"secret_file" yaml:"secret_file"`
+
+	// Header is the request header containing the signature. Only used by
+	// the 'hmac' format.
+	Header string `json:"header" yaml:"header"`
+
+	// Tolerance is the maximum age of the webhook timestamp. Only used by
+	// the 'stripe' format.
+	Tolerance time.Duration `json:"tolerance" yaml:"tolerance"`
+
+	// MaxBodySize is the maximum size of the request body to verify.
+	// Requests with a larger body are rejected.
+	MaxBodySize int64 `json:"max_body_size" yaml:"max_body_size"`
+}
+
+func (c *WebhookConfig) Enabled() bool {
+	return c.Format != ""
+}
+
+func (c *WebhookConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	switch c.Format {
+	case "github", "stripe":
+	case "hmac":
+		if c.Header == "" {
+			return fmt.Errorf("missing header")
+		}
+	default:
+		return fmt.Errorf("unsupported format: %s", c.Format)
+	}
+	if c.Secret == "" {
+		return fmt.Errorf("missing secret")
+	}
+	if c.Tolerance < 0 {
+		return fmt.Errorf("tolerance cannot be negative")
+	}
+	if c.MaxBodySize < 0 {
+		return fmt.Errorf("max body size cannot be negative")
+	}
+	return nil
+}
+
+// LoadSecrets loads the secret from a file if configured.
+func (c *WebhookConfig) LoadSecrets() error {
+	if err := pikoconfig.LoadSecretFile(&c.Secret, c.SecretFile); err != nil {
+		return fmt.Errorf("secret: %w", err)
+	}
+	return nil
+}
+
+// CORSConfig configures the CORS policy the proxy applies to requests to an
+// endpoint.
+type CORSConfig struct {
+	// AllowedOrigins are the origins permitted to make cross-origin
+	// requests, which may be a pattern containing '*' wildcards, such as
+	// 'https://*.example.com'. An origin of '*' permits any origin. If
+	// empty, CORS isn't handled by the proxy.
+	AllowedOrigins []string `json:"allowed_origins" yaml:"allowed_origins"`
+
+	// AllowedMethods are the methods permitted in cross-origin requests. If
+	// empty, defaults to 'GET', 'HEAD', 'POST', 'PUT', 'PATCH' and 'DELETE'.
+	AllowedMethods []string `json:"allowed_methods" yaml:"allowed_methods"`
+
+	// AllowedHeaders are the request headers permitted in cross-origin
+	// requests. A header of '*' permits any header.
+	AllowedHeaders []string `json:"allowed_headers" yaml:"allowed_headers"`
+
+	// ExposedHeaders are the response headers browsers may expose to
+	// cross-origin requests.
+	ExposedHeaders []string `json:"exposed_headers" yaml:"exposed_headers"`
+
+	// AllowCredentials indicates whether cross-origin requests may include
+	// credentials, such as cookies.
+	AllowCredentials bool `json:"allow_credentials" yaml:"allow_credentials"`
+
+	// MaxAge is how long browsers may cache preflight responses. If 0, the
+	// browser default is used.
+	MaxAge time.Duration `json:"max_age" yaml:"max_age"`
+}
+
+func (c *CORSConfig) Enabled() bool {
+	return len(c.AllowedOrigins) != 0
+}
+
+func (c *CORSConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		if origin == "" {
+			return fmt.Errorf("empty allowed origin")
+		}
+		if origin == "*" && c.AllowCredentials {
+			return fmt.Errorf("cannot allow credentials with origin '*'")
+		}
+	}
+	for _, method := range c.AllowedMethods {
+		if method == "" || strings.ToUpper(method) != method {
+			return fmt.Errorf("invalid allowed method: %q", method)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max age cannot be negative")
+	}
+	return nil
+}
+
+// ForwardAuthConfig configures delegating authorization of requests to an
+// endpoint to an external auth service.
+type ForwardAuthConfig struct {
+	// URL is the URL of the auth service. If empty, requests aren't
+	// forwarded for authorization.
+	URL string `json:"url" yaml:"url"`
+
+	// Timeout is the timeout waiting for the auth service to respond.
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+
+	// RequestHeaders are the request headers to forward to the auth service.
+	// If empty, all request headers are forwarded.
+	RequestHeaders []string `json:"request_headers" yaml:"request_headers"`
+
+	// ResponseHeaders are the auth service response headers to add to the
+	// proxied request, such as the ID of the authenticated user.
+	ResponseHeaders []string `json:"response_headers" yaml:"response_headers"`
+}
+
+func (c *ForwardAuthConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+func (c *ForwardAuthConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid url: unsupported scheme: %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid url: missing host")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	for _, name := range c.RequestHeaders {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("request headers: invalid header name: %q", name)
+		}
+	}
+	for _, name := range c.ResponseHeaders {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("response headers: invalid header name: %q", name)
+		}
+	}
+	return nil
+}
+
+// ProxyEndpointConfig configures how requests to an endpoint are handled by
+// the proxy, such as the client IPs permitted to reach the endpoint.
+type ProxyEndpointConfig struct {
+	// Endpoint is the endpoint ID, which may be a pattern containing '*'
+	// wildcards, such as 'team-a-*'.
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+
+	// AllowCIDRs are the client IP ranges permitted to reach the endpoint.
+	// If empty, all clients are permitted.
+	AllowCIDRs []string `json:"allow_cidrs" yaml:"allow_cidrs"`
+
+	// Webhook configures verifying the signature of webhook requests to the
+	// endpoint.
+	Webhook WebhookConfig `json:"webhook" yaml:"webhook"`
+
+	// CORS configures the CORS policy of the endpoint.
+	CORS CORSConfig `json:"cors" yaml:"cors"`
+
+	// ForwardAuth configures delegating authorization of requests to the
+	// endpoint to an external auth service.
+	ForwardAuth ForwardAuthConfig `json:"forward_auth" yaml:"forward_auth"`
+
+	// Visibility is either 'public', where proxy clients don't need to
+	// authenticate, or 'private', where proxy clients must authenticate. If
+	// empty, the endpoint is private if proxy client authentication is
+	// enabled.
+	Visibility string `json:"visibility" yaml:"visibility"`
+
+	// ResponseHeaders are headers to set on responses from the endpoint,
+	// such as security headers like 'Strict-Transport-Security'. Headers set
+	// by the upstream are replaced, and an empty value removes the header
+	// from the response.
+	ResponseHeaders map[string]string `json:"response_headers" yaml:"response_headers"`
+}
+
+func (c *ProxyEndpointConfig) Validate() error {
+	if c.Endpoint == "" {
+		return fmt.Errorf("missing endpoint")
+	}
+	if _, err := ParseCIDRs(c.AllowCIDRs); err != nil {
+		return fmt.Errorf("allow cidrs: %w", err)
+	}
+	if err := c.Webhook.Validate(); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	if err := c.CORS.Validate(); err != nil {
+		return fmt.Errorf("cors: %w", err)
+	}
+	if err := c.ForwardAuth.Validate(); err != nil {
+		return fmt.Errorf("forward auth: %w", err)
+	}
+	switch c.Visibility {
+	case "", "public", "private":
+	default:
+		return fmt.Errorf("unsupported visibility: %s", c.Visibility)
+	}
+	for name, value := range c.ResponseHeaders {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("response headers: invalid header name: %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("response headers: %s: invalid header value", name)
+		}
+	}
+	return nil
+}
+
+type ProxyConfig struct {
+	// BindAddr is the address to bind to listen for incoming HTTP connections.
+	BindAddr string `json:"bind_addr" yaml:"bind_addr"`
+
+	// AdvertiseAddr is the address to advertise to other nodes.
+	AdvertiseAddr string `json:"advertise_addr" yaml:"advertise_addr"`
+
+	// AdvertiseInterface is a network interface name or CIDR to select the
+	// advertise IP from.
+	AdvertiseInterface string `json:"advertise_interface" yaml:"advertise_interface"`
+
+	// Timeout is the timeout to forward incoming requests to the upstream.
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+
+	// FlushInterval is the interval to flush response bodies to the client.
+	// If zero, response bodies are buffered, except streaming responses which
+	// are flushed immediately. If negative, response bodies are flushed
+	// immediately after each write.
+	FlushInterval time.Duration `json:"flush_interval" yaml:"flush_interval"`
+
+	// AccessLog indicates whether to log all incoming connections and
+	// requests.
+	AccessLog bool `json:"access_log" yaml:"access_log"`
+
+	// MaxEndpointMetrics is the maximum number of endpoints to record
+	// per-endpoint request metrics for. Requests for any other endpoints are
+	// recorded with the '__other__' endpoint label.
+	//
+	// A limit of 0 disables per-endpoint metrics.
+	MaxEndpointMetrics int `json:"max_endpoint_metrics" yaml:"max_endpoint_metrics"`
+
+	// SlowRequestThreshold is the duration above which a proxied request is
+	// logged as slow. A threshold of 0 disables logging slow requests.
+	SlowRequestThreshold time.Duration `json:"slow_request_threshold" yaml:"slow_request_threshold"`
+
+	// MaxConnections is the maximum number of open downstream connections
+	// to the node. Requests on connections exceeding the limit are rejected.
+	//
+	// A limit of 0 means there is no limit.
+	MaxConnections int `json:"max_connections" yaml:"max_connections"`
+
+	// MaxConcurrentRequests is the maximum number of concurrent proxied
+	// requests to the node. Requests exceeding the limit are rejected.
+	//
+	// A limit of 0 means there is no limit.
+	MaxConcurrentRequests int `json:"max_concurrent_requests" yaml:"max_concurrent_requests"`
+
+	HTTP HTTPConfig `json:"http" yaml:"http"`
+
+	Forward ForwardConfig `json:"forward" yaml:"forward"`
+
+	TLS ProxyTLSConfig `json:"tls" yaml:"tls"`
+
+	// Auth configures verifying proxy client tokens. If enabled, proxy
+	// requests must include a token permitting the requested endpoint.
+	Auth auth.Config `json:"auth" yaml:"auth"`
+
+	// AuthLockout configures locking out clients after repeated failed
+	// proxy client authentication attempts.
+	AuthLockout LockoutConfig `json:"auth_lockout" yaml:"auth_lockout"`
+
+	// Listeners are additional listeners to accept proxy connections, each
+	// with their own bind address and TLS configuration.
+	//
+	// Only the main listener is advertised to other nodes.
+	Listeners []ProxyListenerConfig `json:"listeners" yaml:"listeners"`
+
+	// AllowCIDRs are the client IP ranges permitted to send proxy requests.
+	// If empty, all clients are permitted unless denied by DenyCIDRs.
+	AllowCIDRs []string `json:"allow_cidrs" yaml:"allow_cidrs"`
+
+	// DenyCIDRs are the client IP ranges not permitted to send proxy
+	// requests. Takes precedence over AllowCIDRs.
+	DenyCIDRs []string `json:"deny_cidrs" yaml:"deny_cidrs"`
+
+	// TrustedProxies are the IP ranges of proxies, such as load balancers,
+	// trusted to set the client IP in the 'X-Forwarded-For' header.
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"`
+
+	// Endpoints configures how requests to specific endpoints are handled,
+	// such as restricting the permitted client IPs. If an endpoint matches
+	// multiple entries, the first is used.
+	//
+	// Only configurable using YAML.
+	Endpoints []ProxyEndpointConfig `json:"endpoints" yaml:"endpoints"`
+}
+
+func (c *ProxyConfig) Validate() error {
+	if c.BindAddr == "" {
+		return fmt.Errorf("missing bind addr")
+	}
+	if path, ok := UnixSocketPath(c.BindAddr); ok && path == "" {
+		return fmt.Errorf("missing bind addr unix socket path")
+	}
+	if err := validateAdvertiseInterface(
+		c.AdvertiseAddr, c.AdvertiseInterface,
+	); err != nil {
+		return err
+	}
+	if c.Timeout == 0 {
+		return fmt.Errorf("missing timeout")
+	}
+	if c.MaxEndpointMetrics < 0 {
+		return fmt.Errorf("max endpoint metrics cannot be negative")
+	}
+	if c.SlowRequestThreshold < 0 {
+		return fmt.Errorf("slow request threshold cannot be negative")
+	}
+	if c.MaxConnections < 0 {
+		return fmt.Errorf("max connections cannot be negative")
+	}
+	if c.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("max concurrent requests cannot be negative")
+	}
+	if err := c.Forward.Validate(); err != nil {
+		return fmt.Errorf("forward: %w", err)
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	if err := c.AuthLockout.Validate(); err != nil {
+		return fmt.Errorf("auth lockout: %w", err)
+	}
+	for _, l := range c.Listeners {
+		if err := l.Validate(); err != nil {
+			if l.BindAddr != "" {
+				return fmt.Errorf("listener: %s: %w", l.BindAddr, err)
+			}
+			return fmt.Errorf("listener: %w", err)
+		}
+	}
+	if _, err := ParseCIDRs(c.AllowCIDRs); err != nil {
+		return fmt.Errorf("allow cidrs: %w", err)
+	}
+	if _, err := ParseCIDRs(c.DenyCIDRs); err != nil {
+		return fmt.Errorf("deny cidrs: %w", err)
+	}
+	if _, err := ParseCIDRs(c.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted proxies: %w", err)
+	}
+	for _, e := range c.Endpoints {
+		if err := e.Validate(); err != nil {
+			if e.Endpoint != "" {
+				return fmt.Errorf("endpoint: %s: %w", e.Endpoint, err)
+			}
+			return fmt.Errorf("endpoint: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *ProxyConfig) RegisterFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&c.BindAddr,
+		"proxy.bind-addr",
+		c.BindAddr,
+		`
+The host/port to listen for incoming proxy connections.
+
+If the host is unspecified it defaults to all listeners, such as
+'--proxy.bind-addr :8000' will listen on '0.0.0.0:8000'
+
+To listen on a Unix domain socket, use a 'unix://' prefix followed by the
+socket path, such as '--proxy.bind-addr unix:///run/piko/proxy.sock'. Since
+other nodes can't connect to the socket, when running a cluster
+'--proxy.advertise-addr' must be an address other nodes can use to reach the
+socket (such as a local proxy that forwards to the socket).`,
+	)
+
+	fs.StringVar(
+		&c.AdvertiseAddr,
+		"proxy.advertise-addr",
+		c.AdvertiseAddr,
+		`
+Address to advertise to other nodes in the cluster. This is the
+address other nodes will used to forward proxy connections.
+
+Such as if the listen address is ':8000', the advertised address may be
+'10.26.104.45:8000' or 'node1.cluster:8000'.
+
+By default, if the bind address includes an IP to bind to that will be used.
+If the bind address does not include an IP (such as ':8000') the nodes
+private IP will be used, such as a bind address of ':8000' may have an
+advertise address of '10.26.104.14:8000'.`,
+	)
+
+	fs.StringVar(
+		&c.AdvertiseInterface,
+		"proxy.advertise-interface",
+		c.AdvertiseInterface,
+		`
+Network interface to select the advertise IP from, when no advertise address
+is configured. The advertised port is the bind port.
+
+This may be either an interface name, such as 'eth1', or a CIDR to select the
+first interface address in the range, such as '10.26.0.0/16'. This is useful
+on hosts with multiple network interfaces, where the default private IP may
+be the wrong interface.
+
+If the interface has both IPv4 and IPv6 addresses, the address is selected
+using '--cluster.advertise-ip-family'.`,
+	)
+
+	fs.DurationVar(
+		&c.Timeout,
+		"proxy.timeout",
+		c.Timeout,
+		`
+Timeout when forwarding incoming requests to the upstream.
+
+Streaming responses, such as Server-Sent Events ('text/event-stream'), are
+exempt from the timeout (and '--proxy.http.write-timeout') once the upstream
+responds, so the stream stays open for as long as the client is connected.`,
+	)
+
+	fs.DurationVar(
+		&c.FlushInterval,
+		"proxy.flush-interval",
+		c.FlushInterval,
+		`
+The interval to flush response bodies to the client while proxying.
+
+If 0, response bodies are buffered, except streaming responses (such as
+Server-Sent Events or responses without a content length) which are flushed
+immediately. Set to a negative value, such as '-1ms', to flush immediately
+after each write.`,
+	)
+
+	fs.BoolVar(
+		&c.AccessLog,
+		"proxy.access-log",
+		c.AccessLog,
+		`
+Whether to log all incoming connections and requests.`,
+	)
+
+	fs.IntVar(
+		&c.MaxEndpointMetrics,
+		"proxy.max-endpoint-metrics",
+		c.MaxEndpointMetrics,
+		`
+The maximum number of endpoints to record per-endpoint request metrics for,
+including the number of requests, latency and bytes proxied.
+
+Limiting the number of endpoints limits the cardinality of the metrics.
+Requests for endpoints exceeding the limit are recorded with the '__other__'
+endpoint label.
+
+Set to 0 to disable per-endpoint metrics.`,
+	)
+
+	fs.DurationVar(
+		&c.SlowRequestThreshold,
+		"proxy.slow-request-threshold",
+		c.SlowRequestThreshold,
+		`
+The duration above which a proxied request is logged as slow, such as '2s'.
+
+Slow requests are logged with the endpoint ID, the upstream node (if the
+request was forwarded to another node), and a timing breakdown including the
+time waiting for a connection to the upstream ('queue'), writing the request
+('forward') and waiting for the upstream to respond ('upstream').
+
+Set to 0 to disable logging slow requests.`,
+	)
+
+	fs.IntVar(
+		&c.MaxConnections,
+		"proxy.max-connections",
+		c.MaxConnections,
+		`
+The maximum number of open downstream connections to the node, across all
+proxy listeners.
+
+Requests on connections exceeding the limit are rejected with a 503 status and
+the connection is closed.
+
+Set to 0 for no limit.`,
+	)
+
+	fs.IntVar(
+		&c.MaxConcurrentRequests,
+		"proxy.max-concurrent-requests",
+		c.MaxConcurrentRequests,
+		`
+The maximum number of concurrent proxied requests to the node, including
+requests forwarded by other nodes. Note TCP and WebSocket connections count as
+a request for the lifetime of the connection.
+
+Requests exceeding the limit are rejected with a 503 status.
+
+Set to 0 for no limit.`,
+	)
+
+	c.HTTP.RegisterFlags(fs, "proxy")
+
+	c.Forward.RegisterFlags(fs)
+
+	c.TLS.RegisterFlags(fs, "proxy")
+
+	c.Auth.RegisterFlagsWithPrefix(fs, "proxy.auth", "proxy client")
+
+	c.AuthLockout.RegisterFlags(fs, "proxy")
+
+	fs.StringSliceVar(
+		&c.AllowCIDRs,
+		"proxy.allow-cidrs",
+		c.AllowCIDRs,
+		`
+Client IP ranges permitted to send proxy requests, such as '10.0.0.0/8'. A
+single IP may also be given, such as '10.26.104.14'.
+
+If configured, requests from clients outside the ranges are rejected with a
+403 status before being proxied. Applies to all proxy listeners.
+
+Note when forwarding requests between nodes using HTTP, forwarded requests
+are also checked, so either permit the node IPs or configure the nodes as
+trusted proxies.`,
+	)
+
+	fs.StringSliceVar(
+		&c.DenyCIDRs,
+		"proxy.deny-cidrs",
+		c.DenyCIDRs,
+		`
+Client IP ranges not permitted to send proxy requests. Requests from clients
+in the ranges are rejected with a 403 status before being proxied, even if
+the client is in '--proxy.allow-cidrs'.`,
+	)
+
+	fs.StringSliceVar(
+		&c.TrustedProxies,
+		"proxy.trusted-proxies",
+		c.TrustedProxies,
+		`
+IP ranges of proxies, such as load balancers, trusted to set the client IP
+using the 'X-Forwarded-For' or 'X-Real-IP' headers.
+
+The client IP used by '--proxy.allow-cidrs' and '--proxy.deny-cidrs' is the
+connection IP, unless the connection is from a trusted proxy, in which case
+it's the last IP in 'X-Forwarded-For' that isn't a trusted proxy.
+
+By default no proxies are trusted.`,
+	)
+}
+
+// ParseCIDRs parses the given IP ranges. Each entry may be either a CIDR or a
+// single IP.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var ipNets []*net.IPNet
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			} else {
+				ip = ip.To4()
+			}
+			ipNets = append(ipNets, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr: %s", cidr)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+// RebalanceConfig configures rebalancing upstream connections across the
+// nodes in the cluster.
+type RebalanceConfig struct {
+	// Threshold is the fraction above the cluster average number of
+	// connected upstreams a node must exceed before it sheds connections.
+	//
+	// A threshold of 0 disables rebalancing.
+	Threshold float64 `json:"threshold" yaml:"threshold"`
+
+	// ShedRate is the maximum fraction of the nodes connected upstreams to
+	// shed when rebalancing.
+	ShedRate float64 `json:"shed_rate" yaml:"shed_rate"`
+
+	// MinConns is the minimum number of upstreams connected to the node
+	// before it will shed connections.
+	MinConns int `json:"min_conns" yaml:"min_conns"`
+}
+
+func (c *RebalanceConfig) Validate() error {
+	if c.Threshold < 0 {
+		return fmt.Errorf("threshold cannot be negative")
+	}
+	if c.ShedRate < 0 || c.ShedRate > 1 {
+		return fmt.Errorf("shed rate must be between 0 and 1")
+	}
+	return nil
+}
+
+func (c *RebalanceConfig) RegisterFlags(fs *pflag.FlagSet) {
+	fs.Float64Var(
+		&c.Threshold,
+		"upstream.rebalance.threshold",
+		c.Threshold,
+		`
+When a new node joins the cluster, a node will shed upstream connections if
+its number of connected upstreams exceeds the cluster average by more than the
+given fraction. The shed upstreams will reconnect, and are likely to be routed
+to the new node, so load converges without waiting for upstreams to reconnect
+naturally.
+
+Such as a threshold of 0.2 means the node will shed connections if it has 20%
+more connections than the cluster average.
+
+A threshold of 0 disables rebalancing.`,
+	)
+
+	fs.Float64Var(
+		&c.ShedRate,
+		"upstream.rebalance.shed-rate",
+		c.ShedRate,
+		`
+The maximum fraction of the nodes connected upstreams to shed when
+rebalancing.`,
+	)
+
+	fs.IntVar(
+		&c.MinConns,
+		"upstream.rebalance.min-conns",
+		c.MinConns,
+		`
+The minimum number of upstreams connected to the node before it will shed
+connections when rebalancing.`,
+	)
+}
+
+type UpstreamConfig struct {
+	// BindAddr is the address to bind to listen for incoming HTTP connections.
+	BindAddr string `json:"bind_addr" yaml:"bind_addr"`
+
+	// AdvertiseAddr is the address to advertise to other nodes.
+	AdvertiseAddr string `json:"advertise_addr" yaml:"advertise_addr"`
+
+	// MaxConnections is the maximum number of connected upstreams to the
+	// node. Upstreams exceeding the limit are rejected.
+	//
+	// A limit of 0 means there is no limit.
+	MaxConnections int `json:"max_connections" yaml:"max_connections"`
+
+	// MaxRequestsPerConnection is the maximum number of concurrent requests
+	// to each connected upstream.
+	//
+	// A limit of 0 means there is no limit.
+	MaxRequestsPerConnection int `json:"max_requests_per_connection" yaml:"max_requests_per_connection"`
+
+	// HeartbeatInterval is the interval to send heartbeats to connected
+	// upstreams.
+	HeartbeatInterval time.Duration `json:"heartbeat_interval" yaml:"heartbeat_interval"`
+
+	// HeartbeatTimeout is the timeout waiting for a heartbeat response from
+	// an upstream before closing the connection.
+	HeartbeatTimeout time.Duration `json:"heartbeat_timeout" yaml:"heartbeat_timeout"`
+
+	// RequireApproval indicates whether endpoints must be approved before
+	// their upstreams receive traffic.
+	RequireApproval bool `json:"require_approval" yaml:"require_approval"`
+
+	// ApprovedEndpoints contains endpoints that are always approved when
+	// RequireApproval is enabled, which may be patterns such as 'team-a-*'.
+	ApprovedEndpoints []string `json:"approved_endpoints" yaml:"approved_endpoints"`
+
+	// TrustedProxies are the IP ranges of proxies, such as load balancers,
+	// trusted to set the upstream client IP in the 'X-Forwarded-For' header.
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"`
+
+	Rebalance RebalanceConfig `json:"rebalance" yaml:"rebalance"`
+
+	TLS TLSConfig `json:"tls" yaml:"tls"`
+}
+
+func (c *UpstreamConfig) Validate() error {
+	if c.BindAddr == "" {
+		return fmt.Errorf("missing bind addr")
+	}
+	if c.MaxConnections < 0 {
+		return fmt.Errorf("max connections cannot be negative")
+	}
+	if c.MaxRequestsPerConnection < 0 {
+		return fmt.Errorf("max requests per connection cannot be negative")
+	}
+	if c.HeartbeatInterval <= 0 {
+		return fmt.Errorf("missing heartbeat interval")
+	}
+	if c.HeartbeatTimeout <= 0 {
+		return fmt.Errorf("missing heartbeat timeout")
+	}
+	for _, endpoint := range c.ApprovedEndpoints {
+		if endpoint == "" {
+			return fmt.Errorf("approved endpoints: empty endpoint")
+		}
+	}
+	if _, err := ParseCIDRs(c.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted proxies: %w", err)
+	}
+	if err := c.Rebalance.Validate(); err != nil {
+		return fmt.Errorf("rebalance: %w", err)
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	return nil
+}
+
+func (c *UpstreamConfig) RegisterFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&c.BindAddr,
+		"upstream.bind-addr",
+		c.BindAddr,
+		`
+The host/port to listen for incoming upstream connections.
+
+If the host is unspecified it defaults to all listeners, such as
+'--upstream.bind-addr :8001' will listen on '0.0.0.0:8001'`,
+	)
+
+	fs.StringVar(
+		&c.AdvertiseAddr,
+		"upstream.advertise-addr",
+		c.AdvertiseAddr,
+		`
+Address to advertise to other nodes in the cluster.
+
+Such as if the listen address is ':8001', the advertised address may be
+'10.26.104.45:8001' or 'node1.cluster:8001'.
+
+By default, if the bind address includes an IP to bind to that will be used.
+If the bind address does not include an IP (such as ':8000') the nodes
+private IP will be used, such as a bind address of ':8000' may have an
+advertise address of '10.26.104.14:8000'.`,
+	)
+
+	fs.IntVar(
+		&c.MaxConnections,
+		"upstream.max-connections",
+		c.MaxConnections,
+		`
+The maximum number of connected upstreams to the node.
+
+Upstreams exceeding the limit are rejected with a 503 status, so they retry and
+may connect to another node in the cluster.
+
+Set to 0 for no limit.`,
+	)
+
+	fs.IntVar(
+		&c.MaxRequestsPerConnection,
+		"upstream.max-requests-per-connection",
+		c.MaxRequestsPerConnection,
+		`
+The maximum number of concurrent requests to each connected upstream.
+
+Requests to an upstream at the limit are sent to another upstream connected
+for the same endpoint. If all upstreams for the endpoint are at the limit, the
+request waits for capacity up to the proxy timeout, then is rejected with a
+503 status. Note TCP and WebSocket connections aren't limited.
+
+Set to 0 for no limit.`,
+	)
+
+	fs.DurationVar(
+		&c.HeartbeatInterval,
+		"upstream.heartbeat-interval",
+		c.HeartbeatInterval,
+		`
+The interval to send heartbeats to connected upstreams to detect broken
+connections.`,
+	)
+
+	fs.DurationVar(
+		&c.HeartbeatTimeout,
+		"upstream.heartbeat-timeout",
+		c.HeartbeatTimeout,
+		`
+The timeout waiting for a heartbeat response from an upstream (or writing to
+the upstream connection) before closing the connection.
+
+Once closed, the upstream is removed from routing, so a broken upstream
+connection is detected within the heartbeat interval plus the heartbeat
+timeout.`,
+	)
+
+	fs.BoolVar(
+		&c.RequireApproval,
+		"upstream.require-approval",
+		c.RequireApproval,
+		`
+Whether endpoints must be approved before their upstreams receive traffic.
+
+When enabled, upstreams for an endpoint that isn't approved stay connected
+but are held as pending, and only receive traffic once the endpoint is
+approved using the admin API (such as 'piko endpoints approve'). This
+prevents typo-squatting or accidentally exposing endpoints in shared
+clusters.
+
+Approvals using the admin API aren't persisted, so endpoints that should
+always be approved should be added to '--upstream.approved-endpoints'.`,
+	)
+
+	fs.StringSliceVar(
+		&c.ApprovedEndpoints,
+		"upstream.approved-endpoints",
+		c.ApprovedEndpoints,
+		`
+Endpoints that are always approved when '--upstream.require-approval' is
+enabled.
+
+Each endpoint may be a pattern where '*' matches any sequence of characters,
+such as 'team-a-*'.`,
+	)
+
+	fs.StringSliceVar(
+		&c.TrustedProxies,
+		"upstream.trusted-proxies",
+		c.TrustedProxies,
+		`
+IP ranges of proxies, such as load balancers, trusted to set the upstream
+client IP using the 'X-Forwarded-For' or 'X-Real-IP' headers.
+
+The client IP used to ban upstreams is the connection IP, unless the
+connection is from a trusted proxy, in which case it's the last IP in
+'X-Forwarded-For' that isn't a trusted proxy.
+
+By default no proxies are trusted.`,
+	)
+
+	c.Rebalance.RegisterFlags(fs)
+
+	c.TLS.RegisterFlags(fs, "upstream")
+}
+
+type AdminConfig struct {
 	// BindAddr is the address to bind to listen for incoming HTTP connections.
 	BindAddr string `json:"bind_addr" yaml:"bind_addr"`
 
 	// AdvertiseAddr is the address to advertise to other nodes.
 	AdvertiseAddr string `json:"advertise_addr" yaml:"advertise_addr"`
 
-	// Timeout is the timeout to forward incoming requests to the upstream.
-	Timeout time.Duration `json:"timeout" yaml:"timeout"`
-
-	// AccessLog indicates whether to log all incoming connections and
-	// requests.
-	AccessLog bool `json:"access_log" yaml:"access_log"`
+	// AdvertiseInterface is a network interface name or CIDR to select the
+	// advertise IP from.
+	AdvertiseInterface string `json:"advertise_interface" yaml:"advertise_interface"`
 
 	HTTP HTTPConfig `json:"http" yaml:"http"`
 
 	TLS TLSConfig `json:"tls" yaml:"tls"`
+
+	OIDC OIDCConfig `json:"oidc" yaml:"oidc"`
+
+	// AuthLockout configures locking out clients after repeated failed
+	// OIDC authentication attempts.
+	AuthLockout LockoutConfig `json:"auth_lockout" yaml:"auth_lockout"`
 }
 
-func (c *ProxyConfig) Validate() error {
+func (c *AdminConfig) Validate() error {
 	if c.BindAddr == "" {
 		return fmt.Errorf("missing bind addr")
 	}
-	if c.Timeout == 0 {
-		return fmt.Errorf("missing timeout")
+	if path, ok := UnixSocketPath(c.BindAddr); ok && path == "" {
+		return fmt.Errorf("missing bind addr unix socket path")
+	}
+	if err := validateAdvertiseInterface(
+		c.AdvertiseAddr, c.AdvertiseInterface,
+	); err != nil {
+		return err
 	}
 	if err := c.TLS.Validate(); err != nil {
 		return fmt.Errorf("tls: %w", err)
 	}
+	if err := c.OIDC.Validate(); err != nil {
+		return fmt.Errorf("oidc: %w", err)
+	}
+	if err := c.AuthLockout.Validate(); err != nil {
+		return fmt.Errorf("auth lockout: %w", err)
+	}
 	return nil
 }
 
-func (c *ProxyConfig) RegisterFlags(fs *pflag.FlagSet) {
+func (c *AdminConfig) RegisterFlags(fs *pflag.FlagSet) {
 	fs.StringVar(
 		&c.BindAddr,
-		"proxy.bind-addr",
+		"admin.bind-addr",
 		c.BindAddr,
 		`
-The host/port to listen for incoming proxy connections.
+The host/port to listen for incoming admin connections.
 
 If the host is unspecified it defaults to all listeners, such as
-'--proxy.bind-addr :8000' will listen on '0.0.0.0:8000'`,
+'--admin.bind-addr :8002' will listen on '0.0.0.0:8002'
+
+To listen on a Unix domain socket, use a 'unix://' prefix followed by the
+socket path, such as '--admin.bind-addr unix:///run/piko/admin.sock'. Since
+other nodes can't connect to the socket, when running a cluster
+'--admin.advertise-addr' must be an address other nodes can use to reach the
+socket.`,
 	)
 
 	fs.StringVar(
 		&c.AdvertiseAddr,
-		"proxy.advertise-addr",
+		"admin.advertise-addr",
 		c.AdvertiseAddr,
 		`
-Address to advertise to other nodes in the cluster. This is the
-address other nodes will used to forward proxy connections.
+Admin listen address to advertise to other nodes in the cluster. This is the
+address other nodes will used to forward admin requests.
 
-Such as if the listen address is ':8000', the advertised address may be
-'10.26.104.45:8000' or 'node1.cluster:8000'.
+Such as if the listen address is ':8002', the advertised address may be
+'10.26.104.45:8002' or 'node1.cluster:8002'.
 
 By default, if the bind address includes an IP to bind to that will be used.
-If the bind address does not include an IP (such as ':8000') the nodes
-private IP will be used, such as a bind address of ':8000' may have an
-advertise address of '10.26.104.14:8000'.`,
+If the bind address does not include an IP (such as ':8002') the nodes
+private IP will be used, such as a bind address of ':8002' may have an
+advertise address of '10.26.104.14:8002'.`,
 	)
 
-	fs.DurationVar(
-		&c.Timeout,
-		"proxy.timeout",
-		c.Timeout,
+	fs.StringVar(
+		&c.AdvertiseInterface,
+		"admin.advertise-interface",
+		c.AdvertiseInterface,
 		`
-Timeout when forwarding incoming requests to the upstream.`,
+Network interface to select the advertise IP from, when no advertise address
+is configured. The advertised port is the bind port.
+
+This may be either an interface name, such as 'eth1', or a CIDR to select the
+first interface address in the range, such as '10.26.0.0/16'. This is useful
+on hosts with multiple network interfaces, where the default private IP may
+be the wrong interface.
+
+If the interface has both IPv4 and IPv6 addresses, the address is selected
+using '--cluster.advertise-ip-family'.`,
 	)
+	c.HTTP.RegisterFlags(fs, "admin")
 
-	fs.BoolVar(
-		&c.AccessLog,
-		"proxy.access-log",
-		c.AccessLog,
+	c.TLS.RegisterFlags(fs, "admin")
+	fs.StringVar(
+		&c.TLS.RootCAs,
+		"admin.tls.root-cas",
+		c.TLS.RootCAs,
 		`
-Whether to log all incoming connections and requests.`,
+Path to the PEM encoded root CA certificates used to verify the admin
+certificates of other nodes when forwarding admin requests.
+
+If TLS is enabled, admin requests are forwarded to other nodes using HTTPS, so
+all nodes in the cluster must enable admin TLS. By default the system root CAs
+are used.`,
 	)
 
-	c.HTTP.RegisterFlags(fs, "proxy")
+	c.OIDC.RegisterFlags(fs, "admin")
 
-	c.TLS.RegisterFlags(fs, "proxy")
+	c.AuthLockout.RegisterFlags(fs, "admin")
 }
 
-type UpstreamConfig struct {
-	// BindAddr is the address to bind to listen for incoming HTTP connections.
+// RPCConfig configures the internal RPC server used to forward requests
+// between nodes.
+type RPCConfig struct {
+	// BindAddr is the address to bind to listen for incoming RPC
+	// connections. If empty the RPC server is disabled.
 	BindAddr string `json:"bind_addr" yaml:"bind_addr"`
 
 	// AdvertiseAddr is the address to advertise to other nodes.
 	AdvertiseAddr string `json:"advertise_addr" yaml:"advertise_addr"`
-
-	TLS TLSConfig `json:"tls" yaml:"tls"`
 }
 
-func (c *UpstreamConfig) Validate() error {
-	if c.BindAddr == "" {
-		return fmt.Errorf("missing bind addr")
-	}
-	if err := c.TLS.Validate(); err != nil {
-		return fmt.Errorf("tls: %w", err)
-	}
-	return nil
+func (c *RPCConfig) Enabled() bool {
+	return c.BindAddr != ""
 }
 
-func (c *UpstreamConfig) RegisterFlags(fs *pflag.FlagSet) {
+func (c *RPCConfig) RegisterFlags(fs *pflag.FlagSet) {
 	fs.StringVar(
 		&c.BindAddr,
-		"upstream.bind-addr",
+		"rpc.bind-addr",
 		c.BindAddr,
 		`
-The host/port to listen for incoming upstream connections.
+The host/port to listen for incoming gRPC connections from other nodes in the
+cluster, such as ':8004'.
 
-If the host is unspecified it defaults to all listeners, such as
-'--upstream.bind-addr :8001' will listen on '0.0.0.0:8001'`,
+Other nodes forward requests to this port when using
+'--proxy.forward.protocol grpc'.
+
+Only other nodes may send requests, so nodes must authenticate using either
+SPIFFE mutual TLS (see '--spiffe.enabled') or the gossip join token (see
+'--gossip.join-token').
+
+If empty the RPC server is disabled.`,
 	)
 
 	fs.StringVar(
 		&c.AdvertiseAddr,
-		"upstream.advertise-addr",
+		"rpc.advertise-addr",
 		c.AdvertiseAddr,
 		`
-Address to advertise to other nodes in the cluster.
+RPC listen address to advertise to other nodes in the cluster. This is the
+address other nodes will used to forward requests.
 
-Such as if the listen address is ':8001', the advertised address may be
-'10.26.104.45:8001' or 'node1.cluster:8001'.
+Such as if the listen address is ':8004', the advertised address may be
+'10.26.104.45:8004' or 'node1.cluster:8004'.
 
 By default, if the bind address includes an IP to bind to that will be used.
-If the bind address does not include an IP (such as ':8000') the nodes
-private IP will be used, such as a bind address of ':8000' may have an
-advertise address of '10.26.104.14:8000'.`,
+If the bind address does not include an IP (such as ':8004') the nodes
+private IP will be used, such as a bind address of ':8004' may have an
+advertise address of '10.26.104.14:8004'.`,
 	)
-
-	c.TLS.RegisterFlags(fs, "upstream")
 }
 
-type AdminConfig struct {
-	// BindAddr is the address to bind to listen for incoming HTTP connections.
-	BindAddr string `json:"bind_addr" yaml:"bind_addr"`
+// FederationConfig configures federation with other independent Piko
+// clusters.
+type FederationConfig struct {
+	// Token is a shared token used to authenticate clusters requesting the
+	// endpoints available in this cluster. If empty federation is disabled.
+	Token string `json:"token" yaml:"token"`
 
-	// AdvertiseAddr is the address to advertise to other nodes.
-	AdvertiseAddr string `json:"advertise_addr" yaml:"advertise_addr"`
+	// TokenFile is the path of a file containing the token.
+	TokenFile string `json:"token_file" yaml:"token_file"`
 
-	TLS TLSConfig `json:"tls" yaml:"tls"`
+	// Clusters maps the names of remote clusters to federate with to the
+	// URL of the clusters proxy port.
+	Clusters map[string]string `json:"clusters" yaml:"clusters"`
+
+	// SyncInterval is the interval to fetch the endpoints available in each
+	// remote cluster.
+	SyncInterval time.Duration `json:"sync_interval" yaml:"sync_interval"`
 }
 
-func (c *AdminConfig) Validate() error {
-	if c.BindAddr == "" {
-		return fmt.Errorf("missing bind addr")
+func (c *FederationConfig) Enabled() bool {
+	return c.Token != ""
+}
+
+func (c *FederationConfig) Validate() error {
+	if len(c.Clusters) > 0 && c.Token == "" {
+		return fmt.Errorf("missing token")
 	}
-	if err := c.TLS.Validate(); err != nil {
-		return fmt.Errorf("tls: %w", err)
+	for name, addr := range c.Clusters {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return fmt.Errorf("cluster %s: invalid url: %w", name, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("cluster %s: unsupported url scheme: %s", name, u.Scheme)
+		}
+	}
+	if c.Enabled() && c.SyncInterval <= 0 {
+		return fmt.Errorf("missing sync interval")
 	}
 	return nil
 }
 
-func (c *AdminConfig) RegisterFlags(fs *pflag.FlagSet) {
+// LoadSecrets loads the token from a file if configured.
+func (c *FederationConfig) LoadSecrets() error {
+	if err := pikoconfig.LoadSecretFile(&c.Token, c.TokenFile); err != nil {
+		return fmt.Errorf("token: %w", err)
+	}
+	return nil
+}
+
+func (c *FederationConfig) RegisterFlags(fs *pflag.FlagSet) {
 	fs.StringVar(
-		&c.BindAddr,
-		"admin.bind-addr",
-		c.BindAddr,
+		&c.Token,
+		"federation.token",
+		c.Token,
 		`
-The host/port to listen for incoming admin connections.
+A shared token used to authenticate federated clusters.
 
-If the host is unspecified it defaults to all listeners, such as
-'--admin.bind-addr :8002' will listen on '0.0.0.0:8002'`,
+When set, remote clusters configured with the same token can fetch the
+endpoints available in this cluster, and forward requests for those endpoints
+to this cluster.
+
+If empty federation is disabled.`,
+	)
+	fs.StringVar(
+		&c.TokenFile,
+		"federation.token-file",
+		c.TokenFile,
+		`
+Path of a file containing the shared token used to authenticate federated
+clusters, so the token isn't passed as a flag or environment variable.`,
+	)
+
+	fs.StringToStringVar(
+		&c.Clusters,
+		"federation.clusters",
+		c.Clusters,
+		`
+The remote clusters to federate with, mapping the cluster name to the URL of
+the clusters proxy port, such as
+'--federation.clusters eu-west=https://piko.eu-west.example.com:8000'.
+
+Each node periodically fetches the endpoints available in each remote
+cluster. When a request is received for an endpoint with no upstreams in the
+local cluster, but with upstreams in a remote cluster, the request is
+forwarded to the remote cluster.
+
+Requires '--federation.token'.`,
+	)
+
+	fs.DurationVar(
+		&c.SyncInterval,
+		"federation.sync-interval",
+		c.SyncInterval,
+		`
+The interval to fetch the endpoints available in each remote cluster.`,
 	)
+}
+
+type MetricsConfig struct {
+	// StatsDAddr is the address of a StatsD server to push metrics to. If
+	// empty metrics are not pushed.
+	StatsDAddr string `json:"statsd_addr" yaml:"statsd_addr"`
+
+	// StatsDInterval is the interval to push metrics to the StatsD server.
+	StatsDInterval time.Duration `json:"statsd_interval" yaml:"statsd_interval"`
+
+	// StatsDTags are tags to add to every metric pushed to the StatsD
+	// server, such as 'env:prod'.
+	StatsDTags []string `json:"statsd_tags" yaml:"statsd_tags"`
+}
+
+func (c *MetricsConfig) StatsDEnabled() bool {
+	return c.StatsDAddr != ""
+}
 
+func (c *MetricsConfig) Validate() error {
+	if c.StatsDEnabled() && c.StatsDInterval <= 0 {
+		return fmt.Errorf("missing statsd interval")
+	}
+	return nil
+}
+
+func (c *MetricsConfig) RegisterFlags(fs *pflag.FlagSet) {
 	fs.StringVar(
-		&c.AdvertiseAddr,
-		"admin.advertise-addr",
-		c.AdvertiseAddr,
+		&c.StatsDAddr,
+		"metrics.statsd-addr",
+		c.StatsDAddr,
 		`
-Admin listen address to advertise to other nodes in the cluster. This is the
-address other nodes will used to forward admin requests.
+Address of a StatsD or DogStatsD server to push metrics to, such as
+'localhost:8125'.
 
-Such as if the listen address is ':8002', the advertised address may be
-'10.26.104.45:8002' or 'node1.cluster:8002'.
+Metrics are always available to scrape from the admin server at '/metrics'
+in the Prometheus format. When configured, the same metrics are also pushed
+to the StatsD server over UDP, with metric labels sent as DogStatsD tags.
 
-By default, if the bind address includes an IP to bind to that will be used.
-If the bind address does not include an IP (such as ':8002') the nodes
-private IP will be used, such as a bind address of ':8002' may have an
-advertise address of '10.26.104.14:8002'.`,
+If not set metrics are not pushed.`,
+	)
+	fs.DurationVar(
+		&c.StatsDInterval,
+		"metrics.statsd-interval",
+		c.StatsDInterval,
+		`
+Interval to push metrics to the StatsD server.`,
+	)
+	fs.StringSliceVar(
+		&c.StatsDTags,
+		"metrics.statsd-tags",
+		c.StatsDTags,
+		`
+A list of tags to add to every metric pushed to the StatsD server, such as
+'--metrics.statsd-tags env:prod,region:eu'.`,
 	)
-	c.TLS.RegisterFlags(fs, "admin")
 }
 
 type UsageConfig struct {
@@ -362,6 +1715,96 @@ architecture, requests processed and upstreams registered.`,
 	)
 }
 
+// EncryptionConfig configures encrypting state persisted to disk.
+type EncryptionConfig struct {
+	// KeyFile is the path of a file containing the hex encoded 32 byte key to
+	// encrypt persisted state. If empty, state isn't encrypted.
+	KeyFile string `json:"key_file" yaml:"key_file"`
+}
+
+func (c *EncryptionConfig) Enabled() bool {
+	return c.KeyFile != ""
+}
+
+// Validate verifies the key file contains a valid key.
+func (c *EncryptionConfig) Validate() error {
+	if _, err := c.LoadKey(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LoadKey loads the encryption key from the key file, or returns nil if
+// encryption isn't enabled.
+func (c *EncryptionConfig) LoadKey() ([]byte, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	return encryption.LoadKeyFile(c.KeyFile)
+}
+
+func (c *EncryptionConfig) RegisterFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&c.KeyFile,
+		"encryption.key-file",
+		c.KeyFile,
+		`
+Path of a file containing a hex encoded 32 byte key to encrypt state persisted
+to disk (currently the node ID file configured by '--cluster.node-id-file'),
+such as generated with 'openssl rand -hex 32'.
+
+State is encrypted with AES-256-GCM. Existing plaintext state is encrypted
+when loaded.`,
+	)
+}
+
+// TenantConfig configures the quotas of the tenants matching the tenant
+// pattern.
+//
+// Quotas are enforced by each node, so limit the usage of a tenant on a
+// single node rather than across the cluster.
+type TenantConfig struct {
+	// Tenant is the tenant name, which may be a pattern containing '*'
+	// wildcards, such as 'team-*'.
+	Tenant string `json:"tenant" yaml:"tenant"`
+
+	// MaxEndpoints is the maximum number of endpoints the tenant may
+	// register. A limit of 0 means there is no limit.
+	MaxEndpoints int `json:"max_endpoints" yaml:"max_endpoints"`
+
+	// MaxUpstreams is the maximum number of concurrently connected upstreams
+	// for the tenant. A limit of 0 means there is no limit.
+	MaxUpstreams int `json:"max_upstreams" yaml:"max_upstreams"`
+
+	// MaxRequestsPerSecond is the maximum rate of proxied requests to the
+	// tenant's endpoints. A limit of 0 means there is no limit.
+	MaxRequestsPerSecond int `json:"max_requests_per_second" yaml:"max_requests_per_second"`
+
+	// MaxBytesPerSecond is the maximum rate of request and response body
+	// bytes proxied to the tenant's endpoints. A limit of 0 means there is
+	// no limit.
+	MaxBytesPerSecond int64 `json:"max_bytes_per_second" yaml:"max_bytes_per_second"`
+}
+
+func (c *TenantConfig) Validate() error {
+	if c.Tenant == "" {
+		return fmt.Errorf("missing tenant")
+	}
+	if c.MaxEndpoints < 0 {
+		return fmt.Errorf("max endpoints cannot be negative")
+	}
+	if c.MaxUpstreams < 0 {
+		return fmt.Errorf("max upstreams cannot be negative")
+	}
+	if c.MaxRequestsPerSecond < 0 {
+		return fmt.Errorf("max requests per second cannot be negative")
+	}
+	if c.MaxBytesPerSecond < 0 {
+		return fmt.Errorf("max bytes per second cannot be negative")
+	}
+	return nil
+}
+
 type Config struct {
 	Cluster ClusterConfig `json:"cluster" yaml:"cluster"`
 
@@ -371,29 +1814,77 @@ type Config struct {
 
 	Admin AdminConfig `json:"admin" yaml:"admin"`
 
+	RPC RPCConfig `json:"rpc" yaml:"rpc"`
+
+	Federation FederationConfig `json:"federation" yaml:"federation"`
+
 	Gossip gossip.Config `json:"gossip" yaml:"gossip"`
 
 	Auth auth.Config `json:"auth" yaml:"auth"`
 
 	Usage UsageConfig `json:"usage" yaml:"usage"`
 
+	Encryption EncryptionConfig `json:"encryption" yaml:"encryption"`
+
+	// SPIFFE configures using a SPIFFE X.509 SVID for mutual TLS between
+	// agents and the upstream listener, and between nodes using RPC.
+	SPIFFE spiffe.Config `json:"spiffe" yaml:"spiffe"`
+
+	// Tenants configures the quotas of each tenant. If a tenant matches
+	// multiple entries, the first is used.
+	//
+	// Only configurable using YAML.
+	Tenants []TenantConfig `json:"tenants" yaml:"tenants"`
+
+	Metrics MetricsConfig `json:"metrics" yaml:"metrics"`
+
 	Log log.Config `json:"log" yaml:"log"`
 
+	Telemetry telemetry.Config `json:"telemetry" yaml:"telemetry"`
+
+	ErrorReporting errorreport.Config `json:"error_reporting" yaml:"error_reporting"`
+
 	// GracePeriod is the duration to gracefully shutdown the server. During
 	// the grace period, listeners and idle connections are closed, then waits
 	// for active requests to complete and closes their connections.
 	GracePeriod time.Duration `json:"grace_period" yaml:"grace_period"`
 }
 
+// validateAdvertiseInterface validates an advertise interface, which is either
+// an interface name or CIDR.
+func validateAdvertiseInterface(advertiseAddr, advertiseInterface string) error {
+	if advertiseInterface == "" {
+		return nil
+	}
+	if advertiseAddr != "" {
+		return fmt.Errorf("cannot set both advertise addr and advertise interface")
+	}
+	if strings.Contains(advertiseInterface, "/") {
+		if _, _, err := net.ParseCIDR(advertiseInterface); err != nil {
+			return fmt.Errorf("invalid advertise interface cidr: %w", err)
+		}
+	}
+	return nil
+}
+
+// UnixSocketPath returns the socket path if the given bind address is a Unix
+// domain socket, such as 'unix:///run/piko/proxy.sock'.
+func UnixSocketPath(bindAddr string) (string, bool) {
+	return strings.CutPrefix(bindAddr, "unix://")
+}
+
 func Default() *Config {
 	return &Config{
 		Cluster: ClusterConfig{
-			AbortIfJoinFails: true,
+			AbortIfJoinFails:  true,
+			Role:              "full",
+			AdvertiseIPFamily: "auto",
 		},
 		Proxy: ProxyConfig{
-			BindAddr:  ":8000",
-			Timeout:   time.Second * 30,
-			AccessLog: true,
+			BindAddr:           ":8000",
+			Timeout:            time.Second * 30,
+			AccessLog:          true,
+			MaxEndpointMetrics: 100,
 			HTTP: HTTPConfig{
 				ReadTimeout:       time.Second * 10,
 				ReadHeaderTimeout: time.Second * 10,
@@ -401,20 +1892,74 @@ func Default() *Config {
 				IdleTimeout:       time.Minute * 5,
 				MaxHeaderBytes:    1 << 20,
 			},
+			Forward: ForwardConfig{
+				Protocol:     "http",
+				MaxIdleConns: 100,
+				IdleTimeout:  time.Second * 90,
+				Compression:  "off",
+			},
+			AuthLockout: LockoutConfig{
+				Window:   time.Minute,
+				Duration: time.Minute * 5,
+			},
 		},
 		Upstream: UpstreamConfig{
-			BindAddr: ":8001",
+			BindAddr:          ":8001",
+			HeartbeatInterval: time.Second * 10,
+			HeartbeatTimeout:  time.Second * 10,
+			Rebalance: RebalanceConfig{
+				Threshold: 0.2,
+				ShedRate:  0.1,
+				MinConns:  10,
+			},
 		},
 		Admin: AdminConfig{
 			BindAddr: ":8002",
+			// The admin server streams events and status updates, so
+			// doesn't have a read or write timeout by default.
+			HTTP: HTTPConfig{
+				ReadHeaderTimeout: time.Second * 10,
+				IdleTimeout:       time.Minute * 5,
+				MaxHeaderBytes:    1 << 20,
+			},
+			OIDC: OIDCConfig{
+				Scopes:          []string{"openid", "profile", "email"},
+				GroupsClaim:     "groups",
+				SessionDuration: time.Hour * 12,
+			},
+			AuthLockout: LockoutConfig{
+				Window:   time.Minute,
+				Duration: time.Minute * 5,
+			},
+		},
+		Federation: FederationConfig{
+			SyncInterval: time.Second * 10,
 		},
 		Gossip: gossip.Config{
-			BindAddr:      ":8003",
-			Interval:      time.Millisecond * 500,
-			MaxPacketSize: 1400,
+			BindAddr:           ":8003",
+			Interval:           time.Millisecond * 500,
+			Fanout:             1,
+			MaxPacketSize:      1400,
+			LivenessInterval:   time.Millisecond * 500,
+			SuspicionThreshold: 20,
+			NodeExpiry:         time.Minute,
+			FullSyncInterval:   time.Second * 30,
+			FullSyncFanout:     1,
+		},
+		Metrics: MetricsConfig{
+			StatsDInterval: time.Second * 10,
 		},
 		Log: log.Config{
-			Level: "info",
+			Level:  "info",
+			Format: "json",
+			File: log.FileConfig{
+				MaxSize:        100,
+				RotateInterval: time.Hour * 24,
+				MaxBackups:     5,
+			},
+		},
+		Telemetry: telemetry.Config{
+			SampleRate: 1,
 		},
 		GracePeriod: time.Minute,
 	}
@@ -441,10 +1986,52 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("gossip: %w", err)
 	}
 
+	if err := c.Federation.Validate(); err != nil {
+		return fmt.Errorf("federation: %w", err)
+	}
+
+	if err := c.Encryption.Validate(); err != nil {
+		return fmt.Errorf("encryption: %w", err)
+	}
+
+	if err := c.SPIFFE.Validate(); err != nil {
+		return fmt.Errorf("spiffe: %w", err)
+	}
+	if c.SPIFFE.Enabled() && c.Upstream.TLS.Enabled {
+		return fmt.Errorf("spiffe: cannot enable both spiffe and upstream tls")
+	}
+
+	// Only other nodes may send RPC requests, so nodes must be able to
+	// authenticate each other.
+	if c.RPC.Enabled() && !c.SPIFFE.Enabled() && c.Gossip.JoinToken == "" {
+		return fmt.Errorf("rpc: requires either spiffe or a gossip join token to authenticate nodes")
+	}
+
+	for _, t := range c.Tenants {
+		if err := t.Validate(); err != nil {
+			if t.Tenant != "" {
+				return fmt.Errorf("tenant: %s: %w", t.Tenant, err)
+			}
+			return fmt.Errorf("tenant: %w", err)
+		}
+	}
+
+	if err := c.Metrics.Validate(); err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+
 	if err := c.Log.Validate(); err != nil {
 		return fmt.Errorf("log: %w", err)
 	}
 
+	if err := c.Telemetry.Validate(); err != nil {
+		return fmt.Errorf("telemetry: %w", err)
+	}
+
+	if err := c.ErrorReporting.Validate(); err != nil {
+		return fmt.Errorf("error reporting: %w", err)
+	}
+
 	if c.GracePeriod == 0 {
 		return fmt.Errorf("missing grace period")
 	}
@@ -452,6 +2039,55 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// LoadSecrets loads any secrets configured from files. This must be called
+// before Validate.
+func (c *Config) LoadSecrets() error {
+	if err := c.Auth.LoadSecrets(); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	if err := c.Proxy.Auth.LoadSecrets(); err != nil {
+		return fmt.Errorf("proxy: auth: %w", err)
+	}
+	if err := c.Admin.OIDC.LoadSecrets(); err != nil {
+		return fmt.Errorf("admin: oidc: %w", err)
+	}
+	for i := range c.Proxy.Endpoints {
+		e := &c.Proxy.Endpoints[i]
+		if err := e.Webhook.LoadSecrets(); err != nil {
+			return fmt.Errorf("proxy: endpoint: %s: webhook: %w", e.Endpoint, err)
+		}
+	}
+	if err := c.Federation.LoadSecrets(); err != nil {
+		return fmt.Errorf("federation: %w", err)
+	}
+	if err := c.Gossip.LoadSecrets(); err != nil {
+		return fmt.Errorf("gossip: %w", err)
+	}
+	if err := c.ErrorReporting.LoadSecrets(); err != nil {
+		return fmt.Errorf("error reporting: %w", err)
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with secrets redacted, such
+// as to log or expose the configuration.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redact(&redacted.Auth.TokenHMACSecretKey)
+	redact(&redacted.Proxy.Auth.TokenHMACSecretKey)
+	redact(&redacted.Admin.OIDC.ClientSecret)
+	redact(&redacted.Admin.OIDC.SessionKey)
+	// Copy the endpoints to avoid modifying the original config.
+	redacted.Proxy.Endpoints = slices.Clone(c.Proxy.Endpoints)
+	for i := range redacted.Proxy.Endpoints {
+		redact(&redacted.Proxy.Endpoints[i].Webhook.Secret)
+	}
+	redact(&redacted.Federation.Token)
+	redact(&redacted.Gossip.JoinToken)
+	redact(&redacted.ErrorReporting.DSN)
+	return &redacted
+}
+
 func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
 	c.Cluster.RegisterFlags(fs)
 
@@ -461,14 +2097,28 @@ func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
 
 	c.Admin.RegisterFlags(fs)
 
+	c.RPC.RegisterFlags(fs)
+
+	c.Federation.RegisterFlags(fs)
+
 	c.Gossip.RegisterFlags(fs)
 
 	c.Auth.RegisterFlags(fs)
 
 	c.Usage.RegisterFlags(fs)
 
+	c.Encryption.RegisterFlags(fs)
+
+	c.SPIFFE.RegisterFlags(fs, "")
+
+	c.Metrics.RegisterFlags(fs)
+
 	c.Log.RegisterFlags(fs)
 
+	c.Telemetry.RegisterFlags(fs)
+
+	c.ErrorReporting.RegisterFlags(fs)
+
 	fs.DurationVar(
 		&c.GracePeriod,
 		"grace-period",
@@ -481,3 +2131,11 @@ connections to upstream listeners and announcing to the cluster the node is
 leaving.`,
 	)
 }
+
+// redact replaces the given value with a placeholder if set, so it's clear
+// whether the value was configured.
+func redact(v *string) {
+	if *v != "" {
+		*v = "REDACTED"
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// LockoutConfig configures locking out clients after repeated failed
+// authentication attempts.
+type LockoutConfig struct {
+	// MaxFailures is the number of failed authentication attempts from a
+	// client IP within the window before the client is locked out. If 0,
+	// clients are never locked out.
+	MaxFailures int `json:"max_failures" yaml:"max_failures"`
+
+	// Window is the duration failed attempts are counted over.
+	Window time.Duration `json:"window" yaml:"window"`
+
+	// Duration is how long a client is locked out for.
+	Duration time.Duration `json:"duration" yaml:"duration"`
+}
+
+func (c *LockoutConfig) Enabled() bool {
+	return c.MaxFailures != 0
+}
+
+func (c *LockoutConfig) Validate() error {
+	if c.MaxFailures < 0 {
+		return fmt.Errorf("max failures cannot be negative")
+	}
+	if !c.Enabled() {
+		return nil
+	}
+	if c.Window <= 0 {
+		return fmt.Errorf("missing window")
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("missing duration")
+	}
+	return nil
+}
+
+func (c *LockoutConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += ".auth-lockout."
+
+	fs.IntVar(
+		&c.MaxFailures,
+		prefix+"max-failures",
+		c.MaxFailures,
+		`
+The number of failed authentication attempts from a client IP within the
+window before the client is locked out.
+
+Requests from a locked out client are rejected with a 429 status without
+attempting authentication, to slow down guessing credentials.
+
+Set to 0 to never lock out clients.`,
+	)
+	fs.DurationVar(
+		&c.Window,
+		prefix+"window",
+		c.Window,
+		`
+The duration failed authentication attempts are counted over.`,
+	)
+	fs.DurationVar(
+		&c.Duration,
+		prefix+"duration",
+		c.Duration,
+		`
+How long a client is locked out for after exceeding the maximum failed
+authentication attempts.`,
+	)
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	pikoconfig "github.com/andydunstall/piko/pkg/config"
+	"github.com/spf13/pflag"
+)
+
+// OIDCConfig configures authenticating admin users with an OpenID Connect
+// provider.
+type OIDCConfig struct {
+	// IssuerURL is the URL of the OpenID provider. If empty, OIDC is
+	// disabled.
+	IssuerURL string `json:"issuer_url" yaml:"issuer_url"`
+
+	ClientID string `json:"client_id" yaml:"client_id"`
+
+	ClientSecret string `json:"client_secret" yaml:"client_secret"`
+
+	// ClientSecretFile is the path of a file containing the client secret.
+	ClientSecretFile string `json:"client_secret_file" yaml:"client_secret_file"`
+
+	// RedirectURL is the admin server URL the provider redirects to after
+	// login, which must have path '/oidc/callback'.
+	RedirectURL string `json:"redirect_url" yaml:"redirect_url"`
+
+	// Scopes are the scopes to request from the provider.
+	Scopes []string `json:"scopes" yaml:"scopes"`
+
+	// GroupsClaim is the ID token claim containing the user's groups.
+	GroupsClaim string `json:"groups_claim" yaml:"groups_claim"`
+
+	// AdminGroups are the groups permitted to make any admin request.
+	AdminGroups []string `json:"admin_groups" yaml:"admin_groups"`
+
+	// ReadOnlyGroups are the groups permitted to make admin requests that
+	// don't modify the node, such as inspecting the status.
+	ReadOnlyGroups []string `json:"read_only_groups" yaml:"read_only_groups"`
+
+	// SessionKey is the secret key to sign session cookies. All nodes in
+	// the cluster must use the same key.
+	SessionKey string `json:"session_key" yaml:"session_key"`
+
+	// SessionKeyFile is the path of a file containing the session key.
+	SessionKeyFile string `json:"session_key_file" yaml:"session_key_file"`
+
+	// SessionDuration is how long a user stays logged in.
+	SessionDuration time.Duration `json:"session_duration" yaml:"session_duration"`
+}
+
+func (c *OIDCConfig) Enabled() bool {
+	return c.IssuerURL != ""
+}
+
+func (c *OIDCConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	if _, err := url.Parse(c.IssuerURL); err != nil {
+		return fmt.Errorf("invalid issuer url: %w", err)
+	}
+	if c.ClientID == "" {
+		return fmt.Errorf("missing client id")
+	}
+	if c.RedirectURL == "" {
+		return fmt.Errorf("missing redirect url")
+	}
+	redirectURL, err := url.Parse(c.RedirectURL)
+	if err != nil {
+		return fmt.Errorf("invalid redirect url: %w", err)
+	}
+	if redirectURL.Path != "/oidc/callback" {
+		return fmt.Errorf("redirect url path must be '/oidc/callback'")
+	}
+	if !slices.Contains(c.Scopes, "openid") {
+		return fmt.Errorf("scopes must include 'openid'")
+	}
+	if c.GroupsClaim == "" {
+		return fmt.Errorf("missing groups claim")
+	}
+	if len(c.SessionKey) < 32 {
+		return fmt.Errorf("session key must be at least 32 bytes")
+	}
+	if c.SessionDuration <= 0 {
+		return fmt.Errorf("missing session duration")
+	}
+	return nil
+}
+
+// LoadSecrets loads the client secret and session key from files if
+// configured.
+func (c *OIDCConfig) LoadSecrets() error {
+	if err := pikoconfig.LoadSecretFile(
+		&c.ClientSecret, c.ClientSecretFile,
+	); err != nil {
+		return fmt.Errorf("client secret: %w", err)
+	}
+	if err := pikoconfig.LoadSecretFile(
+		&c.SessionKey, c.SessionKeyFile,
+	); err != nil {
+		return fmt.Errorf("session key: %w", err)
+	}
+	return nil
+}
+
+func (c *OIDCConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += ".oidc."
+
+	fs.StringVar(
+		&c.IssuerURL,
+		prefix+"issuer-url",
+		c.IssuerURL,
+		`
+URL of the OpenID Connect provider to authenticate admin users, such as
+'https://accounts.google.com'.
+
+If configured, all admin requests (except '/health', '/ready' and
+'/metrics') must be authenticated, either using a session cookie after
+logging in at '/oidc/login', or by passing an ID token issued by the provider
+in the 'Authorization' header.`,
+	)
+	fs.StringVar(
+		&c.ClientID,
+		prefix+"client-id",
+		c.ClientID,
+		`
+OIDC client ID registered with the provider.`,
+	)
+	fs.StringVar(
+		&c.ClientSecret,
+		prefix+"client-secret",
+		c.ClientSecret,
+		`
+OIDC client secret registered with the provider.`,
+	)
+	fs.StringVar(
+		&c.ClientSecretFile,
+		prefix+"client-secret-file",
+		c.ClientSecretFile,
+		`
+Path of a file containing the OIDC client secret, so the secret isn't passed
+as a flag or environment variable.`,
+	)
+	fs.StringVar(
+		&c.RedirectURL,
+		prefix+"redirect-url",
+		c.RedirectURL,
+		`
+URL the provider redirects to after login. This must be the admin server URL
+with path '/oidc/callback', such as
+'https://piko-admin.example.com/oidc/callback'.`,
+	)
+	fs.StringSliceVar(
+		&c.Scopes,
+		prefix+"scopes",
+		c.Scopes,
+		`
+Scopes to request from the provider. Must include 'openid', and may need to
+include a scope to include the groups claim in the ID token, depending on the
+provider.`,
+	)
+	fs.StringVar(
+		&c.GroupsClaim,
+		prefix+"groups-claim",
+		c.GroupsClaim,
+		`
+ID token claim containing the groups the user belongs to.`,
+	)
+	fs.StringSliceVar(
+		&c.AdminGroups,
+		prefix+"admin-groups",
+		c.AdminGroups,
+		`
+Groups permitted to make any admin request, including requests that modify
+the node such as draining the node or disconnecting upstreams.
+
+If neither admin nor read only groups are configured, all authenticated users
+are permitted to make any request.`,
+	)
+	fs.StringSliceVar(
+		&c.ReadOnlyGroups,
+		prefix+"read-only-groups",
+		c.ReadOnlyGroups,
+		`
+Groups permitted to make admin requests that don't modify the node, such as
+viewing the dashboard and inspecting the node status.`,
+	)
+	fs.StringVar(
+		&c.SessionKey,
+		prefix+"session-key",
+		c.SessionKey,
+		`
+Secret key to sign session cookies, which must be at least 32 bytes.
+
+Since admin requests may be forwarded between nodes, all nodes in the cluster
+must use the same key.`,
+	)
+	fs.StringVar(
+		&c.SessionKeyFile,
+		prefix+"session-key-file",
+		c.SessionKeyFile,
+		`
+Path of a file containing the session key, so the key isn't passed as a flag
+or environment variable.`,
+	)
+	fs.DurationVar(
+		&c.SessionDuration,
+		prefix+"session-duration",
+		c.SessionDuration,
+		`
+How long users stay logged in before needing to log in again.`,
+	)
+}
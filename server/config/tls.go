@@ -2,7 +2,13 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 )
@@ -11,6 +17,27 @@ type TLSConfig struct {
 	Enabled bool   `json:"enabled" yaml:"enabled"`
 	Cert    string `json:"cert" yaml:"cert"`
 	Key     string `json:"key" yaml:"key"`
+
+	// WatchInterval is the interval to check whether the cert or key files
+	// have changed, and if so reload the certificate. If zero the files
+	// aren't watched.
+	WatchInterval time.Duration `json:"watch_interval" yaml:"watch_interval"`
+
+	// RootCAs is a path to the PEM encoded root CA certificates used to
+	// verify the certificates of other nodes when connecting to their
+	// listener. If empty the system root CAs are used.
+	//
+	// Only used by listeners that other nodes connect to.
+	RootCAs string `json:"root_cas,omitempty" yaml:"root_cas,omitempty"`
+
+	// MinVersion is the minimum TLS version to accept, either '1.0', '1.1',
+	// '1.2' or '1.3'. If empty defaults to '1.2'.
+	MinVersion string `json:"min_version" yaml:"min_version"`
+
+	// CipherSuites are the names of the cipher suites to accept for TLS 1.2
+	// and earlier, such as 'TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256'. If empty
+	// the Go defaults are used.
+	CipherSuites []string `json:"cipher_suites" yaml:"cipher_suites"`
 }
 
 func (c *TLSConfig) Validate() error {
@@ -24,6 +51,20 @@ func (c *TLSConfig) Validate() error {
 	if c.Key == "" {
 		return fmt.Errorf("missing key")
 	}
+	if c.WatchInterval < 0 {
+		return fmt.Errorf("watch interval cannot be negative")
+	}
+	return c.validateVersion()
+}
+
+// validateVersion validates the minimum version and cipher suites.
+func (c *TLSConfig) validateVersion() error {
+	if _, err := parseTLSVersion(c.MinVersion); err != nil {
+		return err
+	}
+	if _, err := parseCipherSuites(c.CipherSuites); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -53,6 +94,42 @@ Path to the PEM encoded certificate file.`,
 		`
 Path to the PEM encoded key file.`,
 	)
+	fs.DurationVar(
+		&c.WatchInterval,
+		prefix+"watch-interval",
+		c.WatchInterval,
+		`
+Interval to check whether the cert or key files have changed, and if so
+reload the certificate without restarting the node or dropping connections.
+
+This supports rotating certificates by replacing the files, such as when
+using cert-manager. Note the certificate is also reloaded when the node
+configuration is reloaded.
+
+If zero, the files aren't watched.`,
+	)
+	fs.StringVar(
+		&c.MinVersion,
+		prefix+"min-version",
+		c.MinVersion,
+		`
+Minimum TLS version to accept, either '1.0', '1.1', '1.2' or '1.3'.
+
+If empty, defaults to '1.2'.`,
+	)
+	fs.StringSliceVar(
+		&c.CipherSuites,
+		prefix+"cipher-suites",
+		c.CipherSuites,
+		`
+Cipher suites to accept for TLS 1.2 and earlier, such as
+'TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256'.
+
+TLS 1.3 cipher suites aren't configurable, as all TLS 1.3 cipher suites are
+considered secure.
+
+If empty, the Go default cipher suites are used.`,
+	)
 }
 
 func (c *TLSConfig) Load() (*tls.Config, error) {
@@ -61,6 +138,9 @@ func (c *TLSConfig) Load() (*tls.Config, error) {
 	}
 
 	tlsConfig := &tls.Config{}
+	if err := c.Configure(tlsConfig); err != nil {
+		return nil, err
+	}
 	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
 	if err != nil {
 		return nil, fmt.Errorf("load key pair: %w", err)
@@ -69,3 +149,217 @@ func (c *TLSConfig) Load() (*tls.Config, error) {
 
 	return tlsConfig, nil
 }
+
+// LoadClient loads the TLS configuration used to connect to the listener
+// on other nodes.
+func (c *TLSConfig) LoadClient() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if err := c.Configure(tlsConfig); err != nil {
+		return nil, err
+	}
+	if c.RootCAs != "" {
+		caCert, err := os.ReadFile(c.RootCAs)
+		if err != nil {
+			return nil, fmt.Errorf("open root cas: %s: %w", c.RootCAs, err)
+		}
+		caCertPool := x509.NewCertPool()
+		ok := caCertPool.AppendCertsFromPEM(caCert)
+		if !ok {
+			return nil, fmt.Errorf("parse root cas: %s", c.RootCAs)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	return tlsConfig, nil
+}
+
+// Configure sets the minimum version and cipher suites of the given TLS
+// configuration.
+func (c *TLSConfig) Configure(tlsConfig *tls.Config) error {
+	minVersion, err := parseTLSVersion(c.MinVersion)
+	if err != nil {
+		return err
+	}
+	cipherSuites, err := parseCipherSuites(c.CipherSuites)
+	if err != nil {
+		return err
+	}
+	tlsConfig.MinVersion = minVersion
+	tlsConfig.CipherSuites = cipherSuites
+	return nil
+}
+
+func parseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "":
+		return tls.VersionTLS12, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported min version: %s", s)
+	}
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suites := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		suites[suite.Name] = suite
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		suite, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite: %s", name)
+		}
+		if !slices.ContainsFunc(suite.SupportedVersions, func(v uint16) bool {
+			return v <= tls.VersionTLS12
+		}) {
+			return nil, fmt.Errorf(
+				"cipher suite not configurable: %s: tls 1.3 cipher suites aren't configurable",
+				name,
+			)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// ProxyTLSConfig is the TLS configuration for the proxy listener, which
+// supports obtaining certificates automatically using ACME (such as from
+// Let's Encrypt) rather than configuring a cert and key.
+type ProxyTLSConfig struct {
+	TLSConfig `yaml:",inline"`
+
+	// ACMEDomains contains the domains to obtain certificates for using
+	// ACME. If set, TLS is enabled on the listener and certificates are
+	// obtained and renewed automatically.
+	ACMEDomains []string `json:"acme_domains" yaml:"acme_domains"`
+
+	// ACMEEmail is the contact email for the ACME account, used to notify
+	// about problems with issued certificates.
+	ACMEEmail string `json:"acme_email" yaml:"acme_email"`
+
+	// ACMECacheDir is the directory to cache the ACME account key and
+	// certificates.
+	ACMECacheDir string `json:"acme_cache_dir" yaml:"acme_cache_dir"`
+
+	// ACMEDirectoryURL is the ACME directory URL. Defaults to Let's Encrypt.
+	ACMEDirectoryURL string `json:"acme_directory_url" yaml:"acme_directory_url"`
+
+	// ACMEHTTPBindAddr is the address to bind to to respond to HTTP-01
+	// challenges. If empty only TLS-ALPN-01 challenges are supported.
+	ACMEHTTPBindAddr string `json:"acme_http_bind_addr" yaml:"acme_http_bind_addr"`
+}
+
+// ACMEEnabled returns whether certificates are obtained using ACME.
+func (c *ProxyTLSConfig) ACMEEnabled() bool {
+	return len(c.ACMEDomains) > 0
+}
+
+func (c *ProxyTLSConfig) Validate() error {
+	if !c.ACMEEnabled() {
+		return c.TLSConfig.Validate()
+	}
+
+	if c.Cert != "" || c.Key != "" {
+		return fmt.Errorf("cannot configure both acme domains and a cert and key")
+	}
+	for _, domain := range c.ACMEDomains {
+		if domain == "" {
+			return fmt.Errorf("invalid acme domain: empty domain")
+		}
+		if strings.Contains(domain, "*") {
+			return fmt.Errorf("invalid acme domain: %s: wildcard domains not supported", domain)
+		}
+	}
+	if c.ACMECacheDir == "" {
+		return fmt.Errorf("missing acme cache dir")
+	}
+	if c.ACMEDirectoryURL != "" {
+		if _, err := url.Parse(c.ACMEDirectoryURL); err != nil {
+			return fmt.Errorf("invalid acme directory url: %w", err)
+		}
+	}
+	return c.validateVersion()
+}
+
+func (c *ProxyTLSConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	c.TLSConfig.RegisterFlags(fs, prefix)
+
+	prefix += ".tls."
+
+	fs.StringSliceVar(
+		&c.ACMEDomains,
+		prefix+"acme-domains",
+		c.ACMEDomains,
+		`
+Domains to obtain TLS certificates for using ACME, such as Let's Encrypt.
+
+If set, TLS is enabled on the listener and certificates are obtained and
+renewed automatically, so there's no need to configure a cert and key. Each
+domain must resolve to the node.
+
+ACME supports both TLS-ALPN-01 challenges, which require the listener to be
+reachable on port 443, and HTTP-01 challenges, which require
+'--proxy.tls.acme-http-bind-addr' to be reachable on port 80.
+
+Note certificates are obtained by each node independently, so this is intended
+for single node deployments.`,
+	)
+	fs.StringVar(
+		&c.ACMEEmail,
+		prefix+"acme-email",
+		c.ACMEEmail,
+		`
+Contact email for the ACME account, used to notify about problems with issued
+certificates.`,
+	)
+	fs.StringVar(
+		&c.ACMECacheDir,
+		prefix+"acme-cache-dir",
+		c.ACMECacheDir,
+		`
+Directory to cache the ACME account key and certificates.
+
+Required when using ACME, so certificates are reused across restarts rather
+than being requested again (which may exceed the ACME rate limits).`,
+	)
+	fs.StringVar(
+		&c.ACMEDirectoryURL,
+		prefix+"acme-directory-url",
+		c.ACMEDirectoryURL,
+		`
+ACME directory URL.
+
+Defaults to Let's Encrypt. Such as to use the Let's Encrypt staging
+environment, use 'https://acme-staging-v02.api.letsencrypt.org/directory'.`,
+	)
+	fs.StringVar(
+		&c.ACMEHTTPBindAddr,
+		prefix+"acme-http-bind-addr",
+		c.ACMEHTTPBindAddr,
+		`
+Address to bind to to respond to ACME HTTP-01 challenges, such as ':80'.
+
+Any other HTTP requests to the address are redirected to HTTPS.
+
+If empty, only TLS-ALPN-01 challenges are supported.`,
+	)
+}
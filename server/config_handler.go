@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/andydunstall/piko/server/status"
+	"github.com/gin-gonic/gin"
+)
+
+// configHandler registers the admin route to inspect the node
+// configuration.
+type configHandler struct {
+	server *Server
+}
+
+func newConfigHandler(server *Server) *configHandler {
+	return &configHandler{
+		server: server,
+	}
+}
+
+func (h *configHandler) Register(group *gin.RouterGroup) {
+	group.GET("", h.configRoute)
+}
+
+// configRoute returns the effective configuration of the node, including
+// defaults, the config file and flags, with secrets redacted.
+func (h *configHandler) configRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, h.server.Config().Redacted())
+}
+
+var _ status.Handler = &configHandler{}
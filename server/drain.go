@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/andydunstall/piko/server/status"
+	"github.com/gin-gonic/gin"
+)
+
+// DrainStatus contains the drain status of the node.
+type DrainStatus struct {
+	// Draining indicates whether the node has started draining.
+	Draining bool `json:"draining"`
+
+	// Upstreams is the number of upstreams still connected to the node.
+	Upstreams int `json:"upstreams"`
+
+	// Drained indicates the node has finished draining so is safe to shut
+	// down.
+	Drained bool `json:"drained"`
+}
+
+// drainHandler registers the admin routes to drain the node.
+type drainHandler struct {
+	server *Server
+}
+
+func newDrainHandler(server *Server) *drainHandler {
+	return &drainHandler{
+		server: server,
+	}
+}
+
+func (h *drainHandler) Register(group *gin.RouterGroup) {
+	group.POST("", h.drainRoute)
+	group.GET("", h.statusRoute)
+	group.POST("/shutdown", h.shutdownRoute)
+}
+
+func (h *drainHandler) drainRoute(c *gin.Context) {
+	h.server.Drain()
+	c.JSON(http.StatusAccepted, h.server.DrainStatus())
+}
+
+func (h *drainHandler) statusRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, h.server.DrainStatus())
+}
+
+// shutdownRoute shuts down the node. The node must be drained first to avoid
+// dropping connected upstreams.
+func (h *drainHandler) shutdownRoute(c *gin.Context) {
+	if !h.server.DrainStatus().Drained {
+		c.JSON(http.StatusConflict, gin.H{"error": "node not drained"})
+		return
+	}
+
+	h.server.Shutdown()
+	c.Status(http.StatusAccepted)
+}
+
+var _ status.Handler = &drainHandler{}
@@ -0,0 +1,154 @@
+// Package events streams events from the cluster and local node to the
+// admin server.
+package events
+
+import (
+	"time"
+
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/status"
+	"github.com/andydunstall/piko/server/upstream"
+	"github.com/gin-gonic/gin"
+)
+
+// Type is the type of an event.
+type Type string
+
+const (
+	// TypeNodeJoined means a node joined the cluster.
+	TypeNodeJoined Type = "node_joined"
+	// TypeNodeLeft means a node left the cluster.
+	TypeNodeLeft Type = "node_left"
+	// TypeNodeStatus means the status of a node changed.
+	TypeNodeStatus Type = "node_status"
+	// TypeNodeDraining means whether a node is draining changed.
+	TypeNodeDraining Type = "node_draining"
+	// TypeEndpointRegistered means an endpoint was registered on a node, or
+	// the number of listeners for the endpoint on the node changed.
+	TypeEndpointRegistered Type = "endpoint_registered"
+	// TypeEndpointUnregistered means an endpoint no longer has listeners on
+	// a node.
+	TypeEndpointUnregistered Type = "endpoint_unregistered"
+	// TypeUpstreamConnected means an upstream connected to the local node.
+	TypeUpstreamConnected Type = "upstream_connected"
+	// TypeUpstreamDisconnected means an upstream disconnected from the local
+	// node.
+	TypeUpstreamDisconnected Type = "upstream_disconnected"
+)
+
+// Event describes a change to the cluster or an upstream connected to the
+// local node.
+type Event struct {
+	Type Type `json:"type"`
+
+	Time time.Time `json:"time"`
+
+	// NodeID is the ID of the node the event occured on.
+	NodeID string `json:"node_id"`
+
+	// Status is the updated node status. Only set for 'node_joined' and
+	// 'node_status'.
+	Status cluster.NodeStatus `json:"status,omitempty"`
+
+	// Draining is whether the node is draining. Only set for
+	// 'node_draining'.
+	Draining bool `json:"draining,omitempty"`
+
+	// EndpointID is the ID of the endpoint for endpoint and upstream events.
+	EndpointID string `json:"endpoint_id,omitempty"`
+
+	// Listeners is the number of listeners for the endpoint on the node. Only
+	// set for 'endpoint_registered'.
+	Listeners int `json:"listeners,omitempty"`
+
+	// Upstream is the upstream connection. Only set for upstream events.
+	Upstream *upstream.ConnInfo `json:"upstream,omitempty"`
+}
+
+// Handler registers the admin route to stream events.
+type Handler struct {
+	clusterState *cluster.State
+
+	upstreams *upstream.LoadBalancedManager
+}
+
+func NewHandler(
+	clusterState *cluster.State,
+	upstreams *upstream.LoadBalancedManager,
+) *Handler {
+	return &Handler{
+		clusterState: clusterState,
+		upstreams:    upstreams,
+	}
+}
+
+func (h *Handler) Register(group *gin.RouterGroup) {
+	group.GET("/events", h.streamRoute)
+}
+
+// streamRoute streams events as server-sent events.
+//
+// Each event has the event type as the event name and the JSON encoded event
+// as the data. Note only events after the request is received are streamed.
+func (h *Handler) streamRoute(c *gin.Context) {
+	stream := status.NewStream()
+
+	unsubscribeCluster := h.clusterState.Subscribe(func(change cluster.Change) {
+		event := eventFromChange(change)
+		stream.Publish(string(event.Type), event)
+	})
+	defer unsubscribeCluster()
+
+	unsubscribeUpstreams := h.upstreams.SubscribeConns(func(connEvent upstream.ConnEvent) {
+		event := eventFromConn(connEvent)
+		stream.Publish(string(event.Type), event)
+	})
+	defer unsubscribeUpstreams()
+
+	stream.Serve(c)
+}
+
+func eventFromChange(change cluster.Change) Event {
+	event := Event{
+		Time:       time.Now(),
+		NodeID:     change.NodeID,
+		Status:     change.Status,
+		Draining:   change.Draining,
+		EndpointID: change.EndpointID,
+		Listeners:  change.Listeners,
+	}
+	switch change.Type {
+	case cluster.ChangeTypeNodeAdded:
+		event.Type = TypeNodeJoined
+	case cluster.ChangeTypeNodeRemoved:
+		event.Type = TypeNodeLeft
+	case cluster.ChangeTypeNodeStatus:
+		event.Type = TypeNodeStatus
+	case cluster.ChangeTypeNodeDraining:
+		event.Type = TypeNodeDraining
+	case cluster.ChangeTypeEndpointUpdated:
+		event.Type = TypeEndpointRegistered
+	case cluster.ChangeTypeEndpointRemoved:
+		event.Type = TypeEndpointUnregistered
+	default:
+		event.Type = Type(change.Type)
+	}
+	return event
+}
+
+func eventFromConn(connEvent upstream.ConnEvent) Event {
+	conn := connEvent.Conn
+	event := Event{
+		Type:       TypeUpstreamConnected,
+		Time:       time.Now(),
+		NodeID:     conn.NodeID,
+		EndpointID: conn.EndpointID,
+		Upstream:   &conn,
+	}
+	if !connEvent.Connected {
+		event.Type = TypeUpstreamDisconnected
+	}
+	return event
+}
+
+var _ status.Handler = &Handler{}
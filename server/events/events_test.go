@@ -0,0 +1,98 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/upstream"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readEvent(t *testing.T, r *bufio.Reader) (string, Event) {
+	name, err := r.ReadString('\n')
+	require.NoError(t, err)
+	data, err := r.ReadString('\n')
+	require.NoError(t, err)
+	// Skip the blank line separating events.
+	_, err = r.ReadString('\n')
+	require.NoError(t, err)
+
+	var event Event
+	require.NoError(t, json.Unmarshal(
+		[]byte(strings.TrimPrefix(strings.TrimSpace(data), "data:")), &event,
+	))
+	return strings.TrimPrefix(strings.TrimSpace(name), "event:"), event
+}
+
+func TestHandler_Stream(t *testing.T) {
+	state := cluster.NewState(&cluster.Node{
+		ID: "local",
+	}, log.NewNopLogger())
+	manager := upstream.NewLoadBalancedManager(state)
+
+	router := gin.New()
+	NewHandler(state, manager).Register(router.Group(""))
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	r := bufio.NewReader(resp.Body)
+
+	t.Run("node joined", func(t *testing.T) {
+		state.AddNode(&cluster.Node{
+			ID:     "remote",
+			Status: cluster.NodeStatusActive,
+		})
+
+		name, event := readEvent(t, r)
+		assert.Equal(t, "node_joined", name)
+		assert.Equal(t, TypeNodeJoined, event.Type)
+		assert.Equal(t, "remote", event.NodeID)
+		assert.Equal(t, cluster.NodeStatusActive, event.Status)
+	})
+
+	t.Run("upstream connected", func(t *testing.T) {
+		u := upstream.NewConnUpstream("my-endpoint", "10.26.104.56", nil)
+		manager.AddConn(u)
+
+		// Adding the upstream registers the endpoint on the local node.
+		name, event := readEvent(t, r)
+		assert.Equal(t, "endpoint_registered", name)
+		assert.Equal(t, "local", event.NodeID)
+		assert.Equal(t, "my-endpoint", event.EndpointID)
+		assert.Equal(t, 1, event.Listeners)
+
+		name, event = readEvent(t, r)
+		assert.Equal(t, "upstream_connected", name)
+		assert.Equal(t, "my-endpoint", event.EndpointID)
+		require.NotNil(t, event.Upstream)
+		assert.Equal(t, u.ID(), event.Upstream.ID)
+		assert.Equal(t, "10.26.104.56", event.Upstream.ClientIP)
+
+		manager.RemoveConn(u)
+
+		name, event = readEvent(t, r)
+		assert.Equal(t, "endpoint_unregistered", name)
+		assert.Equal(t, "my-endpoint", event.EndpointID)
+
+		name, event = readEvent(t, r)
+		assert.Equal(t, "upstream_disconnected", name)
+		require.NotNil(t, event.Upstream)
+		assert.Equal(t, u.ID(), event.Upstream.ID)
+	})
+}
@@ -0,0 +1,274 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/upstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// EndpointsResponse contains the endpoints available in a cluster.
+type EndpointsResponse struct {
+	// Endpoints maps the endpoint ID to the number of listeners for that
+	// endpoint in the cluster.
+	Endpoints map[string]int `json:"endpoints"`
+}
+
+// ClusterStatus contains the known state of a remote cluster.
+type ClusterStatus struct {
+	Name string `json:"name"`
+
+	URL string `json:"url"`
+
+	// Endpoints is the number of endpoints available in the cluster.
+	Endpoints int `json:"endpoints"`
+
+	// LastSync is the time of the last successful sync with the cluster.
+	LastSync time.Time `json:"last_sync,omitempty"`
+
+	// Error contains the error from the last sync, or is empty if the last
+	// sync succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+type remoteCluster struct {
+	name string
+	url  *url.URL
+
+	// endpoints contains the endpoints available in the cluster from the
+	// last successful sync.
+	endpoints map[string]int
+
+	lastSync time.Time
+	err      error
+}
+
+// Federation shares endpoint availability with remote independent Piko
+// clusters.
+//
+// Each node periodically fetches the endpoints available in each remote
+// cluster, so requests for an endpoint with no upstreams in the local cluster
+// can be forwarded to a remote cluster with upstreams for that endpoint.
+//
+// Remote clusters only share a summary of the endpoints available across all
+// nodes in the cluster, rather than their full cluster state, so a forwarded
+// request is routed to the node with the upstream by the remote cluster.
+type Federation struct {
+	clusterState *cluster.State
+
+	clusters map[string]*remoteCluster
+
+	// mu protects clusters.
+	mu sync.Mutex
+
+	token string
+
+	syncInterval time.Duration
+
+	client *http.Client
+
+	metrics *Metrics
+
+	logger log.Logger
+}
+
+func NewFederation(
+	clusterState *cluster.State,
+	conf config.FederationConfig,
+	logger log.Logger,
+) (*Federation, error) {
+	clusters := make(map[string]*remoteCluster)
+	for name, addr := range conf.Clusters {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: invalid url: %w", name, err)
+		}
+		clusters[name] = &remoteCluster{
+			name:      name,
+			url:       u,
+			endpoints: make(map[string]int),
+		}
+	}
+
+	return &Federation{
+		clusterState: clusterState,
+		clusters:     clusters,
+		token:        conf.Token,
+		syncInterval: conf.SyncInterval,
+		client: &http.Client{
+			Timeout: conf.SyncInterval,
+		},
+		metrics: NewMetrics(),
+		logger:  logger.WithSubsystem("federation"),
+	}, nil
+}
+
+// Select looks up a remote cluster with upstreams for the given endpoint ID.
+//
+// If multiple clusters have upstreams for the endpoint, the cluster with the
+// most listeners is selected.
+func (f *Federation) Select(endpointID string) (upstream.Upstream, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var selected *remoteCluster
+	for _, c := range f.clusters {
+		listeners := c.endpoints[endpointID]
+		if listeners == 0 {
+			continue
+		}
+		if selected == nil ||
+			listeners > selected.endpoints[endpointID] ||
+			(listeners == selected.endpoints[endpointID] && c.name < selected.name) {
+			selected = c
+		}
+	}
+	if selected == nil {
+		return nil, false
+	}
+
+	f.metrics.RequestsTotal.With(prometheus.Labels{
+		"cluster": selected.name,
+	}).Inc()
+	return upstream.NewClusterUpstream(endpointID, selected.name, selected.url), true
+}
+
+// Endpoints returns the endpoints available in the local cluster, to share
+// with remote clusters.
+func (f *Federation) Endpoints() map[string]int {
+	return f.clusterState.Endpoints()
+}
+
+// Clusters returns the known state of each remote cluster, sorted by name.
+func (f *Federation) Clusters() []ClusterStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var clusters []ClusterStatus
+	for _, c := range f.clusters {
+		status := ClusterStatus{
+			Name:      c.name,
+			URL:       c.url.String(),
+			Endpoints: len(c.endpoints),
+			LastSync:  c.lastSync,
+		}
+		if c.err != nil {
+			status.Error = c.err.Error()
+		}
+		clusters = append(clusters, status)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+	return clusters
+}
+
+// Run periodically fetches the endpoints available in each remote cluster
+// until the context is cancelled.
+func (f *Federation) Run(ctx context.Context) {
+	if len(f.clusters) == 0 {
+		return
+	}
+
+	f.Sync(ctx)
+
+	ticker := time.NewTicker(f.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.Sync(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync fetches the endpoints available in each remote cluster.
+func (f *Federation) Sync(ctx context.Context) {
+	f.mu.Lock()
+	clusters := make([]*remoteCluster, 0, len(f.clusters))
+	for _, c := range f.clusters {
+		clusters = append(clusters, c)
+	}
+	f.mu.Unlock()
+
+	for _, c := range clusters {
+		endpoints, err := f.fetchEndpoints(ctx, c.url)
+		if err != nil {
+			f.logger.Warn(
+				"failed to sync cluster",
+				zap.String("cluster", c.name),
+				zap.Error(err),
+			)
+			f.metrics.SyncErrorsTotal.With(prometheus.Labels{
+				"cluster": c.name,
+			}).Inc()
+
+			// Discard the known endpoints since the cluster may be
+			// unreachable.
+			endpoints = make(map[string]int)
+		}
+
+		f.mu.Lock()
+		c.endpoints = endpoints
+		c.err = err
+		if err == nil {
+			c.lastSync = time.Now()
+		}
+		f.mu.Unlock()
+
+		f.metrics.RemoteEndpoints.With(prometheus.Labels{
+			"cluster": c.name,
+		}).Set(float64(len(endpoints)))
+	}
+}
+
+func (f *Federation) Metrics() *Metrics {
+	return f.metrics
+}
+
+func (f *Federation) fetchEndpoints(
+	ctx context.Context,
+	u *url.URL,
+) (map[string]int, error) {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, u.JoinPath("/_piko/v1/federation/endpoints").String(), nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+f.token)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request: bad status: %d", resp.StatusCode)
+	}
+
+	var endpointsResp EndpointsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&endpointsResp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if endpointsResp.Endpoints == nil {
+		endpointsResp.Endpoints = make(map[string]int)
+	}
+	return endpointsResp.Endpoints, nil
+}
@@ -0,0 +1,140 @@
+package federation
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/upstream"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFederation_Sync(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		remoteURL := testRemoteCluster(t, "my-token", "my-endpoint")
+
+		f, err := NewFederation(
+			testClusterState(),
+			config.FederationConfig{
+				Token: "my-token",
+				Clusters: map[string]string{
+					"remote": remoteURL,
+				},
+				SyncInterval: time.Second,
+			},
+			log.NewNopLogger(),
+		)
+		require.NoError(t, err)
+
+		f.Sync(context.Background())
+
+		u, ok := f.Select("my-endpoint")
+		require.True(t, ok)
+		clusterUpstream := u.(*upstream.ClusterUpstream)
+		assert.Equal(t, "my-endpoint", clusterUpstream.EndpointID())
+		assert.Equal(t, "remote", clusterUpstream.Cluster())
+		assert.Equal(t, remoteURL, clusterUpstream.URL().String())
+
+		_, ok = f.Select("unknown")
+		assert.False(t, ok)
+
+		clusters := f.Clusters()
+		require.Equal(t, 1, len(clusters))
+		assert.Equal(t, "remote", clusters[0].Name)
+		assert.Equal(t, 1, clusters[0].Endpoints)
+		assert.Empty(t, clusters[0].Error)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		remoteURL := testRemoteCluster(t, "my-token", "my-endpoint")
+
+		f, err := NewFederation(
+			testClusterState(),
+			config.FederationConfig{
+				Token: "invalid-token",
+				Clusters: map[string]string{
+					"remote": remoteURL,
+				},
+				SyncInterval: time.Second,
+			},
+			log.NewNopLogger(),
+		)
+		require.NoError(t, err)
+
+		f.Sync(context.Background())
+
+		_, ok := f.Select("my-endpoint")
+		assert.False(t, ok)
+
+		clusters := f.Clusters()
+		require.Equal(t, 1, len(clusters))
+		assert.Equal(t, 0, clusters[0].Endpoints)
+		assert.Equal(t, "request: bad status: 401", clusters[0].Error)
+	})
+
+	t.Run("prefer most listeners", func(t *testing.T) {
+		remoteURL1 := testRemoteCluster(t, "my-token", "my-endpoint")
+		remoteURL2 := testRemoteCluster(
+			t, "my-token", "my-endpoint", "my-endpoint",
+		)
+
+		f, err := NewFederation(
+			testClusterState(),
+			config.FederationConfig{
+				Token: "my-token",
+				Clusters: map[string]string{
+					"remote-1": remoteURL1,
+					"remote-2": remoteURL2,
+				},
+				SyncInterval: time.Second,
+			},
+			log.NewNopLogger(),
+		)
+		require.NoError(t, err)
+
+		f.Sync(context.Background())
+
+		u, ok := f.Select("my-endpoint")
+		require.True(t, ok)
+		assert.Equal(t, "remote-2", u.(*upstream.ClusterUpstream).Cluster())
+	})
+}
+
+func testClusterState(endpointIDs ...string) *cluster.State {
+	state := cluster.NewState(&cluster.Node{
+		ID:     "local",
+		Status: cluster.NodeStatusActive,
+	}, log.NewNopLogger())
+	for _, endpointID := range endpointIDs {
+		state.AddLocalEndpoint(endpointID)
+	}
+	return state
+}
+
+// testRemoteCluster starts a server that serves the endpoints of a remote
+// cluster with the given endpoints, and returns the servers URL.
+func testRemoteCluster(t *testing.T, token string, endpointIDs ...string) string {
+	f, err := NewFederation(
+		testClusterState(endpointIDs...),
+		config.FederationConfig{
+			Token:        token,
+			SyncInterval: time.Second,
+		},
+		log.NewNopLogger(),
+	)
+	require.NoError(t, err)
+
+	router := gin.New()
+	NewHandler(f).Register(router.Group("/_piko/v1/federation"))
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
@@ -0,0 +1,44 @@
+package federation
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/andydunstall/piko/server/status"
+	"github.com/gin-gonic/gin"
+)
+
+// Handler serves the endpoints available in the local cluster to remote
+// clusters.
+//
+// Requests must be authenticated with the federation token.
+type Handler struct {
+	federation *Federation
+}
+
+func NewHandler(federation *Federation) *Handler {
+	return &Handler{
+		federation: federation,
+	}
+}
+
+func (h *Handler) Register(group *gin.RouterGroup) {
+	group.GET("/endpoints", h.endpointsRoute)
+}
+
+func (h *Handler) endpointsRoute(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare(
+		[]byte(token), []byte(h.federation.token),
+	) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, &EndpointsResponse{
+		Endpoints: h.federation.Endpoints(),
+	})
+}
+
+var _ status.Handler = &Handler{}
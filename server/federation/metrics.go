@@ -0,0 +1,58 @@
+package federation
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type Metrics struct {
+	// RequestsTotal is the number of requests forwarded to a remote cluster.
+	// Labelled by the remote cluster name.
+	RequestsTotal *prometheus.CounterVec
+
+	// RemoteEndpoints is the number of endpoints available in each remote
+	// cluster. Labelled by the remote cluster name.
+	RemoteEndpoints *prometheus.GaugeVec
+
+	// SyncErrorsTotal is the number of failed attempts to fetch the
+	// endpoints available in a remote cluster. Labelled by the remote
+	// cluster name.
+	SyncErrorsTotal *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		RequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "federation",
+				Name:      "requests_total",
+				Help:      "Number of requests forwarded to a remote cluster",
+			},
+			[]string{"cluster"},
+		),
+		RemoteEndpoints: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "piko",
+				Subsystem: "federation",
+				Name:      "remote_endpoints",
+				Help:      "Number of endpoints available in a remote cluster",
+			},
+			[]string{"cluster"},
+		),
+		SyncErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "federation",
+				Name:      "sync_errors_total",
+				Help:      "Number of failed attempts to fetch the endpoints available in a remote cluster",
+			},
+			[]string{"cluster"},
+		),
+	}
+}
+
+func (m *Metrics) Register(registry *prometheus.Registry) {
+	registry.MustRegister(
+		m.RequestsTotal,
+		m.RemoteEndpoints,
+		m.SyncErrorsTotal,
+	)
+}
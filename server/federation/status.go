@@ -0,0 +1,28 @@
+package federation
+
+import (
+	"net/http"
+
+	"github.com/andydunstall/piko/server/status"
+	"github.com/gin-gonic/gin"
+)
+
+type Status struct {
+	federation *Federation
+}
+
+func NewStatus(federation *Federation) *Status {
+	return &Status{
+		federation: federation,
+	}
+}
+
+func (s *Status) Register(group *gin.RouterGroup) {
+	group.GET("/clusters", s.listClustersRoute)
+}
+
+func (s *Status) listClustersRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, s.federation.Clusters())
+}
+
+var _ status.Handler = &Status{}
@@ -9,6 +9,8 @@ import (
 	"github.com/andydunstall/piko/pkg/gossip"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/revocation"
+	"github.com/andydunstall/piko/server/upstream"
 	"go.uber.org/zap"
 )
 
@@ -31,6 +33,10 @@ type Gossip struct {
 
 func NewGossip(
 	clusterState *cluster.State,
+	revocations *revocation.List,
+	visibility *upstream.VisibilityList,
+	bans *upstream.BanList,
+	approvals *upstream.ApprovalList,
 	streamLn net.Listener,
 	packetLn net.PacketConn,
 	conf *gossip.Config,
@@ -38,7 +44,10 @@ func NewGossip(
 ) *Gossip {
 	logger = logger.WithSubsystem("gossip")
 
-	syncer := newSyncer(clusterState, logger)
+	syncer := newSyncer(clusterState, revocations, logger)
+	syncer.visibility = visibility
+	syncer.bans = bans
+	syncer.approvals = approvals
 	gossiper := gossip.New(
 		clusterState.LocalNode().ID,
 		conf,
@@ -85,6 +94,45 @@ func (g *Gossip) JoinOnStartup(ctx context.Context, addrs []string) ([]string, e
 	}
 }
 
+// JoinPeriodically re-resolves and joins the given addresses every interval
+// until the context is cancelled.
+//
+// Only resolved addresses that don't belong to a known live member are
+// joined, so the node discovers members added to the join domain, and
+// recovers if it couldn't join the cluster on startup.
+func (g *Gossip) JoinPeriodically(
+	ctx context.Context,
+	addrs []string,
+	interval time.Duration,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			nodeIDs, err := g.gossiper.Rejoin(addrs)
+			if err != nil {
+				g.logger.Warn("failed to rejoin cluster", zap.Error(err))
+				continue
+			}
+			if len(nodeIDs) > 0 {
+				g.logger.Info(
+					"rejoined cluster",
+					zap.Strings("node-ids", nodeIDs),
+				)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SetResolver sets the resolver used to resolve domains in join addresses.
+func (g *Gossip) SetResolver(resolver *net.Resolver) {
+	g.gossiper.SetResolver(resolver)
+}
+
 // Leave notifies the known members that this node is leaving the cluster.
 //
 // This will attempt to sync with up to 3 nodes to ensure the leave status is
@@ -115,6 +163,18 @@ func (g *Gossip) NodeState(id string) (*gossip.NodeState, bool) {
 	return g.gossiper.Node(id)
 }
 
+// SyncStats returns statistics about the full state syncs initiated by the
+// local node.
+func (g *Gossip) SyncStats() gossip.SyncStats {
+	return g.gossiper.SyncStats()
+}
+
+// AuthFailures returns the most recent messages rejected from
+// unauthenticated nodes.
+func (g *Gossip) AuthFailures() []gossip.AuthFailure {
+	return g.gossiper.AuthFailures()
+}
+
 func (g *Gossip) Metrics() *gossip.Metrics {
 	return g.gossiper.Metrics()
 }
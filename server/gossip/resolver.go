@@ -0,0 +1,35 @@
+package gossip
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// NewResolver returns a resolver that sends DNS queries to the given DNS
+// servers, rather than the system configured servers.
+//
+// Each server is an IP address with an optional port (defaulting to 53).
+// Queries rotate through the servers, so when a query to one server fails,
+// the retry is sent to the next server.
+func NewResolver(servers []string) *net.Resolver {
+	addrs := make([]string, 0, len(servers))
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			host := strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")
+			server = net.JoinHostPort(host, "53")
+		}
+		addrs = append(addrs, server)
+	}
+
+	var next atomic.Uint64
+	var dialer net.Dialer
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			addr := addrs[(next.Add(1)-1)%uint64(len(addrs))]
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
@@ -21,6 +21,8 @@ func NewStatus(gossip *Gossip) *Status {
 func (s *Status) Register(group *gin.RouterGroup) {
 	group.GET("/nodes", s.listNodesRoute)
 	group.GET("/nodes/:id", s.getNodeRoute)
+	group.GET("/sync", s.syncRoute)
+	group.GET("/auth-failures", s.authFailuresRoute)
 }
 
 func (s *Status) listNodesRoute(c *gin.Context) {
@@ -45,4 +47,12 @@ func (s *Status) getNodeRoute(c *gin.Context) {
 	c.JSON(http.StatusOK, state)
 }
 
+func (s *Status) syncRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, s.gossip.SyncStats())
+}
+
+func (s *Status) authFailuresRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, s.gossip.AuthFailures())
+}
+
 var _ status.Handler = &Status{}
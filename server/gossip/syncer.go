@@ -1,13 +1,17 @@
 package gossip
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/andydunstall/piko/pkg/gossip"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/revocation"
+	"github.com/andydunstall/piko/server/upstream"
 	"go.uber.org/zap"
 )
 
@@ -32,15 +36,34 @@ type syncer struct {
 
 	clusterState *cluster.State
 
+	revocations *revocation.List
+
+	// visibility contains the visibility of endpoints set using the admin
+	// API, or nil if endpoint visibility is disabled.
+	visibility *upstream.VisibilityList
+
+	// bans contains upstreams banned from connecting, or nil if bans aren't
+	// propagated.
+	bans *upstream.BanList
+
+	// approvals contains the endpoints approved using the admin API, or nil
+	// if approval isn't required.
+	approvals *upstream.ApprovalList
+
 	gossiper gossiper
 
 	logger log.Logger
 }
 
-func newSyncer(clusterState *cluster.State, logger log.Logger) *syncer {
+func newSyncer(
+	clusterState *cluster.State,
+	revocations *revocation.List,
+	logger log.Logger,
+) *syncer {
 	return &syncer{
 		pendingNodes: make(map[string]*cluster.Node),
 		clusterState: clusterState,
+		revocations:  revocations,
 		logger:       logger,
 	}
 }
@@ -49,16 +72,73 @@ func (s *syncer) Sync(gossiper gossiper) {
 	s.gossiper = gossiper
 
 	s.clusterState.OnLocalEndpointUpdate(s.onLocalEndpointUpdate)
+	s.clusterState.OnLocalDrainingUpdate(s.onLocalDrainingUpdate)
+	s.revocations.OnRevoke(s.onRevoke)
+	s.revocations.OnExpire(s.onRevocationExpire)
+	if s.visibility != nil {
+		s.visibility.OnUpdate(s.onVisibilityUpdate)
+	}
+	if s.bans != nil {
+		s.bans.OnBan(s.onBan)
+		s.bans.OnExpire(s.onBanExpire)
+	}
+	if s.approvals != nil {
+		s.approvals.OnUpdate(s.onApprovalUpdate)
+	}
 
 	localNode := s.clusterState.LocalNode()
-	// First add immutable fields.
+	// First add immutable fields. Note the role, build metadata, labels and
+	// RPC address are optional so are added before the required fields to ensure
+	// they are known before the node is added to the cluster.
+	if localNode.Role != "" {
+		s.gossiper.UpsertLocal("role", string(localNode.Role))
+	}
+	if localNode.Version != "" {
+		s.gossiper.UpsertLocal("version", localNode.Version)
+	}
+	if localNode.Commit != "" {
+		s.gossiper.UpsertLocal("commit", localNode.Commit)
+	}
+	if localNode.BuildDate != "" {
+		s.gossiper.UpsertLocal("build_date", localNode.BuildDate)
+	}
+	if localNode.GoVersion != "" {
+		s.gossiper.UpsertLocal("go_version", localNode.GoVersion)
+	}
+	for key, value := range localNode.Labels {
+		s.gossiper.UpsertLocal("label:"+key, value)
+	}
+	if localNode.RPCAddr != "" {
+		s.gossiper.UpsertLocal("rpc_addr", localNode.RPCAddr)
+	}
 	s.gossiper.UpsertLocal("proxy_addr", localNode.ProxyAddr)
 	s.gossiper.UpsertLocal("admin_addr", localNode.AdminAddr)
 	// Finally add mutable fields.
+	if localNode.Draining {
+		s.gossiper.UpsertLocal("draining", "true")
+	}
 	for endpointID, listeners := range localNode.Endpoints {
 		key := "endpoint:" + endpointID
 		s.gossiper.UpsertLocal(key, strconv.Itoa(listeners))
 	}
+	for _, r := range s.revocations.Revocations() {
+		s.gossiper.UpsertLocal("revoked:"+r.ID, formatExpiry(r.Expiry))
+	}
+	if s.visibility != nil {
+		for _, r := range s.visibility.Records() {
+			s.gossiper.UpsertLocal("visibility:"+r.EndpointID, formatVisibility(r))
+		}
+	}
+	if s.bans != nil {
+		for _, b := range s.bans.Bans() {
+			s.gossiper.UpsertLocal(banKey(b.EndpointID, b.ClientIP), formatExpiry(b.Expiry))
+		}
+	}
+	if s.approvals != nil {
+		for _, r := range s.approvals.Records() {
+			s.gossiper.UpsertLocal("approval:"+r.EndpointID, formatApproval(r))
+		}
+	}
 }
 
 func (s *syncer) OnJoin(nodeID string) {
@@ -257,7 +337,32 @@ func (s *syncer) OnUpsertKey(nodeID, key, value string) {
 		return
 	}
 
-	if key == "proxy_addr" || key == "admin_addr" {
+	// Revocations aren't node state, so are handled whether or not the node
+	// is in the cluster.
+	if strings.HasPrefix(key, "revoked:") {
+		s.onRemoteRevoke(nodeID, key, value)
+		return
+	}
+	// Likewise endpoint visibility isn't node state.
+	if strings.HasPrefix(key, "visibility:") {
+		s.onRemoteVisibility(nodeID, key, value)
+		return
+	}
+	// Likewise upstream bans aren't node state.
+	if strings.HasPrefix(key, "banned:") {
+		s.onRemoteBan(nodeID, key, value)
+		return
+	}
+	// Likewise endpoint approvals aren't node state.
+	if strings.HasPrefix(key, "approval:") {
+		s.onRemoteApproval(nodeID, key, value)
+		return
+	}
+
+	if key == "proxy_addr" || key == "admin_addr" || key == "rpc_addr" ||
+		key == "role" || key == "version" || key == "commit" ||
+		key == "build_date" || key == "go_version" ||
+		strings.HasPrefix(key, "label:") {
 		// Ignore immutable fields if the node is in the cluster state. This
 		// may occur after a compaction so immutable fields are re-versioned.
 		if _, ok := s.clusterState.Node(nodeID); ok {
@@ -267,6 +372,11 @@ func (s *syncer) OnUpsertKey(nodeID, key, value string) {
 
 	// First check if the node is already in the cluster. Only check mutable
 	// fields.
+	if key == "draining" {
+		if s.clusterState.UpdateRemoteDraining(nodeID, value == "true") {
+			return
+		}
+	}
 	if strings.HasPrefix(key, "endpoint:") {
 		endpointID, _ := strings.CutPrefix(key, "endpoint:")
 		listeners, err := strconv.Atoi(value)
@@ -300,6 +410,26 @@ func (s *syncer) OnUpsertKey(nodeID, key, value string) {
 		node.ProxyAddr = value
 	} else if key == "admin_addr" {
 		node.AdminAddr = value
+	} else if key == "rpc_addr" {
+		node.RPCAddr = value
+	} else if key == "role" {
+		node.Role = cluster.NodeRole(value)
+	} else if key == "version" {
+		node.Version = value
+	} else if key == "commit" {
+		node.Commit = value
+	} else if key == "build_date" {
+		node.BuildDate = value
+	} else if key == "go_version" {
+		node.GoVersion = value
+	} else if strings.HasPrefix(key, "label:") {
+		labelKey, _ := strings.CutPrefix(key, "label:")
+		if node.Labels == nil {
+			node.Labels = make(map[string]string)
+		}
+		node.Labels[labelKey] = value
+	} else if key == "draining" {
+		node.Draining = value == "true"
 	} else if strings.HasPrefix(key, "endpoint:") {
 		endpointID, _ := strings.CutPrefix(key, "endpoint:")
 		listeners, err := strconv.Atoi(value)
@@ -362,7 +492,28 @@ func (s *syncer) OnDeleteKey(nodeID, key string) {
 		return
 	}
 
-	// Only endpoint state can be deleted.
+	// Revocations are deleted once the token expires, which each node
+	// discards itself.
+	if strings.HasPrefix(key, "revoked:") {
+		return
+	}
+	// Endpoint visibility is never deleted, since unsetting the visibility
+	// is propagated as an update.
+	if strings.HasPrefix(key, "visibility:") {
+		return
+	}
+	// Bans are deleted once expired, which each node discards itself.
+	if strings.HasPrefix(key, "banned:") {
+		return
+	}
+	// Endpoint approvals are never deleted, since revoking an approval is
+	// propagated as an update.
+	if strings.HasPrefix(key, "approval:") {
+		return
+	}
+
+	// Only endpoint, revocation, visibility, ban and approval state can be
+	// deleted.
 	if !strings.HasPrefix(key, "endpoint:") {
 		s.logger.Error(
 			"node delete state; unsupported key",
@@ -416,4 +567,284 @@ func (s *syncer) onLocalEndpointUpdate(endpointID string) {
 	}
 }
 
+func (s *syncer) onLocalDrainingUpdate(draining bool) {
+	s.gossiper.UpsertLocal("draining", strconv.FormatBool(draining))
+}
+
+func (s *syncer) onRemoteRevoke(nodeID, key, value string) {
+	id, _ := strings.CutPrefix(key, "revoked:")
+	expiry, err := parseExpiry(value)
+	if err != nil {
+		s.logger.Error(
+			"node upsert state; invalid revocation expiry",
+			zap.String("node-id", nodeID),
+			zap.String("expiry", value),
+			zap.Error(err),
+		)
+		return
+	}
+	if s.revocations.Revoke(revocation.Revocation{
+		ID:     id,
+		Expiry: expiry,
+	}) {
+		s.logger.Info(
+			"node upsert state; token revoked",
+			zap.String("node-id", nodeID),
+			zap.String("token-id", id),
+		)
+	}
+}
+
+// onRevoke adds the revocation to the local node state, including
+// revocations learned from other nodes, so the revocation is still
+// propagated after the node it was revoked on leaves.
+func (s *syncer) onRevoke(r revocation.Revocation) {
+	// Update gossip in the background, since revocations learned from other
+	// nodes are received with the gossip state mutex held.
+	go s.gossiper.UpsertLocal("revoked:"+r.ID, formatExpiry(r.Expiry))
+}
+
+func (s *syncer) onRevocationExpire(id string) {
+	go s.gossiper.DeleteLocal("revoked:" + id)
+}
+
+func (s *syncer) onRemoteVisibility(nodeID, key, value string) {
+	// Ignore the visibility if endpoint visibility is disabled on the local
+	// node.
+	if s.visibility == nil {
+		return
+	}
+
+	endpointID, _ := strings.CutPrefix(key, "visibility:")
+	r, err := parseVisibility(endpointID, value)
+	if err != nil {
+		s.logger.Error(
+			"node upsert state; invalid endpoint visibility",
+			zap.String("node-id", nodeID),
+			zap.String("visibility", value),
+			zap.Error(err),
+		)
+		return
+	}
+	if s.visibility.Apply(r) {
+		s.logger.Info(
+			"node upsert state; endpoint visibility updated",
+			zap.String("node-id", nodeID),
+			zap.String("endpoint-id", endpointID),
+			zap.String("visibility", r.Visibility),
+		)
+	}
+}
+
+// onVisibilityUpdate adds the visibility to the local node state, including
+// updates learned from other nodes, so the update is still propagated after
+// the node it was set on leaves.
+func (s *syncer) onVisibilityUpdate(r upstream.VisibilityRecord) {
+	// Update gossip in the background, since updates learned from other
+	// nodes are received with the gossip state mutex held. Since updates
+	// may be reordered, add the latest update rather than r.
+	go func() {
+		latest, ok := s.visibility.Record(r.EndpointID)
+		if !ok {
+			return
+		}
+		s.gossiper.UpsertLocal(
+			"visibility:"+latest.EndpointID, formatVisibility(latest),
+		)
+	}()
+}
+
+func (s *syncer) onRemoteBan(nodeID, key, value string) {
+	// Ignore the ban if bans aren't propagated to the local node.
+	if s.bans == nil {
+		return
+	}
+
+	clientIP, endpointID, ok := strings.Cut(strings.TrimPrefix(key, "banned:"), "/")
+	if !ok {
+		s.logger.Error(
+			"node upsert state; invalid ban",
+			zap.String("node-id", nodeID),
+			zap.String("key", key),
+		)
+		return
+	}
+	expiry, err := parseExpiry(value)
+	if err != nil || expiry.IsZero() {
+		s.logger.Error(
+			"node upsert state; invalid ban expiry",
+			zap.String("node-id", nodeID),
+			zap.String("expiry", value),
+			zap.Error(err),
+		)
+		return
+	}
+	if s.bans.Apply(upstream.Ban{
+		EndpointID: endpointID,
+		ClientIP:   clientIP,
+		Expiry:     expiry,
+	}) {
+		s.logger.Info(
+			"node upsert state; upstream banned",
+			zap.String("node-id", nodeID),
+			zap.String("endpoint-id", endpointID),
+			zap.String("client-ip", clientIP),
+		)
+	}
+}
+
+// onBan adds the ban to the local node state, including bans learned from
+// other nodes, so the ban is still propagated after the node it was added on
+// leaves.
+func (s *syncer) onBan(b upstream.Ban) {
+	// Update gossip in the background, since bans learned from other nodes
+	// are received with the gossip state mutex held.
+	go s.syncBan(b.EndpointID, b.ClientIP)
+}
+
+func (s *syncer) onBanExpire(b upstream.Ban) {
+	go s.syncBan(b.EndpointID, b.ClientIP)
+}
+
+// syncBan updates the local node state with the latest ban for the upstream.
+// Since ban and expire updates may be reordered, this adds the current ban
+// if there is one, otherwise deletes it.
+func (s *syncer) syncBan(endpointID string, clientIP string) {
+	key := banKey(endpointID, clientIP)
+	expiry, ok := s.bans.Banned(endpointID, clientIP)
+	if !ok {
+		s.gossiper.DeleteLocal(key)
+		return
+	}
+	s.gossiper.UpsertLocal(key, formatExpiry(expiry))
+}
+
+// banKey returns the gossip key for the ban, such as
+// 'banned:10.26.104.56/my-endpoint'. The client IP comes first since endpoint
+// IDs may contain '/' (when scoped to a tenant) whereas IP addresses cannot.
+func banKey(endpointID string, clientIP string) string {
+	return "banned:" + clientIP + "/" + endpointID
+}
+
+func (s *syncer) onRemoteApproval(nodeID, key, value string) {
+	// Ignore the approval if approval isn't required on the local node.
+	if s.approvals == nil {
+		return
+	}
+
+	endpointID, _ := strings.CutPrefix(key, "approval:")
+	r, err := parseApproval(endpointID, value)
+	if err != nil {
+		s.logger.Error(
+			"node upsert state; invalid endpoint approval",
+			zap.String("node-id", nodeID),
+			zap.String("approval", value),
+			zap.Error(err),
+		)
+		return
+	}
+	if s.approvals.Apply(r) {
+		s.logger.Info(
+			"node upsert state; endpoint approval updated",
+			zap.String("node-id", nodeID),
+			zap.String("endpoint-id", endpointID),
+			zap.Bool("approved", r.Approved),
+		)
+	}
+}
+
+// onApprovalUpdate adds the approval to the local node state, including
+// updates learned from other nodes, so the update is still propagated after
+// the node it was made on leaves.
+func (s *syncer) onApprovalUpdate(r upstream.ApprovalRecord) {
+	// Update gossip in the background, since updates learned from other
+	// nodes are received with the gossip state mutex held. Since updates
+	// may be reordered, add the latest update rather than r.
+	go func() {
+		latest, ok := s.approvals.Record(r.EndpointID)
+		if !ok {
+			return
+		}
+		s.gossiper.UpsertLocal(
+			"approval:"+latest.EndpointID, formatApproval(latest),
+		)
+	}()
+}
+
+// formatApproval formats the approval update as the update time as a Unix
+// timestamp in nanoseconds, followed by either 'approved' or 'revoked', such
+// as '1718000000000000000:approved'.
+func formatApproval(r upstream.ApprovalRecord) string {
+	state := "revoked"
+	if r.Approved {
+		state = "approved"
+	}
+	return strconv.FormatInt(r.Updated.UnixNano(), 10) + ":" + state
+}
+
+func parseApproval(endpointID string, s string) (upstream.ApprovalRecord, error) {
+	updated, state, ok := strings.Cut(s, ":")
+	if !ok {
+		return upstream.ApprovalRecord{}, fmt.Errorf("missing update time")
+	}
+	unix, err := strconv.ParseInt(updated, 10, 64)
+	if err != nil {
+		return upstream.ApprovalRecord{}, err
+	}
+	if state != "approved" && state != "revoked" {
+		return upstream.ApprovalRecord{}, fmt.Errorf("invalid approval: %s", state)
+	}
+	return upstream.ApprovalRecord{
+		EndpointID: endpointID,
+		Approved:   state == "approved",
+		Updated:    time.Unix(0, unix),
+	}, nil
+}
+
+// formatVisibility formats the visibility update as the update time as a Unix
+// timestamp in nanoseconds, followed by the visibility, such as
+// '1718000000000000000:public'. The visibility is empty if unset.
+func formatVisibility(r upstream.VisibilityRecord) string {
+	return strconv.FormatInt(r.Updated.UnixNano(), 10) + ":" + r.Visibility
+}
+
+func parseVisibility(endpointID string, s string) (upstream.VisibilityRecord, error) {
+	updated, visibility, ok := strings.Cut(s, ":")
+	if !ok {
+		return upstream.VisibilityRecord{}, fmt.Errorf("missing update time")
+	}
+	unix, err := strconv.ParseInt(updated, 10, 64)
+	if err != nil {
+		return upstream.VisibilityRecord{}, err
+	}
+	if visibility != "" && !upstream.ValidVisibility(visibility) {
+		return upstream.VisibilityRecord{}, fmt.Errorf("invalid visibility: %s", visibility)
+	}
+	return upstream.VisibilityRecord{
+		EndpointID: endpointID,
+		Visibility: visibility,
+		Updated:    time.Unix(0, unix),
+	}, nil
+}
+
+// formatExpiry formats the revocation expiry as a Unix timestamp in seconds,
+// or '0' if the revocation doesn't expire.
+func formatExpiry(expiry time.Time) string {
+	if expiry.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(expiry.Unix(), 10)
+}
+
+func parseExpiry(s string) (time.Time, error) {
+	unix, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if unix == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(unix, 0), nil
+}
+
 var _ gossip.Watcher = &syncer{}
@@ -1,10 +1,15 @@
 package gossip
 
 import (
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/revocation"
+	"github.com/andydunstall/piko/server/upstream"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,9 +21,16 @@ type upsert struct {
 type fakeGossiper struct {
 	upserts []upsert
 	deletes []string
+
+	// mu protects the above fields, since revocations are gossiped in the
+	// background.
+	mu sync.Mutex
 }
 
 func (g *fakeGossiper) UpsertLocal(key, value string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	g.upserts = append(g.upserts, upsert{
 		Key:   key,
 		Value: value,
@@ -26,36 +38,93 @@ func (g *fakeGossiper) UpsertLocal(key, value string) {
 }
 
 func (g *fakeGossiper) DeleteLocal(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	g.deletes = append(g.deletes, key)
 }
 
+func (g *fakeGossiper) Upserts() []upsert {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]upsert(nil), g.upserts...)
+}
+
+func (g *fakeGossiper) Deletes() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]string(nil), g.deletes...)
+}
+
 var _ gossiper = &fakeGossiper{}
 
 func TestSyncer_Sync(t *testing.T) {
-	localNode := &cluster.Node{
-		ID:        "local",
-		ProxyAddr: "10.26.104.56:8000",
-		AdminAddr: "10.26.104.56:8001",
-	}
-	m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
-	m.AddLocalEndpoint("my-endpoint")
-	m.AddLocalEndpoint("my-endpoint")
-	m.AddLocalEndpoint("my-endpoint")
+	t.Run("optional fields", func(t *testing.T) {
+		localNode := &cluster.Node{
+			ID:        "local",
+			Role:      cluster.NodeRoleProxy,
+			Version:   "v0.1.0",
+			Commit:    "1a2b3c",
+			BuildDate: "2024-06-01T12:00:00Z",
+			GoVersion: "go1.22.4",
+			Labels:    map[string]string{"region": "eu"},
+			ProxyAddr: "10.26.104.56:8000",
+			AdminAddr: "10.26.104.56:8001",
+			RPCAddr:   "10.26.104.56:8004",
+		}
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-	sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
-	gossiper := &fakeGossiper{}
-	sync.Sync(gossiper)
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
 
-	assert.Equal(
-		t,
-		[]upsert{
-			{"proxy_addr", "10.26.104.56:8000"},
-			{"admin_addr", "10.26.104.56:8001"},
-			{"endpoint:my-endpoint", "3"},
-		},
-		gossiper.upserts,
-	)
+		// The optional fields must be gossiped before the required fields.
+		assert.Equal(
+			t,
+			[]upsert{
+				{"role", "proxy"},
+				{"version", "v0.1.0"},
+				{"commit", "1a2b3c"},
+				{"build_date", "2024-06-01T12:00:00Z"},
+				{"go_version", "go1.22.4"},
+				{"label:region", "eu"},
+				{"rpc_addr", "10.26.104.56:8004"},
+				{"proxy_addr", "10.26.104.56:8000"},
+				{"admin_addr", "10.26.104.56:8001"},
+			},
+			gossiper.upserts,
+		)
+	})
+
+	t.Run("endpoints", func(t *testing.T) {
+		localNode := &cluster.Node{
+			ID:        "local",
+			ProxyAddr: "10.26.104.56:8000",
+			AdminAddr: "10.26.104.56:8001",
+		}
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		m.AddLocalEndpoint("my-endpoint")
+		m.AddLocalEndpoint("my-endpoint")
+		m.AddLocalEndpoint("my-endpoint")
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		assert.Equal(
+			t,
+			[]upsert{
+				{"proxy_addr", "10.26.104.56:8000"},
+				{"admin_addr", "10.26.104.56:8001"},
+				{"endpoint:my-endpoint", "3"},
+			},
+			gossiper.upserts,
+		)
+	})
 }
 
 func TestSyncer_OnLocalEndpointUpdate(t *testing.T) {
@@ -66,7 +135,7 @@ func TestSyncer_OnLocalEndpointUpdate(t *testing.T) {
 	}
 	m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-	sync := newSyncer(m, log.NewNopLogger())
+	sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 	gossiper := &fakeGossiper{}
 	sync.Sync(gossiper)
@@ -100,6 +169,483 @@ func TestSyncer_OnLocalEndpointUpdate(t *testing.T) {
 	)
 }
 
+func TestSyncer_OnLocalDrainingUpdate(t *testing.T) {
+	localNode := &cluster.Node{
+		ID:        "local",
+		ProxyAddr: "10.26.104.56:8000",
+		AdminAddr: "10.26.104.56:8001",
+	}
+	m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+
+	sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+
+	gossiper := &fakeGossiper{}
+	sync.Sync(gossiper)
+
+	m.SetLocalDraining(true)
+	assert.Equal(
+		t,
+		upsert{"draining", "true"},
+		gossiper.upserts[len(gossiper.upserts)-1],
+	)
+}
+
+func TestSyncer_Revocations(t *testing.T) {
+	localNode := &cluster.Node{
+		ID:        "local",
+		ProxyAddr: "10.26.104.56:8000",
+		AdminAddr: "10.26.104.56:8001",
+	}
+
+	t.Run("local revoke", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		revocations := revocation.NewList()
+
+		sync := newSyncer(m, revocations, log.NewNopLogger())
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+		revocations.Revoke(revocation.Revocation{
+			ID:     "my-token",
+			Expiry: expiry,
+		})
+
+		assert.Eventually(t, func() bool {
+			upserts := gossiper.Upserts()
+			return len(upserts) == 3 && upserts[2] == upsert{
+				"revoked:my-token", strconv.FormatInt(expiry.Unix(), 10),
+			}
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("remote revoke", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		revocations := revocation.NewList()
+
+		sync := newSyncer(m, revocations, log.NewNopLogger())
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		// Revocations are applied even if the node isn't known.
+		sync.OnUpsertKey("remote", "revoked:my-token", "0")
+		assert.True(t, revocations.Revoked("my-token"))
+
+		// The revocation is propagated by the local node.
+		assert.Eventually(t, func() bool {
+			upserts := gossiper.Upserts()
+			return len(upserts) == 3 &&
+				upserts[2] == upsert{"revoked:my-token", "0"}
+		}, time.Second, time.Millisecond)
+
+		// Deleting the remote revocation doesn't affect the local node.
+		sync.OnDeleteKey("remote", "revoked:my-token")
+		assert.True(t, revocations.Revoked("my-token"))
+	})
+
+	t.Run("remote revoke expired", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		revocations := revocation.NewList()
+
+		sync := newSyncer(m, revocations, log.NewNopLogger())
+		sync.Sync(&fakeGossiper{})
+
+		expiry := time.Now().Add(-time.Minute).Unix()
+		sync.OnUpsertKey(
+			"remote", "revoked:my-token", strconv.FormatInt(expiry, 10),
+		)
+		assert.False(t, revocations.Revoked("my-token"))
+	})
+
+	t.Run("sync existing", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		revocations := revocation.NewList()
+		revocations.Revoke(revocation.Revocation{ID: "my-token"})
+
+		sync := newSyncer(m, revocations, log.NewNopLogger())
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		assert.Equal(t, upsert{"revoked:my-token", "0"}, gossiper.Upserts()[2])
+	})
+}
+
+func TestSyncer_Visibility(t *testing.T) {
+	localNode := &cluster.Node{
+		ID:        "local",
+		ProxyAddr: "10.26.104.56:8000",
+		AdminAddr: "10.26.104.56:8001",
+	}
+
+	t.Run("local set", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		visibility := upstream.NewVisibilityList()
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.visibility = visibility
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		visibility.Set("my-endpoint", "public")
+		r, ok := visibility.Record("my-endpoint")
+		assert.True(t, ok)
+
+		assert.Eventually(t, func() bool {
+			upserts := gossiper.Upserts()
+			return len(upserts) == 3 && upserts[2] == upsert{
+				"visibility:my-endpoint", formatVisibility(r),
+			}
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("remote set", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		visibility := upstream.NewVisibilityList()
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.visibility = visibility
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		sync.OnUpsertKey("remote", "visibility:my-endpoint", "100:private")
+		v, ok := visibility.Lookup("my-endpoint")
+		assert.True(t, ok)
+		assert.Equal(t, "private", v)
+
+		// The visibility is propagated by the local node.
+		assert.Eventually(t, func() bool {
+			upserts := gossiper.Upserts()
+			return len(upserts) == 3 &&
+				upserts[2] == upsert{"visibility:my-endpoint", "100:private"}
+		}, time.Second, time.Millisecond)
+
+		// Older updates are ignored.
+		sync.OnUpsertKey("remote", "visibility:my-endpoint", "50:public")
+		v, _ = visibility.Lookup("my-endpoint")
+		assert.Equal(t, "private", v)
+
+		// Unsetting the visibility is propagated as an update.
+		sync.OnUpsertKey("remote", "visibility:my-endpoint", "200:")
+		_, ok = visibility.Lookup("my-endpoint")
+		assert.False(t, ok)
+
+		// Deleting the remote visibility doesn't affect the local node.
+		sync.OnUpsertKey("remote", "visibility:other-endpoint", "100:public")
+		sync.OnDeleteKey("remote", "visibility:other-endpoint")
+		_, ok = visibility.Lookup("other-endpoint")
+		assert.True(t, ok)
+	})
+
+	t.Run("remote set invalid", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		visibility := upstream.NewVisibilityList()
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.visibility = visibility
+		sync.Sync(&fakeGossiper{})
+
+		for _, value := range []string{"public", "abc:public", "100:unknown"} {
+			sync.OnUpsertKey("remote", "visibility:my-endpoint", value)
+		}
+		_, ok := visibility.Lookup("my-endpoint")
+		assert.False(t, ok)
+	})
+
+	t.Run("remote set disabled", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		sync.OnUpsertKey("remote", "visibility:my-endpoint", "100:private")
+		assert.Len(t, gossiper.Upserts(), 2)
+	})
+
+	t.Run("sync existing", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		visibility := upstream.NewVisibilityList()
+		visibility.Apply(upstream.VisibilityRecord{
+			EndpointID: "my-endpoint",
+			Visibility: "public",
+			Updated:    time.Unix(0, 100),
+		})
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.visibility = visibility
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		assert.Equal(
+			t,
+			upsert{"visibility:my-endpoint", "100:public"},
+			gossiper.Upserts()[2],
+		)
+	})
+}
+
+func TestSyncer_Approvals(t *testing.T) {
+	localNode := &cluster.Node{
+		ID:        "local",
+		ProxyAddr: "10.26.104.56:8000",
+		AdminAddr: "10.26.104.56:8001",
+	}
+
+	t.Run("local approve", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		approvals := upstream.NewApprovalList(nil)
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.approvals = approvals
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		approvals.Approve("my-endpoint")
+		r, ok := approvals.Record("my-endpoint")
+		assert.True(t, ok)
+
+		assert.Eventually(t, func() bool {
+			upserts := gossiper.Upserts()
+			return len(upserts) == 3 && upserts[2] == upsert{
+				"approval:my-endpoint", formatApproval(r),
+			}
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("remote approve", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		approvals := upstream.NewApprovalList(nil)
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.approvals = approvals
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		approved, cancel := approvals.Wait("my-endpoint", "10.26.104.10")
+		defer cancel()
+
+		// Approvals are applied even if the node isn't known.
+		sync.OnUpsertKey("remote", "approval:my-endpoint", "100:approved")
+		assert.True(t, approvals.Approved("my-endpoint"))
+
+		// Pending upstreams are released.
+		select {
+		case <-approved:
+		default:
+			t.Fatal("upstream not approved")
+		}
+
+		// The approval is propagated by the local node.
+		assert.Eventually(t, func() bool {
+			upserts := gossiper.Upserts()
+			return len(upserts) == 3 &&
+				upserts[2] == upsert{"approval:my-endpoint", "100:approved"}
+		}, time.Second, time.Millisecond)
+
+		// Older updates are ignored.
+		sync.OnUpsertKey("remote", "approval:my-endpoint", "50:revoked")
+		assert.True(t, approvals.Approved("my-endpoint"))
+
+		// Revoking the approval is propagated as an update.
+		sync.OnUpsertKey("remote", "approval:my-endpoint", "200:revoked")
+		assert.False(t, approvals.Approved("my-endpoint"))
+
+		// Deleting the remote approval doesn't affect the local node.
+		sync.OnUpsertKey("remote", "approval:other-endpoint", "100:approved")
+		sync.OnDeleteKey("remote", "approval:other-endpoint")
+		assert.True(t, approvals.Approved("other-endpoint"))
+	})
+
+	t.Run("remote approve invalid", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		approvals := upstream.NewApprovalList(nil)
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.approvals = approvals
+		sync.Sync(&fakeGossiper{})
+
+		for _, value := range []string{"approved", "abc:approved", "100:unknown"} {
+			sync.OnUpsertKey("remote", "approval:my-endpoint", value)
+		}
+		assert.False(t, approvals.Approved("my-endpoint"))
+	})
+
+	t.Run("remote approve disabled", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		sync.OnUpsertKey("remote", "approval:my-endpoint", "100:approved")
+		assert.Len(t, gossiper.Upserts(), 2)
+	})
+
+	t.Run("sync existing", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		approvals := upstream.NewApprovalList(nil)
+		approvals.Apply(upstream.ApprovalRecord{
+			EndpointID: "my-endpoint",
+			Approved:   true,
+			Updated:    time.Unix(0, 100),
+		})
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.approvals = approvals
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		assert.Equal(
+			t,
+			upsert{"approval:my-endpoint", "100:approved"},
+			gossiper.Upserts()[2],
+		)
+	})
+}
+
+func TestSyncer_Bans(t *testing.T) {
+	localNode := &cluster.Node{
+		ID:        "local",
+		ProxyAddr: "10.26.104.56:8000",
+		AdminAddr: "10.26.104.56:8001",
+	}
+
+	t.Run("local ban", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		bans := upstream.NewBanList()
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.bans = bans
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		b := bans.Ban("my-tenant/my-endpoint", "10.26.104.10", time.Hour)
+
+		assert.Eventually(t, func() bool {
+			upserts := gossiper.Upserts()
+			return len(upserts) == 3 && upserts[2] == upsert{
+				"banned:10.26.104.10/my-tenant/my-endpoint",
+				strconv.FormatInt(b.Expiry.Unix(), 10),
+			}
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("local ban expired", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		bans := upstream.NewBanList()
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.bans = bans
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		bans.Ban("my-endpoint", "10.26.104.10", time.Millisecond)
+		time.Sleep(time.Millisecond * 2)
+		_, ok := bans.Banned("my-endpoint", "10.26.104.10")
+		assert.False(t, ok)
+
+		assert.Eventually(t, func() bool {
+			deletes := gossiper.Deletes()
+			return len(deletes) > 0 &&
+				deletes[len(deletes)-1] == "banned:10.26.104.10/my-endpoint"
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("remote ban", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		bans := upstream.NewBanList()
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.bans = bans
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		expiry := time.Now().Add(time.Hour).Unix()
+		value := strconv.FormatInt(expiry, 10)
+
+		// Bans are applied even if the node isn't known.
+		sync.OnUpsertKey(
+			"remote", "banned:10.26.104.10/my-tenant/my-endpoint", value,
+		)
+		e, ok := bans.Banned("my-tenant/my-endpoint", "10.26.104.10")
+		assert.True(t, ok)
+		assert.Equal(t, expiry, e.Unix())
+
+		// The ban is propagated by the local node.
+		assert.Eventually(t, func() bool {
+			upserts := gossiper.Upserts()
+			return len(upserts) == 3 && upserts[2] == upsert{
+				"banned:10.26.104.10/my-tenant/my-endpoint", value,
+			}
+		}, time.Second, time.Millisecond)
+
+		// Shorter bans are ignored.
+		sync.OnUpsertKey(
+			"remote",
+			"banned:10.26.104.10/my-tenant/my-endpoint",
+			strconv.FormatInt(expiry-60, 10),
+		)
+		e, _ = bans.Banned("my-tenant/my-endpoint", "10.26.104.10")
+		assert.Equal(t, expiry, e.Unix())
+
+		// Deleting the remote ban doesn't affect the local node.
+		sync.OnDeleteKey("remote", "banned:10.26.104.10/my-tenant/my-endpoint")
+		_, ok = bans.Banned("my-tenant/my-endpoint", "10.26.104.10")
+		assert.True(t, ok)
+	})
+
+	t.Run("remote ban invalid", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		bans := upstream.NewBanList()
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.bans = bans
+		sync.Sync(&fakeGossiper{})
+
+		expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+		for _, kv := range [][2]string{
+			{"banned:10.26.104.10/my-endpoint", "abc"},
+			{"banned:10.26.104.10/my-endpoint", "0"},
+			{"banned:10.26.104.10/my-endpoint", expired},
+			{"banned:10.26.104.10", "100"},
+		} {
+			sync.OnUpsertKey("remote", kv[0], kv[1])
+		}
+		assert.Empty(t, bans.Bans())
+	})
+
+	t.Run("sync existing", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		bans := upstream.NewBanList()
+		b := bans.Ban("my-endpoint", "10.26.104.10", time.Hour)
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+		sync.bans = bans
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		assert.Equal(t, upsert{
+			"banned:10.26.104.10/my-endpoint",
+			strconv.FormatInt(b.Expiry.Unix(), 10),
+		}, gossiper.Upserts()[2])
+	})
+}
+
 func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 	t.Run("add node", func(t *testing.T) {
 		localNode := &cluster.Node{
@@ -109,7 +655,7 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -140,7 +686,7 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -162,7 +708,7 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -184,7 +730,7 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -212,6 +758,72 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 			},
 		})
 	})
+
+	t.Run("update node draining", func(t *testing.T) {
+		localNode := &cluster.Node{
+			ID:        "local",
+			Status:    cluster.NodeStatusActive,
+			ProxyAddr: "10.26.104.56:8000",
+			AdminAddr: "10.26.104.56:8001",
+		}
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		sync.OnJoin("remote")
+		sync.OnUpsertKey("remote", "proxy_addr", "10.26.104.98:8000")
+		sync.OnUpsertKey("remote", "admin_addr", "10.26.104.98:8001")
+
+		node, ok := m.Node("remote")
+		assert.True(t, ok)
+		assert.False(t, node.Draining)
+
+		sync.OnUpsertKey("remote", "draining", "true")
+
+		node, ok = m.Node("remote")
+		assert.True(t, ok)
+		assert.True(t, node.Draining)
+	})
+
+	t.Run("add node optional fields", func(t *testing.T) {
+		localNode := &cluster.Node{
+			ID:        "local",
+			Status:    cluster.NodeStatusActive,
+			ProxyAddr: "10.26.104.56:8000",
+			AdminAddr: "10.26.104.56:8001",
+		}
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		sync.OnJoin("remote")
+		sync.OnUpsertKey("remote", "role", "proxy")
+		sync.OnUpsertKey("remote", "version", "v0.1.0")
+		sync.OnUpsertKey("remote", "commit", "1a2b3c")
+		sync.OnUpsertKey("remote", "build_date", "2024-06-01T12:00:00Z")
+		sync.OnUpsertKey("remote", "go_version", "go1.22.4")
+		sync.OnUpsertKey("remote", "label:region", "eu")
+		sync.OnUpsertKey("remote", "rpc_addr", "10.26.104.98:8004")
+		sync.OnUpsertKey("remote", "proxy_addr", "10.26.104.98:8000")
+		sync.OnUpsertKey("remote", "admin_addr", "10.26.104.98:8001")
+
+		node, ok := m.Node("remote")
+		assert.True(t, ok)
+		assert.Equal(t, cluster.NodeRoleProxy, node.Role)
+		assert.Equal(t, "v0.1.0", node.Version)
+		assert.Equal(t, "1a2b3c", node.Commit)
+		assert.Equal(t, "2024-06-01T12:00:00Z", node.BuildDate)
+		assert.Equal(t, "go1.22.4", node.GoVersion)
+		assert.Equal(t, map[string]string{"region": "eu"}, node.Labels)
+		assert.Equal(t, "10.26.104.98:8004", node.RPCAddr)
+		assert.False(t, node.AcceptsUpstreams())
+	})
 }
 
 func TestSyncer_RemoteNodeLeave(t *testing.T) {
@@ -223,7 +835,7 @@ func TestSyncer_RemoteNodeLeave(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -264,7 +876,7 @@ func TestSyncer_RemoteNodeLeave(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -291,7 +903,7 @@ func TestSyncer_RemoteNodeLeave(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -312,7 +924,7 @@ func TestSyncer_RemoteNodeUnreachable(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -353,7 +965,7 @@ func TestSyncer_RemoteNodeUnreachable(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -391,7 +1003,7 @@ func TestSyncer_RemoteNodeUnreachable(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -424,7 +1036,7 @@ func TestSyncer_RemoteNodeUnreachable(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -452,7 +1064,7 @@ func TestSyncer_RemoteNodeUnreachable(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, revocation.NewList(), log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
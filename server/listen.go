@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/andydunstall/piko/server/config"
+)
+
+// listen listens on the given bind address, which may be either a TCP
+// address or a Unix domain socket (such as 'unix:///run/piko/proxy.sock').
+func listen(bindAddr string) (net.Listener, error) {
+	path, ok := config.UnixSocketPath(bindAddr)
+	if !ok {
+		return net.Listen("tcp", bindAddr)
+	}
+
+	// If the node didn't shut down cleanly, the socket file may still exist
+	// which would cause listen to fail. Only remove the file if it is a
+	// socket that no process is listening on.
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket in use")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+
+	return net.Listen("unix", path)
+}
+
+// listenAddr returns the address the listener is bound to, in the same
+// format as the bind address.
+func listenAddr(ln net.Listener) string {
+	if ln.Addr().Network() == "unix" {
+		return "unix://" + ln.Addr().String()
+	}
+	return ln.Addr().String()
+}
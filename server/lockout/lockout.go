@@ -0,0 +1,189 @@
+package lockout
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
+	"go.uber.org/zap"
+)
+
+type errorMessage struct {
+	Error string `json:"error"`
+}
+
+type client struct {
+	// failures is the number of failed attempts since windowStart.
+	failures    int
+	windowStart time.Time
+
+	// lockedUntil is the time the client is locked out until, or zero if
+	// the client isn't locked out.
+	lockedUntil time.Time
+}
+
+// Lockout throttles repeated failed authentication attempts from the same
+// client IP, to slow down guessing credentials.
+//
+// Once a client exceeds the maximum failed attempts within the window, all
+// requests from the client are rejected without attempting authentication
+// until the lockout expires.
+//
+// Failures are tracked on the local node only.
+type Lockout struct {
+	conf config.LockoutConfig
+
+	clients map[string]*client
+
+	// lastSweep is the time expired clients were last removed.
+	lastSweep time.Time
+
+	mu sync.Mutex
+
+	// now returns the current time, which may be overridden in tests.
+	now func() time.Time
+
+	metrics *Metrics
+
+	logger log.Logger
+}
+
+// NewLockout creates a lockout for the listener with the given subsystem,
+// such as 'proxy'.
+func NewLockout(
+	conf config.LockoutConfig,
+	subsystem string,
+	logger log.Logger,
+) *Lockout {
+	return &Lockout{
+		conf:      conf,
+		clients:   make(map[string]*client),
+		lastSweep: time.Now(),
+		now:       time.Now,
+		metrics:   NewMetrics(subsystem),
+		logger:    logger.WithSubsystem(subsystem + ".lockout"),
+	}
+}
+
+func (l *Lockout) Metrics() *Metrics {
+	return l.metrics
+}
+
+// Locked returns the duration until the client is no longer locked out, or
+// zero if the client isn't locked out.
+func (l *Lockout) Locked(clientIP string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[clientIP]
+	if !ok {
+		return 0
+	}
+	if wait := c.lockedUntil.Sub(l.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Allow returns whether the client may attempt to authenticate. If the
+// client is locked out, writes a '429 Too Many Requests' response and
+// returns false.
+func (l *Lockout) Allow(w http.ResponseWriter, clientIP string) bool {
+	wait := l.Locked(clientIP)
+	if wait == 0 {
+		return true
+	}
+
+	l.metrics.LockedOutRequestsTotal.Inc()
+
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	_ = json.NewEncoder(w).Encode(&errorMessage{
+		Error: "too many failed authentication attempts",
+	})
+	return false
+}
+
+// Failed records a failed authentication attempt from the client, and locks
+// out the client if it exceeds the maximum failed attempts.
+func (l *Lockout) Failed(clientIP string) {
+	l.metrics.AuthFailuresTotal.Inc()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweepLocked(now)
+
+	c, ok := l.clients[clientIP]
+	if !ok {
+		c = &client{}
+		l.clients[clientIP] = c
+	}
+	if now.Before(c.lockedUntil) {
+		return
+	}
+	if now.Sub(c.windowStart) > l.conf.Window {
+		c.failures = 0
+		c.windowStart = now
+	}
+
+	c.failures++
+	if c.failures < l.conf.MaxFailures {
+		return
+	}
+
+	c.lockedUntil = now.Add(l.conf.Duration)
+	c.failures = 0
+	c.windowStart = time.Time{}
+
+	l.metrics.LockoutsTotal.Inc()
+	l.logger.Warn(
+		"client locked out after failed authentication attempts",
+		zap.String("client-ip", clientIP),
+		zap.Int("failures", l.conf.MaxFailures),
+		zap.Duration("duration", l.conf.Duration),
+	)
+}
+
+// Succeeded records a successful authentication from the client, which
+// resets its failed attempts.
+func (l *Lockout) Succeeded(clientIP string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[clientIP]
+	if !ok {
+		return
+	}
+	if l.now().Before(c.lockedUntil) {
+		return
+	}
+	delete(l.clients, clientIP)
+}
+
+// sweepLocked removes clients that are neither locked out nor have failed
+// attempts within the window, so idle clients don't accumulate.
+func (l *Lockout) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < l.conf.Window {
+		return
+	}
+	l.lastSweep = now
+
+	for clientIP, c := range l.clients {
+		if now.Before(c.lockedUntil) {
+			continue
+		}
+		if now.Sub(c.windowStart) <= l.conf.Window {
+			continue
+		}
+		delete(l.clients, clientIP)
+	}
+}
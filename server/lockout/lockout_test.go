@@ -0,0 +1,106 @@
+package lockout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLockout(now *time.Time) *Lockout {
+	l := NewLockout(config.LockoutConfig{
+		MaxFailures: 3,
+		Window:      time.Minute,
+		Duration:    time.Minute * 5,
+	}, "proxy", log.NewNopLogger())
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestLockout(t *testing.T) {
+	t.Run("lock out", func(t *testing.T) {
+		now := time.Now()
+		l := newTestLockout(&now)
+
+		l.Failed("10.26.104.14")
+		l.Failed("10.26.104.14")
+		assert.Equal(t, time.Duration(0), l.Locked("10.26.104.14"))
+
+		l.Failed("10.26.104.14")
+		assert.Equal(t, time.Minute*5, l.Locked("10.26.104.14"))
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(l.metrics.LockoutsTotal))
+		assert.Equal(t, 3.0, promtestutil.ToFloat64(l.metrics.AuthFailuresTotal))
+
+		// Other clients aren't affected.
+		assert.Equal(t, time.Duration(0), l.Locked("10.26.104.15"))
+
+		// Succeeding doesn't reset an active lockout.
+		l.Succeeded("10.26.104.14")
+		assert.Equal(t, time.Minute*5, l.Locked("10.26.104.14"))
+
+		now = now.Add(time.Minute * 5)
+		assert.Equal(t, time.Duration(0), l.Locked("10.26.104.14"))
+
+		// Failures before the lockout aren't counted again.
+		l.Failed("10.26.104.14")
+		assert.Equal(t, time.Duration(0), l.Locked("10.26.104.14"))
+	})
+
+	t.Run("window expires", func(t *testing.T) {
+		now := time.Now()
+		l := newTestLockout(&now)
+
+		l.Failed("10.26.104.14")
+		l.Failed("10.26.104.14")
+
+		now = now.Add(time.Minute * 2)
+		l.Failed("10.26.104.14")
+		assert.Equal(t, time.Duration(0), l.Locked("10.26.104.14"))
+	})
+
+	t.Run("success resets failures", func(t *testing.T) {
+		now := time.Now()
+		l := newTestLockout(&now)
+
+		l.Failed("10.26.104.14")
+		l.Failed("10.26.104.14")
+		l.Succeeded("10.26.104.14")
+		l.Failed("10.26.104.14")
+		assert.Equal(t, time.Duration(0), l.Locked("10.26.104.14"))
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		now := time.Now()
+		l := newTestLockout(&now)
+
+		l.Failed("10.26.104.14")
+		now = now.Add(time.Minute * 2)
+		l.Failed("10.26.104.15")
+
+		assert.Len(t, l.clients, 1)
+	})
+}
+
+func TestLockout_Allow(t *testing.T) {
+	now := time.Now()
+	l := newTestLockout(&now)
+
+	w := httptest.NewRecorder()
+	assert.True(t, l.Allow(w, "10.26.104.14"))
+
+	for i := 0; i != 3; i++ {
+		l.Failed("10.26.104.14")
+	}
+	now = now.Add(time.Millisecond * 1500)
+
+	w = httptest.NewRecorder()
+	assert.False(t, l.Allow(w, "10.26.104.14"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "299", w.Header().Get("Retry-After"))
+	assert.Equal(t, 1.0, promtestutil.ToFloat64(l.metrics.LockedOutRequestsTotal))
+}
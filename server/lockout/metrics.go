@@ -0,0 +1,56 @@
+package lockout
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type Metrics struct {
+	// AuthFailuresTotal is the total number of failed authentication
+	// attempts.
+	AuthFailuresTotal prometheus.Counter
+
+	// LockoutsTotal is the total number of clients locked out after
+	// exceeding the maximum failed authentication attempts.
+	LockoutsTotal prometheus.Counter
+
+	// LockedOutRequestsTotal is the total number of requests rejected as
+	// the client is locked out.
+	LockedOutRequestsTotal prometheus.Counter
+}
+
+// NewMetrics creates the lockout metrics for the listener with the given
+// subsystem, such as 'proxy'.
+func NewMetrics(subsystem string) *Metrics {
+	return &Metrics{
+		AuthFailuresTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: subsystem,
+				Name:      "auth_failures_total",
+				Help:      "Total number of failed authentication attempts",
+			},
+		),
+		LockoutsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: subsystem,
+				Name:      "auth_lockouts_total",
+				Help:      "Total number of clients locked out after repeated failed authentication attempts",
+			},
+		),
+		LockedOutRequestsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: subsystem,
+				Name:      "auth_locked_out_requests_total",
+				Help:      "Total number of requests rejected as the client is locked out",
+			},
+		),
+	}
+}
+
+func (m *Metrics) Register(registry *prometheus.Registry) {
+	registry.MustRegister(
+		m.AuthFailuresTotal,
+		m.LockoutsTotal,
+		m.LockedOutRequestsTotal,
+	)
+}
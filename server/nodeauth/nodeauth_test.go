@@ -0,0 +1,142 @@
+package nodeauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestNodeAuthenticator(t *testing.T) {
+	t.Run("request", func(t *testing.T) {
+		auth := NewAuthenticator("my-token")
+
+		r := httptest.NewRequest(http.MethodGet, "/foo?bar=baz", nil)
+		auth.SignRequest(r)
+		assert.NotEmpty(t, r.Header.Get(Header))
+
+		assert.True(t, auth.VerifyRequest(r))
+		// The credential must be removed from the request.
+		assert.Empty(t, r.Header.Get(Header))
+	})
+
+	t.Run("request modified", func(t *testing.T) {
+		auth := NewAuthenticator("my-token")
+
+		r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		auth.SignRequest(r)
+
+		modified := httptest.NewRequest(http.MethodGet, "/bar", nil)
+		modified.Header.Set(Header, r.Header.Get(Header))
+		assert.False(t, auth.VerifyRequest(modified))
+	})
+
+	t.Run("request invalid token", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		NewAuthenticator("invalid-token").SignRequest(r)
+
+		assert.False(t, NewAuthenticator("my-token").VerifyRequest(r))
+	})
+
+	t.Run("request missing credential", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		assert.False(t, NewAuthenticator("my-token").VerifyRequest(r))
+	})
+
+	t.Run("forwarded request", func(t *testing.T) {
+		auth := NewAuthenticator("my-token")
+
+		r := httptest.NewRequest(http.MethodPut, "/log", nil)
+		auth.SignForwardedRequest(r, "10.26.104.10")
+
+		clientIP, ok := auth.VerifyForwardedRequest(r)
+		assert.True(t, ok)
+		assert.Equal(t, "10.26.104.10", clientIP)
+		// The credential and client IP must be removed from the request.
+		assert.Empty(t, r.Header.Get(Header))
+		assert.Empty(t, r.Header.Get(ForwardedForHeader))
+	})
+
+	t.Run("forwarded request modified client ip", func(t *testing.T) {
+		auth := NewAuthenticator("my-token")
+
+		r := httptest.NewRequest(http.MethodPut, "/log", nil)
+		auth.SignForwardedRequest(r, "10.26.104.10")
+		r.Header.Set(ForwardedForHeader, "10.26.104.11")
+
+		_, ok := auth.VerifyForwardedRequest(r)
+		assert.False(t, ok)
+	})
+
+	t.Run("forwarded request not interchangeable", func(t *testing.T) {
+		auth := NewAuthenticator("my-token")
+
+		// A credential for a proxied request can't be used to report a
+		// client IP.
+		r := httptest.NewRequest(http.MethodPut, "/log", nil)
+		auth.SignRequest(r)
+		r.Header.Set(ForwardedForHeader, "10.26.104.10")
+
+		_, ok := auth.VerifyForwardedRequest(r)
+		assert.False(t, ok)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		auth := NewAuthenticator("my-token")
+
+		now := time.Now()
+		credential := auth.credential(now.Add(-tolerance*2), "http", "/foo")
+		assert.False(t, auth.verify(credential, now, "http", "/foo"))
+
+		credential = auth.credential(now.Add(tolerance*2), "http", "/foo")
+		assert.False(t, auth.verify(credential, now, "http", "/foo"))
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		auth := NewAuthenticator("my-token")
+
+		now := time.Now()
+		for _, credential := range []string{
+			"",
+			"123",
+			"abc.def",
+			"123.xyz",
+		} {
+			assert.False(t, auth.verify(credential, now, "http", "/foo"))
+		}
+	})
+
+	t.Run("rpc", func(t *testing.T) {
+		auth := NewAuthenticator("my-token")
+
+		ctx := auth.SignRPC(context.Background(), "/piko.proxy.Proxy/Nodes")
+		md, _ := metadata.FromOutgoingContext(ctx)
+		ctx = metadata.NewIncomingContext(context.Background(), md)
+
+		assert.True(t, auth.VerifyRPC(ctx, "/piko.proxy.Proxy/Nodes"))
+		assert.False(t, auth.VerifyRPC(ctx, "/piko.proxy.Proxy/Forward"))
+	})
+
+	t.Run("rpc missing credential", func(t *testing.T) {
+		auth := NewAuthenticator("my-token")
+		assert.False(t, auth.VerifyRPC(context.Background(), "/piko.proxy.Proxy/Nodes"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		auth := NewAuthenticator("")
+		assert.False(t, auth.Enabled())
+
+		r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		auth.SignRequest(r)
+		assert.Empty(t, r.Header.Get(Header))
+
+		// A disabled authenticator must never authenticate requests.
+		r.Header.Set(Header, "123.abc")
+		assert.False(t, auth.VerifyRequest(r))
+		assert.False(t, auth.VerifyRPC(context.Background(), "/piko.proxy.Proxy/Nodes"))
+	})
+}
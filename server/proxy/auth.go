@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/andydunstall/piko/server/lockout"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// clientTokenHeader is the header containing the proxy client token.
+	//
+	// A separate header to 'Authorization' is used so clients can still
+	// authenticate with the upstream service itself.
+	clientTokenHeader = "x-piko-authorization"
+)
+
+// clientAuthenticator verifies proxy client tokens, and that the token
+// permits access to the requested endpoint.
+type clientAuthenticator struct {
+	verifier auth.Verifier
+
+	// lockout locks out clients after repeated failed authentication
+	// attempts, or is nil if clients are never locked out.
+	lockout *lockout.Lockout
+
+	rejected *prometheus.CounterVec
+
+	logger log.Logger
+}
+
+func newClientAuthenticator(
+	verifier auth.Verifier,
+	lockout *lockout.Lockout,
+	rejected *prometheus.CounterVec,
+	logger log.Logger,
+) *clientAuthenticator {
+	return &clientAuthenticator{
+		verifier: verifier,
+		lockout:  lockout,
+		rejected: rejected,
+		logger:   logger,
+	}
+}
+
+// Authenticate verifies the request token permits access to the given
+// endpoint, and returns the verified token. If not, writes an error response
+// and returns false.
+//
+// Missing or invalid tokens count as failed attempts from the client IP, so
+// the client may be locked out.
+//
+// If tcp is true, the token may also be given in the 'Authorization' header,
+// since TCP connections are made by Piko clients rather than proxied from
+// the downstream client, so the header isn't used by the upstream.
+func (a *clientAuthenticator) Authenticate(
+	w http.ResponseWriter,
+	r *http.Request,
+	clientIP string,
+	endpointID string,
+	tcp bool,
+) (auth.EndpointToken, bool) {
+	if a.lockout != nil && !a.lockout.Allow(w, clientIP) {
+		a.rejected.With(prometheus.Labels{"reason": "locked_out"}).Inc()
+		return auth.EndpointToken{}, false
+	}
+
+	tokenString := clientToken(r, tcp)
+	if tokenString == "" {
+		a.logger.Debug(
+			"missing client token",
+			zap.String("endpoint-id", endpointID),
+		)
+		a.failed(clientIP)
+		a.reject(w, http.StatusUnauthorized, "missing token")
+		return auth.EndpointToken{}, false
+	}
+
+	token, err := a.verifier.VerifyEndpointToken(tokenString)
+	if err != nil {
+		a.logger.Warn(
+			"invalid client token",
+			zap.String("endpoint-id", endpointID),
+			zap.String("client-ip", clientIP),
+			zap.Error(err),
+		)
+		a.failed(clientIP)
+		if errors.Is(err, auth.ErrExpiredToken) {
+			a.reject(w, http.StatusUnauthorized, "expired token")
+		} else if errors.Is(err, auth.ErrRevokedToken) {
+			a.reject(w, http.StatusUnauthorized, "revoked token")
+		} else {
+			a.reject(w, http.StatusUnauthorized, "invalid token")
+		}
+		return auth.EndpointToken{}, false
+	}
+
+	if !token.EndpointPermitted(endpointID) {
+		a.logger.Warn(
+			"endpoint not permitted",
+			zap.Strings("token-endpoints", token.Endpoints),
+			zap.String("endpoint-id", endpointID),
+		)
+		a.reject(w, http.StatusForbidden, "endpoint not permitted")
+		return auth.EndpointToken{}, false
+	}
+
+	if a.lockout != nil {
+		a.lockout.Succeeded(clientIP)
+	}
+	return token, true
+}
+
+func (a *clientAuthenticator) failed(clientIP string) {
+	if a.lockout != nil {
+		a.lockout.Failed(clientIP)
+	}
+}
+
+func (a *clientAuthenticator) reject(
+	w http.ResponseWriter,
+	statusCode int,
+	message string,
+) {
+	a.rejected.With(prometheus.Labels{"reason": "unauthorized"}).Inc()
+	_ = errorResponse(w, statusCode, message)
+}
+
+// clientToken returns the proxy client token from the request, or an empty
+// string if no token is given. The 'Bearer' prefix is optional.
+func clientToken(r *http.Request, tcp bool) string {
+	header := r.Header.Get(clientTokenHeader)
+	if header == "" && tcp {
+		header = r.Header.Get("Authorization")
+	}
+	return strings.TrimPrefix(header, "Bearer ")
+}
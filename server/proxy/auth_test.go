@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/lockout"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeVerifier struct {
+	handler func(token string) (auth.EndpointToken, error)
+}
+
+func (v *fakeVerifier) VerifyEndpointToken(token string) (auth.EndpointToken, error) {
+	return v.handler(token)
+}
+
+var _ auth.Verifier = &fakeVerifier{}
+
+func TestClientAuthenticator(t *testing.T) {
+	verifier := &fakeVerifier{
+		handler: func(token string) (auth.EndpointToken, error) {
+			switch token {
+			case "valid":
+				return auth.EndpointToken{
+					Expiry:    time.Now().Add(time.Hour),
+					Endpoints: []string{"my-endpoint"},
+				}, nil
+			case "expired":
+				return auth.EndpointToken{}, fmt.Errorf("foo: %w", auth.ErrExpiredToken)
+			default:
+				return auth.EndpointToken{}, fmt.Errorf("foo: %w", auth.ErrInvalidToken)
+			}
+		},
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		token      string
+		endpointID string
+		tcp        bool
+		statusCode int
+		message    string
+	}{
+		{
+			name:       "ok",
+			header:     "x-piko-authorization",
+			token:      "Bearer valid",
+			endpointID: "my-endpoint",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "ok without bearer prefix",
+			header:     "x-piko-authorization",
+			token:      "valid",
+			endpointID: "my-endpoint",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "tcp authorization header",
+			header:     "Authorization",
+			token:      "Bearer valid",
+			endpointID: "my-endpoint",
+			tcp:        true,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "http authorization header",
+			header:     "Authorization",
+			token:      "Bearer valid",
+			endpointID: "my-endpoint",
+			statusCode: http.StatusUnauthorized,
+			message:    "missing token",
+		},
+		{
+			name:       "missing token",
+			endpointID: "my-endpoint",
+			statusCode: http.StatusUnauthorized,
+			message:    "missing token",
+		},
+		{
+			name:       "invalid token",
+			header:     "x-piko-authorization",
+			token:      "Bearer invalid",
+			endpointID: "my-endpoint",
+			statusCode: http.StatusUnauthorized,
+			message:    "invalid token",
+		},
+		{
+			name:       "expired token",
+			header:     "x-piko-authorization",
+			token:      "Bearer expired",
+			endpointID: "my-endpoint",
+			statusCode: http.StatusUnauthorized,
+			message:    "expired token",
+		},
+		{
+			name:       "endpoint not permitted",
+			header:     "x-piko-authorization",
+			token:      "Bearer valid",
+			endpointID: "other-endpoint",
+			statusCode: http.StatusForbidden,
+			message:    "endpoint not permitted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := NewMetrics()
+			a := newClientAuthenticator(
+				verifier, nil, metrics.RejectedRequestsTotal, log.NewNopLogger(),
+			)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.token)
+			}
+			w := httptest.NewRecorder()
+
+			_, ok := a.Authenticate(w, r, "10.26.104.14", tt.endpointID, tt.tcp)
+			assert.Equal(t, tt.statusCode == http.StatusOK, ok)
+
+			rejected := promtestutil.ToFloat64(
+				metrics.RejectedRequestsTotal.With(prometheus.Labels{
+					"reason": "unauthorized",
+				}),
+			)
+			if ok {
+				assert.Equal(t, 0.0, rejected)
+				return
+			}
+			assert.Equal(t, 1.0, rejected)
+
+			resp := w.Result()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+
+			var m errorMessage
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
+			assert.Equal(t, tt.message, m.Error)
+		})
+	}
+}
+
+func TestClientAuthenticator_Lockout(t *testing.T) {
+	verifier := &fakeVerifier{
+		handler: func(token string) (auth.EndpointToken, error) {
+			if token == "valid" {
+				return auth.EndpointToken{
+					Expiry:    time.Now().Add(time.Hour),
+					Endpoints: []string{"my-endpoint"},
+				}, nil
+			}
+			return auth.EndpointToken{}, fmt.Errorf("foo: %w", auth.ErrInvalidToken)
+		},
+	}
+
+	metrics := NewMetrics()
+	a := newClientAuthenticator(
+		verifier,
+		lockout.NewLockout(config.LockoutConfig{
+			MaxFailures: 2,
+			Window:      time.Minute,
+			Duration:    time.Minute,
+		}, "proxy", log.NewNopLogger()),
+		metrics.RejectedRequestsTotal,
+		log.NewNopLogger(),
+	)
+
+	authenticate := func(clientIP string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("x-piko-authorization", token)
+		w := httptest.NewRecorder()
+		_, _ = a.Authenticate(w, r, clientIP, "my-endpoint", false)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, authenticate("10.26.104.14", "invalid").Code)
+	assert.Equal(t, http.StatusUnauthorized, authenticate("10.26.104.14", "invalid").Code)
+
+	// Once locked out, even valid tokens are rejected.
+	w := authenticate("10.26.104.14", "valid")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, 1.0, promtestutil.ToFloat64(
+		metrics.RejectedRequestsTotal.With(prometheus.Labels{
+			"reason": "locked_out",
+		}),
+	))
+
+	// Other clients aren't affected.
+	assert.Equal(t, http.StatusOK, authenticate("10.26.104.15", "valid").Code)
+}
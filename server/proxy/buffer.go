@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"net/http/httputil"
+	"sync"
+)
+
+// bufferSize is the size of the buffers used to copy request and response
+// bodies, which matches the buffer size used by io.Copy.
+const bufferSize = 32 * 1024
+
+// bufferPool is a pool of buffers used to copy bodies.
+//
+// Without a pool each proxied request allocates a new buffer to copy the
+// response body (and the request body when forwarding using gRPC), which
+// adds significant garbage collection overhead under load.
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{
+		pool: sync.Pool{
+			New: func() any {
+				b := make([]byte, size)
+				return &b
+			},
+		},
+	}
+}
+
+// Get returns a buffer from the pool, or allocates a new buffer if the pool
+// is empty.
+func (p *bufferPool) Get() []byte {
+	return *p.pool.Get().(*[]byte)
+}
+
+// Put returns the buffer to the pool. The buffer must not be used after it
+// is returned.
+func (p *bufferPool) Put(b []byte) {
+	p.pool.Put(&b)
+}
+
+// buffers is the buffer pool shared by the proxies.
+var buffers = newBufferPool(bufferSize)
+
+var _ httputil.BufferPool = &bufferPool{}
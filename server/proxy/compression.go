@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/andydunstall/piko/pkg/compress"
+	"github.com/andydunstall/piko/server/upstream"
+)
+
+// tunnelTransport compresses requests to upstreams that negotiated
+// compression when connecting, using the negotiated encoding.
+type tunnelTransport struct {
+	transport http.RoundTripper
+
+	// compressTransports contains a transport for each supported encoding.
+	compressTransports map[string]http.RoundTripper
+}
+
+func newTunnelTransport(transport http.RoundTripper) *tunnelTransport {
+	return &tunnelTransport{
+		transport: transport,
+		compressTransports: map[string]http.RoundTripper{
+			"gzip": compress.NewTransport(transport, "gzip"),
+			"zstd": compress.NewTransport(transport, "zstd"),
+		},
+	}
+}
+
+func (t *tunnelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u, ok := req.Context().Value(upstreamContextKey).(*upstream.ConnUpstream)
+	if !ok {
+		return t.transport.RoundTrip(req)
+	}
+	transport, ok := t.compressTransports[u.Compression()]
+	if !ok {
+		return t.transport.RoundTrip(req)
+	}
+	return transport.RoundTrip(req)
+}
+
+var _ http.RoundTripper = &tunnelTransport{}
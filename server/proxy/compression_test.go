@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andydunstall/piko/server/upstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTunnelTransport(t *testing.T) {
+	var encoding, acceptEncoding string
+	transport := newTunnelTransport(roundTripperFunc(
+		func(r *http.Request) (*http.Response, error) {
+			encoding = r.Header.Get("x-piko-forward-encoding")
+			acceptEncoding = r.Header.Get("x-piko-forward-accept-encoding")
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       http.NoBody,
+			}, nil
+		},
+	))
+
+	roundTrip := func(u upstream.Upstream, contentType string) {
+		r := httptest.NewRequest(
+			http.MethodPost, "/", strings.NewReader(strings.Repeat("foo", 100)),
+		)
+		r.Header.Set("Content-Type", contentType)
+		r = r.WithContext(context.WithValue(r.Context(), upstreamContextKey, u))
+
+		resp, err := transport.RoundTrip(r)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	t.Run("negotiated", func(t *testing.T) {
+		u := upstream.NewConnUpstream("my-endpoint", "", nil)
+		u.SetCompression("zstd")
+		roundTrip(u, "application/json")
+		assert.Equal(t, "zstd", encoding)
+		assert.Equal(t, "zstd", acceptEncoding)
+	})
+
+	t.Run("already compressed", func(t *testing.T) {
+		u := upstream.NewConnUpstream("my-endpoint", "", nil)
+		u.SetCompression("gzip")
+		roundTrip(u, "image/png")
+		// The response may still be compressed.
+		assert.Equal(t, "", encoding)
+		assert.Equal(t, "gzip", acceptEncoding)
+	})
+
+	t.Run("not negotiated", func(t *testing.T) {
+		roundTrip(upstream.NewConnUpstream("my-endpoint", "", nil), "application/json")
+		assert.Equal(t, "", encoding)
+		assert.Equal(t, "", acceptEncoding)
+	})
+}
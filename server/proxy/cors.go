@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/andydunstall/piko/server/config"
+)
+
+var (
+	defaultCORSMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+	}
+
+	// corsResponseHeaders are the CORS response headers set by the proxy,
+	// which override any set by the upstream.
+	corsResponseHeaders = []string{
+		"Access-Control-Allow-Origin",
+		"Access-Control-Allow-Credentials",
+		"Access-Control-Allow-Methods",
+		"Access-Control-Allow-Headers",
+		"Access-Control-Expose-Headers",
+		"Access-Control-Max-Age",
+	}
+)
+
+// endpointCORS is the CORS configuration of the endpoints matching the
+// endpoint pattern.
+type endpointCORS struct {
+	endpoint string
+	conf     config.CORSConfig
+}
+
+// corsHandler applies the CORS policy of endpoints configured with CORS, so
+// browser apps can make cross-origin requests to the endpoint without the
+// upstream implementing CORS.
+type corsHandler struct {
+	endpoints []endpointCORS
+}
+
+func newCORSHandler(endpoints []endpointCORS) *corsHandler {
+	return &corsHandler{
+		endpoints: endpoints,
+	}
+}
+
+// Handle applies the CORS policy of the endpoint, if configured.
+//
+// Preflight requests are answered by the proxy without being proxied to the
+// upstream, in which case returns false. Otherwise returns a response writer
+// to proxy the request with, which adds the CORS headers to the response.
+func (h *corsHandler) Handle(
+	w http.ResponseWriter,
+	r *http.Request,
+	endpointID string,
+) (http.ResponseWriter, bool) {
+	conf, ok := h.lookup(endpointID)
+	if !ok {
+		return w, true
+	}
+
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if r.Method == http.MethodOptions &&
+		r.Header.Get("Access-Control-Request-Method") != "" {
+		h.preflight(w, r, conf, origin)
+		return w, false
+	}
+
+	if origin == "" || !corsOriginAllowed(conf, origin) {
+		return w, true
+	}
+
+	header := make(http.Header)
+	header.Set("Access-Control-Allow-Origin", corsAllowOrigin(conf, origin))
+	if conf.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(conf.ExposedHeaders) != 0 {
+		header.Set(
+			"Access-Control-Expose-Headers", strings.Join(conf.ExposedHeaders, ", "),
+		)
+	}
+
+	// Set the headers now so they're included in error responses from the
+	// proxy, such as if the client isn't authenticated.
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	return &corsResponseWriter{
+		ResponseWriter: w,
+		header:         header,
+	}, true
+}
+
+func (h *corsHandler) preflight(
+	w http.ResponseWriter,
+	r *http.Request,
+	conf config.CORSConfig,
+	origin string,
+) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	// If the request isn't permitted, the response doesn't include any CORS
+	// headers, so the browser rejects the request.
+	if origin == "" || !corsOriginAllowed(conf, origin) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	methods := conf.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	if !slices.Contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	requestHeaders := r.Header.Get("Access-Control-Request-Headers")
+	if !corsHeadersAllowed(conf, requestHeaders) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", corsAllowOrigin(conf, origin))
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if requestHeaders != "" {
+		// Since the requested headers are permitted, respond with the
+		// requested headers, which also supports '*' with credentials.
+		w.Header().Set("Access-Control-Allow-Headers", requestHeaders)
+	}
+	if conf.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if conf.MaxAge > 0 {
+		w.Header().Set(
+			"Access-Control-Max-Age", strconv.Itoa(int(conf.MaxAge.Seconds())),
+		)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookup returns the CORS configuration of the first entry matching the
+// endpoint, or false if the endpoint doesn't handle CORS.
+func (h *corsHandler) lookup(endpointID string) (config.CORSConfig, bool) {
+	for _, e := range h.endpoints {
+		if auth.MatchEndpoint(e.endpoint, endpointID) {
+			return e.conf, e.conf.Enabled()
+		}
+	}
+	return config.CORSConfig{}, false
+}
+
+func corsOriginAllowed(conf config.CORSConfig, origin string) bool {
+	for _, pattern := range conf.AllowedOrigins {
+		if auth.MatchEndpoint(strings.ToLower(pattern), strings.ToLower(origin)) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsAllowOrigin returns the 'Access-Control-Allow-Origin' header for the
+// permitted origin.
+func corsAllowOrigin(conf config.CORSConfig, origin string) string {
+	if slices.Contains(conf.AllowedOrigins, "*") {
+		return "*"
+	}
+	return origin
+}
+
+// corsHeadersAllowed returns whether all headers in the
+// 'Access-Control-Request-Headers' header are permitted.
+func corsHeadersAllowed(conf config.CORSConfig, requestHeaders string) bool {
+	if slices.Contains(conf.AllowedHeaders, "*") {
+		return true
+	}
+	for _, header := range strings.Split(requestHeaders, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		if !slices.ContainsFunc(conf.AllowedHeaders, func(allowed string) bool {
+			return strings.EqualFold(allowed, header)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// corsResponseWriter replaces any CORS headers set by the upstream with the
+// headers of the endpoint's CORS policy.
+type corsResponseWriter struct {
+	http.ResponseWriter
+
+	header      http.Header
+	wroteHeader bool
+}
+
+func (w *corsResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader && statusCode >= http.StatusOK {
+		w.wroteHeader = true
+		for _, k := range corsResponseHeaders {
+			w.ResponseWriter.Header().Del(k)
+		}
+		for k, v := range w.header {
+			w.ResponseWriter.Header()[k] = v
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *corsResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *corsResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying response writer, so the reverse proxy can
+// hijack the connection for upgraded requests.
+func (w *corsResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSHandler(t *testing.T) {
+	h := newCORSHandler([]endpointCORS{
+		{
+			endpoint: "my-api",
+			conf: config.CORSConfig{
+				AllowedOrigins:   []string{"https://*.example.com"},
+				AllowedHeaders:   []string{"Content-Type", "X-Piko-Authorization"},
+				ExposedHeaders:   []string{"X-Request-Id"},
+				AllowCredentials: true,
+				MaxAge:           time.Minute,
+			},
+		},
+		{
+			endpoint: "my-public-api",
+			conf: config.CORSConfig{
+				AllowedOrigins: []string{"*"},
+				AllowedMethods: []string{http.MethodGet},
+				AllowedHeaders: []string{"*"},
+			},
+		},
+	})
+
+	preflight := func(
+		endpointID string,
+		origin string,
+		method string,
+		headers string,
+	) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodOptions, "/", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			r.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		w := httptest.NewRecorder()
+		_, ok := h.Handle(w, r, endpointID)
+		assert.False(t, ok)
+		return w
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		w := preflight(
+			"my-api",
+			"https://app.example.com",
+			http.MethodPut,
+			"content-type, x-piko-authorization",
+		)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(
+			t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"),
+		)
+		assert.Equal(
+			t,
+			"GET, HEAD, POST, PUT, PATCH, DELETE",
+			w.Header().Get("Access-Control-Allow-Methods"),
+		)
+		assert.Equal(
+			t,
+			"content-type, x-piko-authorization",
+			w.Header().Get("Access-Control-Allow-Headers"),
+		)
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "60", w.Header().Get("Access-Control-Max-Age"))
+	})
+
+	t.Run("preflight origin not allowed", func(t *testing.T) {
+		w := preflight("my-api", "https://evil.com", http.MethodGet, "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("preflight method not allowed", func(t *testing.T) {
+		w := preflight("my-public-api", "https://evil.com", http.MethodPost, "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("preflight header not allowed", func(t *testing.T) {
+		w := preflight(
+			"my-api", "https://app.example.com", http.MethodGet, "x-other",
+		)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("preflight any header", func(t *testing.T) {
+		w := preflight("my-public-api", "https://other.com", http.MethodGet, "x-other")
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "x-other", w.Header().Get("Access-Control-Allow-Headers"))
+	})
+
+	t.Run("request", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Origin", "https://app.example.com")
+		rec := httptest.NewRecorder()
+
+		w, ok := h.Handle(rec, r, "my-api")
+		assert.True(t, ok)
+
+		// Headers set by the upstream are replaced.
+		w.Header().Add("Access-Control-Allow-Origin", "https://upstream.com")
+		w.Header().Set("Access-Control-Allow-Methods", "GET")
+		w.WriteHeader(http.StatusOK)
+
+		assert.Equal(
+			t,
+			[]string{"https://app.example.com"},
+			rec.Header().Values("Access-Control-Allow-Origin"),
+		)
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "X-Request-Id", rec.Header().Get("Access-Control-Expose-Headers"))
+	})
+
+	t.Run("request origin not allowed", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Origin", "https://evil.com")
+		rec := httptest.NewRecorder()
+
+		w, ok := h.Handle(rec, r, "my-api")
+		assert.True(t, ok)
+		w.WriteHeader(http.StatusOK)
+
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("endpoint without cors", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodOptions, "/", nil)
+		r.Header.Set("Origin", "https://app.example.com")
+		r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		rec := httptest.NewRecorder()
+
+		// Preflight requests are proxied to the upstream.
+		_, ok := h.Handle(rec, r, "other-endpoint")
+		assert.True(t, ok)
+		assert.Empty(t, rec.Header())
+	})
+}
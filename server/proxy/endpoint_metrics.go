@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/middleware"
+	"github.com/andydunstall/piko/pkg/telemetry"
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// otherEndpointsLabel is the endpoint label used for endpoints exceeding
+	// the endpoint metrics limit.
+	otherEndpointsLabel = "__other__"
+)
+
+// endpointMetrics records request metrics labelled by tenant and endpoint
+// ID.
+//
+// To limit the metrics cardinality, only the first maxEndpoints endpoints
+// are recorded with their own label, and any other endpoints are recorded
+// with the '__other__' label. Endpoints aren't removed once recorded, so
+// their metrics aren't lost if the endpoint reconnects.
+type endpointMetrics struct {
+	maxEndpoints int
+
+	endpoints map[string]struct{}
+
+	mu sync.Mutex
+
+	metrics *Metrics
+}
+
+func newEndpointMetrics(maxEndpoints int, metrics *Metrics) *endpointMetrics {
+	return &endpointMetrics{
+		maxEndpoints: maxEndpoints,
+		endpoints:    make(map[string]struct{}),
+		metrics:      metrics,
+	}
+}
+
+// Enabled returns whether per-endpoint metrics are enabled.
+func (m *endpointMetrics) Enabled() bool {
+	return m.maxEndpoints > 0
+}
+
+// Observe records a completed request for the given endpoint.
+func (m *endpointMetrics) Observe(
+	ctx context.Context,
+	endpointID string,
+	statusCode int,
+	requestBytes int64,
+	responseBytes int64,
+	latency time.Duration,
+) {
+	// Endpoints exceeding the limit keep their tenant label, so per-tenant
+	// totals are still accurate.
+	tenant, label := auth.SplitEndpoint(endpointID)
+	if m.label(endpointID) == otherEndpointsLabel {
+		label = otherEndpointsLabel
+	}
+
+	// If no response was written the server responds with 200.
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	m.metrics.EndpointRequestsTotal.With(prometheus.Labels{
+		"tenant":       tenant,
+		"endpoint_id":  label,
+		"status_class": middleware.StatusClass(statusCode),
+	}).Inc()
+	telemetry.ObserveWithExemplar(
+		ctx,
+		m.metrics.EndpointRequestLatency.With(prometheus.Labels{
+			"tenant":      tenant,
+			"endpoint_id": label,
+		}),
+		latency.Seconds(),
+	)
+	m.metrics.EndpointRequestBytesTotal.With(prometheus.Labels{
+		"tenant":      tenant,
+		"endpoint_id": label,
+	}).Add(float64(requestBytes))
+	m.metrics.EndpointResponseBytesTotal.With(prometheus.Labels{
+		"tenant":      tenant,
+		"endpoint_id": label,
+	}).Add(float64(responseBytes))
+}
+
+func (m *endpointMetrics) label(endpointID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.endpoints[endpointID]; ok {
+		return endpointID
+	}
+	if len(m.endpoints) >= m.maxEndpoints {
+		return otherEndpointsLabel
+	}
+	m.endpoints[endpointID] = struct{}{}
+	return endpointID
+}
+
+// metricsResponseWriter records the status code and number of bytes written
+// to the response.
+type metricsResponseWriter struct {
+	http.ResponseWriter
+
+	statusCode int
+	bytes      int64
+}
+
+func (w *metricsResponseWriter) WriteHeader(statusCode int) {
+	// Informational responses are overridden by the final response.
+	if w.statusCode < http.StatusOK {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *metricsResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *metricsResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying response writer, so the reverse proxy can
+// hijack the connection for upgraded requests.
+func (w *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// metricsRequestBody records the number of bytes read from the request
+// body.
+type metricsRequestBody struct {
+	io.ReadCloser
+
+	bytes int64
+}
+
+func (b *metricsRequestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	return n, err
+}
+
+var _ http.ResponseWriter = &metricsResponseWriter{}
+var _ http.Flusher = &metricsResponseWriter{}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/upstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointMetrics(t *testing.T) {
+	t.Run("observe", func(t *testing.T) {
+		metrics := NewMetrics()
+		ctx := context.Background()
+		m := newEndpointMetrics(10, metrics)
+
+		m.Observe(ctx, "my-endpoint", http.StatusOK, 10, 20, time.Millisecond)
+		m.Observe(ctx, "my-endpoint", http.StatusNotFound, 5, 0, time.Millisecond)
+		m.Observe(ctx, "my-endpoint", 0, 0, 0, time.Millisecond)
+
+		assert.Equal(t, 2.0, testutil.ToFloat64(
+			metrics.EndpointRequestsTotal.With(prometheus.Labels{
+				"tenant":       "",
+				"endpoint_id":  "my-endpoint",
+				"status_class": "2xx",
+			}),
+		))
+		assert.Equal(t, 1.0, testutil.ToFloat64(
+			metrics.EndpointRequestsTotal.With(prometheus.Labels{
+				"tenant":       "",
+				"endpoint_id":  "my-endpoint",
+				"status_class": "4xx",
+			}),
+		))
+		assert.Equal(t, 15.0, testutil.ToFloat64(
+			metrics.EndpointRequestBytesTotal.With(prometheus.Labels{
+				"tenant":      "",
+				"endpoint_id": "my-endpoint",
+			}),
+		))
+		assert.Equal(t, 20.0, testutil.ToFloat64(
+			metrics.EndpointResponseBytesTotal.With(prometheus.Labels{
+				"tenant":      "",
+				"endpoint_id": "my-endpoint",
+			}),
+		))
+	})
+
+	t.Run("max endpoints", func(t *testing.T) {
+		metrics := NewMetrics()
+		ctx := context.Background()
+		m := newEndpointMetrics(2, metrics)
+
+		m.Observe(ctx, "endpoint-1", http.StatusOK, 0, 0, time.Millisecond)
+		m.Observe(ctx, "endpoint-2", http.StatusOK, 0, 0, time.Millisecond)
+		m.Observe(ctx, "endpoint-3", http.StatusOK, 0, 0, time.Millisecond)
+		m.Observe(ctx, "endpoint-4", http.StatusOK, 0, 0, time.Millisecond)
+		// Endpoints already recorded keep their label.
+		m.Observe(ctx, "endpoint-1", http.StatusOK, 0, 0, time.Millisecond)
+
+		assert.Equal(t, 3, testutil.CollectAndCount(metrics.EndpointRequestsTotal))
+		assert.Equal(t, 2.0, testutil.ToFloat64(
+			metrics.EndpointRequestsTotal.With(prometheus.Labels{
+				"tenant":       "",
+				"endpoint_id":  "endpoint-1",
+				"status_class": "2xx",
+			}),
+		))
+		assert.Equal(t, 2.0, testutil.ToFloat64(
+			metrics.EndpointRequestsTotal.With(prometheus.Labels{
+				"tenant":       "",
+				"endpoint_id":  otherEndpointsLabel,
+				"status_class": "2xx",
+			}),
+		))
+	})
+
+	t.Run("tenant", func(t *testing.T) {
+		metrics := NewMetrics()
+		ctx := context.Background()
+		m := newEndpointMetrics(1, metrics)
+
+		m.Observe(ctx, "team-a/endpoint-1", http.StatusOK, 0, 0, time.Millisecond)
+		m.Observe(ctx, "team-a/endpoint-2", http.StatusOK, 0, 0, time.Millisecond)
+
+		assert.Equal(t, 1.0, testutil.ToFloat64(
+			metrics.EndpointRequestsTotal.With(prometheus.Labels{
+				"tenant":       "team-a",
+				"endpoint_id":  "endpoint-1",
+				"status_class": "2xx",
+			}),
+		))
+		// Endpoints exceeding the limit keep their tenant.
+		assert.Equal(t, 1.0, testutil.ToFloat64(
+			metrics.EndpointRequestsTotal.With(prometheus.Labels{
+				"tenant":       "team-a",
+				"endpoint_id":  otherEndpointsLabel,
+				"status_class": "2xx",
+			}),
+		))
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				// nolint
+				w.Write([]byte("bar"))
+			},
+		))
+		defer server.Close()
+
+		proxy := NewHTTPProxy(
+			&fakeManager{
+				handler: func(string, bool) (upstream.Upstream, bool) {
+					return &tcpUpstream{
+						addr: server.Listener.Addr().String(),
+					}, true
+				},
+			},
+			time.Second,
+			config.ForwardConfig{},
+			log.NewNopLogger(),
+		)
+		proxy.endpointMetrics = newEndpointMetrics(10, proxy.Metrics())
+
+		r := httptest.NewRequest(
+			http.MethodPost, "/", bytes.NewReader([]byte("foo-bar")),
+		)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		metrics := proxy.Metrics()
+		assert.Equal(t, 1.0, testutil.ToFloat64(
+			metrics.EndpointRequestsTotal.With(prometheus.Labels{
+				"tenant":       "",
+				"endpoint_id":  "my-endpoint",
+				"status_class": "2xx",
+			}),
+		))
+		assert.Equal(t, 7.0, testutil.ToFloat64(
+			metrics.EndpointRequestBytesTotal.With(prometheus.Labels{
+				"tenant":      "",
+				"endpoint_id": "my-endpoint",
+			}),
+		))
+		assert.Equal(t, 3.0, testutil.ToFloat64(
+			metrics.EndpointResponseBytesTotal.With(prometheus.Labels{
+				"tenant":      "",
+				"endpoint_id": "my-endpoint",
+			}),
+		))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		m := newEndpointMetrics(0, NewMetrics())
+		assert.False(t, m.Enabled())
+	})
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// flowControlConn records the time spent blocked writing to an upstream
+// stream.
+//
+// Each stream to an upstream has its own flow control window, so writes
+// block once the upstream stops reading rather than buffering the request
+// body on the node.
+type flowControlConn struct {
+	net.Conn
+
+	blocked prometheus.Counter
+}
+
+func (c *flowControlConn) Write(b []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Write(b)
+	c.blocked.Add(time.Since(start).Seconds())
+	return n, err
+}
+
+// flowControlResponseWriter records the time spent blocked writing the
+// response to the downstream client.
+//
+// While blocked the node stops reading the response from the upstream
+// stream, so a slow downstream client applies backpressure to the upstream
+// rather than the node buffering the response.
+type flowControlResponseWriter struct {
+	http.ResponseWriter
+
+	blocked prometheus.Counter
+}
+
+func (w *flowControlResponseWriter) Write(b []byte) (int, error) {
+	start := time.Now()
+	n, err := w.ResponseWriter.Write(b)
+	w.blocked.Add(time.Since(start).Seconds())
+	return n, err
+}
+
+func (w *flowControlResponseWriter) Flush() {
+	start := time.Now()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	w.blocked.Add(time.Since(start).Seconds())
+}
+
+// Unwrap returns the underlying response writer, so the reverse proxy can
+// hijack the connection for upgraded requests.
+func (w *flowControlResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+var _ net.Conn = &flowControlConn{}
+var _ http.ResponseWriter = &flowControlResponseWriter{}
+var _ http.Flusher = &flowControlResponseWriter{}
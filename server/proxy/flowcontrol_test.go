@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlowControlConn(t *testing.T) {
+	blocked := prometheus.NewCounter(prometheus.CounterOpts{Name: "blocked"})
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	conn := &flowControlConn{
+		Conn:    local,
+		blocked: blocked,
+	}
+
+	// The write blocks until the remote reads.
+	go func() {
+		<-time.After(time.Millisecond * 50)
+		buf := make([]byte, 3)
+		_, _ = remote.Read(buf)
+	}()
+
+	n, err := conn.Write([]byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	assert.GreaterOrEqual(t, testutil.ToFloat64(blocked), 0.05)
+}
+
+func TestFlowControlResponseWriter(t *testing.T) {
+	blocked := prometheus.NewCounter(prometheus.CounterOpts{Name: "blocked"})
+
+	rec := httptest.NewRecorder()
+	w := &flowControlResponseWriter{
+		ResponseWriter: rec,
+		blocked:        blocked,
+	}
+
+	n, err := w.Write([]byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	w.Flush()
+
+	assert.Equal(t, "foo", rec.Body.String())
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, rec, w.Unwrap())
+}
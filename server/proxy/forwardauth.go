@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultForwardAuthTimeout is the timeout waiting for the auth service
+	// to respond if not configured.
+	defaultForwardAuthTimeout = time.Second * 5
+
+	// maxForwardAuthBodySize is the maximum size of an auth service response
+	// body returned to the client when a request is denied.
+	maxForwardAuthBodySize = 1 << 20
+)
+
+// forwardAuthHopHeaders are hop-by-hop headers that aren't forwarded to or
+// from the auth service.
+var forwardAuthHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Content-Length",
+}
+
+// endpointForwardAuth is the forward auth configuration of the endpoints
+// matching the endpoint pattern.
+type endpointForwardAuth struct {
+	endpoint string
+	conf     config.ForwardAuthConfig
+}
+
+// forwardAuthenticator delegates authorization of requests to endpoints
+// configured with forward auth to an external auth service, like nginx
+// 'auth_request' or Traefik 'forwardAuth'.
+//
+// The auth service is sent a GET request with the original request headers
+// and metadata. If the auth service responds with a 2xx status the request
+// is proxied, otherwise the auth service response is returned to the client.
+type forwardAuthenticator struct {
+	endpoints []endpointForwardAuth
+
+	client *http.Client
+
+	rejected *prometheus.CounterVec
+
+	logger log.Logger
+}
+
+func newForwardAuthenticator(
+	endpoints []endpointForwardAuth,
+	rejected *prometheus.CounterVec,
+	logger log.Logger,
+) *forwardAuthenticator {
+	return &forwardAuthenticator{
+		endpoints: endpoints,
+		client: &http.Client{
+			// Redirects, such as to a login page, are returned to the
+			// client rather than followed.
+			CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		rejected: rejected,
+		logger:   logger,
+	}
+}
+
+// Authorize forwards the request to the auth service if the endpoint is
+// configured with forward auth. If the request is denied, writes the auth
+// service response and returns false.
+//
+// If the request is allowed, the configured auth service response headers
+// are added to the request.
+func (a *forwardAuthenticator) Authorize(c *gin.Context, endpointID string) bool {
+	conf, ok := a.lookup(endpointID)
+	if !ok {
+		return true
+	}
+
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = defaultForwardAuthTimeout
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, conf.URL, nil)
+	if err != nil {
+		// Will not happen since the URL is validated.
+		panic("forward auth: " + err.Error())
+	}
+	forwardAuthRequestHeaders(conf, c, endpointID, req.Header)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		a.logger.Warn(
+			"forward auth request failed",
+			zap.String("endpoint-id", endpointID),
+			zap.String("url", conf.URL),
+			zap.Error(err),
+		)
+		a.rejected.With(prometheus.Labels{"reason": "forward_auth"}).Inc()
+		_ = errorResponse(c.Writer, http.StatusBadGateway, "auth service unavailable")
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		a.logger.Debug(
+			"forward auth denied",
+			zap.String("endpoint-id", endpointID),
+			zap.String("client-ip", c.ClientIP()),
+			zap.Int("status", resp.StatusCode),
+		)
+		a.rejected.With(prometheus.Labels{"reason": "forward_auth"}).Inc()
+
+		for name, values := range resp.Header {
+			c.Writer.Header()[name] = values
+		}
+		for _, name := range forwardAuthHopHeaders {
+			c.Writer.Header().Del(name)
+		}
+		c.Writer.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(c.Writer, io.LimitReader(resp.Body, maxForwardAuthBodySize))
+		return false
+	}
+
+	// Set the configured headers from the auth service response, or remove
+	// them if not set, so clients can't set the headers themselves.
+	for _, name := range conf.ResponseHeaders {
+		values := resp.Header.Values(name)
+		if len(values) == 0 {
+			c.Request.Header.Del(name)
+			continue
+		}
+		c.Request.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	return true
+}
+
+// lookup returns the forward auth configuration of the first entry matching
+// the endpoint, or false if the endpoint doesn't use forward auth.
+func (a *forwardAuthenticator) lookup(endpointID string) (config.ForwardAuthConfig, bool) {
+	for _, e := range a.endpoints {
+		if auth.MatchEndpoint(e.endpoint, endpointID) {
+			return e.conf, e.conf.Enabled()
+		}
+	}
+	return config.ForwardAuthConfig{}, false
+}
+
+// forwardAuthRequestHeaders sets the headers of the request to the auth
+// service, including the original request headers and the 'X-Forwarded-*'
+// headers describing the request.
+func forwardAuthRequestHeaders(
+	conf config.ForwardAuthConfig,
+	c *gin.Context,
+	endpointID string,
+	header http.Header,
+) {
+	if len(conf.RequestHeaders) == 0 {
+		for name, values := range c.Request.Header {
+			header[name] = values
+		}
+		for _, name := range forwardAuthHopHeaders {
+			header.Del(name)
+		}
+		// The proxy client token is only used by Piko.
+		header.Del(clientTokenHeader)
+	} else {
+		for _, name := range conf.RequestHeaders {
+			if values := c.Request.Header.Values(name); len(values) != 0 {
+				header[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+	}
+
+	proto := "http"
+	if c.Request.TLS != nil {
+		proto = "https"
+	}
+	header.Set("X-Forwarded-Method", c.Request.Method)
+	header.Set("X-Forwarded-Proto", proto)
+	header.Set("X-Forwarded-Host", c.Request.Host)
+	header.Set("X-Forwarded-Uri", c.Request.URL.RequestURI())
+	header.Set("X-Forwarded-For", c.ClientIP())
+	header.Set("X-Piko-Endpoint", endpointID)
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardAuthenticator(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodGet, r.Method)
+			assert.Equal(t, "/my/path?foo=bar", r.Header.Get("X-Forwarded-Uri"))
+			assert.Equal(t, "my-endpoint", r.Header.Get("X-Piko-Endpoint"))
+			assert.Empty(t, r.Header.Get("X-Piko-Authorization"))
+
+			switch r.Header.Get("Authorization") {
+			case "Bearer valid":
+				w.Header().Set("X-User", "alice")
+				w.WriteHeader(http.StatusOK)
+			case "":
+				http.Redirect(w, r, "https://login.example.com", http.StatusFound)
+			default:
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte("denied"))
+			}
+		},
+	))
+	defer authServer.Close()
+
+	rejected := prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "rejected"},
+		[]string{"reason"},
+	)
+	a := newForwardAuthenticator(
+		[]endpointForwardAuth{
+			{
+				endpoint: "my-*",
+				conf: config.ForwardAuthConfig{
+					URL:             authServer.URL,
+					ResponseHeaders: []string{"X-User"},
+				},
+			},
+		},
+		rejected,
+		log.NewNopLogger(),
+	)
+
+	authorize := func(
+		endpointID string,
+		authorization string,
+	) (*httptest.ResponseRecorder, bool, *http.Request) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/my/path?foo=bar", nil)
+		c.Request.Header.Set("X-Piko-Authorization", "my-token")
+		// Clients must not be able to set the auth response headers.
+		c.Request.Header.Set("X-User", "bob")
+		if authorization != "" {
+			c.Request.Header.Set("Authorization", authorization)
+		}
+		return w, a.Authorize(c, endpointID), c.Request
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		_, ok, r := authorize("my-endpoint", "Bearer valid")
+		assert.True(t, ok)
+		assert.Equal(t, "alice", r.Header.Get("X-User"))
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		w, ok, _ := authorize("my-endpoint", "Bearer invalid")
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, "denied", w.Body.String())
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		w, ok, _ := authorize("my-endpoint", "")
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://login.example.com", w.Header().Get("Location"))
+	})
+
+	t.Run("endpoint without forward auth", func(t *testing.T) {
+		_, ok, r := authorize("other-endpoint", "")
+		assert.True(t, ok)
+		assert.Equal(t, "bob", r.Header.Get("X-User"))
+	})
+
+	assert.Equal(
+		t,
+		2.0,
+		promtestutil.ToFloat64(rejected.With(prometheus.Labels{"reason": "forward_auth"})),
+	)
+}
+
+func TestForwardAuthenticator_Unavailable(t *testing.T) {
+	authServer := httptest.NewServer(http.NotFoundHandler())
+	authServer.Close()
+
+	a := newForwardAuthenticator(
+		[]endpointForwardAuth{
+			{
+				endpoint: "my-endpoint",
+				conf: config.ForwardAuthConfig{
+					URL: authServer.URL,
+				},
+			},
+		},
+		prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "rejected"},
+			[]string{"reason"},
+		),
+		log.NewNopLogger(),
+	)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.False(t, a.Authorize(c, "my-endpoint"))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/andydunstall/piko/server/auth"
+)
+
+// endpointHeaders is the response headers of the endpoints matching the
+// endpoint pattern.
+type endpointHeaders struct {
+	endpoint string
+	headers  map[string]string
+}
+
+// headerInjector sets the configured response headers on responses from
+// endpoints, such as security headers, so policy can be centralized at the
+// proxy rather than configured in every upstream.
+type headerInjector struct {
+	endpoints []endpointHeaders
+}
+
+func newHeaderInjector(endpoints []endpointHeaders) *headerInjector {
+	return &headerInjector{
+		endpoints: endpoints,
+	}
+}
+
+// Wrap returns a response writer that sets the endpoint's configured
+// response headers, replacing any set by the upstream. If the endpoint
+// doesn't have any configured headers, returns the given writer.
+func (i *headerInjector) Wrap(
+	w http.ResponseWriter,
+	endpointID string,
+) http.ResponseWriter {
+	headers, ok := i.lookup(endpointID)
+	if !ok {
+		return w
+	}
+
+	hw := &headersResponseWriter{
+		ResponseWriter: w,
+		headers:        headers,
+	}
+	// Set the headers now so they're included in error responses from the
+	// proxy, such as if the client isn't authenticated.
+	hw.setHeaders()
+	return hw
+}
+
+// lookup returns the response headers of the first entry matching the
+// endpoint, or false if the endpoint doesn't have any configured headers.
+func (i *headerInjector) lookup(endpointID string) (map[string]string, bool) {
+	for _, e := range i.endpoints {
+		if auth.MatchEndpoint(e.endpoint, endpointID) {
+			return e.headers, len(e.headers) != 0
+		}
+	}
+	return nil, false
+}
+
+type headersResponseWriter struct {
+	http.ResponseWriter
+
+	headers     map[string]string
+	wroteHeader bool
+}
+
+func (w *headersResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader && statusCode >= http.StatusOK {
+		w.wroteHeader = true
+		w.setHeaders()
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *headersResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headersResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying response writer, so the reverse proxy can
+// hijack the connection for upgraded requests.
+func (w *headersResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *headersResponseWriter) setHeaders() {
+	for name, value := range w.headers {
+		if value == "" {
+			w.ResponseWriter.Header().Del(name)
+			continue
+		}
+		w.ResponseWriter.Header().Set(name, value)
+	}
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderInjector(t *testing.T) {
+	i := newHeaderInjector([]endpointHeaders{
+		{
+			endpoint: "my-endpoint-*",
+			headers: map[string]string{
+				"Strict-Transport-Security": "max-age=31536000",
+				"X-Content-Type-Options":    "nosniff",
+				"X-Powered-By":              "",
+			},
+		},
+	})
+
+	t.Run("replaces upstream headers", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := i.Wrap(rec, "my-endpoint-123")
+
+		w.Header().Set("Strict-Transport-Security", "max-age=0")
+		w.Header().Set("X-Powered-By", "Express")
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("foo"))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(
+			t, "max-age=31536000", rec.Header().Get("Strict-Transport-Security"),
+		)
+		assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+		assert.Empty(t, rec.Header().Get("X-Powered-By"))
+		assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	})
+
+	t.Run("proxy response", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		_ = i.Wrap(rec, "my-endpoint-123")
+
+		// Headers are set on the underlying writer so are included in
+		// responses written by the proxy.
+		_ = errorResponse(rec, http.StatusUnauthorized, "missing token")
+		assert.Equal(
+			t, "max-age=31536000", rec.Header().Get("Strict-Transport-Security"),
+		)
+	})
+
+	t.Run("endpoint without headers", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := i.Wrap(rec, "other-endpoint")
+		assert.Equal(t, rec, w)
+		assert.Empty(t, rec.Header())
+	})
+}
+
+func BenchmarkHeaderInjector(b *testing.B) {
+	i := newHeaderInjector([]endpointHeaders{
+		{
+			endpoint: "my-endpoint-*",
+			headers: map[string]string{
+				"Strict-Transport-Security": "max-age=31536000",
+				"X-Content-Type-Options":    "nosniff",
+				"X-Powered-By":              "",
+			},
+		},
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		rec := httptest.NewRecorder()
+		w := i.Wrap(rec, "my-endpoint-123")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Powered-By", "Express")
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -10,8 +10,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/andydunstall/piko/pkg/compress"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/streaming"
+	"github.com/andydunstall/piko/pkg/telemetry"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/federation"
+	"github.com/andydunstall/piko/server/nodeauth"
 	"github.com/andydunstall/piko/server/upstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -21,6 +30,7 @@ type contextKey int
 const (
 	endpointContextKey contextKey = iota
 	upstreamContextKey
+	nodeForwardContextKey
 )
 
 // HTTPProxy proxies HTTP traffic to upsteam listeners.
@@ -29,43 +39,147 @@ type HTTPProxy struct {
 
 	proxy *httputil.ReverseProxy
 
+	// forwardProxy forwards requests to other nodes in the cluster using a
+	// pool of persistent connections.
+	forwardProxy *httputil.ReverseProxy
+
+	forwardTransport *http.Transport
+
+	// rpcProxy forwards requests to other nodes in the cluster using gRPC.
+	// Nil unless the forward protocol is 'grpc'.
+	rpcProxy *httputil.ReverseProxy
+
+	rpcClient *rpcClient
+
+	// nodeAuth authenticates requests forwarded to other nodes. Nil if
+	// forwarded requests aren't authenticated.
+	nodeAuth *nodeauth.Authenticator
+
+	// federation forwards requests for endpoints that only exist in a remote
+	// cluster. Nil if federation is disabled.
+	federation *federation.Federation
+
 	timeout time.Duration
 
+	stats *requestStats
+
+	// endpointMetrics records per-endpoint request metrics. Disabled by
+	// default.
+	endpointMetrics *endpointMetrics
+
+	// slowRequestThreshold is the duration above which a request is logged
+	// as slow. Zero disables logging slow requests.
+	slowRequestThreshold time.Duration
+
+	metrics *Metrics
+
 	logger log.Logger
 }
 
 func NewHTTPProxy(
 	upstreams upstream.Manager,
 	timeout time.Duration,
+	forwardConfig config.ForwardConfig,
 	logger log.Logger,
 ) *HTTPProxy {
+	metrics := NewMetrics()
 	rp := &HTTPProxy{
-		upstreams: upstreams,
-		timeout:   timeout,
-		logger:    logger.WithSubsystem("proxy.http"),
+		upstreams:       upstreams,
+		timeout:         timeout,
+		stats:           newRequestStats(),
+		endpointMetrics: newEndpointMetrics(0, metrics),
+		metrics:         metrics,
+		logger:          logger.WithSubsystem("proxy.http"),
 	}
 
 	rp.proxy = &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
 			req.URL.Scheme = "http"
 			req.URL.Host = req.Context().Value(endpointContextKey).(string)
+			// Don't pass the proxy client token to the upstream.
+			req.Header.Del(clientTokenHeader)
 		},
-		Transport: &http.Transport{
+		// Requests to upstreams that negotiated compression when
+		// connecting are compressed.
+		Transport: newTunnelTransport(&http.Transport{
 			DialContext: rp.dialUpstream,
 			// 'connections' to the upstream are multiplexed over a single TCP
 			// connection so theres no overhead to creating new connections,
 			// therefore it doesn't make sense to keep them alive.
 			DisableKeepAlives: true,
+			// Wait for the upstream to respond to requests with
+			// 'Expect: 100-continue' before sending the body.
+			ExpectContinueTimeout: expectContinueTimeout,
+		}),
+		ErrorLog:     logger.StdLogger(zapcore.WarnLevel),
+		ErrorHandler: rp.errorHandler,
+		BufferPool:   buffers,
+	}
+
+	rp.forwardTransport = newForwardTransport(forwardConfig, rp.metrics)
+	var forwardTransport http.RoundTripper = rp.forwardTransport
+	if compress.Enabled(forwardConfig.Compression) {
+		forwardTransport = compress.NewTransport(
+			forwardTransport, forwardConfig.Compression,
+		)
+	}
+	rp.forwardProxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			switch u := req.Context().Value(upstreamContextKey).(type) {
+			case *upstream.NodeUpstream:
+				// Use the node address as the host so connections are
+				// pooled per node.
+				req.URL.Scheme = "http"
+				req.URL.Host = u.Addr()
+				// Authenticate the request so the node doesn't repeat
+				// checks already applied by this node, such as quotas.
+				rp.nodeAuth.SignRequest(req)
+			case *upstream.ClusterUpstream:
+				req.URL.Scheme = u.URL().Scheme
+				req.URL.Host = u.URL().Host
+			}
 		},
+		Transport:    forwardTransport,
 		ErrorLog:     logger.StdLogger(zapcore.WarnLevel),
 		ErrorHandler: rp.errorHandler,
+		BufferPool:   buffers,
+	}
+
+	if forwardConfig.Protocol == "grpc" {
+		rp.rpcClient = newRPCClient()
+		var rpcTransport http.RoundTripper = rp.rpcClient
+		if compress.Enabled(forwardConfig.Compression) {
+			rpcTransport = compress.NewTransport(
+				rpcTransport, forwardConfig.Compression,
+			)
+		}
+		rp.rpcProxy = &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				node := req.Context().Value(upstreamContextKey).(*upstream.NodeUpstream)
+				req.URL.Scheme = "http"
+				req.URL.Host = node.RPCAddr()
+			},
+			Transport:    rpcTransport,
+			ErrorLog:     logger.StdLogger(zapcore.WarnLevel),
+			ErrorHandler: rp.errorHandler,
+			BufferPool:   buffers,
+		}
 	}
 
 	return rp
 }
 
 func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	endpointID := EndpointIDFromRequest(r)
+	p.ServeEndpoint(w, r, EndpointIDFromRequest(r))
+}
+
+// ServeEndpoint proxies the request to an upstream for the given endpoint ID,
+// which may be scoped to a tenant.
+func (p *HTTPProxy) ServeEndpoint(
+	w http.ResponseWriter,
+	r *http.Request,
+	endpointID string,
+) {
 	if endpointID == "" {
 		p.logger.Warn("request missing endpoint id")
 
@@ -73,20 +187,17 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Whether the request was forwarded from another Piko node.
-	forwarded := r.Header.Get("x-piko-forward") == "true"
-
-	// If there is a connected upstream, attempt to forward the request to one
-	// of those upstreams. Note this includes remote nodes that are reporting
-	// they have an available upstream. We don't allow multiple hops, so if
-	// forwarded is true we only select from local nodes.
-	upstream, ok := p.upstreams.Select(endpointID, !forwarded)
+	upstream, ok := selectUpstream(p.upstreams, p.federation, r, endpointID)
 	if !ok {
 		p.logger.Warn(
 			"no available upstreams",
 			zap.String("endpoint-id", endpointID),
 		)
 
+		p.stats.RecordError(
+			endpointID, http.StatusBadGateway, "no available upstreams",
+		)
+
 		_ = errorResponse(w, http.StatusBadGateway, "no available upstreams")
 		return
 	}
@@ -100,37 +211,234 @@ func (p *HTTPProxy) ServeHTTPWithUpstream(
 	endpointID string,
 	upstream upstream.Upstream,
 ) {
-	if p.timeout != 0 {
-		ctx, cancel := context.WithTimeout(r.Context(), p.timeout)
-		defer cancel()
+	p.stats.RecordRequest(endpointID)
+
+	if p.endpointMetrics.Enabled() {
+		start := time.Now()
+		mw := &metricsResponseWriter{ResponseWriter: w}
+		w = mw
+		body := &metricsRequestBody{}
+		if r.Body != nil && r.Body != http.NoBody {
+			body.ReadCloser = r.Body
+			r.Body = body
+		}
+		defer func() {
+			p.endpointMetrics.Observe(
+				r.Context(), endpointID, mw.statusCode, body.bytes, mw.bytes, time.Since(start),
+			)
+		}()
+	}
 
-		r = r.WithContext(ctx)
+	if p.slowRequestThreshold != 0 && !isUpgrade(r) {
+		timing := newRequestTiming()
+		r = r.WithContext(timing.WithContext(r.Context()))
+		defer func() {
+			if timing.Duration() < p.slowRequestThreshold {
+				return
+			}
+			fields := []zap.Field{
+				zap.String("endpoint-id", endpointID),
+				zap.String("upstream", upstreamKind(upstream)),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+			}
+			if nodeID, ok := upstreamNodeID(upstream); ok {
+				fields = append(fields, zap.String("node-id", nodeID))
+			}
+			p.logger.Warn("slow request", append(fields, timing.Fields()...)...)
+		}()
 	}
 
-	r.Header.Set("x-piko-forward", "true")
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.String("piko.endpoint_id", endpointID),
+		attribute.String("piko.upstream", upstreamKind(upstream)),
+	)
+
+	// Streaming responses, such as Server-Sent Events, are exempt from the
+	// timeout once the upstream responds.
+	ctx, w, cancel := streaming.WithTimeout(r.Context(), w, p.timeout)
+	defer cancel()
+
+	r = r.WithContext(ctx)
+
+	// If the upstream is at its concurrent request limit, wait for capacity
+	// up to the request timeout.
+	if !isUpgrade(r) {
+		release, ok := acquireUpstream(r.Context(), upstream)
+		if !ok {
+			p.logger.Warn(
+				"upstream at capacity",
+				zap.String("endpoint-id", endpointID),
+			)
+
+			p.metrics.RejectedRequestsTotal.With(
+				prometheus.Labels{"reason": "upstream_max_requests"},
+			).Inc()
+			p.stats.RecordError(
+				endpointID, http.StatusServiceUnavailable, "upstream at capacity",
+			)
+
+			_ = errorResponse(w, http.StatusServiceUnavailable, "upstream at capacity")
+			return
+		}
+		defer release()
+	}
+
+	// If the request was forwarded from another node or cluster, it may have
+	// a compressed body and accept a compressed response.
+	if r.Header.Get("x-piko-forward") == "true" ||
+		r.Header.Get("x-piko-federated") == "true" {
+		forwardedW, forwardedR, finish, err := compress.DecompressRequest(w, r)
+		if err != nil {
+			p.logger.Warn("forwarded request", zap.Error(err))
+
+			_ = errorResponse(w, http.StatusBadRequest, "invalid forwarded request")
+			return
+		}
+		defer finish()
+
+		w, r = forwardedW, forwardedR
+	}
+
+	if isClusterUpstream(upstream) {
+		// Requests forwarded to a remote cluster may be forwarded again to
+		// the node in the remote cluster with the upstream, though must not
+		// be forwarded to another cluster.
+		r.Header.Set("x-piko-federated", "true")
+	} else {
+		r.Header.Set("x-piko-forward", "true")
+	}
 
 	r = r.WithContext(context.WithValue(r.Context(), endpointContextKey, endpointID))
 
 	// Add the upstream to the context to pass to 'DialContext'.
 	r = r.WithContext(context.WithValue(r.Context(), upstreamContextKey, upstream))
 
+	// Record the latency of requests forwarded to each node, to identify
+	// slow or overloaded nodes. Upgraded connections are excluded since the
+	// latency is the lifetime of the connection.
+	if nodeID, ok := upstreamNodeID(upstream); ok && !isUpgrade(r) {
+		start := time.Now()
+		defer func() {
+			telemetry.ObserveWithExemplar(
+				r.Context(),
+				p.metrics.ForwardRequestLatency.With(prometheus.Labels{
+					"node_id": nodeID,
+				}),
+				time.Since(start).Seconds(),
+			)
+		}()
+	}
+
+	if p.rpcProxy != nil && isRPCUpstream(upstream) && !isUpgrade(r) {
+		p.rpcProxy.ServeHTTP(w, r)
+		return
+	}
+
+	if isNodeUpstream(upstream) {
+		ctx, done := withForwardTrace(r.Context(), p.metrics)
+		defer done()
+
+		r = r.WithContext(ctx)
+		p.forwardProxy.ServeHTTP(w, r)
+		return
+	}
+
+	if !isUpgrade(r) {
+		w = &flowControlResponseWriter{
+			ResponseWriter: w,
+			blocked:        p.metrics.DownstreamWriteBlockedSeconds,
+		}
+	}
 	p.proxy.ServeHTTP(w, r)
 }
 
+// selectUpstream selects an upstream for the request.
+//
+// If there is a connected upstream, attempt to forward the request to one of
+// those upstreams. Note this includes remote nodes that are reporting they
+// have an available upstream. We don't allow multiple hops, so if the request
+// was forwarded from another node we only select from local nodes.
+//
+// If there are no upstreams in the local cluster, falls back to a federated
+// remote cluster, unless the request was forwarded from another node or
+// cluster.
+func selectUpstream(
+	upstreams upstream.Manager,
+	federation *federation.Federation,
+	r *http.Request,
+	endpointID string,
+) (upstream.Upstream, bool) {
+	// Whether the request was forwarded from another Piko node.
+	forwarded := r.Header.Get("x-piko-forward") == "true"
+	// Whether the request was forwarded from a remote Piko cluster.
+	federated := r.Header.Get("x-piko-federated") == "true"
+
+	u, ok := upstreams.Select(endpointID, !forwarded)
+	if ok {
+		return u, true
+	}
+	if federation == nil || forwarded || federated {
+		return nil, false
+	}
+	return federation.Select(endpointID)
+}
+
+// setFlushInterval sets the interval to flush response bodies to the client.
+func (p *HTTPProxy) setFlushInterval(interval time.Duration) {
+	p.proxy.FlushInterval = interval
+	p.forwardProxy.FlushInterval = interval
+	if p.rpcProxy != nil {
+		p.rpcProxy.FlushInterval = interval
+	}
+}
+
+func (p *HTTPProxy) Metrics() *Metrics {
+	return p.metrics
+}
+
+// Close closes any connections to other nodes.
+func (p *HTTPProxy) Close() error {
+	p.forwardTransport.CloseIdleConnections()
+	if p.rpcClient != nil {
+		return p.rpcClient.Close()
+	}
+	return nil
+}
+
 func (p *HTTPProxy) dialUpstream(ctx context.Context, _, _ string) (net.Conn, error) {
 	// As a bit of a hack to work with http.Transport, we add the upstream
 	// to the dial context.
-	upstream := ctx.Value(upstreamContextKey).(upstream.Upstream)
-	return upstream.Dial()
+	u := ctx.Value(upstreamContextKey).(upstream.Upstream)
+	conn, err := u.Dial()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := u.(*upstream.ConnUpstream); ok {
+		conn = &flowControlConn{
+			Conn:    conn,
+			blocked: p.metrics.UpstreamWriteBlockedSeconds,
+		}
+	}
+	return conn, nil
 }
 
-func (p *HTTPProxy) errorHandler(w http.ResponseWriter, _ *http.Request, err error) {
+func (p *HTTPProxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
 	p.logger.Warn("proxy request", zap.Error(err))
 
-	if errors.Is(err, context.DeadlineExceeded) {
+	trace.SpanFromContext(r.Context()).RecordError(err)
+
+	endpointID, _ := r.Context().Value(endpointContextKey).(string)
+
+	if errors.Is(err, context.DeadlineExceeded) || streaming.TimedOut(r.Context()) {
+		p.stats.RecordError(endpointID, http.StatusGatewayTimeout, err.Error())
+
 		_ = errorResponse(w, http.StatusGatewayTimeout, "upstream timeout")
 		return
 	}
+
+	p.stats.RecordError(endpointID, http.StatusBadGateway, err.Error())
+
 	_ = errorResponse(w, http.StatusBadGateway, "upstream unreachable")
 }
 
@@ -2,18 +2,26 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/federation"
+	"github.com/andydunstall/piko/server/nodeauth"
 	"github.com/andydunstall/piko/server/upstream"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type fakeManager struct {
@@ -79,6 +87,7 @@ func TestHTTPProxy_Forward(t *testing.T) {
 				},
 			},
 			time.Second,
+			config.ForwardConfig{},
 			log.NewNopLogger(),
 		)
 
@@ -121,6 +130,7 @@ func TestHTTPProxy_Forward(t *testing.T) {
 				},
 			},
 			time.Millisecond,
+			config.ForwardConfig{},
 			log.NewNopLogger(),
 		)
 
@@ -140,6 +150,89 @@ func TestHTTPProxy_Forward(t *testing.T) {
 		assert.Equal(t, "upstream timeout", m.Error)
 	})
 
+	t.Run("streaming response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for i := 0; i != 3; i++ {
+					// nolint
+					w.Write([]byte("data: foo\n\n"))
+					w.(http.Flusher).Flush()
+
+					// Exceed the proxy timeout.
+					<-time.After(time.Millisecond * 20)
+				}
+			},
+		))
+		defer server.Close()
+
+		proxy := NewHTTPProxy(
+			&fakeManager{
+				handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+					return &tcpUpstream{
+						addr: server.Listener.Addr().String(),
+					}, true
+				},
+			},
+			time.Millisecond*10,
+			config.ForwardConfig{},
+			log.NewNopLogger(),
+		)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, w.Flushed)
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("data: foo\n\n", 3), string(b))
+	})
+
+	t.Run("upstream at capacity", func(t *testing.T) {
+		u := upstream.NewConnUpstream("my-endpoint", "", nil)
+		u.SetMaxRequests(1)
+		require.True(t, u.Acquire(context.Background()))
+		defer u.Release()
+
+		proxy := NewHTTPProxy(
+			&fakeManager{
+				handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+					return u, true
+				},
+			},
+			time.Millisecond*10,
+			config.ForwardConfig{},
+			log.NewNopLogger(),
+		)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+		m := errorMessage{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
+		assert.Equal(t, "upstream at capacity", m.Error)
+
+		assert.Equal(t, 1.0, testutil.ToFloat64(
+			proxy.Metrics().RejectedRequestsTotal.WithLabelValues("upstream_max_requests"),
+		))
+	})
+
 	t.Run("upstream unreachable", func(t *testing.T) {
 		proxy := NewHTTPProxy(
 			&fakeManager{
@@ -152,6 +245,7 @@ func TestHTTPProxy_Forward(t *testing.T) {
 				},
 			},
 			time.Second,
+			config.ForwardConfig{},
 			log.NewNopLogger(),
 		)
 
@@ -181,6 +275,7 @@ func TestHTTPProxy_Forward(t *testing.T) {
 				},
 			},
 			time.Second,
+			config.ForwardConfig{},
 			log.NewNopLogger(),
 		)
 
@@ -210,6 +305,7 @@ func TestHTTPProxy_Forward(t *testing.T) {
 				},
 			},
 			time.Second,
+			config.ForwardConfig{},
 			log.NewNopLogger(),
 		)
 
@@ -230,8 +326,378 @@ func TestHTTPProxy_Forward(t *testing.T) {
 		assert.Equal(t, "no available upstreams", m.Error)
 	})
 
+	t.Run("forward to node authenticated", func(t *testing.T) {
+		auth := nodeauth.NewAuthenticator("my-token")
+
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.True(t, auth.VerifyRequest(r))
+
+				// nolint
+				w.Write([]byte("bar"))
+			},
+		))
+		defer server.Close()
+
+		node := &cluster.Node{
+			ID:        "node-1",
+			ProxyAddr: server.Listener.Addr().String(),
+		}
+		proxy := NewHTTPProxy(
+			&fakeManager{
+				handler: func(endpointID string, _ bool) (upstream.Upstream, bool) {
+					return upstream.NewNodeUpstream(endpointID, node), true
+				},
+			},
+			time.Second,
+			config.ForwardConfig{},
+			log.NewNopLogger(),
+		)
+		defer proxy.Close()
+		proxy.nodeAuth = auth
+
+		r := httptest.NewRequest(http.MethodGet, "/foo?bar=baz", nil)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("forward to node reuses connection", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "true", r.Header.Get("x-piko-forward"))
+
+				// nolint
+				w.Write([]byte("bar"))
+			},
+		))
+		defer server.Close()
+
+		node := &cluster.Node{
+			ID:        "node-1",
+			ProxyAddr: server.Listener.Addr().String(),
+		}
+		proxy := NewHTTPProxy(
+			&fakeManager{
+				handler: func(endpointID string, _ bool) (upstream.Upstream, bool) {
+					return upstream.NewNodeUpstream(endpointID, node), true
+				},
+			},
+			time.Second,
+			config.ForwardConfig{
+				MaxIdleConns: 10,
+				IdleTimeout:  time.Minute,
+			},
+			log.NewNopLogger(),
+		)
+
+		for i := 0; i != 3; i++ {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, r)
+
+			resp := w.Result()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			resp.Body.Close()
+		}
+
+		metrics := proxy.Metrics()
+		assert.Equal(
+			t, 1.0, testutil.ToFloat64(metrics.ForwardConnectionsOpenedTotal),
+		)
+		assert.Equal(
+			t, 1.0, testutil.ToFloat64(metrics.ForwardConnections),
+		)
+		assert.Equal(t, 2.0, testutil.ToFloat64(
+			metrics.ForwardRequestsTotal.WithLabelValues("true"),
+		))
+		// Verify the latency of the forwarded requests was recorded for
+		// the target node.
+		assert.Equal(
+			t, 1, testutil.CollectAndCount(metrics.ForwardRequestLatency),
+		)
+	})
+
+	t.Run("forward to node max conns", func(t *testing.T) {
+		blockCh := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				<-blockCh
+
+				// nolint
+				w.Write([]byte("bar"))
+			},
+		))
+		defer server.Close()
+
+		node := &cluster.Node{
+			ID:        "node-1",
+			ProxyAddr: server.Listener.Addr().String(),
+		}
+		proxy := NewHTTPProxy(
+			&fakeManager{
+				handler: func(endpointID string, _ bool) (upstream.Upstream, bool) {
+					return upstream.NewNodeUpstream(endpointID, node), true
+				},
+			},
+			time.Second,
+			config.ForwardConfig{
+				MaxConns:     1,
+				MaxIdleConns: 10,
+				IdleTimeout:  time.Minute,
+			},
+			log.NewNopLogger(),
+		)
+		metrics := proxy.Metrics()
+
+		var wg sync.WaitGroup
+		for i := 0; i != 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+				w := httptest.NewRecorder()
+				proxy.ServeHTTP(w, r)
+
+				assert.Equal(t, http.StatusOK, w.Code)
+			}()
+		}
+
+		// One request waits for the other to release the connection.
+		assert.Eventually(t, func() bool {
+			return testutil.ToFloat64(metrics.ForwardConnections) == 1 &&
+				testutil.ToFloat64(metrics.ForwardRequestsWaiting) == 1
+		}, time.Second, time.Millisecond*10)
+
+		close(blockCh)
+		wg.Wait()
+
+		assert.Equal(
+			t, 1.0, testutil.ToFloat64(metrics.ForwardConnectionsOpenedTotal),
+		)
+		assert.Equal(
+			t, 0.0, testutil.ToFloat64(metrics.ForwardRequestsWaiting),
+		)
+		assert.Equal(
+			t, 1, testutil.CollectAndCount(metrics.ForwardConnWaitLatency),
+		)
+	})
+
+	t.Run("forward to node with compression", func(t *testing.T) {
+		for _, encoding := range []string{"gzip", "zstd"} {
+			t.Run(encoding, func(t *testing.T) {
+				upstreamServer := httptest.NewServer(http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						assert.Empty(t, r.Header.Get("x-piko-forward-encoding"))
+						assert.Empty(t, r.Header.Get("x-piko-forward-accept-encoding"))
+
+						buf := new(strings.Builder)
+						// nolint
+						io.Copy(buf, r.Body)
+						assert.Equal(t, strings.Repeat("foo", 1000), buf.String())
+
+						w.Header().Set("x-my-response-header", "my-value")
+						w.WriteHeader(http.StatusCreated)
+						// nolint
+						w.Write([]byte(strings.Repeat("bar", 1000)))
+					},
+				))
+				defer upstreamServer.Close()
+
+				// The remote node accepts the forwarded request and proxies
+				// to the upstream.
+				remoteProxy := NewHTTPProxy(
+					&fakeManager{
+						handler: func(_ string, allowForward bool) (upstream.Upstream, bool) {
+							assert.False(t, allowForward)
+							return &tcpUpstream{
+								addr: upstreamServer.Listener.Addr().String(),
+							}, true
+						},
+					},
+					time.Second,
+					config.ForwardConfig{},
+					log.NewNopLogger(),
+				)
+				remoteServer := httptest.NewServer(http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						// Verify the request body is compressed between nodes.
+						assert.Equal(t, encoding, r.Header.Get("x-piko-forward-encoding"))
+						assert.Equal(t, encoding, r.Header.Get("x-piko-forward-accept-encoding"))
+
+						remoteProxy.ServeHTTP(w, r)
+					},
+				))
+				defer remoteServer.Close()
+
+				node := &cluster.Node{
+					ID:        "node-1",
+					ProxyAddr: remoteServer.Listener.Addr().String(),
+				}
+				proxy := NewHTTPProxy(
+					&fakeManager{
+						handler: func(endpointID string, _ bool) (upstream.Upstream, bool) {
+							return upstream.NewNodeUpstream(endpointID, node), true
+						},
+					},
+					time.Second,
+					config.ForwardConfig{
+						Compression: encoding,
+					},
+					log.NewNopLogger(),
+				)
+				defer proxy.Close()
+
+				b := bytes.NewReader([]byte(strings.Repeat("foo", 1000)))
+				r := httptest.NewRequest(http.MethodPost, "/", b)
+				r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+				w := httptest.NewRecorder()
+				proxy.ServeHTTP(w, r)
+
+				resp := w.Result()
+				defer resp.Body.Close()
+
+				assert.Equal(t, http.StatusCreated, resp.StatusCode)
+				assert.Equal(t, "my-value", resp.Header.Get("x-my-response-header"))
+				assert.Empty(t, resp.Header.Get("x-piko-forward-encoding"))
+
+				buf := new(strings.Builder)
+				// nolint
+				io.Copy(buf, resp.Body)
+				assert.Equal(t, strings.Repeat("bar", 1000), buf.String())
+			})
+		}
+	})
+
+	t.Run("forward to remote cluster", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "true", r.Header.Get("x-piko-federated"))
+
+				// nolint
+				w.Write([]byte("bar"))
+			},
+		))
+		defer upstreamServer.Close()
+
+		// The remote cluster has an upstream for the endpoint.
+		remoteState := cluster.NewState(&cluster.Node{
+			ID:     "remote",
+			Status: cluster.NodeStatusActive,
+		}, log.NewNopLogger())
+		remoteState.AddLocalEndpoint("my-endpoint")
+		remoteFederation, err := federation.NewFederation(
+			remoteState,
+			config.FederationConfig{
+				Token:        "my-token",
+				SyncInterval: time.Second,
+			},
+			log.NewNopLogger(),
+		)
+		require.NoError(t, err)
+
+		remoteServer := NewServer(
+			&fakeManager{
+				handler: func(_ string, allowForward bool) (upstream.Upstream, bool) {
+					// Requests from a remote cluster may be forwarded
+					// within the cluster.
+					assert.True(t, allowForward)
+					return &tcpUpstream{
+						addr: upstreamServer.Listener.Addr().String(),
+					}, true
+				},
+			},
+			config.ProxyConfig{},
+			remoteFederation,
+			nil,
+			nil,
+			log.NewNopLogger(),
+		)
+		remoteLn, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go func() {
+			_ = remoteServer.Serve(remoteLn)
+		}()
+		defer remoteServer.Shutdown(context.Background())
+
+		localFederation, err := federation.NewFederation(
+			cluster.NewState(&cluster.Node{
+				ID:     "local",
+				Status: cluster.NodeStatusActive,
+			}, log.NewNopLogger()),
+			config.FederationConfig{
+				Token: "my-token",
+				Clusters: map[string]string{
+					"remote": "http://" + remoteLn.Addr().String(),
+				},
+				SyncInterval: time.Second,
+			},
+			log.NewNopLogger(),
+		)
+		require.NoError(t, err)
+		localFederation.Sync(context.Background())
+
+		// The local cluster has no upstreams for the endpoint.
+		proxy := NewHTTPProxy(
+			&fakeManager{
+				handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+					return nil, false
+				},
+			},
+			time.Second,
+			config.ForwardConfig{},
+			log.NewNopLogger(),
+		)
+		proxy.federation = localFederation
+		defer proxy.Close()
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		buf := new(strings.Builder)
+		// nolint
+		io.Copy(buf, resp.Body)
+		assert.Equal(t, "bar", buf.String())
+
+		// Requests from another cluster must not be forwarded to a remote
+		// cluster.
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+		r.Header.Add("x-piko-federated", "true")
+
+		w = httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		federatedResp := w.Result()
+		defer federatedResp.Body.Close()
+
+		assert.Equal(t, http.StatusBadGateway, federatedResp.StatusCode)
+	})
+
 	t.Run("missing endpoint id", func(t *testing.T) {
-		proxy := NewHTTPProxy(nil, time.Second, log.NewNopLogger())
+		proxy := NewHTTPProxy(
+			nil, time.Second, config.ForwardConfig{}, log.NewNopLogger(),
+		)
 
 		r := httptest.NewRequest(http.MethodGet, "/", nil)
 		// The host must have a '.' separator to be parsed as an endpoint ID.
@@ -278,3 +744,74 @@ func TestEndpointIDFromRequest(t *testing.T) {
 		assert.Equal(t, "", endpointID)
 	})
 }
+
+func BenchmarkHTTPProxy_Forward(b *testing.B) {
+	body := bytes.Repeat([]byte("a"), 64*1024)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// nolint
+			io.Copy(io.Discard, r.Body)
+			// nolint
+			w.Write(body)
+		},
+	))
+	defer server.Close()
+
+	proxy := NewHTTPProxy(
+		&fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				return &tcpUpstream{
+					addr: server.Listener.Addr().String(),
+				}, true
+			},
+		},
+		time.Second,
+		config.ForwardConfig{},
+		log.NewNopLogger(),
+	)
+	defer proxy.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			b.Fatalf("unexpected status: %d", w.Code)
+		}
+	}
+}
+
+func BenchmarkEndpointIDFromRequest(b *testing.B) {
+	b.Run("header", func(b *testing.B) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if EndpointIDFromRequest(r) != "my-endpoint" {
+				b.Fatal("unexpected endpoint id")
+			}
+		}
+	})
+
+	b.Run("host", func(b *testing.B) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = "my-endpoint.piko.example.com"
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if EndpointIDFromRequest(r) != "my-endpoint" {
+				b.Fatal("unexpected endpoint id")
+			}
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// endpointAllowList is the client IP ranges permitted to reach the endpoints
+// matching the endpoint pattern.
+type endpointAllowList struct {
+	endpoint string
+	allow    []*net.IPNet
+}
+
+// ipFilter rejects requests from clients that aren't permitted by the
+// allow and deny lists, or by the allow list of the requested endpoint.
+// Requests that are rejected get a 403.
+//
+// The client IP is determined by the router's trusted proxies.
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+
+	endpoints []endpointAllowList
+
+	rejected *prometheus.CounterVec
+
+	logger log.Logger
+}
+
+func newIPFilter(
+	allow []*net.IPNet,
+	deny []*net.IPNet,
+	endpoints []endpointAllowList,
+	rejected *prometheus.CounterVec,
+	logger log.Logger,
+) *ipFilter {
+	return &ipFilter{
+		allow:     allow,
+		deny:      deny,
+		endpoints: endpoints,
+		rejected:  rejected,
+		logger:    logger,
+	}
+}
+
+// Enabled returns whether any allow or deny ranges are configured for all
+// endpoints.
+func (f *ipFilter) Enabled() bool {
+	return len(f.allow) > 0 || len(f.deny) > 0
+}
+
+// Handler rejects requests from clients that aren't permitted.
+func (f *ipFilter) Handler(c *gin.Context) {
+	clientIP := c.ClientIP()
+	if f.Permitted(net.ParseIP(clientIP)) {
+		c.Next()
+		return
+	}
+
+	f.logger.Debug(
+		"client ip not permitted",
+		zap.String("client-ip", clientIP),
+	)
+	f.reject(c)
+}
+
+// PermitEndpoint returns whether the client is permitted to reach the given
+// endpoint. If not, writes an error response and returns false.
+func (f *ipFilter) PermitEndpoint(c *gin.Context, endpointID string) bool {
+	if len(f.endpoints) == 0 {
+		return true
+	}
+
+	clientIP := c.ClientIP()
+	if f.EndpointPermitted(endpointID, net.ParseIP(clientIP)) {
+		return true
+	}
+
+	f.logger.Debug(
+		"client ip not permitted for endpoint",
+		zap.String("client-ip", clientIP),
+		zap.String("endpoint-id", endpointID),
+	)
+	f.reject(c)
+	return false
+}
+
+// Permitted returns whether the given client IP is permitted. If the client
+// IP is unknown (such as connections to a Unix socket), it's only permitted
+// if there's no allow list.
+func (f *ipFilter) Permitted(ip net.IP) bool {
+	if ip == nil {
+		return len(f.allow) == 0
+	}
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	return containsIP(f.allow, ip)
+}
+
+// EndpointPermitted returns whether the given client IP is permitted to reach
+// the endpoint. Endpoints without an allow list permit all clients.
+//
+// Only the first entry matching the endpoint is used.
+func (f *ipFilter) EndpointPermitted(endpointID string, ip net.IP) bool {
+	for _, e := range f.endpoints {
+		if !auth.MatchEndpoint(e.endpoint, endpointID) {
+			continue
+		}
+		if len(e.allow) == 0 {
+			return true
+		}
+		return ip != nil && containsIP(e.allow, ip)
+	}
+	return true
+}
+
+func (f *ipFilter) reject(c *gin.Context) {
+	f.rejected.With(prometheus.Labels{"reason": "ip_denied"}).Inc()
+
+	c.Abort()
+	_ = errorResponse(c.Writer, http.StatusForbidden, "forbidden")
+}
+
+func containsIP(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
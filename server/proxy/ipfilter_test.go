@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPFilter_Permitted(t *testing.T) {
+	tests := []struct {
+		name      string
+		allow     []string
+		deny      []string
+		ip        string
+		permitted bool
+	}{
+		{
+			name:      "allowed",
+			allow:     []string{"10.0.0.0/8"},
+			ip:        "10.26.104.14",
+			permitted: true,
+		},
+		{
+			name:      "not allowed",
+			allow:     []string{"10.0.0.0/8"},
+			ip:        "192.168.1.1",
+			permitted: false,
+		},
+		{
+			name:      "allowed single ip",
+			allow:     []string{"192.168.1.1"},
+			ip:        "192.168.1.1",
+			permitted: true,
+		},
+		{
+			name:      "denied",
+			deny:      []string{"192.168.0.0/16"},
+			ip:        "192.168.1.1",
+			permitted: false,
+		},
+		{
+			name:      "not denied",
+			deny:      []string{"192.168.0.0/16"},
+			ip:        "10.26.104.14",
+			permitted: true,
+		},
+		{
+			name:      "deny takes precedence",
+			allow:     []string{"10.0.0.0/8"},
+			deny:      []string{"10.26.0.0/16"},
+			ip:        "10.26.104.14",
+			permitted: false,
+		},
+		{
+			name:      "ipv6",
+			allow:     []string{"fd00::/8"},
+			ip:        "fd12::1",
+			permitted: true,
+		},
+		{
+			name:      "unknown ip with allow list",
+			allow:     []string{"10.0.0.0/8"},
+			permitted: false,
+		},
+		{
+			name:      "unknown ip with deny list",
+			deny:      []string{"10.0.0.0/8"},
+			permitted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allow, err := config.ParseCIDRs(tt.allow)
+			require.NoError(t, err)
+			deny, err := config.ParseCIDRs(tt.deny)
+			require.NoError(t, err)
+
+			filter := newIPFilter(
+				allow, deny, nil, NewMetrics().RejectedRequestsTotal, log.NewNopLogger(),
+			)
+			assert.Equal(t, tt.permitted, filter.Permitted(net.ParseIP(tt.ip)))
+		})
+	}
+}
+
+func TestIPFilter_EndpointPermitted(t *testing.T) {
+	office, err := config.ParseCIDRs([]string{"10.26.0.0/16"})
+	require.NoError(t, err)
+	vpn, err := config.ParseCIDRs([]string{"10.27.0.0/16"})
+	require.NoError(t, err)
+
+	filter := newIPFilter(
+		nil,
+		nil,
+		[]endpointAllowList{
+			{endpoint: "my-db-admin", allow: office},
+			{endpoint: "team-a-*", allow: vpn},
+			// Ignored as 'team-a-*' takes precedence.
+			{endpoint: "team-a-api", allow: office},
+			// Entries without an allow list permit all clients.
+			{endpoint: "my-webhook"},
+		},
+		NewMetrics().RejectedRequestsTotal,
+		log.NewNopLogger(),
+	)
+
+	tests := []struct {
+		endpointID string
+		ip         string
+		permitted  bool
+	}{
+		{"my-db-admin", "10.26.104.14", true},
+		{"my-db-admin", "10.27.104.14", false},
+		{"my-db-admin", "", false},
+		{"team-a-api", "10.27.104.14", true},
+		{"team-a-api", "10.26.104.14", false},
+		{"my-webhook", "192.168.1.1", true},
+		// Endpoints without an allow list permit all clients.
+		{"my-endpoint", "192.168.1.1", true},
+		{"my-endpoint", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpointID+"/"+tt.ip, func(t *testing.T) {
+			assert.Equal(
+				t,
+				tt.permitted,
+				filter.EndpointPermitted(tt.endpointID, net.ParseIP(tt.ip)),
+			)
+		})
+	}
+}
+
+func TestIPFilter_Handler(t *testing.T) {
+	allow, err := config.ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	metrics := NewMetrics()
+	filter := newIPFilter(
+		allow, nil, nil, metrics.RejectedRequestsTotal, log.NewNopLogger(),
+	)
+
+	router := gin.New()
+	require.NoError(t, router.SetTrustedProxies([]string{"172.16.0.0/12"}))
+	router.Use(filter.Handler)
+	router.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  string
+		expectedCode  int
+		expectedCount float64
+	}{
+		{
+			name:         "allowed",
+			remoteAddr:   "10.26.104.14:5000",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "not allowed",
+			remoteAddr:    "192.168.1.1:5000",
+			expectedCode:  http.StatusForbidden,
+			expectedCount: 1,
+		},
+		{
+			name:         "trusted proxy",
+			remoteAddr:   "172.16.0.1:5000",
+			forwardedFor: "10.26.104.14",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "untrusted proxy",
+			remoteAddr:    "192.168.1.1:5000",
+			forwardedFor:  "10.26.104.14",
+			expectedCode:  http.StatusForbidden,
+			expectedCount: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics.RejectedRequestsTotal.Reset()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, tt.expectedCount, promtestutil.ToFloat64(
+				metrics.RejectedRequestsTotal.With(prometheus.Labels{
+					"reason": "ip_denied",
+				}),
+			))
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andydunstall/piko/server/upstream"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type connLimitExceededKey struct{}
+
+// limiter limits the number of open downstream connections and concurrent
+// proxied requests. Requests exceeding either limit are rejected with a 503.
+type limiter struct {
+	maxConns    int64
+	maxRequests int64
+
+	conns    atomic.Int64
+	requests atomic.Int64
+
+	rejected *prometheus.CounterVec
+}
+
+func newLimiter(maxConns, maxRequests int, rejected *prometheus.CounterVec) *limiter {
+	return &limiter{
+		maxConns:    int64(maxConns),
+		maxRequests: int64(maxRequests),
+		rejected:    rejected,
+	}
+}
+
+// Listener wraps the given listener to count the open connections.
+func (l *limiter) Listener(ln net.Listener) net.Listener {
+	if l.maxConns == 0 {
+		return ln
+	}
+	return &limitListener{
+		Listener: ln,
+		limiter:  l,
+	}
+}
+
+// ConnContext adds whether the connection exceeds the connection limit to
+// the connection context.
+func (l *limiter) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		c = tlsConn.NetConn()
+	}
+	if conn, ok := c.(*limitConn); ok && conn.exceeded {
+		return context.WithValue(ctx, connLimitExceededKey{}, true)
+	}
+	return ctx
+}
+
+// Handler rejects requests exceeding the limits.
+func (l *limiter) Handler(c *gin.Context) {
+	if exceeded, _ := c.Request.Context().Value(connLimitExceededKey{}).(bool); exceeded {
+		l.rejected.With(prometheus.Labels{"reason": "max_connections"}).Inc()
+
+		// Close the connection so the client doesn't reuse it.
+		c.Header("Connection", "close")
+		c.AbortWithStatusJSON(
+			http.StatusServiceUnavailable,
+			gin.H{"error": "too many connections"},
+		)
+		return
+	}
+
+	if l.maxRequests == 0 {
+		c.Next()
+		return
+	}
+
+	if l.requests.Add(1) > l.maxRequests {
+		l.requests.Add(-1)
+
+		l.rejected.With(prometheus.Labels{"reason": "max_concurrent_requests"}).Inc()
+		c.AbortWithStatusJSON(
+			http.StatusServiceUnavailable,
+			gin.H{"error": "too many concurrent requests"},
+		)
+		return
+	}
+	defer l.requests.Add(-1)
+
+	c.Next()
+}
+
+type limitListener struct {
+	net.Listener
+
+	limiter *limiter
+}
+
+func (ln *limitListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	// Rather than closing connections that exceed the limit, they are
+	// accepted so requests are rejected with a 503.
+	n := ln.limiter.conns.Add(1)
+	return &limitConn{
+		Conn:     conn,
+		limiter:  ln.limiter,
+		exceeded: n > ln.limiter.maxConns,
+	}, nil
+}
+
+type limitConn struct {
+	net.Conn
+
+	limiter *limiter
+
+	// exceeded indicates the connection was accepted when the number of
+	// open connections exceeded the limit.
+	exceeded bool
+
+	closeOnce sync.Once
+}
+
+func (c *limitConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.limiter.conns.Add(-1)
+	})
+	return c.Conn.Close()
+}
+
+// acquireUpstream waits for capacity to send a request to the upstream, if
+// the upstream is connected to the local node and has a concurrent request
+// limit. Returns false if the context is cancelled first.
+//
+// If true, the returned function must be called once the request completes.
+func acquireUpstream(ctx context.Context, u upstream.Upstream) (func(), bool) {
+	conn, ok := u.(*upstream.ConnUpstream)
+	if !ok {
+		return func() {}, true
+	}
+	if !conn.Acquire(ctx) {
+		return nil, false
+	}
+	return conn.Release, true
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Run("max connections", func(t *testing.T) {
+		metrics := NewMetrics()
+		limiter := newLimiter(1, 0, metrics.RejectedRequestsTotal)
+
+		router := gin.New()
+		router.Use(limiter.Handler)
+		router.GET("/", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		server := &http.Server{
+			Handler:     router,
+			ConnContext: limiter.ConnContext,
+		}
+		go func() {
+			_ = server.Serve(limiter.Listener(ln))
+		}()
+		defer server.Close()
+
+		url := "http://" + ln.Addr().String()
+
+		// Use separate transports so each client has its own connection.
+		client1 := &http.Client{Transport: &http.Transport{}}
+		client2 := &http.Client{Transport: &http.Transport{}}
+
+		resp, err := client1.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// The first client keeps its connection open so the second
+		// client exceeds the limit.
+		resp, err = client2.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.True(t, resp.Close)
+
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(
+			metrics.RejectedRequestsTotal.With(prometheus.Labels{
+				"reason": "max_connections",
+			}),
+		))
+
+		// Once the first client closes its connection, the second client
+		// can connect.
+		client1.CloseIdleConnections()
+		assert.Eventually(t, func() bool {
+			resp, err := client2.Get(url)
+			if err != nil {
+				return false
+			}
+			resp.Body.Close()
+			return resp.StatusCode == http.StatusOK
+		}, time.Second, time.Millisecond*10)
+	})
+
+	t.Run("max concurrent requests", func(t *testing.T) {
+		metrics := NewMetrics()
+		limiter := newLimiter(0, 1, metrics.RejectedRequestsTotal)
+
+		blockCh := make(chan struct{})
+		router := gin.New()
+		router.Use(limiter.Handler)
+		router.GET("/", func(c *gin.Context) {
+			<-blockCh
+			c.Status(http.StatusOK)
+		})
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		server := &http.Server{
+			Handler:     router,
+			ConnContext: limiter.ConnContext,
+		}
+		go func() {
+			_ = server.Serve(limiter.Listener(ln))
+		}()
+		defer server.Close()
+
+		url := "http://" + ln.Addr().String()
+
+		respCh := make(chan int, 1)
+		go func() {
+			resp, err := http.Get(url)
+			if err != nil {
+				respCh <- 0
+				return
+			}
+			resp.Body.Close()
+			respCh <- resp.StatusCode
+		}()
+
+		// Wait for the first request to be in progress.
+		require.Eventually(t, func() bool {
+			return limiter.requests.Load() == 1
+		}, time.Second, time.Millisecond)
+
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(
+			metrics.RejectedRequestsTotal.With(prometheus.Labels{
+				"reason": "max_concurrent_requests",
+			}),
+		))
+
+		close(blockCh)
+		assert.Equal(t, http.StatusOK, <-respCh)
+
+		// Once the first request completes, new requests are accepted.
+		resp, err = http.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
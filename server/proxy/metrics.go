@@ -0,0 +1,201 @@
+package proxy
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type Metrics struct {
+	// ForwardConnectionsOpenedTotal is the total number of connections opened
+	// to other nodes to forward requests.
+	ForwardConnectionsOpenedTotal prometheus.Counter
+
+	// ForwardConnections is the number of open connections to other nodes
+	// to forward requests, including idle connections.
+	ForwardConnections prometheus.Gauge
+
+	// ForwardRequestsTotal is the total number of requests forwarded to
+	// other nodes, labelled by whether the request reused an existing
+	// connection.
+	ForwardRequestsTotal *prometheus.CounterVec
+
+	// ForwardRequestsWaiting is the number of requests forwarded to other
+	// nodes that are waiting for a connection, such as when the connection
+	// limit to a node is reached.
+	ForwardRequestsWaiting prometheus.Gauge
+
+	// ForwardConnWaitLatency is the time requests forwarded to other nodes
+	// waited for a connection.
+	ForwardConnWaitLatency prometheus.Histogram
+
+	// ForwardRequestLatency is the latency of requests forwarded to other
+	// nodes, labelled by the target node ID.
+	ForwardRequestLatency *prometheus.HistogramVec
+
+	// EndpointRequestsTotal is the total number of proxied requests,
+	// labelled by tenant, endpoint ID and status class.
+	EndpointRequestsTotal *prometheus.CounterVec
+
+	// EndpointRequestLatency is the latency of proxied requests, labelled by
+	// tenant and endpoint ID.
+	EndpointRequestLatency *prometheus.HistogramVec
+
+	// EndpointRequestBytesTotal is the total number of request body bytes
+	// proxied, labelled by tenant and endpoint ID.
+	EndpointRequestBytesTotal *prometheus.CounterVec
+
+	// EndpointResponseBytesTotal is the total number of response body bytes
+	// proxied, labelled by tenant and endpoint ID.
+	EndpointResponseBytesTotal *prometheus.CounterVec
+
+	// RejectedRequestsTotal is the total number of requests rejected due to
+	// exceeding the connection or concurrent request limits, failing client
+	// authentication, or coming from a client IP that isn't permitted,
+	// labelled by the reason.
+	RejectedRequestsTotal *prometheus.CounterVec
+
+	// UpstreamWriteBlockedSeconds is the total number of seconds spent
+	// blocked writing requests to upstreams connected to the local node,
+	// such as when the upstream isn't reading the request body fast enough.
+	UpstreamWriteBlockedSeconds prometheus.Counter
+
+	// DownstreamWriteBlockedSeconds is the total number of seconds spent
+	// blocked writing responses from upstreams connected to the local node
+	// to downstream clients, such as when the client isn't reading the
+	// response fast enough.
+	DownstreamWriteBlockedSeconds prometheus.Counter
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		ForwardConnectionsOpenedTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "forward_connections_opened_total",
+				Help:      "Total number of connections opened to other nodes",
+			},
+		),
+		ForwardConnections: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "forward_connections",
+				Help:      "Number of open connections to other nodes",
+			},
+		),
+		ForwardRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "forward_requests_total",
+				Help:      "Total number of requests forwarded to other nodes",
+			},
+			[]string{"reused"},
+		),
+		ForwardRequestsWaiting: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "forward_requests_waiting",
+				Help:      "Number of requests forwarded to other nodes waiting for a connection",
+			},
+		),
+		ForwardConnWaitLatency: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "forward_conn_wait_seconds",
+				Help:      "Time requests forwarded to other nodes waited for a connection",
+				Buckets:   prometheus.DefBuckets,
+			},
+		),
+		ForwardRequestLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "forward_request_latency_seconds",
+				Help:      "Latency of requests forwarded to other nodes",
+				Buckets:   prometheus.DefBuckets,
+			},
+			[]string{"node_id"},
+		),
+		EndpointRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "endpoint_requests_total",
+				Help:      "Total number of proxied requests by endpoint",
+			},
+			[]string{"tenant", "endpoint_id", "status_class"},
+		),
+		EndpointRequestLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "endpoint_request_latency_seconds",
+				Help:      "Proxied request latency by endpoint",
+				Buckets:   prometheus.DefBuckets,
+			},
+			[]string{"tenant", "endpoint_id"},
+		),
+		EndpointRequestBytesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "endpoint_request_bytes_total",
+				Help:      "Total proxied request body bytes by endpoint",
+			},
+			[]string{"tenant", "endpoint_id"},
+		),
+		EndpointResponseBytesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "endpoint_response_bytes_total",
+				Help:      "Total proxied response body bytes by endpoint",
+			},
+			[]string{"tenant", "endpoint_id"},
+		),
+		RejectedRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "rejected_requests_total",
+				Help:      "Total number of requests rejected before being proxied",
+			},
+			[]string{"reason"},
+		),
+		UpstreamWriteBlockedSeconds: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "upstream_write_blocked_seconds_total",
+				Help:      "Total seconds spent blocked writing requests to upstreams",
+			},
+		),
+		DownstreamWriteBlockedSeconds: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "proxy",
+				Name:      "downstream_write_blocked_seconds_total",
+				Help:      "Total seconds spent blocked writing upstream responses to downstream clients",
+			},
+		),
+	}
+}
+
+func (m *Metrics) Register(registry *prometheus.Registry) {
+	registry.MustRegister(
+		m.ForwardConnectionsOpenedTotal,
+		m.ForwardConnections,
+		m.ForwardRequestsTotal,
+		m.ForwardRequestsWaiting,
+		m.ForwardConnWaitLatency,
+		m.ForwardRequestLatency,
+		m.EndpointRequestsTotal,
+		m.EndpointRequestLatency,
+		m.EndpointRequestBytesTotal,
+		m.EndpointResponseBytesTotal,
+		m.RejectedRequestsTotal,
+		m.UpstreamWriteBlockedSeconds,
+		m.DownstreamWriteBlockedSeconds,
+	)
+}
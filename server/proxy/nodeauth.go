@@ -0,0 +1,19 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+)
+
+// withNodeForward returns a context indicating the request was forwarded
+// from an authenticated node.
+func withNodeForward(ctx context.Context) context.Context {
+	return context.WithValue(ctx, nodeForwardContextKey, true)
+}
+
+// isNodeForward returns whether the request was forwarded from an
+// authenticated node.
+func isNodeForward(r *http.Request) bool {
+	forwarded, _ := r.Context().Value(nodeForwardContextKey).(bool)
+	return forwarded
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andydunstall/piko/server/nodeauth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_NodeForwardHandler(t *testing.T) {
+	auth := nodeauth.NewAuthenticator("my-token")
+	s := &Server{
+		httpProxy: &HTTPProxy{
+			nodeAuth: auth,
+		},
+	}
+
+	var forwarded bool
+	var credential string
+	var forwardHeader string
+	router := gin.New()
+	router.Use(s.nodeForwardHandler)
+	router.GET("/foo", func(c *gin.Context) {
+		forwarded = isNodeForward(c.Request)
+		credential = c.Request.Header.Get(nodeauth.Header)
+		forwardHeader = c.Request.Header.Get("x-piko-forward")
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		r.Header.Set("x-piko-forward", "true")
+		auth.SignRequest(r)
+
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		assert.True(t, forwarded)
+		assert.Empty(t, credential)
+		assert.Equal(t, "true", forwardHeader)
+	})
+
+	t.Run("invalid credential", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		nodeauth.NewAuthenticator("invalid-token").SignRequest(r)
+
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		assert.False(t, forwarded)
+		assert.Empty(t, credential)
+	})
+
+	t.Run("forward header", func(t *testing.T) {
+		// The forward header alone must not mark the request as forwarded
+		// from another node, and is removed.
+		r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		r.Header.Set("x-piko-forward", "true")
+
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		assert.False(t, forwarded)
+		assert.Empty(t, forwardHeader)
+	})
+}
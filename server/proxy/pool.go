@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/upstream"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newForwardTransport returns a transport that forwards requests to other
+// nodes in the cluster.
+//
+// Unlike forwarding to upstreams connected to the local node, forwarding to
+// another node requires opening a new TCP connection. Therefore the transport
+// keeps a pool of persistent connections to each node (keyed by the nodes
+// proxy address) to avoid the latency of opening a connection per request.
+func newForwardTransport(
+	conf config.ForwardConfig,
+	metrics *Metrics,
+) *http.Transport {
+	dialer := &net.Dialer{}
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			metrics.ForwardConnectionsOpenedTotal.Inc()
+			metrics.ForwardConnections.Inc()
+
+			return &trackedConn{
+				Conn:    conn,
+				metrics: metrics,
+			}, nil
+		},
+		MaxConnsPerHost:     conf.MaxConns,
+		MaxIdleConnsPerHost: conf.MaxIdleConns,
+		IdleConnTimeout:     conf.IdleTimeout,
+		// Wait for the node to respond to requests with
+		// 'Expect: 100-continue' before sending the body.
+		ExpectContinueTimeout: expectContinueTimeout,
+	}
+}
+
+// isNodeUpstream returns whether the upstream is a remote node or cluster, so
+// should be forwarded using the forward transport.
+func isNodeUpstream(u upstream.Upstream) bool {
+	switch u.(type) {
+	case *upstream.NodeUpstream, *upstream.ClusterUpstream:
+		return true
+	default:
+		return false
+	}
+}
+
+// isClusterUpstream returns whether the upstream is a remote federated
+// cluster.
+func isClusterUpstream(u upstream.Upstream) bool {
+	_, ok := u.(*upstream.ClusterUpstream)
+	return ok
+}
+
+// upstreamKind returns a description of the upstream type, used for
+// tracing.
+func upstreamKind(u upstream.Upstream) string {
+	switch u.(type) {
+	case *upstream.NodeUpstream:
+		return "node"
+	case *upstream.ClusterUpstream:
+		return "cluster"
+	default:
+		return "conn"
+	}
+}
+
+// upstreamNodeID returns the ID of the remote node if the upstream is a
+// node in the cluster.
+func upstreamNodeID(u upstream.Upstream) (string, bool) {
+	node, ok := u.(*upstream.NodeUpstream)
+	if !ok {
+		return "", false
+	}
+	return node.NodeID(), true
+}
+
+// isRPCUpstream returns whether the upstream is a remote node that accepts
+// RPC requests.
+func isRPCUpstream(u upstream.Upstream) bool {
+	node, ok := u.(*upstream.NodeUpstream)
+	return ok && node.RPCAddr() != ""
+}
+
+// isUpgrade returns whether the request is a protocol upgrade (such as a
+// WebSocket), which can only be forwarded using HTTP.
+func isUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != ""
+}
+
+// withForwardTrace adds a trace to the context to record whether forwarded
+// requests reused a pooled connection, and how long requests wait for a
+// connection.
+//
+// The returned function must be called once the request completes.
+func withForwardTrace(ctx context.Context, metrics *Metrics) (context.Context, func()) {
+	var start time.Time
+	var waiting atomic.Bool
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(_ string) {
+			start = time.Now()
+			waiting.Store(true)
+			metrics.ForwardRequestsWaiting.Inc()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if waiting.CompareAndSwap(true, false) {
+				metrics.ForwardRequestsWaiting.Dec()
+				metrics.ForwardConnWaitLatency.Observe(time.Since(start).Seconds())
+			}
+
+			metrics.ForwardRequestsTotal.With(prometheus.Labels{
+				"reused": strconv.FormatBool(info.Reused),
+			}).Inc()
+		},
+	})
+	return ctx, func() {
+		// If the request failed while waiting for a connection, GotConn
+		// isn't called.
+		if waiting.CompareAndSwap(true, false) {
+			metrics.ForwardRequestsWaiting.Dec()
+		}
+	}
+}
+
+// trackedConn updates the open connections metric when the connection is
+// closed.
+type trackedConn struct {
+	net.Conn
+
+	closeOnce sync.Once
+
+	metrics *Metrics
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.metrics.ForwardConnections.Dec()
+	})
+	return c.Conn.Close()
+}
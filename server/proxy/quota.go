@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/quota"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// tenantQuotas enforces the request rate and bandwidth quotas of each
+// tenant.
+//
+// Requests forwarded from another node were already counted by the node
+// that received the request, so aren't counted again.
+type tenantQuotas struct {
+	quotas *quota.Quotas
+
+	rejected *prometheus.CounterVec
+
+	logger log.Logger
+}
+
+func newTenantQuotas(
+	quotas *quota.Quotas,
+	rejected *prometheus.CounterVec,
+	logger log.Logger,
+) *tenantQuotas {
+	return &tenantQuotas{
+		quotas:   quotas,
+		rejected: rejected,
+		logger:   logger,
+	}
+}
+
+// Allow returns whether the request is permitted by the tenant's quotas. If
+// not, writes an error response and returns false.
+func (q *tenantQuotas) Allow(c *gin.Context, tenant string) bool {
+	if tenant == "" || isNodeForward(c.Request) {
+		return true
+	}
+
+	err := q.quotas.AllowRequest(tenant)
+	if err == nil {
+		return true
+	}
+
+	q.logger.Debug(
+		"tenant quota exceeded",
+		zap.String("tenant", tenant),
+		zap.Error(err),
+	)
+	q.rejected.With(prometheus.Labels{"reason": "quota_exceeded"}).Inc()
+
+	var quotaErr *quota.Error
+	if errors.As(err, &quotaErr) {
+		quotaErr.WriteResponse(c.Writer)
+	} else {
+		_ = errorResponse(c.Writer, http.StatusTooManyRequests, "quota exceeded")
+	}
+	return false
+}
+
+// Track wraps the response writer and request body to count the bytes
+// proxied for the tenant. The returned function records the bytes, and
+// must be called once the request completes.
+func (q *tenantQuotas) Track(
+	w http.ResponseWriter,
+	r *http.Request,
+	tenant string,
+) (http.ResponseWriter, func()) {
+	if tenant == "" || isNodeForward(r) {
+		return w, func() {}
+	}
+
+	mw := &metricsResponseWriter{ResponseWriter: w}
+	body := &metricsRequestBody{}
+	if r.Body != nil && r.Body != http.NoBody {
+		body.ReadCloser = r.Body
+		r.Body = body
+	}
+	return mw, func() {
+		q.quotas.RecordBytes(tenant, body.bytes+mw.bytes)
+	}
+}
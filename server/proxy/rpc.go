@@ -0,0 +1,550 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/nodeauth"
+	"github.com/ugorji/go/codec"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// The internal RPC service used to forward requests between nodes.
+//
+// Rather than generating the service from a protobuf definition, messages
+// are encoded with msgpack using rpcCodec.
+const (
+	rpcServiceName = "piko.proxy.v1.Proxy"
+
+	rpcForwardMethod = "/" + rpcServiceName + "/Forward"
+	rpcNodesMethod   = "/" + rpcServiceName + "/Nodes"
+)
+
+// forwardRequestHead contains the request line and headers of a forwarded
+// request.
+type forwardRequestHead struct {
+	EndpointID    string              `codec:"endpoint_id"`
+	Method        string              `codec:"method"`
+	URI           string              `codec:"uri"`
+	Host          string              `codec:"host"`
+	Header        map[string][]string `codec:"header"`
+	ContentLength int64               `codec:"content_length"`
+}
+
+// forwardResponseHead contains the status and headers of a forwarded
+// response.
+type forwardResponseHead struct {
+	StatusCode int                 `codec:"status_code"`
+	Header     map[string][]string `codec:"header"`
+}
+
+// forwardFrameSize is the maximum size of the body in each forward frame.
+//
+// Bodies are streamed in fixed size frames rather than buffered, which bounds
+// the memory used by each request and keeps frames below the gRPC maximum
+// message size regardless of the size of the body.
+const forwardFrameSize = 32 * 1024
+
+// forwardFrame is a message in the forward stream.
+//
+// The client first sends a frame with the request head, followed by frames
+// containing the request body, then closes its side of the stream. The server
+// responds with a frame containing the response head, followed by frames
+// containing the response body, then a frame containing any trailers.
+//
+// The response head may be preceded by any number of informational (1xx)
+// response heads.
+type forwardFrame struct {
+	RequestHead  *forwardRequestHead  `codec:"request_head,omitempty"`
+	ResponseHead *forwardResponseHead `codec:"response_head,omitempty"`
+	Body         []byte               `codec:"body,omitempty"`
+	Trailer      map[string][]string  `codec:"trailer,omitempty"`
+}
+
+type nodesRequest struct{}
+
+type nodesResponse struct {
+	Nodes []*cluster.NodeMetadata `codec:"nodes"`
+}
+
+var (
+	msgpackHandle codec.MsgpackHandle
+
+	// encoders and decoders pool msgpack encoders and decoders, which are
+	// expensive to create, to avoid allocating them for every frame.
+	encoders = sync.Pool{
+		New: func() any {
+			return codec.NewEncoderBytes(nil, &msgpackHandle)
+		},
+	}
+	decoders = sync.Pool{
+		New: func() any {
+			return codec.NewDecoderBytes(nil, &msgpackHandle)
+		},
+	}
+)
+
+// rpcCodec encodes RPC messages using msgpack.
+type rpcCodec struct{}
+
+func (rpcCodec) Marshal(v any) ([]byte, error) {
+	enc := encoders.Get().(*codec.Encoder)
+	defer encoders.Put(enc)
+
+	// gRPC may retain the returned bytes so encode to a new slice.
+	var b []byte
+	enc.ResetBytes(&b)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (rpcCodec) Unmarshal(data []byte, v any) error {
+	dec := decoders.Get().(*codec.Decoder)
+	defer decoders.Put(dec)
+
+	dec.ResetBytes(data)
+	err := dec.Decode(v)
+	// Don't retain data while the decoder is in the pool.
+	dec.ResetBytes(nil)
+	return err
+}
+
+func (rpcCodec) Name() string {
+	return "msgpack"
+}
+
+var rpcForwardStreamDesc = grpc.StreamDesc{
+	StreamName:    "Forward",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+var rpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: rpcServiceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Nodes",
+			Handler: func(
+				srv any,
+				ctx context.Context,
+				dec func(any) error,
+				interceptor grpc.UnaryServerInterceptor,
+			) (any, error) {
+				var req nodesRequest
+				if err := dec(&req); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(*RPCServer).nodes(ctx, &req)
+				}
+				info := &grpc.UnaryServerInfo{
+					Server:     srv,
+					FullMethod: rpcNodesMethod,
+				}
+				return interceptor(ctx, &req, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(*RPCServer).nodes(ctx, req.(*nodesRequest))
+				})
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: rpcForwardStreamDesc.StreamName,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(*RPCServer).forward(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+// RPCServer handles RPC requests from other nodes in the cluster.
+//
+// Only other nodes may send requests, so each request must either be sent
+// over a TLS connection with a verified client certificate, such as when
+// using SPIFFE, or include a node credential (see nodeauth.Authenticator).
+type RPCServer struct {
+	// handler handles forwarded requests. This is the proxy server handler,
+	// so forwarded requests are checked the same as requests forwarded
+	// using HTTP.
+	handler http.Handler
+
+	clusterState *cluster.State
+
+	auth *nodeauth.Authenticator
+
+	server *grpc.Server
+
+	logger log.Logger
+}
+
+// NewRPCServer returns a server that forwards requests to the given proxy
+// handler.
+//
+// token is the token shared by nodes in the cluster to authenticate
+// requests. If tlsConfig verifies client certificates, requests with a
+// verified certificate are also authenticated.
+func NewRPCServer(
+	handler http.Handler,
+	clusterState *cluster.State,
+	token string,
+	tlsConfig *tls.Config,
+	logger log.Logger,
+) *RPCServer {
+	s := &RPCServer{
+		handler:      handler,
+		clusterState: clusterState,
+		auth:         nodeauth.NewAuthenticator(token),
+		logger:       logger.WithSubsystem("proxy.rpc"),
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ForceServerCodec(rpcCodec{}),
+		grpc.UnaryInterceptor(s.unaryInterceptor),
+		grpc.StreamInterceptor(s.streamInterceptor),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s.server = grpc.NewServer(opts...)
+	s.server.RegisterService(&rpcServiceDesc, s)
+	return s
+}
+
+func (s *RPCServer) Serve(ln net.Listener) error {
+	s.logger.Info(
+		"starting rpc server",
+		zap.String("addr", ln.Addr().String()),
+	)
+
+	if err := s.server.Serve(ln); err != nil &&
+		!errors.Is(err, grpc.ErrServerStopped) {
+		return fmt.Errorf("grpc serve: %w", err)
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the server, waiting for active requests to
+// complete until the context is cancelled.
+func (s *RPCServer) Shutdown(ctx context.Context) error {
+	stoppedCh := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stoppedCh)
+	}()
+
+	select {
+	case <-stoppedCh:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+func (s *RPCServer) nodes(_ context.Context, _ *nodesRequest) (*nodesResponse, error) {
+	return &nodesResponse{
+		Nodes: s.clusterState.NodesMetadata(),
+	}, nil
+}
+
+func (s *RPCServer) unaryInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	if err := s.authenticate(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *RPCServer) streamInterceptor(
+	srv any,
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if err := s.authenticate(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// authenticate returns an error unless the request is from another node.
+func (s *RPCServer) authenticate(ctx context.Context, method string) error {
+	if verifiedPeerCertificate(ctx) || s.auth.VerifyRPC(ctx, method) {
+		return nil
+	}
+
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	s.logger.Warn(
+		"rpc request unauthenticated",
+		zap.String("method", method),
+		zap.String("addr", addr),
+	)
+	return status.Error(codes.Unauthenticated, "unauthenticated")
+}
+
+func (s *RPCServer) forward(stream grpc.ServerStream) error {
+	var frame forwardFrame
+	if err := stream.RecvMsg(&frame); err != nil {
+		return err
+	}
+	head := frame.RequestHead
+	if head == nil {
+		return status.Error(codes.InvalidArgument, "missing request head")
+	}
+
+	var body io.ReadCloser = http.NoBody
+	if head.ContentLength != 0 {
+		body = &rpcServerBody{
+			stream: stream,
+		}
+	}
+	// The request was forwarded from an authenticated node.
+	r, err := http.NewRequestWithContext(
+		withNodeForward(stream.Context()), head.Method, head.URI, body,
+	)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	r.Header = head.Header
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.Host = head.Host
+	r.ContentLength = head.ContentLength
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+
+	w := &rpcResponseWriter{
+		stream: stream,
+		header: make(http.Header),
+	}
+
+	aborted := s.serveHTTP(w, r)
+
+	// If the response was aborted, such as the upstream connection closing
+	// or the request timing out while copying the body, return an error so
+	// the client doesn't mistake the truncated body for a complete response.
+	if aborted {
+		return status.Error(codes.Aborted, "response aborted")
+	}
+
+	// Ensure the response head is sent even if there was no body.
+	if err := w.writeHead(); err != nil {
+		return err
+	}
+	return w.writeTrailer()
+}
+
+// rpcHTTPServer is set as the server of forwarded requests.
+var rpcHTTPServer = &http.Server{}
+
+// serveHTTP handles the forwarded request. Returns true if the response was
+// aborted after the response head was sent.
+func (s *RPCServer) serveHTTP(
+	w http.ResponseWriter,
+	r *http.Request,
+) (aborted bool) {
+	// The reverse proxy only aborts the handler with http.ErrAbortHandler
+	// when it fails to copy the response body if it is running under a HTTP
+	// server, otherwise it returns as if the response were complete.
+	r = r.WithContext(context.WithValue(
+		r.Context(), http.ServerContextKey, rpcHTTPServer,
+	))
+
+	defer func() {
+		if err := recover(); err != nil {
+			if err != http.ErrAbortHandler {
+				panic(err)
+			}
+			aborted = true
+		}
+	}()
+
+	s.handler.ServeHTTP(w, r)
+	return false
+}
+
+// verifiedPeerCertificate returns whether the client presented a TLS
+// certificate verified by the server's TLS configuration.
+func verifiedPeerCertificate(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return false
+	}
+	return len(info.State.PeerCertificates) > 0
+}
+
+// rpcServerBody reads the request body from the forward stream.
+type rpcServerBody struct {
+	stream grpc.ServerStream
+	buf    []byte
+}
+
+func (b *rpcServerBody) Read(p []byte) (int, error) {
+	for len(b.buf) == 0 {
+		var frame forwardFrame
+		if err := b.stream.RecvMsg(&frame); err != nil {
+			return 0, err
+		}
+		b.buf = frame.Body
+	}
+
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+func (b *rpcServerBody) Close() error {
+	return nil
+}
+
+// rpcResponseWriter writes the response to the forward stream.
+type rpcResponseWriter struct {
+	stream grpc.ServerStream
+
+	header     http.Header
+	statusCode int
+	headSent   bool
+	err        error
+
+	// trailer contains the trailer keys announced in the response head.
+	trailer []string
+}
+
+func (w *rpcResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *rpcResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode != 0 || w.err != nil {
+		return
+	}
+	// Informational responses are sent immediately, and are followed by
+	// the final response.
+	if statusCode < http.StatusOK && statusCode != http.StatusSwitchingProtocols {
+		if err := w.stream.SendMsg(&forwardFrame{
+			ResponseHead: &forwardResponseHead{
+				StatusCode: statusCode,
+				Header:     w.header,
+			},
+		}); err != nil {
+			w.err = err
+		}
+		return
+	}
+	w.statusCode = statusCode
+}
+
+func (w *rpcResponseWriter) Write(b []byte) (int, error) {
+	if err := w.writeHead(); err != nil {
+		return 0, err
+	}
+
+	// Note SendMsg encodes the message before returning so its safe to reuse
+	// b.
+	n := 0
+	for n < len(b) {
+		frame := b[n:min(n+forwardFrameSize, len(b))]
+		if err := w.stream.SendMsg(&forwardFrame{Body: frame}); err != nil {
+			w.err = err
+			return n, err
+		}
+		n += len(frame)
+	}
+	return n, nil
+}
+
+// Flush is a no-op as each write is sent immediately, though is needed for
+// the reverse proxy to stream responses.
+func (w *rpcResponseWriter) Flush() {
+}
+
+func (w *rpcResponseWriter) writeHead() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.headSent {
+		return nil
+	}
+	w.headSent = true
+
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	for _, v := range w.header.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				w.trailer = append(w.trailer, http.CanonicalHeaderKey(k))
+			}
+		}
+	}
+	if err := w.stream.SendMsg(&forwardFrame{
+		ResponseHead: &forwardResponseHead{
+			StatusCode: w.statusCode,
+			Header:     w.header,
+		},
+	}); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+// writeTrailer sends the trailers set after the response head was sent,
+// either announced in the 'Trailer' header or prefixed with
+// http.TrailerPrefix.
+func (w *rpcResponseWriter) writeTrailer() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	trailer := make(http.Header)
+	for _, k := range w.trailer {
+		if v, ok := w.header[k]; ok {
+			trailer[k] = v
+		}
+	}
+	for k, v := range w.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailer[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = v
+		}
+	}
+	if len(trailer) == 0 {
+		return nil
+	}
+	return w.stream.SendMsg(&forwardFrame{Trailer: trailer})
+}
+
+var _ http.ResponseWriter = &rpcResponseWriter{}
+var _ http.Flusher = &rpcResponseWriter{}
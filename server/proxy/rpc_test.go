@@ -0,0 +1,704 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/testutil"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/nodeauth"
+	"github.com/andydunstall/piko/server/upstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestRPC_Forward(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/foo/bar", r.URL.Path)
+				assert.Equal(t, "a=b", r.URL.RawQuery)
+				assert.Equal(t, "my-value", r.Header.Get("x-my-header"))
+
+				buf := new(strings.Builder)
+				// nolint
+				io.Copy(buf, r.Body)
+				assert.Equal(t, "foo", buf.String())
+
+				w.Header().Set("x-my-response-header", "my-value")
+				w.WriteHeader(http.StatusCreated)
+				// nolint
+				w.Write([]byte("bar"))
+			},
+		))
+		defer upstreamServer.Close()
+
+		rpcAddr := testRPCServer(t, &fakeManager{
+			handler: func(endpointID string, allowForward bool) (upstream.Upstream, bool) {
+				assert.Equal(t, "my-endpoint", endpointID)
+				assert.False(t, allowForward)
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		})
+
+		proxy := testRPCProxy(rpcAddr)
+		defer proxy.Close()
+
+		b := bytes.NewReader([]byte("foo"))
+		r := httptest.NewRequest(http.MethodPost, "/foo/bar?a=b", b)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+		r.Header.Add("x-my-header", "my-value")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "my-value", resp.Header.Get("x-my-response-header"))
+
+		buf := new(strings.Builder)
+		// nolint
+		io.Copy(buf, resp.Body)
+		assert.Equal(t, "bar", buf.String())
+	})
+
+	t.Run("large body", func(t *testing.T) {
+		body := bytes.Repeat([]byte("foo"), 4*1024*1024)
+
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				b, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, body, b)
+
+				// nolint
+				w.Write(b)
+			},
+		))
+		defer upstreamServer.Close()
+
+		rpcAddr := testRPCServer(t, &fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		})
+
+		proxy := testRPCProxy(rpcAddr)
+		defer proxy.Close()
+
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		b, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, b)
+	})
+
+	t.Run("aborted body", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				// nolint
+				w.Write([]byte("foo"))
+				w.(http.Flusher).Flush()
+
+				// Abort the response part way through the body.
+				panic(http.ErrAbortHandler)
+			},
+		))
+		defer upstreamServer.Close()
+
+		rpcAddr := testRPCServer(t, &fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		})
+
+		proxy := testRPCProxy(rpcAddr)
+		defer proxy.Close()
+
+		proxyServer := httptest.NewServer(proxy)
+		defer proxyServer.Close()
+
+		req, err := http.NewRequest(http.MethodGet, proxyServer.URL, nil)
+		require.NoError(t, err)
+		req.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// The client must see an error rather than a truncated body.
+		_, err = io.ReadAll(resp.Body)
+		assert.Error(t, err)
+	})
+
+	t.Run("informational", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Link", "</style.css>; rel=preload")
+				w.WriteHeader(http.StatusEarlyHints)
+				w.Header().Del("Link")
+
+				// nolint
+				w.Write([]byte("foo"))
+			},
+		))
+		defer upstreamServer.Close()
+
+		rpcAddr := testRPCServer(t, &fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		})
+
+		proxy := testRPCProxy(rpcAddr)
+		defer proxy.Close()
+
+		proxyServer := httptest.NewServer(proxy)
+		defer proxyServer.Close()
+
+		var codes []int
+		var links []string
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				codes = append(codes, code)
+				links = append(links, header.Get("Link"))
+				return nil
+			},
+		}
+		r, _ := http.NewRequestWithContext(
+			httptrace.WithClientTrace(context.Background(), trace),
+			http.MethodGet, proxyServer.URL, nil,
+		)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		resp, err := http.DefaultClient.Do(r)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, []int{http.StatusEarlyHints}, codes)
+		assert.Equal(t, []string{"</style.css>; rel=preload"}, links)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Link"))
+
+		b, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "foo", string(b))
+	})
+
+	t.Run("trailer", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				b, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, "foo", string(b))
+
+				w.Header().Set("Trailer", "x-announced-trailer")
+				// nolint
+				w.Write([]byte("bar"))
+				w.Header().Set("x-announced-trailer", "announced")
+				w.Header().Set(http.TrailerPrefix+"x-unannounced-trailer", "unannounced")
+			},
+		))
+		defer upstreamServer.Close()
+
+		rpcAddr := testRPCServer(t, &fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		})
+
+		proxy := testRPCProxy(rpcAddr)
+		defer proxy.Close()
+
+		proxyServer := httptest.NewServer(proxy)
+		defer proxyServer.Close()
+
+		// Send a chunked request.
+		r, _ := http.NewRequest(
+			http.MethodPost, proxyServer.URL, io.MultiReader(strings.NewReader("foo")),
+		)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		resp, err := http.DefaultClient.Do(r)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+		b, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "bar", string(b))
+		assert.Equal(t, "announced", resp.Trailer.Get("x-announced-trailer"))
+		assert.Equal(t, "unannounced", resp.Trailer.Get("x-unannounced-trailer"))
+	})
+
+	t.Run("expect continue", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/reject" {
+					w.WriteHeader(http.StatusExpectationFailed)
+					return
+				}
+
+				// Reading the body sends '100 Continue'.
+				// nolint
+				io.Copy(w, r.Body)
+			},
+		))
+		defer upstreamServer.Close()
+
+		rpcAddr := testRPCServer(t, &fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		})
+
+		proxy := testRPCProxy(rpcAddr)
+		defer proxy.Close()
+
+		proxyServer := httptest.NewServer(proxy)
+		defer proxyServer.Close()
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				// Use a long timeout so the body is only sent if the
+				// upstream responds with '100 Continue'.
+				ExpectContinueTimeout: time.Minute,
+			},
+		}
+
+		send := func(path string) (*http.Response, bool) {
+			var gotContinue bool
+			trace := &httptrace.ClientTrace{
+				Got100Continue: func() {
+					gotContinue = true
+				},
+			}
+			r, _ := http.NewRequestWithContext(
+				httptrace.WithClientTrace(context.Background(), trace),
+				http.MethodPost, proxyServer.URL+path, strings.NewReader("foo"),
+			)
+			r.Header.Add("x-piko-endpoint", "my-endpoint")
+			r.Header.Set("Expect", "100-continue")
+
+			resp, err := client.Do(r)
+			require.NoError(t, err)
+			return resp, gotContinue
+		}
+
+		resp, gotContinue := send("/")
+		defer resp.Body.Close()
+
+		assert.True(t, gotContinue)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		b, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "foo", string(b))
+
+		resp, gotContinue = send("/reject")
+		defer resp.Body.Close()
+
+		assert.False(t, gotContinue)
+		assert.Equal(t, http.StatusExpectationFailed, resp.StatusCode)
+	})
+
+	t.Run("no available upstreams", func(t *testing.T) {
+		rpcAddr := testRPCServer(t, &fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				return nil, false
+			},
+		})
+
+		proxy := testRPCProxy(rpcAddr)
+		defer proxy.Close()
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+		m := errorMessage{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
+		assert.Equal(t, "no available upstreams", m.Error)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		rpcAddr := testRPCServer(t, &fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				t.Error("request forwarded")
+				return nil, false
+			},
+		})
+
+		proxy := testRPCProxy(rpcAddr)
+		defer proxy.Close()
+		proxy.rpcClient.auth = nodeauth.NewAuthenticator("invalid-token")
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		blockCh := make(chan struct{})
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(_ http.ResponseWriter, _ *http.Request) {
+				<-blockCh
+			},
+		))
+		defer upstreamServer.Close()
+		defer close(blockCh)
+
+		rpcAddr := testRPCServer(t, &fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		})
+
+		node := &cluster.Node{
+			ID:      "node-1",
+			RPCAddr: rpcAddr,
+		}
+		proxy := NewHTTPProxy(
+			&fakeManager{
+				handler: func(endpointID string, _ bool) (upstream.Upstream, bool) {
+					return upstream.NewNodeUpstream(endpointID, node), true
+				},
+			},
+			time.Millisecond*100,
+			config.ForwardConfig{
+				Protocol: "grpc",
+			},
+			log.NewNopLogger(),
+		)
+		defer proxy.Close()
+		proxy.rpcClient.auth = nodeauth.NewAuthenticator(testNodeToken)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	})
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+
+	frames []*forwardFrame
+}
+
+func (s *fakeServerStream) SendMsg(m any) error {
+	s.frames = append(s.frames, m.(*forwardFrame))
+	return nil
+}
+
+func TestRPCResponseWriter(t *testing.T) {
+	stream := &fakeServerStream{}
+	w := &rpcResponseWriter{
+		stream: stream,
+		header: make(http.Header),
+	}
+
+	// Large writes are split into fixed size frames.
+	body := bytes.Repeat([]byte("a"), forwardFrameSize*2+10)
+	n, err := w.Write(body)
+	require.NoError(t, err)
+	assert.Equal(t, len(body), n)
+
+	require.Len(t, stream.frames, 4)
+	assert.Equal(t, http.StatusOK, stream.frames[0].ResponseHead.StatusCode)
+	assert.Len(t, stream.frames[1].Body, forwardFrameSize)
+	assert.Len(t, stream.frames[2].Body, forwardFrameSize)
+	assert.Len(t, stream.frames[3].Body, 10)
+}
+
+func TestRPC_Nodes(t *testing.T) {
+	clusterState := cluster.NewState(&cluster.Node{
+		ID:        "local",
+		ProxyAddr: "10.26.104.56:8000",
+		AdminAddr: "10.26.104.56:8002",
+	}, log.NewNopLogger())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := NewRPCServer(
+		http.NotFoundHandler(), clusterState, testNodeToken, nil, log.NewNopLogger(),
+	)
+	go func() {
+		_ = server.Serve(ln)
+	}()
+	defer func() {
+		_ = server.Shutdown(context.Background())
+	}()
+
+	t.Run("ok", func(t *testing.T) {
+		client := newRPCClient()
+		client.auth = nodeauth.NewAuthenticator(testNodeToken)
+		defer client.Close()
+
+		nodes, err := client.Nodes(context.Background(), ln.Addr().String())
+		require.NoError(t, err)
+		assert.Equal(t, clusterState.NodesMetadata(), nodes)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		client := newRPCClient()
+		defer client.Close()
+
+		_, err := client.Nodes(context.Background(), ln.Addr().String())
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		client := newRPCClient()
+		client.auth = nodeauth.NewAuthenticator("invalid-token")
+		defer client.Close()
+
+		_, err := client.Nodes(context.Background(), ln.Addr().String())
+		assert.Error(t, err)
+	})
+}
+
+func TestRPC_NodesTLS(t *testing.T) {
+	clusterState := cluster.NewState(&cluster.Node{
+		ID:        "local",
+		ProxyAddr: "10.26.104.56:8000",
+		AdminAddr: "10.26.104.56:8002",
+	}, log.NewNopLogger())
+
+	rootCAPool, cert, err := testutil.LocalTLSServerCert()
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := NewRPCServer(
+		http.NotFoundHandler(),
+		clusterState,
+		testNodeToken,
+		&tls.Config{Certificates: []tls.Certificate{cert}},
+		log.NewNopLogger(),
+	)
+	go func() {
+		_ = server.Serve(ln)
+	}()
+	defer func() {
+		_ = server.Shutdown(context.Background())
+	}()
+
+	t.Run("ok", func(t *testing.T) {
+		client := newRPCClient()
+		client.tlsConfig = &tls.Config{RootCAs: rootCAPool}
+		client.auth = nodeauth.NewAuthenticator(testNodeToken)
+		defer client.Close()
+
+		nodes, err := client.Nodes(context.Background(), ln.Addr().String())
+		require.NoError(t, err)
+		assert.Equal(t, clusterState.NodesMetadata(), nodes)
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		client := newRPCClient()
+		client.tlsConfig = &tls.Config{}
+		client.auth = nodeauth.NewAuthenticator(testNodeToken)
+		defer client.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := client.Nodes(ctx, ln.Addr().String())
+		assert.Error(t, err)
+	})
+}
+
+func BenchmarkRPC_Forward(b *testing.B) {
+	body := bytes.Repeat([]byte("a"), 64*1024)
+	upstreamServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// nolint
+			io.Copy(io.Discard, r.Body)
+			// nolint
+			w.Write(body)
+		},
+	))
+	defer upstreamServer.Close()
+
+	rpcAddr := testRPCServer(b, &fakeManager{
+		handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+			return &tcpUpstream{
+				addr: upstreamServer.Listener.Addr().String(),
+			}, true
+		},
+	})
+
+	proxy := testRPCProxy(rpcAddr)
+	defer proxy.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			b.Fatalf("unexpected status: %d", w.Code)
+		}
+	}
+}
+
+func BenchmarkRPCCodec(b *testing.B) {
+	frame := &forwardFrame{
+		RequestHead: &forwardRequestHead{
+			EndpointID: "my-endpoint",
+			Method:     http.MethodGet,
+			URI:        "/foo/bar?a=b",
+			Host:       "my-endpoint.example.com",
+			Header: map[string][]string{
+				"Accept":     {"application/json"},
+				"User-Agent": {"piko-bench"},
+			},
+		},
+	}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		data, err := rpcCodec{}.Marshal(frame)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var decoded forwardFrame
+		if err := (rpcCodec{}).Unmarshal(data, &decoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// testRPCTimeout is the proxy timeout used by testRPCServer and
+// testRPCProxy. This is long enough for large bodies to be forwarded when
+// running with the race detector, so tests don't depend on timing.
+const testRPCTimeout = time.Minute
+
+// testNodeToken is the token used to authenticate requests between nodes.
+const testNodeToken = "my-token"
+
+// testRPCServer starts an RPC server with the given upstreams and returns
+// its address.
+func testRPCServer(t testing.TB, upstreams upstream.Manager) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	proxyServer := NewServer(
+		upstreams,
+		config.ProxyConfig{
+			Timeout: testRPCTimeout,
+		},
+		nil,
+		nil,
+		nil,
+		log.NewNopLogger(),
+	)
+	server := NewRPCServer(
+		proxyServer.Handler(),
+		nil,
+		testNodeToken,
+		nil,
+		log.NewNopLogger(),
+	)
+	go func() {
+		_ = server.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown(context.Background())
+	})
+
+	return ln.Addr().String()
+}
+
+// testRPCProxy returns a proxy that forwards all requests to the node at the
+// given RPC address.
+func testRPCProxy(rpcAddr string) *HTTPProxy {
+	node := &cluster.Node{
+		ID:      "node-1",
+		RPCAddr: rpcAddr,
+	}
+	proxy := NewHTTPProxy(
+		&fakeManager{
+			handler: func(endpointID string, _ bool) (upstream.Upstream, bool) {
+				return upstream.NewNodeUpstream(endpointID, node), true
+			},
+		},
+		testRPCTimeout,
+		config.ForwardConfig{
+			Protocol: "grpc",
+		},
+		log.NewNopLogger(),
+	)
+	proxy.rpcClient.auth = nodeauth.NewAuthenticator(testNodeToken)
+	return proxy
+}
@@ -0,0 +1,350 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/nodeauth"
+	"github.com/andydunstall/piko/server/upstream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// rpcClient sends RPC requests to other nodes in the cluster.
+//
+// The client keeps a single gRPC connection to each node, which multiplexes
+// concurrent requests.
+type rpcClient struct {
+	conns map[string]*grpc.ClientConn
+
+	// tlsConfig is the TLS configuration to connect to other nodes, or nil
+	// to connect without TLS.
+	tlsConfig *tls.Config
+
+	// auth adds node credentials to requests, so the receiving node can
+	// authenticate them.
+	auth *nodeauth.Authenticator
+
+	// mu protects conns.
+	mu sync.Mutex
+}
+
+func newRPCClient() *rpcClient {
+	return &rpcClient{
+		conns: make(map[string]*grpc.ClientConn),
+	}
+}
+
+// RoundTrip forwards the request to the node in the requests upstream
+// context.
+//
+// This implements http.RoundTripper so can be used as the transport for a
+// reverse proxy.
+func (c *rpcClient) RoundTrip(req *http.Request) (*http.Response, error) {
+	node := req.Context().Value(upstreamContextKey).(*upstream.NodeUpstream)
+
+	conn, err := c.conn(node.RPCAddr())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	stream, err := conn.NewStream(
+		c.auth.SignRPC(ctx, rpcForwardMethod),
+		&rpcForwardStreamDesc, rpcForwardMethod,
+		grpc.ForceCodec(rpcCodec{}),
+	)
+	if err != nil {
+		cancel()
+		return nil, rpcError(err)
+	}
+
+	if err := stream.SendMsg(&forwardFrame{
+		RequestHead: &forwardRequestHead{
+			EndpointID:    node.EndpointID(),
+			Method:        req.Method,
+			URI:           req.URL.RequestURI(),
+			Host:          req.Host,
+			Header:        req.Header,
+			ContentLength: req.ContentLength,
+		},
+	}); err != nil {
+		cancel()
+		return nil, rpcError(err)
+	}
+
+	// continueCh receives whether to send the request body of requests with
+	// 'Expect: 100-continue', once the upstream responds.
+	var continueCh chan bool
+	if expectContinue(req) {
+		continueCh = make(chan bool, 1)
+		go sendRequestBodyOnContinue(ctx, stream, req, continueCh)
+	} else {
+		go sendRequestBody(stream, req)
+	}
+
+	var frame forwardFrame
+	for {
+		if err := stream.RecvMsg(&frame); err != nil {
+			cancel()
+			return nil, rpcError(err)
+		}
+		if frame.ResponseHead == nil {
+			cancel()
+			return nil, fmt.Errorf("missing response head")
+		}
+
+		statusCode := frame.ResponseHead.StatusCode
+		if statusCode >= http.StatusOK || statusCode == http.StatusSwitchingProtocols {
+			break
+		}
+
+		// Informational responses are passed to the client trace, which
+		// the reverse proxy uses to forward them to the client.
+		if statusCode == http.StatusContinue && continueCh != nil {
+			continueCh <- true
+			continueCh = nil
+		}
+		trace := httptrace.ContextClientTrace(req.Context())
+		if trace != nil && trace.Got1xxResponse != nil {
+			if err := trace.Got1xxResponse(
+				statusCode, textproto.MIMEHeader(frame.ResponseHead.Header),
+			); err != nil {
+				cancel()
+				return nil, err
+			}
+		}
+		frame = forwardFrame{}
+	}
+	// If the upstream responds without '100 Continue', the request body
+	// isn't sent.
+	if continueCh != nil {
+		continueCh <- false
+	}
+
+	header := http.Header(frame.ResponseHead.Header)
+	if header == nil {
+		header = make(http.Header)
+	}
+	resp := &http.Response{
+		Status: fmt.Sprintf(
+			"%d %s",
+			frame.ResponseHead.StatusCode,
+			http.StatusText(frame.ResponseHead.StatusCode),
+		),
+		StatusCode:    frame.ResponseHead.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Trailer:       announcedTrailer(header),
+		ContentLength: -1,
+		Request:       req,
+	}
+	resp.Body = &rpcClientBody{stream: stream, cancel: cancel, resp: resp}
+	return resp, nil
+}
+
+// Nodes queries the cluster state known by the node at the given RPC
+// address.
+func (c *rpcClient) Nodes(
+	ctx context.Context,
+	addr string,
+) ([]*cluster.NodeMetadata, error) {
+	conn, err := c.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp nodesResponse
+	if err := conn.Invoke(
+		c.auth.SignRPC(ctx, rpcNodesMethod), rpcNodesMethod, &nodesRequest{}, &resp,
+		grpc.ForceCodec(rpcCodec{}),
+	); err != nil {
+		return nil, rpcError(err)
+	}
+	return resp.Nodes, nil
+}
+
+// Close closes the connections to all nodes.
+func (c *rpcClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs error
+	for addr, conn := range c.conns {
+		errs = errors.Join(errs, conn.Close())
+		delete(c.conns, addr)
+	}
+	return errs
+}
+
+func (c *rpcClient) conn(addr string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if c.tlsConfig != nil {
+		creds = credentials.NewTLS(c.tlsConfig)
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("grpc client: %s: %w", addr, err)
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// sendRequestBody writes the request body to the forward stream, then closes
+// the send side of the stream.
+func sendRequestBody(stream grpc.ClientStream, req *http.Request) {
+	if body := req.Body; body != nil {
+		defer body.Close()
+
+		buf := buffers.Get()
+		defer buffers.Put(buf)
+
+		for {
+			n, err := body.Read(buf[:forwardFrameSize])
+			if n > 0 {
+				if err := stream.SendMsg(&forwardFrame{Body: buf[:n]}); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	_ = stream.CloseSend()
+}
+
+// expectContinueTimeout is the time to wait for the upstream to respond to
+// a request with 'Expect: 100-continue' before sending the body anyway,
+// matching the default HTTP transport.
+const expectContinueTimeout = time.Second
+
+func expectContinue(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody &&
+		strings.EqualFold(req.Header.Get("Expect"), "100-continue")
+}
+
+// sendRequestBodyOnContinue writes the request body to the forward stream
+// once the upstream responds with '100 Continue', or expectContinueTimeout
+// expires. If the upstream responds with a final response first, the body
+// isn't sent.
+func sendRequestBodyOnContinue(
+	ctx context.Context,
+	stream grpc.ClientStream,
+	req *http.Request,
+	continueCh <-chan bool,
+) {
+	timer := time.NewTimer(expectContinueTimeout)
+	defer timer.Stop()
+
+	select {
+	case ok := <-continueCh:
+		if !ok {
+			req.Body.Close()
+			_ = stream.CloseSend()
+			return
+		}
+	case <-timer.C:
+	case <-ctx.Done():
+		req.Body.Close()
+		return
+	}
+	sendRequestBody(stream, req)
+}
+
+// announcedTrailer returns the trailers announced in the 'Trailer' response
+// header, which are removed from the header, with their values set once the
+// body has been read.
+func announcedTrailer(header http.Header) http.Header {
+	values := header.Values("Trailer")
+	if len(values) == 0 {
+		return nil
+	}
+	header.Del("Trailer")
+
+	trailer := make(http.Header)
+	for _, v := range values {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				trailer[http.CanonicalHeaderKey(k)] = nil
+			}
+		}
+	}
+	return trailer
+}
+
+// rpcClientBody reads the response body from the forward stream.
+type rpcClientBody struct {
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+	buf    []byte
+
+	// resp is the response whose trailers are set once received.
+	resp *http.Response
+}
+
+func (b *rpcClientBody) Read(p []byte) (int, error) {
+	for len(b.buf) == 0 {
+		var frame forwardFrame
+		if err := b.stream.RecvMsg(&frame); err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, io.EOF
+			}
+			return 0, rpcError(err)
+		}
+		if frame.Trailer != nil {
+			if b.resp.Trailer == nil {
+				b.resp.Trailer = make(http.Header)
+			}
+			for k, v := range frame.Trailer {
+				b.resp.Trailer[k] = v
+			}
+		}
+		b.buf = frame.Body
+	}
+
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+func (b *rpcClientBody) Close() error {
+	b.cancel()
+	return nil
+}
+
+// rpcError converts gRPC status errors to the equivalent local errors.
+func rpcError(err error) error {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded:
+		return fmt.Errorf("rpc: %w", context.DeadlineExceeded)
+	case codes.Canceled:
+		return fmt.Errorf("rpc: %w", context.Canceled)
+	default:
+		return fmt.Errorf("rpc: %w", err)
+	}
+}
+
+var _ http.RoundTripper = &rpcClient{}
@@ -6,10 +6,17 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/pkg/middleware"
+	"github.com/andydunstall/piko/server/auth"
 	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/federation"
+	"github.com/andydunstall/piko/server/lockout"
+	"github.com/andydunstall/piko/server/nodeauth"
+	"github.com/andydunstall/piko/server/quota"
 	"github.com/andydunstall/piko/server/upstream"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
@@ -17,30 +24,125 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+const (
+	// tenantHeader is the header containing the tenant to scope the request
+	// to when proxy authentication is disabled. When authentication is
+	// enabled, the tenant comes from the client token instead.
+	tenantHeader = "x-piko-tenant"
+)
+
 type Server struct {
 	httpProxy *HTTPProxy
 	tcpProxy  *TCPProxy
 
 	httpServer *http.Server
 
+	limiter *limiter
+
+	ipFilter *ipFilter
+
+	webhooks *webhookVerifier
+
+	forwardAuth *forwardAuthenticator
+
+	cors *corsHandler
+
+	headers *headerInjector
+
+	// auth verifies proxy client tokens. If nil, proxy requests aren't
+	// authenticated.
+	auth *clientAuthenticator
+
+	// visibility determines the endpoints proxy clients can reach without
+	// authenticating, when authentication is enabled.
+	visibility *visibilityPolicy
+
+	// quotas enforces tenant request quotas. If nil, tenants aren't
+	// limited.
+	quotas *tenantQuotas
+
 	logger log.Logger
 }
 
 func NewServer(
 	upstreams upstream.Manager,
 	proxyConfig config.ProxyConfig,
+	federation *federation.Federation,
 	registry *prometheus.Registry,
 	tlsConfig *tls.Config,
 	logger log.Logger,
 ) *Server {
 	logger = logger.WithSubsystem("proxy")
 
-	httpProxy := NewHTTPProxy(upstreams, proxyConfig.Timeout, logger)
+	httpProxy := NewHTTPProxy(
+		upstreams, proxyConfig.Timeout, proxyConfig.Forward, logger,
+	)
+	httpProxy.federation = federation
+	httpProxy.endpointMetrics = newEndpointMetrics(
+		proxyConfig.MaxEndpointMetrics, httpProxy.metrics,
+	)
+	httpProxy.slowRequestThreshold = proxyConfig.SlowRequestThreshold
+	httpProxy.setFlushInterval(proxyConfig.FlushInterval)
+
+	tcpProxy := NewTCPProxy(upstreams, httpProxy, logger)
+	tcpProxy.federation = federation
+
+	limiter := newLimiter(
+		proxyConfig.MaxConnections,
+		proxyConfig.MaxConcurrentRequests,
+		httpProxy.metrics.RejectedRequestsTotal,
+	)
+
+	// CIDRs already validated in ProxyConfig.Validate.
+	allowCIDRs, _ := config.ParseCIDRs(proxyConfig.AllowCIDRs)
+	denyCIDRs, _ := config.ParseCIDRs(proxyConfig.DenyCIDRs)
+	var endpoints []endpointAllowList
+	var webhooks []endpointWebhook
+	var cors []endpointCORS
+	var forwardAuth []endpointForwardAuth
+	var headers []endpointHeaders
+	var visibility []endpointVisibility
+	for _, e := range proxyConfig.Endpoints {
+		allow, _ := config.ParseCIDRs(e.AllowCIDRs)
+		endpoints = append(endpoints, endpointAllowList{
+			endpoint: e.Endpoint,
+			allow:    allow,
+		})
+		webhooks = append(webhooks, endpointWebhook{
+			endpoint: e.Endpoint,
+			conf:     e.Webhook,
+		})
+		cors = append(cors, endpointCORS{
+			endpoint: e.Endpoint,
+			conf:     e.CORS,
+		})
+		forwardAuth = append(forwardAuth, endpointForwardAuth{
+			endpoint: e.Endpoint,
+			conf:     e.ForwardAuth,
+		})
+		headers = append(headers, endpointHeaders{
+			endpoint: e.Endpoint,
+			headers:  e.ResponseHeaders,
+		})
+		visibility = append(visibility, endpointVisibility{
+			endpoint:   e.Endpoint,
+			visibility: e.Visibility,
+		})
+	}
+	ipFilter := newIPFilter(
+		allowCIDRs,
+		denyCIDRs,
+		endpoints,
+		httpProxy.metrics.RejectedRequestsTotal,
+		logger,
+	)
 
 	router := gin.New()
+	_ = router.SetTrustedProxies(proxyConfig.TrustedProxies)
+
 	s := &Server{
 		httpProxy: httpProxy,
-		tcpProxy:  NewTCPProxy(upstreams, httpProxy, logger),
+		tcpProxy:  tcpProxy,
 		httpServer: &http.Server{
 			Handler:           router,
 			TLSConfig:         tlsConfig,
@@ -49,9 +151,21 @@ func NewServer(
 			WriteTimeout:      proxyConfig.HTTP.WriteTimeout,
 			IdleTimeout:       proxyConfig.HTTP.IdleTimeout,
 			MaxHeaderBytes:    proxyConfig.HTTP.MaxHeaderBytes,
+			ConnContext:       limiter.ConnContext,
 			ErrorLog:          logger.StdLogger(zapcore.WarnLevel),
 		},
-		logger: logger,
+		limiter:  limiter,
+		ipFilter: ipFilter,
+		webhooks: newWebhookVerifier(
+			webhooks, httpProxy.metrics.RejectedRequestsTotal, logger,
+		),
+		forwardAuth: newForwardAuthenticator(
+			forwardAuth, httpProxy.metrics.RejectedRequestsTotal, logger,
+		),
+		cors:       newCORSHandler(cors),
+		headers:    newHeaderInjector(headers),
+		visibility: newVisibilityPolicy(visibility),
+		logger:     logger,
 	}
 
 	// Recover from panics.
@@ -62,20 +176,92 @@ func NewServer(
 	metrics := middleware.NewMetrics("proxy")
 	if registry != nil {
 		metrics.Register(registry)
+		httpProxy.Metrics().Register(registry)
 	}
 	router.Use(metrics.Handler())
 
-	s.registerRoutes(router)
+	router.Use(middleware.NewTracing("piko.proxy"))
+
+	router.Use(s.nodeForwardHandler)
+
+	// Reject requests from clients that aren't permitted, before any other
+	// processing.
+	if s.ipFilter.Enabled() {
+		router.Use(s.ipFilter.Handler)
+	}
+
+	// Reject requests exceeding the connection or concurrent request
+	// limits.
+	router.Use(limiter.Handler)
+
+	s.registerRoutes(router, federation)
 
 	return s
 }
 
+// SetVerifier enables verifying proxy client tokens, so requests are only
+// proxied to the endpoints permitted by the client's token.
+//
+// If lockout is not nil, clients are locked out after repeated failed
+// authentication attempts.
+func (s *Server) SetVerifier(verifier auth.Verifier, lockout *lockout.Lockout) {
+	s.auth = newClientAuthenticator(
+		verifier, lockout, s.httpProxy.metrics.RejectedRequestsTotal, s.logger,
+	)
+}
+
+// SetVisibility sets the list of endpoint visibility set using the admin API,
+// which takes precedence over the configured visibility.
+//
+// Must be called before serving.
+func (s *Server) SetVisibility(visibility *upstream.VisibilityList) {
+	s.visibility.overrides = visibility
+}
+
+// SetQuotas enforces the request rate and bandwidth quotas of each tenant.
+//
+// Must be called before serving.
+func (s *Server) SetQuotas(quotas *quota.Quotas) {
+	s.quotas = newTenantQuotas(
+		quotas, s.httpProxy.metrics.RejectedRequestsTotal, s.logger,
+	)
+}
+
+// SetNodeAuthToken sets the token shared by nodes in the cluster, used to
+// authenticate requests forwarded between nodes.
+//
+// Must be called before serving.
+func (s *Server) SetNodeAuthToken(token string) {
+	auth := nodeauth.NewAuthenticator(token)
+	s.httpProxy.nodeAuth = auth
+	if s.httpProxy.rpcClient != nil {
+		s.httpProxy.rpcClient.auth = auth
+	}
+}
+
+// SetRPCTLSConfig sets the TLS configuration used to forward requests to
+// other nodes using RPC.
+//
+// Must be called before serving.
+func (s *Server) SetRPCTLSConfig(tlsConfig *tls.Config) {
+	if s.httpProxy.rpcClient != nil {
+		s.httpProxy.rpcClient.tlsConfig = tlsConfig
+	}
+}
+
+// Handler returns the handler for proxy requests.
+func (s *Server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
 func (s *Server) Serve(ln net.Listener) error {
 	s.logger.Info(
 		"starting proxy server",
 		zap.String("addr", ln.Addr().String()),
 	)
 
+	ln = s.limiter.Listener(ln)
+
 	var err error
 	if s.httpServer.TLSConfig != nil {
 		err = s.httpServer.ServeTLS(ln, "", "")
@@ -89,32 +275,210 @@ func (s *Server) Serve(ln net.Listener) error {
 	return nil
 }
 
+// ServeListener serves proxy requests on an additional listener using the
+// given TLS config, rather than the TLS config the server was created with. If
+// tlsConfig is nil the listener doesn't use TLS.
+//
+// The listener is closed when the server is shut down.
+func (s *Server) ServeListener(ln net.Listener, tlsConfig *tls.Config) error {
+	s.logger.Info(
+		"starting proxy listener",
+		zap.String("addr", ln.Addr().String()),
+		zap.Bool("tls", tlsConfig != nil),
+	)
+
+	ln = s.limiter.Listener(ln)
+
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		// Support HTTP/2 as with ServeTLS.
+		if !slices.Contains(tlsConfig.NextProtos, "h2") {
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos, "h2")
+		}
+		if !slices.Contains(tlsConfig.NextProtos, "http/1.1") {
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos, "http/1.1")
+		}
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	if err := s.httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("http serve: %w", err)
+	}
+	return nil
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
 	if err := s.httpServer.Shutdown(ctx); err != nil {
 		return err
 	}
-	return nil
+	return s.httpProxy.Close()
 }
 
-func (s *Server) registerRoutes(router *gin.Engine) {
+func (s *Server) registerRoutes(
+	router *gin.Engine,
+	fed *federation.Federation,
+) {
 	// All /_piko routes are reserved.
 	piko := router.Group("/_piko")
 	v1 := piko.Group("/v1")
 	v1.GET("/tcp/:endpointID", s.proxyTCPRoute)
 
+	if fed != nil {
+		federation.NewHandler(fed).Register(v1.Group("/federation"))
+	}
+
 	router.NoRoute(s.proxyHTTPRoute)
 }
 
 func (s *Server) proxyHTTPRoute(c *gin.Context) {
-	s.httpProxy.ServeHTTP(c.Writer, c.Request)
+	endpointID := EndpointIDFromRequest(c.Request)
+	if !validEndpointID(c, endpointID) {
+		return
+	}
+	if !s.ipFilter.PermitEndpoint(c, endpointID) {
+		return
+	}
+	w := s.headers.Wrap(
+		&informationalResponseWriter{ResponseWriter: c.Writer}, endpointID,
+	)
+	// Note CORS is handled before authentication, since browsers don't
+	// include credentials in preflight requests.
+	w, ok := s.cors.Handle(w, c.Request, endpointID)
+	if !ok {
+		return
+	}
+	tenant, ok := s.tenant(c, endpointID, false)
+	if !ok {
+		return
+	}
+	endpointID = auth.ScopeEndpoint(tenant, endpointID)
+	if !s.forwardAuth.Authorize(c, endpointID) {
+		return
+	}
+	if !s.webhooks.Verify(c, endpointID) {
+		return
+	}
+
+	if s.quotas != nil {
+		if !s.quotas.Allow(c, tenant) {
+			return
+		}
+		var record func()
+		w, record = s.quotas.Track(w, c.Request, tenant)
+		defer record()
+	}
+	s.httpProxy.ServeEndpoint(w, c.Request, endpointID)
+}
+
+// informationalResponseWriter writes informational (1xx) responses, such as
+// '103 Early Hints', to the client immediately. Gin only records the status
+// code until the response body is written, so would otherwise drop them.
+type informationalResponseWriter struct {
+	gin.ResponseWriter
+}
+
+func (w *informationalResponseWriter) WriteHeader(statusCode int) {
+	if statusCode < http.StatusOK && statusCode != http.StatusSwitchingProtocols &&
+		!w.Written() {
+		if u, ok := w.ResponseWriter.(interface{ Unwrap() http.ResponseWriter }); ok {
+			u.Unwrap().WriteHeader(statusCode)
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap returns the underlying response writer, so the reverse proxy can
+// hijack the connection for upgraded requests.
+func (w *informationalResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 func (s *Server) proxyTCPRoute(c *gin.Context) {
 	endpointID := c.Param("endpointID")
-	s.tcpProxy.ServeHTTP(c.Writer, c.Request, endpointID)
+	if !s.ipFilter.PermitEndpoint(c, endpointID) {
+		return
+	}
+	tenant, ok := s.tenant(c, endpointID, true)
+	if !ok {
+		return
+	}
+	// Note only the request rate quota applies to TCP connections.
+	if s.quotas != nil && !s.quotas.Allow(c, tenant) {
+		return
+	}
+	s.tcpProxy.ServeHTTP(
+		c.Writer, c.Request, auth.ScopeEndpoint(tenant, endpointID),
+	)
+}
+
+// tenant authenticates the request, if authentication is enabled, and
+// returns the tenant the request is scoped to.
+//
+// When authentication is enabled the tenant comes from the client token,
+// otherwise the tenant may be given in the 'x-piko-tenant' header. If the
+// request is rejected, writes an error response and returns false.
+//
+// Requests to public endpoints without a client token aren't authenticated,
+// so the tenant may be given in the 'x-piko-tenant' header.
+func (s *Server) tenant(c *gin.Context, endpointID string, tcp bool) (string, bool) {
+	if s.auth != nil {
+		if clientToken(c.Request, tcp) == "" {
+			tenant := c.Request.Header.Get(tenantHeader)
+			if auth.ValidTenant(tenant) &&
+				s.visibility.Public(auth.ScopeEndpoint(tenant, endpointID)) {
+				return tenant, true
+			}
+		}
+
+		token, ok := s.auth.Authenticate(
+			c.Writer, c.Request, c.ClientIP(), endpointID, tcp,
+		)
+		return token.Tenant, ok
+	}
+
+	tenant := c.Request.Header.Get(tenantHeader)
+	if !auth.ValidTenant(tenant) {
+		_ = errorResponse(c.Writer, http.StatusBadRequest, "invalid tenant")
+		return "", false
+	}
+	return tenant, true
+}
+
+// validEndpointID returns whether the endpoint ID is valid. Endpoint IDs must
+// not contain '/', so requests can't address endpoints in another tenant. If
+// invalid, writes an error response and returns false.
+func validEndpointID(c *gin.Context, endpointID string) bool {
+	if strings.Contains(endpointID, "/") {
+		_ = errorResponse(c.Writer, http.StatusBadRequest, "invalid endpoint id")
+		return false
+	}
+	return true
+}
+
+// nodeForwardHandler marks requests forwarded from another node with a valid
+// node credential. The credential is always removed, so clients can't pass
+// their own credentials to upstreams.
+func (s *Server) nodeForwardHandler(c *gin.Context) {
+	if s.httpProxy.nodeAuth.VerifyRequest(c.Request) {
+		c.Request = c.Request.WithContext(withNodeForward(c.Request.Context()))
+		return
+	}
+	// If nodes authenticate forwarded requests, don't trust the forward
+	// header from unauthenticated clients.
+	if s.httpProxy.nodeAuth.Enabled() && !isNodeForward(c.Request) {
+		c.Request.Header.Del("x-piko-forward")
+	}
 }
 
 func (s *Server) panicRoute(c *gin.Context, err any) {
+	// The reverse proxy aborts the handler if it fails to copy the response
+	// body, so the response isn't mistaken for a complete response. Pass
+	// the panic on to the HTTP server to abort the response.
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+
 	s.logger.Error(
 		"handler panic",
 		zap.String("path", c.FullPath()),
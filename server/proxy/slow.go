@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// requestTiming records the timing of a proxied request, used to log a
+// breakdown of slow requests.
+type requestTiming struct {
+	start time.Time
+
+	// getConn is when the proxy started to get a connection to the
+	// upstream.
+	getConn time.Time
+	// gotConn is when the proxy got a connection to the upstream.
+	gotConn time.Time
+	// wroteRequest is when the proxy finished writing the request to the
+	// upstream.
+	wroteRequest time.Time
+	// firstByte is when the proxy received the first byte of the upstream
+	// response.
+	firstByte time.Time
+
+	// mu protects the above fields since the trace hooks may be called from
+	// other goroutines.
+	mu sync.Mutex
+}
+
+func newRequestTiming() *requestTiming {
+	return &requestTiming{
+		start: time.Now(),
+	}
+}
+
+// WithContext returns a context that records the timing of the request.
+//
+// Note timing is only recorded for requests forwarded using HTTP, so isn't
+// available for requests forwarded using RPC.
+func (t *requestTiming) WithContext(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(_ string) {
+			t.record(&t.getConn)
+		},
+		GotConn: func(_ httptrace.GotConnInfo) {
+			t.record(&t.gotConn)
+		},
+		WroteRequest: func(_ httptrace.WroteRequestInfo) {
+			t.record(&t.wroteRequest)
+		},
+		GotFirstResponseByte: func() {
+			t.record(&t.firstByte)
+		},
+	})
+}
+
+// Duration returns the duration since the request started.
+func (t *requestTiming) Duration() time.Duration {
+	return time.Since(t.start)
+}
+
+// Fields returns the timing breakdown as log fields:
+// * queue: Time waiting for a connection to the upstream, including dialing
+// a new connection
+// * forward: Time writing the request to the upstream
+// * upstream: Time waiting for the upstream to respond
+func (t *requestTiming) Fields() []zap.Field {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fields := []zap.Field{
+		zap.Duration("duration", time.Since(t.start)),
+	}
+	if !t.getConn.IsZero() && !t.gotConn.IsZero() {
+		fields = append(fields, zap.Duration("queue", t.gotConn.Sub(t.getConn)))
+	}
+	if !t.gotConn.IsZero() && !t.wroteRequest.IsZero() {
+		fields = append(fields, zap.Duration("forward", t.wroteRequest.Sub(t.gotConn)))
+	}
+	if !t.wroteRequest.IsZero() && !t.firstByte.IsZero() {
+		fields = append(fields, zap.Duration("upstream", t.firstByte.Sub(t.wroteRequest)))
+	}
+	return fields
+}
+
+func (t *requestTiming) record(ts *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Only record the first event, such as if the request is retried.
+	if ts.IsZero() {
+		*ts = time.Now()
+	}
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTiming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		},
+	))
+	defer server.Close()
+
+	timing := newRequestTiming()
+
+	req, err := http.NewRequestWithContext(
+		timing.WithContext(context.Background()),
+		http.MethodGet,
+		server.URL,
+		nil,
+	)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	var keys []string
+	for _, field := range timing.Fields() {
+		keys = append(keys, field.Key)
+	}
+	assert.Equal(t, []string{"duration", "queue", "forward", "upstream"}, keys)
+}
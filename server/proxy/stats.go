@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRequestErrors is the maximum number of recent request errors to
+	// record.
+	maxRequestErrors = 100
+)
+
+// EndpointStats contains request statistics for an endpoint.
+type EndpointStats struct {
+	EndpointID string `json:"endpoint_id"`
+
+	// Requests is the number of requests for the endpoint.
+	Requests uint64 `json:"requests"`
+
+	// Errors is the number of requests for the endpoint that failed.
+	Errors uint64 `json:"errors"`
+}
+
+// RequestError describes a proxy request that failed.
+type RequestError struct {
+	EndpointID string `json:"endpoint_id"`
+
+	// Status is the HTTP status code returned to the client.
+	Status int `json:"status"`
+
+	Message string `json:"message"`
+
+	Time time.Time `json:"time"`
+}
+
+// requestStats records statistics about the requests handled by the proxy.
+//
+// Statistics are only recorded for endpoints with an upstream, to avoid
+// recording arbitrary endpoint IDs from clients.
+type requestStats struct {
+	endpoints map[string]*EndpointStats
+
+	// errors contains the most recent request errors.
+	errors []RequestError
+
+	mu sync.Mutex
+}
+
+func newRequestStats() *requestStats {
+	return &requestStats{
+		endpoints: make(map[string]*EndpointStats),
+	}
+}
+
+// RecordRequest records a request for the given endpoint.
+func (s *requestStats) RecordRequest(endpointID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.endpoints[endpointID]
+	if !ok {
+		stats = &EndpointStats{
+			EndpointID: endpointID,
+		}
+		s.endpoints[endpointID] = stats
+	}
+	stats.Requests++
+}
+
+// RecordError records a failed request for the given endpoint.
+func (s *requestStats) RecordError(endpointID string, status int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stats, ok := s.endpoints[endpointID]; ok {
+		stats.Errors++
+	}
+
+	s.errors = append(s.errors, RequestError{
+		EndpointID: endpointID,
+		Status:     status,
+		Message:    message,
+		Time:       time.Now(),
+	})
+	if len(s.errors) > maxRequestErrors {
+		s.errors = s.errors[len(s.errors)-maxRequestErrors:]
+	}
+}
+
+// Endpoints returns the request statistics for each endpoint, sorted by
+// endpoint ID.
+func (s *requestStats) Endpoints() []EndpointStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints := make([]EndpointStats, 0, len(s.endpoints))
+	for _, stats := range s.endpoints {
+		endpoints = append(endpoints, *stats)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].EndpointID < endpoints[j].EndpointID
+	})
+	return endpoints
+}
+
+// Errors returns the most recent request errors, ordered from oldest to
+// newest.
+func (s *requestStats) Errors() []RequestError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errors := make([]RequestError, len(s.errors))
+	copy(errors, s.errors)
+	return errors
+}
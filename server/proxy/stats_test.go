@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestStats(t *testing.T) {
+	t.Run("endpoints", func(t *testing.T) {
+		stats := newRequestStats()
+
+		stats.RecordRequest("endpoint-2")
+		stats.RecordRequest("endpoint-1")
+		stats.RecordRequest("endpoint-1")
+		stats.RecordError("endpoint-1", http.StatusBadGateway, "unreachable")
+		// Errors for unknown endpoints aren't counted.
+		stats.RecordError("endpoint-3", http.StatusBadGateway, "unreachable")
+
+		assert.Equal(t, []EndpointStats{
+			{EndpointID: "endpoint-1", Requests: 2, Errors: 1},
+			{EndpointID: "endpoint-2", Requests: 1},
+		}, stats.Endpoints())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		stats := newRequestStats()
+
+		for i := 0; i != maxRequestErrors+10; i++ {
+			stats.RecordError("my-endpoint", http.StatusBadGateway, "unreachable")
+		}
+		stats.RecordError("my-endpoint", http.StatusGatewayTimeout, "timeout")
+
+		errors := stats.Errors()
+		assert.Equal(t, maxRequestErrors, len(errors))
+		assert.Equal(t, http.StatusGatewayTimeout, errors[len(errors)-1].Status)
+		assert.Equal(t, "timeout", errors[len(errors)-1].Message)
+	})
+}
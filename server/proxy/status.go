@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/andydunstall/piko/server/status"
+	"github.com/gin-gonic/gin"
+)
+
+type Status struct {
+	server *Server
+}
+
+func NewStatus(server *Server) *Status {
+	return &Status{
+		server: server,
+	}
+}
+
+func (s *Status) Register(group *gin.RouterGroup) {
+	group.GET("/endpoints", s.listEndpointsRoute)
+	group.GET("/errors", s.listErrorsRoute)
+}
+
+func (s *Status) listEndpointsRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, s.server.httpProxy.stats.Endpoints())
+}
+
+func (s *Status) listErrorsRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, s.server.httpProxy.stats.Errors())
+}
+
+var _ status.Handler = &Status{}
@@ -1,13 +1,20 @@
 package proxy
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/andydunstall/piko/pkg/log"
 	pikowebsocket "github.com/andydunstall/piko/pkg/websocket"
+	"github.com/andydunstall/piko/server/federation"
 	"github.com/andydunstall/piko/server/upstream"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
@@ -17,11 +24,19 @@ import (
 //
 // Incoming TCP traffic is sent over WebSockets by a Piko client, then
 // forwarded to an upstream via a multiplexed stream.
+//
+// If the upstream is connected to another node, the WebSocket connection is
+// forwarded to that node without being decoded, so the bytes are copied
+// directly between the client and node TCP connections.
 type TCPProxy struct {
 	upstreams upstream.Manager
 
 	httpProxy *HTTPProxy
 
+	// federation forwards connections for endpoints that only exist in a
+	// remote cluster. Nil if federation is disabled.
+	federation *federation.Federation
+
 	websocketUpgrader *websocket.Upgrader
 
 	logger log.Logger
@@ -41,13 +56,7 @@ func NewTCPProxy(
 }
 
 func (p *TCPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, endpointID string) {
-	forwarded := r.Header.Get("x-piko-forward") == "true"
-
-	// If there is a connected upstream, attempt to forward the request to one
-	// of those upstreams. Note this includes remote nodes that are reporting
-	// they have an available upstream. We don't allow multiple hops, so if
-	// forwarded is true we only select from local nodes.
-	u, ok := p.upstreams.Select(endpointID, !forwarded)
+	u, ok := selectUpstream(p.upstreams, p.federation, r, endpointID)
 	if !ok {
 		p.logger.Warn(
 			"no available upstreams",
@@ -58,10 +67,16 @@ func (p *TCPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, endpointID
 		return
 	}
 
-	// If the upstream is a remote node rather than a client listener, forward
-	// the connection via the HTTP reverse proxy. As it is a WebSocket
-	// connection the remote node can handle the connection and forward to an
-	// upstream listener.
+	// If the upstream is another node in the cluster, forward the WebSocket
+	// connection directly to the node, which handles the connection and
+	// forwards to an upstream listener.
+	if node, ok := u.(*upstream.NodeUpstream); ok {
+		p.forwardToNode(w, r, endpointID, node)
+		return
+	}
+
+	// Otherwise if the upstream is a remote cluster, forward the connection
+	// via the HTTP reverse proxy.
 	if u.Forward() {
 		p.httpProxy.ServeHTTPWithUpstream(w, r, endpointID, u)
 		return
@@ -86,20 +101,179 @@ func (p *TCPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, endpointID
 	forward(upstreamConn, downstreamConn)
 }
 
+// forwardToNode forwards the WebSocket connection to the given node.
+//
+// Rather than forwarding via the HTTP reverse proxy, which copies the
+// upgraded connection using user space buffers, this sends the upgrade
+// request to the node itself, then once the node accepts the upgrade,
+// forwards the raw connections using 'forward'. When both the client and
+// node connections are TCP, this avoids copying via user space.
+func (p *TCPProxy) forwardToNode(
+	w http.ResponseWriter,
+	r *http.Request,
+	endpointID string,
+	node *upstream.NodeUpstream,
+) {
+	p.httpProxy.stats.RecordRequest(endpointID)
+
+	ctx := r.Context()
+	if p.httpProxy.timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.httpProxy.timeout)
+		defer cancel()
+	}
+
+	nodeConn, err := (&net.Dialer{}).DialContext(ctx, "tcp", node.Addr())
+	if err != nil {
+		p.forwardError(w, endpointID, fmt.Errorf("dial: %w", err))
+		return
+	}
+	defer nodeConn.Close()
+
+	p.httpProxy.metrics.ForwardConnectionsOpenedTotal.Inc()
+	p.httpProxy.metrics.ForwardConnections.Inc()
+	defer p.httpProxy.metrics.ForwardConnections.Dec()
+
+	// Bound the time to wait for the node to accept the upgrade.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = nodeConn.SetDeadline(deadline)
+	}
+
+	outreq := r.Clone(ctx)
+	outreq.URL.Scheme = "http"
+	outreq.URL.Host = node.Addr()
+	// Don't add a default user agent.
+	if _, ok := outreq.Header["User-Agent"]; !ok {
+		outreq.Header.Set("User-Agent", "")
+	}
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := outreq.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		outreq.Header.Set("X-Forwarded-For", clientIP)
+	}
+	outreq.Header.Set("x-piko-forward", "true")
+	// Authenticate the request so the node doesn't repeat checks already
+	// applied by this node, such as quotas.
+	p.httpProxy.nodeAuth.SignRequest(outreq)
+
+	if err := outreq.Write(nodeConn); err != nil {
+		p.forwardError(w, endpointID, fmt.Errorf("write request: %w", err))
+		return
+	}
+
+	nodeReader := bufio.NewReader(nodeConn)
+	resp, err := http.ReadResponse(nodeReader, outreq)
+	if err != nil {
+		p.forwardError(w, endpointID, fmt.Errorf("read response: %w", err))
+		return
+	}
+
+	// If the node rejected the upgrade, such as if the upstream disconnected,
+	// pass the response to the client.
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		// nolint
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	_ = nodeConn.SetDeadline(time.Time{})
+
+	downstreamConn, downstreamRW, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		p.forwardError(w, endpointID, fmt.Errorf("hijack: %w", err))
+		return
+	}
+	defer downstreamConn.Close()
+
+	_ = downstreamConn.SetDeadline(time.Time{})
+
+	resp.Body = nil
+	if err := resp.Write(downstreamRW); err != nil {
+		p.logger.Warn("failed to write upgrade response", zap.Error(err))
+		return
+	}
+	if err := downstreamRW.Flush(); err != nil {
+		p.logger.Warn("failed to write upgrade response", zap.Error(err))
+		return
+	}
+
+	// Pass on any bytes already read into the buffers before forwarding the
+	// connections directly.
+	if n := nodeReader.Buffered(); n > 0 {
+		b, _ := nodeReader.Peek(n)
+		if _, err := downstreamConn.Write(b); err != nil {
+			return
+		}
+	}
+	if n := downstreamRW.Reader.Buffered(); n > 0 {
+		b, _ := downstreamRW.Reader.Peek(n)
+		if _, err := nodeConn.Write(b); err != nil {
+			return
+		}
+	}
+
+	forward(nodeConn, downstreamConn)
+}
+
+func (p *TCPProxy) forwardError(
+	w http.ResponseWriter,
+	endpointID string,
+	err error,
+) {
+	p.logger.Warn(
+		"forward to node",
+		zap.String("endpoint-id", endpointID),
+		zap.Error(err),
+	)
+
+	if errors.Is(err, context.DeadlineExceeded) || isTimeout(err) {
+		p.httpProxy.stats.RecordError(endpointID, http.StatusGatewayTimeout, err.Error())
+
+		_ = errorResponse(w, http.StatusGatewayTimeout, "upstream timeout")
+		return
+	}
+
+	p.httpProxy.stats.RecordError(endpointID, http.StatusBadGateway, err.Error())
+
+	_ = errorResponse(w, http.StatusBadGateway, "upstream unreachable")
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// forward copies data between the two connections until either connection
+// is closed.
+//
+// When both connections are TCP, io.CopyBuffer uses the connections
+// ReadFrom, which on Linux uses splice(2) so the data isn't copied via user
+// space. Otherwise falls back to copying using a pooled buffer.
 func forward(conn1 net.Conn, conn2 net.Conn) {
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
 		defer conn1.Close()
+		buf := buffers.Get()
+		defer buffers.Put(buf)
 		// nolint
-		io.Copy(conn1, conn2)
+		io.CopyBuffer(conn1, conn2, buf)
 	}()
 	go func() {
 		defer wg.Done()
 		defer conn2.Close()
+		buf := buffers.Get()
+		defer buffers.Put(buf)
 		// nolint
-		io.Copy(conn2, conn1)
+		io.CopyBuffer(conn2, conn1, buf)
 	}()
 	wg.Wait()
 }
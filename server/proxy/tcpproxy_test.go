@@ -9,11 +9,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/pkg/websocket"
+	"github.com/andydunstall/piko/server/cluster"
 	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/nodeauth"
 	"github.com/andydunstall/piko/server/upstream"
+	gorillawebsocket "github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -59,6 +63,7 @@ func TestTCPProxy_Forward(t *testing.T) {
 			config.ProxyConfig{},
 			nil,
 			nil,
+			nil,
 			log.NewNopLogger(),
 		)
 
@@ -89,6 +94,161 @@ func TestTCPProxy_Forward(t *testing.T) {
 		}
 	})
 
+	t.Run("forward to node", func(t *testing.T) {
+		auth := nodeauth.NewAuthenticator("my-token")
+
+		nodeServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "true", r.Header.Get("x-piko-forward"))
+				assert.True(t, auth.VerifyRequest(r))
+
+				upgrader := &gorillawebsocket.Upgrader{}
+				wsConn, err := upgrader.Upgrade(w, r, nil)
+				if !assert.NoError(t, err) {
+					return
+				}
+				conn := websocket.New(wsConn)
+				defer conn.Close()
+
+				// nolint
+				io.Copy(conn, conn)
+			},
+		))
+		defer nodeServer.Close()
+
+		node := &cluster.Node{
+			ID:        "node-1",
+			ProxyAddr: nodeServer.Listener.Addr().String(),
+		}
+		httpProxy := NewHTTPProxy(
+			&fakeManager{},
+			time.Second,
+			config.ForwardConfig{},
+			log.NewNopLogger(),
+		)
+		defer httpProxy.Close()
+		httpProxy.nodeAuth = auth
+
+		proxy := NewTCPProxy(
+			&fakeManager{
+				handler: func(endpointID string, allowForward bool) (upstream.Upstream, bool) {
+					assert.Equal(t, "my-endpoint", endpointID)
+					assert.True(t, allowForward)
+					return upstream.NewNodeUpstream(endpointID, node), true
+				},
+			},
+			httpProxy,
+			log.NewNopLogger(),
+		)
+
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				proxy.ServeHTTP(w, r, "my-endpoint")
+			},
+		))
+		defer server.Close()
+
+		conn, err := websocket.Dial(
+			context.TODO(),
+			"ws://"+server.Listener.Addr().String()+"/_piko/v1/tcp/my-endpoint",
+		)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		buf := make([]byte, 512)
+		for i := 0; i != 10; i++ {
+			_, err = conn.Write([]byte("foo"))
+			assert.NoError(t, err)
+
+			n, err := conn.Read(buf)
+			assert.NoError(t, err)
+			assert.Equal(t, "foo", string(buf[:n]))
+		}
+	})
+
+	t.Run("forward to node rejected", func(t *testing.T) {
+		nodeServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				_ = errorResponse(w, http.StatusBadGateway, "no available upstreams")
+			},
+		))
+		defer nodeServer.Close()
+
+		node := &cluster.Node{
+			ID:        "node-1",
+			ProxyAddr: nodeServer.Listener.Addr().String(),
+		}
+		httpProxy := NewHTTPProxy(
+			&fakeManager{},
+			time.Second,
+			config.ForwardConfig{},
+			log.NewNopLogger(),
+		)
+		defer httpProxy.Close()
+
+		proxy := NewTCPProxy(
+			&fakeManager{
+				handler: func(endpointID string, _ bool) (upstream.Upstream, bool) {
+					return upstream.NewNodeUpstream(endpointID, node), true
+				},
+			},
+			httpProxy,
+			log.NewNopLogger(),
+		)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r, "my-endpoint")
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+		m := errorMessage{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
+		assert.Equal(t, "no available upstreams", m.Error)
+	})
+
+	t.Run("forward to node unreachable", func(t *testing.T) {
+		node := &cluster.Node{
+			ID:        "node-1",
+			ProxyAddr: "localhost:55555",
+		}
+		httpProxy := NewHTTPProxy(
+			&fakeManager{},
+			time.Second,
+			config.ForwardConfig{},
+			log.NewNopLogger(),
+		)
+		defer httpProxy.Close()
+
+		proxy := NewTCPProxy(
+			&fakeManager{
+				handler: func(endpointID string, _ bool) (upstream.Upstream, bool) {
+					return upstream.NewNodeUpstream(endpointID, node), true
+				},
+			},
+			httpProxy,
+			log.NewNopLogger(),
+		)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r, "my-endpoint")
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+		m := errorMessage{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
+		assert.Equal(t, "upstream unreachable", m.Error)
+	})
+
 	t.Run("upstream unreachable", func(t *testing.T) {
 		proxy := NewTCPProxy(
 			&fakeManager{
@@ -147,3 +307,81 @@ func TestTCPProxy_Forward(t *testing.T) {
 		assert.Equal(t, "no available upstreams", m.Error)
 	})
 }
+
+// tcpConnPair returns both ends of a TCP connection.
+func tcpConnPair(b *testing.B) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- conn
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	serverConn := <-accepted
+	if serverConn == nil {
+		b.Fatal("accept failed")
+	}
+	return conn, serverConn
+}
+
+// bufferedConn hides the ReadFrom and WriteTo methods of the wrapped
+// connection, so copies use a user space buffer.
+type bufferedConn struct {
+	net.Conn
+}
+
+func BenchmarkForward(b *testing.B) {
+	benchmarkForward := func(b *testing.B, wrap func(net.Conn) net.Conn) {
+		downstream, proxyDownstream := tcpConnPair(b)
+		defer downstream.Close()
+		proxyUpstream, upstream := tcpConnPair(b)
+		defer upstream.Close()
+
+		go forward(wrap(proxyDownstream), wrap(proxyUpstream))
+
+		// nolint
+		go io.Copy(io.Discard, downstream)
+
+		chunk := make([]byte, 256*1024)
+		buf := make([]byte, len(chunk))
+
+		b.SetBytes(int64(len(chunk)))
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			go func() {
+				// nolint
+				downstream.Write(chunk)
+			}()
+			if _, err := io.ReadFull(upstream, buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("tcp", func(b *testing.B) {
+		benchmarkForward(b, func(conn net.Conn) net.Conn {
+			return conn
+		})
+	})
+
+	b.Run("buffered", func(b *testing.B) {
+		benchmarkForward(b, func(conn net.Conn) net.Conn {
+			return &bufferedConn{Conn: conn}
+		})
+	})
+}
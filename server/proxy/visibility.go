@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/andydunstall/piko/server/upstream"
+)
+
+// endpointVisibility is the configured visibility of the endpoints matching
+// the endpoint pattern.
+type endpointVisibility struct {
+	endpoint   string
+	visibility string
+}
+
+// visibilityPolicy determines whether proxy clients must authenticate to
+// reach an endpoint, when proxy client authentication is enabled.
+type visibilityPolicy struct {
+	configured []endpointVisibility
+
+	// overrides contains the visibility of endpoints set using the admin
+	// API, which takes precedence over the configured visibility. May be
+	// nil.
+	overrides *upstream.VisibilityList
+}
+
+func newVisibilityPolicy(configured []endpointVisibility) *visibilityPolicy {
+	return &visibilityPolicy{
+		configured: configured,
+	}
+}
+
+// Public returns whether the endpoint is public, so proxy clients don't need
+// to authenticate. Endpoints are private unless configured otherwise.
+func (p *visibilityPolicy) Public(endpointID string) bool {
+	if p.overrides != nil {
+		if visibility, ok := p.overrides.Lookup(endpointID); ok {
+			return visibility == upstream.VisibilityPublic
+		}
+	}
+	for _, e := range p.configured {
+		if auth.MatchEndpoint(e.endpoint, endpointID) {
+			return e.visibility == upstream.VisibilityPublic
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/andydunstall/piko/server/upstream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVisibilityPolicy(t *testing.T) {
+	p := newVisibilityPolicy([]endpointVisibility{
+		{endpoint: "public-*", visibility: upstream.VisibilityPublic},
+		{endpoint: "public-admin", visibility: upstream.VisibilityPrivate},
+		{endpoint: "*", visibility: ""},
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		assert.True(t, p.Public("public-docs"))
+		// The first matching entry is used.
+		assert.True(t, p.Public("public-admin"))
+		assert.False(t, p.Public("my-endpoint"))
+	})
+
+	t.Run("override", func(t *testing.T) {
+		overrides := upstream.NewVisibilityList()
+		p.overrides = overrides
+
+		overrides.Set("public-docs", upstream.VisibilityPrivate)
+		overrides.Set("my-endpoint", upstream.VisibilityPublic)
+		assert.False(t, p.Public("public-docs"))
+		assert.True(t, p.Public("my-endpoint"))
+
+		overrides.Unset("public-docs")
+		assert.True(t, p.Public("public-docs"))
+	})
+}
@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/compress"
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultWebhookMaxBodySize is the maximum webhook body size to verify
+	// if not configured.
+	defaultWebhookMaxBodySize = 1 << 20
+
+	// defaultWebhookTolerance is the maximum age of a Stripe webhook
+	// timestamp if not configured.
+	defaultWebhookTolerance = time.Minute * 5
+)
+
+var (
+	errBodyTooLarge = errors.New("body too large")
+)
+
+// endpointWebhook is the webhook configuration of the endpoints matching the
+// endpoint pattern.
+type endpointWebhook struct {
+	endpoint string
+	conf     config.WebhookConfig
+}
+
+// webhookVerifier verifies the signature of webhook requests to endpoints
+// configured with a webhook secret, so forged requests are rejected before
+// being proxied to the upstream.
+type webhookVerifier struct {
+	endpoints []endpointWebhook
+
+	rejected *prometheus.CounterVec
+
+	logger log.Logger
+}
+
+func newWebhookVerifier(
+	endpoints []endpointWebhook,
+	rejected *prometheus.CounterVec,
+	logger log.Logger,
+) *webhookVerifier {
+	return &webhookVerifier{
+		endpoints: endpoints,
+		rejected:  rejected,
+		logger:    logger,
+	}
+}
+
+// Verify verifies the request signature if the endpoint is configured to
+// verify webhooks. If the signature is invalid, writes an error response and
+// returns false.
+//
+// The request body is read to verify the signature, then replaced so it can
+// be proxied. If the request was forwarded from another node with a
+// compressed body, the body is decompressed to verify the signature of the
+// original body.
+func (v *webhookVerifier) Verify(c *gin.Context, endpointID string) bool {
+	conf, ok := v.lookup(endpointID)
+	if !ok {
+		return true
+	}
+
+	maxBodySize := conf.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = defaultWebhookMaxBodySize
+	}
+	body, err := readBody(c.Request, maxBodySize)
+	if err != nil {
+		v.rejected.With(prometheus.Labels{"reason": "invalid_signature"}).Inc()
+		if errors.Is(err, errBodyTooLarge) {
+			_ = errorResponse(c.Writer, http.StatusRequestEntityTooLarge, "body too large")
+			return false
+		}
+		_ = errorResponse(c.Writer, http.StatusBadRequest, "invalid body")
+		return false
+	}
+
+	if err := verifyWebhookSignature(conf, c.Request.Header, body, time.Now()); err != nil {
+		v.logger.Warn(
+			"invalid webhook signature",
+			zap.String("endpoint-id", endpointID),
+			zap.String("client-ip", c.ClientIP()),
+			zap.Error(err),
+		)
+		v.rejected.With(prometheus.Labels{"reason": "invalid_signature"}).Inc()
+		_ = errorResponse(c.Writer, http.StatusUnauthorized, "invalid signature")
+		return false
+	}
+	return true
+}
+
+// lookup returns the webhook configuration of the first entry matching the
+// endpoint, or false if the endpoint doesn't verify webhooks.
+func (v *webhookVerifier) lookup(endpointID string) (config.WebhookConfig, bool) {
+	for _, e := range v.endpoints {
+		if auth.MatchEndpoint(e.endpoint, endpointID) {
+			return e.conf, e.conf.Enabled()
+		}
+	}
+	return config.WebhookConfig{}, false
+}
+
+// verifyWebhookSignature verifies the webhook signature in the request headers
+// matches the body.
+func verifyWebhookSignature(
+	conf config.WebhookConfig,
+	header http.Header,
+	body []byte,
+	now time.Time,
+) error {
+	switch conf.Format {
+	case "github":
+		// GitHub signs the body with HMAC-SHA256 using header
+		// 'X-Hub-Signature-256: sha256=<hex>'.
+		signature, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok {
+			return fmt.Errorf("missing signature")
+		}
+		return verifyHMAC(conf.Secret, body, signature)
+	case "stripe":
+		return verifyStripeSignature(conf, header.Get("Stripe-Signature"), body, now)
+	case "hmac":
+		signature := header.Get(conf.Header)
+		if signature == "" {
+			return fmt.Errorf("missing signature")
+		}
+		signature = strings.TrimPrefix(signature, "sha256=")
+		return verifyHMAC(conf.Secret, body, signature)
+	default:
+		return fmt.Errorf("unsupported format: %s", conf.Format)
+	}
+}
+
+// verifyStripeSignature verifies a Stripe signature header, such as
+// 'Stripe-Signature: t=1492774577,v1=<hex>', which signs '<t>.<body>' with
+// HMAC-SHA256. The timestamp must be within the configured tolerance to
+// prevent replaying old webhooks.
+func verifyStripeSignature(
+	conf config.WebhookConfig,
+	header string,
+	body []byte,
+	now time.Time,
+) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return fmt.Errorf("missing signature")
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %s", timestamp)
+	}
+	tolerance := conf.Tolerance
+	if tolerance == 0 {
+		tolerance = defaultWebhookTolerance
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("timestamp outside tolerance")
+	}
+
+	signed := append([]byte(timestamp+"."), body...)
+	// Stripe may include multiple signatures when rotating secrets.
+	for _, signature := range signatures {
+		if verifyHMAC(conf.Secret, signed, signature) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature mismatch")
+}
+
+func verifyHMAC(secret string, body []byte, signature string) error {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// readBody reads the request body, up to maxSize bytes, and replaces the
+// body so it can be read again.
+//
+// If the request was forwarded from another node or cluster with a compressed
+// body, the body is replaced with the decompressed body.
+func readBody(r *http.Request, maxSize int64) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	var reader io.Reader = r.Body
+	encoding := forwardedEncoding(r)
+	if encoding != "" {
+		decompressed, err := compress.NewReader(encoding, r.Body)
+		if err != nil {
+			r.Body.Close()
+			return nil, err
+		}
+		defer decompressed.Close()
+		reader = decompressed
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, errBodyTooLarge
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if encoding != "" {
+		r.ContentLength = int64(len(body))
+		r.Header.Del("Content-Length")
+		r.Header.Del(compress.EncodingHeader)
+	}
+	return body, nil
+}
+
+// forwardedEncoding returns the encoding of a compressed body of a request
+// forwarded from another node or cluster, or an empty string if the body
+// isn't compressed.
+func forwardedEncoding(r *http.Request) string {
+	if r.Header.Get("x-piko-forward") != "true" &&
+		r.Header.Get("x-piko-federated") != "true" {
+		return ""
+	}
+	return r.Header.Get(compress.EncodingHeader)
+}
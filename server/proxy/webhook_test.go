@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/compress"
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSignature(secret string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	expiredTS := strconv.FormatInt(now.Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name   string
+		conf   config.WebhookConfig
+		header http.Header
+		valid  bool
+	}{
+		{
+			name: "github",
+			conf: config.WebhookConfig{Format: "github", Secret: "my-secret"},
+			header: http.Header{
+				"X-Hub-Signature-256": []string{
+					"sha256=" + testSignature("my-secret", "my-body"),
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "github invalid secret",
+			conf: config.WebhookConfig{Format: "github", Secret: "my-secret"},
+			header: http.Header{
+				"X-Hub-Signature-256": []string{
+					"sha256=" + testSignature("other-secret", "my-body"),
+				},
+			},
+			valid: false,
+		},
+		{
+			name:   "github missing signature",
+			conf:   config.WebhookConfig{Format: "github", Secret: "my-secret"},
+			header: http.Header{},
+			valid:  false,
+		},
+		{
+			name: "stripe",
+			conf: config.WebhookConfig{Format: "stripe", Secret: "my-secret"},
+			header: http.Header{
+				"Stripe-Signature": []string{
+					"t=" + ts + ",v1=" + testSignature("my-secret", ts+".my-body"),
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "stripe multiple signatures",
+			conf: config.WebhookConfig{Format: "stripe", Secret: "my-secret"},
+			header: http.Header{
+				"Stripe-Signature": []string{
+					"t=" + ts +
+						",v1=" + testSignature("old-secret", ts+".my-body") +
+						",v1=" + testSignature("my-secret", ts+".my-body"),
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "stripe expired",
+			conf: config.WebhookConfig{Format: "stripe", Secret: "my-secret"},
+			header: http.Header{
+				"Stripe-Signature": []string{
+					"t=" + expiredTS + ",v1=" + testSignature(
+						"my-secret", expiredTS+".my-body",
+					),
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "stripe tolerance",
+			conf: config.WebhookConfig{
+				Format:    "stripe",
+				Secret:    "my-secret",
+				Tolerance: time.Hour * 2,
+			},
+			header: http.Header{
+				"Stripe-Signature": []string{
+					"t=" + expiredTS + ",v1=" + testSignature(
+						"my-secret", expiredTS+".my-body",
+					),
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "stripe signature without timestamp",
+			conf: config.WebhookConfig{Format: "stripe", Secret: "my-secret"},
+			header: http.Header{
+				"Stripe-Signature": []string{
+					"t=" + ts + ",v1=" + testSignature("my-secret", "my-body"),
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "hmac",
+			conf: config.WebhookConfig{
+				Format: "hmac",
+				Secret: "my-secret",
+				Header: "X-Signature",
+			},
+			header: http.Header{
+				"X-Signature": []string{testSignature("my-secret", "my-body")},
+			},
+			valid: true,
+		},
+		{
+			name: "hmac prefix",
+			conf: config.WebhookConfig{
+				Format: "hmac",
+				Secret: "my-secret",
+				Header: "X-Signature",
+			},
+			header: http.Header{
+				"X-Signature": []string{
+					"sha256=" + testSignature("my-secret", "my-body"),
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "hmac invalid encoding",
+			conf: config.WebhookConfig{
+				Format: "hmac",
+				Secret: "my-secret",
+				Header: "X-Signature",
+			},
+			header: http.Header{
+				"X-Signature": []string{"not-hex"},
+			},
+			valid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyWebhookSignature(tt.conf, tt.header, []byte("my-body"), now)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestWebhookVerifier(t *testing.T) {
+	rejected := prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "rejected"},
+		[]string{"reason"},
+	)
+	v := newWebhookVerifier(
+		[]endpointWebhook{
+			{
+				endpoint: "my-webhook",
+				conf: config.WebhookConfig{
+					Format:      "github",
+					Secret:      "my-secret",
+					MaxBodySize: 16,
+				},
+			},
+			{
+				endpoint: "my-*",
+			},
+		},
+		rejected,
+		log.NewNopLogger(),
+	)
+
+	verify := func(endpointID string, body string, signature string) (*httptest.ResponseRecorder, bool, *http.Request) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if signature != "" {
+			c.Request.Header.Set("X-Hub-Signature-256", "sha256="+signature)
+		}
+		return w, v.Verify(c, endpointID), c.Request
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		_, ok, r := verify(
+			"my-webhook", "my-body", testSignature("my-secret", "my-body"),
+		)
+		assert.True(t, ok)
+
+		// Verify the body can still be read.
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "my-body", string(body))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		w, ok, _ := verify(
+			"my-webhook", "my-body", testSignature("other-secret", "my-body"),
+		)
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(
+			rejected.With(prometheus.Labels{"reason": "invalid_signature"}),
+		))
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		body := strings.Repeat("a", 17)
+		w, ok, _ := verify(
+			"my-webhook", body, testSignature("my-secret", body),
+		)
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("forwarded compressed", func(t *testing.T) {
+		for _, encoding := range []string{"gzip", "zstd"} {
+			t.Run(encoding, func(t *testing.T) {
+				var compressed bytes.Buffer
+				cw, err := compress.NewWriter(encoding, &compressed)
+				require.NoError(t, err)
+				_, err = cw.Write([]byte("my-body"))
+				require.NoError(t, err)
+				require.NoError(t, cw.Close())
+
+				w := httptest.NewRecorder()
+				c, _ := gin.CreateTestContext(w)
+				c.Request = httptest.NewRequest(http.MethodPost, "/", &compressed)
+				c.Request.Header.Set("x-piko-forward", "true")
+				c.Request.Header.Set(compress.EncodingHeader, encoding)
+				c.Request.Header.Set(
+					"X-Hub-Signature-256",
+					"sha256="+testSignature("my-secret", "my-body"),
+				)
+
+				// The signature is of the decompressed body.
+				assert.True(t, v.Verify(c, "my-webhook"))
+
+				// The request body is replaced with the decompressed body.
+				assert.Empty(t, c.Request.Header.Get(compress.EncodingHeader))
+				assert.Equal(t, int64(len("my-body")), c.Request.ContentLength)
+				body, err := io.ReadAll(c.Request.Body)
+				require.NoError(t, err)
+				assert.Equal(t, "my-body", string(body))
+			})
+		}
+	})
+
+	t.Run("not verified", func(t *testing.T) {
+		// The first matching entry doesn't verify webhooks.
+		_, ok, _ := verify("my-endpoint", "my-body", "")
+		assert.True(t, ok)
+
+		_, ok, _ = verify("other-endpoint", "my-body", "")
+		assert.True(t, ok)
+	})
+}
@@ -0,0 +1,43 @@
+package quota
+
+import (
+	"net/http"
+
+	"github.com/andydunstall/piko/server/status"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler registers the admin routes to inspect tenant quota usage.
+type AdminHandler struct {
+	quotas *Quotas
+}
+
+func NewAdminHandler(quotas *Quotas) *AdminHandler {
+	return &AdminHandler{
+		quotas: quotas,
+	}
+}
+
+func (h *AdminHandler) Register(group *gin.RouterGroup) {
+	group.GET("/quotas", h.listQuotasRoute)
+}
+
+// listQuotasRoute returns the quotas and usage of each tenant on the local
+// node.
+//
+// If the 'tenant' query is set, only the given tenant is returned.
+func (h *AdminHandler) listQuotasRoute(c *gin.Context) {
+	usages := h.quotas.Usage()
+	if tenant, ok := c.GetQuery("tenant"); ok {
+		filtered := make([]Usage, 0)
+		for _, usage := range usages {
+			if usage.Tenant == tenant {
+				filtered = append(filtered, usage)
+			}
+		}
+		usages = filtered
+	}
+	c.JSON(http.StatusOK, usages)
+}
+
+var _ status.Handler = &AdminHandler{}
@@ -0,0 +1,374 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/andydunstall/piko/server/config"
+)
+
+const (
+	QuotaMaxEndpoints         = "max_endpoints"
+	QuotaMaxUpstreams         = "max_upstreams"
+	QuotaMaxRequestsPerSecond = "max_requests_per_second"
+	QuotaMaxBytesPerSecond    = "max_bytes_per_second"
+)
+
+// Error describes a tenant exceeding one of its quotas.
+type Error struct {
+	Tenant string `json:"tenant"`
+
+	// Quota is the name of the exceeded quota, such as
+	// 'max_requests_per_second'.
+	Quota string `json:"quota"`
+
+	Limit int64 `json:"limit"`
+
+	// RetryAfter is the duration until the tenant is within the quota, or
+	// zero if unknown (such as the tenant must first disconnect upstreams).
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf(
+		"tenant %s exceeded quota %s (limit %d)", e.Tenant, e.Quota, e.Limit,
+	)
+}
+
+type errorMessage struct {
+	Error  string `json:"error"`
+	Tenant string `json:"tenant"`
+	Quota  string `json:"quota"`
+	Limit  int64  `json:"limit"`
+}
+
+// WriteResponse writes a '429 Too Many Requests' response describing the
+// exceeded quota, such as
+// '{"error": "quota exceeded", "tenant": "team-a", "quota": "max_upstreams", "limit": 10}'.
+func (e *Error) WriteResponse(w http.ResponseWriter) {
+	if e.RetryAfter > 0 {
+		retryAfter := int(math.Ceil(e.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	_ = json.NewEncoder(w).Encode(&errorMessage{
+		Error:  "quota exceeded",
+		Tenant: e.Tenant,
+		Quota:  e.Quota,
+		Limit:  e.Limit,
+	})
+}
+
+// Limits contains the quotas of a tenant, where a limit of 0 means there is
+// no limit.
+type Limits struct {
+	MaxEndpoints         int   `json:"max_endpoints"`
+	MaxUpstreams         int   `json:"max_upstreams"`
+	MaxRequestsPerSecond int   `json:"max_requests_per_second"`
+	MaxBytesPerSecond    int64 `json:"max_bytes_per_second"`
+}
+
+// Usage describes the usage of a tenant on the local node.
+type Usage struct {
+	Tenant string `json:"tenant"`
+
+	Limits Limits `json:"limits"`
+
+	// Endpoints is the number of endpoints with upstreams connected.
+	Endpoints int `json:"endpoints"`
+
+	// Upstreams is the number of connected upstreams.
+	Upstreams int `json:"upstreams"`
+
+	// Requests is the total number of proxied requests.
+	Requests uint64 `json:"requests"`
+
+	// Bytes is the total number of request and response body bytes proxied.
+	Bytes uint64 `json:"bytes"`
+
+	// Rejected contains the number of upstreams and requests rejected,
+	// keyed by the exceeded quota.
+	Rejected map[string]uint64 `json:"rejected"`
+}
+
+type tenantUsage struct {
+	limits Limits
+
+	// endpoints contains the number of connected upstreams for each of the
+	// tenant's endpoints.
+	endpoints map[string]int
+	upstreams int
+
+	requests *bucket
+	bytes    *bucket
+
+	requestsTotal uint64
+	bytesTotal    uint64
+	rejected      map[string]uint64
+}
+
+// Quotas enforces the quotas of each tenant on the local node.
+//
+// Only tenants matching a configured entry are limited. Endpoints that
+// don't belong to a tenant aren't limited.
+type Quotas struct {
+	conf []config.TenantConfig
+
+	tenants map[string]*tenantUsage
+
+	mu sync.Mutex
+
+	// now returns the current time, which may be overridden in tests.
+	now func() time.Time
+}
+
+func NewQuotas(conf []config.TenantConfig) *Quotas {
+	return &Quotas{
+		conf:    conf,
+		tenants: make(map[string]*tenantUsage),
+		now:     time.Now,
+	}
+}
+
+// AddUpstream registers an upstream for the given endpoint ID, which is
+// scoped to the tenant.
+//
+// Returns an error if adding the upstream would exceed the tenant's endpoint
+// or upstream quota. Otherwise returns a function to remove the upstream,
+// which must be called once the upstream disconnects.
+func (q *Quotas) AddUpstream(endpointID string) (func(), error) {
+	tenant, _ := auth.SplitEndpoint(endpointID)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage, ok := q.usageLocked(tenant)
+	if !ok {
+		return func() {}, nil
+	}
+
+	if usage.limits.MaxUpstreams != 0 &&
+		usage.upstreams >= usage.limits.MaxUpstreams {
+		return nil, usage.reject(
+			tenant, QuotaMaxUpstreams, int64(usage.limits.MaxUpstreams), 0,
+		)
+	}
+	if _, ok := usage.endpoints[endpointID]; !ok &&
+		usage.limits.MaxEndpoints != 0 &&
+		len(usage.endpoints) >= usage.limits.MaxEndpoints {
+		return nil, usage.reject(
+			tenant, QuotaMaxEndpoints, int64(usage.limits.MaxEndpoints), 0,
+		)
+	}
+
+	usage.endpoints[endpointID]++
+	usage.upstreams++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+
+			usage.upstreams--
+			usage.endpoints[endpointID]--
+			if usage.endpoints[endpointID] == 0 {
+				delete(usage.endpoints, endpointID)
+			}
+		})
+	}, nil
+}
+
+// AllowRequest returns whether a request to the tenant's endpoints is
+// permitted by the tenant's request rate and bandwidth quotas.
+func (q *Quotas) AllowRequest(tenant string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage, ok := q.usageLocked(tenant)
+	if !ok {
+		return nil
+	}
+
+	now := q.now()
+	// Bandwidth is only known once the request completes, so requests are
+	// rejected while the tenant is over its bandwidth quota.
+	if usage.bytes != nil {
+		if wait := usage.bytes.Wait(now); wait > 0 {
+			return usage.reject(
+				tenant, QuotaMaxBytesPerSecond, usage.limits.MaxBytesPerSecond, wait,
+			)
+		}
+	}
+	if usage.requests != nil {
+		if wait := usage.requests.Take(now, 1); wait > 0 {
+			return usage.reject(
+				tenant,
+				QuotaMaxRequestsPerSecond,
+				int64(usage.limits.MaxRequestsPerSecond),
+				wait,
+			)
+		}
+	}
+
+	usage.requestsTotal++
+	return nil
+}
+
+// RecordBytes records the number of body bytes proxied for the tenant.
+func (q *Quotas) RecordBytes(tenant string, n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage, ok := q.usageLocked(tenant)
+	if !ok {
+		return
+	}
+
+	usage.bytesTotal += uint64(n)
+	if usage.bytes != nil {
+		usage.bytes.Consume(q.now(), float64(n))
+	}
+}
+
+// Usage returns the usage of each tenant with a quota, sorted by tenant.
+func (q *Quotas) Usage() []Usage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usages := make([]Usage, 0, len(q.tenants))
+	for tenant, usage := range q.tenants {
+		rejected := make(map[string]uint64, len(usage.rejected))
+		for quota, n := range usage.rejected {
+			rejected[quota] = n
+		}
+		usages = append(usages, Usage{
+			Tenant:    tenant,
+			Limits:    usage.limits,
+			Endpoints: len(usage.endpoints),
+			Upstreams: usage.upstreams,
+			Requests:  usage.requestsTotal,
+			Bytes:     usage.bytesTotal,
+			Rejected:  rejected,
+		})
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Tenant < usages[j].Tenant
+	})
+	return usages
+}
+
+// usageLocked returns the usage of the given tenant, or false if the tenant
+// doesn't have a quota.
+func (q *Quotas) usageLocked(tenant string) (*tenantUsage, bool) {
+	if tenant == "" {
+		return nil, false
+	}
+	if usage, ok := q.tenants[tenant]; ok {
+		return usage, true
+	}
+
+	for _, conf := range q.conf {
+		if !auth.MatchEndpoint(conf.Tenant, tenant) {
+			continue
+		}
+
+		usage := &tenantUsage{
+			limits: Limits{
+				MaxEndpoints:         conf.MaxEndpoints,
+				MaxUpstreams:         conf.MaxUpstreams,
+				MaxRequestsPerSecond: conf.MaxRequestsPerSecond,
+				MaxBytesPerSecond:    conf.MaxBytesPerSecond,
+			},
+			endpoints: make(map[string]int),
+			rejected:  make(map[string]uint64),
+		}
+		if conf.MaxRequestsPerSecond != 0 {
+			usage.requests = newBucket(float64(conf.MaxRequestsPerSecond), q.now())
+		}
+		if conf.MaxBytesPerSecond != 0 {
+			usage.bytes = newBucket(float64(conf.MaxBytesPerSecond), q.now())
+		}
+		q.tenants[tenant] = usage
+		return usage, true
+	}
+	return nil, false
+}
+
+func (u *tenantUsage) reject(
+	tenant string,
+	quota string,
+	limit int64,
+	retryAfter time.Duration,
+) *Error {
+	u.rejected[quota]++
+	return &Error{
+		Tenant:     tenant,
+		Quota:      quota,
+		Limit:      limit,
+		RetryAfter: retryAfter,
+	}
+}
+
+// bucket is a token bucket that refills at the given rate per second, up to
+// one second of tokens.
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, now time.Time) *bucket {
+	return &bucket{
+		rate:   rate,
+		tokens: rate,
+		last:   now,
+	}
+}
+
+// Take takes n tokens from the bucket. If there aren't enough tokens, no
+// tokens are taken and returns the duration until there will be.
+func (b *bucket) Take(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	if b.tokens < n {
+		return b.waitFor(n)
+	}
+	b.tokens -= n
+	return 0
+}
+
+// Consume takes n tokens from the bucket, which may leave the bucket in
+// debt.
+func (b *bucket) Consume(now time.Time, n float64) {
+	b.refill(now)
+	b.tokens -= n
+}
+
+// Wait returns the duration until the bucket is no longer in debt, or zero
+// if it isn't in debt.
+func (b *bucket) Wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return b.waitFor(0)
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.rate, b.tokens+elapsed.Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+func (b *bucket) waitFor(n float64) time.Duration {
+	return time.Duration(math.Ceil((n - b.tokens) / b.rate * float64(time.Second)))
+}
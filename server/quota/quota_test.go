@@ -0,0 +1,168 @@
+package quota
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/server/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotas_AddUpstream(t *testing.T) {
+	t.Run("max upstreams", func(t *testing.T) {
+		q := NewQuotas([]config.TenantConfig{
+			{Tenant: "team-a", MaxUpstreams: 2},
+		})
+
+		remove1, err := q.AddUpstream("team-a/endpoint-1")
+		require.NoError(t, err)
+		_, err = q.AddUpstream("team-a/endpoint-2")
+		require.NoError(t, err)
+
+		_, err = q.AddUpstream("team-a/endpoint-1")
+		var quotaErr *Error
+		require.ErrorAs(t, err, &quotaErr)
+		assert.Equal(t, "team-a", quotaErr.Tenant)
+		assert.Equal(t, QuotaMaxUpstreams, quotaErr.Quota)
+		assert.Equal(t, int64(2), quotaErr.Limit)
+
+		// Other tenants aren't affected.
+		_, err = q.AddUpstream("team-b/endpoint-1")
+		require.NoError(t, err)
+		_, err = q.AddUpstream("endpoint-1")
+		require.NoError(t, err)
+
+		remove1()
+		// Removing multiple times is a no-op.
+		remove1()
+
+		_, err = q.AddUpstream("team-a/endpoint-1")
+		require.NoError(t, err)
+	})
+
+	t.Run("max endpoints", func(t *testing.T) {
+		q := NewQuotas([]config.TenantConfig{
+			{Tenant: "team-*", MaxEndpoints: 1},
+		})
+
+		remove, err := q.AddUpstream("team-a/endpoint-1")
+		require.NoError(t, err)
+		// Upstreams for an existing endpoint are permitted.
+		_, err = q.AddUpstream("team-a/endpoint-1")
+		require.NoError(t, err)
+
+		_, err = q.AddUpstream("team-a/endpoint-2")
+		var quotaErr *Error
+		require.ErrorAs(t, err, &quotaErr)
+		assert.Equal(t, QuotaMaxEndpoints, quotaErr.Quota)
+
+		// The endpoint is only removed once all its upstreams disconnect.
+		remove()
+		_, err = q.AddUpstream("team-a/endpoint-2")
+		require.Error(t, err)
+	})
+}
+
+func TestQuotas_AllowRequest(t *testing.T) {
+	t.Run("max requests per second", func(t *testing.T) {
+		now := time.Now()
+		q := NewQuotas([]config.TenantConfig{
+			{Tenant: "team-a", MaxRequestsPerSecond: 2},
+		})
+		q.now = func() time.Time { return now }
+
+		assert.NoError(t, q.AllowRequest("team-a"))
+		assert.NoError(t, q.AllowRequest("team-a"))
+
+		err := q.AllowRequest("team-a")
+		var quotaErr *Error
+		require.ErrorAs(t, err, &quotaErr)
+		assert.Equal(t, QuotaMaxRequestsPerSecond, quotaErr.Quota)
+		assert.Equal(t, time.Millisecond*500, quotaErr.RetryAfter)
+
+		// Tenants without a quota aren't limited.
+		assert.NoError(t, q.AllowRequest("team-b"))
+		assert.NoError(t, q.AllowRequest(""))
+
+		now = now.Add(time.Millisecond * 500)
+		assert.NoError(t, q.AllowRequest("team-a"))
+		assert.Error(t, q.AllowRequest("team-a"))
+	})
+
+	t.Run("max bytes per second", func(t *testing.T) {
+		now := time.Now()
+		q := NewQuotas([]config.TenantConfig{
+			{Tenant: "team-a", MaxBytesPerSecond: 1000},
+		})
+		q.now = func() time.Time { return now }
+
+		assert.NoError(t, q.AllowRequest("team-a"))
+		q.RecordBytes("team-a", 3000)
+
+		// The tenant is 2000 bytes over the quota, so must wait 2 seconds.
+		err := q.AllowRequest("team-a")
+		var quotaErr *Error
+		require.ErrorAs(t, err, &quotaErr)
+		assert.Equal(t, QuotaMaxBytesPerSecond, quotaErr.Quota)
+		assert.Equal(t, time.Second*2, quotaErr.RetryAfter)
+
+		now = now.Add(time.Second * 2)
+		assert.NoError(t, q.AllowRequest("team-a"))
+	})
+}
+
+func TestQuotas_Usage(t *testing.T) {
+	q := NewQuotas([]config.TenantConfig{
+		{Tenant: "team-a", MaxUpstreams: 1, MaxRequestsPerSecond: 10},
+	})
+
+	_, err := q.AddUpstream("team-a/endpoint-1")
+	require.NoError(t, err)
+	_, err = q.AddUpstream("team-a/endpoint-2")
+	require.Error(t, err)
+	require.NoError(t, q.AllowRequest("team-a"))
+	q.RecordBytes("team-a", 100)
+
+	assert.Equal(t, []Usage{
+		{
+			Tenant: "team-a",
+			Limits: Limits{
+				MaxUpstreams:         1,
+				MaxRequestsPerSecond: 10,
+			},
+			Endpoints: 1,
+			Upstreams: 1,
+			Requests:  1,
+			Bytes:     100,
+			Rejected: map[string]uint64{
+				QuotaMaxUpstreams: 1,
+			},
+		},
+	}, q.Usage())
+}
+
+func TestError_WriteResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&Error{
+		Tenant:     "team-a",
+		Quota:      QuotaMaxRequestsPerSecond,
+		Limit:      10,
+		RetryAfter: time.Millisecond * 100,
+	}).WriteResponse(w)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	var m errorMessage
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&m))
+	assert.Equal(t, errorMessage{
+		Error:  "quota exceeded",
+		Tenant: "team-a",
+		Quota:  QuotaMaxRequestsPerSecond,
+		Limit:  10,
+	}, m)
+}
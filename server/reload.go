@@ -0,0 +1,215 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/status"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// SetConfigLoader sets the function used to load the latest configuration
+// when the configuration is reloaded, such as from the config file,
+// environment and command line flags.
+func (s *Server) SetConfigLoader(loader func() (*config.Config, error)) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	s.configLoader = loader
+}
+
+// ReloadConfig loads the latest configuration and reloads it.
+func (s *Server) ReloadConfig() error {
+	s.reloadMu.Lock()
+	loader := s.configLoader
+	s.reloadMu.Unlock()
+
+	if loader == nil {
+		return fmt.Errorf("config loader not configured")
+	}
+
+	conf, err := loader()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	return s.Reload(conf)
+}
+
+// Reload updates the node with the subset of the given configuration that
+// can be changed at runtime, without restarting the node or dropping
+// connected upstreams:
+// - Log level and subsystems
+// - Endpoint and proxy client token verification keys
+// - TLS certificates
+//
+// Any other configuration requires a restart so is ignored.
+//
+// The configuration is validated before being applied, so if reloading fails
+// the node configuration is unchanged.
+func (s *Server) Reload(conf *config.Config) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	var verifier auth.Verifier
+	if (s.verifier != nil) != conf.Auth.AuthEnabled() {
+		return fmt.Errorf("auth: enabling or disabling auth requires a restart")
+	}
+	if s.verifier != nil {
+		v, err := auth.NewJWTVerifierFromConfig(conf.Auth)
+		if err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+		verifier = v
+	}
+
+	var proxyVerifier auth.Verifier
+	if (s.proxyVerifier != nil) != conf.Proxy.Auth.AuthEnabled() {
+		return fmt.Errorf("proxy: auth: enabling or disabling auth requires a restart")
+	}
+	if s.proxyVerifier != nil {
+		v, err := auth.NewJWTVerifierFromConfig(conf.Proxy.Auth)
+		if err != nil {
+			return fmt.Errorf("proxy: auth: %w", err)
+		}
+		proxyVerifier = v
+	}
+
+	certs, err := s.reloadCerts(conf)
+	if err != nil {
+		return err
+	}
+	loadedCerts := make([]*tls.Certificate, len(certs))
+	for i, c := range certs {
+		// Enabling TLS requires a restart. Note the upstream listener has
+		// no certificate on proxy only nodes, even if TLS is configured.
+		if c.cert == nil {
+			continue
+		}
+		if !c.conf.Enabled {
+			return fmt.Errorf("%s tls: disabling tls requires a restart", c.name)
+		}
+		cert, err := tls.LoadX509KeyPair(c.conf.Cert, c.conf.Key)
+		if err != nil {
+			return fmt.Errorf("%s tls: load key pair: %w", c.name, err)
+		}
+		loadedCerts[i] = &cert
+	}
+
+	if err := s.logger.SetLevel(conf.Log.Level); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+	s.logger.SetSubsystems(conf.Log.Subsystems)
+
+	if verifier != nil {
+		s.verifier.Reload(verifier)
+	}
+	if proxyVerifier != nil {
+		s.proxyVerifier.Reload(proxyVerifier)
+	}
+	for i, c := range certs {
+		if loadedCerts[i] != nil {
+			c.cert.Store(loadedCerts[i])
+		}
+	}
+
+	s.logger.Info(
+		"reloaded config",
+		zap.String("log-level", conf.Log.Level),
+		zap.Strings("log-subsystems", conf.Log.Subsystems),
+	)
+
+	return nil
+}
+
+// reloadCert is the reloadable TLS certificate of a listener, along with the
+// listener TLS configuration to reload the certificate from.
+type reloadCert struct {
+	name string
+	cert *certificate
+	conf config.TLSConfig
+}
+
+// reloadCerts returns the reloadable certificate of each listener, using the
+// listener TLS configuration in conf.
+func (s *Server) reloadCerts(conf *config.Config) ([]reloadCert, error) {
+	certs := []reloadCert{
+		{"proxy", s.proxyCert, conf.Proxy.TLS.TLSConfig},
+		{"upstream", s.upstreamCert, conf.Upstream.TLS},
+		{"admin", s.adminCert, conf.Admin.TLS},
+	}
+	if len(conf.Proxy.Listeners) != len(s.proxyListeners) {
+		return nil, fmt.Errorf("proxy: adding or removing listeners requires a restart")
+	}
+	for i, l := range s.proxyListeners {
+		certs = append(certs, reloadCert{
+			"proxy listener " + conf.Proxy.Listeners[i].BindAddr,
+			l.cert,
+			conf.Proxy.Listeners[i].TLS,
+		})
+	}
+	return certs, nil
+}
+
+// certificate is a TLS certificate that can be replaced at runtime.
+type certificate struct {
+	cert atomic.Pointer[tls.Certificate]
+}
+
+func (c *certificate) Store(cert *tls.Certificate) {
+	c.cert.Store(cert)
+}
+
+func (c *certificate) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// loadTLS loads the TLS configuration for a listener, where the certificate
+// can be reloaded at runtime.
+//
+// Returns a nil configuration and certificate if TLS is disabled.
+func loadTLS(conf config.TLSConfig) (*tls.Config, *certificate, error) {
+	tlsConfig, err := conf.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if tlsConfig == nil {
+		return nil, nil, nil
+	}
+
+	cert := &certificate{}
+	cert.Store(&tlsConfig.Certificates[0])
+	tlsConfig.Certificates = nil
+	tlsConfig.GetCertificate = cert.GetCertificate
+	return tlsConfig, cert, nil
+}
+
+// reloadHandler registers the admin route to reload the node configuration.
+type reloadHandler struct {
+	server *Server
+}
+
+func newReloadHandler(server *Server) *reloadHandler {
+	return &reloadHandler{
+		server: server,
+	}
+}
+
+func (h *reloadHandler) Register(group *gin.RouterGroup) {
+	group.POST("", h.reloadRoute)
+}
+
+func (h *reloadHandler) reloadRoute(c *gin.Context) {
+	if err := h.server.ReloadConfig(); err != nil {
+		h.server.logger.Warn("failed to reload config", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+var _ status.Handler = &reloadHandler{}
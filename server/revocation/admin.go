@@ -0,0 +1,53 @@
+package revocation
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/andydunstall/piko/server/status"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler registers the admin routes to revoke tokens.
+type AdminHandler struct {
+	revocations *List
+}
+
+func NewAdminHandler(revocations *List) *AdminHandler {
+	return &AdminHandler{
+		revocations: revocations,
+	}
+}
+
+func (h *AdminHandler) Register(group *gin.RouterGroup) {
+	group.GET("/revocations", h.listRevocationsRoute)
+	group.POST("/revocations", h.revokeRoute)
+}
+
+// listRevocationsRoute returns the revoked tokens known by the local node.
+func (h *AdminHandler) listRevocationsRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, h.revocations.Revocations())
+}
+
+// revokeRoute revokes a token, such as '{"id": "2f1c9a"}'. The revocation
+// is propagated to the rest of the cluster.
+func (h *AdminHandler) revokeRoute(c *gin.Context) {
+	var r Revocation
+	if err := c.BindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if r.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing token id"})
+		return
+	}
+	if r.expired(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token already expired"})
+		return
+	}
+
+	h.revocations.Revoke(r)
+	c.JSON(http.StatusOK, r)
+}
+
+var _ status.Handler = &AdminHandler{}
@@ -0,0 +1,186 @@
+package revocation
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Revocation describes a revoked token.
+type Revocation struct {
+	// ID is the revoked token ID ('jti' claim).
+	ID string `json:"id"`
+
+	// Expiry is the time the token expires, after which the revocation is
+	// discarded since the token is no longer valid anyway. Zero if the
+	// revocation doesn't expire.
+	Expiry time.Time `json:"expiry"`
+}
+
+func (r *Revocation) expired(now time.Time) bool {
+	return !r.Expiry.IsZero() && now.After(r.Expiry)
+}
+
+type revocationWaiter struct {
+	ch chan struct{}
+}
+
+// List contains the revoked token IDs.
+//
+// Revocations are propagated to the other nodes in the cluster using gossip.
+// Each node that learns about a revocation also propagates it, so the
+// revocation isn't lost when the node it was revoked on leaves. Revocations
+// aren't persisted, so are lost if all nodes restart.
+type List struct {
+	revocations map[string]Revocation
+
+	// waiters contains the connections waiting for their token to be
+	// revoked, keyed by token ID.
+	waiters map[string]map[*revocationWaiter]struct{}
+
+	revokeSubscribers []func(r Revocation)
+	expireSubscribers []func(id string)
+
+	mu sync.Mutex
+}
+
+func NewList() *List {
+	return &List{
+		revocations: make(map[string]Revocation),
+		waiters:     make(map[string]map[*revocationWaiter]struct{}),
+	}
+}
+
+// Revoke revokes the token with the given ID, and notifies any connections
+// waiting for the token to be revoked.
+//
+// Returns false if the token is already revoked, or the revocation has
+// already expired.
+func (l *List) Revoke(r Revocation) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.removeExpiredLocked(now)
+
+	if r.expired(now) {
+		return false
+	}
+	if _, ok := l.revocations[r.ID]; ok {
+		return false
+	}
+	l.revocations[r.ID] = r
+
+	for w := range l.waiters[r.ID] {
+		close(w.ch)
+	}
+	delete(l.waiters, r.ID)
+
+	for _, f := range l.revokeSubscribers {
+		f(r)
+	}
+	return true
+}
+
+// Revoked returns whether the token with the given ID is revoked.
+func (l *List) Revoked(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.revocations[id]
+	return ok && !r.expired(time.Now())
+}
+
+// Wait registers a connection as waiting for the token with the given ID to
+// be revoked.
+//
+// Returns a channel that is closed once the token is revoked, and a function
+// to stop waiting, which must be called once the connection closes.
+func (l *List) Wait(id string) (<-chan struct{}, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := &revocationWaiter{
+		ch: make(chan struct{}),
+	}
+	if r, ok := l.revocations[id]; ok && !r.expired(time.Now()) {
+		close(w.ch)
+		return w.ch, func() {}
+	}
+
+	waiters, ok := l.waiters[id]
+	if !ok {
+		waiters = make(map[*revocationWaiter]struct{})
+		l.waiters[id] = waiters
+	}
+	waiters[w] = struct{}{}
+
+	return w.ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		waiters, ok := l.waiters[id]
+		if !ok {
+			return
+		}
+		delete(waiters, w)
+		if len(waiters) == 0 {
+			delete(l.waiters, id)
+		}
+	}
+}
+
+// Revocations returns the revoked tokens, sorted by ID.
+func (l *List) Revocations() []Revocation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	revocations := make([]Revocation, 0, len(l.revocations))
+	for _, r := range l.revocations {
+		if r.expired(now) {
+			continue
+		}
+		revocations = append(revocations, r)
+	}
+	sort.Slice(revocations, func(i, j int) bool {
+		return revocations[i].ID < revocations[j].ID
+	})
+	return revocations
+}
+
+// OnRevoke subscribes to tokens being revoked, including revocations learned
+// from other nodes.
+//
+// The callback is called with the list mutex locked so must not block or
+// call back to the list.
+func (l *List) OnRevoke(f func(r Revocation)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.revokeSubscribers = append(l.revokeSubscribers, f)
+}
+
+// OnExpire subscribes to revocations being discarded once the token has
+// expired.
+//
+// The callback is called with the list mutex locked so must not block or
+// call back to the list.
+func (l *List) OnExpire(f func(id string)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expireSubscribers = append(l.expireSubscribers, f)
+}
+
+func (l *List) removeExpiredLocked(now time.Time) {
+	for id, r := range l.revocations {
+		if !r.expired(now) {
+			continue
+		}
+		delete(l.revocations, id)
+		for _, f := range l.expireSubscribers {
+			f(id)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package revocation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	t.Run("revoke", func(t *testing.T) {
+		l := NewList()
+
+		var revoked []Revocation
+		l.OnRevoke(func(r Revocation) {
+			revoked = append(revoked, r)
+		})
+
+		assert.True(t, l.Revoke(Revocation{ID: "token-1"}))
+		assert.True(t, l.Revoked("token-1"))
+		assert.False(t, l.Revoked("token-2"))
+
+		// Revoking an already revoked token does nothing.
+		assert.False(t, l.Revoke(Revocation{ID: "token-1"}))
+
+		assert.Equal(t, []Revocation{{ID: "token-1"}}, revoked)
+		assert.Equal(t, []Revocation{{ID: "token-1"}}, l.Revocations())
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		l := NewList()
+
+		var expired []string
+		l.OnExpire(func(id string) {
+			expired = append(expired, id)
+		})
+
+		// Revocations that have already expired are ignored.
+		assert.False(t, l.Revoke(Revocation{
+			ID:     "token-1",
+			Expiry: time.Now().Add(-time.Minute),
+		}))
+		assert.False(t, l.Revoked("token-1"))
+
+		// Add a revocation that expires immediately, then check it's
+		// removed on the next revoke.
+		assert.True(t, l.Revoke(Revocation{
+			ID:     "token-2",
+			Expiry: time.Now().Add(time.Millisecond),
+		}))
+		assert.Eventually(t, func() bool {
+			return !l.Revoked("token-2")
+		}, time.Second, time.Millisecond)
+
+		assert.True(t, l.Revoke(Revocation{ID: "token-3"}))
+		assert.Equal(t, []string{"token-2"}, expired)
+		assert.Equal(t, []Revocation{{ID: "token-3"}}, l.Revocations())
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		l := NewList()
+
+		revoked, cancel := l.Wait("token-1")
+		defer cancel()
+
+		select {
+		case <-revoked:
+			t.Fatal("token revoked")
+		default:
+		}
+
+		l.Revoke(Revocation{ID: "token-1"})
+
+		select {
+		case <-revoked:
+		default:
+			t.Fatal("token not revoked")
+		}
+	})
+
+	t.Run("wait already revoked", func(t *testing.T) {
+		l := NewList()
+		l.Revoke(Revocation{ID: "token-1"})
+
+		revoked, cancel := l.Wait("token-1")
+		defer cancel()
+
+		select {
+		case <-revoked:
+		default:
+			t.Fatal("token not revoked")
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package revocation
+
+import (
+	"github.com/andydunstall/piko/server/auth"
+)
+
+// Verifier is a Verifier that rejects revoked tokens.
+type Verifier struct {
+	verifier    auth.Verifier
+	revocations *List
+}
+
+func NewVerifier(verifier auth.Verifier, revocations *List) *Verifier {
+	return &Verifier{
+		verifier:    verifier,
+		revocations: revocations,
+	}
+}
+
+// VerifyEndpointToken verifies the token using the underlying verifier, then
+// returns auth.ErrRevokedToken if the token ID has been revoked.
+func (v *Verifier) VerifyEndpointToken(tokenString string) (auth.EndpointToken, error) {
+	token, err := v.verifier.VerifyEndpointToken(tokenString)
+	if err != nil {
+		return auth.EndpointToken{}, err
+	}
+	if token.ID != "" && v.revocations.Revoked(token.ID) {
+		return auth.EndpointToken{}, auth.ErrRevokedToken
+	}
+	return token, nil
+}
+
+var _ auth.Verifier = &Verifier{}
@@ -0,0 +1,45 @@
+package revocation
+
+import (
+	"testing"
+
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeVerifier struct {
+	token auth.EndpointToken
+}
+
+func (v *fakeVerifier) VerifyEndpointToken(_ string) (auth.EndpointToken, error) {
+	return v.token, nil
+}
+
+func TestVerifier(t *testing.T) {
+	revocations := NewList()
+	revocations.Revoke(Revocation{ID: "revoked-token"})
+
+	t.Run("revoked", func(t *testing.T) {
+		v := NewVerifier(&fakeVerifier{
+			token: auth.EndpointToken{ID: "revoked-token"},
+		}, revocations)
+		_, err := v.VerifyEndpointToken("token")
+		assert.ErrorIs(t, err, auth.ErrRevokedToken)
+	})
+
+	t.Run("not revoked", func(t *testing.T) {
+		v := NewVerifier(&fakeVerifier{
+			token: auth.EndpointToken{ID: "my-token"},
+		}, revocations)
+		token, err := v.VerifyEndpointToken("token")
+		require.NoError(t, err)
+		assert.Equal(t, "my-token", token.ID)
+	})
+
+	t.Run("no id", func(t *testing.T) {
+		v := NewVerifier(&fakeVerifier{}, revocations)
+		_, err := v.VerifyEndpointToken("token")
+		require.NoError(t, err)
+	})
+}
@@ -2,25 +2,37 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
-	"strings"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/andydunstall/piko/pkg/build"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/sdnotify"
+	"github.com/andydunstall/piko/pkg/spiffe"
+	"github.com/andydunstall/piko/pkg/statsd"
 	"github.com/andydunstall/piko/server/admin"
 	"github.com/andydunstall/piko/server/auth"
 	"github.com/andydunstall/piko/server/cluster"
 	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/events"
+	"github.com/andydunstall/piko/server/federation"
 	"github.com/andydunstall/piko/server/gossip"
+	"github.com/andydunstall/piko/server/lockout"
 	"github.com/andydunstall/piko/server/proxy"
+	"github.com/andydunstall/piko/server/quota"
+	"github.com/andydunstall/piko/server/revocation"
 	"github.com/andydunstall/piko/server/upstream"
 	"github.com/andydunstall/piko/server/usage"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/hashicorp/go-sockaddr"
 	rungroup "github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // Server is a Piko server node.
@@ -30,190 +42,547 @@ type Server struct {
 	proxyLn     net.Listener
 	proxyServer *proxy.Server
 
+	// proxyListeners are the additional proxy listeners, which serve the
+	// same proxy server with their own TLS configuration.
+	proxyListeners []*proxyListener
+
+	// acmeManager obtains the proxy listener certificates using ACME, or is
+	// nil if ACME is disabled.
+	acmeManager *autocert.Manager
+	// acmeHTTPLn is the listener for ACME HTTP-01 challenges, or nil if
+	// HTTP-01 challenges are disabled.
+	acmeHTTPLn net.Listener
+
 	upstreamLn     net.Listener
 	upstreamServer *upstream.Server
 
+	upstreams *upstream.LoadBalancedManager
+
 	adminLn     net.Listener
 	adminServer *admin.Server
 
+	rpcLn     net.Listener
+	rpcServer *proxy.RPCServer
+
+	gossipStreamLn net.Listener
+	gossipPacketLn net.PacketConn
+
+	federation *federation.Federation
+
 	gossiper *gossip.Gossip
 
 	reporter *usage.Reporter
 
+	// statsdExporter pushes metrics to StatsD, or is nil if StatsD is not
+	// configured.
+	statsdExporter *statsd.Exporter
+
+	// verifier is the reloadable endpoint token verifier, or nil if
+	// authentication is disabled.
+	verifier *auth.ReloadableVerifier
+
+	// proxyVerifier is the reloadable proxy client token verifier, or nil
+	// if proxy client authentication is disabled.
+	proxyVerifier *auth.ReloadableVerifier
+
+	// spiffeSource fetches the node's SPIFFE SVID from the Workload API, or
+	// is nil if SPIFFE is disabled.
+	spiffeSource *spiffe.Source
+
+	// proxyCert, upstreamCert and adminCert are the reloadable TLS
+	// certificates for each listener, or nil if TLS is disabled.
+	proxyCert    *certificate
+	upstreamCert *certificate
+	adminCert    *certificate
+
+	// configLoader loads the latest configuration to reload.
+	configLoader func() (*config.Config, error)
+
+	// reloadMu prevents concurrent reloads.
+	reloadMu sync.Mutex
+
 	conf *config.Config
 
-	closeCh    chan struct{}
-	shutdownCh chan struct{}
+	closeCh      chan struct{}
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+
+	readyOnce sync.Once
+
+	// inherited contains the listeners inherited from the parent process if
+	// the node was started by an upgrade.
+	inherited *inheritedListeners
+
+	// upgrading indicates the node is handing off its listeners to a new
+	// process.
+	upgrading atomic.Bool
+
+	// left indicates the node has already left the cluster, which happens
+	// before handing off its listeners to a new process.
+	left atomic.Bool
 
 	logger log.Logger
 }
 
+// proxyListener is an additional proxy listener.
+type proxyListener struct {
+	ln        net.Listener
+	tlsConfig *tls.Config
+	// cert is the reloadable TLS certificate, or nil if TLS is disabled.
+	cert *certificate
+}
+
 func NewServer(conf *config.Config, logger log.Logger) (*Server, error) {
 	logger = logger.WithSubsystem("server")
 
-	var verifier auth.Verifier
+	// The verifier and TLS certificates can be reloaded at runtime, so are
+	// wrapped to be replaced on reload.
+
+	var verifier *auth.ReloadableVerifier
 	if conf.Auth.AuthEnabled() {
-		verifierConf := auth.JWTVerifierConfig{
-			HMACSecretKey: []byte(conf.Auth.TokenHMACSecretKey),
-			Audience:      conf.Auth.TokenAudience,
-			Issuer:        conf.Auth.TokenIssuer,
+		v, err := auth.NewJWTVerifierFromConfig(conf.Auth)
+		if err != nil {
+			return nil, err
 		}
+		verifier = auth.NewReloadableVerifier(v)
+	}
 
-		if conf.Auth.TokenRSAPublicKey != "" {
-			rsaPublicKey, err := jwt.ParseRSAPublicKeyFromPEM(
-				[]byte(conf.Auth.TokenRSAPublicKey),
-			)
-			if err != nil {
-				return nil, fmt.Errorf("parse rsa public key: %w", err)
-			}
-			verifierConf.RSAPublicKey = rsaPublicKey
+	var proxyVerifier *auth.ReloadableVerifier
+	if conf.Proxy.Auth.AuthEnabled() {
+		v, err := auth.NewJWTVerifierFromConfig(conf.Proxy.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
 		}
-		if conf.Auth.TokenECDSAPublicKey != "" {
-			ecdsaPublicKey, err := jwt.ParseECPublicKeyFromPEM(
-				[]byte(conf.Auth.TokenECDSAPublicKey),
-			)
-			if err != nil {
-				return nil, fmt.Errorf("parse ecdsa public key: %w", err)
-			}
-			verifierConf.ECDSAPublicKey = ecdsaPublicKey
+		proxyVerifier = auth.NewReloadableVerifier(v)
+	}
+
+	// Revoked tokens are rejected by both the upstream and proxy verifiers,
+	// and propagated to the rest of the cluster using gossip.
+	revocations := revocation.NewList()
+
+	// The SPIFFE SVID is used for mutual TLS on the upstream listener and
+	// between nodes using RPC. The source rotates the SVID as the Workload
+	// API pushes updates.
+	var spiffeSource *spiffe.Source
+	if conf.SPIFFE.Enabled() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		source, err := spiffe.NewSource(ctx, conf.SPIFFE, logger)
+		if err != nil {
+			return nil, fmt.Errorf("spiffe: %w", err)
 		}
-		verifier = auth.NewJWTVerifier(verifierConf)
+		spiffeSource = source
 	}
 
 	registry := prometheus.NewRegistry()
 
+	// If the node was started by an upgrade, it inherits the listeners of
+	// the parent process rather than binding new listeners.
+	inherited, err := loadInheritedListeners()
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: %w", err)
+	}
+	defer inherited.Close()
+
 	// Proxy listener.
 
-	proxyLn, err := net.Listen("tcp", conf.Proxy.BindAddr)
+	proxyLn, err := inherited.Listen("proxy", func() (net.Listener, error) {
+		return listen(conf.Proxy.BindAddr)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("proxy listen: %s: %w", conf.Proxy.BindAddr, err)
 	}
 	if conf.Proxy.AdvertiseAddr == "" {
-		advertiseAddr, err := advertiseAddrFromBindAddr(proxyLn.Addr().String())
+		advertiseAddr, err := advertiseAddrFromBindAddr(
+			listenAddr(proxyLn),
+			conf.Proxy.AdvertiseInterface,
+			conf.Cluster.AdvertiseIPFamily,
+		)
 		if err != nil {
-			// Should never happen.
-			panic("invalid listen address: " + err.Error())
+			return nil, fmt.Errorf("proxy: advertise addr: %w", err)
 		}
 		conf.Proxy.AdvertiseAddr = advertiseAddr
 	}
 
-	// Upstream listener.
+	// Additional proxy listeners.
 
-	upstreamLn, err := net.Listen("tcp", conf.Upstream.BindAddr)
-	if err != nil {
-		return nil, fmt.Errorf("upstream listen: %s: %w", conf.Upstream.BindAddr, err)
+	var proxyListeners []*proxyListener
+	for i, listenerConf := range conf.Proxy.Listeners {
+		tlsConfig, cert, err := loadTLS(listenerConf.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("proxy listener: %s: tls: %w", listenerConf.BindAddr, err)
+		}
+		ln, err := inherited.Listen(
+			fmt.Sprintf("proxy-%d", i),
+			func() (net.Listener, error) {
+				return listen(listenerConf.BindAddr)
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("proxy listen: %s: %w", listenerConf.BindAddr, err)
+		}
+		proxyListeners = append(proxyListeners, &proxyListener{
+			ln:        ln,
+			tlsConfig: tlsConfig,
+			cert:      cert,
+		})
+	}
+
+	// ACME HTTP-01 challenge listener.
+
+	var acmeHTTPLn net.Listener
+	if conf.Proxy.TLS.ACMEEnabled() && conf.Proxy.TLS.ACMEHTTPBindAddr != "" {
+		acmeHTTPLn, err = inherited.Listen("acme-http", func() (net.Listener, error) {
+			return net.Listen("tcp", conf.Proxy.TLS.ACMEHTTPBindAddr)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("acme http listen: %s: %w", conf.Proxy.TLS.ACMEHTTPBindAddr, err)
+		}
 	}
-	if conf.Upstream.AdvertiseAddr == "" {
-		advertiseAddr, err := advertiseAddrFromBindAddr(upstreamLn.Addr().String())
+
+	// Upstream listener.
+	//
+	// Proxy only nodes never accept upstream connections so don't listen.
+
+	var upstreamLn net.Listener
+	if !conf.Cluster.ProxyOnly() {
+		upstreamLn, err = inherited.Listen("upstream", func() (net.Listener, error) {
+			return net.Listen("tcp", conf.Upstream.BindAddr)
+		})
 		if err != nil {
-			// Should never happen.
-			panic("invalid listen address: " + err.Error())
+			return nil, fmt.Errorf("upstream listen: %s: %w", conf.Upstream.BindAddr, err)
+		}
+		if conf.Upstream.AdvertiseAddr == "" {
+			advertiseAddr, err := advertiseAddrFromBindAddr(
+				upstreamLn.Addr().String(), "", conf.Cluster.AdvertiseIPFamily,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("upstream: advertise addr: %w", err)
+			}
+			conf.Upstream.AdvertiseAddr = advertiseAddr
 		}
-		conf.Upstream.AdvertiseAddr = advertiseAddr
 	}
 
 	// Admin listener.
 
-	adminLn, err := net.Listen("tcp", conf.Admin.BindAddr)
+	adminLn, err := inherited.Listen("admin", func() (net.Listener, error) {
+		return listen(conf.Admin.BindAddr)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("admin listen: %s: %w", conf.Admin.BindAddr, err)
 	}
 	if conf.Admin.AdvertiseAddr == "" {
-		advertiseAddr, err := advertiseAddrFromBindAddr(adminLn.Addr().String())
+		advertiseAddr, err := advertiseAddrFromBindAddr(
+			listenAddr(adminLn),
+			conf.Admin.AdvertiseInterface,
+			conf.Cluster.AdvertiseIPFamily,
+		)
 		if err != nil {
-			// Should never happen.
-			panic("invalid listen address: " + err.Error())
+			return nil, fmt.Errorf("admin: advertise addr: %w", err)
 		}
 		conf.Admin.AdvertiseAddr = advertiseAddr
 	}
 
-	// Gossip listener.
+	// RPC listener.
+	//
+	// The RPC server is optional so only listen if enabled.
 
-	gossipStreamLn, err := net.Listen("tcp", conf.Gossip.BindAddr)
-	if err != nil {
-		return nil, fmt.Errorf("gossip listen: %s: %w", conf.Gossip.BindAddr, err)
+	var rpcLn net.Listener
+	if conf.RPC.Enabled() {
+		rpcLn, err = inherited.Listen("rpc", func() (net.Listener, error) {
+			return net.Listen("tcp", conf.RPC.BindAddr)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("rpc listen: %s: %w", conf.RPC.BindAddr, err)
+		}
+		if conf.RPC.AdvertiseAddr == "" {
+			advertiseAddr, err := advertiseAddrFromBindAddr(
+				rpcLn.Addr().String(), "", conf.Cluster.AdvertiseIPFamily,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("rpc: advertise addr: %w", err)
+			}
+			conf.RPC.AdvertiseAddr = advertiseAddr
+		}
 	}
 
-	gossipPacketLn, err := net.ListenUDP("udp", &net.UDPAddr{
-		IP:   gossipStreamLn.Addr().(*net.TCPAddr).IP,
-		Port: gossipStreamLn.Addr().(*net.TCPAddr).Port,
+	// Gossip listener.
+
+	gossipStreamLn, err := inherited.Listen("gossip-stream", func() (net.Listener, error) {
+		return net.Listen("tcp", conf.Gossip.BindAddr)
 	})
 	if err != nil {
 		return nil, fmt.Errorf("gossip listen: %s: %w", conf.Gossip.BindAddr, err)
 	}
 
 	if conf.Gossip.AdvertiseAddr == "" {
-		advertiseAddr, err := advertiseAddrFromBindAddr(gossipStreamLn.Addr().String())
+		advertiseAddr, err := advertiseAddrFromBindAddr(
+			gossipStreamLn.Addr().String(),
+			conf.Gossip.AdvertiseInterface,
+			conf.Cluster.AdvertiseIPFamily,
+		)
 		if err != nil {
-			// Should never happen.
-			panic("invalid listen address: " + err.Error())
+			return nil, fmt.Errorf("gossip: advertise addr: %w", err)
 		}
 		conf.Gossip.AdvertiseAddr = advertiseAddr
 	}
 
+	// If gossip is TCP only, there is no packet listener.
+	var gossipPacketLn net.PacketConn
+	if !conf.Gossip.TCPOnly {
+		if conf.Gossip.PacketBindAddr == "" {
+			// Default to the same address as the stream listener.
+			gossipPacketLn, err = inherited.ListenPacket("gossip-packet", func() (net.PacketConn, error) {
+				return net.ListenUDP("udp", &net.UDPAddr{
+					IP:   gossipStreamLn.Addr().(*net.TCPAddr).IP,
+					Port: gossipStreamLn.Addr().(*net.TCPAddr).Port,
+					Zone: gossipStreamLn.Addr().(*net.TCPAddr).Zone,
+				})
+			})
+			if err != nil {
+				return nil, fmt.Errorf("gossip listen: %s: %w", conf.Gossip.BindAddr, err)
+			}
+			if conf.Gossip.PacketAdvertiseAddr == "" {
+				conf.Gossip.PacketAdvertiseAddr = conf.Gossip.AdvertiseAddr
+			}
+		} else {
+			gossipPacketLn, err = inherited.ListenPacket("gossip-packet", func() (net.PacketConn, error) {
+				return net.ListenPacket("udp", conf.Gossip.PacketBindAddr)
+			})
+			if err != nil {
+				return nil, fmt.Errorf(
+					"gossip packet listen: %s: %w", conf.Gossip.PacketBindAddr, err,
+				)
+			}
+			if conf.Gossip.PacketAdvertiseAddr == "" {
+				advertiseAddr, err := advertiseAddrFromBindAddr(
+					gossipPacketLn.LocalAddr().String(),
+					conf.Gossip.AdvertiseInterface,
+					conf.Cluster.AdvertiseIPFamily,
+				)
+				if err != nil {
+					return nil, fmt.Errorf("gossip packet: advertise addr: %w", err)
+				}
+				conf.Gossip.PacketAdvertiseAddr = advertiseAddr
+			}
+		}
+	}
+
 	// Cluster.
 
+	buildInfo := build.GetInfo()
 	clusterState := cluster.NewState(&cluster.Node{
 		ID:        conf.Cluster.NodeID,
+		Role:      cluster.NodeRole(conf.Cluster.Role),
+		Version:   buildInfo.Version,
+		Commit:    buildInfo.Commit,
+		BuildDate: buildInfo.Date,
+		GoVersion: buildInfo.GoVersion,
+		Labels:    conf.Cluster.Metadata,
 		ProxyAddr: conf.Proxy.AdvertiseAddr,
 		AdminAddr: conf.Admin.AdvertiseAddr,
+		RPCAddr:   conf.RPC.AdvertiseAddr,
 	}, logger)
 	clusterState.Metrics().Register(registry)
+	clusterState.SetExpectedSize(conf.Cluster.ExpectedSize)
+	clusterState.SetPreferLabels(conf.Cluster.PreferLabels)
 
 	upstreams := upstream.NewLoadBalancedManager(clusterState)
 	upstreams.Metrics().Register(registry)
 
+	upstream.NewRebalancer(upstreams, clusterState, conf.Upstream.Rebalance, logger)
+
+	// Federation.
+
+	var fed *federation.Federation
+	if conf.Federation.Enabled() {
+		fed, err = federation.NewFederation(clusterState, conf.Federation, logger)
+		if err != nil {
+			return nil, fmt.Errorf("federation: %w", err)
+		}
+		fed.Metrics().Register(registry)
+	}
+
 	// Proxy server.
 
-	proxyTLSConfig, err := conf.Proxy.TLS.Load()
-	if err != nil {
-		return nil, fmt.Errorf("proxy tls: %w", err)
+	var proxyTLSConfig *tls.Config
+	var proxyCert *certificate
+	var acmeManager *autocert.Manager
+	if conf.Proxy.TLS.ACMEEnabled() {
+		acmeManager = newACMEManager(conf.Proxy.TLS)
+		proxyTLSConfig = acmeManager.TLSConfig()
+		if err := conf.Proxy.TLS.Configure(proxyTLSConfig); err != nil {
+			return nil, fmt.Errorf("proxy tls: %w", err)
+		}
+	} else {
+		proxyTLSConfig, proxyCert, err = loadTLS(conf.Proxy.TLS.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("proxy tls: %w", err)
+		}
 	}
+	var quotas *quota.Quotas
+	if len(conf.Tenants) != 0 {
+		quotas = quota.NewQuotas(conf.Tenants)
+	}
+
 	proxyServer := proxy.NewServer(
 		upstreams,
 		conf.Proxy,
+		fed,
 		registry,
 		proxyTLSConfig,
 		logger,
 	)
+	if proxyVerifier != nil {
+		var proxyLockout *lockout.Lockout
+		if conf.Proxy.AuthLockout.Enabled() {
+			proxyLockout = lockout.NewLockout(
+				conf.Proxy.AuthLockout, "proxy", logger,
+			)
+			proxyLockout.Metrics().Register(registry)
+		}
+		proxyServer.SetVerifier(
+			revocation.NewVerifier(proxyVerifier, revocations), proxyLockout,
+		)
+	}
+	// Endpoint visibility only applies when proxy clients must authenticate.
+	var visibility *upstream.VisibilityList
+	if proxyVerifier != nil {
+		visibility = upstream.NewVisibilityList()
+		proxyServer.SetVisibility(visibility)
+	}
+	if quotas != nil {
+		proxyServer.SetQuotas(quotas)
+	}
+	if spiffeSource != nil {
+		proxyServer.SetRPCTLSConfig(spiffeSource.ClientTLSConfig())
+	}
+	// Nodes authenticate requests forwarded between nodes using the gossip
+	// join token.
+	proxyServer.SetNodeAuthToken(conf.Gossip.JoinToken)
+
+	// RPC server.
+
+	var rpcServer *proxy.RPCServer
+	if conf.RPC.Enabled() {
+		var rpcTLSConfig *tls.Config
+		if spiffeSource != nil {
+			rpcTLSConfig = spiffeSource.ServerTLSConfig()
+		}
+		rpcServer = proxy.NewRPCServer(
+			proxyServer.Handler(),
+			clusterState,
+			conf.Gossip.JoinToken,
+			rpcTLSConfig,
+			logger,
+		)
+	}
 
 	// Upstream server.
 
-	upstreamTLSConfig, err := conf.Upstream.TLS.Load()
-	if err != nil {
-		return nil, fmt.Errorf("upstream tls: %w", err)
+	bans := upstream.NewBanList()
+	var approvals *upstream.ApprovalList
+	if conf.Upstream.RequireApproval {
+		approvals = upstream.NewApprovalList(conf.Upstream.ApprovedEndpoints)
+	}
+	var upstreamServer *upstream.Server
+	var upstreamCert *certificate
+	if !conf.Cluster.ProxyOnly() {
+		var upstreamTLSConfig *tls.Config
+		if spiffeSource != nil {
+			upstreamTLSConfig = spiffeSource.ServerTLSConfig()
+		} else {
+			upstreamTLSConfig, upstreamCert, err = loadTLS(conf.Upstream.TLS)
+			if err != nil {
+				return nil, fmt.Errorf("upstream tls: %w", err)
+			}
+		}
+		// Note must only pass the verifier if set, to avoid passing a nil
+		// pointer as a non-nil interface.
+		var upstreamVerifier auth.Verifier
+		if verifier != nil {
+			upstreamVerifier = revocation.NewVerifier(verifier, revocations)
+		}
+		upstreamServer = upstream.NewServer(
+			upstreams,
+			bans,
+			upstreamVerifier,
+			upstreamTLSConfig,
+			logger,
+		)
+		upstreamServer.SetMaxConnections(conf.Upstream.MaxConnections)
+		upstreamServer.SetMaxRequestsPerConnection(
+			conf.Upstream.MaxRequestsPerConnection,
+		)
+		upstreamServer.SetHeartbeat(
+			conf.Upstream.HeartbeatInterval, conf.Upstream.HeartbeatTimeout,
+		)
+		upstreamServer.SetApprovals(approvals)
+		if err := upstreamServer.SetTrustedProxies(
+			conf.Upstream.TrustedProxies,
+		); err != nil {
+			return nil, fmt.Errorf("upstream trusted proxies: %w", err)
+		}
+		upstreamServer.SetRevocations(revocations)
+		if quotas != nil {
+			upstreamServer.SetQuotas(quotas)
+		}
+		upstreamServer.Metrics().Register(registry)
 	}
-	upstreamServer := upstream.NewServer(
-		upstreams,
-		verifier,
-		upstreamTLSConfig,
-		logger,
-	)
 
 	// Admin server.
 
-	adminTLSConfig, err := conf.Admin.TLS.Load()
+	adminTLSConfig, adminCert, err := loadTLS(conf.Admin.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("admin tls: %w", err)
+	}
+	adminForwardTLSConfig, err := conf.Admin.TLS.LoadClient()
 	if err != nil {
 		return nil, fmt.Errorf("admin tls: %w", err)
 	}
 	adminServer := admin.NewServer(
 		clusterState,
 		registry,
+		conf.Admin.HTTP,
 		adminTLSConfig,
+		adminForwardTLSConfig,
 		logger,
 	)
+	adminServer.SetNodeAuthToken(conf.Gossip.JoinToken)
+	if conf.Admin.OIDC.Enabled() {
+		var adminLockout *lockout.Lockout
+		if conf.Admin.AuthLockout.Enabled() {
+			adminLockout = lockout.NewLockout(
+				conf.Admin.AuthLockout, "admin", logger,
+			)
+			adminLockout.Metrics().Register(registry)
+		}
+		adminServer.SetOIDC(conf.Admin.OIDC, adminLockout)
+	}
+	adminServer.AddStatus("/proxy", proxy.NewStatus(proxyServer))
 	adminServer.AddStatus("/upstream", upstream.NewStatus(upstreams))
 	adminServer.AddStatus("/cluster", cluster.NewStatus(clusterState))
+	if fed != nil {
+		adminServer.AddStatus("/federation", federation.NewStatus(fed))
+	}
 
 	// Gossip.
 
 	gossiper := gossip.NewGossip(
 		clusterState,
+		revocations,
+		visibility,
+		bans,
+		approvals,
 		gossipStreamLn,
 		gossipPacketLn,
 		&conf.Gossip,
 		logger,
 	)
+	if len(conf.Cluster.JoinDNSServers) > 0 {
+		gossiper.SetResolver(gossip.NewResolver(conf.Cluster.JoinDNSServers))
+	}
 	gossiper.Metrics().Register(registry)
 	adminServer.AddStatus("/gossip", gossip.NewStatus(gossiper))
 
@@ -221,21 +590,68 @@ func NewServer(conf *config.Config, logger log.Logger) (*Server, error) {
 
 	reporter := usage.NewReporter(upstreams.Usage(), logger)
 
-	return &Server{
+	// StatsD.
+
+	var statsdExporter *statsd.Exporter
+	if conf.Metrics.StatsDEnabled() {
+		statsdExporter, err = statsd.NewExporter(
+			registry,
+			conf.Metrics.StatsDAddr,
+			conf.Metrics.StatsDInterval,
+			conf.Metrics.StatsDTags,
+			logger,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("statsd: %w", err)
+		}
+	}
+
+	s := &Server{
 		clusterState:   clusterState,
 		proxyLn:        proxyLn,
 		proxyServer:    proxyServer,
+		proxyListeners: proxyListeners,
+		acmeManager:    acmeManager,
+		acmeHTTPLn:     acmeHTTPLn,
 		upstreamLn:     upstreamLn,
 		upstreamServer: upstreamServer,
+		upstreams:      upstreams,
 		adminLn:        adminLn,
 		adminServer:    adminServer,
+		rpcLn:          rpcLn,
+		rpcServer:      rpcServer,
+		gossipStreamLn: gossipStreamLn,
+		gossipPacketLn: gossipPacketLn,
+		federation:     fed,
 		gossiper:       gossiper,
 		reporter:       reporter,
+		statsdExporter: statsdExporter,
+		verifier:       verifier,
+		proxyVerifier:  proxyVerifier,
+		spiffeSource:   spiffeSource,
+		proxyCert:      proxyCert,
+		upstreamCert:   upstreamCert,
+		adminCert:      adminCert,
 		conf:           conf,
+		inherited:      inherited,
 		closeCh:        make(chan struct{}),
 		shutdownCh:     make(chan struct{}),
 		logger:         logger,
-	}, nil
+	}
+	adminServer.AddHandler("/drain", newDrainHandler(s))
+	adminServer.AddHandler("/config", newConfigHandler(s))
+	adminServer.AddHandler("/reload", newReloadHandler(s))
+	adminServer.AddHandler("/version", newVersionHandler())
+	adminServer.AddHandler("", upstream.NewAdminHandler(
+		upstreams, bans, approvals, visibility,
+	))
+	adminServer.AddHandler("/cluster", cluster.NewAdminHandler(clusterState))
+	adminServer.AddHandler("/tokens", revocation.NewAdminHandler(revocations))
+	if quotas != nil {
+		adminServer.AddHandler("/tenants", quota.NewAdminHandler(quotas))
+	}
+	adminServer.AddHandler("", events.NewHandler(clusterState, upstreams))
+	return s, nil
 }
 
 func (s *Server) Config() *config.Config {
@@ -246,13 +662,63 @@ func (s *Server) ClusterState() *cluster.State {
 	return s.clusterState
 }
 
+// Drain marks the node as draining, so other nodes stop forwarding new
+// requests to the node, then closes the connected upstreams so they reconnect
+// to another node in the cluster.
+//
+// Drain doesn't wait for the upstreams to reconnect. Use DrainStatus to check
+// whether the node is safe to shut down.
+func (s *Server) Drain() {
+	if s.clusterState.LocalDraining() {
+		return
+	}
+
+	s.logger.Info("draining node")
+
+	s.clusterState.SetLocalDraining(true)
+	if s.upstreamServer != nil {
+		s.upstreamServer.Drain()
+	}
+}
+
+// Shutdown requests the server gracefully shuts down. Run returns once the
+// server has shut down.
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdownCh)
+	})
+}
+
+// DrainStatus returns the drain status of the node.
+func (s *Server) DrainStatus() DrainStatus {
+	upstreams := 0
+	for _, n := range s.upstreams.Endpoints() {
+		upstreams += n
+	}
+	// Upstreams are removed once asked to reconnect, though the node isn't
+	// drained until their in-flight requests complete and the connections
+	// close.
+	conns := 0
+	if s.upstreamServer != nil {
+		conns = s.upstreamServer.Conns()
+	}
+	draining := s.clusterState.LocalDraining()
+	return DrainStatus{
+		Draining:  draining,
+		Upstreams: upstreams,
+		Drained:   draining && upstreams == 0 && conns == 0,
+	}
+}
+
 func (s *Server) Run(ctx context.Context) error {
 	s.logger.Info(
 		"starting piko server",
 		zap.String("node-id", s.conf.Cluster.NodeID),
+		zap.String("role", s.conf.Cluster.Role),
 		zap.String("version", build.Version),
+		zap.String("commit", build.GetInfo().Commit),
 	)
-	s.logger.Debug("piko config", zap.Any("config", s.conf))
+	s.logger.Debug("piko config", zap.Any("config", s.conf.Redacted()))
 
 	// Attempt to join an existing cluster.
 	//
@@ -270,6 +736,9 @@ func (s *Server) Run(ctx context.Context) error {
 			zap.Strings("node-ids", nodeIDs),
 		)
 	}
+	if len(nodeIDs) > 0 || len(s.conf.Cluster.Join) == 0 {
+		s.setJoined()
+	}
 
 	var group rungroup.Group
 
@@ -294,26 +763,59 @@ func (s *Server) Run(ctx context.Context) error {
 		s.logger.Info("proxy server shut down")
 	})
 
+	// Additional proxy listeners.
+
+	for _, l := range s.proxyListeners {
+		group.Add(func() error {
+			if err := s.proxyServer.ServeListener(l.ln, l.tlsConfig); err != nil {
+				return fmt.Errorf("proxy listener serve: %w", err)
+			}
+			return nil
+		}, func(error) {
+			// The listener is closed when the proxy server shuts down.
+		})
+	}
+
+	// ACME HTTP-01 challenges.
+
+	if s.acmeHTTPLn != nil {
+		acmeHTTPServer := newACMEHTTPServer(s.acmeManager)
+		group.Add(func() error {
+			s.logger.Info(
+				"starting acme http server",
+				zap.String("addr", s.acmeHTTPLn.Addr().String()),
+			)
+			if err := acmeHTTPServer.Serve(s.acmeHTTPLn); err != nil && err != http.ErrServerClosed {
+				return fmt.Errorf("acme http server serve: %w", err)
+			}
+			return nil
+		}, func(error) {
+			acmeHTTPServer.Close()
+		})
+	}
+
 	// Upstream server.
 
-	group.Add(func() error {
-		if err := s.upstreamServer.Serve(s.upstreamLn); err != nil {
-			return fmt.Errorf("upstream server serve: %w", err)
-		}
-		return nil
-	}, func(error) {
-		shutdownCtx, cancel := context.WithTimeout(
-			context.Background(),
-			s.conf.GracePeriod,
-		)
-		defer cancel()
+	if s.upstreamServer != nil {
+		group.Add(func() error {
+			if err := s.upstreamServer.Serve(s.upstreamLn); err != nil {
+				return fmt.Errorf("upstream server serve: %w", err)
+			}
+			return nil
+		}, func(error) {
+			shutdownCtx, cancel := context.WithTimeout(
+				context.Background(),
+				s.conf.GracePeriod,
+			)
+			defer cancel()
 
-		if err := s.upstreamServer.Shutdown(shutdownCtx); err != nil {
-			s.logger.Warn("failed to gracefully shutdown admin server", zap.Error(err))
-		}
+			if err := s.upstreamServer.Shutdown(shutdownCtx); err != nil {
+				s.logger.Warn("failed to gracefully shutdown admin server", zap.Error(err))
+			}
 
-		s.logger.Info("upstream server shut down")
-	})
+			s.logger.Info("upstream server shut down")
+		})
+	}
 
 	// Admin server.
 
@@ -336,6 +838,60 @@ func (s *Server) Run(ctx context.Context) error {
 		s.logger.Info("admin server shut down")
 	})
 
+	// RPC server.
+
+	if s.rpcServer != nil {
+		group.Add(func() error {
+			if err := s.rpcServer.Serve(s.rpcLn); err != nil {
+				return fmt.Errorf("rpc server serve: %w", err)
+			}
+			return nil
+		}, func(error) {
+			shutdownCtx, cancel := context.WithTimeout(
+				context.Background(),
+				s.conf.GracePeriod,
+			)
+			defer cancel()
+
+			if err := s.rpcServer.Shutdown(shutdownCtx); err != nil {
+				s.logger.Warn("failed to gracefully shutdown rpc server", zap.Error(err))
+			}
+
+			s.logger.Info("rpc server shut down")
+		})
+	}
+
+	// Federation.
+
+	if s.federation != nil {
+		federationCtx, federationCancel := context.WithCancel(context.Background())
+		group.Add(func() error {
+			s.federation.Run(federationCtx)
+			<-federationCtx.Done()
+			return nil
+		}, func(error) {
+			federationCancel()
+		})
+	}
+
+	// TLS certificate watchers.
+
+	// The listeners are unchanged since startup so this can't fail.
+	certs, _ := s.reloadCerts(s.conf)
+	for _, c := range certs {
+		if c.cert == nil || c.conf.WatchInterval == 0 {
+			continue
+		}
+		watcher := newCertWatcher(c, s, s.logger)
+		watchCtx, watchCancel := context.WithCancel(context.Background())
+		group.Add(func() error {
+			watcher.Run(watchCtx)
+			return nil
+		}, func(error) {
+			watchCancel()
+		})
+	}
+
 	// Gossip.
 
 	gossipCtx, gossipCancel := context.WithCancel(context.Background())
@@ -355,8 +911,18 @@ func (s *Server) Run(ctx context.Context) error {
 				)
 			}
 		}
-
-		<-gossipCtx.Done()
+		// Even if the node failed to join it continues serving as its own
+		// cluster, so is considered ready.
+		s.setJoined()
+
+		if s.conf.Cluster.JoinInterval > 0 && len(s.conf.Cluster.Join) > 0 {
+			// Blocks until the context is cancelled.
+			s.gossiper.JoinPeriodically(
+				gossipCtx, s.conf.Cluster.Join, s.conf.Cluster.JoinInterval,
+			)
+		} else {
+			<-gossipCtx.Done()
+		}
 
 		leaveCtx, cancel := context.WithTimeout(
 			context.Background(),
@@ -366,10 +932,12 @@ func (s *Server) Run(ctx context.Context) error {
 
 		// Leave as soon as we receive the shutdown signal to avoid receiving
 		// forward proxy requests.
-		if err := s.gossiper.Leave(leaveCtx); err != nil {
-			s.logger.Warn("failed to gracefully leave cluster", zap.Error(err))
-		} else {
-			s.logger.Info("left cluster")
+		if !s.left.Load() {
+			if err := s.gossiper.Leave(leaveCtx); err != nil {
+				s.logger.Warn("failed to gracefully leave cluster", zap.Error(err))
+			} else {
+				s.logger.Info("left cluster")
+			}
 		}
 
 		s.gossiper.Close()
@@ -391,6 +959,32 @@ func (s *Server) Run(ctx context.Context) error {
 		})
 	}
 
+	// StatsD.
+
+	if s.statsdExporter != nil {
+		statsdCtx, statsdCancel := context.WithCancel(context.Background())
+		group.Add(func() error {
+			s.statsdExporter.Run(statsdCtx)
+			s.statsdExporter.Close()
+			return nil
+		}, func(error) {
+			statsdCancel()
+		})
+	}
+
+	// SPIFFE.
+
+	if s.spiffeSource != nil {
+		spiffeCtx, spiffeCancel := context.WithCancel(context.Background())
+		group.Add(func() error {
+			<-spiffeCtx.Done()
+			s.spiffeSource.Close()
+			return nil
+		}, func(error) {
+			spiffeCancel()
+		})
+	}
+
 	// Shutdown handler.
 
 	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
@@ -400,9 +994,19 @@ func (s *Server) Run(ctx context.Context) error {
 			// On shutdown just exit the function and rungroup will shutdown
 			// the remaining modules.
 			s.logger.Info("received shutdown signal")
+		case <-s.shutdownCh:
+			s.logger.Info("received shutdown request")
 		case <-shutdownCtx.Done():
 		}
 
+		// If the node was upgraded, the new process is now the main process
+		// so the service isn't stopping.
+		if !s.upgrading.Load() {
+			if err := sdnotify.Notify(sdnotify.Stopping); err != nil {
+				s.logger.Warn("failed to notify systemd", zap.Error(err))
+			}
+		}
+
 		return nil
 	}, func(error) {
 		shutdownCancel()
@@ -411,25 +1015,25 @@ func (s *Server) Run(ctx context.Context) error {
 	return group.Run()
 }
 
-func advertiseAddrFromBindAddr(bindAddr string) (string, error) {
-	if strings.HasPrefix(bindAddr, ":") {
-		bindAddr = "0.0.0.0" + bindAddr
-	}
-
-	host, port, err := net.SplitHostPort(bindAddr)
-	if err != nil {
-		return "", fmt.Errorf("invalid bind addr: %s: %w", bindAddr, err)
-	}
-
-	if host == "0.0.0.0" || host == "::" {
-		ip, err := sockaddr.GetPrivateIP()
-		if err != nil {
-			return "", fmt.Errorf("get interface addr: %w", err)
+// setJoined marks the node as having joined the cluster (or failed to join
+// and continuing as its own cluster). Since the listeners are already bound,
+// the node is ready so notifies systemd.
+func (s *Server) setJoined() {
+	s.adminServer.SetJoined()
+
+	s.readyOnce.Do(func() {
+		state := sdnotify.Ready
+		if s.inherited.Upgraded() {
+			// Tell systemd this process replaces the parent process as the
+			// main process of the service.
+			state = fmt.Sprintf("MAINPID=%d\n%s", os.Getpid(), sdnotify.Ready)
 		}
-		if ip == "" {
-			return "", fmt.Errorf("no private ip found")
+		if err := sdnotify.Notify(state); err != nil {
+			s.logger.Warn("failed to notify systemd", zap.Error(err))
 		}
-		return ip + ":" + port, nil
-	}
-	return bindAddr, nil
+
+		if err := s.inherited.NotifyReady(); err != nil {
+			s.logger.Warn("failed to notify upgrade parent process", zap.Error(err))
+		}
+	})
 }
@@ -1,10 +1,15 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/user"
 	fspath "path"
 	"time"
 )
@@ -14,47 +19,132 @@ type Client struct {
 
 	url *url.URL
 
+	// socketPath is the path of the servers admin Unix domain socket, if the
+	// URL uses the 'unix' scheme, such as 'unix:///run/piko/admin.sock'.
+	socketPath string
+
+	tlsConfig *tls.Config
+
 	forward string
+
+	// token is an ID token to authenticate with the server.
+	token string
+
+	// caller identifies the user of the client in the server audit log.
+	caller string
 }
 
 func NewClient(url *url.URL) *Client {
-	return &Client{
+	c := &Client{
 		httpClient: &http.Client{
 			Timeout: time.Second * 15,
 		},
-		url: url,
+		caller: localCaller(),
 	}
+	c.SetURL(url)
+	return c
+}
+
+// SetURL sets the server admin URL. The URL may use the 'unix' scheme to
+// connect to an admin Unix domain socket, such as
+// 'unix:///run/piko/admin.sock'.
+func (c *Client) SetURL(u *url.URL) {
+	c.url = u
+	c.socketPath = ""
+	if u != nil && u.Scheme == "unix" {
+		c.socketPath = u.Path
+		// Requests are sent over the socket so the host is ignored.
+		c.url = &url.URL{Scheme: "http", Host: "piko"}
+	}
+	c.updateTransport()
+}
+
+// SetTLSConfig sets the TLS configuration used to connect to the server.
+func (c *Client) SetTLSConfig(tlsConfig *tls.Config) {
+	c.tlsConfig = tlsConfig
+	c.updateTransport()
 }
 
-func (c *Client) SetURL(url *url.URL) {
-	c.url = url
+// SetTimeout sets the timeout for requests to the server.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.httpClient.Timeout = timeout
 }
 
 func (c *Client) SetForward(forward string) {
 	c.forward = forward
 }
 
+// SetToken sets the ID token to authenticate with the server.
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
 func (c *Client) Request(path string) (io.ReadCloser, error) {
+	return c.Do(http.MethodGet, path)
+}
+
+// Do sends a request with the given method to the given path, and returns the
+// response body.
+//
+// Returns an error if the response doesn't have a 2xx status code.
+func (c *Client) Do(method string, path string) (io.ReadCloser, error) {
+	return c.DoWithQuery(method, path, nil)
+}
+
+// DoWithQuery sends a request like Do, with the given query parameters.
+func (c *Client) DoWithQuery(
+	method string,
+	path string,
+	query url.Values,
+) (io.ReadCloser, error) {
+	return c.do(method, path, query, nil)
+}
+
+// DoWithBody sends a request like Do, with the given request body.
+func (c *Client) DoWithBody(
+	method string,
+	path string,
+	body io.Reader,
+) (io.ReadCloser, error) {
+	return c.do(method, path, nil, body)
+}
+
+func (c *Client) do(
+	method string,
+	path string,
+	query url.Values,
+	body io.Reader,
+) (io.ReadCloser, error) {
 	url := new(url.URL)
 	*url = *c.url
 
 	if c.forward != "" {
-		url.RawQuery = "forward=" + c.forward
+		if query == nil {
+			query = make(map[string][]string)
+		}
+		query.Set("forward", c.forward)
 	}
+	url.RawQuery = query.Encode()
 
 	url.Path = fspath.Join(url.Path, path)
 
-	req, err := http.NewRequest(http.MethodGet, url.String(), nil)
+	req, err := http.NewRequest(method, url.String(), body)
 	if err != nil {
 		return nil, fmt.Errorf("request: %w", err)
 	}
+	if c.caller != "" {
+		req.Header.Set("x-piko-caller", c.caller)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("request: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		defer resp.Body.Close()
 
 		return nil, fmt.Errorf("request: bad status: %d", resp.StatusCode)
@@ -62,3 +152,35 @@ func (c *Client) Request(path string) (io.ReadCloser, error) {
 
 	return resp.Body, nil
 }
+
+func (c *Client) updateTransport() {
+	if c.tlsConfig == nil && c.socketPath == "" {
+		c.httpClient.Transport = nil
+		return
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = c.tlsConfig
+	if c.socketPath != "" {
+		socketPath := c.socketPath
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+	}
+	c.httpClient.Transport = transport
+}
+
+// localCaller returns the local user and host, such as 'alice@laptop', used
+// to identify the caller in the server audit log.
+func localCaller() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return u.Username
+	}
+	return u.Username + "@" + hostname
+}
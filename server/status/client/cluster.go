@@ -31,6 +31,21 @@ func (c *Cluster) Nodes() ([]*cluster.NodeMetadata, error) {
 	return nodes, nil
 }
 
+// Netmap returns the known state of each node in the cluster.
+func (c *Cluster) Netmap() (*cluster.Netmap, error) {
+	r, err := c.client.Request("/cluster/nodes")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var netmap cluster.Netmap
+	if err := json.NewDecoder(r).Decode(&netmap); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &netmap, nil
+}
+
 func (c *Cluster) Node(nodeID string) (*cluster.Node, error) {
 	r, err := c.client.Request("/status/cluster/nodes/" + nodeID)
 	if err != nil {
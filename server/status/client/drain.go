@@ -0,0 +1,53 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/andydunstall/piko/server"
+)
+
+type Drain struct {
+	client *Client
+}
+
+func NewDrain(client *Client) *Drain {
+	return &Drain{
+		client: client,
+	}
+}
+
+// Drain starts draining the node.
+func (c *Drain) Drain() (*server.DrainStatus, error) {
+	return c.request(http.MethodPost)
+}
+
+// Status returns the drain status of the node.
+func (c *Drain) Status() (*server.DrainStatus, error) {
+	return c.request(http.MethodGet)
+}
+
+// Shutdown shuts down the node. The node must be drained first.
+func (c *Drain) Shutdown() error {
+	r, err := c.client.Do(http.MethodPost, "/drain/shutdown")
+	if err != nil {
+		return err
+	}
+	r.Close()
+	return nil
+}
+
+func (c *Drain) request(method string) (*server.DrainStatus, error) {
+	r, err := c.client.Do(method, "/drain")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var status server.DrainStatus
+	if err := json.NewDecoder(r).Decode(&status); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &status, nil
+}
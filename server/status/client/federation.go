@@ -0,0 +1,32 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/andydunstall/piko/server/federation"
+)
+
+type Federation struct {
+	client *Client
+}
+
+func NewFederation(client *Client) *Federation {
+	return &Federation{
+		client: client,
+	}
+}
+
+func (c *Federation) Clusters() ([]federation.ClusterStatus, error) {
+	r, err := c.client.Request("/status/federation/clusters")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var clusters []federation.ClusterStatus
+	if err := json.NewDecoder(r).Decode(&clusters); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return clusters, nil
+}
@@ -44,3 +44,31 @@ func (c *Gossip) Node(nodeID string) (*gossip.NodeState, error) {
 	}
 	return &node, nil
 }
+
+func (c *Gossip) Sync() (*gossip.SyncStats, error) {
+	r, err := c.client.Request("/status/gossip/sync")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var stats gossip.SyncStats
+	if err := json.NewDecoder(r).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &stats, nil
+}
+
+func (c *Gossip) AuthFailures() ([]gossip.AuthFailure, error) {
+	r, err := c.client.Request("/status/gossip/auth-failures")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var failures []gossip.AuthFailure
+	if err := json.NewDecoder(r).Decode(&failures); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return failures, nil
+}
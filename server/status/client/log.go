@@ -0,0 +1,57 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/admin"
+)
+
+type Log struct {
+	client *Client
+}
+
+func NewLog(client *Client) *Log {
+	return &Log{
+		client: client,
+	}
+}
+
+// Config returns the log configuration of the node.
+func (c *Log) Config() (*log.Config, error) {
+	r, err := c.client.Request("/log")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var conf log.Config
+	if err := json.NewDecoder(r).Decode(&conf); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &conf, nil
+}
+
+// Update updates the log configuration of the node, and returns the updated
+// configuration.
+func (c *Log) Update(update admin.LogUpdate) (*log.Config, error) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+
+	r, err := c.client.DoWithBody(http.MethodPut, "/log", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var conf log.Config
+	if err := json.NewDecoder(r).Decode(&conf); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &conf, nil
+}
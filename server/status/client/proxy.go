@@ -0,0 +1,46 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/andydunstall/piko/server/proxy"
+)
+
+type Proxy struct {
+	client *Client
+}
+
+func NewProxy(client *Client) *Proxy {
+	return &Proxy{
+		client: client,
+	}
+}
+
+func (c *Proxy) Endpoints() ([]proxy.EndpointStats, error) {
+	r, err := c.client.Request("/status/proxy/endpoints")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var endpoints []proxy.EndpointStats
+	if err := json.NewDecoder(r).Decode(&endpoints); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return endpoints, nil
+}
+
+func (c *Proxy) Errors() ([]proxy.RequestError, error) {
+	r, err := c.client.Request("/status/proxy/errors")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var errors []proxy.RequestError
+	if err := json.NewDecoder(r).Decode(&errors); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return errors, nil
+}
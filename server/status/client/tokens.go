@@ -0,0 +1,53 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/andydunstall/piko/server/revocation"
+)
+
+type Tokens struct {
+	client *Client
+}
+
+func NewTokens(client *Client) *Tokens {
+	return &Tokens{
+		client: client,
+	}
+}
+
+// Revocations returns the revoked tokens known by the node.
+func (c *Tokens) Revocations() ([]revocation.Revocation, error) {
+	r, err := c.client.Request("/tokens/revocations")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var revocations []revocation.Revocation
+	if err := json.NewDecoder(r).Decode(&revocations); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return revocations, nil
+}
+
+// Revoke revokes the token, which the node propagates to the rest of the
+// cluster.
+func (c *Tokens) Revoke(r revocation.Revocation) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("encode request: %w", err)
+	}
+
+	resp, err := c.client.DoWithBody(
+		http.MethodPost, "/tokens/revocations", bytes.NewReader(b),
+	)
+	if err != nil {
+		return err
+	}
+	resp.Close()
+	return nil
+}
@@ -1,8 +1,14 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/andydunstall/piko/server/upstream"
 )
 
 type Upstream struct {
@@ -28,3 +34,152 @@ func (c *Upstream) Endpoints() (map[string]int, error) {
 	}
 	return endpoints, nil
 }
+
+// Conns returns the upstreams connected to the node.
+func (c *Upstream) Conns() ([]upstream.ConnInfo, error) {
+	r, err := c.client.Request("/upstreams")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var conns []upstream.ConnInfo
+	if err := json.NewDecoder(r).Decode(&conns); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return conns, nil
+}
+
+// CloseConn closes the upstream connected to the node with the given
+// connection ID. If ban is non-zero, the upstream is also banned from
+// reconnecting to the node for the given duration.
+func (c *Upstream) CloseConn(id string, ban time.Duration) (upstream.ConnInfo, error) {
+	query := make(url.Values)
+	if ban != 0 {
+		query.Set("ban", ban.String())
+	}
+	r, err := c.client.DoWithQuery(http.MethodDelete, "/upstreams/"+id, query)
+	if err != nil {
+		return upstream.ConnInfo{}, err
+	}
+	defer r.Close()
+
+	var conn upstream.ConnInfo
+	if err := json.NewDecoder(r).Decode(&conn); err != nil {
+		return upstream.ConnInfo{}, fmt.Errorf("decode response: %w", err)
+	}
+	return conn, nil
+}
+
+// Bans returns the upstreams banned from connecting to the node.
+func (c *Upstream) Bans() ([]upstream.Ban, error) {
+	r, err := c.client.Request("/upstreams/bans")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var bans []upstream.Ban
+	if err := json.NewDecoder(r).Decode(&bans); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return bans, nil
+}
+
+// ClusterEndpoints returns the endpoints with upstreams connected to the
+// cluster.
+func (c *Upstream) ClusterEndpoints() ([]upstream.EndpointInfo, error) {
+	r, err := c.client.Request("/endpoints")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var endpoints []upstream.EndpointInfo
+	if err := json.NewDecoder(r).Decode(&endpoints); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return endpoints, nil
+}
+
+// Approvals returns the endpoints approved on the node, and the endpoints
+// waiting for approval.
+func (c *Upstream) Approvals() (upstream.Approvals, error) {
+	return c.approvalsRequest(http.MethodGet, "/endpoints/approvals")
+}
+
+// Approve approves the endpoint to receive traffic on the node.
+func (c *Upstream) Approve(endpointID string) (upstream.Approvals, error) {
+	return c.approvalsRequest(
+		http.MethodPost, "/endpoints/approvals/"+endpointID,
+	)
+}
+
+// Revoke revokes the endpoints approval on the node.
+func (c *Upstream) Revoke(endpointID string) (upstream.Approvals, error) {
+	return c.approvalsRequest(
+		http.MethodDelete, "/endpoints/approvals/"+endpointID,
+	)
+}
+
+func (c *Upstream) approvalsRequest(method string, path string) (upstream.Approvals, error) {
+	r, err := c.client.Do(method, path)
+	if err != nil {
+		return upstream.Approvals{}, err
+	}
+	defer r.Close()
+
+	var approvals upstream.Approvals
+	if err := json.NewDecoder(r).Decode(&approvals); err != nil {
+		return upstream.Approvals{}, fmt.Errorf("decode response: %w", err)
+	}
+	return approvals, nil
+}
+
+// Visibility returns the visibility of endpoints set on the node.
+func (c *Upstream) Visibility() ([]upstream.EndpointVisibility, error) {
+	r, err := c.client.Request("/endpoints/visibility")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var visibility []upstream.EndpointVisibility
+	if err := json.NewDecoder(r).Decode(&visibility); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return visibility, nil
+}
+
+// SetVisibility sets the visibility of the endpoint on the node, either
+// 'public' or 'private'.
+func (c *Upstream) SetVisibility(endpointID string, visibility string) error {
+	b, err := json.Marshal(upstream.VisibilityUpdate{
+		Visibility: visibility,
+	})
+	if err != nil {
+		return fmt.Errorf("encode request: %w", err)
+	}
+
+	r, err := c.client.DoWithBody(
+		http.MethodPut, "/endpoints/visibility/"+endpointID, bytes.NewReader(b),
+	)
+	if err != nil {
+		return err
+	}
+	r.Close()
+	return nil
+}
+
+// UnsetVisibility removes the visibility of the endpoint set on the node, so
+// the configured visibility is used.
+func (c *Upstream) UnsetVisibility(endpointID string) error {
+	r, err := c.client.Do(
+		http.MethodDelete, "/endpoints/visibility/"+endpointID,
+	)
+	if err != nil {
+		return err
+	}
+	r.Close()
+	return nil
+}
@@ -1,15 +1,53 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/url"
+	"os"
 
 	"github.com/spf13/pflag"
 )
 
+type TLSConfig struct {
+	// RootCAs contains a path to root certificate authorities to validate
+	// the TLS connection to the Piko server admin port.
+	//
+	// Defaults to using the host root CAs.
+	RootCAs string `json:"root_cas"`
+}
+
+func (c *TLSConfig) Load() (*tls.Config, error) {
+	if c.RootCAs == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	caCert, err := os.ReadFile(c.RootCAs)
+	if err != nil {
+		return nil, fmt.Errorf("open root cas: %s: %w", c.RootCAs, err)
+	}
+	caCertPool := x509.NewCertPool()
+	ok := caCertPool.AppendCertsFromPEM(caCert)
+	if !ok {
+		return nil, fmt.Errorf("parse root cas: %s", c.RootCAs)
+	}
+	tlsConfig.RootCAs = caCertPool
+
+	return tlsConfig, nil
+}
+
 type ServerConfig struct {
 	// URL is the server URL.
 	URL string `json:"url"`
+
+	// Token is an ID token to authenticate with the server admin API, when
+	// the server uses OIDC authentication.
+	Token string `json:"token"`
+
+	TLS TLSConfig `json:"tls"`
 }
 
 func (c *ServerConfig) Validate() error {
@@ -42,6 +80,33 @@ func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
 		"http://localhost:8002",
 		`
 Piko server URL. This URL should point to the server admin port.
+
+If the server admin port listens on a Unix domain socket, use a 'unix://'
+URL with the socket path, such as 'unix:///run/piko/admin.sock'.
+`,
+	)
+
+	fs.StringVar(
+		&c.Server.Token,
+		"server.token",
+		"",
+		`
+ID token to authenticate with the Piko server admin API, when the server is
+configured to authenticate admin users with OIDC. The token must be issued by
+the server's OIDC provider for the server's client ID.
+`,
+	)
+
+	fs.StringVar(
+		&c.Server.TLS.RootCAs,
+		"server.tls.root-cas",
+		"",
+		`
+A path to a certificate PEM file containing root certificiate authorities to
+validate the TLS connection to the Piko server admin port, when the server
+URL uses 'https'.
+
+Defaults to using the host root CAs.
 `,
 	)
 
@@ -0,0 +1,73 @@
+package status
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// streamBufferSize is the maximum number of events buffered for each
+	// stream before the stream is closed.
+	streamBufferSize = 1024
+)
+
+type streamEvent struct {
+	name string
+	data any
+}
+
+// Stream streams events to a client as server-sent events.
+//
+// Events are published by subscribers that must not block, so events are
+// buffered until written to the client. If the client can't keep up with the
+// events, the stream is closed rather than skipping events.
+type Stream struct {
+	events chan streamEvent
+
+	// overflowCh is closed if the buffer is full.
+	overflowCh   chan struct{}
+	overflowOnce sync.Once
+}
+
+func NewStream() *Stream {
+	return &Stream{
+		events:     make(chan streamEvent, streamBufferSize),
+		overflowCh: make(chan struct{}),
+	}
+}
+
+// Publish adds an event with the given name and data to the stream, where
+// the data is JSON encoded. This never blocks.
+func (s *Stream) Publish(name string, data any) {
+	select {
+	case s.events <- streamEvent{name: name, data: data}:
+	default:
+		s.overflowOnce.Do(func() {
+			close(s.overflowCh)
+		})
+	}
+}
+
+// Serve writes published events to the client until the client disconnects
+// or the stream overflows.
+func (s *Stream) Serve(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	c.Stream(func(_ io.Writer) bool {
+		select {
+		case event := <-s.events:
+			c.SSEvent(event.name, event.data)
+			return true
+		case <-s.overflowCh:
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
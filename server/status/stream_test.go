@@ -0,0 +1,68 @@
+package status
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	t.Run("events", func(t *testing.T) {
+		stream := NewStream()
+
+		resp := testServeStream(t, stream)
+		defer resp.Body.Close()
+
+		stream.Publish("foo", map[string]int{"a": 1})
+		stream.Publish("bar", map[string]int{"b": 2})
+
+		r := bufio.NewReader(resp.Body)
+		for _, expected := range []string{
+			"event:foo", `data:{"a":1}`, "",
+			"event:bar", `data:{"b":2}`, "",
+		} {
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, expected, strings.TrimSpace(line))
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		stream := NewStream()
+		for i := 0; i != streamBufferSize+1; i++ {
+			stream.Publish("foo", i)
+		}
+
+		resp := testServeStream(t, stream)
+		defer resp.Body.Close()
+
+		// The stream must close rather than skipping events, so not all
+		// published events are written.
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Less(t, strings.Count(string(b), "event:foo"), streamBufferSize+1)
+	})
+}
+
+// testServeStream serves the stream and returns the response.
+func testServeStream(t *testing.T, stream *Stream) *http.Response {
+	router := gin.New()
+	router.GET("/", stream.Serve)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return resp
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// upgradeListenersEnv is the environment variable containing the
+	// comma separated names of the listeners inherited from the parent
+	// process, in the order of the inherited file descriptors (starting from
+	// 3).
+	upgradeListenersEnv = "PIKO_UPGRADE_LISTENERS"
+
+	// upgradeReadyEnv is the environment variable containing the file
+	// descriptor the new process writes to once ready.
+	upgradeReadyEnv = "PIKO_UPGRADE_READY_FD"
+)
+
+// inheritedListeners contains the listeners inherited from the parent process
+// during an upgrade.
+//
+// If the process wasn't started by an upgrade, there are no inherited
+// listeners so the node binds new listeners as usual.
+type inheritedListeners struct {
+	files map[string]*os.File
+
+	// ready is written to once the node is ready, to tell the parent process
+	// to shut down.
+	ready *os.File
+}
+
+// loadInheritedListeners loads the listeners inherited from the parent
+// process, if any.
+func loadInheritedListeners() (*inheritedListeners, error) {
+	inherited := &inheritedListeners{
+		files: make(map[string]*os.File),
+	}
+
+	names := os.Getenv(upgradeListenersEnv)
+	readyFD := os.Getenv(upgradeReadyEnv)
+	// Unset the variables so they aren't passed on to future upgrades.
+	os.Unsetenv(upgradeListenersEnv)
+	os.Unsetenv(upgradeReadyEnv)
+
+	if names == "" && readyFD == "" {
+		return inherited, nil
+	}
+
+	if names != "" {
+		for i, name := range strings.Split(names, ",") {
+			inherited.files[name] = os.NewFile(uintptr(3+i), name)
+		}
+	}
+
+	fd, err := strconv.Atoi(readyFD)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ready fd: %s", readyFD)
+	}
+	inherited.ready = os.NewFile(uintptr(fd), "ready")
+
+	return inherited, nil
+}
+
+// Upgraded returns whether the process was started by an upgrade.
+func (l *inheritedListeners) Upgraded() bool {
+	return l.ready != nil
+}
+
+// Listen returns the inherited listener with the given name, or if there is
+// no inherited listener, uses listenFunc to create a new listener.
+func (l *inheritedListeners) Listen(
+	name string,
+	listenFunc func() (net.Listener, error),
+) (net.Listener, error) {
+	f, ok := l.files[name]
+	if !ok {
+		return listenFunc()
+	}
+	delete(l.files, name)
+
+	// FileListener duplicates the file descriptor so close the original.
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited listener: %w", err)
+	}
+	return ln, nil
+}
+
+// ListenPacket returns the inherited packet listener with the given name, or
+// if there is no inherited listener, uses listenFunc to create a new
+// listener.
+func (l *inheritedListeners) ListenPacket(
+	name string,
+	listenFunc func() (net.PacketConn, error),
+) (net.PacketConn, error) {
+	f, ok := l.files[name]
+	if !ok {
+		return listenFunc()
+	}
+	delete(l.files, name)
+
+	defer f.Close()
+
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited packet listener: %w", err)
+	}
+	return conn, nil
+}
+
+// Close closes any inherited listeners that weren't used, such as if the
+// configuration changed to disable a listener.
+func (l *inheritedListeners) Close() {
+	for name, f := range l.files {
+		f.Close()
+		delete(l.files, name)
+	}
+}
+
+// NotifyReady tells the parent process the node is ready.
+func (l *inheritedListeners) NotifyReady() error {
+	if l.ready == nil {
+		return nil
+	}
+	defer l.ready.Close()
+
+	if _, err := l.ready.Write([]byte{1}); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+// Upgrade starts a new server process, using the same executable and
+// arguments, which inherits the node's listeners.
+//
+// Since the new process inherits the gossip listeners, it has the same gossip
+// address as this node. So two nodes don't share an address, this node leaves
+// the cluster before starting the new process.
+//
+// Once the new process has joined the cluster, this node stops accepting
+// connections and gracefully shuts down. Since the listeners are shared, new
+// connections are accepted by the new process while this node waits for
+// in-progress requests to complete. Connected upstreams are closed once
+// the proxy server has shut down, so they reconnect to the new process.
+//
+// If the new process fails after this node has left the cluster, this node
+// can't rejoin, so shuts down rather than serving outside the cluster.
+//
+// Note the new process is a new node in the cluster, so the node ID must
+// not be static.
+func (s *Server) Upgrade(ctx context.Context) error {
+	if !s.upgrading.CompareAndSwap(false, true) {
+		return fmt.Errorf("upgrade already in progress")
+	}
+
+	err := s.upgrade(ctx)
+	if err != nil {
+		s.upgrading.Store(false)
+		if s.left.Load() {
+			s.logger.Error(
+				"upgrade failed after leaving cluster; shutting down",
+				zap.Error(err),
+			)
+			s.Shutdown()
+		}
+		return err
+	}
+
+	s.logger.Info("upgrade complete; shutting down")
+
+	// Unix sockets must not be removed on close as the new process is
+	// still listening.
+	listeners := []net.Listener{s.proxyLn, s.adminLn}
+	for _, l := range s.proxyListeners {
+		listeners = append(listeners, l.ln)
+	}
+	for _, ln := range listeners {
+		if unixLn, ok := ln.(*net.UnixListener); ok {
+			unixLn.SetUnlinkOnClose(false)
+		}
+	}
+
+	s.Shutdown()
+
+	return nil
+}
+
+func (s *Server) upgrade(ctx context.Context) error {
+	names, files, err := s.listenerFiles()
+	// The files are duplicates of the listeners, so must always be closed
+	// once passed to the new process.
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("executable: %w", err)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	s.leaveCluster(ctx)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(
+		os.Environ(),
+		upgradeListenersEnv+"="+strings.Join(names, ","),
+		upgradeReadyEnv+"="+strconv.Itoa(3+len(files)),
+	)
+
+	err = cmd.Start()
+	// Close the parent's write end so reading returns EOF if the new process
+	// exits.
+	readyW.Close()
+	if err != nil {
+		return fmt.Errorf("start process: %w", err)
+	}
+
+	s.logger.Info(
+		"started upgrade process; waiting for process to be ready",
+		zap.Int("pid", cmd.Process.Pid),
+	)
+
+	// Reap the process if it exits.
+	go func() {
+		_ = cmd.Wait()
+	}()
+
+	readyCh := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		_, err := readyR.Read(b)
+		readyCh <- err
+	}()
+
+	select {
+	case err := <-readyCh:
+		if err != nil {
+			return fmt.Errorf("process exited before ready")
+		}
+		return nil
+	case <-ctx.Done():
+		if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			s.logger.Warn("failed to kill upgrade process", zap.Error(err))
+		}
+		return fmt.Errorf("process not ready: %w", ctx.Err())
+	}
+}
+
+// leaveCluster leaves the cluster and stops gossiping, so the new process can
+// join the cluster with the inherited gossip listeners.
+//
+// The gossip listeners are closed, though since the new process inherits
+// duplicates of the listeners, the sockets stay open.
+func (s *Server) leaveCluster(ctx context.Context) {
+	// Mark the node as draining so other nodes stop forwarding requests to
+	// this node.
+	s.clusterState.SetLocalDraining(true)
+
+	if err := s.gossiper.Leave(ctx); err != nil {
+		s.logger.Warn("failed to gracefully leave cluster", zap.Error(err))
+	} else {
+		s.logger.Info("left cluster")
+	}
+	s.gossiper.Close()
+
+	s.left.Store(true)
+}
+
+// listenerFiles returns duplicates of the node's listener file descriptors,
+// along with the name of each listener.
+func (s *Server) listenerFiles() ([]string, []*os.File, error) {
+	type filer interface {
+		File() (*os.File, error)
+	}
+
+	listeners := []struct {
+		name string
+		ln   any
+	}{
+		{"proxy", s.proxyLn},
+		{"upstream", s.upstreamLn},
+		{"admin", s.adminLn},
+		{"rpc", s.rpcLn},
+		{"gossip-stream", s.gossipStreamLn},
+		{"gossip-packet", s.gossipPacketLn},
+		{"acme-http", s.acmeHTTPLn},
+	}
+	for i, l := range s.proxyListeners {
+		listeners = append(listeners, struct {
+			name string
+			ln   any
+		}{fmt.Sprintf("proxy-%d", i), l.ln})
+	}
+
+	var names []string
+	var files []*os.File
+	for _, l := range listeners {
+		ln, ok := l.ln.(filer)
+		if !ok {
+			// Either the listener is disabled or doesn't support passing
+			// to a new process, in which case the new process binds its
+			// own listener.
+			continue
+		}
+		f, err := ln.File()
+		if err != nil {
+			return names, files, fmt.Errorf("%s listener: %w", l.name, err)
+		}
+		names = append(names, l.name)
+		files = append(files, f)
+	}
+	return names, files, nil
+}
@@ -0,0 +1,326 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andydunstall/piko/server/status"
+	"github.com/gin-gonic/gin"
+)
+
+// ConnInfo describes an upstream connected to the local node.
+type ConnInfo struct {
+	// ID is the identifier of the connection on the local node.
+	ID string `json:"id"`
+
+	// EndpointID is the endpoint ID, scoped to the tenant if the upstream
+	// registered with a tenant token.
+	EndpointID string `json:"endpoint_id"`
+
+	// Tenant is the tenant the endpoint belongs to, if any.
+	Tenant string `json:"tenant,omitempty"`
+
+	// NodeID is the ID of the node the upstream is connected to.
+	NodeID string `json:"node_id"`
+
+	ClientIP string `json:"client_ip"`
+
+	ConnectedAt time.Time `json:"connected_at"`
+
+	// Version is the tunnel protocol version negotiated with the upstream.
+	Version int `json:"version"`
+
+	// AgeSeconds is the number of seconds since the upstream connected.
+	AgeSeconds int64 `json:"age_seconds"`
+}
+
+// EndpointNode describes the upstreams for an endpoint connected to a node.
+type EndpointNode struct {
+	NodeID string `json:"node_id"`
+
+	// Connections is the number of upstreams for the endpoint connected to
+	// the node.
+	Connections int `json:"connections"`
+}
+
+// EndpointInfo describes an endpoint with upstreams connected to the
+// cluster.
+type EndpointInfo struct {
+	// EndpointID is the endpoint ID, scoped to the tenant if the endpoint
+	// belongs to a tenant.
+	EndpointID string `json:"endpoint_id"`
+
+	// Tenant is the tenant the endpoint belongs to, if any.
+	Tenant string `json:"tenant,omitempty"`
+
+	// Connections is the total number of upstreams for the endpoint
+	// connected to the cluster.
+	Connections int `json:"connections"`
+
+	// Nodes contains the nodes with upstreams for the endpoint.
+	Nodes []EndpointNode `json:"nodes"`
+}
+
+// AdminHandler registers the admin routes to inspect and manage the
+// connected upstreams and endpoints.
+type AdminHandler struct {
+	manager *LoadBalancedManager
+
+	bans *BanList
+
+	// approvals contains the approved endpoints, or nil if approval isn't
+	// required.
+	approvals *ApprovalList
+
+	// visibility contains the visibility of endpoints, or nil if proxy
+	// clients aren't authenticated.
+	visibility *VisibilityList
+}
+
+func NewAdminHandler(
+	manager *LoadBalancedManager,
+	bans *BanList,
+	approvals *ApprovalList,
+	visibility *VisibilityList,
+) *AdminHandler {
+	h := &AdminHandler{
+		manager:    manager,
+		bans:       bans,
+		approvals:  approvals,
+		visibility: visibility,
+	}
+	if approvals != nil {
+		approvals.OnUpdate(h.onApprovalUpdate)
+	}
+	return h
+}
+
+func (h *AdminHandler) Register(group *gin.RouterGroup) {
+	group.GET("/upstreams", h.listUpstreamsRoute)
+	group.DELETE("/upstreams/:id", h.closeUpstreamRoute)
+	group.GET("/upstreams/bans", h.listBansRoute)
+	group.GET("/endpoints", h.listEndpointsRoute)
+	if h.approvals != nil {
+		group.GET("/endpoints/approvals", h.listApprovalsRoute)
+		// Use a wildcard since endpoint IDs scoped to a tenant contain
+		// a '/'.
+		group.POST("/endpoints/approvals/*endpointID", h.approveRoute)
+		group.DELETE("/endpoints/approvals/*endpointID", h.revokeRoute)
+	}
+	if h.visibility != nil {
+		group.GET("/endpoints/visibility", h.listVisibilityRoute)
+		group.PUT("/endpoints/visibility/*endpointID", h.setVisibilityRoute)
+		group.DELETE("/endpoints/visibility/*endpointID", h.unsetVisibilityRoute)
+	}
+}
+
+// listUpstreamsRoute returns the upstreams connected to the local node.
+//
+// If the 'tenant' query is set, only upstreams for the tenant are returned.
+func (h *AdminHandler) listUpstreamsRoute(c *gin.Context) {
+	conns := h.manager.Conns()
+	if tenant, ok := c.GetQuery("tenant"); ok {
+		filtered := make([]ConnInfo, 0)
+		for _, conn := range conns {
+			if conn.Tenant == tenant {
+				filtered = append(filtered, conn)
+			}
+		}
+		conns = filtered
+	}
+	c.JSON(http.StatusOK, conns)
+}
+
+// closeUpstreamRoute closes the upstream connected to the local node with the
+// given connection ID.
+//
+// If the 'ban' query is set, the upstream is also banned from reconnecting
+// to the cluster for the given duration. The ban is propagated to the other
+// nodes using gossip.
+func (h *AdminHandler) closeUpstreamRoute(c *gin.Context) {
+	var banDuration time.Duration
+	if ban := c.Query("ban"); ban != "" {
+		d, err := time.ParseDuration(ban)
+		if err != nil || d <= 0 {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{"error": fmt.Sprintf("invalid ban duration: %s", ban)},
+			)
+			return
+		}
+		banDuration = d
+	}
+
+	conn, ok := h.manager.Conn(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upstream not found"})
+		return
+	}
+
+	// Ban before closing the connection, otherwise the upstream could
+	// reconnect before the ban is added.
+	if banDuration != 0 {
+		h.bans.Ban(conn.EndpointID, conn.ClientIP, banDuration)
+	}
+
+	// If the upstream already disconnected there is nothing to close, though
+	// the ban still applies.
+	h.manager.CloseConn(conn.ID)
+
+	c.JSON(http.StatusOK, conn)
+}
+
+// listBansRoute returns the upstreams banned from connecting, including bans
+// learned from other nodes.
+func (h *AdminHandler) listBansRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, h.bans.Bans())
+}
+
+// listEndpointsRoute returns the endpoints with upstreams connected to the
+// cluster.
+//
+// If the 'tenant' query is set, only endpoints for the tenant are returned.
+func (h *AdminHandler) listEndpointsRoute(c *gin.Context) {
+	endpoints := h.manager.ClusterEndpoints()
+	if tenant, ok := c.GetQuery("tenant"); ok {
+		filtered := make([]EndpointInfo, 0)
+		for _, endpoint := range endpoints {
+			if endpoint.Tenant == tenant {
+				filtered = append(filtered, endpoint)
+			}
+		}
+		endpoints = filtered
+	}
+	c.JSON(http.StatusOK, endpoints)
+}
+
+// listApprovalsRoute returns the approved endpoints, including endpoints
+// approved on other nodes, and the endpoints with upstreams waiting for
+// approval on the local node.
+func (h *AdminHandler) listApprovalsRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, h.approvals.Approvals())
+}
+
+// approveRoute approves the endpoint to receive traffic. The approval is
+// propagated to the other nodes using gossip.
+func (h *AdminHandler) approveRoute(c *gin.Context) {
+	endpointID, ok := endpointIDParam(c)
+	if !ok {
+		return
+	}
+	h.approvals.Approve(endpointID)
+	c.JSON(http.StatusOK, h.approvals.Approvals())
+}
+
+// revokeRoute revokes the endpoints approval, and closes the endpoints
+// connected upstreams so they wait for approval when they reconnect. The
+// revocation is propagated to the other nodes using gossip, which close
+// their own connected upstreams for the endpoint.
+func (h *AdminHandler) revokeRoute(c *gin.Context) {
+	endpointID, ok := endpointIDParam(c)
+	if !ok {
+		return
+	}
+	if !h.approvals.Revoke(endpointID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "endpoint not approved"})
+		return
+	}
+
+	h.closeEndpointConns(endpointID)
+
+	c.JSON(http.StatusOK, h.approvals.Approvals())
+}
+
+// onApprovalUpdate closes the connected upstreams for endpoints revoked on
+// other nodes.
+func (h *AdminHandler) onApprovalUpdate(r ApprovalRecord) {
+	if r.Approved {
+		return
+	}
+	// Close in the background, since updates are received with the
+	// approvals mutex held.
+	go func() {
+		// The endpoint may have been approved again, or be approved in the
+		// configuration.
+		if h.approvals.Approved(r.EndpointID) {
+			return
+		}
+		h.closeEndpointConns(r.EndpointID)
+	}()
+}
+
+func (h *AdminHandler) closeEndpointConns(endpointID string) {
+	for _, conn := range h.manager.Conns() {
+		if conn.EndpointID == endpointID {
+			h.manager.CloseConn(conn.ID)
+		}
+	}
+}
+
+// listVisibilityRoute returns the visibility of endpoints known by the local
+// node.
+func (h *AdminHandler) listVisibilityRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, h.visibility.Visibilities())
+}
+
+// VisibilityUpdate contains the visibility to set for an endpoint.
+type VisibilityUpdate struct {
+	// Visibility is either 'public' or 'private'.
+	Visibility string `json:"visibility"`
+}
+
+// setVisibilityRoute sets the visibility of the endpoint, such as
+// '{"visibility": "public"}'. The visibility is propagated to the rest of the
+// cluster.
+func (h *AdminHandler) setVisibilityRoute(c *gin.Context) {
+	endpointID, ok := endpointIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req VisibilityUpdate
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if !ValidVisibility(req.Visibility) {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("invalid visibility: %s", req.Visibility)},
+		)
+		return
+	}
+
+	h.visibility.Set(endpointID, req.Visibility)
+	c.JSON(http.StatusOK, h.visibility.Visibilities())
+}
+
+// unsetVisibilityRoute removes the visibility of the endpoint, so the
+// configured visibility is used. The update is propagated to the rest of the
+// cluster.
+func (h *AdminHandler) unsetVisibilityRoute(c *gin.Context) {
+	endpointID, ok := endpointIDParam(c)
+	if !ok {
+		return
+	}
+	if !h.visibility.Unset(endpointID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "endpoint visibility not set"})
+		return
+	}
+	c.JSON(http.StatusOK, h.visibility.Visibilities())
+}
+
+// endpointIDParam returns the endpoint ID from the wildcard route parameter.
+// If the endpoint ID is missing, writes an error response and returns false.
+func endpointIDParam(c *gin.Context) (string, bool) {
+	endpointID := strings.TrimPrefix(c.Param("endpointID"), "/")
+	if endpointID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing endpoint id"})
+		return "", false
+	}
+	return endpointID, true
+}
+
+var _ status.Handler = &AdminHandler{}
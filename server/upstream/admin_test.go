@@ -0,0 +1,441 @@
+package upstream
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandler(t *testing.T) {
+	state := cluster.NewState(&cluster.Node{
+		ID:     "local",
+		Status: cluster.NodeStatusActive,
+	}, log.NewNopLogger())
+	state.AddNode(&cluster.Node{
+		ID:     "remote",
+		Status: cluster.NodeStatusActive,
+	})
+	state.UpdateRemoteEndpoint("remote", "endpoint-1", 2)
+
+	manager := NewLoadBalancedManager(state)
+	u1 := NewConnUpstream("endpoint-1", "10.26.104.56", nil)
+	manager.AddConn(u1)
+	u2 := NewConnUpstream("endpoint-2", "10.26.104.57", nil)
+	manager.AddConn(u2)
+
+	router := gin.New()
+	NewAdminHandler(manager, NewBanList(), nil, nil).Register(router.Group(""))
+
+	t.Run("upstreams", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/upstreams", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var conns []ConnInfo
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&conns))
+		require.Equal(t, 2, len(conns))
+
+		assert.Equal(t, u1.ID(), conns[0].ID)
+		assert.Equal(t, "endpoint-1", conns[0].EndpointID)
+		assert.Equal(t, "local", conns[0].NodeID)
+		assert.Equal(t, "10.26.104.56", conns[0].ClientIP)
+		assert.True(t, u1.ConnectedAt().Equal(conns[0].ConnectedAt))
+
+		assert.Equal(t, u2.ID(), conns[1].ID)
+		assert.Equal(t, "endpoint-2", conns[1].EndpointID)
+	})
+
+	t.Run("endpoints", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/endpoints", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var endpoints []EndpointInfo
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&endpoints))
+		assert.Equal(t, []EndpointInfo{
+			{
+				EndpointID:  "endpoint-1",
+				Connections: 3,
+				Nodes: []EndpointNode{
+					{NodeID: "local", Connections: 1},
+					{NodeID: "remote", Connections: 2},
+				},
+			},
+			{
+				EndpointID:  "endpoint-2",
+				Connections: 1,
+				Nodes: []EndpointNode{
+					{NodeID: "local", Connections: 1},
+				},
+			},
+		}, endpoints)
+	})
+}
+
+func TestAdminHandler_CloseUpstream(t *testing.T) {
+	state := cluster.NewState(&cluster.Node{
+		ID:     "local",
+		Status: cluster.NodeStatusActive,
+	}, log.NewNopLogger())
+
+	manager := NewLoadBalancedManager(state)
+	bans := NewBanList()
+
+	router := gin.New()
+	NewAdminHandler(manager, bans, nil, nil).Register(router.Group(""))
+
+	t.Run("close", func(t *testing.T) {
+		u := NewConnUpstream("endpoint-1", "10.26.104.56", testSession(t))
+		manager.AddConn(u)
+		defer manager.RemoveConn(u)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodDelete, "/upstreams/"+u.ID(), nil,
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var conn ConnInfo
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&conn))
+		assert.Equal(t, u.ID(), conn.ID)
+		assert.Equal(t, "endpoint-1", conn.EndpointID)
+
+		assert.True(t, u.sess.IsClosed())
+
+		_, banned := bans.Banned("endpoint-1", "10.26.104.56")
+		assert.False(t, banned)
+	})
+
+	t.Run("close and ban", func(t *testing.T) {
+		u := NewConnUpstream("endpoint-1", "10.26.104.56", testSession(t))
+		manager.AddConn(u)
+		defer manager.RemoveConn(u)
+
+		// Check the upstream is banned by the time the connection closes, so
+		// it can't reconnect before the ban is added.
+		bannedOnClose := make(chan bool, 1)
+		go func() {
+			<-u.sess.CloseChan()
+			_, banned := bans.Banned("endpoint-1", "10.26.104.56")
+			bannedOnClose <- banned
+		}()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodDelete, "/upstreams/"+u.ID()+"?ban=10m", nil,
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.True(t, u.sess.IsClosed())
+		assert.True(t, <-bannedOnClose)
+
+		_, banned := bans.Banned("endpoint-1", "10.26.104.56")
+		assert.True(t, banned)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodGet, "/upstreams/bans", nil,
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var listed []Ban
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+		require.Equal(t, 1, len(listed))
+		assert.Equal(t, "endpoint-1", listed[0].EndpointID)
+		assert.Equal(t, "10.26.104.56", listed[0].ClientIP)
+	})
+
+	t.Run("invalid ban", func(t *testing.T) {
+		u := NewConnUpstream("endpoint-1", "10.26.104.56", testSession(t))
+		manager.AddConn(u)
+		defer manager.RemoveConn(u)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodDelete, "/upstreams/"+u.ID()+"?ban=foo", nil,
+		))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// The upstream must not be closed.
+		assert.False(t, u.sess.IsClosed())
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodDelete, "/upstreams/unknown", nil,
+		))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestAdminHandler_Approvals(t *testing.T) {
+	state := cluster.NewState(&cluster.Node{
+		ID:     "local",
+		Status: cluster.NodeStatusActive,
+	}, log.NewNopLogger())
+
+	manager := NewLoadBalancedManager(state)
+	approvals := NewApprovalList([]string{"team-a-*"})
+
+	router := gin.New()
+	NewAdminHandler(manager, NewBanList(), approvals, nil).Register(router.Group(""))
+
+	t.Run("approve", func(t *testing.T) {
+		approved, cancel := approvals.Wait("endpoint-1", "10.26.104.56")
+		defer cancel()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodGet, "/endpoints/approvals", nil,
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var listed Approvals
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+		assert.Equal(t, []string{"team-a-*"}, listed.Configured)
+		require.Equal(t, 1, len(listed.Pending))
+		assert.Equal(t, "endpoint-1", listed.Pending[0].EndpointID)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodPost, "/endpoints/approvals/endpoint-1", nil,
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+		assert.Equal(t, []string{"endpoint-1"}, listed.Approved)
+		assert.Empty(t, listed.Pending)
+
+		// The pending upstream must be released.
+		select {
+		case <-approved:
+		default:
+			t.Fatal("upstream not approved")
+		}
+		assert.True(t, approvals.Approved("endpoint-1"))
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		approvals.Approve("endpoint-2")
+
+		u := NewConnUpstream("endpoint-2", "10.26.104.56", testSession(t))
+		manager.AddConn(u)
+		defer manager.RemoveConn(u)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodDelete, "/endpoints/approvals/endpoint-2", nil,
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.False(t, approvals.Approved("endpoint-2"))
+		// Connected upstreams are closed so they must be approved again.
+		assert.True(t, u.sess.IsClosed())
+	})
+
+	// Tests upstreams are closed when the endpoint is revoked on another
+	// node.
+	t.Run("revoke remote", func(t *testing.T) {
+		approvals.Approve("endpoint-4")
+
+		u := NewConnUpstream("endpoint-4", "10.26.104.56", testSession(t))
+		manager.AddConn(u)
+		defer manager.RemoveConn(u)
+
+		r, _ := approvals.Record("endpoint-4")
+		assert.True(t, approvals.Apply(ApprovalRecord{
+			EndpointID: "endpoint-4",
+			Approved:   false,
+			Updated:    r.Updated.Add(time.Second),
+		}))
+
+		assert.Eventually(t, func() bool {
+			return u.sess.IsClosed()
+		}, time.Second, time.Millisecond)
+	})
+
+	// Tests a remote revocation doesn't close upstreams for endpoints
+	// approved in the configuration.
+	t.Run("revoke remote configured", func(t *testing.T) {
+		u := NewConnUpstream("team-a-2", "10.26.104.56", testSession(t))
+		manager.AddConn(u)
+		defer manager.RemoveConn(u)
+
+		assert.True(t, approvals.Apply(ApprovalRecord{
+			EndpointID: "team-a-2",
+			Approved:   false,
+			Updated:    time.Now(),
+		}))
+
+		time.Sleep(time.Millisecond * 10)
+		assert.False(t, u.sess.IsClosed())
+	})
+
+	t.Run("revoke not approved", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodDelete, "/endpoints/approvals/unknown", nil,
+		))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		// Configured endpoints can't be revoked.
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodDelete, "/endpoints/approvals/team-a-1", nil,
+		))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.True(t, approvals.Approved("team-a-1"))
+	})
+
+	t.Run("approve tenant endpoint", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodPost, "/endpoints/approvals/tenant-1/endpoint-3", nil,
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.True(t, approvals.Approved("tenant-1/endpoint-3"))
+		assert.False(t, approvals.Approved("endpoint-3"))
+	})
+}
+
+func TestAdminHandler_Visibility(t *testing.T) {
+	state := cluster.NewState(&cluster.Node{
+		ID:     "local",
+		Status: cluster.NodeStatusActive,
+	}, log.NewNopLogger())
+
+	manager := NewLoadBalancedManager(state)
+	visibility := NewVisibilityList()
+
+	router := gin.New()
+	NewAdminHandler(manager, NewBanList(), nil, visibility).Register(router.Group(""))
+
+	t.Run("set", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodPut,
+			"/endpoints/visibility/tenant-1/endpoint-1",
+			strings.NewReader(`{"visibility": "public"}`),
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var listed []EndpointVisibility
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+		assert.Equal(t, []EndpointVisibility{
+			{EndpointID: "tenant-1/endpoint-1", Visibility: VisibilityPublic},
+		}, listed)
+
+		v, ok := visibility.Lookup("tenant-1/endpoint-1")
+		assert.True(t, ok)
+		assert.Equal(t, VisibilityPublic, v)
+	})
+
+	t.Run("set invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodPut,
+			"/endpoints/visibility/endpoint-2",
+			strings.NewReader(`{"visibility": "unknown"}`),
+		))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		_, ok := visibility.Lookup("endpoint-2")
+		assert.False(t, ok)
+	})
+
+	t.Run("unset", func(t *testing.T) {
+		visibility.Set("endpoint-3", VisibilityPrivate)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodDelete, "/endpoints/visibility/endpoint-3", nil,
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		_, ok := visibility.Lookup("endpoint-3")
+		assert.False(t, ok)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodDelete, "/endpoints/visibility/endpoint-3", nil,
+		))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestAdminHandler_Tenants(t *testing.T) {
+	state := cluster.NewState(&cluster.Node{
+		ID:     "local",
+		Status: cluster.NodeStatusActive,
+	}, log.NewNopLogger())
+
+	manager := NewLoadBalancedManager(state)
+	manager.AddConn(NewConnUpstream("my-endpoint", "10.26.104.56", nil))
+	manager.AddConn(NewConnUpstream("tenant-1/my-endpoint", "10.26.104.57", nil))
+	manager.AddConn(NewConnUpstream("tenant-2/my-endpoint", "10.26.104.58", nil))
+
+	router := gin.New()
+	NewAdminHandler(manager, NewBanList(), nil, nil).Register(router.Group(""))
+
+	t.Run("upstreams", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodGet, "/upstreams?tenant=tenant-1", nil,
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var conns []ConnInfo
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&conns))
+		require.Equal(t, 1, len(conns))
+		assert.Equal(t, "tenant-1/my-endpoint", conns[0].EndpointID)
+		assert.Equal(t, "tenant-1", conns[0].Tenant)
+	})
+
+	t.Run("endpoints", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodGet, "/endpoints", nil,
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var endpoints []EndpointInfo
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&endpoints))
+		require.Equal(t, 3, len(endpoints))
+		assert.Equal(t, "my-endpoint", endpoints[0].EndpointID)
+		assert.Equal(t, "", endpoints[0].Tenant)
+		assert.Equal(t, "tenant-2/my-endpoint", endpoints[2].EndpointID)
+		assert.Equal(t, "tenant-2", endpoints[2].Tenant)
+
+		// An empty tenant filters endpoints without a tenant.
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(
+			http.MethodGet, "/endpoints?tenant=", nil,
+		))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&endpoints))
+		require.Equal(t, 1, len(endpoints))
+		assert.Equal(t, "my-endpoint", endpoints[0].EndpointID)
+	})
+}
+
+func testSession(t *testing.T) *yamux.Session {
+	conn, _ := net.Pipe()
+	sess, err := yamux.Client(conn, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { sess.Close() })
+	return sess
+}
@@ -0,0 +1,307 @@
+package upstream
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andydunstall/piko/server/auth"
+)
+
+// PendingEndpoint describes an endpoint with upstreams waiting for approval.
+type PendingEndpoint struct {
+	EndpointID string `json:"endpoint_id"`
+
+	// Upstreams is the number of upstreams for the endpoint waiting for
+	// approval.
+	Upstreams int `json:"upstreams"`
+
+	// ClientIPs contains the client IPs of the waiting upstreams.
+	ClientIPs []string `json:"client_ips"`
+
+	// Since is the time the first waiting upstream connected.
+	Since time.Time `json:"since"`
+}
+
+// Approvals describes the endpoints approved to receive traffic, and the
+// endpoints waiting for approval.
+type Approvals struct {
+	// Configured contains the endpoint patterns approved in the
+	// configuration.
+	Configured []string `json:"configured"`
+
+	// Approved contains the endpoints approved using the admin API.
+	Approved []string `json:"approved"`
+
+	Pending []PendingEndpoint `json:"pending"`
+}
+
+// ApprovalRecord is the latest approval or revocation of an endpoint using
+// the admin API, used to propagate approvals to other nodes.
+type ApprovalRecord struct {
+	EndpointID string
+
+	// Approved is true if the endpoint was approved, or false if the
+	// approval was revoked.
+	Approved bool
+
+	// Updated is the time the endpoint was approved or revoked.
+	Updated time.Time
+}
+
+// newer returns whether the record is a newer update than other.
+func (r *ApprovalRecord) newer(other ApprovalRecord) bool {
+	if !r.Updated.Equal(other.Updated) {
+		return r.Updated.After(other.Updated)
+	}
+	// Break ties deterministically so every node selects the same update,
+	// preferring to revoke.
+	return !r.Approved && other.Approved
+}
+
+type approvalWaiter struct {
+	clientIP string
+	since    time.Time
+	ch       chan struct{}
+}
+
+// ApprovalList contains the endpoints approved to receive traffic.
+//
+// When approval is required, upstreams for endpoints that aren't approved
+// are held as pending, and only added once the endpoint is approved.
+//
+// Approvals using the admin API are propagated to the other nodes in the
+// cluster using gossip, where the latest approval or revocation of each
+// endpoint wins. Each node that learns about an update also propagates it,
+// so the update isn't lost when the node it was made on leaves. Revoking an
+// endpoint is recorded as an update, so it isn't overridden by an earlier
+// approval. Approvals aren't persisted, so are lost if all nodes restart.
+type ApprovalList struct {
+	// configured contains endpoint patterns approved in the configuration,
+	// which may contain '*' wildcards.
+	configured []string
+
+	// records contains the latest approval or revocation of each endpoint
+	// using the admin API.
+	records map[string]ApprovalRecord
+
+	// waiters contains the upstreams waiting for approval, keyed by
+	// endpoint ID.
+	waiters map[string]map[*approvalWaiter]struct{}
+
+	subscribers []func(r ApprovalRecord)
+
+	mu sync.Mutex
+}
+
+func NewApprovalList(configured []string) *ApprovalList {
+	return &ApprovalList{
+		configured: configured,
+		records:    make(map[string]ApprovalRecord),
+		waiters:    make(map[string]map[*approvalWaiter]struct{}),
+	}
+}
+
+// Approved returns whether the endpoint is approved to receive traffic.
+func (l *ApprovalList) Approved(endpointID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.approvedLocked(endpointID)
+}
+
+// Wait registers an upstream for the endpoint as waiting for approval.
+//
+// Returns a channel that is closed once the endpoint is approved, and a
+// function to remove the upstream from the pending upstreams, which must be
+// called once the upstream stops waiting.
+func (l *ApprovalList) Wait(endpointID string, clientIP string) (<-chan struct{}, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := &approvalWaiter{
+		clientIP: clientIP,
+		since:    time.Now(),
+		ch:       make(chan struct{}),
+	}
+	if l.approvedLocked(endpointID) {
+		close(w.ch)
+		return w.ch, func() {}
+	}
+
+	waiters, ok := l.waiters[endpointID]
+	if !ok {
+		waiters = make(map[*approvalWaiter]struct{})
+		l.waiters[endpointID] = waiters
+	}
+	waiters[w] = struct{}{}
+
+	return w.ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		waiters, ok := l.waiters[endpointID]
+		if !ok {
+			return
+		}
+		delete(waiters, w)
+		if len(waiters) == 0 {
+			delete(l.waiters, endpointID)
+		}
+	}
+}
+
+// Approve approves the endpoint to receive traffic, and releases any
+// upstreams waiting for approval.
+func (l *ApprovalList) Approve(endpointID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.updateLocked(endpointID, true)
+}
+
+// Revoke revokes an endpoint approved using Approve.
+//
+// Returns false if the endpoint wasn't approved. Note endpoints approved in
+// the configuration can't be revoked.
+func (l *ApprovalList) Revoke(endpointID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if r, ok := l.records[endpointID]; !ok || !r.Approved {
+		return false
+	}
+	l.updateLocked(endpointID, false)
+	return true
+}
+
+// Apply applies an update learned from another node, unless the list already
+// has a newer update for the endpoint. If the endpoint is approved, any
+// upstreams waiting for approval are released.
+//
+// Returns false if the update wasn't applied.
+func (l *ApprovalList) Apply(r ApprovalRecord) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if existing, ok := l.records[r.EndpointID]; ok && !r.newer(existing) {
+		return false
+	}
+	l.setLocked(r)
+	return true
+}
+
+// Record returns the latest approval or revocation of the endpoint.
+func (l *ApprovalList) Record(endpointID string) (ApprovalRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.records[endpointID]
+	return r, ok
+}
+
+// Records returns the latest approval or revocation of each endpoint,
+// including revoked endpoints.
+func (l *ApprovalList) Records() []ApprovalRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	records := make([]ApprovalRecord, 0, len(l.records))
+	for _, r := range l.records {
+		records = append(records, r)
+	}
+	return records
+}
+
+// OnUpdate subscribes to endpoints being approved or revoked, including
+// updates learned from other nodes.
+//
+// The callback is called with the list mutex locked so must not block or
+// call back to the list.
+func (l *ApprovalList) OnUpdate(f func(r ApprovalRecord)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.subscribers = append(l.subscribers, f)
+}
+
+// Approvals returns the approved and pending endpoints, sorted by endpoint
+// ID.
+func (l *ApprovalList) Approvals() Approvals {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	approvals := Approvals{
+		Configured: make([]string, len(l.configured)),
+		Approved:   make([]string, 0, len(l.records)),
+		Pending:    make([]PendingEndpoint, 0, len(l.waiters)),
+	}
+	copy(approvals.Configured, l.configured)
+	for endpointID, r := range l.records {
+		if r.Approved {
+			approvals.Approved = append(approvals.Approved, endpointID)
+		}
+	}
+	sort.Strings(approvals.Approved)
+
+	for endpointID, waiters := range l.waiters {
+		pending := PendingEndpoint{
+			EndpointID: endpointID,
+			Upstreams:  len(waiters),
+		}
+		for w := range waiters {
+			pending.ClientIPs = append(pending.ClientIPs, w.clientIP)
+			if pending.Since.IsZero() || w.since.Before(pending.Since) {
+				pending.Since = w.since
+			}
+		}
+		sort.Strings(pending.ClientIPs)
+		approvals.Pending = append(approvals.Pending, pending)
+	}
+	sort.Slice(approvals.Pending, func(i, j int) bool {
+		return approvals.Pending[i].EndpointID < approvals.Pending[j].EndpointID
+	})
+
+	return approvals
+}
+
+func (l *ApprovalList) updateLocked(endpointID string, approved bool) {
+	r := ApprovalRecord{
+		EndpointID: endpointID,
+		Approved:   approved,
+		Updated:    time.Now(),
+	}
+	// Ensure the update is newer than the existing update, even if the
+	// existing update was learned from a node whose clock is ahead.
+	if existing, ok := l.records[endpointID]; ok && !r.Updated.After(existing.Updated) {
+		r.Updated = existing.Updated.Add(time.Nanosecond)
+	}
+	l.setLocked(r)
+}
+
+func (l *ApprovalList) setLocked(r ApprovalRecord) {
+	l.records[r.EndpointID] = r
+
+	if r.Approved {
+		for w := range l.waiters[r.EndpointID] {
+			close(w.ch)
+		}
+		delete(l.waiters, r.EndpointID)
+	}
+
+	for _, f := range l.subscribers {
+		f(r)
+	}
+}
+
+func (l *ApprovalList) approvedLocked(endpointID string) bool {
+	if r, ok := l.records[endpointID]; ok && r.Approved {
+		return true
+	}
+	for _, pattern := range l.configured {
+		if auth.MatchEndpoint(pattern, endpointID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApprovalList(t *testing.T) {
+	t.Run("revoke", func(t *testing.T) {
+		l := NewApprovalList(nil)
+
+		assert.False(t, l.Revoke("my-endpoint"))
+
+		l.Approve("my-endpoint")
+		assert.True(t, l.Approved("my-endpoint"))
+
+		assert.True(t, l.Revoke("my-endpoint"))
+		assert.False(t, l.Approved("my-endpoint"))
+		assert.Empty(t, l.Approvals().Approved)
+
+		// The revocation is recorded so it can be propagated.
+		r, ok := l.Record("my-endpoint")
+		assert.True(t, ok)
+		assert.False(t, r.Approved)
+
+		assert.False(t, l.Revoke("my-endpoint"))
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		l := NewApprovalList(nil)
+
+		approved, cancel := l.Wait("my-endpoint", "10.26.104.56")
+		defer cancel()
+
+		assert.True(t, l.Apply(ApprovalRecord{
+			EndpointID: "my-endpoint",
+			Approved:   true,
+			Updated:    time.Unix(100, 0),
+		}))
+		assert.True(t, l.Approved("my-endpoint"))
+
+		// Pending upstreams are released.
+		select {
+		case <-approved:
+		default:
+			t.Fatal("upstream not approved")
+		}
+
+		// Older updates are ignored.
+		assert.False(t, l.Apply(ApprovalRecord{
+			EndpointID: "my-endpoint",
+			Approved:   false,
+			Updated:    time.Unix(50, 0),
+		}))
+		assert.True(t, l.Approved("my-endpoint"))
+
+		assert.True(t, l.Apply(ApprovalRecord{
+			EndpointID: "my-endpoint",
+			Approved:   false,
+			Updated:    time.Unix(200, 0),
+		}))
+		assert.False(t, l.Approved("my-endpoint"))
+	})
+
+	t.Run("apply tie", func(t *testing.T) {
+		l := NewApprovalList(nil)
+
+		// Revocations win ties, so every node selects the same update.
+		assert.True(t, l.Apply(ApprovalRecord{
+			EndpointID: "my-endpoint",
+			Approved:   true,
+			Updated:    time.Unix(100, 0),
+		}))
+		assert.True(t, l.Apply(ApprovalRecord{
+			EndpointID: "my-endpoint",
+			Approved:   false,
+			Updated:    time.Unix(100, 0),
+		}))
+		assert.False(t, l.Apply(ApprovalRecord{
+			EndpointID: "my-endpoint",
+			Approved:   true,
+			Updated:    time.Unix(100, 0),
+		}))
+		assert.False(t, l.Approved("my-endpoint"))
+	})
+
+	t.Run("update after remote", func(t *testing.T) {
+		l := NewApprovalList(nil)
+
+		// Updates learned from a node whose clock is ahead.
+		future := time.Now().Add(time.Hour)
+		l.Apply(ApprovalRecord{
+			EndpointID: "my-endpoint",
+			Approved:   true,
+			Updated:    future,
+		})
+
+		assert.True(t, l.Revoke("my-endpoint"))
+		r, _ := l.Record("my-endpoint")
+		assert.False(t, r.Approved)
+		assert.True(t, r.Updated.After(future))
+	})
+
+	t.Run("subscribe", func(t *testing.T) {
+		l := NewApprovalList(nil)
+
+		var updates []ApprovalRecord
+		l.OnUpdate(func(r ApprovalRecord) {
+			updates = append(updates, r)
+		})
+
+		l.Approve("my-endpoint")
+		l.Revoke("my-endpoint")
+
+		assert.Equal(t, 2, len(updates))
+		assert.True(t, updates[0].Approved)
+		assert.False(t, updates[1].Approved)
+	})
+}
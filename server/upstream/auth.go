@@ -62,6 +62,17 @@ func (m *AuthMiddleware) VerifyEndpointToken(c *gin.Context) {
 			)
 			return
 		}
+		if errors.Is(err, auth.ErrRevokedToken) {
+			m.logger.Warn(
+				"auth revoked token",
+				zap.Error(err),
+			)
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				gin.H{"error": "revoked token"},
+			)
+			return
+		}
 
 		m.logger.Warn(
 			"unknown verification error",
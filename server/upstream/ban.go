@@ -0,0 +1,184 @@
+package upstream
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban describes an upstream that is temporarily banned from connecting.
+type Ban struct {
+	EndpointID string `json:"endpoint_id"`
+
+	ClientIP string `json:"client_ip"`
+
+	// Expiry is the time the ban expires.
+	Expiry time.Time `json:"expiry"`
+}
+
+type banKey struct {
+	endpointID string
+	clientIP   string
+}
+
+// BanList contains upstreams that are temporarily banned from connecting to
+// the cluster.
+//
+// Upstreams are identified by their endpoint ID and client IP, since a
+// disconnected upstream will reconnect with a new connection.
+//
+// Bans are propagated to the other nodes in the cluster using gossip. Each
+// node that learns about a ban also propagates it, so the ban isn't lost
+// when the node it was added on leaves. If an upstream is banned multiple
+// times, the latest expiry wins. Bans aren't persisted, so are lost if all
+// nodes restart.
+type BanList struct {
+	bans map[banKey]time.Time
+
+	banSubscribers    []func(b Ban)
+	expireSubscribers []func(b Ban)
+
+	mu sync.Mutex
+}
+
+func NewBanList() *BanList {
+	return &BanList{
+		bans: make(map[banKey]time.Time),
+	}
+}
+
+// Ban bans the upstream for the given endpoint and client IP from connecting
+// for the given duration.
+//
+// If the upstream is already banned for longer, the existing ban is kept.
+func (l *BanList) Ban(endpointID string, clientIP string, d time.Duration) Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.removeExpiredLocked(now)
+
+	b := Ban{
+		EndpointID: endpointID,
+		ClientIP:   clientIP,
+		Expiry:     now.Add(d),
+	}
+	l.applyLocked(b)
+
+	b.Expiry = l.bans[banKey{
+		endpointID: endpointID,
+		clientIP:   clientIP,
+	}]
+	return b
+}
+
+// Apply applies a ban learned from another node, unless the upstream is
+// already banned for longer or the ban has expired.
+//
+// Returns false if the ban wasn't applied.
+func (l *BanList) Apply(b Ban) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.removeExpiredLocked(now)
+
+	if now.After(b.Expiry) {
+		return false
+	}
+	return l.applyLocked(b)
+}
+
+// Banned returns whether the upstream for the given endpoint and client IP is
+// banned, and if so, when the ban expires.
+func (l *BanList) Banned(endpointID string, clientIP string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.removeExpiredLocked(time.Now())
+
+	expiry, ok := l.bans[banKey{
+		endpointID: endpointID,
+		clientIP:   clientIP,
+	}]
+	return expiry, ok
+}
+
+// Bans returns the active bans, sorted by expiry.
+func (l *BanList) Bans() []Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.removeExpiredLocked(time.Now())
+
+	bans := make([]Ban, 0, len(l.bans))
+	for key, expiry := range l.bans {
+		bans = append(bans, Ban{
+			EndpointID: key.endpointID,
+			ClientIP:   key.clientIP,
+			Expiry:     expiry,
+		})
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Expiry.Before(bans[j].Expiry)
+	})
+	return bans
+}
+
+// OnBan subscribes to upstreams being banned, including bans learned from
+// other nodes.
+//
+// The callback is called with the list mutex locked so must not block or
+// call back to the list.
+func (l *BanList) OnBan(f func(b Ban)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.banSubscribers = append(l.banSubscribers, f)
+}
+
+// OnExpire subscribes to bans being discarded once expired.
+//
+// The callback is called with the list mutex locked so must not block or
+// call back to the list.
+func (l *BanList) OnExpire(f func(b Ban)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expireSubscribers = append(l.expireSubscribers, f)
+}
+
+func (l *BanList) applyLocked(b Ban) bool {
+	key := banKey{
+		endpointID: b.EndpointID,
+		clientIP:   b.ClientIP,
+	}
+	if expiry, ok := l.bans[key]; ok && !b.Expiry.After(expiry) {
+		return false
+	}
+	l.bans[key] = b.Expiry
+
+	for _, f := range l.banSubscribers {
+		f(b)
+	}
+	return true
+}
+
+func (l *BanList) removeExpiredLocked(now time.Time) {
+	for key, expiry := range l.bans {
+		if !now.After(expiry) {
+			continue
+		}
+		delete(l.bans, key)
+
+		b := Ban{
+			EndpointID: key.endpointID,
+			ClientIP:   key.clientIP,
+			Expiry:     expiry,
+		}
+		for _, f := range l.expireSubscribers {
+			f(b)
+		}
+	}
+}
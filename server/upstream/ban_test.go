@@ -0,0 +1,89 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBanList(t *testing.T) {
+	t.Run("ban", func(t *testing.T) {
+		bans := NewBanList()
+
+		var banned []Ban
+		bans.OnBan(func(b Ban) {
+			banned = append(banned, b)
+		})
+
+		b := bans.Ban("my-endpoint", "10.26.104.10", time.Hour)
+
+		expiry, ok := bans.Banned("my-endpoint", "10.26.104.10")
+		assert.True(t, ok)
+		assert.Equal(t, b.Expiry, expiry)
+		assert.Equal(t, []Ban{b}, banned)
+
+		// Other upstreams aren't banned.
+		_, ok = bans.Banned("my-endpoint", "10.26.104.11")
+		assert.False(t, ok)
+		_, ok = bans.Banned("other-endpoint", "10.26.104.10")
+		assert.False(t, ok)
+
+		// Shorter bans don't reduce the existing ban.
+		shorter := bans.Ban("my-endpoint", "10.26.104.10", time.Minute)
+		assert.Equal(t, b.Expiry, shorter.Expiry)
+		assert.Len(t, banned, 1)
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		bans := NewBanList()
+
+		var expired []Ban
+		bans.OnExpire(func(b Ban) {
+			expired = append(expired, b)
+		})
+
+		b := bans.Ban("my-endpoint", "10.26.104.10", time.Millisecond)
+		time.Sleep(time.Millisecond * 2)
+
+		_, ok := bans.Banned("my-endpoint", "10.26.104.10")
+		assert.False(t, ok)
+		assert.Equal(t, []Ban{b}, expired)
+		assert.Empty(t, bans.Bans())
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		bans := NewBanList()
+
+		expiry := time.Now().Add(time.Hour)
+		assert.True(t, bans.Apply(Ban{
+			EndpointID: "my-endpoint",
+			ClientIP:   "10.26.104.10",
+			Expiry:     expiry,
+		}))
+
+		// Shorter bans are ignored.
+		assert.False(t, bans.Apply(Ban{
+			EndpointID: "my-endpoint",
+			ClientIP:   "10.26.104.10",
+			Expiry:     expiry.Add(-time.Minute),
+		}))
+
+		// Longer bans extend the existing ban.
+		assert.True(t, bans.Apply(Ban{
+			EndpointID: "my-endpoint",
+			ClientIP:   "10.26.104.10",
+			Expiry:     expiry.Add(time.Minute),
+		}))
+		e, ok := bans.Banned("my-endpoint", "10.26.104.10")
+		assert.True(t, ok)
+		assert.Equal(t, expiry.Add(time.Minute), e)
+
+		// Expired bans are ignored.
+		assert.False(t, bans.Apply(Ban{
+			EndpointID: "other-endpoint",
+			ClientIP:   "10.26.104.10",
+			Expiry:     time.Now().Add(-time.Minute),
+		}))
+	})
+}
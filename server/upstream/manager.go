@@ -1,8 +1,12 @@
 package upstream
 
 import (
+	"io"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/andydunstall/piko/server/auth"
 	"github.com/andydunstall/piko/server/cluster"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/atomic"
@@ -32,6 +36,11 @@ type Manager interface {
 
 // loadBalancer load balances requests among upstreams in a round-robin
 // fashion.
+//
+// Upstreams at their concurrent request limit are skipped, so requests spill
+// over to other upstreams for the endpoint. If all upstreams are at their
+// limit, the next upstream is selected anyway and the request waits for
+// capacity.
 type loadBalancer struct {
 	upstreams []Upstream
 	nextIndex int
@@ -61,20 +70,48 @@ func (lb *loadBalancer) Next() Upstream {
 		return nil
 	}
 
+	for i := 0; i != len(lb.upstreams); i++ {
+		index := (lb.nextIndex + i) % len(lb.upstreams)
+		u := lb.upstreams[index]
+		if available(u) {
+			lb.nextIndex = (index + 1) % len(lb.upstreams)
+			return u
+		}
+	}
+
 	u := lb.upstreams[lb.nextIndex]
 	lb.nextIndex++
 	lb.nextIndex %= len(lb.upstreams)
 	return u
 }
 
+// available returns whether the upstream has capacity for another request.
+func available(u Upstream) bool {
+	limited, ok := u.(interface{ Available() bool })
+	return !ok || limited.Available()
+}
+
 type Usage struct {
 	Requests  *atomic.Uint64
 	Upstreams *atomic.Uint64
 }
 
+// ConnEvent describes an upstream connecting to or disconnecting from the
+// local node.
+type ConnEvent struct {
+	// Connected is true if the upstream connected, or false if the upstream
+	// disconnected.
+	Connected bool
+
+	Conn ConnInfo
+}
+
 type LoadBalancedManager struct {
 	localUpstreams map[string]*loadBalancer
 
+	connSubscribers      map[uint64]func(event ConnEvent)
+	nextConnSubscriberID uint64
+
 	mu sync.Mutex
 
 	usage *Usage
@@ -86,8 +123,9 @@ type LoadBalancedManager struct {
 
 func NewLoadBalancedManager(cluster *cluster.State) *LoadBalancedManager {
 	return &LoadBalancedManager{
-		localUpstreams: make(map[string]*loadBalancer),
-		cluster:        cluster,
+		localUpstreams:  make(map[string]*loadBalancer),
+		connSubscribers: make(map[uint64]func(event ConnEvent)),
+		cluster:         cluster,
 		usage: &Usage{
 			Requests:  atomic.NewUint64(0),
 			Upstreams: atomic.NewUint64(0),
@@ -122,7 +160,6 @@ func (m *LoadBalancedManager) Select(endpointID string, allowRemote bool) (Upstr
 
 func (m *LoadBalancedManager) AddConn(u Upstream) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 
 	lb, ok := m.localUpstreams[u.EndpointID()]
 	if !ok {
@@ -137,26 +174,133 @@ func (m *LoadBalancedManager) AddConn(u Upstream) {
 	m.cluster.AddLocalEndpoint(u.EndpointID())
 
 	m.metrics.ConnectedUpstreams.Inc()
+	m.metrics.UpstreamConnectsTotal.Inc()
+	m.metrics.EndpointUpstreams.With(prometheus.Labels{
+		"endpoint_id": u.EndpointID(),
+	}).Set(float64(len(lb.upstreams)))
 	m.usage.Upstreams.Inc()
+
+	subscribers := m.connSubscribersLocked()
+
+	m.mu.Unlock()
+
+	m.notifyConn(subscribers, u, true)
 }
 
 func (m *LoadBalancedManager) RemoveConn(u Upstream) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 
 	lb, ok := m.localUpstreams[u.EndpointID()]
 	if !ok {
+		m.mu.Unlock()
 		return
 	}
 	if lb.Remove(u) {
 		delete(m.localUpstreams, u.EndpointID())
 
 		m.metrics.RegisteredEndpoints.Dec()
+		// Remove the endpoint label to avoid accumulating labels for
+		// endpoints that are no longer connected.
+		m.metrics.EndpointUpstreams.Delete(prometheus.Labels{
+			"endpoint_id": u.EndpointID(),
+		})
+	} else {
+		m.metrics.EndpointUpstreams.With(prometheus.Labels{
+			"endpoint_id": u.EndpointID(),
+		}).Set(float64(len(lb.upstreams)))
 	}
 
 	m.cluster.RemoveLocalEndpoint(u.EndpointID())
 
 	m.metrics.ConnectedUpstreams.Dec()
+	m.metrics.UpstreamDisconnectsTotal.Inc()
+
+	subscribers := m.connSubscribersLocked()
+
+	m.mu.Unlock()
+
+	m.notifyConn(subscribers, u, false)
+}
+
+// SubscribeConns subscribes to upstreams connecting to and disconnecting
+// from the local node. Returns a function to unsubscribe.
+//
+// The callback is called without the manager mutex locked, though must not
+// block.
+func (m *LoadBalancedManager) SubscribeConns(f func(event ConnEvent)) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.nextConnSubscriberID
+	m.nextConnSubscriberID++
+	m.connSubscribers[id] = f
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		delete(m.connSubscribers, id)
+	}
+}
+
+// Shed gracefully closes up to n local upstream connections, so those
+// upstreams reconnect (possibly to another node in the cluster). Connections
+// are shed from the endpoints with the most connections first.
+//
+// The shed upstreams stop receiving new requests and are asked to reconnect,
+// though their connections stay open until in-flight requests complete.
+//
+// Returns the number of closed connections.
+func (m *LoadBalancedManager) Shed(n int) int {
+	m.mu.Lock()
+
+	endpoints := make([][]Upstream, 0, len(m.localUpstreams))
+	for _, lb := range m.localUpstreams {
+		upstreams := make([]Upstream, len(lb.upstreams))
+		copy(upstreams, lb.upstreams)
+		endpoints = append(endpoints, upstreams)
+	}
+
+	m.mu.Unlock()
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return len(endpoints[i]) > len(endpoints[j])
+	})
+
+	// Select one connection from each endpoint in turn until we have
+	// enough connections.
+	var shed []Upstream
+	for len(shed) < n {
+		selected := false
+		for i := range endpoints {
+			if len(shed) == n {
+				break
+			}
+			if len(endpoints[i]) == 0 {
+				continue
+			}
+			shed = append(shed, endpoints[i][0])
+			endpoints[i] = endpoints[i][1:]
+			selected = true
+		}
+		if !selected {
+			break
+		}
+	}
+
+	// Close the connections without holding the mutex, since closing will
+	// call back to RemoveConn.
+	closed := 0
+	for _, u := range shed {
+		closer, ok := u.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err == nil {
+			closed++
+		}
+	}
+	return closed
 }
 
 func (m *LoadBalancedManager) Endpoints() map[string]int {
@@ -170,6 +314,119 @@ func (m *LoadBalancedManager) Endpoints() map[string]int {
 	return endpoints
 }
 
+// Conns returns the upstreams connected to the local node, sorted by
+// endpoint ID and connection time.
+func (m *LoadBalancedManager) Conns() []ConnInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	conns := make([]ConnInfo, 0)
+	for _, lb := range m.localUpstreams {
+		for _, u := range lb.upstreams {
+			conn, ok := u.(*ConnUpstream)
+			if !ok {
+				continue
+			}
+			conns = append(conns, m.connInfo(conn, now))
+		}
+	}
+
+	sort.Slice(conns, func(i, j int) bool {
+		if conns[i].EndpointID != conns[j].EndpointID {
+			return conns[i].EndpointID < conns[j].EndpointID
+		}
+		return conns[i].ConnectedAt.Before(conns[j].ConnectedAt)
+	})
+	return conns
+}
+
+// Conn returns the upstream connected to the local node with the given
+// connection ID, or false if no upstream with the given ID is connected.
+func (m *LoadBalancedManager) Conn(id string) (ConnInfo, bool) {
+	m.mu.Lock()
+	conn := m.connLocked(id)
+	m.mu.Unlock()
+
+	if conn == nil {
+		return ConnInfo{}, false
+	}
+	return m.connInfo(conn, time.Now()), true
+}
+
+// CloseConn immediately closes the upstream connected to the local node with
+// the given connection ID, including any in-flight requests.
+//
+// Returns the closed connection, or false if no upstream with the given ID
+// is connected.
+func (m *LoadBalancedManager) CloseConn(id string) (ConnInfo, bool) {
+	m.mu.Lock()
+	conn := m.connLocked(id)
+	m.mu.Unlock()
+
+	if conn == nil {
+		return ConnInfo{}, false
+	}
+
+	// Close without holding the mutex since closing the connection calls
+	// back to RemoveConn.
+	_ = conn.CloseNow()
+	return m.connInfo(conn, time.Now()), true
+}
+
+func (m *LoadBalancedManager) connLocked(id string) *ConnUpstream {
+	for _, lb := range m.localUpstreams {
+		for _, u := range lb.upstreams {
+			if c, ok := u.(*ConnUpstream); ok && c.ID() == id {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// ClusterEndpoints returns the endpoints with upstreams connected to any
+// active node in the cluster, sorted by endpoint ID.
+func (m *LoadBalancedManager) ClusterEndpoints() []EndpointInfo {
+	endpoints := make(map[string]*EndpointInfo)
+	for _, node := range m.cluster.Nodes() {
+		if node.Status != cluster.NodeStatusActive {
+			continue
+		}
+		for endpointID, listeners := range node.Endpoints {
+			if listeners == 0 {
+				continue
+			}
+			endpoint, ok := endpoints[endpointID]
+			if !ok {
+				tenant, _ := auth.SplitEndpoint(endpointID)
+				endpoint = &EndpointInfo{
+					EndpointID: endpointID,
+					Tenant:     tenant,
+				}
+				endpoints[endpointID] = endpoint
+			}
+			endpoint.Connections += listeners
+			endpoint.Nodes = append(endpoint.Nodes, EndpointNode{
+				NodeID:      node.ID,
+				Connections: listeners,
+			})
+		}
+	}
+
+	infos := make([]EndpointInfo, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		sort.Slice(endpoint.Nodes, func(i, j int) bool {
+			return endpoint.Nodes[i].NodeID < endpoint.Nodes[j].NodeID
+		})
+		infos = append(infos, *endpoint)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].EndpointID < infos[j].EndpointID
+	})
+	return infos
+}
+
 func (m *LoadBalancedManager) Usage() *Usage {
 	return m.usage
 }
@@ -177,3 +434,46 @@ func (m *LoadBalancedManager) Usage() *Usage {
 func (m *LoadBalancedManager) Metrics() *Metrics {
 	return m.metrics
 }
+
+func (m *LoadBalancedManager) connSubscribersLocked() []func(event ConnEvent) {
+	subscribers := make([]func(event ConnEvent), 0, len(m.connSubscribers))
+	for _, f := range m.connSubscribers {
+		subscribers = append(subscribers, f)
+	}
+	return subscribers
+}
+
+// notifyConn notifies the subscribers of a connection event. Only upstreams
+// connected to the local node are notified.
+func (m *LoadBalancedManager) notifyConn(
+	subscribers []func(event ConnEvent),
+	u Upstream,
+	connected bool,
+) {
+	conn, ok := u.(*ConnUpstream)
+	if !ok || len(subscribers) == 0 {
+		return
+	}
+
+	event := ConnEvent{
+		Connected: connected,
+		Conn:      m.connInfo(conn, time.Now()),
+	}
+	for _, f := range subscribers {
+		f(event)
+	}
+}
+
+func (m *LoadBalancedManager) connInfo(conn *ConnUpstream, now time.Time) ConnInfo {
+	tenant, _ := auth.SplitEndpoint(conn.EndpointID())
+	return ConnInfo{
+		ID:          conn.ID(),
+		EndpointID:  conn.EndpointID(),
+		Tenant:      tenant,
+		NodeID:      m.cluster.LocalID(),
+		ClientIP:    conn.ClientIP(),
+		ConnectedAt: conn.ConnectedAt(),
+		Version:     conn.Version(),
+		AgeSeconds:  int64(now.Sub(conn.ConnectedAt()).Seconds()),
+	}
+}
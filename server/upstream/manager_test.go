@@ -1,9 +1,16 @@
 package upstream
 
 import (
+	"context"
 	"net"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -58,3 +65,85 @@ func TestLocalLoadBalancer(t *testing.T) {
 
 	assert.Nil(t, lb.Next())
 }
+
+func TestLocalLoadBalancer_MaxRequests(t *testing.T) {
+	lb := &loadBalancer{}
+
+	u1 := NewConnUpstream("1", "", nil)
+	u1.SetMaxRequests(1)
+	u2 := NewConnUpstream("2", "", nil)
+	u2.SetMaxRequests(1)
+	lb.Add(u1)
+	lb.Add(u2)
+
+	// Upstreams at their limit are skipped.
+	assert.True(t, u1.Acquire(context.Background()))
+	assert.Equal(t, "2", lb.Next().EndpointID())
+	assert.Equal(t, "2", lb.Next().EndpointID())
+
+	// If all upstreams are at their limit, fall back to round-robin.
+	assert.True(t, u2.Acquire(context.Background()))
+	assert.Equal(t, "1", lb.Next().EndpointID())
+	assert.Equal(t, "2", lb.Next().EndpointID())
+
+	// Requests wait for capacity.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.False(t, u1.Acquire(ctx))
+
+	u1.Release()
+	assert.True(t, u1.Available())
+	assert.False(t, u2.Available())
+	assert.Equal(t, "1", lb.Next().EndpointID())
+}
+
+func TestLoadBalancedManager_Metrics(t *testing.T) {
+	state := cluster.NewState(&cluster.Node{
+		ID: "local",
+	}, log.NewNopLogger())
+	manager := NewLoadBalancedManager(state)
+	metrics := manager.Metrics()
+
+	u1 := &fakeUpstream{endpointID: "my-endpoint"}
+	u2 := &fakeUpstream{endpointID: "my-endpoint"}
+	manager.AddConn(u1)
+	manager.AddConn(u2)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(
+		metrics.EndpointUpstreams.With(prometheus.Labels{
+			"endpoint_id": "my-endpoint",
+		}),
+	))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.UpstreamConnectsTotal))
+
+	manager.RemoveConn(u1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(
+		metrics.EndpointUpstreams.With(prometheus.Labels{
+			"endpoint_id": "my-endpoint",
+		}),
+	))
+
+	// Once the endpoint has no upstreams its label is removed.
+	manager.RemoveConn(u2)
+	assert.Equal(t, 0, testutil.CollectAndCount(metrics.EndpointUpstreams))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.UpstreamDisconnectsTotal))
+}
+
+func BenchmarkLoadBalancedManager_Select(b *testing.B) {
+	state := cluster.NewState(&cluster.Node{
+		ID: "local",
+	}, log.NewNopLogger())
+	manager := NewLoadBalancedManager(state)
+	for i := 0; i != 1000; i++ {
+		manager.AddConn(&fakeUpstream{endpointID: "endpoint-" + strconv.Itoa(i)})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, ok := manager.Select("endpoint-"+strconv.Itoa(i%1000), false); !ok {
+			b.Fatal("upstream not found")
+		}
+	}
+}
@@ -9,6 +9,18 @@ type Metrics struct {
 	// RegisteredEndpoints is the number of endpoints registered to this node.
 	RegisteredEndpoints prometheus.Gauge
 
+	// EndpointUpstreams is the number of upstreams connected to this node,
+	// labelled by endpoint ID.
+	EndpointUpstreams *prometheus.GaugeVec
+
+	// UpstreamConnectsTotal is the number of upstreams that have connected
+	// to this node.
+	UpstreamConnectsTotal prometheus.Counter
+
+	// UpstreamDisconnectsTotal is the number of upstreams that have
+	// disconnected from this node.
+	UpstreamDisconnectsTotal prometheus.Counter
+
 	// UpstreamRequestsTotal is the number of requests sent to an
 	// upstream connected to the local node.
 	UpstreamRequestsTotal prometheus.Counter
@@ -16,6 +28,10 @@ type Metrics struct {
 	// RemoteRequestsTotal is the number of requests sent to another node.
 	// Labelled by target node ID.
 	RemoteRequestsTotal *prometheus.CounterVec
+
+	// RebalancedUpstreamsTotal is the number of upstream connections closed
+	// to rebalance upstreams across the cluster.
+	RebalancedUpstreamsTotal prometheus.Counter
 }
 
 func NewMetrics() *Metrics {
@@ -36,6 +52,31 @@ func NewMetrics() *Metrics {
 				Help:      "Number of endpoints registered to this node",
 			},
 		),
+		EndpointUpstreams: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "piko",
+				Subsystem: "upstreams",
+				Name:      "endpoint_upstreams",
+				Help:      "Number of upstreams connected to this node by endpoint",
+			},
+			[]string{"endpoint_id"},
+		),
+		UpstreamConnectsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "upstreams",
+				Name:      "upstream_connects_total",
+				Help:      "Number of upstreams that have connected to this node",
+			},
+		),
+		UpstreamDisconnectsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "upstreams",
+				Name:      "upstream_disconnects_total",
+				Help:      "Number of upstreams that have disconnected from this node",
+			},
+		),
 		UpstreamRequestsTotal: prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Namespace: "piko",
@@ -53,6 +94,14 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"node_id"},
 		),
+		RebalancedUpstreamsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "upstreams",
+				Name:      "rebalanced_upstreams_total",
+				Help:      "Number of upstream connections closed to rebalance upstreams across the cluster",
+			},
+		),
 	}
 }
 
@@ -60,7 +109,53 @@ func (m *Metrics) Register(registry *prometheus.Registry) {
 	registry.MustRegister(
 		m.ConnectedUpstreams,
 		m.RegisteredEndpoints,
+		m.EndpointUpstreams,
+		m.UpstreamConnectsTotal,
+		m.UpstreamDisconnectsTotal,
 		m.UpstreamRequestsTotal,
 		m.RemoteRequestsTotal,
+		m.RebalancedUpstreamsTotal,
+	)
+}
+
+// ServerMetrics contains metrics for the upstream server, which accepts
+// upstream connections.
+type ServerMetrics struct {
+	// RegistrationFailuresTotal is the number of upstream connections
+	// rejected by the server. Labelled by the failure reason.
+	RegistrationFailuresTotal *prometheus.CounterVec
+
+	// HeartbeatRTT is the round-trip time of heartbeats sent to connected
+	// upstreams.
+	HeartbeatRTT prometheus.Histogram
+}
+
+func NewServerMetrics() *ServerMetrics {
+	return &ServerMetrics{
+		RegistrationFailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "upstreams",
+				Name:      "registration_failures_total",
+				Help:      "Number of upstream connections rejected by this node",
+			},
+			[]string{"reason"},
+		),
+		HeartbeatRTT: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: "piko",
+				Subsystem: "upstreams",
+				Name:      "heartbeat_rtt_seconds",
+				Help:      "Round-trip time of heartbeats sent to connected upstreams",
+				Buckets:   prometheus.DefBuckets,
+			},
+		),
+	}
+}
+
+func (m *ServerMetrics) Register(registry *prometheus.Registry) {
+	registry.MustRegister(
+		m.RegistrationFailuresTotal,
+		m.HeartbeatRTT,
 	)
 }
@@ -0,0 +1,109 @@
+package upstream
+
+import (
+	"math"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+	"go.uber.org/zap"
+)
+
+// Rebalancer rebalances upstream connections across the nodes in the
+// cluster.
+//
+// When a new node joins the cluster it has no connected upstreams, so without
+// rebalancing the load would only converge as upstreams naturally reconnect.
+// Instead, when a node joins, if the local node has more than its share of
+// upstreams it gracefully closes a fraction of its connections. Those
+// upstreams then reconnect, and are likely to be routed to the new node.
+type Rebalancer struct {
+	manager *LoadBalancedManager
+
+	cluster *cluster.State
+
+	conf config.RebalanceConfig
+
+	logger log.Logger
+}
+
+func NewRebalancer(
+	manager *LoadBalancedManager,
+	cluster *cluster.State,
+	conf config.RebalanceConfig,
+	logger log.Logger,
+) *Rebalancer {
+	r := &Rebalancer{
+		manager: manager,
+		cluster: cluster,
+		conf:    conf,
+		logger:  logger.WithSubsystem("upstream.rebalancer"),
+	}
+	if conf.Threshold > 0 {
+		cluster.OnNodeAdded(r.onNodeAdded)
+	}
+	return r
+}
+
+// Rebalance sheds upstream connections if the local node has more than its
+// share of connected upstreams.
+//
+// Returns the number of shed connections.
+func (r *Rebalancer) Rebalance() int {
+	shed := r.shedCount()
+	if shed == 0 {
+		return 0
+	}
+
+	shed = r.manager.Shed(shed)
+	r.manager.Metrics().RebalancedUpstreamsTotal.Add(float64(shed))
+
+	r.logger.Info("rebalanced upstreams", zap.Int("shed", shed))
+
+	return shed
+}
+
+// shedCount returns the number of connections the local node should shed.
+func (r *Rebalancer) shedCount() int {
+	var local, total, nodes int
+	for _, node := range r.cluster.Nodes() {
+		if node.Status != cluster.NodeStatusActive || node.Draining || !node.AcceptsUpstreams() {
+			// Ignore nodes that can't accept upstreams.
+			continue
+		}
+
+		upstreams := 0
+		for _, listeners := range node.Endpoints {
+			upstreams += listeners
+		}
+
+		if node.ID == r.cluster.LocalID() {
+			local = upstreams
+		}
+		total += upstreams
+		nodes++
+	}
+
+	if nodes == 0 || local < r.conf.MinConns {
+		return 0
+	}
+
+	average := float64(total) / float64(nodes)
+	if float64(local) <= average*(1+r.conf.Threshold) {
+		return 0
+	}
+
+	shed := int(float64(local) - average)
+	maxShed := int(math.Ceil(float64(local) * r.conf.ShedRate))
+	if shed > maxShed {
+		shed = maxShed
+	}
+	return shed
+}
+
+func (r *Rebalancer) onNodeAdded(nodeID string) {
+	r.logger.Debug("node added; rebalancing", zap.String("node-id", nodeID))
+
+	// Rebalance in the background as the callback must not block.
+	go r.Rebalance()
+}
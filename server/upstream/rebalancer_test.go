@@ -0,0 +1,177 @@
+package upstream
+
+import (
+	"testing"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type closableUpstream struct {
+	fakeUpstream
+
+	manager *LoadBalancedManager
+	closed  bool
+}
+
+func (u *closableUpstream) Close() error {
+	u.closed = true
+	u.manager.RemoveConn(u)
+	return nil
+}
+
+func TestRebalancer_Rebalance(t *testing.T) {
+	t.Run("shed", func(t *testing.T) {
+		state := cluster.NewState(&cluster.Node{
+			ID: "local",
+		}, log.NewNopLogger())
+		manager := NewLoadBalancedManager(state)
+
+		var upstreams []*closableUpstream
+		for i := 0; i != 10; i++ {
+			u := &closableUpstream{
+				fakeUpstream: fakeUpstream{endpointID: "my-endpoint"},
+				manager:      manager,
+			}
+			manager.AddConn(u)
+			upstreams = append(upstreams, u)
+		}
+
+		state.AddNode(&cluster.Node{
+			ID:     "remote",
+			Status: cluster.NodeStatusActive,
+		})
+
+		rebalancer := NewRebalancer(manager, state, config.RebalanceConfig{
+			Threshold: 0.2,
+			ShedRate:  1,
+		}, log.NewNopLogger())
+
+		// The local node has 10 upstreams and the remote has 0, so should
+		// shed 5.
+		assert.Equal(t, 5, rebalancer.Rebalance())
+		assert.Equal(t, 5, manager.Endpoints()["my-endpoint"])
+
+		closed := 0
+		for _, u := range upstreams {
+			if u.closed {
+				closed++
+			}
+		}
+		assert.Equal(t, 5, closed)
+	})
+
+	t.Run("ignore proxy nodes", func(t *testing.T) {
+		state := cluster.NewState(&cluster.Node{
+			ID: "local",
+		}, log.NewNopLogger())
+		manager := NewLoadBalancedManager(state)
+
+		for i := 0; i != 10; i++ {
+			manager.AddConn(&closableUpstream{
+				fakeUpstream: fakeUpstream{endpointID: "my-endpoint"},
+				manager:      manager,
+			})
+		}
+
+		state.AddNode(&cluster.Node{
+			ID:     "remote",
+			Status: cluster.NodeStatusActive,
+			Role:   cluster.NodeRoleProxy,
+		})
+
+		rebalancer := NewRebalancer(manager, state, config.RebalanceConfig{
+			Threshold: 0.2,
+			ShedRate:  1,
+		}, log.NewNopLogger())
+
+		// The remote node doesn't accept upstreams so shouldn't shed.
+		assert.Equal(t, 0, rebalancer.Rebalance())
+		assert.Equal(t, 10, manager.Endpoints()["my-endpoint"])
+	})
+
+	t.Run("shed rate", func(t *testing.T) {
+		state := cluster.NewState(&cluster.Node{
+			ID: "local",
+		}, log.NewNopLogger())
+		manager := NewLoadBalancedManager(state)
+
+		for i := 0; i != 10; i++ {
+			manager.AddConn(&closableUpstream{
+				fakeUpstream: fakeUpstream{endpointID: "my-endpoint"},
+				manager:      manager,
+			})
+		}
+
+		state.AddNode(&cluster.Node{
+			ID:     "remote",
+			Status: cluster.NodeStatusActive,
+		})
+
+		rebalancer := NewRebalancer(manager, state, config.RebalanceConfig{
+			Threshold: 0.2,
+			ShedRate:  0.2,
+		}, log.NewNopLogger())
+
+		assert.Equal(t, 2, rebalancer.Rebalance())
+	})
+
+	t.Run("balanced", func(t *testing.T) {
+		state := cluster.NewState(&cluster.Node{
+			ID: "local",
+		}, log.NewNopLogger())
+		manager := NewLoadBalancedManager(state)
+
+		for i := 0; i != 10; i++ {
+			manager.AddConn(&closableUpstream{
+				fakeUpstream: fakeUpstream{endpointID: "my-endpoint"},
+				manager:      manager,
+			})
+		}
+
+		state.AddNode(&cluster.Node{
+			ID:     "remote",
+			Status: cluster.NodeStatusActive,
+			Endpoints: map[string]int{
+				"my-endpoint": 9,
+			},
+		})
+
+		rebalancer := NewRebalancer(manager, state, config.RebalanceConfig{
+			Threshold: 0.2,
+			ShedRate:  1,
+		}, log.NewNopLogger())
+
+		assert.Equal(t, 0, rebalancer.Rebalance())
+		assert.Equal(t, 10, manager.Endpoints()["my-endpoint"])
+	})
+
+	t.Run("min conns", func(t *testing.T) {
+		state := cluster.NewState(&cluster.Node{
+			ID: "local",
+		}, log.NewNopLogger())
+		manager := NewLoadBalancedManager(state)
+
+		for i := 0; i != 4; i++ {
+			manager.AddConn(&closableUpstream{
+				fakeUpstream: fakeUpstream{endpointID: "my-endpoint"},
+				manager:      manager,
+			})
+		}
+
+		state.AddNode(&cluster.Node{
+			ID:     "remote",
+			Status: cluster.NodeStatusActive,
+		})
+
+		rebalancer := NewRebalancer(manager, state, config.RebalanceConfig{
+			Threshold: 0.2,
+			ShedRate:  1,
+			MinConns:  5,
+		}, log.NewNopLogger())
+
+		assert.Equal(t, 0, rebalancer.Rebalance())
+	})
+}
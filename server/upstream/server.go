@@ -5,35 +5,102 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/andydunstall/piko/pkg/compress"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/tunnel"
 	pikowebsocket "github.com/andydunstall/piko/pkg/websocket"
 	"github.com/andydunstall/piko/server/auth"
+	"github.com/andydunstall/piko/server/quota"
+	"github.com/andydunstall/piko/server/revocation"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/hashicorp/yamux"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+const (
+	// goAwayTimeout is the maximum time to wait for in-flight requests to
+	// an upstream to complete when gracefully closing the connection.
+	goAwayTimeout = time.Second * 30
+
+	// goAwayPollInterval is the interval to check whether an upstream being
+	// closed still has in-flight requests.
+	goAwayPollInterval = time.Millisecond * 100
+
+	// controlWriteTimeout is the timeout writing a control message to an
+	// upstream.
+	controlWriteTimeout = time.Second * 10
+)
+
+var errTokenRevoked = errors.New("token revoked")
+
 // Server accepts connections from upstream services.
 type Server struct {
 	upstreams Manager
 
+	// bans contains upstreams banned from connecting. May be nil.
+	bans *BanList
+
+	// approvals contains the endpoints approved to receive traffic. If nil,
+	// approval isn't required.
+	approvals *ApprovalList
+
+	// quotas enforces the endpoint and upstream quotas of each tenant. If
+	// nil, tenants aren't limited.
+	quotas *quota.Quotas
+
+	// revocations contains the revoked tokens, used to close upstreams
+	// whose token is revoked after connecting. May be nil.
+	revocations *revocation.List
+
+	router *gin.Engine
+
 	httpServer *http.Server
 
 	websocketUpgrader *websocket.Upgrader
 
-	ctx    context.Context
-	cancel func()
+	// closeCtx is cancelled when the server shuts down to close the
+	// connected upstreams.
+	closeCtx context.Context
+	cancel   func()
+
+	// drainCtx is cancelled when the server is draining to close the
+	// connected upstreams.
+	drainCtx    context.Context
+	drainCancel func()
+
+	// maxConns is the maximum number of connected upstreams, or 0 if there
+	// is no limit.
+	maxConns int64
+	conns    atomic.Int64
+
+	// maxRequests is the maximum number of concurrent requests to each
+	// connected upstream, or 0 if there is no limit.
+	maxRequests int
+
+	// heartbeatInterval and heartbeatTimeout configure the heartbeats sent
+	// to connected upstreams. If zero the yamux defaults are used.
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+
+	metrics *ServerMetrics
 
 	logger log.Logger
 }
 
 func NewServer(
 	upstreams Manager,
+	bans *BanList,
 	verifier auth.Verifier,
 	tlsConfig *tls.Config,
 	logger log.Logger,
@@ -41,17 +108,27 @@ func NewServer(
 	logger = logger.WithSubsystem("admin")
 
 	router := gin.New()
+	// By default don't trust any proxies, so the client IP used to ban
+	// upstreams can't be spoofed using the 'X-Forwarded-For' header.
+	_ = router.SetTrustedProxies(nil)
+
 	ctx, cancel := context.WithCancel(context.Background())
+	drainCtx, drainCancel := context.WithCancel(ctx)
 	server := &Server{
 		upstreams: upstreams,
+		bans:      bans,
+		router:    router,
 		httpServer: &http.Server{
 			Handler:   router,
 			TLSConfig: tlsConfig,
 			ErrorLog:  logger.StdLogger(zapcore.WarnLevel),
 		},
 		websocketUpgrader: &websocket.Upgrader{},
-		ctx:               ctx,
+		closeCtx:          ctx,
 		cancel:            cancel,
+		drainCtx:          drainCtx,
+		drainCancel:       drainCancel,
+		metrics:           NewServerMetrics(),
 		logger:            logger,
 	}
 
@@ -60,7 +137,14 @@ func NewServer(
 
 	if verifier != nil {
 		authMiddleware := NewAuthMiddleware(verifier, logger)
-		router.Use(authMiddleware.VerifyEndpointToken)
+		router.Use(func(c *gin.Context) {
+			authMiddleware.VerifyEndpointToken(c)
+			// The route never aborts, so the request is only aborted if
+			// the token is rejected.
+			if c.IsAborted() {
+				server.registrationFailed("unauthorized")
+			}
+		})
 	}
 
 	server.registerRoutes(router)
@@ -96,11 +180,124 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return err
 }
 
+func (s *Server) Metrics() *ServerMetrics {
+	return s.metrics
+}
+
+// SetMaxConnections limits the number of connected upstreams. Upstreams
+// exceeding the limit are rejected with a retryable status. A limit of 0
+// means there is no limit.
+//
+// Must be called before serving.
+func (s *Server) SetMaxConnections(max int) {
+	s.maxConns = int64(max)
+}
+
+// SetMaxRequestsPerConnection limits the number of concurrent requests to
+// each connected upstream. A limit of 0 means there is no limit.
+//
+// Must be called before serving.
+func (s *Server) SetMaxRequestsPerConnection(max int) {
+	s.maxRequests = max
+}
+
+// SetHeartbeat configures the interval to send heartbeats to connected
+// upstreams, and the timeout waiting for a heartbeat response before closing
+// the connection.
+//
+// Must be called before serving.
+func (s *Server) SetHeartbeat(interval time.Duration, timeout time.Duration) {
+	s.heartbeatInterval = interval
+	s.heartbeatTimeout = timeout
+}
+
+// SetApprovals requires endpoints to be approved before their upstreams
+// receive traffic. Upstreams for endpoints that aren't approved are held
+// as pending until the endpoint is approved.
+//
+// Must be called before serving.
+func (s *Server) SetApprovals(approvals *ApprovalList) {
+	s.approvals = approvals
+}
+
+// SetQuotas limits the number of endpoints and upstreams each tenant may
+// register.
+//
+// Must be called before serving.
+func (s *Server) SetQuotas(quotas *quota.Quotas) {
+	s.quotas = quotas
+}
+
+// SetTrustedProxies sets the proxies trusted to set the upstream client IP
+// in the 'X-Forwarded-For' header. The client IP is used to ban upstreams.
+//
+// Must be called before serving.
+func (s *Server) SetTrustedProxies(proxies []string) error {
+	return s.router.SetTrustedProxies(proxies)
+}
+
+// SetRevocations closes connected upstreams when their token is revoked.
+//
+// Must be called before serving.
+func (s *Server) SetRevocations(revocations *revocation.List) {
+	s.revocations = revocations
+}
+
+// Drain rejects new upstream connections and gracefully closes the existing
+// connected upstreams, so they reconnect to another node in the cluster.
+func (s *Server) Drain() {
+	s.drainCancel()
+}
+
+// Conns returns the number of open upstream connections, including
+// connections being gracefully closed.
+func (s *Server) Conns() int {
+	return int(s.conns.Load())
+}
+
 // upstreamRoute handles WebSocket connections from upstream services.
 func (s *Server) upstreamRoute(c *gin.Context) {
 	endpointID := c.Param("endpointID")
 
 	token, ok := c.Get(TokenContextKey)
+
+	// Endpoints registered with a tenant token are scoped to the tenant, so
+	// tenants can use the same endpoint IDs without conflicting.
+	scopedID := endpointID
+	if ok {
+		scopedID = auth.ScopeEndpoint(
+			token.(*auth.EndpointToken).Tenant, endpointID,
+		)
+	}
+
+	if s.drainCtx.Err() != nil {
+		s.registrationFailed("draining")
+
+		// Reply with a retryable status so the upstream reconnects to
+		// another node.
+		c.JSON(
+			http.StatusServiceUnavailable,
+			gin.H{"error": "node draining"},
+		)
+		return
+	}
+
+	if s.bans != nil {
+		if expiry, banned := s.bans.Banned(scopedID, c.ClientIP()); banned {
+			s.registrationFailed("banned")
+
+			// Reply with a retryable status so the upstream reconnects
+			// once the ban expires.
+			retryAfter := int(math.Ceil(time.Until(expiry).Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(
+				http.StatusTooManyRequests,
+				gin.H{"error": "upstream banned"},
+			)
+			return
+		}
+	}
+
 	if ok {
 		endpointToken := token.(*auth.EndpointToken)
 		if !endpointToken.EndpointPermitted(endpointID) {
@@ -109,6 +306,8 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 				zap.Strings("token-endpoints", endpointToken.Endpoints),
 				zap.String("endpoint-id", endpointID),
 			)
+			s.registrationFailed("endpoint_not_permitted")
+
 			c.JSON(
 				http.StatusUnauthorized,
 				gin.H{"error": "endpoint not permitted"},
@@ -117,10 +316,79 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 		}
 	}
 
-	wsConn, err := s.websocketUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if s.quotas != nil {
+		remove, err := s.quotas.AddUpstream(scopedID)
+		if err != nil {
+			s.logger.Warn(
+				"tenant quota exceeded",
+				zap.String("endpoint-id", scopedID),
+				zap.Error(err),
+			)
+			s.registrationFailed("quota_exceeded")
+
+			var quotaErr *quota.Error
+			if errors.As(err, &quotaErr) {
+				quotaErr.WriteResponse(c.Writer)
+			} else {
+				c.JSON(
+					http.StatusTooManyRequests,
+					gin.H{"error": "quota exceeded"},
+				)
+			}
+			return
+		}
+		defer remove()
+	}
+
+	if n := s.conns.Add(1); s.maxConns > 0 && n > s.maxConns {
+		s.conns.Add(-1)
+		s.registrationFailed("max_connections")
+
+		// Reply with a retryable status so the upstream reconnects, either
+		// to another node or once there is capacity.
+		c.JSON(
+			http.StatusServiceUnavailable,
+			gin.H{"error": "too many upstream connections"},
+		)
+		return
+	}
+	defer s.conns.Add(-1)
+
+	version, err := tunnel.NegotiateVersion(
+		c.Request.Header.Get(tunnel.VersionHeader),
+	)
+	if err != nil {
+		s.logger.Warn(
+			"unsupported protocol version",
+			zap.String("endpoint-id", scopedID),
+			zap.Error(err),
+		)
+		s.registrationFailed("unsupported_version")
+
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "unsupported protocol version"},
+		)
+		return
+	}
+
+	responseHeader := http.Header{}
+	responseHeader.Set(tunnel.VersionHeader, strconv.Itoa(version))
+
+	// The upstream may request compression for requests sent to it, in
+	// which case respond with the negotiated encoding.
+	compression := compress.Negotiate(
+		c.Request.Header.Get(compress.TunnelAcceptEncodingHeader),
+	)
+	if compression != "" {
+		responseHeader.Set(compress.TunnelEncodingHeader, compression)
+	}
+
+	wsConn, err := s.websocketUpgrader.Upgrade(c.Writer, c.Request, responseHeader)
 	if err != nil {
 		// Upgrade replies to the client so nothing else to do.
 		s.logger.Warn("failed to upgrade websocket", zap.Error(err))
+		s.registrationFailed("upgrade")
 		return
 	}
 	conn := pikowebsocket.New(wsConn)
@@ -128,16 +396,18 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 
 	s.logger.Info(
 		"upstream connected",
-		zap.String("endpoint-id", endpointID),
+		zap.String("endpoint-id", scopedID),
 		zap.String("client-ip", c.ClientIP()),
+		zap.Int("version", version),
+		zap.String("compression", compression),
 	)
 	defer s.logger.Info(
 		"upstream disconnected",
-		zap.String("endpoint-id", endpointID),
+		zap.String("endpoint-id", scopedID),
 		zap.String("client-ip", c.ClientIP()),
 	)
 
-	ctx := s.ctx
+	ctx := s.drainCtx
 	if ok {
 		// If the token has an expiry, then we ensure we close the connection
 		// to the endpoint once the token expires.
@@ -147,9 +417,34 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 			ctx, cancel = context.WithDeadline(ctx, endpointToken.Expiry)
 			defer cancel()
 		}
+
+		// If the token is revoked, we close the connection to the endpoint.
+		if s.revocations != nil && endpointToken.ID != "" {
+			revoked, stopWaiting := s.revocations.Wait(endpointToken.ID)
+			defer stopWaiting()
+
+			var cancel context.CancelCauseFunc
+			ctx, cancel = context.WithCancelCause(ctx)
+			defer cancel(nil)
+			go func() {
+				select {
+				case <-revoked:
+					cancel(errTokenRevoked)
+				case <-ctx.Done():
+				}
+			}()
+		}
 	}
 
 	muxConfig := yamux.DefaultConfig()
+	// Note yamux closes the session if a heartbeat isn't acknowledged within
+	// the connection write timeout.
+	if s.heartbeatInterval != 0 {
+		muxConfig.KeepAliveInterval = s.heartbeatInterval
+	}
+	if s.heartbeatTimeout != 0 {
+		muxConfig.ConnectionWriteTimeout = s.heartbeatTimeout
+	}
 	muxConfig.Logger = s.logger.StdLogger(zap.WarnLevel)
 	muxConfig.LogOutput = nil
 	sess, err := yamux.Server(conn, muxConfig)
@@ -159,32 +454,214 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 	}
 	defer sess.Close()
 
-	upstream := NewConnUpstream(endpointID, sess)
+	go s.heartbeat(sess, muxConfig.KeepAliveInterval)
+
+	if s.approvals != nil && !s.waitForApproval(ctx, sess, scopedID, c.ClientIP()) {
+		return
+	}
+
+	upstream := NewConnUpstream(scopedID, c.ClientIP(), sess)
+	upstream.SetVersion(version)
+	upstream.SetCompression(compression)
+	upstream.SetMaxRequests(s.maxRequests)
 
 	s.upstreams.AddConn(upstream)
-	defer s.upstreams.RemoveConn(upstream)
+	var removeOnce sync.Once
+	remove := func() {
+		removeOnce.Do(func() {
+			s.upstreams.RemoveConn(upstream)
+		})
+	}
+	defer remove()
+
+	acceptErrCh := make(chan error, 1)
+	go func() {
+		acceptErrCh <- s.acceptStreams(sess, upstream)
+	}()
 
+	select {
+	case err := <-acceptErrCh:
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if errors.Is(err, yamux.ErrSessionShutdown) {
+			// Session already closed.
+			return
+		}
+		s.logger.Warn("session closed unexpectedly", zap.Error(err))
+	case <-upstream.GoAwayChan():
+		// Closed by the server, such as when rebalancing.
+		s.goAway(sess, upstream, remove)
+	case <-ctx.Done():
+		if errors.Is(context.Cause(ctx), errTokenRevoked) {
+			s.logger.Info("upstream token revoked")
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			s.logger.Info("upstream token expired")
+			return
+		}
+		if s.closeCtx.Err() != nil {
+			// Server shutdown.
+			return
+		}
+		// Server draining.
+		s.goAway(sess, upstream, remove)
+	}
+}
+
+// acceptStreams accepts streams opened by the upstream until the session is
+// closed. The upstream only opens a control stream, if supported by the
+// negotiated protocol version.
+func (s *Server) acceptStreams(sess *yamux.Session, upstream *ConnUpstream) error {
 	for {
-		// The client will never open streams but block on accept to wait for
-		// close or an error.
-		if _, err := sess.AcceptStreamWithContext(ctx); err != nil {
-			if errors.Is(err, net.ErrClosed) {
-				return
-			}
-			if errors.Is(err, context.Canceled) {
-				// Server shutdown.
-				return
-			}
-			if errors.Is(err, context.DeadlineExceeded) {
-				s.logger.Info("upstream token expired")
-				return
+		stream, err := sess.AcceptStream()
+		if err != nil {
+			return err
+		}
+
+		if upstream.Version() < tunnel.ControlVersion {
+			stream.Close()
+			continue
+		}
+		ok, err := upstream.SetControl(stream)
+		if !ok {
+			s.logger.Warn(
+				"upstream opened multiple control streams",
+				zap.String("endpoint-id", upstream.EndpointID()),
+			)
+			stream.Close()
+			continue
+		}
+		if err != nil {
+			s.logger.Warn(
+				"failed to send upstream go away",
+				zap.String("endpoint-id", upstream.EndpointID()),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// goAway gracefully closes the connection to the upstream.
+//
+// The upstream is removed so no new requests are routed to it, and is asked
+// to reconnect (possibly to another node in the cluster). Once in-flight
+// requests to the upstream complete, or after goAwayTimeout, the session is
+// closed. Upstreams that don't support a control stream reconnect once the
+// session is closed.
+func (s *Server) goAway(
+	sess *yamux.Session,
+	upstream *ConnUpstream,
+	remove func(),
+) {
+	remove()
+
+	s.logger.Info(
+		"closing upstream",
+		zap.String("endpoint-id", upstream.EndpointID()),
+		zap.String("client-ip", upstream.ClientIP()),
+	)
+
+	if _, err := upstream.GoAway(); err != nil {
+		s.logger.Warn(
+			"failed to send upstream go away",
+			zap.String("endpoint-id", upstream.EndpointID()),
+			zap.Error(err),
+		)
+	}
+
+	timeout := time.NewTimer(goAwayTimeout)
+	defer timeout.Stop()
+
+	ticker := time.NewTicker(goAwayPollInterval)
+	defer ticker.Stop()
+
+	for upstream.Streams() > 0 {
+		select {
+		case <-ticker.C:
+		case <-timeout.C:
+			s.logger.Warn(
+				"upstream close timeout; closing with in-flight requests",
+				zap.String("endpoint-id", upstream.EndpointID()),
+				zap.Int("streams", upstream.Streams()),
+			)
+			return
+		case <-sess.CloseChan():
+			return
+		case <-s.closeCtx.Done():
+			return
+		}
+	}
+}
+
+// waitForApproval waits for the endpoint to be approved before the upstream
+// is added. Returns false if the upstream disconnects or the server closes
+// while waiting.
+func (s *Server) waitForApproval(
+	ctx context.Context,
+	sess *yamux.Session,
+	endpointID string,
+	clientIP string,
+) bool {
+	approved, cancel := s.approvals.Wait(endpointID, clientIP)
+	defer cancel()
+
+	select {
+	case <-approved:
+		return true
+	default:
+	}
+
+	s.logger.Info(
+		"upstream pending approval",
+		zap.String("endpoint-id", endpointID),
+		zap.String("client-ip", clientIP),
+	)
+
+	select {
+	case <-approved:
+		s.logger.Info(
+			"upstream approved",
+			zap.String("endpoint-id", endpointID),
+			zap.String("client-ip", clientIP),
+		)
+		return true
+	case <-sess.CloseChan():
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// heartbeat periodically pings the upstream to record the round-trip time,
+// until the session is closed.
+func (s *Server) heartbeat(sess *yamux.Session, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rtt, err := sess.Ping()
+			if err != nil {
+				// The session will be closed by yamux if the upstream
+				// doesn't respond.
+				continue
 			}
-			s.logger.Warn("session closed unexpectedly", zap.Error(err))
+			s.metrics.HeartbeatRTT.Observe(rtt.Seconds())
+		case <-sess.CloseChan():
 			return
 		}
 	}
 }
 
+func (s *Server) registrationFailed(reason string) {
+	s.metrics.RegistrationFailuresTotal.With(prometheus.Labels{
+		"reason": reason,
+	}).Inc()
+}
+
 func (s *Server) registerRoutes(router *gin.Engine) {
 	piko := router.Group("/piko/v1")
 	piko.GET("/upstream/:endpointID", s.upstreamRoute)
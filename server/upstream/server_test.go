@@ -4,14 +4,21 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"testing"
 	"time"
 
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/pkg/testutil"
+	"github.com/andydunstall/piko/pkg/tunnel"
 	"github.com/andydunstall/piko/pkg/websocket"
 	"github.com/andydunstall/piko/server/auth"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/quota"
+	"github.com/hashicorp/yamux"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -47,7 +54,7 @@ func TestServer_Register(t *testing.T) {
 
 		manager := newFakeManager()
 
-		s := NewServer(manager, nil, nil, log.NewNopLogger())
+		s := NewServer(manager, nil, nil, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -69,6 +76,93 @@ func TestServer_Register(t *testing.T) {
 		assert.Equal(t, "my-endpoint", removedUpstream.EndpointID())
 	})
 
+	t.Run("compression", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		s := NewServer(manager, nil, nil, nil, log.NewNopLogger())
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+
+		// Unsupported encodings are skipped.
+		conn, err := websocket.Dial(
+			context.TODO(), url,
+			websocket.WithHeader("x-piko-tunnel-accept-encoding", "br, zstd"),
+		)
+		require.NoError(t, err)
+
+		addedUpstream := <-manager.addConnCh
+		assert.Equal(t, "zstd", addedUpstream.(*ConnUpstream).Compression())
+
+		conn.Close()
+		<-manager.removeConnCh
+
+		conn, err = websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+
+		addedUpstream = <-manager.addConnCh
+		assert.Equal(t, "", addedUpstream.(*ConnUpstream).Compression())
+
+		conn.Close()
+		<-manager.removeConnCh
+	})
+
+	t.Run("version", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		s := NewServer(manager, nil, nil, nil, log.NewNopLogger())
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+
+		// Upstreams without a version use version 1.
+		conn, err := websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+
+		addedUpstream := <-manager.addConnCh
+		assert.Equal(t, 1, addedUpstream.(*ConnUpstream).Version())
+
+		conn.Close()
+		<-manager.removeConnCh
+
+		// Upstreams with a newer version use the latest supported version.
+		conn, err = websocket.Dial(
+			context.TODO(), url,
+			websocket.WithHeader("x-piko-tunnel-version", "100"),
+		)
+		require.NoError(t, err)
+
+		addedUpstream = <-manager.addConnCh
+		assert.Equal(t, tunnel.Version, addedUpstream.(*ConnUpstream).Version())
+
+		conn.Close()
+		<-manager.removeConnCh
+
+		_, err = websocket.Dial(
+			context.TODO(), url,
+			websocket.WithHeader("x-piko-tunnel-version", "0"),
+		)
+		assert.ErrorContains(t, err, "unsupported protocol version")
+	})
+
 	// Tests the server closes upstream connections when it is shutdown.
 	t.Run("close on shutdown", func(t *testing.T) {
 		ln, err := net.Listen("tcp", "127.0.0.1:0")
@@ -76,7 +170,7 @@ func TestServer_Register(t *testing.T) {
 
 		manager := newFakeManager()
 
-		s := NewServer(manager, nil, nil, log.NewNopLogger())
+		s := NewServer(manager, nil, nil, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -98,6 +192,444 @@ func TestServer_Register(t *testing.T) {
 		removedUpstream := <-manager.removeConnCh
 		assert.Equal(t, "my-endpoint", removedUpstream.EndpointID())
 	})
+
+	// Tests the server closes upstream connections and rejects new
+	// connections when draining.
+	t.Run("drain", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		s := NewServer(manager, nil, nil, nil, log.NewNopLogger())
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		conn, err := websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		addedUpstream := <-manager.addConnCh
+		assert.Equal(t, "my-endpoint", addedUpstream.EndpointID())
+
+		s.Drain()
+
+		removedUpstream := <-manager.removeConnCh
+		assert.Equal(t, "my-endpoint", removedUpstream.EndpointID())
+
+		_, err = websocket.Dial(context.TODO(), url)
+		require.ErrorContains(t, err, "503: node draining")
+
+		var retryableError *websocket.RetryableError
+		assert.ErrorAs(t, err, &retryableError)
+
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(
+			s.Metrics().RegistrationFailuresTotal.With(prometheus.Labels{
+				"reason": "draining",
+			}),
+		))
+	})
+
+	// Tests closing an upstream asks the upstream to reconnect, then closes
+	// the connection once in-flight requests complete.
+	t.Run("go away", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		s := NewServer(manager, nil, nil, nil, log.NewNopLogger())
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		conn, err := websocket.Dial(
+			context.TODO(), url,
+			websocket.WithHeader("x-piko-tunnel-version", "2"),
+		)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		sess, err := yamux.Client(conn, nil)
+		require.NoError(t, err)
+		defer sess.Close()
+
+		control, err := sess.OpenStream()
+		require.NoError(t, err)
+
+		addedUpstream := <-manager.addConnCh
+
+		// Open an in-flight request.
+		serverStream, err := addedUpstream.Dial()
+		require.NoError(t, err)
+		clientStream, err := sess.AcceptStream()
+		require.NoError(t, err)
+
+		require.NoError(t, addedUpstream.(*ConnUpstream).Close())
+
+		// The upstream must be removed so it receives no new requests.
+		removedUpstream := <-manager.removeConnCh
+		assert.Equal(t, "my-endpoint", removedUpstream.EndpointID())
+
+		b := make([]byte, 1)
+		_, err = io.ReadFull(control, b)
+		require.NoError(t, err)
+		assert.Equal(t, tunnel.ControlGoAway, b[0])
+
+		// The session must stay open until the request completes.
+		_, err = serverStream.Write([]byte("foo"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(clientStream, make([]byte, 3))
+		require.NoError(t, err)
+		assert.False(t, sess.IsClosed())
+
+		serverStream.Close()
+		clientStream.Close()
+
+		select {
+		case <-sess.CloseChan():
+		case <-time.After(time.Second):
+			t.Error("session not closed")
+		}
+	})
+
+	// Tests closing an upstream that doesn't support a control stream
+	// closes the connection once in-flight requests complete.
+	t.Run("go away version 1", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		s := NewServer(manager, nil, nil, nil, log.NewNopLogger())
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		conn, err := websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		sess, err := yamux.Client(conn, nil)
+		require.NoError(t, err)
+		defer sess.Close()
+
+		addedUpstream := <-manager.addConnCh
+		require.NoError(t, addedUpstream.(*ConnUpstream).Close())
+
+		<-manager.removeConnCh
+
+		select {
+		case <-sess.CloseChan():
+		case <-time.After(time.Second):
+			t.Error("session not closed")
+		}
+	})
+
+	// Tests the server rejects upstreams exceeding the connection limit with
+	// a retryable error.
+	t.Run("max connections", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		s := NewServer(manager, nil, nil, nil, log.NewNopLogger())
+		s.SetMaxConnections(1)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		conn, err := websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+
+		<-manager.addConnCh
+
+		_, err = websocket.Dial(context.TODO(), url)
+		require.ErrorContains(t, err, "503: too many upstream connections")
+
+		var retryableError *websocket.RetryableError
+		assert.ErrorAs(t, err, &retryableError)
+
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(
+			s.Metrics().RegistrationFailuresTotal.With(prometheus.Labels{
+				"reason": "max_connections",
+			}),
+		))
+
+		// Once the upstream disconnects, a new upstream can connect.
+		conn.Close()
+		<-manager.removeConnCh
+		require.Eventually(t, func() bool {
+			return s.conns.Load() == 0
+		}, time.Second, time.Millisecond)
+
+		conn, err = websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+
+		<-manager.addConnCh
+
+		conn.Close()
+		<-manager.removeConnCh
+	})
+
+	// Tests the server closes upstreams that don't respond to heartbeats.
+	t.Run("heartbeat timeout", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		s := NewServer(manager, nil, nil, nil, log.NewNopLogger())
+		s.SetHeartbeat(time.Millisecond*10, time.Millisecond*10)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		// Connect without a yamux session so heartbeats aren't
+		// acknowledged.
+		conn, err := websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		addedUpstream := <-manager.addConnCh
+		assert.Equal(t, "my-endpoint", addedUpstream.EndpointID())
+
+		select {
+		case removedUpstream := <-manager.removeConnCh:
+			assert.Equal(t, "my-endpoint", removedUpstream.EndpointID())
+		case <-time.After(time.Second):
+			t.Fatal("upstream not removed")
+		}
+	})
+
+	// Tests the server rejects banned upstreams with a retryable error.
+	t.Run("banned", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		bans := NewBanList()
+		bans.Ban("my-endpoint", "127.0.0.1", time.Minute)
+
+		s := NewServer(manager, bans, nil, nil, log.NewNopLogger())
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		_, err = websocket.Dial(context.TODO(), url)
+		require.ErrorContains(t, err, "429: upstream banned")
+
+		var retryableError *websocket.RetryableError
+		assert.ErrorAs(t, err, &retryableError)
+
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(
+			s.Metrics().RegistrationFailuresTotal.With(prometheus.Labels{
+				"reason": "banned",
+			}),
+		))
+
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(
+			s.Metrics().RegistrationFailuresTotal.With(prometheus.Labels{
+				"reason": "banned",
+			}),
+		))
+
+		// Other endpoints are not banned.
+		url = fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/other-endpoint",
+			ln.Addr().String(),
+		)
+		conn, err := websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+
+		addedUpstream := <-manager.addConnCh
+		assert.Equal(t, "other-endpoint", addedUpstream.EndpointID())
+
+		conn.Close()
+
+		removedUpstream := <-manager.removeConnCh
+		assert.Equal(t, "other-endpoint", removedUpstream.EndpointID())
+	})
+
+	// Tests the client IP used to check bans can't be spoofed using the
+	// 'X-Forwarded-For' header.
+	t.Run("banned spoofed forwarded for", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		bans := NewBanList()
+		bans.Ban("my-endpoint", "127.0.0.1", time.Minute)
+
+		s := NewServer(manager, bans, nil, nil, log.NewNopLogger())
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		_, err = websocket.Dial(
+			context.TODO(),
+			url,
+			websocket.WithHeader("X-Forwarded-For", "10.26.104.56"),
+			websocket.WithHeader("X-Real-IP", "10.26.104.56"),
+		)
+		require.ErrorContains(t, err, "429: upstream banned")
+	})
+
+	// Tests the client IP is read from 'X-Forwarded-For' when the connection
+	// is from a trusted proxy.
+	t.Run("banned trusted proxy", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		bans := NewBanList()
+		bans.Ban("my-endpoint", "10.26.104.56", time.Minute)
+
+		s := NewServer(manager, bans, nil, nil, log.NewNopLogger())
+		require.NoError(t, s.SetTrustedProxies([]string{"127.0.0.1"}))
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		_, err = websocket.Dial(
+			context.TODO(),
+			url,
+			websocket.WithHeader("X-Forwarded-For", "10.26.104.56"),
+		)
+		require.ErrorContains(t, err, "429: upstream banned")
+	})
+
+	t.Run("pending approval", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+		approvals := NewApprovalList([]string{"approved-*"})
+
+		s := NewServer(manager, nil, nil, nil, log.NewNopLogger())
+		s.SetApprovals(approvals)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		conn, err := websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// The upstream must not be added until the endpoint is approved.
+		select {
+		case <-manager.addConnCh:
+			t.Fatal("upstream added before approval")
+		case <-time.After(time.Millisecond * 100):
+		}
+
+		pending := approvals.Approvals().Pending
+		require.Equal(t, 1, len(pending))
+		assert.Equal(t, "my-endpoint", pending[0].EndpointID)
+		assert.Equal(t, 1, pending[0].Upstreams)
+		assert.Equal(t, []string{"127.0.0.1"}, pending[0].ClientIPs)
+
+		approvals.Approve("my-endpoint")
+
+		addedUpstream := <-manager.addConnCh
+		assert.Equal(t, "my-endpoint", addedUpstream.EndpointID())
+		assert.Empty(t, approvals.Approvals().Pending)
+
+		// Endpoints approved in the configuration are added immediately.
+		url = fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/approved-endpoint",
+			ln.Addr().String(),
+		)
+		approvedConn, err := websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+		defer approvedConn.Close()
+
+		addedUpstream = <-manager.addConnCh
+		assert.Equal(t, "approved-endpoint", addedUpstream.EndpointID())
+	})
+
+	t.Run("pending approval disconnect", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+		approvals := NewApprovalList(nil)
+
+		s := NewServer(manager, nil, nil, nil, log.NewNopLogger())
+		s.SetApprovals(approvals)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		conn, err := websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			return len(approvals.Approvals().Pending) == 1
+		}, time.Second, time.Millisecond*10)
+
+		conn.Close()
+
+		// Disconnected upstreams are no longer pending.
+		require.Eventually(t, func() bool {
+			return len(approvals.Approvals().Pending) == 0
+		}, time.Second, time.Millisecond*10)
+	})
 }
 
 func TestServer_Authentication(t *testing.T) {
@@ -117,7 +649,7 @@ func TestServer_Authentication(t *testing.T) {
 			},
 		}
 
-		s := NewServer(manager, verifier, nil, log.NewNopLogger())
+		s := NewServer(manager, nil, verifier, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -139,6 +671,91 @@ func TestServer_Authentication(t *testing.T) {
 		assert.Equal(t, "my-endpoint", removedUpstream.EndpointID())
 	})
 
+	t.Run("tenant", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		verifier := &fakeVerifier{
+			handler: func(token string) (auth.EndpointToken, error) {
+				assert.Equal(t, "123", token)
+				return auth.EndpointToken{
+					Expiry:    time.Now().Add(time.Hour),
+					Endpoints: []string{"my-endpoint"},
+					Tenant:    "team-a",
+				}, nil
+			},
+		}
+
+		s := NewServer(manager, nil, verifier, nil, log.NewNopLogger())
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		conn, err := websocket.Dial(context.TODO(), url, websocket.WithToken("123"))
+		require.NoError(t, err)
+
+		// The endpoint must be scoped to the tenant.
+		addedUpstream := <-manager.addConnCh
+		assert.Equal(t, "team-a/my-endpoint", addedUpstream.EndpointID())
+
+		conn.Close()
+
+		removedUpstream := <-manager.removeConnCh
+		assert.Equal(t, "team-a/my-endpoint", removedUpstream.EndpointID())
+	})
+
+	t.Run("tenant quota", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		verifier := &fakeVerifier{
+			handler: func(_ string) (auth.EndpointToken, error) {
+				return auth.EndpointToken{
+					Tenant: "team-a",
+				}, nil
+			},
+		}
+
+		s := NewServer(manager, nil, verifier, nil, log.NewNopLogger())
+		s.SetQuotas(quota.NewQuotas([]config.TenantConfig{
+			{Tenant: "team-a", MaxEndpoints: 1},
+		}))
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		conn, err := websocket.Dial(context.TODO(), fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/endpoint-1",
+			ln.Addr().String(),
+		), websocket.WithToken("123"))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		<-manager.addConnCh
+
+		_, err = websocket.Dial(context.TODO(), fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/endpoint-2",
+			ln.Addr().String(),
+		), websocket.WithToken("123"))
+		require.ErrorContains(t, err, "429: quota exceeded")
+
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(
+			s.Metrics().RegistrationFailuresTotal.With(prometheus.Labels{
+				"reason": "quota_exceeded",
+			}),
+		))
+	})
+
 	t.Run("token expires", func(t *testing.T) {
 		ln, err := net.Listen("tcp", "127.0.0.1:0")
 		require.NoError(t, err)
@@ -156,7 +773,7 @@ func TestServer_Authentication(t *testing.T) {
 			},
 		}
 
-		s := NewServer(manager, verifier, nil, log.NewNopLogger())
+		s := NewServer(manager, nil, verifier, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -195,7 +812,7 @@ func TestServer_Authentication(t *testing.T) {
 			},
 		}
 
-		s := NewServer(manager, verifier, nil, log.NewNopLogger())
+		s := NewServer(manager, nil, verifier, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -222,7 +839,7 @@ func TestServer_Authentication(t *testing.T) {
 			},
 		}
 
-		s := NewServer(manager, verifier, nil, log.NewNopLogger())
+		s := NewServer(manager, nil, verifier, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -234,6 +851,12 @@ func TestServer_Authentication(t *testing.T) {
 		)
 		_, err = websocket.Dial(context.TODO(), url, websocket.WithToken("123"))
 		require.ErrorContains(t, err, "401: invalid token")
+
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(
+			s.Metrics().RegistrationFailuresTotal.With(prometheus.Labels{
+				"reason": "unauthorized",
+			}),
+		))
 	})
 }
 
@@ -249,7 +872,7 @@ func TestServer_TLS(t *testing.T) {
 
 	manager := newFakeManager()
 
-	s := NewServer(manager, nil, tlsConfig, log.NewNopLogger())
+	s := NewServer(manager, nil, nil, tlsConfig, log.NewNopLogger())
 	go func() {
 		require.NoError(t, s.Serve(ln))
 	}()
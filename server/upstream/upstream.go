@@ -1,8 +1,15 @@
 package upstream
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net"
+	"net/url"
+	"sync"
+	"time"
 
+	"github.com/andydunstall/piko/pkg/tunnel"
 	"github.com/andydunstall/piko/server/cluster"
 	"github.com/hashicorp/yamux"
 )
@@ -22,21 +29,137 @@ type Upstream interface {
 // ConnUpstream represents a connection to an upstream service thats connected
 // to the local node.
 type ConnUpstream struct {
-	endpointID string
-	sess       *yamux.Session
+	id          string
+	endpointID  string
+	clientIP    string
+	connectedAt time.Time
+	sess        *yamux.Session
+
+	// version is the tunnel protocol version negotiated with the upstream.
+	version int
+
+	// compression is the encoding negotiated to compress requests to the
+	// upstream, or empty if requests aren't compressed.
+	compression string
+
+	// requests limits the number of concurrent requests to the upstream, or
+	// is nil if there is no limit.
+	requests chan struct{}
+
+	// goAwayCh is closed when the upstream is asked to close.
+	goAwayCh   chan struct{}
+	goAwayOnce sync.Once
+
+	// control is the control stream opened by the upstream, or nil if the
+	// upstream doesn't support a control stream or hasn't opened it yet.
+	control net.Conn
+	// goAwaySent indicates whether ControlGoAway has been requested.
+	goAwaySent bool
+	// controlMu protects the above fields.
+	controlMu sync.Mutex
 }
 
-func NewConnUpstream(endpointID string, sess *yamux.Session) *ConnUpstream {
+func NewConnUpstream(
+	endpointID string,
+	clientIP string,
+	sess *yamux.Session,
+) *ConnUpstream {
 	return &ConnUpstream{
-		endpointID: endpointID,
-		sess:       sess,
+		id:          generateConnID(),
+		endpointID:  endpointID,
+		clientIP:    clientIP,
+		connectedAt: time.Now(),
+		sess:        sess,
+		goAwayCh:    make(chan struct{}),
 	}
 }
 
+// ID returns a unique identifier for the connection on the local node.
+func (u *ConnUpstream) ID() string {
+	return u.id
+}
+
 func (u *ConnUpstream) EndpointID() string {
 	return u.endpointID
 }
 
+// ClientIP returns the IP of the upstream service.
+func (u *ConnUpstream) ClientIP() string {
+	return u.clientIP
+}
+
+// ConnectedAt returns the time the upstream connected.
+func (u *ConnUpstream) ConnectedAt() time.Time {
+	return u.connectedAt
+}
+
+// SetVersion sets the tunnel protocol version negotiated with the upstream
+// when connecting.
+//
+// Must be called before the upstream is added.
+func (u *ConnUpstream) SetVersion(version int) {
+	u.version = version
+}
+
+// Version returns the tunnel protocol version negotiated with the upstream.
+func (u *ConnUpstream) Version() int {
+	return u.version
+}
+
+// SetCompression sets the encoding negotiated with the upstream when
+// connecting to compress requests.
+//
+// Must be called before the upstream is added.
+func (u *ConnUpstream) SetCompression(encoding string) {
+	u.compression = encoding
+}
+
+// Compression returns the encoding used to compress requests to the
+// upstream, or an empty string if requests aren't compressed.
+func (u *ConnUpstream) Compression() string {
+	return u.compression
+}
+
+// SetMaxRequests limits the number of concurrent requests to the upstream.
+// A limit of 0 means there is no limit.
+//
+// Must be called before the upstream is added.
+func (u *ConnUpstream) SetMaxRequests(max int) {
+	if max > 0 {
+		u.requests = make(chan struct{}, max)
+	}
+}
+
+// Acquire waits until the upstream has capacity for another request, or the
+// context is cancelled. Returns false if the context was cancelled.
+//
+// If true, Release must be called once the request completes.
+func (u *ConnUpstream) Acquire(ctx context.Context) bool {
+	if u.requests == nil {
+		return true
+	}
+	select {
+	case u.requests <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Release releases capacity acquired with Acquire.
+func (u *ConnUpstream) Release() {
+	if u.requests == nil {
+		return
+	}
+	<-u.requests
+}
+
+// Available returns whether the upstream has capacity for another request
+// without waiting.
+func (u *ConnUpstream) Available() bool {
+	return u.requests == nil || len(u.requests) < cap(u.requests)
+}
+
 func (u *ConnUpstream) Dial() (net.Conn, error) {
 	return u.sess.OpenStream()
 }
@@ -45,6 +168,102 @@ func (u *ConnUpstream) Forward() bool {
 	return false
 }
 
+// Close gracefully closes the connection to the upstream service, so the
+// upstream reconnects (possibly to another node in the cluster).
+//
+// The upstream stops receiving new requests and is asked to reconnect, then
+// the connection is closed once in-flight requests complete. Close doesn't
+// wait for the connection to close.
+func (u *ConnUpstream) Close() error {
+	u.goAwayOnce.Do(func() {
+		close(u.goAwayCh)
+	})
+	return nil
+}
+
+// CloseNow immediately closes the connection to the upstream service,
+// including any in-flight requests.
+func (u *ConnUpstream) CloseNow() error {
+	return u.sess.Close()
+}
+
+// GoAwayChan returns a channel that's closed when the upstream is asked to
+// close using Close.
+func (u *ConnUpstream) GoAwayChan() <-chan struct{} {
+	return u.goAwayCh
+}
+
+// SetControl sets the control stream opened by the upstream. If GoAway was
+// already called, ControlGoAway is sent immediately.
+//
+// Returns false if the upstream already has a control stream.
+func (u *ConnUpstream) SetControl(stream net.Conn) (bool, error) {
+	u.controlMu.Lock()
+	defer u.controlMu.Unlock()
+
+	if u.control != nil {
+		return false, nil
+	}
+	u.control = stream
+	if u.goAwaySent {
+		return true, u.writeGoAwayLocked()
+	}
+	return true, nil
+}
+
+// GoAway sends ControlGoAway to the upstream to ask it to reconnect. If the
+// upstream hasn't opened its control stream yet, ControlGoAway is sent once
+// it's opened.
+//
+// Returns false if the upstream doesn't support a control stream, in which
+// case it only reconnects once the connection is closed.
+func (u *ConnUpstream) GoAway() (bool, error) {
+	if u.version < tunnel.ControlVersion {
+		return false, nil
+	}
+
+	u.controlMu.Lock()
+	defer u.controlMu.Unlock()
+
+	if u.goAwaySent {
+		return true, nil
+	}
+	u.goAwaySent = true
+	if u.control == nil {
+		return true, nil
+	}
+	return true, u.writeGoAwayLocked()
+}
+
+// Streams returns the number of open proxied connections to the upstream,
+// excluding the control stream.
+func (u *ConnUpstream) Streams() int {
+	u.controlMu.Lock()
+	defer u.controlMu.Unlock()
+
+	streams := u.sess.NumStreams()
+	if u.control != nil {
+		streams--
+	}
+	return streams
+}
+
+func (u *ConnUpstream) writeGoAwayLocked() error {
+	// The upstream should always be reading the control stream, so a write
+	// only blocks if the connection is broken.
+	_ = u.control.SetWriteDeadline(time.Now().Add(controlWriteTimeout))
+	_, err := u.control.Write([]byte{tunnel.ControlGoAway})
+	return err
+}
+
+func generateConnID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic("read rand: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
 // NodeUpstream represents a remote Piko server node.
 type NodeUpstream struct {
 	endpointID string
@@ -66,6 +285,74 @@ func (u *NodeUpstream) Dial() (net.Conn, error) {
 	return net.Dial("tcp", u.node.ProxyAddr)
 }
 
+// NodeID returns the ID of the remote node.
+func (u *NodeUpstream) NodeID() string {
+	return u.node.ID
+}
+
+// Addr returns the proxy address of the remote node.
+func (u *NodeUpstream) Addr() string {
+	return u.node.ProxyAddr
+}
+
+// RPCAddr returns the RPC address of the remote node, or an empty string if
+// the node doesn't accept RPC requests.
+func (u *NodeUpstream) RPCAddr() string {
+	return u.node.RPCAddr
+}
+
 func (u *NodeUpstream) Forward() bool {
 	return true
 }
+
+// ClusterUpstream represents a remote federated Piko cluster.
+type ClusterUpstream struct {
+	endpointID string
+	cluster    string
+	url        *url.URL
+}
+
+func NewClusterUpstream(
+	endpointID string,
+	cluster string,
+	url *url.URL,
+) *ClusterUpstream {
+	return &ClusterUpstream{
+		endpointID: endpointID,
+		cluster:    cluster,
+		url:        url,
+	}
+}
+
+func (u *ClusterUpstream) EndpointID() string {
+	return u.endpointID
+}
+
+func (u *ClusterUpstream) Dial() (net.Conn, error) {
+	return net.Dial("tcp", u.Addr())
+}
+
+// Cluster returns the name of the remote cluster.
+func (u *ClusterUpstream) Cluster() string {
+	return u.cluster
+}
+
+// URL returns the URL of the remote clusters proxy port.
+func (u *ClusterUpstream) URL() *url.URL {
+	return u.url
+}
+
+// Addr returns the host and port of the remote clusters proxy port.
+func (u *ClusterUpstream) Addr() string {
+	if u.url.Port() != "" {
+		return u.url.Host
+	}
+	if u.url.Scheme == "https" {
+		return net.JoinHostPort(u.url.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.url.Hostname(), "80")
+}
+
+func (u *ClusterUpstream) Forward() bool {
+	return true
+}
@@ -0,0 +1,201 @@
+package upstream
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// VisibilityPublic means proxy clients don't need to authenticate to
+	// reach the endpoint.
+	VisibilityPublic = "public"
+	// VisibilityPrivate means proxy clients must authenticate to reach the
+	// endpoint.
+	VisibilityPrivate = "private"
+)
+
+// ValidVisibility returns whether the visibility is either 'public' or
+// 'private'.
+func ValidVisibility(visibility string) bool {
+	return visibility == VisibilityPublic || visibility == VisibilityPrivate
+}
+
+// EndpointVisibility describes the visibility of an endpoint set using the
+// admin API.
+type EndpointVisibility struct {
+	EndpointID string `json:"endpoint_id"`
+	Visibility string `json:"visibility"`
+}
+
+// VisibilityRecord is the latest update to the visibility of an endpoint set
+// using the admin API, used to propagate the visibility to other nodes.
+type VisibilityRecord struct {
+	EndpointID string
+
+	// Visibility is the visibility of the endpoint, or empty if the
+	// visibility was unset.
+	Visibility string
+
+	// Updated is the time the visibility was set or unset.
+	Updated time.Time
+}
+
+// newer returns whether the record is a newer update than other.
+func (r *VisibilityRecord) newer(other VisibilityRecord) bool {
+	if !r.Updated.Equal(other.Updated) {
+		return r.Updated.After(other.Updated)
+	}
+	// Break ties deterministically so every node selects the same update.
+	return r.Visibility > other.Visibility
+}
+
+// VisibilityList contains the visibility of endpoints set using the admin
+// API, which overrides the configured visibility.
+//
+// The visibility is propagated to the other nodes in the cluster using
+// gossip, where the latest update to each endpoint wins. Each node that
+// learns about an update also propagates it, so the update isn't lost when
+// the node it was set on leaves. Unsetting the visibility is recorded as an
+// update with no visibility, so it isn't overridden by an earlier update.
+// The visibility isn't persisted, so is lost if all nodes restart.
+type VisibilityList struct {
+	records map[string]VisibilityRecord
+
+	subscribers []func(r VisibilityRecord)
+
+	mu sync.Mutex
+}
+
+func NewVisibilityList() *VisibilityList {
+	return &VisibilityList{
+		records: make(map[string]VisibilityRecord),
+	}
+}
+
+// Lookup returns the visibility of the endpoint, or false if the visibility
+// of the endpoint isn't set.
+func (l *VisibilityList) Lookup(endpointID string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.records[endpointID]
+	if !ok || r.Visibility == "" {
+		return "", false
+	}
+	return r.Visibility, true
+}
+
+// Set sets the visibility of the endpoint.
+func (l *VisibilityList) Set(endpointID string, visibility string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.updateLocked(endpointID, visibility)
+}
+
+// Unset removes the visibility of the endpoint set using Set, so the
+// configured visibility is used.
+//
+// Returns false if the visibility of the endpoint wasn't set.
+func (l *VisibilityList) Unset(endpointID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if r, ok := l.records[endpointID]; !ok || r.Visibility == "" {
+		return false
+	}
+	l.updateLocked(endpointID, "")
+	return true
+}
+
+// Apply applies an update learned from another node, unless the list already
+// has a newer update for the endpoint.
+//
+// Returns false if the update wasn't applied.
+func (l *VisibilityList) Apply(r VisibilityRecord) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if existing, ok := l.records[r.EndpointID]; ok && !r.newer(existing) {
+		return false
+	}
+	l.records[r.EndpointID] = r
+	for _, f := range l.subscribers {
+		f(r)
+	}
+	return true
+}
+
+// Record returns the latest update to the visibility of the endpoint.
+func (l *VisibilityList) Record(endpointID string) (VisibilityRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.records[endpointID]
+	return r, ok
+}
+
+// Records returns the latest update to the visibility of each endpoint,
+// including endpoints whose visibility was unset.
+func (l *VisibilityList) Records() []VisibilityRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	records := make([]VisibilityRecord, 0, len(l.records))
+	for _, r := range l.records {
+		records = append(records, r)
+	}
+	return records
+}
+
+// Visibilities returns the endpoints with visibility set, sorted by endpoint
+// ID.
+func (l *VisibilityList) Visibilities() []EndpointVisibility {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	visibilities := make([]EndpointVisibility, 0, len(l.records))
+	for endpointID, r := range l.records {
+		if r.Visibility == "" {
+			continue
+		}
+		visibilities = append(visibilities, EndpointVisibility{
+			EndpointID: endpointID,
+			Visibility: r.Visibility,
+		})
+	}
+	sort.Slice(visibilities, func(i, j int) bool {
+		return visibilities[i].EndpointID < visibilities[j].EndpointID
+	})
+	return visibilities
+}
+
+// OnUpdate subscribes to the visibility of endpoints being set or unset,
+// including updates learned from other nodes.
+//
+// The callback is called with the list mutex locked so must not block or
+// call back to the list.
+func (l *VisibilityList) OnUpdate(f func(r VisibilityRecord)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.subscribers = append(l.subscribers, f)
+}
+
+func (l *VisibilityList) updateLocked(endpointID string, visibility string) {
+	r := VisibilityRecord{
+		EndpointID: endpointID,
+		Visibility: visibility,
+		Updated:    time.Now(),
+	}
+	// Ensure the update is newer than the existing update, even if the
+	// existing update was learned from a node whose clock is ahead.
+	if existing, ok := l.records[endpointID]; ok && !r.Updated.After(existing.Updated) {
+		r.Updated = existing.Updated.Add(time.Nanosecond)
+	}
+	l.records[endpointID] = r
+	for _, f := range l.subscribers {
+		f(r)
+	}
+}
@@ -0,0 +1,139 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVisibilityList(t *testing.T) {
+	t.Run("set", func(t *testing.T) {
+		l := NewVisibilityList()
+
+		_, ok := l.Lookup("my-endpoint")
+		assert.False(t, ok)
+
+		l.Set("my-endpoint", VisibilityPublic)
+		v, ok := l.Lookup("my-endpoint")
+		assert.True(t, ok)
+		assert.Equal(t, VisibilityPublic, v)
+
+		l.Set("my-endpoint", VisibilityPrivate)
+		v, _ = l.Lookup("my-endpoint")
+		assert.Equal(t, VisibilityPrivate, v)
+
+		assert.Equal(t, []EndpointVisibility{
+			{EndpointID: "my-endpoint", Visibility: VisibilityPrivate},
+		}, l.Visibilities())
+	})
+
+	t.Run("unset", func(t *testing.T) {
+		l := NewVisibilityList()
+
+		assert.False(t, l.Unset("my-endpoint"))
+
+		l.Set("my-endpoint", VisibilityPublic)
+		assert.True(t, l.Unset("my-endpoint"))
+		_, ok := l.Lookup("my-endpoint")
+		assert.False(t, ok)
+		assert.Empty(t, l.Visibilities())
+
+		// The unset is recorded so it can be propagated.
+		r, ok := l.Record("my-endpoint")
+		assert.True(t, ok)
+		assert.Equal(t, "", r.Visibility)
+
+		assert.False(t, l.Unset("my-endpoint"))
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		l := NewVisibilityList()
+
+		assert.True(t, l.Apply(VisibilityRecord{
+			EndpointID: "my-endpoint",
+			Visibility: VisibilityPrivate,
+			Updated:    time.Unix(100, 0),
+		}))
+		v, _ := l.Lookup("my-endpoint")
+		assert.Equal(t, VisibilityPrivate, v)
+
+		// Older updates are ignored.
+		assert.False(t, l.Apply(VisibilityRecord{
+			EndpointID: "my-endpoint",
+			Visibility: VisibilityPublic,
+			Updated:    time.Unix(50, 0),
+		}))
+		// Duplicate updates are ignored.
+		assert.False(t, l.Apply(VisibilityRecord{
+			EndpointID: "my-endpoint",
+			Visibility: VisibilityPrivate,
+			Updated:    time.Unix(100, 0),
+		}))
+		v, _ = l.Lookup("my-endpoint")
+		assert.Equal(t, VisibilityPrivate, v)
+
+		// Concurrent updates are resolved deterministically.
+		assert.True(t, l.Apply(VisibilityRecord{
+			EndpointID: "my-endpoint",
+			Visibility: VisibilityPublic,
+			Updated:    time.Unix(100, 0),
+		}))
+		v, _ = l.Lookup("my-endpoint")
+		assert.Equal(t, VisibilityPublic, v)
+
+		// Newer unset.
+		assert.True(t, l.Apply(VisibilityRecord{
+			EndpointID: "my-endpoint",
+			Updated:    time.Unix(200, 0),
+		}))
+		_, ok := l.Lookup("my-endpoint")
+		assert.False(t, ok)
+	})
+
+	t.Run("set after newer remote update", func(t *testing.T) {
+		l := NewVisibilityList()
+
+		// An update from a node whose clock is ahead.
+		remote := VisibilityRecord{
+			EndpointID: "my-endpoint",
+			Visibility: VisibilityPublic,
+			Updated:    time.Now().Add(time.Hour),
+		}
+		l.Apply(remote)
+
+		// Local updates must still override the existing update.
+		l.Set("my-endpoint", VisibilityPrivate)
+		r, _ := l.Record("my-endpoint")
+		assert.Equal(t, VisibilityPrivate, r.Visibility)
+		assert.True(t, r.Updated.After(remote.Updated))
+	})
+
+	t.Run("on update", func(t *testing.T) {
+		l := NewVisibilityList()
+
+		var updates []VisibilityRecord
+		l.OnUpdate(func(r VisibilityRecord) {
+			updates = append(updates, r)
+		})
+
+		l.Set("my-endpoint", VisibilityPublic)
+		l.Unset("my-endpoint")
+		l.Apply(VisibilityRecord{
+			EndpointID: "other-endpoint",
+			Visibility: VisibilityPrivate,
+			Updated:    time.Now().Add(time.Hour),
+		})
+		// Ignored updates aren't notified.
+		l.Apply(VisibilityRecord{
+			EndpointID: "other-endpoint",
+			Visibility: VisibilityPublic,
+			Updated:    time.Unix(0, 0),
+		})
+
+		assert.Len(t, updates, 3)
+		assert.Equal(t, VisibilityPublic, updates[0].Visibility)
+		assert.Equal(t, "", updates[1].Visibility)
+		assert.Equal(t, "other-endpoint", updates[2].EndpointID)
+	})
+}
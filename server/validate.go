@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/andydunstall/piko/server/auth"
+	"github.com/andydunstall/piko/server/config"
+)
+
+// ValidateConfig checks the given configuration can be used to start a
+// server node, without binding any ports.
+//
+// As well as validating the configuration, this resolves any advertise
+// addresses that aren't configured from their bind address, loads the TLS
+// certificates and parses the authentication keys, so reports errors that
+// would otherwise only be found when starting the node.
+//
+// Note as ports aren't bound, advertise addresses for bind addresses with a
+// port of 0 will have port 0.
+func ValidateConfig(conf *config.Config) error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	listeners := []struct {
+		name               string
+		enabled            bool
+		bindAddr           string
+		advertiseInterface string
+		advertiseAddr      *string
+	}{
+		{"proxy", true, conf.Proxy.BindAddr, conf.Proxy.AdvertiseInterface, &conf.Proxy.AdvertiseAddr},
+		{"upstream", !conf.Cluster.ProxyOnly(), conf.Upstream.BindAddr, "", &conf.Upstream.AdvertiseAddr},
+		{"admin", true, conf.Admin.BindAddr, conf.Admin.AdvertiseInterface, &conf.Admin.AdvertiseAddr},
+		{"rpc", conf.RPC.Enabled(), conf.RPC.BindAddr, "", &conf.RPC.AdvertiseAddr},
+		{"gossip", true, conf.Gossip.BindAddr, conf.Gossip.AdvertiseInterface, &conf.Gossip.AdvertiseAddr},
+	}
+	for _, l := range listeners {
+		if !l.enabled || *l.advertiseAddr != "" {
+			continue
+		}
+		advertiseAddr, err := advertiseAddrFromBindAddr(
+			l.bindAddr, l.advertiseInterface, conf.Cluster.AdvertiseIPFamily,
+		)
+		if err != nil {
+			return fmt.Errorf("%s: advertise addr: %w", l.name, err)
+		}
+		*l.advertiseAddr = advertiseAddr
+	}
+
+	if _, err := conf.Proxy.TLS.Load(); err != nil {
+		return fmt.Errorf("proxy: tls: %w", err)
+	}
+	for _, l := range conf.Proxy.Listeners {
+		if _, err := l.TLS.Load(); err != nil {
+			return fmt.Errorf("proxy: listener: %s: tls: %w", l.BindAddr, err)
+		}
+	}
+	if !conf.Cluster.ProxyOnly() {
+		if _, err := conf.Upstream.TLS.Load(); err != nil {
+			return fmt.Errorf("upstream: tls: %w", err)
+		}
+	}
+	if _, err := conf.Admin.TLS.Load(); err != nil {
+		return fmt.Errorf("admin: tls: %w", err)
+	}
+	if _, err := conf.Admin.TLS.LoadClient(); err != nil {
+		return fmt.Errorf("admin: tls: %w", err)
+	}
+
+	if conf.Auth.AuthEnabled() {
+		if _, err := auth.NewJWTVerifierFromConfig(conf.Auth); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	if conf.Proxy.Auth.AuthEnabled() {
+		if _, err := auth.NewJWTVerifierFromConfig(conf.Proxy.Auth); err != nil {
+			return fmt.Errorf("proxy: auth: %w", err)
+		}
+	}
+
+	return nil
+}
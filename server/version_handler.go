@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/andydunstall/piko/pkg/build"
+	"github.com/andydunstall/piko/server/status"
+	"github.com/gin-gonic/gin"
+)
+
+// versionHandler registers the admin route to inspect the node build
+// version.
+type versionHandler struct {
+}
+
+func newVersionHandler() *versionHandler {
+	return &versionHandler{}
+}
+
+func (h *versionHandler) Register(group *gin.RouterGroup) {
+	group.GET("", h.versionRoute)
+}
+
+// versionRoute returns the version, git commit, build date and Go version of
+// the node.
+func (h *versionHandler) versionRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, build.GetInfo())
+}
+
+var _ status.Handler = &versionHandler{}
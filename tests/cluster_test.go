@@ -137,3 +137,40 @@ func TestCluster_Proxy(t *testing.T) {
 		wg.Wait()
 	})
 }
+
+// Tests chunked transfer encoding, 'Expect: 100-continue' and informational
+// responses are passed through when forwarding requests between nodes.
+func TestCluster_HTTPSemantics(t *testing.T) {
+	for _, protocol := range []string{"http", "grpc"} {
+		t.Run(protocol, func(t *testing.T) {
+			manager := cluster.NewManager(cluster.WithForwardProtocol(protocol))
+			defer manager.Close()
+
+			manager.Update(&config.Config{
+				Nodes: 3,
+			})
+
+			remoteEndpointCh := make(chan string, 1)
+			manager.Nodes()[1].ClusterState().OnRemoteEndpointUpdate(
+				func(_ string, endpointID string) {
+					remoteEndpointCh <- endpointID
+				},
+			)
+
+			upstreamURL := "http://" + manager.Nodes()[0].UpstreamAddr()
+			pikoClient := client.New(client.WithUpstreamURL(upstreamURL))
+			ln, err := pikoClient.Listen(context.TODO(), "my-endpoint")
+			assert.NoError(t, err)
+
+			server := httptest.NewUnstartedServer(semanticsHandler())
+			server.Listener = ln
+			go server.Start()
+			defer server.Close()
+
+			// Wait for node 2 to learn about the new upstream.
+			assert.Equal(t, "my-endpoint", <-remoteEndpointCh)
+
+			testHTTPSemantics(t, "http://"+manager.Nodes()[1].ProxyAddr())
+		})
+	}
+}
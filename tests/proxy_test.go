@@ -12,8 +12,11 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/andydunstall/piko/agent/client"
 	cluster "github.com/andydunstall/piko/workloadv2/cluster"
@@ -186,7 +189,92 @@ func TestProxy_HTTP(t *testing.T) {
 		assert.Equal(t, []byte("echo"), message)
 	})
 
+	t.Run("multiple connections", func(t *testing.T) {
+		node := cluster.NewNode()
+		node.Start()
+		defer node.Stop()
+
+		// Add upstream listener with 3 connections to Piko.
+
+		upstreamURL := "http://" + node.UpstreamAddr()
+		pikoClient := client.New(
+			client.WithUpstreamURL(upstreamURL),
+			client.WithConnections(3),
+		)
+		ln, err := pikoClient.Listen(context.TODO(), "my-endpoint")
+		assert.NoError(t, err)
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(
+			func(_ http.ResponseWriter, _ *http.Request) {},
+		))
+		server.Listener = ln
+		go server.Start()
+		defer server.Close()
+
+		assert.Eventually(t, func() bool {
+			return node.ClusterState().LocalEndpointListeners("my-endpoint") == 3
+		}, time.Second, time.Millisecond*10)
+
+		// Send requests to the upstream via Piko, which are load balanced
+		// among the connections.
+
+		for i := 0; i != 10; i++ {
+			req, _ := http.NewRequest(
+				http.MethodGet,
+				"http://"+node.ProxyAddr(),
+				nil,
+			)
+			req.Header.Add("x-piko-endpoint", "my-endpoint")
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+
+		// Closing the listener closes all connections.
+
+		ln.Close()
+
+		assert.Eventually(t, func() bool {
+			return node.ClusterState().LocalEndpointListeners("my-endpoint") == 0
+		}, time.Second, time.Millisecond*10)
+	})
+
 	// Tests sending a request to an endpoint with no listeners.
+	// Tests a listener configured with a zero heartbeat uses the default
+	// heartbeat.
+	t.Run("zero heartbeat", func(t *testing.T) {
+		node := cluster.NewNode()
+		node.Start()
+		defer node.Stop()
+
+		upstreamURL := "http://" + node.UpstreamAddr()
+		pikoClient := client.New(
+			client.WithUpstreamURL(upstreamURL),
+			client.WithHeartbeat(0, 0),
+		)
+		ln, err := pikoClient.Listen(context.TODO(), "my-endpoint")
+		assert.NoError(t, err)
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+		))
+		server.Listener = ln
+		go server.Start()
+		defer server.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, "http://"+node.ProxyAddr(), nil)
+		req.Header.Add("x-piko-endpoint", "my-endpoint")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
 	t.Run("no listeners", func(t *testing.T) {
 		node := cluster.NewNode()
 		node.Start()
@@ -212,6 +300,26 @@ func TestProxy_HTTP(t *testing.T) {
 	})
 }
 
+// Tests chunked transfer encoding, 'Expect: 100-continue' and informational
+// responses are passed through the proxy and upstream connection.
+func TestProxy_HTTPSemantics(t *testing.T) {
+	node := cluster.NewNode()
+	node.Start()
+	defer node.Stop()
+
+	upstreamURL := "http://" + node.UpstreamAddr()
+	pikoClient := client.New(client.WithUpstreamURL(upstreamURL))
+	ln, err := pikoClient.Listen(context.TODO(), "my-endpoint")
+	assert.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(semanticsHandler())
+	server.Listener = ln
+	go server.Start()
+	defer server.Close()
+
+	testHTTPSemantics(t, "http://"+node.ProxyAddr())
+}
+
 func TestProxy_TCP(t *testing.T) {
 	t.Run("tcp", func(t *testing.T) {
 		node := cluster.NewNode()
@@ -270,6 +378,241 @@ func TestProxy_TCP(t *testing.T) {
 	})
 }
 
+// semanticsHandler returns an upstream handler used to test HTTP semantics
+// are passed through Piko.
+//
+// '/chunked' echos the chunked request body with a chunked response with
+// trailers, '/reject' rejects the request without reading the body, and
+// '/early-hints' responds with '103 Early Hints' before the final response.
+// Otherwise the request body is echoed.
+func semanticsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chunked":
+			if len(r.TransferEncoding) != 1 || r.TransferEncoding[0] != "chunked" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			w.Header().Set("Trailer", "x-checksum")
+			buf := make([]byte, 1024)
+			for {
+				n, err := r.Body.Read(buf)
+				if n > 0 {
+					_, _ = w.Write(buf[:n])
+					w.(http.Flusher).Flush()
+				}
+				if err != nil {
+					break
+				}
+			}
+			w.Header().Set("x-checksum", "my-checksum")
+		case "/reject":
+			w.WriteHeader(http.StatusExpectationFailed)
+		case "/early-hints":
+			w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+			w.WriteHeader(http.StatusEarlyHints)
+			w.Header().Del("Link")
+
+			_, _ = w.Write([]byte("foo"))
+		default:
+			// Reading the body sends '100 Continue' if expected.
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				return
+			}
+			_, _ = w.Write(b)
+		}
+	})
+}
+
+// testHTTPSemantics sends requests to an upstream with semanticsHandler
+// via the Piko proxy at the given URL.
+func testHTTPSemantics(t *testing.T, proxyURL string) {
+	t.Run("chunked", func(t *testing.T) {
+		// Send the request body in multiple chunks.
+		pr, pw := io.Pipe()
+		go func() {
+			for _, chunk := range []string{"foo", "bar", "baz"} {
+				if _, err := pw.Write([]byte(chunk)); err != nil {
+					return
+				}
+			}
+			pw.Close()
+		}()
+
+		req, _ := http.NewRequest(http.MethodPost, proxyURL+"/chunked", pr)
+		req.Header.Add("x-piko-endpoint", "my-endpoint")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+		respBody, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("foobarbaz"), respBody)
+		assert.Equal(t, "my-checksum", resp.Trailer.Get("x-checksum"))
+	})
+
+	t.Run("expect continue", func(t *testing.T) {
+		// Use a long timeout so the client only sends the body after
+		// receiving '100 Continue'.
+		httpClient := &http.Client{
+			Transport: &http.Transport{
+				ExpectContinueTimeout: time.Minute,
+			},
+		}
+
+		send := func(path string) (*http.Response, bool) {
+			var gotContinue bool
+			trace := &httptrace.ClientTrace{
+				Got100Continue: func() {
+					gotContinue = true
+				},
+			}
+			req, _ := http.NewRequestWithContext(
+				httptrace.WithClientTrace(context.Background(), trace),
+				http.MethodPost,
+				proxyURL+path,
+				bytes.NewReader([]byte("foo")),
+			)
+			req.Header.Add("x-piko-endpoint", "my-endpoint")
+			req.Header.Set("Expect", "100-continue")
+			resp, err := httpClient.Do(req)
+			assert.NoError(t, err)
+			return resp, gotContinue
+		}
+
+		// The upstream accepts the request by reading the body.
+		resp, gotContinue := send("/")
+		defer resp.Body.Close()
+
+		assert.True(t, gotContinue)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		respBody, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("foo"), respBody)
+
+		// The upstream rejects the request so the body is never sent.
+		resp, gotContinue = send("/reject")
+		defer resp.Body.Close()
+
+		assert.False(t, gotContinue)
+		assert.Equal(t, http.StatusExpectationFailed, resp.StatusCode)
+	})
+
+	t.Run("early hints", func(t *testing.T) {
+		var informational []int
+		var link string
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				informational = append(informational, code)
+				link = header.Get("Link")
+				return nil
+			},
+		}
+		req, _ := http.NewRequestWithContext(
+			httptrace.WithClientTrace(context.Background(), trace),
+			http.MethodGet,
+			proxyURL+"/early-hints",
+			nil,
+		)
+		req.Header.Add("x-piko-endpoint", "my-endpoint")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, []int{http.StatusEarlyHints}, informational)
+		assert.Equal(t, "</style.css>; rel=preload; as=style", link)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		// The early hints headers aren't included in the final response.
+		assert.Empty(t, resp.Header.Get("Link"))
+		respBody, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("foo"), respBody)
+	})
+}
+
+// Tests draining a node asks connected upstreams to reconnect without
+// interrupting in-flight requests.
+func TestProxy_Drain(t *testing.T) {
+	node := cluster.NewNode()
+	node.Start()
+	defer node.Stop()
+
+	upstreamURL := "http://" + node.UpstreamAddr()
+	pikoClient := client.New(client.WithUpstreamURL(upstreamURL))
+	ln, err := pikoClient.Listen(context.TODO(), "my-endpoint")
+	assert.NoError(t, err)
+
+	requestCh := make(chan struct{})
+	releaseCh := make(chan struct{})
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			close(requestCh)
+			<-releaseCh
+			_, _ = w.Write([]byte("foo"))
+		},
+	))
+	server.Listener = ln
+	go server.Start()
+	defer server.Close()
+
+	respCh := make(chan *http.Response, 1)
+	go func() {
+		req, _ := http.NewRequest(
+			http.MethodGet,
+			"http://"+node.ProxyAddr(),
+			nil,
+		)
+		req.Header.Add("x-piko-endpoint", "my-endpoint")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		respCh <- resp
+	}()
+
+	<-requestCh
+
+	// Drain the node with the request in-flight.
+	drainResp, err := http.Post("http://"+node.AdminAddr()+"/drain", "", nil)
+	assert.NoError(t, err)
+	drainResp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, drainResp.StatusCode)
+
+	// The node isn't drained until the request completes.
+	assert.False(t, testDrained(t, node.AdminAddr()))
+
+	close(releaseCh)
+
+	resp := <-respCh
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	respBody, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo"), respBody)
+
+	assert.Eventually(t, func() bool {
+		return testDrained(t, node.AdminAddr())
+	}, time.Second*5, time.Millisecond*10)
+}
+
+// testDrained returns whether the node with the given admin address is
+// drained.
+func testDrained(t *testing.T, adminAddr string) bool {
+	resp, err := http.Get("http://" + adminAddr + "/drain")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	var status struct {
+		Drained bool `json:"drained"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	return status.Drained
+}
+
 func randomBytes(n int) []byte {
 	b := make([]byte, n)
 	_, err := rand.Read(b)
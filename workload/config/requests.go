@@ -34,7 +34,8 @@ func DefaultRequestsConfig() *RequestsConfig {
 			URL: "http://localhost:8000",
 		},
 		Log: log.Config{
-			Level: "info",
+			Level:  "info",
+			Format: "json",
 		},
 	}
 }
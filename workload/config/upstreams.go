@@ -77,7 +77,8 @@ func DefaultUpstreamsConfig() *UpstreamsConfig {
 			URL: "http://localhost:8001",
 		},
 		Log: log.Config{
-			Level: "info",
+			Level:  "info",
+			Format: "json",
 		},
 	}
 }
@@ -0,0 +1,180 @@
+package cluster
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// latencyProxy is a TCP proxy that adds latency to each write in both
+// directions, used to add latency to requests forwarded between nodes.
+type latencyProxy struct {
+	ln net.Listener
+
+	target string
+
+	latency time.Duration
+
+	wg sync.WaitGroup
+}
+
+func newLatencyProxy(target string, latency time.Duration) (*latencyProxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &latencyProxy{
+		ln:      ln,
+		target:  target,
+		latency: latency,
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.serve()
+	}()
+	return p, nil
+}
+
+func (p *latencyProxy) Addr() string {
+	return p.ln.Addr().String()
+}
+
+func (p *latencyProxy) Close() {
+	p.ln.Close()
+	p.wg.Wait()
+}
+
+func (p *latencyProxy) serve() {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.proxy(conn)
+	}
+}
+
+func (p *latencyProxy) proxy(conn net.Conn) {
+	defer conn.Close()
+
+	target, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		p.copy(target, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		p.copy(conn, target)
+		done <- struct{}{}
+	}()
+	// Close both connections once either side closes.
+	<-done
+}
+
+func (p *latencyProxy) copy(dst io.Writer, src io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			time.Sleep(p.latency)
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// lossyPacketProxy is a UDP proxy that drops packets with the configured
+// probability, used to drop gossip packets.
+type lossyPacketProxy struct {
+	conn net.PacketConn
+
+	target *net.UDPAddr
+
+	// loss is the probability of dropping each packet, from 0 to 1.
+	loss float64
+
+	wg sync.WaitGroup
+}
+
+func newLossyPacketProxy(target string, loss float64) (*lossyPacketProxy, error) {
+	targetAddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &lossyPacketProxy{
+		conn:   conn,
+		target: targetAddr,
+		loss:   loss,
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.serve()
+	}()
+	return p, nil
+}
+
+func (p *lossyPacketProxy) Addr() string {
+	return p.conn.LocalAddr().String()
+}
+
+func (p *lossyPacketProxy) Close() {
+	p.conn.Close()
+	p.wg.Wait()
+}
+
+func (p *lossyPacketProxy) serve() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if rand.Float64() < p.loss {
+			continue
+		}
+		// Note gossip responds to the address in the packet rather than the
+		// source address, so packets are only proxied in one direction.
+		_, _ = p.conn.WriteTo(buf[:n], p.target)
+	}
+}
+
+// freeAddr returns a free local address for the given network ('tcp' or
+// 'udp').
+func freeAddr(network string) (string, error) {
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return conn.LocalAddr().String(), nil
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer ln.Close()
+	return ln.Addr().String(), nil
+}
@@ -2,22 +2,183 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/spf13/pflag"
 )
 
+type ChurnConfig struct {
+	// Interval is how often to stop a node and replace it with a new node.
+	// If zero nodes aren't churned.
+	Interval time.Duration `json:"interval" yaml:"interval"`
+}
+
+func (c *ChurnConfig) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("interval cannot be negative")
+	}
+	return nil
+}
+
+func (c *ChurnConfig) RegisterFlags(fs *pflag.FlagSet) {
+	fs.DurationVar(
+		&c.Interval,
+		"churn.interval",
+		c.Interval,
+		`
+How often to stop a node and replace it with a new node (with a new node ID).
+
+If 0 nodes aren't churned.`,
+	)
+}
+
+type ChaosConfig struct {
+	// RestartInterval is how often to stop a random node then restart it
+	// after RestartDelay. If zero nodes aren't restarted.
+	RestartInterval time.Duration `json:"restart_interval" yaml:"restart_interval"`
+
+	// RestartDelay is the duration to wait before restarting a stopped node.
+	RestartDelay time.Duration `json:"restart_delay" yaml:"restart_delay"`
+
+	// GossipPacketLoss is the probability of dropping each gossip packet,
+	// from 0 to 1.
+	GossipPacketLoss float64 `json:"gossip_packet_loss" yaml:"gossip_packet_loss"`
+
+	// ForwardLatency is the latency to add to requests forwarded between
+	// nodes.
+	ForwardLatency time.Duration `json:"forward_latency" yaml:"forward_latency"`
+}
+
+func (c *ChaosConfig) Validate() error {
+	if c.RestartInterval < 0 {
+		return fmt.Errorf("restart interval cannot be negative")
+	}
+	if c.RestartDelay < 0 {
+		return fmt.Errorf("restart delay cannot be negative")
+	}
+	if c.GossipPacketLoss < 0 || c.GossipPacketLoss > 1 {
+		return fmt.Errorf("gossip packet loss must be between 0 and 1")
+	}
+	if c.ForwardLatency < 0 {
+		return fmt.Errorf("forward latency cannot be negative")
+	}
+	return nil
+}
+
+func (c *ChaosConfig) RegisterFlags(fs *pflag.FlagSet) {
+	fs.DurationVar(
+		&c.RestartInterval,
+		"chaos.restart-interval",
+		c.RestartInterval,
+		`
+How often to stop a random node then restart it after the restart delay.
+
+As with a Piko server without a configured node ID, the restarted node is
+assigned a new node ID.
+
+If 0 nodes aren't restarted.`,
+	)
+
+	fs.DurationVar(
+		&c.RestartDelay,
+		"chaos.restart-delay",
+		c.RestartDelay,
+		`
+Duration to wait before restarting a stopped node.`,
+	)
+
+	fs.Float64Var(
+		&c.GossipPacketLoss,
+		"chaos.gossip-packet-loss",
+		c.GossipPacketLoss,
+		`
+Probability of dropping each gossip packet sent between nodes, from 0 to 1.
+
+Only applies to nodes started after the option is configured.`,
+	)
+
+	fs.DurationVar(
+		&c.ForwardLatency,
+		"chaos.forward-latency",
+		c.ForwardLatency,
+		`
+Latency to add to requests forwarded between nodes. The latency is added to
+each write in both directions.
+
+Only applies to nodes started after the option is configured.`,
+	)
+}
+
+type ProbeConfig struct {
+	// Interval is how often to send a probe request through the load
+	// balancer. If zero availability isn't probed.
+	Interval time.Duration `json:"interval" yaml:"interval"`
+
+	// MinAvailability is the minimum fraction of successful probe requests,
+	// from 0 to 1. If availability is lower when the cluster exits, it exits
+	// with an error.
+	MinAvailability float64 `json:"min_availability" yaml:"min_availability"`
+}
+
+func (c *ProbeConfig) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("interval cannot be negative")
+	}
+	if c.MinAvailability < 0 || c.MinAvailability > 1 {
+		return fmt.Errorf("min availability must be between 0 and 1")
+	}
+	return nil
+}
+
+func (c *ProbeConfig) RegisterFlags(fs *pflag.FlagSet) {
+	fs.DurationVar(
+		&c.Interval,
+		"probe.interval",
+		c.Interval,
+		`
+How often to send a probe request to verify end-to-end availability.
+
+The probe registers an upstream endpoint via the load balancer, then sends
+requests to that endpoint via the load balancer, so requests may be forwarded
+between nodes.
+
+If 0 availability isn't probed.`,
+	)
+
+	fs.Float64Var(
+		&c.MinAvailability,
+		"probe.min-availability",
+		c.MinAvailability,
+		`
+Minimum fraction of successful probe requests, from 0 to 1.
+
+If the availability is lower when the cluster shuts down, exits with a
+non-zero status.`,
+	)
+}
+
 type Config struct {
 	Nodes int `json:"nodes" yaml:"nodes"`
 
+	Churn ChurnConfig `json:"churn" yaml:"churn"`
+
+	Chaos ChaosConfig `json:"chaos" yaml:"chaos"`
+
+	Probe ProbeConfig `json:"probe" yaml:"probe"`
+
 	Log log.Config `json:"log" yaml:"log"`
 }
 
 func Default() *Config {
 	return &Config{
 		Nodes: 3,
+		Chaos: ChaosConfig{
+			RestartDelay: time.Second * 5,
+		},
 		Log: log.Config{
-			Level: "info",
+			Level:  "info",
+			Format: "json",
 		},
 	}
 }
@@ -27,6 +188,15 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("missing nodes")
 	}
 
+	if err := c.Churn.Validate(); err != nil {
+		return fmt.Errorf("churn: %w", err)
+	}
+	if err := c.Chaos.Validate(); err != nil {
+		return fmt.Errorf("chaos: %w", err)
+	}
+	if err := c.Probe.Validate(); err != nil {
+		return fmt.Errorf("probe: %w", err)
+	}
 	if err := c.Log.Validate(); err != nil {
 		return fmt.Errorf("log: %w", err)
 	}
@@ -42,5 +212,9 @@ func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
 The number of cluster nodes to start.`,
 	)
 
+	c.Churn.RegisterFlags(fs)
+	c.Chaos.RegisterFlags(fs)
+	c.Probe.RegisterFlags(fs)
+
 	c.Log.RegisterFlags(fs)
 }
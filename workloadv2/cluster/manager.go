@@ -1,7 +1,10 @@
 package cluster
 
 import (
+	"context"
+	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/workloadv2/cluster/config"
@@ -11,6 +14,17 @@ import (
 type Manager struct {
 	nodes []*Node
 
+	// restarting is the number of nodes that have been stopped and are
+	// waiting to be restarted.
+	restarting int
+
+	churn config.ChurnConfig
+	chaos config.ChaosConfig
+
+	// forwardProtocol is the protocol nodes use to forward requests to
+	// other nodes.
+	forwardProtocol string
+
 	mu sync.Mutex
 
 	logger log.Logger
@@ -25,7 +39,8 @@ func NewManager(opts ...Option) *Manager {
 	}
 
 	return &Manager{
-		logger: options.logger.WithSubsystem("cluster.manager"),
+		forwardProtocol: options.forwardProtocol,
+		logger:          options.logger.WithSubsystem("cluster.manager"),
 	}
 }
 
@@ -35,20 +50,47 @@ func (m *Manager) Update(config *config.Config) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Update the active nodes to ensure we have the correct number.
-	if config.Nodes > len(m.nodes) {
-		added := config.Nodes - len(m.nodes)
+	m.churn = config.Churn
+	m.chaos = config.Chaos
+
+	// Update the active nodes to ensure we have the correct number. Include
+	// restarting nodes as they will be added back once restarted.
+	nodes := len(m.nodes) + m.restarting
+	if config.Nodes > nodes {
+		added := config.Nodes - nodes
 		for i := 0; i != added; i++ {
 			m.addNodeLocked()
 		}
-	} else if len(m.nodes) > config.Nodes {
-		removed := len(m.nodes) - config.Nodes
-		for i := 0; i != removed; i++ {
+	} else if nodes > config.Nodes {
+		removed := nodes - config.Nodes
+		for i := 0; i != removed && len(m.nodes) > 0; i++ {
 			m.removeNodeLocked()
 		}
 	}
 }
 
+// Run churns and restarts nodes as configured until the context is
+// cancelled.
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		m.runLoop(ctx, func() time.Duration {
+			return m.churn.Interval
+		}, m.churnNode)
+	}()
+	go func() {
+		defer wg.Done()
+		m.runLoop(ctx, func() time.Duration {
+			return m.chaos.RestartInterval
+		}, func() {
+			m.restartNode(ctx)
+		})
+	}()
+	wg.Wait()
+}
+
 func (m *Manager) Nodes() []*Node {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -69,25 +111,119 @@ func (m *Manager) Close() {
 	}
 }
 
-func (m *Manager) addNodeLocked() {
-	m.logger.Info("add node")
+// runLoop calls f every interval until the context is cancelled. As the
+// interval may be updated, it is reloaded after each call. If the interval
+// is zero, waits for it to be configured.
+func (m *Manager) runLoop(
+	ctx context.Context,
+	interval func() time.Duration,
+	f func(),
+) {
+	for {
+		m.mu.Lock()
+		d := interval()
+		m.mu.Unlock()
+
+		enabled := d != 0
+		if !enabled {
+			d = time.Second
+		}
 
+		select {
+		case <-time.After(d):
+			if enabled {
+				f()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// churnNode replaces the oldest node with a new node.
+func (m *Manager) churnNode() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.nodes) == 0 {
+		return
+	}
+
+	m.logger.Info("churn node")
+
+	m.removeNodeLocked()
+	m.addNodeLocked()
+}
+
+// restartNode stops a random node, then after the configured delay starts a
+// new node to replace it.
+func (m *Manager) restartNode(ctx context.Context) {
+	m.mu.Lock()
+
+	// Always keep at least one node running.
+	if len(m.nodes) <= 1 {
+		m.mu.Unlock()
+		return
+	}
+
+	i := rand.Intn(len(m.nodes))
+	node := m.nodes[i]
+	m.nodes = append(m.nodes[:i], m.nodes[i+1:]...)
+	m.restarting++
+	delay := m.chaos.RestartDelay
+
+	m.logger.Info(
+		"restart node: stopping",
+		zap.String("node-id", node.ID()),
+		zap.Duration("delay", delay),
+	)
+	node.Stop()
+
+	m.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.restarting--
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.logger.Info("restart node: starting")
+	m.addNodeLocked()
+}
+
+func (m *Manager) addNodeLocked() {
 	var gossipAddrs []string
 	for _, node := range m.nodes {
 		gossipAddrs = append(gossipAddrs, node.GossipAddr())
 	}
 
-	node := NewNode(WithJoin(gossipAddrs), WithLogger(m.logger))
+	node := NewNode(
+		WithJoin(gossipAddrs),
+		WithForwardLatency(m.chaos.ForwardLatency),
+		WithGossipPacketLoss(m.chaos.GossipPacketLoss),
+		WithForwardProtocol(m.forwardProtocol),
+		WithLogger(m.logger),
+	)
 	node.Start()
 
+	m.logger.Info("add node", zap.String("node-id", node.ID()))
+
 	m.nodes = append(m.nodes, node)
 }
 
 func (m *Manager) removeNodeLocked() {
-	m.logger.Info("remove node")
-
 	// Remove the oldest node.
 	node := m.nodes[0]
 	m.nodes = m.nodes[1:]
+
+	m.logger.Info("remove node", zap.String("node-id", node.ID()))
+
 	node.Stop()
 }
@@ -22,6 +22,15 @@ type Node struct {
 
 	rootCAPool *x509.CertPool
 
+	// proxyAddr is the address of the node proxy listener. This may differ
+	// from the advertised address if forward latency is configured.
+	proxyAddr string
+
+	// latencyProxy and packetProxy inject faults into requests from other
+	// nodes, or are nil if not configured.
+	latencyProxy *latencyProxy
+	packetProxy  *lossyPacketProxy
+
 	ctx    context.Context
 	cancel func()
 
@@ -44,7 +53,46 @@ func NewNode(opts ...Option) *Node {
 	conf.Admin.BindAddr = "127.0.0.1:0"
 	conf.Gossip.BindAddr = "127.0.0.1:0"
 	conf.Gossip.Interval = time.Millisecond * 10
+	conf.Gossip.LivenessInterval = time.Millisecond * 10
 	conf.Auth = options.authConfig
+	if options.forwardProtocol == "grpc" {
+		conf.Proxy.Forward.Protocol = "grpc"
+		conf.RPC.BindAddr = "127.0.0.1:0"
+		// Nodes authenticate RPC requests using the join token.
+		conf.Gossip.JoinToken = "piko-workload"
+	}
+
+	// To add latency to forwarded requests, other nodes forward requests to
+	// the node via a proxy that adds latency.
+	var latencyProxy *latencyProxy
+	if options.forwardLatency != 0 {
+		addr, err := freeAddr("tcp")
+		if err != nil {
+			panic("free addr: " + err.Error())
+		}
+		latencyProxy, err = newLatencyProxy(addr, options.forwardLatency)
+		if err != nil {
+			panic("latency proxy: " + err.Error())
+		}
+		conf.Proxy.BindAddr = addr
+		conf.Proxy.AdvertiseAddr = latencyProxy.Addr()
+	}
+
+	// To drop gossip packets, other nodes send gossip packets to the node via
+	// a proxy that drops packets.
+	var packetProxy *lossyPacketProxy
+	if options.gossipPacketLoss != 0 {
+		addr, err := freeAddr("udp")
+		if err != nil {
+			panic("free addr: " + err.Error())
+		}
+		packetProxy, err = newLossyPacketProxy(addr, options.gossipPacketLoss)
+		if err != nil {
+			panic("packet proxy: " + err.Error())
+		}
+		conf.Gossip.PacketBindAddr = addr
+		conf.Gossip.PacketAdvertiseAddr = packetProxy.Addr()
+	}
 
 	// If TLS is enabled, generate a certificate and root CA then write to a
 	// file.
@@ -108,17 +156,32 @@ func NewNode(opts ...Option) *Node {
 		panic("server: " + err.Error())
 	}
 
+	proxyAddr := conf.Proxy.AdvertiseAddr
+	if latencyProxy != nil {
+		proxyAddr = conf.Proxy.BindAddr
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Node{
-		server:     server,
-		rootCAPool: rootCAPool,
-		ctx:        ctx,
-		cancel:     cancel,
+		server:       server,
+		rootCAPool:   rootCAPool,
+		proxyAddr:    proxyAddr,
+		latencyProxy: latencyProxy,
+		packetProxy:  packetProxy,
+		ctx:          ctx,
+		cancel:       cancel,
 	}
 }
 
+func (n *Node) ID() string {
+	return n.server.Config().Cluster.NodeID
+}
+
+// ProxyAddr returns the address of the node proxy listener.
+//
+// Note this bypasses any latency added to forwarded requests.
 func (n *Node) ProxyAddr() string {
-	return n.server.Config().Proxy.AdvertiseAddr
+	return n.proxyAddr
 }
 
 func (n *Node) UpstreamAddr() string {
@@ -154,4 +217,11 @@ func (n *Node) Start() {
 func (n *Node) Stop() {
 	n.cancel()
 	n.wg.Wait()
+
+	if n.latencyProxy != nil {
+		n.latencyProxy.Close()
+	}
+	if n.packetProxy != nil {
+		n.packetProxy.Close()
+	}
 }
@@ -1,15 +1,20 @@
 package cluster
 
 import (
+	"time"
+
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/server/auth"
 )
 
 type options struct {
-	join       []string
-	authConfig auth.Config
-	tls        bool
-	logger     log.Logger
+	join             []string
+	authConfig       auth.Config
+	tls              bool
+	forwardLatency   time.Duration
+	forwardProtocol  string
+	gossipPacketLoss float64
+	logger           log.Logger
 }
 
 type joinOption struct {
@@ -49,6 +54,42 @@ func WithTLS(tls bool) Option {
 	return tlsOption(tls)
 }
 
+type forwardLatencyOption time.Duration
+
+func (o forwardLatencyOption) apply(opts *options) {
+	opts.forwardLatency = time.Duration(o)
+}
+
+// WithForwardLatency adds latency to requests forwarded to the node by other
+// nodes.
+func WithForwardLatency(latency time.Duration) Option {
+	return forwardLatencyOption(latency)
+}
+
+type forwardProtocolOption string
+
+func (o forwardProtocolOption) apply(opts *options) {
+	opts.forwardProtocol = string(o)
+}
+
+// WithForwardProtocol configures the protocol used to forward requests to
+// other nodes, either 'http' or 'grpc'. Defaults to 'http'.
+func WithForwardProtocol(protocol string) Option {
+	return forwardProtocolOption(protocol)
+}
+
+type gossipPacketLossOption float64
+
+func (o gossipPacketLossOption) apply(opts *options) {
+	opts.gossipPacketLoss = float64(o)
+}
+
+// WithGossipPacketLoss drops gossip packets sent to the node with the given
+// probability, from 0 to 1.
+func WithGossipPacketLoss(loss float64) Option {
+	return gossipPacketLossOption(loss)
+}
+
 type loggerOption struct {
 	Logger log.Logger
 }
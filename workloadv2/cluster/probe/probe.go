@@ -0,0 +1,166 @@
+// Package probe verifies the end-to-end availability of a cluster.
+package probe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/andydunstall/piko/agent/client"
+	agentconfig "github.com/andydunstall/piko/agent/config"
+	"github.com/andydunstall/piko/agent/reverseproxy"
+	"github.com/andydunstall/piko/pkg/log"
+	"go.uber.org/zap"
+)
+
+const (
+	endpointID = "probe"
+
+	requestTimeout = time.Second * 5
+)
+
+// Prober registers an upstream endpoint then periodically sends requests to
+// the endpoint to verify the cluster is available.
+//
+// Since the upstream connection and requests are routed via the load
+// balancer to random nodes, requests are usually forwarded between nodes.
+type Prober struct {
+	proxyURL    string
+	upstreamURL string
+	interval    time.Duration
+
+	requests int
+	failed   int
+	mu       sync.Mutex
+
+	logger log.Logger
+}
+
+func NewProber(
+	proxyURL string,
+	upstreamURL string,
+	interval time.Duration,
+	logger log.Logger,
+) *Prober {
+	return &Prober{
+		proxyURL:    proxyURL,
+		upstreamURL: upstreamURL,
+		interval:    interval,
+		logger:      logger.WithSubsystem("probe"),
+	}
+}
+
+// Run registers the probe endpoint and sends requests until the context is
+// cancelled.
+func (p *Prober) Run(ctx context.Context) error {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(endpointID))
+	}))
+	defer server.Close()
+
+	client := client.New(
+		client.WithUpstreamURL(p.upstreamURL),
+		client.WithLogger(p.logger.WithSubsystem("probe.client")),
+	)
+	ln, err := client.Listen(ctx, endpointID)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	defer ln.Close()
+
+	proxy := reverseproxy.NewServer(agentconfig.ListenerConfig{
+		EndpointID: endpointID,
+		Addr:       server.Listener.Addr().String(),
+	}, nil, p.logger)
+	go func() {
+		_ = proxy.Serve(ln)
+	}()
+	defer func() {
+		_ = proxy.Shutdown(context.Background())
+	}()
+
+	p.logger.Info("registered probe endpoint")
+
+	httpClient := &http.Client{
+		Timeout: requestTimeout,
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+
+		err := p.sendRequest(ctx, httpClient)
+		// Ignore requests interrupted by the probe stopping.
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		p.mu.Lock()
+		p.requests++
+		if err != nil {
+			p.failed++
+		}
+		p.mu.Unlock()
+
+		if err != nil {
+			p.logger.Warn("probe failed", zap.Error(err))
+		}
+	}
+}
+
+// Availability returns the fraction of successful probe requests, or 1 if no
+// requests have been sent.
+func (p *Prober) Availability() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.requests == 0 {
+		return 1
+	}
+	return float64(p.requests-p.failed) / float64(p.requests)
+}
+
+// Requests returns the number of probe requests sent and the number that
+// failed.
+func (p *Prober) Requests() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.requests, p.failed
+}
+
+func (p *Prober) sendRequest(ctx context.Context, httpClient *http.Client) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.proxyURL, nil)
+	if err != nil {
+		return fmt.Errorf("request: %w", err)
+	}
+	req.Header.Set("x-piko-endpoint", endpointID)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if string(body) != endpointID {
+		return fmt.Errorf("invalid response")
+	}
+	return nil
+}